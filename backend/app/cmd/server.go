@@ -11,9 +11,11 @@ import (
 	"os/signal"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/go-pkgz/jrpc"
 	"github.com/go-pkgz/lcw/v2/eventbus"
@@ -30,9 +32,11 @@ import (
 	"github.com/go-pkgz/auth/token"
 	cache "github.com/go-pkgz/lcw/v2"
 
+	"github.com/umputun/remark42/backend/app/metrics"
 	"github.com/umputun/remark42/backend/app/migrator"
 	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/providers"
+	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/rest/api"
 	"github.com/umputun/remark42/backend/app/rest/proxy"
 	"github.com/umputun/remark42/backend/app/store"
@@ -58,6 +62,14 @@ type ServerCommand struct {
 	Image      ImageGroup      `group:"image" namespace:"image" env-namespace:"IMAGE"`
 	SSL        SSLGroup        `group:"ssl" namespace:"ssl" env-namespace:"SSL"`
 	ImageProxy ImageProxyGroup `group:"image-proxy" namespace:"image-proxy" env-namespace:"IMAGE_PROXY"`
+	VoteWeight VoteWeightGroup `group:"vote-weight" namespace:"vote-weight" env-namespace:"VOTE_WEIGHT"`
+	Review     ReviewGroup     `group:"review" namespace:"review" env-namespace:"REVIEW"`
+	OEmbed     OEmbedGroup     `group:"oembed" namespace:"oembed" env-namespace:"OEMBED"`
+	Retention  RetentionGroup  `group:"retention" namespace:"retention" env-namespace:"RETENTION"`
+	Trash      TrashGroup      `group:"trash" namespace:"trash" env-namespace:"TRASH"`
+	Live       LiveGroup       `group:"live" namespace:"live" env-namespace:"LIVE"`
+	Preview    PreviewGroup    `group:"preview" namespace:"preview" env-namespace:"PREVIEW"`
+	Breaker    BreakerGroup    `group:"breaker" namespace:"breaker" env-namespace:"BREAKER"`
 
 	Sites                      []string      `long:"site" env:"SITE" default:"remark" description:"site names" env-delim:","`
 	AnonymousVote              bool          `long:"anon-vote" env:"ANON_VOTE" description:"enable anonymous votes (works only with VOTES_IP enabled)"`
@@ -67,24 +79,51 @@ type ServerCommand struct {
 	LegacyImageProxy           bool          `long:"img-proxy" env:"IMG_PROXY" description:"[deprecated, use image-proxy.http2https] enable image proxy"`
 	MinCommentSize             int           `long:"min-comment" env:"MIN_COMMENT_SIZE" default:"0" description:"min comment size"`
 	MaxCommentSize             int           `long:"max-comment" env:"MAX_COMMENT_SIZE" default:"2048" description:"max comment size"`
+	MaxQuoteSize               int           `long:"max-quote" env:"MAX_QUOTE_SIZE" default:"500" description:"max size of the parent's excerpt quoted in reply, 0 disables quotes"`
+	MaxRenderedSize            int           `long:"max-comment-rendered" env:"MAX_COMMENT_RENDERED_SIZE" default:"0" description:"max size of rendered comment, unlimited if 0"`
+	MaxImages                  []string      `long:"max-images" env:"MAX_IMAGES" description:"max images per comment, site=number for the particular site, unlimited if not set" env-delim:","`
+	MaxTreeComments            int           `long:"max-tree" env:"MAX_TREE_COMMENTS" default:"0" description:"soft cap of comments returned in tree format, replies of the oldest threads omitted over it, unlimited if 0"`
+	MaxCountsPosts             int           `long:"max-counts" env:"MAX_COUNTS" default:"1000" description:"max posts in one request of comment counts, unlimited if 0"`
 	MaxVotes                   int           `long:"max-votes" env:"MAX_VOTES" default:"-1" description:"maximum number of votes per comment"`
 	RestrictVoteIP             bool          `long:"votes-ip" env:"VOTES_IP" description:"restrict votes from the same ip"`
 	DurationVoteIP             time.Duration `long:"votes-ip-time" env:"VOTES_IP_TIME" default:"5m" description:"same ip vote duration"`
+	IPMode                     string        `long:"ip-mode" env:"IP_MODE" choice:"hash" choice:"truncate" default:"hash" description:"anonymization of stored client ip"`
+	IPSalt                     string        `long:"ip-salt" env:"IP_SALT" description:"salt of ip hash, site's secret used if not set"`
 	LowScore                   int           `long:"low-score" env:"LOW_SCORE" default:"-5" description:"low score threshold"`
 	CriticalScore              int           `long:"critical-score" env:"CRITICAL_SCORE" default:"-10" description:"critical score threshold"`
+	CollapseScore              int           `long:"collapse-score" env:"COLLAPSE_SCORE" default:"0" description:"comments with score below it marked collapsed, 0 to disable"`
+	ThreadCooldown             time.Duration `long:"thread-cooldown" env:"THREAD_COOLDOWN" default:"0s" description:"min time between comments of the user to the same post, 0 to disable"`
+	DefaultLang                string        `long:"default-lang" env:"DEFAULT_LANG" description:"language of comments without declared or detected language, like en"`
 	PositiveScore              bool          `long:"positive-score" env:"POSITIVE_SCORE" description:"enable positive score only"`
 	ReadOnlyAge                int           `long:"read-age" env:"READONLY_AGE" default:"0" description:"read-only age of comments, days"`
 	EditDuration               time.Duration `long:"edit-time" env:"EDIT_TIME" default:"5m" description:"edit window"`
 	AdminEdit                  bool          `long:"admin-edit" env:"ADMIN_EDIT" description:"unlimited edit for admins"`
+	ReplyEdit                  []string      `long:"reply-edit" env:"REPLY_EDIT" description:"edit policy of comments with replies, lock, delete or allow, site=policy for the particular site" env-delim:","`
+	EditHistory                int           `long:"edit-history" env:"EDIT_HISTORY" default:"10" description:"max number of comment's prior versions kept on edit, 0 to disable"`
+	DraftTTL                   time.Duration `long:"draft-ttl" env:"DRAFT_TTL" default:"24h" description:"how long comment drafts kept"`
 	Port                       int           `long:"port" env:"REMARK_PORT" default:"8080" description:"port"`
 	Address                    string        `long:"address" env:"REMARK_ADDRESS" default:"" description:"listening address"`
 	WebRoot                    string        `long:"web-root" env:"REMARK_WEB_ROOT" default:"./web" description:"web root directory"`
 	UpdateLimit                float64       `long:"update-limit" env:"UPDATE_LIMIT" default:"0.5" description:"updates/sec limit"`
 	RestrictedWords            []string      `long:"restricted-words" env:"RESTRICTED_WORDS" description:"words prohibited to use in comments" env-delim:","`
 	RestrictedNames            []string      `long:"restricted-names" env:"RESTRICTED_NAMES" description:"names prohibited to use by user" env-delim:","`
+	BlockedNames               []string      `long:"blocked-names" env:"BLOCKED_NAMES" description:"names not allowed in user names, as site=name or name for all sites" env-delim:","`
+	AllowedScripts             []string      `long:"allowed-scripts" env:"ALLOWED_SCRIPTS" description:"unicode scripts allowed in comments, as site=script or script for all other sites" env-delim:","`
+	AvatarFallback             []string      `long:"avatar-fallback" env:"AVATAR_FALLBACK" choice:"provider" choice:"gravatar" choice:"identicon" default:"provider" default:"identicon" description:"avatar fallback chain" env-delim:","` //nolint
 	EnableEmoji                bool          `long:"emoji" env:"EMOJI" description:"enable emoji"`
 	SimpleView                 bool          `long:"simple-view" env:"SIMPLE_VIEW" description:"minimal comment editor mode"`
+	Metrics                    bool          `long:"metrics" env:"METRICS" description:"expose Prometheus metrics on /metrics"`
 	ProxyCORS                  bool          `long:"proxy-cors" env:"PROXY_CORS" description:"disable internal CORS and delegate it to proxy"`
+	PlainText                  []string      `long:"plain-text" env:"PLAIN_TEXT" description:"sites with comments rendered as plain text with links, without markdown, * for all sites" env-delim:","`
+	NormalizeText              []string      `long:"normalize-text" env:"NORMALIZE_TEXT" description:"sites with zero-width characters removed and NFKC applied to comments before checks, * for all sites" env-delim:","`
+	ModLog                     string        `long:"mod-log" env:"MOD_LOG" description:"file of append-only moderation log, disabled if not set"`
+	ConfirmedEmail             []string      `long:"confirmed-email" env:"CONFIRMED_EMAIL" description:"sites accepting comments only from users with confirmed email, * for all sites" env-delim:","`
+	StrictOrigin               []string      `long:"strict-origin" env:"STRICT_ORIGIN" description:"sites accepting comments only from origin of the post's host or allowed origins, * for all sites" env-delim:","`
+	Honeypot                   []string      `long:"honeypot" env:"HONEYPOT" description:"hidden field of anonymous comment form, comments with it filled dropped, site=field for the particular site" env-delim:","`
+	HiddenUserFields           []string      `long:"hidden-user-fields" env:"HIDDEN_USER_FIELDS" description:"author fields (id, name, picture) hidden from readers other than admins, site=field for the particular site" env-delim:","`
+	AllowedOrigins             []string      `long:"allowed-origins" env:"ALLOWED_ORIGINS" description:"CORS allowed origins, site=origin for the particular site" env-delim:","`
+	TrustedProxies             []string      `long:"trusted-proxies" env:"TRUSTED_PROXIES" default:"127.0.0.0/8" default:"10.0.0.0/8" default:"172.16.0.0/12" default:"192.168.0.0/16" default:"::1/128" default:"fc00::/7" description:"networks of proxies allowed to set client ip header" env-delim:","` //nolint
+	RealIPHeader               string        `long:"real-ip-header" env:"REAL_IP_HEADER" description:"header with client ip set by trusted proxy, X-Real-IP or X-Forwarded-For if not set"`
 	AllowedHosts               []string      `long:"allowed-hosts" env:"ALLOWED_HOSTS" description:"limit hosts/sources allowed to embed comments" env-delim:","`
 	SubscribersOnly            bool          `long:"subscribers-only" env:"SUBSCRIBERS_ONLY" description:"enable commenting only for Patreon subscribers"`
 	DisableSignature           bool          `long:"disable-signature" env:"DISABLE_SIGNATURE" description:"disable server signature in headers"`
@@ -94,10 +133,24 @@ type ServerCommand struct {
 		TTL struct {
 			JWT    time.Duration `long:"jwt" env:"JWT" default:"5m" description:"JWT TTL"`
 			Cookie time.Duration `long:"cookie" env:"COOKIE" default:"200h" description:"auth cookie TTL"`
+
+			ProviderJWT    []string `long:"provider-jwt" env:"PROVIDER_JWT" description:"JWT TTL of the auth provider, as provider=ttl" env-delim:","`
+			ProviderCookie []string `long:"provider-cookie" env:"PROVIDER_COOKIE" description:"session TTL of the auth provider, as provider=ttl, not longer than auth cookie TTL" env-delim:","`
 		} `group:"ttl" namespace:"ttl" env-namespace:"TTL"`
 
-		SendJWTHeader bool   `long:"send-jwt-header" env:"SEND_JWT_HEADER" description:"send JWT as a header instead of cookie"`
-		SameSite      string `long:"same-site" env:"SAME_SITE" description:"set same site policy for cookies" choice:"default" choice:"none" choice:"lax" choice:"strict" default:"default"` // nolint
+		Audiences struct {
+			File    string        `long:"file" env:"FILE" description:"file with allowed tokens audiences (site ids), re-read periodically"`
+			Env     string        `long:"env" env:"ENV" description:"env variable with comma separated allowed tokens audiences, re-read periodically"`
+			Refresh time.Duration `long:"refresh" env:"REFRESH" default:"1m" description:"re-read interval of allowed audiences"`
+		} `group:"audiences" namespace:"audiences" env-namespace:"AUDIENCES"`
+
+		SendJWTHeader     bool   `long:"send-jwt-header" env:"SEND_JWT_HEADER" description:"send JWT as a header instead of cookie"`
+		JWTCookieReadable bool   `long:"dev-jwt-cookie-readable" env:"DEV_JWT_COOKIE_READABLE" description:"[dev only] issue JWT cookie without HttpOnly, readable from JS"`
+		SiteCookies       bool   `long:"site-cookies" env:"SITE_COOKIES" description:"name JWT cookie per site, JWT_site, for multiple sites on the same domain"`
+		NormalizeAud      bool   `long:"normalize-aud" env:"NORMALIZE_AUD" description:"trim spaces and lower case of site id in tokens audience and requests"`
+		SameSite          string `long:"same-site" env:"SAME_SITE" description:"set same site policy for cookies" choice:"default" choice:"none" choice:"lax" choice:"strict" choice:"auto" default:"default"` // nolint
+		MaxSessions       int    `long:"max-sessions" env:"MAX_SESSIONS" default:"0" description:"max active sessions per user, the oldest session revoked on login over the limit, unlimited if 0"`
+		IssuerFingerprint bool   `long:"issuer-fingerprint" env:"ISSUER_FINGERPRINT" description:"stamp tokens with fingerprint of the instance issued them"`
 
 		Apple     AppleGroup `group:"apple" namespace:"apple" env-namespace:"APPLE" description:"Apple OAuth"`
 		Google    AuthGroup  `group:"google" namespace:"google" env-namespace:"GOOGLE" description:"Google OAuth"`
@@ -107,9 +160,13 @@ type ServerCommand struct {
 		Yandex    AuthGroup  `group:"yandex" namespace:"yandex" env-namespace:"YANDEX" description:"Yandex OAuth"`
 		Twitter   AuthGroup  `group:"twitter" namespace:"twitter" env-namespace:"TWITTER" description:"Twitter OAuth"`
 		Patreon   AuthGroup  `group:"patreon" namespace:"patreon" env-namespace:"PATREON" description:"Patreon OAuth"`
+		OIDC      OIDCGroup  `group:"oidc" namespace:"oidc" env-namespace:"OIDC" description:"generic OpenID Connect"`
+		Federated []string   `long:"federated" env:"FEDERATED" description:"trusted issuer of bearer tokens and its JWKS url, issuer=url" env-delim:","`
+		ClaimsMap []string   `long:"claims-map" env:"CLAIMS_MAP" description:"user field set from auth provider's field, provider:field=name or provider:field=picture" env-delim:","`
 		Telegram  bool       `long:"telegram" env:"TELEGRAM" description:"Enable Telegram auth (using token from telegram.token)"`
 		Dev       bool       `long:"dev" env:"DEV" description:"enable dev (local) oauth2"`
 		Anonymous bool       `long:"anon" env:"ANON" description:"enable anonymous login"`
+		AnonNames bool       `long:"anon-reserve-names" env:"ANON_RESERVE_NAMES" description:"reserve anonymous name for the first anonymous user posted with it"`
 		Email     struct {
 			Enable       bool          `long:"enable" env:"ENABLE" description:"enable auth via email"`
 			From         string        `long:"from" env:"FROM" description:"from email address"`
@@ -123,6 +180,10 @@ type ServerCommand struct {
 			TimeOut      time.Duration `long:"timeout" env:"TIMEOUT" default:"10s" description:"[deprecated, use --smtp.timeout] SMTP TCP connection timeout"`
 			MsgTemplate  string        `long:"template" env:"TEMPLATE" description:"[deprecated] message template file" default:"email_confirmation_login.html.tmpl"`
 		} `group:"email" namespace:"email" env-namespace:"EMAIL"`
+		Refresh struct {
+			Enable bool   `long:"enable" env:"ENABLE" description:"keep oauth refresh tokens and renew sessions with upstream refresh"`
+			File   string `long:"file" env:"FILE" default:"./var/refresh_tokens.db" description:"refresh tokens bolt file location"`
+		} `group:"refresh" namespace:"refresh" env-namespace:"REFRESH"`
 	} `group:"auth" namespace:"auth" env-namespace:"AUTH"`
 
 	CommonOpts
@@ -137,6 +198,71 @@ type ImageProxyGroup struct {
 	CacheExternal bool `long:"cache-external" env:"CACHE_EXTERNAL" description:"enable caching for external images"`
 }
 
+// ReviewGroup defines options group for review of comments held by restricted words check
+type ReviewGroup struct {
+	Webhook  string        `long:"webhook" env:"WEBHOOK" description:"webhook url to send comments with restricted words for review instead of rejection"`
+	TTL      time.Duration `long:"ttl" env:"TTL" default:"72h" description:"how long comments held for review"`
+	NewUsers int           `long:"new-users" env:"NEW_USERS" default:"0" description:"number of first comments of new users held for review, 0 disables"`
+	Reports  int           `long:"reports" env:"REPORTS" default:"0" description:"number of user reports hiding comment pending review, 0 disables"`
+	Expiry   []string      `long:"expiry" env:"EXPIRY" description:"action on comments not reviewed within ttl, publish or reject, site=action for the particular site" env-delim:","`
+	Reminder time.Duration `long:"reminder" env:"REMINDER" default:"0s" description:"send held comment for review again this long before its expiration, 0 disables"`
+	Trusted  struct {
+		Comments int           `long:"comments" env:"COMMENTS" default:"0" description:"number of published comments of trusted users, not held for review, 0 disables"`
+		Age      time.Duration `long:"age" env:"AGE" default:"0s" description:"min age of the first comment of trusted users"`
+	} `group:"trusted" namespace:"trusted" env-namespace:"TRUSTED"`
+}
+
+// LiveGroup defines options group for live updates of posts with server-sent events
+type LiveGroup struct {
+	Enabled   bool          `long:"enabled" env:"ENABLED" description:"stream new comments to readers of the post"`
+	MaxConn   int           `long:"max-conn" env:"MAX_CONN" default:"200" description:"max number of live connections, 0 for unlimited"`
+	MaxConnIP int           `long:"max-conn-ip" env:"MAX_CONN_IP" default:"5" description:"max number of live connections from the same ip, 0 for unlimited"`
+	Timeout   time.Duration `long:"timeout" env:"TIMEOUT" default:"30m" description:"max duration of live connection, reader reconnects after it"`
+}
+
+// PreviewGroup defines options group for unpublished pages, comments accessible with preview token only
+type PreviewGroup struct {
+	URLs []string      `long:"url" env:"URL" description:"url prefix of unpublished pages" env-delim:","`
+	TTL  time.Duration `long:"ttl" env:"TTL" default:"24h" description:"default lifetime of preview token"`
+}
+
+// BreakerGroup defines options group for global circuit breaker suspending comment creation under flood
+type BreakerGroup struct {
+	Limit    int           `long:"limit" env:"LIMIT" default:"0" description:"max comments created on all sites within window, disabled if 0"`
+	Window   time.Duration `long:"window" env:"WINDOW" default:"1m" description:"period of the limit"`
+	Cooldown time.Duration `long:"cooldown" env:"COOLDOWN" default:"5m" description:"how long comment creation suspended after the limit exceeded"`
+}
+
+// OEmbedGroup defines options group for link previews with oEmbed
+type OEmbedGroup struct {
+	Sites     []string      `long:"site" env:"SITE" description:"sites with link previews enabled" env-delim:","`
+	Providers []string      `long:"provider" env:"PROVIDER" choice:"youtube" choice:"vimeo" choice:"twitter" default:"youtube" default:"vimeo" description:"oembed providers allowed for link previews" env-delim:","` //nolint
+	Limit     int           `long:"limit" env:"LIMIT" default:"3" description:"max link previews per comment"`
+	Timeout   time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"timeout of oembed provider requests"`
+}
+
+// RetentionGroup defines options group for retention policy purging old comments
+type RetentionGroup struct {
+	Age      []string      `long:"age" env:"AGE" description:"max age of comments, site=age for the particular site, disabled if not set" env-delim:","`
+	Mode     string        `long:"mode" env:"MODE" choice:"purge" choice:"anonymize" default:"purge" description:"purge old comments or anonymize their users"` //nolint
+	DryRun   bool          `long:"dry-run" env:"DRY_RUN" description:"report comments to be removed without changing them"`
+	Interval time.Duration `long:"interval" env:"INTERVAL" default:"24h" description:"retention check interval"`
+}
+
+// TrashGroup defines options group for trash keeping comments deleted by moderators for restore
+type TrashGroup struct {
+	TTL      time.Duration `long:"ttl" env:"TTL" default:"0s" description:"how long deleted comments kept in trash for restore, disabled if 0"`
+	Interval time.Duration `long:"interval" env:"INTERVAL" default:"1h" description:"purge check interval of comments kept in trash"`
+}
+
+// VoteWeightGroup defines options group for vote weights by user's role and reputation
+type VoteWeightGroup struct {
+	Admin           int `long:"admin" env:"ADMIN" default:"1" description:"weight of admin's vote"`
+	Verified        int `long:"verified" env:"VERIFIED" default:"1" description:"weight of verified user's vote"`
+	Reputation      int `long:"reputation" env:"REPUTATION" default:"1" description:"weight of vote of user with reputation"`
+	ReputationScore int `long:"reputation-score" env:"REPUTATION_SCORE" default:"10" description:"min total score of user's comments for reputation weight"`
+}
+
 // AppleGroup defines options for Apple auth params
 type AppleGroup struct {
 	CID                string `long:"cid" env:"CID" description:"Apple client ID"`
@@ -145,6 +271,14 @@ type AppleGroup struct {
 	PrivateKeyFilePath string `long:"private-key-filepath" env:"PRIVATE_KEY_FILEPATH" description:"Private key file location" default:"/srv/var/apple.p8"`
 }
 
+// OIDCGroup defines options for generic OpenID Connect provider
+type OIDCGroup struct {
+	Issuer string   `long:"issuer" env:"ISSUER" description:"OIDC issuer URL, endpoints discovered from {issuer}/.well-known/openid-configuration"`
+	CID    string   `long:"cid" env:"CID" description:"OIDC client ID"`
+	CSEC   string   `long:"csec" env:"CSEC" description:"OIDC client secret"`
+	Scopes []string `long:"scopes" env:"SCOPES" default:"profile" default:"email" description:"OIDC scopes requested in addition to openid" env-delim:","` // nolint
+}
+
 // AuthGroup defines options group for auth params
 type AuthGroup struct {
 	CID  string `long:"cid" env:"CID" description:"OAuth client ID"`
@@ -153,11 +287,17 @@ type AuthGroup struct {
 
 // StoreGroup defines options group for store params
 type StoreGroup struct {
-	Type string `long:"type" env:"TYPE" description:"type of storage" choice:"bolt" choice:"rpc" default:"bolt"` // nolint
+	Type string `long:"type" env:"TYPE" description:"type of storage" choice:"bolt" choice:"postgres" choice:"rpc" default:"bolt"` // nolint
 	Bolt struct {
 		Path    string        `long:"path" env:"PATH" default:"./var" description:"parent directory for the bolt files"`
 		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"30s" description:"bolt timeout"`
 	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
+	Postgres struct {
+		URL             string        `long:"url" env:"URL" description:"postgres connection url"`
+		MaxOpenConns    int           `long:"max-open-conns" env:"MAX_OPEN_CONNS" default:"20" description:"max open connections"`
+		MaxIdleConns    int           `long:"max-idle-conns" env:"MAX_IDLE_CONNS" default:"5" description:"max idle connections"`
+		ConnMaxLifetime time.Duration `long:"conn-max-lifetime" env:"CONN_MAX_LIFETIME" default:"30m" description:"max connection lifetime"`
+	} `group:"postgres" namespace:"postgres" env-namespace:"POSTGRES"`
 	RPC RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
 }
 
@@ -165,17 +305,21 @@ type StoreGroup struct {
 type ImageGroup struct {
 	Type string `long:"type" env:"TYPE" description:"type of storage" choice:"fs" choice:"bolt" choice:"rpc" default:"fs"` // nolint
 	FS   struct {
-		Path       string `long:"path" env:"PATH" default:"./var/pictures" description:"images location"`
-		Staging    string `long:"staging" env:"STAGING" default:"./var/pictures.staging" description:"staging location"`
-		Partitions int    `long:"partitions" env:"PARTITIONS" default:"100" description:"partitions (subdirs)"`
+		Path        string `long:"path" env:"PATH" default:"./var/pictures" description:"images location"`
+		Staging     string `long:"staging" env:"STAGING" default:"./var/pictures.staging" description:"staging location"`
+		Partitions  int    `long:"partitions" env:"PARTITIONS" default:"100" description:"partitions (subdirs)"`
+		MigrateFlat bool   `long:"migrate-flat" env:"MIGRATE_FLAT" description:"move images stored without partitions to partitions on start"`
 	} `group:"fs" namespace:"fs" env-namespace:"FS"`
 	Bolt struct {
 		File string `long:"file" env:"FILE" default:"./var/pictures.db" description:"images bolt file location"`
 	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
-	MaxSize      int      `long:"max-size" env:"MAX_SIZE" default:"5000000" description:"max size of image file"`
-	ResizeWidth  int      `long:"resize-width" env:"RESIZE_WIDTH" default:"2400" description:"width of a resized image"`
-	ResizeHeight int      `long:"resize-height" env:"RESIZE_HEIGHT" default:"900" description:"height of a resized image"`
-	RPC          RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
+	MaxSize        int      `long:"max-size" env:"MAX_SIZE" default:"5000000" description:"max size of image file"`
+	MaxDimension   int      `long:"max-dimension" env:"MAX_DIMENSION" default:"10000" description:"max width or height of image, 0 - no limit"`
+	AllowedTypes   []string `long:"allowed-types" env:"ALLOWED_TYPES" default:"image/gif" default:"image/png" default:"image/jpeg" default:"image/webp" description:"allowed image MIME types" env-delim:","` // nolint
+	RejectAnimated bool     `long:"reject-animated" env:"REJECT_ANIMATED" description:"reject animated gif and webp images"`
+	ResizeWidth    int      `long:"resize-width" env:"RESIZE_WIDTH" default:"2400" description:"width of a resized image"`
+	ResizeHeight   int      `long:"resize-height" env:"RESIZE_HEIGHT" default:"900" description:"height of a resized image"`
+	RPC            RPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
 }
 
 // AvatarGroup defines options group for avatar params
@@ -189,6 +333,7 @@ type AvatarGroup struct {
 	} `group:"bolt" namespace:"bolt" env-namespace:"BOLT"`
 	URI    string `long:"uri" env:"URI" default:"./var/avatars" description:"avatars store URI"`
 	RszLmt int    `long:"rsz-lmt" env:"RESIZE" default:"0" description:"max image size for resizing avatars on save"`
+	Upload bool   `long:"upload" env:"UPLOAD" description:"allow registered users to upload own avatar"`
 }
 
 // CacheGroup defines options group for cache params
@@ -209,7 +354,12 @@ type AdminGroup struct {
 		Admins []string `long:"id" env:"ID" description:"admin(s) ids" env-delim:","`
 		Email  []string `long:"email" env:"EMAIL" description:"admin emails" env-delim:","`
 	} `group:"shared" namespace:"shared" env-namespace:"SHARED"`
-	RPC AdminRPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
+	RPC         AdminRPCGroup `group:"rpc" namespace:"rpc" env-namespace:"RPC"`
+	KeyRotation struct {
+		Enable bool          `long:"enable" env:"ENABLE" description:"enable rotation of signing keys by admins"`
+		File   string        `long:"file" env:"FILE" default:"./var/signing_keys.db" description:"file to keep rotated signing keys"`
+		Grace  time.Duration `long:"grace" env:"GRACE" default:"24h" description:"default grace period of the previous key"`
+	} `group:"key-rotation" namespace:"key-rotation" env-namespace:"KEY_ROTATION"`
 }
 
 // TelegramGroup defines token for Telegram used in notify and auth modules
@@ -229,6 +379,7 @@ type SMTPGroup struct {
 	LoginAuth          bool          `long:"login_auth" env:"LOGIN_AUTH" description:"enable LOGIN auth instead of PLAIN"`
 	StartTLS           bool          `long:"starttls" env:"STARTTLS" description:"enable StartTLS"`
 	TimeOut            time.Duration `long:"timeout" env:"TIMEOUT" default:"10s" description:"SMTP TCP connection timeout"`
+	MaxIdle            time.Duration `long:"max_idle" env:"MAX_IDLE" description:"keep SMTP connection of notifications between messages till idle for longer, new connection for each message if not set"`
 }
 
 // NotifyGroup defines options for notification
@@ -244,9 +395,15 @@ type NotifyGroup struct {
 		Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"5s" description:"[deprecated, use --telegram.timeout] telegram timeout"`
 	} `group:"telegram" namespace:"telegram" env-namespace:"TELEGRAM"`
 	Email struct {
-		From                string `long:"from_address" env:"FROM" description:"from email address"`
-		VerificationSubject string `long:"verification_subj" env:"VERIFICATION_SUBJ" description:"verification message subject"`
-		AdminNotifications  bool   `long:"notify_admin" env:"ADMIN" description:"[deprecated, use --notify.admins=email] notify admin on new comments via ADMIN_SHARED_EMAIL"`
+		From                string        `long:"from_address" env:"FROM" description:"from email address"`
+		VerificationSubject string        `long:"verification_subj" env:"VERIFICATION_SUBJ" description:"verification message subject"`
+		SiteTemplates       string        `long:"site_templates" env:"SITE_TEMPLATES" description:"directory with per-site message templates, {dir}/{site}/email_reply.html.tmpl"`
+		Locales             []string      `long:"locale" env:"LOCALE" description:"notifications locale, site=locale for the particular site" env-delim:","`
+		Digest              time.Duration `long:"digest" env:"DIGEST" default:"0s" description:"send email notifications as a digest once per interval, 0 sends each immediately"`
+		UserDigest          time.Duration `long:"user-digest" env:"USER_DIGEST" default:"24h" description:"digest interval for users preferring digest, if digest not set for all"`
+		ThreadRate          int           `long:"thread-rate" env:"THREAD_RATE" default:"0" description:"max notifications per minute about a thread, digest used for the thread if exceeded, 0 for no limit"`
+		ThreadCooldown      time.Duration `long:"thread-cooldown" env:"THREAD_COOLDOWN" default:"15m" description:"digest period for the thread exceeded thread-rate"`
+		AdminNotifications  bool          `long:"notify_admin" env:"ADMIN" description:"[deprecated, use --notify.admins=email] notify admin on new comments via ADMIN_SHARED_EMAIL"`
 	} `group:"email" namespace:"email" env-namespace:"EMAIL"`
 	Slack struct {
 		Token   string `long:"token" env:"TOKEN" description:"slack token"`
@@ -305,7 +462,9 @@ type serverApp struct {
 	authenticator *auth.Service
 	terminated    chan struct{}
 
-	authRefreshCache *authRefreshCache // stored only to close it properly on shutdown
+	authRefreshCache *authRefreshCache           // stored only to close it properly on shutdown
+	refreshStore     *providers.BoltRefreshStore // stored only to close it properly on shutdown, nil if refresh disabled
+	keyRotator       *admin.KeyRotator           // stored only to close it properly on shutdown, nil if rotation disabled
 }
 
 // Execute is the entry point for "server" command, called by flag parser
@@ -503,17 +662,52 @@ func (s *ServerCommand) newServerApp(ctx context.Context) (*serverApp, error) {
 		Engine:                 storeEngine,
 		EditDuration:           s.EditDuration,
 		AdminEdits:             s.AdminEdit,
+		EditHistory:            s.EditHistory,
+		DraftTTL:               s.DraftTTL,
+		TrashTTL:               s.Trash.TTL,
+		ReserveAnonNames:       s.Auth.AnonNames,
 		AdminStore:             adminStore,
 		MinCommentSize:         s.MinCommentSize,
 		MaxCommentSize:         s.MaxCommentSize,
+		MaxQuoteSize:           s.MaxQuoteSize,
+		MaxRenderedSize:        s.MaxRenderedSize,
+		MaxImages:              s.getMaxImages(),
+		BlockedNames:           s.getBlockedNames(),
+		AllowedScripts:         s.getAllowedScripts(),
 		MaxVotes:               s.MaxVotes,
 		PositiveScore:          s.PositiveScore,
+		CollapseScore:          s.CollapseScore,
+		ThreadCooldown:         s.ThreadCooldown,
+		DefaultLang:            s.DefaultLang,
+		NormalizeSites:         s.NormalizeText,
+		ReplyEdit:              s.getReplyEdit(),
+		VoteWeights:            service.VoteWeights(s.VoteWeight),
 		ImageService:           imageService,
 		TitleExtractor:         service.NewTitleExtractor(http.Client{Timeout: time.Second * 5}, s.getAllowedDomains()),
 		RestrictedWordsMatcher: service.NewRestrictedWordsMatcher(service.StaticRestrictedWordsLister{Words: s.RestrictedWords}),
 	}
+	if s.Review.Webhook != "" {
+		dataService.Reviewer = &service.ReviewWebhook{URL: s.Review.Webhook, RemarkURL: s.RemarkURL,
+			Client: &http.Client{Timeout: 10 * time.Second}}
+		dataService.ReviewTTL = s.Review.TTL
+		dataService.NewUserComments = s.Review.NewUsers
+		dataService.Trust = service.TrustLevels{TrustedComments: s.Review.Trusted.Comments, TrustedAge: s.Review.Trusted.Age}
+		dataService.ReviewExpiry, dataService.ReviewReminder = s.getReviewExpiry(), s.Review.Reminder
+		log.Printf("[INFO] comments with restricted words and first %d comments of new users sent for review", s.Review.NewUsers)
+	}
+	if s.Review.Webhook == "" && s.Review.NewUsers > 0 {
+		log.Print("[WARN] pre-moderation of new users requires review webhook, ignored")
+	}
+	dataService.ReportThreshold = s.Review.Reports
+	if s.Review.Webhook == "" && s.Review.Reports > 0 {
+		log.Print("[WARN] comments hidden after reports not sent for review without review webhook, admin should show them back")
+	}
+	if s.Metrics {
+		dataService.Metrics = metrics.New()
+	}
 	dataService.RestrictSameIPVotes.Enabled = s.RestrictVoteIP
 	dataService.RestrictSameIPVotes.Duration = s.DurationVoteIP
+	dataService.IPMode, dataService.IPSalt = store.IPMode(s.IPMode), s.IPSalt
 
 	loadingCache, err := s.makeCache()
 	if err != nil {
@@ -526,16 +720,49 @@ func (s *ServerCommand) newServerApp(ctx context.Context) (*serverApp, error) {
 		_ = dataService.Close()
 		return nil, fmt.Errorf("failed to make avatar store: %w", err)
 	}
+	tokenRefresher, refreshStore, err := s.makeTokenRefresher()
+	if err != nil {
+		_ = dataService.Close()
+		return nil, fmt.Errorf("failed to make token refresher: %w", err)
+	}
+	keyRotator, err := s.makeKeyRotator(adminStore)
+	if err != nil {
+		_ = dataService.Close()
+		if refreshStore != nil {
+			_ = refreshStore.Close()
+		}
+		return nil, fmt.Errorf("failed to make key rotator: %w", err)
+	}
 	authRefreshCache := newAuthRefreshCache()
-	authenticator := s.getAuthenticator(dataService, avatarStore, adminStore, authRefreshCache)
+	closeAuth := func() {
+		_ = authRefreshCache.Close()
+		if refreshStore != nil {
+			_ = refreshStore.Close()
+		}
+		if keyRotator != nil {
+			_ = keyRotator.Close()
+		}
+	}
+	var keys keyReader = adminStore
+	if keyRotator != nil {
+		keys = keyRotator
+	}
+	audiences, err := s.makeAudienceReader(ctx)
+	if err != nil {
+		_ = dataService.Close()
+		closeAuth()
+		return nil, fmt.Errorf("failed to load allowed audiences: %w", err)
+	}
+	sessions := &rest.SessionLimiter{MaxSessions: s.Auth.MaxSessions, TTL: s.Auth.TTL.Cookie}
+	authenticator, claimsUpd, validator := s.getAuthenticator(dataService, avatarStore, keys, audiences, sessions, authRefreshCache, tokenRefresher)
 
 	telegramAuth := s.makeTelegramAuth(authenticator) // telegram auth requires TelegramAPI listener which is constructed below
 	telegramService := s.startTelegramAuthAndNotify(ctx, telegramAuth)
 
-	err = s.addAuthProviders(authenticator)
+	oidc, err := s.addAuthProviders(authenticator, tokenRefresher, sessions)
 	if err != nil {
 		_ = dataService.Close()
-		_ = authRefreshCache.Close()
+		closeAuth()
 		return nil, fmt.Errorf("failed to make authenticator: %w", err)
 	}
 
@@ -558,6 +785,9 @@ func (s *ServerCommand) newServerApp(ctx context.Context) (*serverApp, error) {
 	}
 
 	notifyService := s.makeNotifyService(dataService, notifyDestinations, telegramService)
+	if notifyService != notify.NopService {
+		notifyService.Metrics = dataService.Metrics
+	}
 
 	imgProxy := &proxy.Image{
 		HTTP2HTTPS:    s.ImageProxy.HTTP2HTTPS,
@@ -571,17 +801,27 @@ func (s *ServerCommand) newServerApp(ctx context.Context) (*serverApp, error) {
 		emojiFmt = func(text string) string { return emoji.Sprint(text) }
 	}
 	commentFormatter := store.NewCommentFormatter(imgProxy, emojiFmt)
+	commentFormatter.PlainText = s.PlainText
+	commentFormatter.Previewer = s.makeOEmbed()
 
 	sslConfig, err := s.makeSSLConfig()
 	if err != nil {
 		_ = dataService.Close()
-		_ = authRefreshCache.Close()
+		closeAuth()
 		return nil, fmt.Errorf("failed to make config of ssl server params: %w", err)
 	}
 
+	trustedProxies, err := rest.ParseTrustedProxies(s.TrustedProxies)
+	if err != nil {
+		_ = dataService.Close()
+		closeAuth()
+		return nil, fmt.Errorf("failed to parse trusted proxies: %w", err)
+	}
+
 	srv := &api.Rest{
 		Version:                    s.Revision,
 		DataService:                dataService,
+		Sites:                      s.Sites,
 		WebRoot:                    s.WebRoot,
 		WebFS:                      webFS,
 		RemarkURL:                  s.RemarkURL,
@@ -589,8 +829,12 @@ func (s *ServerCommand) newServerApp(ctx context.Context) (*serverApp, error) {
 		CommentFormatter:           commentFormatter,
 		Migrator:                   migr,
 		ReadOnlyAge:                s.ReadOnlyAge,
+		MaxTreeComments:            s.MaxTreeComments,
+		MaxCountsPosts:             s.MaxCountsPosts,
 		SharedSecret:               s.SharedSecret,
 		Authenticator:              authenticator,
+		ClaimsUpdater:              claimsUpd,
+		TokenValidator:             validator,
 		Cache:                      loadingCache,
 		NotifyService:              notifyService,
 		TelegramService:            telegramService,
@@ -603,21 +847,55 @@ func (s *ServerCommand) newServerApp(ctx context.Context) (*serverApp, error) {
 		AnonVote:                   s.AnonymousVote && s.RestrictVoteIP,
 		SimpleView:                 s.SimpleView,
 		ProxyCORS:                  s.ProxyCORS,
+		RealIP:                     rest.RealIP{Trusted: trustedProxies, Header: s.RealIPHeader},
 		AllowedAncestors:           s.AllowedHosts,
+		SameSiteAuto:               strings.EqualFold(s.Auth.SameSite, "auto"),
+		JWTCookieReadable:          s.Auth.JWTCookieReadable,
+		AvatarUpload:               s.Avatar.Upload,
+		SiteJWTCookies:             s.Auth.SiteCookies,
+		AudNormalizer:              s.audNormalizer(),
+		AllowedOrigins:             s.getAllowedOrigins(),
+		HiddenUserFields:           s.getHiddenUserFields(),
+		HoneypotFields:             s.getHoneypotFields(),
+		ConfirmedEmail:             s.ConfirmedEmail,
+		StrictOrigin:               s.StrictOrigin,
 		SendJWTHeader:              s.Auth.SendJWTHeader,
 		SubscribersOnly:            s.SubscribersOnly,
 		DisableSignature:           s.DisableSignature,
 		DisableFancyTextFormatting: s.DisableFancyTextFormatting,
+		KeyRotator:                 keyRotator,
+		Metrics:                    dataService.Metrics,
+		KeyGrace:                   s.Admin.KeyRotation.Grace,
+		Live: api.LiveParams{
+			Enabled:        s.Live.Enabled,
+			MaxConnections: s.Live.MaxConn,
+			MaxPerIP:       s.Live.MaxConnIP,
+			Timeout:        s.Live.Timeout,
+		},
+		Preview: api.PreviewParams{URLs: s.Preview.URLs, TTL: s.Preview.TTL},
+		Breaker: api.BreakerParams{Limit: s.Breaker.Limit, Window: s.Breaker.Window, Cooldown: s.Breaker.Cooldown},
 	}
 
 	srv.ScoreThresholds.Low, srv.ScoreThresholds.Critical = s.LowScore, s.CriticalScore
+	if oidc != nil {
+		srv.OIDCLogout = oidc.BackChannelLogoutHandler
+	}
+	dataService.HeldExpired = srv.OnHeldExpired
+	if s.ModLog != "" {
+		srv.ModLog = &service.ModLog{Path: s.ModLog}
+		log.Printf("[INFO] moderation actions recorded to %s", s.ModLog)
+	}
+	if issuers := s.getFederatedIssuers(); len(issuers) > 0 {
+		srv.Federated = providers.NewFederatedVerifier(issuers, &http.Client{Timeout: 10 * time.Second})
+		log.Printf("[INFO] bearer tokens of federated issuers accepted, %d issuers", len(issuers))
+	}
 
 	var devAuth *provider.DevAuthServer
 	if s.Auth.Dev {
 		da, errDevAuth := authenticator.DevAuth()
 		if errDevAuth != nil {
 			_ = dataService.Close()
-			_ = authRefreshCache.Close()
+			closeAuth()
 			return nil, fmt.Errorf("can't make dev oauth2 server: %w", errDevAuth)
 		}
 		devAuth = da
@@ -636,6 +914,8 @@ func (s *ServerCommand) newServerApp(ctx context.Context) (*serverApp, error) {
 		authenticator:    authenticator,
 		terminated:       make(chan struct{}),
 		authRefreshCache: authRefreshCache,
+		refreshStore:     refreshStore,
+		keyRotator:       keyRotator,
 	}, nil
 }
 
@@ -680,6 +960,260 @@ func (s *ServerCommand) getAllowedDomains() []string {
 	return allowedDomains
 }
 
+// getHiddenUserFields makes map of author fields hidden from readers per site from s.HiddenUserFields.
+// Fields set as site=field hidden for the particular site, fields without site hidden for sites without own settings.
+func (s *ServerCommand) getHiddenUserFields() map[string][]string {
+	if len(s.HiddenUserFields) == 0 {
+		return nil
+	}
+	res := map[string][]string{}
+	for _, v := range s.HiddenUserFields {
+		siteID, field := api.AllSitesUserFields, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, field = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		switch field {
+		case api.UserFieldID, api.UserFieldName, api.UserFieldPicture:
+			res[siteID] = append(res[siteID], field)
+		default:
+			log.Printf("[WARN] unknown user field %q can't be hidden, ignored", v)
+		}
+	}
+	return res
+}
+
+// getFederatedIssuers makes map of JWKS urls by trusted issuer from s.Auth.Federated, set as issuer=url
+func (s *ServerCommand) getFederatedIssuers() map[string]string {
+	res := map[string]string{}
+	for _, v := range s.Auth.Federated {
+		elems := strings.SplitN(v, "=", 2)
+		if len(elems) != 2 || strings.TrimSpace(elems[0]) == "" || strings.TrimSpace(elems[1]) == "" {
+			log.Printf("[WARN] invalid federated issuer %q, expected issuer=url, ignored", v)
+			continue
+		}
+		res[strings.TrimSpace(elems[0])] = strings.TrimSpace(elems[1])
+	}
+	return res
+}
+
+// getHoneypotFields makes map of honeypot fields of anonymous comment form per site from s.Honeypot.
+// Field set as site=field used for the particular site, field without site for sites without own field.
+func (s *ServerCommand) getHoneypotFields() map[string]string {
+	if len(s.Honeypot) == 0 {
+		return nil
+	}
+	res := map[string]string{}
+	for _, v := range s.Honeypot {
+		siteID, field := api.AllSitesHoneypot, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, field = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		if field == "" {
+			log.Printf("[WARN] empty honeypot field %q, ignored", v)
+			continue
+		}
+		res[siteID] = field
+	}
+	return res
+}
+
+// parseProviderTTL makes map of durations by auth provider from values set as provider=ttl
+func parseProviderTTL(values []string) map[string]time.Duration {
+	if len(values) == 0 {
+		return nil
+	}
+	res := map[string]time.Duration{}
+	for _, v := range values {
+		elems := strings.SplitN(v, "=", 2)
+		if len(elems) != 2 {
+			log.Printf("[WARN] invalid provider ttl %q, should be provider=ttl, ignored", v)
+			continue
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(elems[1]))
+		if err != nil || ttl <= 0 {
+			log.Printf("[WARN] invalid provider ttl %q, ignored", v)
+			continue
+		}
+		res[strings.TrimSpace(elems[0])] = ttl
+	}
+	return res
+}
+
+// getBlockedNames makes map of blocked user names per site from s.BlockedNames.
+// Name set as site=name blocked for the particular site, name without site for all sites.
+func (s *ServerCommand) getBlockedNames() map[string][]string {
+	if len(s.BlockedNames) == 0 {
+		return nil
+	}
+	res := map[string][]string{}
+	for _, v := range s.BlockedNames {
+		siteID, name := service.AllSitesBlockedNames, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, name = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		if name == "" {
+			log.Printf("[WARN] empty blocked name %q, ignored", v)
+			continue
+		}
+		res[siteID] = append(res[siteID], name)
+	}
+	return res
+}
+
+// getAllowedScripts makes map of allowed unicode scripts per site from s.AllowedScripts.
+// Script set as site=script allowed for the particular site, script without site for all other sites.
+// Script names are case-insensitive, i.e. "latin" and "Latin", unknown scripts ignored.
+func (s *ServerCommand) getAllowedScripts() map[string][]string {
+	if len(s.AllowedScripts) == 0 {
+		return nil
+	}
+	scripts := map[string]string{} // canonical script names by lower case ones
+	for name := range unicode.Scripts {
+		scripts[strings.ToLower(name)] = name
+	}
+	res := map[string][]string{}
+	for _, v := range s.AllowedScripts {
+		siteID, name := service.AllSitesAllowedScripts, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, name = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		script, ok := scripts[strings.ToLower(name)]
+		if !ok {
+			log.Printf("[WARN] unknown script %q, ignored", v)
+			continue
+		}
+		res[siteID] = append(res[siteID], script)
+	}
+	return res
+}
+
+// getMaxImages makes map of images limit per comment per site from s.MaxImages.
+// Limit set as site=number applies to the particular site, limit without site to all other sites.
+func (s *ServerCommand) getMaxImages() map[string]int {
+	if len(s.MaxImages) == 0 {
+		return nil
+	}
+	res := map[string]int{}
+	for _, v := range s.MaxImages {
+		siteID, limit := service.AllSitesMaxImages, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, limit = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			log.Printf("[WARN] bad max images value %q, ignored", v)
+			continue
+		}
+		res[siteID] = n
+	}
+	return res
+}
+
+// getReviewExpiry makes map of actions on held comments not reviewed in time per site from s.Review.Expiry.
+// Action set as site=action applies to the particular site, action without site to all other sites.
+func (s *ServerCommand) getReviewExpiry() map[string]string {
+	if len(s.Review.Expiry) == 0 {
+		return nil
+	}
+	res := map[string]string{}
+	for _, v := range s.Review.Expiry {
+		siteID, action := service.AllSitesReviewExpiry, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, action = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		action = strings.ToLower(action)
+		if action != service.ExpiryPublish && action != service.ExpiryReject {
+			log.Printf("[WARN] bad review expiry action %q, ignored", v)
+			continue
+		}
+		res[siteID] = action
+	}
+	return res
+}
+
+// getReplyEdit makes map of edit policies of comments with replies per site from s.ReplyEdit.
+// Policy set as site=policy applies to the particular site, policy without site to all other sites.
+func (s *ServerCommand) getReplyEdit() map[string]string {
+	if len(s.ReplyEdit) == 0 {
+		return nil
+	}
+	res := map[string]string{}
+	for _, v := range s.ReplyEdit {
+		siteID, policy := service.AllSitesReplyEdit, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, policy = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		policy = strings.ToLower(policy)
+		if policy != service.ReplyEditLock && policy != service.ReplyEditDelete && policy != service.ReplyEditAllow {
+			log.Printf("[WARN] bad reply edit policy %q, ignored", v)
+			continue
+		}
+		res[siteID] = policy
+	}
+	return res
+}
+
+// getClaimsMapping makes map of user fields mapping per auth provider from s.Auth.ClaimsMap,
+// set as provider:field=user_field, i.e. github:login=name
+func (s *ServerCommand) getClaimsMapping() map[string][]rest.ClaimMapping {
+	if len(s.Auth.ClaimsMap) == 0 {
+		return nil
+	}
+	res := map[string][]rest.ClaimMapping{}
+	for _, v := range s.Auth.ClaimsMap {
+		providerName, mapping, ok := strings.Cut(strings.TrimSpace(v), ":")
+		field, userField, ok2 := strings.Cut(mapping, "=")
+		providerName, field, userField = strings.TrimSpace(providerName), strings.TrimSpace(field), strings.TrimSpace(userField)
+		if !ok || !ok2 || providerName == "" || field == "" {
+			log.Printf("[WARN] bad claims mapping %q, ignored", v)
+			continue
+		}
+		if userField != rest.ClaimName && userField != rest.ClaimPicture {
+			log.Printf("[WARN] unknown user field %q in claims mapping %q, ignored", userField, v)
+			continue
+		}
+		res[providerName] = append(res[providerName], rest.ClaimMapping{Field: field, User: userField})
+	}
+	return res
+}
+
+// getAllowedOrigins makes map of CORS allowed origins per site from s.AllowedOrigins.
+// Origins set as site=origin allowed for the particular site, origins without site allowed for all sites.
+func (s *ServerCommand) getAllowedOrigins() map[string][]string {
+	if len(s.AllowedOrigins) == 0 {
+		return nil
+	}
+	res := map[string][]string{}
+	for _, v := range s.AllowedOrigins {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		siteID, origin := api.AllSitesOrigins, v
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, origin = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		res[siteID] = append(res[siteID], origin)
+	}
+	return res
+}
+
+// getNotifyLocales makes map of notification locales per site from s.Notify.Email.Locales.
+// Locale set as site=locale used for the particular site, locale without site used for all other sites.
+func (s *ServerCommand) getNotifyLocales() map[string]string {
+	res := map[string]string{}
+	for _, v := range s.Notify.Email.Locales {
+		siteID, locale := notify.AllSitesLocale, strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, locale = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		if locale != "" {
+			res[siteID] = locale
+		}
+	}
+	return res
+}
+
 // Run all application objects
 func (a *serverApp) run(ctx context.Context) error {
 	if a.AdminPasswd != "" {
@@ -704,6 +1238,10 @@ func (a *serverApp) run(ctx context.Context) error {
 	}
 
 	go a.imageService.Cleanup(ctx) // pictures cleanup for staging images
+	a.activateRetention(ctx)       // runs in goroutine for each site with retention age set
+	if a.Trash.TTL > 0 {
+		go service.TrashPurge{DataStore: a.dataService, Sites: a.Sites, Interval: a.Trash.Interval}.Do(ctx)
+	}
 
 	a.restSrv.Run(a.Address, a.Port)
 
@@ -723,6 +1261,16 @@ func (a *serverApp) run(ctx context.Context) error {
 	if e := a.authRefreshCache.Close(); e != nil {
 		log.Printf("[WARN] failed to close auth authRefreshCache, %s", e)
 	}
+	if a.refreshStore != nil {
+		if e := a.refreshStore.Close(); e != nil {
+			log.Printf("[WARN] failed to close refresh tokens store, %s", e)
+		}
+	}
+	if a.keyRotator != nil {
+		if e := a.keyRotator.Close(); e != nil {
+			log.Printf("[WARN] failed to close signing keys store, %s", e)
+		}
+	}
 	a.notifyService.Close()
 	// call potentially infinite loop with cancellation after a minute as a safeguard
 	minuteCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
@@ -752,6 +1300,57 @@ func (a *serverApp) activateBackup(ctx context.Context) {
 	}
 }
 
+// activateRetention runs background retention for each site with max age of comments set
+func (a *serverApp) activateRetention(ctx context.Context) {
+	mode := store.HardDelete
+	if a.Retention.Mode == "anonymize" {
+		mode = store.AnonymizeDelete
+	}
+	for siteID, age := range a.getRetentionAges() {
+		r := service.Retention{
+			DataStore: a.dataService,
+			SiteID:    siteID,
+			MaxAge:    age,
+			Mode:      mode,
+			DryRun:    a.Retention.DryRun,
+			Interval:  a.Retention.Interval,
+			OnChange:  func(siteID string) { a.restSrv.Cache.Flush(cache.Flusher(siteID)) },
+		}
+		go r.Do(ctx)
+	}
+}
+
+// getRetentionAges makes map of max comment ages per site from s.Retention.Age.
+// Age set as site=age used for the particular site, age without site used for all other sites.
+func (s *ServerCommand) getRetentionAges() map[string]time.Duration {
+	res := map[string]time.Duration{}
+	defaultAge := time.Duration(0)
+	for _, v := range s.Retention.Age {
+		siteID, age := "", strings.TrimSpace(v)
+		if elems := strings.SplitN(v, "=", 2); len(elems) == 2 {
+			siteID, age = strings.TrimSpace(elems[0]), strings.TrimSpace(elems[1])
+		}
+		d, err := time.ParseDuration(age)
+		if err != nil || d <= 0 {
+			log.Printf("[WARN] invalid retention age %q, ignored", v)
+			continue
+		}
+		if siteID == "" {
+			defaultAge = d
+			continue
+		}
+		res[siteID] = d
+	}
+	if defaultAge > 0 {
+		for _, siteID := range s.Sites {
+			if _, ok := res[siteID]; !ok {
+				res[siteID] = defaultAge
+			}
+		}
+	}
+	return res
+}
+
 // makeDataStore creates store for all sites
 func (s *ServerCommand) makeDataStore() (result engine.Interface, err error) {
 	log.Printf("[INFO] make data store, type=%s", s.Store.Type)
@@ -766,6 +1365,13 @@ func (s *ServerCommand) makeDataStore() (result engine.Interface, err error) {
 			sites = append(sites, engine.BoltSite{SiteID: site, FileName: fmt.Sprintf("%s/%s.db", s.Store.Bolt.Path, site)})
 		}
 		result, err = engine.NewBoltDB(bolt.Options{Timeout: s.Store.Bolt.Timeout}, sites...)
+	case "postgres":
+		opts := engine.PostgresOptions{
+			MaxOpenConns:    s.Store.Postgres.MaxOpenConns,
+			MaxIdleConns:    s.Store.Postgres.MaxIdleConns,
+			ConnMaxLifetime: s.Store.Postgres.ConnMaxLifetime,
+		}
+		result, err = engine.NewPostgres(s.Store.Postgres.URL, opts, s.Sites...)
 	case "rpc":
 		r := &engine.RPC{Client: jrpc.Client{
 			API:        s.Store.RPC.API,
@@ -805,12 +1411,16 @@ func (s *ServerCommand) makeAvatarStore() (avatar.Store, error) {
 
 func (s *ServerCommand) makePicturesStore() (*image.Service, error) {
 	imageServiceParams := image.ServiceParams{
-		ImageAPI:     s.RemarkURL + "/api/v1/picture/",
-		ProxyAPI:     s.RemarkURL + "/api/v1/img",
-		EditDuration: s.EditDuration,
-		MaxSize:      s.Image.MaxSize,
-		MaxHeight:    s.Image.ResizeHeight,
-		MaxWidth:     s.Image.ResizeWidth,
+		ImageAPI:       s.RemarkURL + "/api/v1/picture/",
+		ProxyAPI:       s.RemarkURL + "/api/v1/img",
+		EditDuration:   s.EditDuration,
+		MaxSize:        s.Image.MaxSize,
+		MaxHeight:      s.Image.ResizeHeight,
+		MaxWidth:       s.Image.ResizeWidth,
+		MaxDimension:   s.Image.MaxDimension,
+		AllowedTypes:   s.Image.AllowedTypes,
+		RejectAnimated: s.Image.RejectAnimated,
+		ReEncode:       true,
 	}
 	switch s.Image.Type {
 	case "bolt":
@@ -823,11 +1433,17 @@ func (s *ServerCommand) makePicturesStore() (*image.Service, error) {
 		if err := makeDirs(s.Image.FS.Path); err != nil {
 			return nil, fmt.Errorf("failed to create pictures store: %w", err)
 		}
-		return image.NewService(&image.FileSystem{
+		fsStore := &image.FileSystem{
 			Location:   s.Image.FS.Path,
 			Staging:    s.Image.FS.Staging,
 			Partitions: s.Image.FS.Partitions,
-		}, imageServiceParams), nil
+		}
+		if s.Image.FS.MigrateFlat {
+			if _, err := fsStore.MigrateFlat(); err != nil {
+				return nil, fmt.Errorf("failed to migrate pictures to partitions: %w", err)
+			}
+		}
+		return image.NewService(fsStore, imageServiceParams), nil
 	case "rpc":
 		return image.NewService(&image.RPC{
 			Client: jrpc.Client{
@@ -895,9 +1511,14 @@ func (s *ServerCommand) makeCache() (LoadingCache, error) {
 	return nil, fmt.Errorf("unsupported cache type %s", s.Cache.Type)
 }
 
+// addAuthProviders adds enabled auth providers to authenticator, returns OIDC provider if enabled, nil otherwise.
+// Sessions of OIDC users logged out at the issuer ended by revoker.
+//
 //nolint:gocyclo // simple code but many if checks
-func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
+func (s *ServerCommand) addAuthProviders(authenticator *auth.Service, refresher *providers.TokenRefresher,
+	revoker providers.Revoker) (*providers.OIDC, error) {
 	providersCount := 0
+	claims := rest.ClaimsMapper{Mappings: s.getClaimsMapping()}
 	if s.Auth.Telegram {
 		providersCount++
 	}
@@ -913,36 +1534,68 @@ func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
 			provider.LoadApplePrivateKeyFromFile(s.Auth.Apple.PrivateKeyFilePath),
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		providersCount++
 	}
 	if s.Auth.Google.CID != "" && s.Auth.Google.CSEC != "" {
-		authenticator.AddProvider("google", s.Auth.Google.CID, s.Auth.Google.CSEC)
+		authenticator.AddProviderWithUserAttributes("google", s.Auth.Google.CID, s.Auth.Google.CSEC, claims.UserAttributes("google"))
 		providersCount++
 	}
 	if s.Auth.Github.CID != "" && s.Auth.Github.CSEC != "" {
-		authenticator.AddProvider("github", s.Auth.Github.CID, s.Auth.Github.CSEC)
+		authenticator.AddProviderWithUserAttributes("github", s.Auth.Github.CID, s.Auth.Github.CSEC, claims.UserAttributes("github"))
 		providersCount++
 	}
 	if s.Auth.Facebook.CID != "" && s.Auth.Facebook.CSEC != "" {
-		authenticator.AddProvider("facebook", s.Auth.Facebook.CID, s.Auth.Facebook.CSEC)
+		authenticator.AddProviderWithUserAttributes("facebook", s.Auth.Facebook.CID, s.Auth.Facebook.CSEC, claims.UserAttributes("facebook"))
 		providersCount++
 	}
 	if s.Auth.Microsoft.CID != "" && s.Auth.Microsoft.CSEC != "" {
-		authenticator.AddProvider("microsoft", s.Auth.Microsoft.CID, s.Auth.Microsoft.CSEC)
+		authenticator.AddProviderWithUserAttributes("microsoft", s.Auth.Microsoft.CID, s.Auth.Microsoft.CSEC, claims.UserAttributes("microsoft"))
 		providersCount++
 	}
 	if s.Auth.Yandex.CID != "" && s.Auth.Yandex.CSEC != "" {
-		authenticator.AddProvider("yandex", s.Auth.Yandex.CID, s.Auth.Yandex.CSEC)
+		authenticator.AddProviderWithUserAttributes("yandex", s.Auth.Yandex.CID, s.Auth.Yandex.CSEC, claims.UserAttributes("yandex"))
 		providersCount++
 	}
 	if s.Auth.Twitter.CID != "" && s.Auth.Twitter.CSEC != "" {
-		authenticator.AddProvider("twitter", s.Auth.Twitter.CID, s.Auth.Twitter.CSEC)
+		authenticator.AddProviderWithUserAttributes("twitter", s.Auth.Twitter.CID, s.Auth.Twitter.CSEC, claims.UserAttributes("twitter"))
 		providersCount++
 	}
 	if s.Auth.Patreon.CID != "" && s.Auth.Patreon.CSEC != "" {
-		authenticator.AddProvider("patreon", s.Auth.Patreon.CID, s.Auth.Patreon.CSEC)
+		authenticator.AddProviderWithUserAttributes("patreon", s.Auth.Patreon.CID, s.Auth.Patreon.CSEC, claims.UserAttributes("patreon"))
+		providersCount++
+	}
+
+	var oidc *providers.OIDC
+	if s.Auth.OIDC.Issuer != "" && s.Auth.OIDC.CID != "" && s.Auth.OIDC.CSEC != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		params := providers.OIDCParams{
+			Issuer:     s.Auth.OIDC.Issuer,
+			Cid:        s.Auth.OIDC.CID,
+			Csecret:    s.Auth.OIDC.CSEC,
+			Scopes:     s.Auth.OIDC.Scopes,
+			URL:        s.RemarkURL,
+			JWTIssuer:  "remark42",
+			JwtService: authenticator.TokenService(),
+			HTTPClient: &http.Client{Timeout: 30 * time.Second},
+			Refresher:  refresher,
+			Attributes: claims.UserAttributes(providers.OIDCName),
+			Revoker:    revoker,
+		}
+		if ava := authenticator.AvatarProxy(); ava != nil {
+			params.AvatarSaver = ava
+		}
+		var err error
+		oidc, err = providers.NewOIDC(ctx, params)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to make oidc provider: %w", err)
+		}
+		authenticator.AddCustomHandler(oidc)
+		if refresher != nil {
+			refresher.Add(oidc)
+		}
 		providersCount++
 	}
 
@@ -950,7 +1603,7 @@ func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
 		log.Print("[INFO] dev access enabled")
 		u, errURL := url.Parse(s.RemarkURL)
 		if errURL != nil {
-			return fmt.Errorf("can't parse Remark42 URL: %w", errURL)
+			return nil, fmt.Errorf("can't parse Remark42 URL: %w", errURL)
 		}
 		authenticator.AddDevProvider(u.Hostname(), 8084)
 		providersCount++
@@ -975,7 +1628,7 @@ func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
 		sndr := sender.NewEmailClient(params, log.Default())
 		tmpl, err := templates.Read(s.Auth.Email.MsgTemplate)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		authenticator.AddVerifProvider("email", string(tmpl), sndr)
 	}
@@ -1018,7 +1671,7 @@ func (s *ServerCommand) addAuthProviders(authenticator *auth.Service) error {
 		log.Printf("[WARN] no auth providers defined")
 	}
 
-	return nil
+	return oidc, nil
 }
 
 // creates and registers telegram auth, which we need separately from other auth providers
@@ -1038,6 +1691,24 @@ func (s *ServerCommand) makeTelegramAuth(authenticator *auth.Service) providers.
 	return nil
 }
 
+// makeOEmbed makes link previewer for sites with previews enabled, limited to the whitelisted providers.
+// Returns nil if previews not enabled for any site.
+func (s *ServerCommand) makeOEmbed() store.LinkPreviewer {
+	if len(s.OEmbed.Sites) == 0 {
+		return nil
+	}
+	providers := []service.OEmbedProvider{}
+	for _, p := range service.OEmbedProviders {
+		if contains(p.Name, s.OEmbed.Providers) {
+			providers = append(providers, p)
+		}
+	}
+	log.Printf("[INFO] link previews enabled for %v, providers %v", s.OEmbed.Sites, s.OEmbed.Providers)
+	res := service.NewOEmbed(s.OEmbed.Sites, providers, s.OEmbed.Timeout)
+	res.Limit = s.OEmbed.Limit
+	return res
+}
+
 func (s *ServerCommand) makeNotifyService(dataStore *service.DataStore, destinations []notify.Destination, telegram *notify.Telegram) *notify.Service {
 	if destinations == nil {
 		destinations = []notify.Destination{}
@@ -1091,25 +1762,29 @@ func (s *ServerCommand) makeNotifyDestinations(authenticator *auth.Service) ([]n
 			VerificationTemplatePath: s.emailVerificationTemplatePath, From: s.Notify.Email.From,
 			VerificationSubject: s.Notify.Email.VerificationSubject,
 			UnsubscribeURL:      s.RemarkURL + "/email/unsubscribe.html",
+			SiteTemplatesDir:    s.Notify.Email.SiteTemplates,
+			Locales:             s.getNotifyLocales(),
+			DigestInterval:      s.Notify.Email.Digest,
+			UserDigestInterval:  s.Notify.Email.UserDigest,
+			ThreadRate:          s.Notify.Email.ThreadRate,
+			ThreadCooldown:      s.Notify.Email.ThreadCooldown,
+			SMTPMaxIdle:         s.SMTP.MaxIdle,
 			// TODO: uncomment after #560 frontend part is ready and URL is known
 			// SubscribeURL:        s.RemarkURL + "/subscribe.html?token=",
+			UnsubscribeThreadURL: s.RemarkURL + "/email/unsubscribe-post.html",
 			TokenGenFn: func(userID, email, site string) (string, error) {
-				claims := token.Claims{
-					Handshake: &token.Handshake{ID: userID + "::" + email},
-					StandardClaims: jwt.StandardClaims{
-						Audience:  site,
-						ExpiresAt: time.Now().Add(100 * 365 * 24 * time.Hour).Unix(),
-						NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
-						Issuer:    "remark42",
-					},
-				}
-				tkn, err := authenticator.TokenService().Token(claims)
-				if err != nil {
-					return "", fmt.Errorf("failed to make unsubscription token: %w", err)
-				}
-				return tkn, nil
+				return makeUnsubscribeToken(authenticator, userID+"::"+email, site)
+			},
+			ThreadTokenGenFn: func(userID, email, site, postURL string) (string, error) {
+				return makeUnsubscribeToken(authenticator, "unsub-post::"+userID+"::"+email+"::"+postURL, site)
 			},
 		}
+		if contains("email", s.Notify.Users) {
+			emailParams.UnfollowURL = s.RemarkURL + "/email/unfollow.html"
+			emailParams.FollowTokenGenFn = func(email, site, postURL string) (string, error) {
+				return makeUnsubscribeToken(authenticator, "follow::"+email+"::"+postURL, site)
+			}
+		}
 		if contains("email", s.Notify.Admins) {
 			emailParams.AdminEmails = s.Admin.Shared.Email
 		}
@@ -1136,6 +1811,25 @@ func (s *ServerCommand) makeNotifyDestinations(authenticator *auth.Service) ([]n
 	return destinations, nil
 }
 
+// makeUnsubscribeToken makes long-living signed token for unsubscribe links, handshakeID identifies user, email and,
+// optionally, the post
+func makeUnsubscribeToken(authenticator *auth.Service, handshakeID, site string) (string, error) {
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: handshakeID},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  site,
+			ExpiresAt: time.Now().Add(100 * 365 * 24 * time.Hour).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+	tkn, err := authenticator.TokenService().Token(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to make unsubscription token: %w", err)
+	}
+	return tkn, nil
+}
+
 // constructs Telegram notify service
 func (s *ServerCommand) makeTelegramNotify() (*notify.Telegram, error) {
 	if contains("telegram", s.Notify.Admins) && s.Notify.Telegram.Channel == "" {
@@ -1185,9 +1879,121 @@ func (s *ServerCommand) makeSSLConfig() (config api.SSLConfig, err error) {
 	return config, err
 }
 
-// getAuthenticator creates new authenticator service, which doesn't have any auth providers enabled
-func (s *ServerCommand) getAuthenticator(ds *service.DataStore, avas avatar.Store, admns admin.Store, authRefreshCache *authRefreshCache) *auth.Service {
-	return auth.NewService(auth.Opts{
+// keyReader returns signing key of the site, admin store or key rotator
+type keyReader interface {
+	Key(siteID string) (string, error)
+}
+
+// makeAudienceReader loads allowed tokens audiences from file or env variable and keeps re-reading them
+// till context canceled. Returns nil if allowed audiences not set, any audience allowed in this case
+func (s *ServerCommand) makeAudienceReader(ctx context.Context) (token.Audience, error) {
+	if s.Auth.Audiences.File == "" && s.Auth.Audiences.Env == "" {
+		return nil, nil
+	}
+	res := &rest.AudienceWatcher{File: s.Auth.Audiences.File, Env: s.Auth.Audiences.Env, Interval: s.Auth.Audiences.Refresh}
+	if err := res.Load(); err != nil {
+		return nil, err
+	}
+	go res.Run(ctx)
+	return res, nil
+}
+
+// audNormalizer returns normalizer of site id used as tokens audience, nil if normalization not enabled
+func (s *ServerCommand) audNormalizer() func(string) string {
+	if !s.Auth.NormalizeAud {
+		return nil
+	}
+	return rest.LowerTrimAud
+}
+
+// getAuthenticator creates new authenticator service, which doesn't have any auth providers enabled.
+// Returns claims updater of the service as well, for dry runs. It has no side effects of issuing a token
+// and doesn't resolve and store avatars, mapped picture reported as is. Validator of the service returned
+// for inspection of tokens.
+func (s *ServerCommand) getAuthenticator(ds *service.DataStore, avas avatar.Store, keys keyReader, audiences token.Audience,
+	sessions *rest.SessionLimiter, authRefreshCache *authRefreshCache, refresher *providers.TokenRefresher) (*auth.Service, token.ClaimsUpdater, token.Validator) {
+	avatarFallback := &rest.AvatarFallback{Chain: s.AvatarFallback} // proxy set after auth service creation
+	var avatarUpload *rest.AvatarUpload                             // nil if upload disabled
+	if s.Avatar.Upload {
+		avatarUpload = &rest.AvatarUpload{}
+	}
+	claimsMapper := &rest.ClaimsMapper{Mappings: s.getClaimsMapping()}
+	providerTTL := &rest.ProviderTTL{JWT: parseProviderTTL(s.Auth.TTL.ProviderJWT), Cookie: parseProviderTTL(s.Auth.TTL.ProviderCookie)}
+	var issuer *rest.IssuerStamp // nil stamp does nothing
+	if s.Auth.IssuerFingerprint {
+		issuer = rest.NewIssuerStamp()
+		log.Printf("[INFO] tokens stamped with issuer fingerprint %s", issuer.Fingerprint)
+	}
+	updUser := func(c token.Claims, mapper *rest.ClaimsMapper) token.Claims { // set attributes, except avatar
+		c = mapper.Update(c)
+		c.User.SetAdmin(ds.IsAdmin(c.Audience, c.User.ID))
+		c.User.SetBoolAttr("blocked", ds.IsBlocked(c.Audience, c.User.ID))
+		c.User.SetStrAttr("trust", ds.TrustLevel(c.Audience, store.User{ID: c.User.ID, Admin: c.User.IsAdmin()}))
+		var err error
+		c.User.Email, err = ds.GetUserEmail(c.Audience, c.User.ID)
+		if err != nil {
+			log.Printf("[WARN] can't read email for %s, %v", c.User.ID, err)
+		}
+		rest.SetEmailVerified(c.User)
+
+		// don't allow anonymous and email with admins names
+		// exclude admin from impersonation detection over email, it prevents a valid admin to login with RestrictedNames
+		if strings.HasPrefix(c.User.ID, "anonymous_") || (strings.HasPrefix(c.User.ID, "email_") && !c.User.IsAdmin()) {
+			for _, a := range s.RestrictedNames {
+				if strings.EqualFold(strings.TrimSpace(c.User.Name), a) {
+					c.User.SetBoolAttr("blocked", true)
+					log.Printf("[INFO] blocked %+v, attempt to impersonate (restricted names)", c.User)
+					break
+				}
+			}
+		}
+		if !c.User.IsAdmin() && ds.IsNameBlocked(c.Audience, c.User.Name) {
+			c.User.SetBoolAttr("blocked", true)
+			log.Printf("[INFO] blocked %+v, name matches blocked names", c.User)
+		}
+		c = providerTTL.Update(c)
+		return issuer.Update(c)
+	}
+	claimsUpd := token.ClaimsUpdFunc(func(c token.Claims) token.Claims { // set attributes, on new token or refresh
+		if c.User == nil {
+			return c
+		}
+		return avatarUpload.Update(avatarFallback.Update(updUser(c, claimsMapper)))
+	})
+	dryRunMapper := &rest.ClaimsMapper{Mappings: claimsMapper.Mappings} // without proxy, picture not stored
+	dryRunUpd := token.ClaimsUpdFunc(func(c token.Claims) token.Claims {
+		if c.User == nil {
+			return c
+		}
+		return updUser(c, dryRunMapper)
+	})
+	validator := token.ValidatorFunc(func(tkn string, claims token.Claims) bool { // check on each auth call (in middleware)
+		ds.Metrics.TokenParsed(claims.ExpiresAt < time.Now().Unix())
+		if claims.User == nil {
+			return false
+		}
+		if claims.User.Audience == "" { // reject empty aud, made with old (pre 0.8.x) version of auth package
+			return false
+		}
+		if claims.User.BoolAttr("blocked") {
+			return false
+		}
+		if sessions.Revoked(claims) {
+			return false
+		}
+		if providerTTL.Expired(claims) {
+			log.Printf("[INFO] session of %s expired by provider's session ttl", claims.User.ID)
+			return false
+		}
+		if refresher != nil { // expired token renewed only if upstream refresh token is still valid
+			if err := refresher.Refresh(tkn, claims); err != nil {
+				log.Printf("[INFO] session of %s not extended, %v", claims.User.ID, err)
+				return false
+			}
+		}
+		return true
+	})
+	authenticator := auth.NewService(auth.Opts{
 		URL:            strings.TrimSuffix(s.RemarkURL, "/"),
 		Issuer:         "remark42",
 		TokenDuration:  s.Auth.TTL.JWT,
@@ -1196,44 +2002,24 @@ func (s *ServerCommand) getAuthenticator(ds *service.DataStore, avas avatar.Stor
 		SameSiteCookie: s.parseSameSite(s.Auth.SameSite),
 		SecureCookies:  strings.HasPrefix(s.RemarkURL, "https://"),
 		SecretReader: token.SecretFunc(func(aud string) (string, error) { // get secret per site
-			return admns.Key(aud)
+			aud = api.TrimPreviewAud(aud) // preview tokens signed with the key of their site
+			if normalize := s.audNormalizer(); normalize != nil {
+				aud = normalize(aud)
+			}
+			return keys.Key(aud)
 		}),
-		ClaimsUpd: token.ClaimsUpdFunc(func(c token.Claims) token.Claims { // set attributes, on new token or refresh
+		AudienceReader: audiences,
+		ClaimsUpd: token.ClaimsUpdFunc(func(c token.Claims) token.Claims { // issued token counted and registered in sessions
 			if c.User == nil {
 				return c
 			}
-			c.User.SetAdmin(ds.IsAdmin(c.Audience, c.User.ID))
-			c.User.SetBoolAttr("blocked", ds.IsBlocked(c.Audience, c.User.ID))
-			var err error
-			c.User.Email, err = ds.GetUserEmail(c.Audience, c.User.ID)
-			if err != nil {
-				log.Printf("[WARN] can't read email for %s, %v", c.User.ID, err)
-			}
-
-			// don't allow anonymous and email with admins names
-			// exclude admin from impersonation detection over email, it prevents a valid admin to login with RestrictedNames
-			if strings.HasPrefix(c.User.ID, "anonymous_") || (strings.HasPrefix(c.User.ID, "email_") && !c.User.IsAdmin()) {
-				for _, a := range s.RestrictedNames {
-					if strings.EqualFold(strings.TrimSpace(c.User.Name), a) {
-						c.User.SetBoolAttr("blocked", true)
-						log.Printf("[INFO] blocked %+v, attempt to impersonate (restricted names)", c.User)
-						break
-					}
-				}
-			}
-
+			ds.Metrics.TokenIssued()
+			c = claimsUpd.Update(c)
+			sessions.Register(c)
 			return c
 		}),
-		AdminPasswd: s.AdminPasswd,
-		Validator: token.ValidatorFunc(func(_ string, claims token.Claims) bool { // check on each auth call (in middleware)
-			if claims.User == nil {
-				return false
-			}
-			if claims.User.Audience == "" { // reject empty aud, made with old (pre 0.8.x) version of auth package
-				return false
-			}
-			return !claims.User.BoolAttr("blocked")
-		}),
+		AdminPasswd:       s.AdminPasswd,
+		Validator:         validator,
 		JWTQuery:          "jwt", // change default from "token" as it used for deleteme
 		AvatarStore:       avas,
 		AvatarResizeLimit: s.Avatar.RszLmt,
@@ -1243,6 +2029,44 @@ func (s *ServerCommand) getAuthenticator(ds *service.DataStore, avas avatar.Stor
 		UseGravatar:       true,
 		AudSecrets:        s.Admin.RPC.SecretPerSite,
 	})
+	avatarFallback.Proxy = authenticator.AvatarProxy()
+	if avatarUpload != nil {
+		avatarUpload.Proxy = authenticator.AvatarProxy()
+	}
+	claimsMapper.Proxy = authenticator.AvatarProxy()
+	return authenticator, dryRunUpd, validator
+}
+
+// makeTokenRefresher creates refresher of upstream oauth tokens with persistent store,
+// returns nil refresher and store if refresh disabled
+func (s *ServerCommand) makeTokenRefresher() (*providers.TokenRefresher, *providers.BoltRefreshStore, error) {
+	if !s.Auth.Refresh.Enable {
+		return nil, nil, nil
+	}
+	store, err := providers.NewBoltRefreshStore(s.Auth.Refresh.File, bolt.Options{Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, nil, err
+	}
+	refresher, err := providers.NewTokenRefresher(store, s.SharedSecret, 30*time.Second)
+	if err != nil {
+		_ = store.Close()
+		return nil, nil, err
+	}
+	log.Printf("[INFO] oauth refresh tokens enabled, store %s", s.Auth.Refresh.File)
+	return refresher, store, nil
+}
+
+// makeKeyRotator creates rotator of signing keys with persistent store, returns nil if rotation disabled
+func (s *ServerCommand) makeKeyRotator(adminStore admin.Store) (*admin.KeyRotator, error) {
+	if !s.Admin.KeyRotation.Enable {
+		return nil, nil
+	}
+	kr, err := admin.NewKeyRotator(adminStore, s.Admin.RPC.SecretPerSite, s.Admin.KeyRotation.File, bolt.Options{Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] signing keys rotation enabled, store %s", s.Admin.KeyRotation.File)
+	return kr, nil
 }
 
 func (s *ServerCommand) parseSameSite(ss string) http.SameSite {
@@ -1251,7 +2075,7 @@ func (s *ServerCommand) parseSameSite(ss string) http.SameSite {
 		return http.SameSiteDefaultMode
 	case "none":
 		return http.SameSiteNoneMode
-	case "lax":
+	case "lax", "auto": // auto adjusted per request by rest.SameSiteAuto, lax for requests without Sec-Fetch-Site
 		return http.SameSiteLaxMode
 	case "strict":
 		return http.SameSiteStrictMode
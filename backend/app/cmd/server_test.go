@@ -3,12 +3,15 @@ package cmd
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -22,6 +25,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/service"
 )
 
 func TestServerApp(t *testing.T) {
@@ -136,6 +144,7 @@ func TestServerApp_AnonMode(t *testing.T) {
 	tkn, claims := getAuthFromCookie(t, app, resp)
 	require.NotEmpty(t, tkn)
 	assert.False(t, claims.User.BoolAttr("blocked"), "should not be blocked")
+	assert.False(t, claims.User.BoolAttr("email_verified"), "anonymous user has no confirmed email")
 	req.Header.Add("X-JWT", tkn)
 	resp, err = client.Do(req)
 	require.NoError(t, err)
@@ -223,6 +232,209 @@ func getAuthFromCookie(t *testing.T, app *serverApp, resp *http.Response) (tkn s
 	return tkn, claims
 }
 
+func TestServerApp_RefreshTokens(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	refreshFile := filepath.Join(t.TempDir(), "refresh_tokens.db")
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Auth.Refresh.Enable = true
+		o.Auth.Refresh.File = refreshFile
+		return o
+	})
+	require.NotNil(t, app.refreshStore)
+	assert.FileExists(t, refreshFile)
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+	cancel()
+	app.Wait()
+
+	opts := ServerCommand{}
+	opts.SetCommon(CommonOpts{RemarkURL: "https://demo.remark42.com", SharedSecret: "123456"})
+	opts.Auth.Refresh.Enable = true
+	opts.Auth.Refresh.File = "/dev/null/refresh_tokens.db"
+	_, _, err := opts.makeTokenRefresher()
+	assert.Error(t, err)
+}
+
+func TestServerApp_KeyRotation(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	keysFile := filepath.Join(t.TempDir(), "signing_keys.db")
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Admin.KeyRotation.Enable = true
+		o.Admin.KeyRotation.File = keysFile
+		return o
+	})
+	require.NotNil(t, app.keyRotator)
+	assert.Equal(t, app.keyRotator, app.restSrv.KeyRotator)
+	assert.FileExists(t, keysFile)
+
+	// tokens signed with the rotated key
+	claims := token.Claims{User: &token.User{ID: "github_user"}, StandardClaims: jwt.StandardClaims{Audience: "remark"}}
+	tkn, err := app.authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	_, err = app.keyRotator.Rotate("remark", 0)
+	require.NoError(t, err)
+	_, err = app.authenticator.TokenService().Parse(tkn)
+	assert.Error(t, err, "token signed with replaced key")
+	tkn, err = app.authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	_, err = app.authenticator.TokenService().Parse(tkn)
+	assert.NoError(t, err)
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+	cancel()
+	app.Wait()
+
+	opts := ServerCommand{}
+	opts.Admin.KeyRotation.Enable = true
+	opts.Admin.KeyRotation.File = "/dev/null/signing_keys.db"
+	_, err = opts.makeKeyRotator(admin.NewStaticKeyStore("123456"))
+	assert.Error(t, err)
+}
+
+func TestServerApp_MaxSessions(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Auth.MaxSessions = 2
+		return o
+	})
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+	defer func() {
+		cancel()
+		app.Wait()
+	}()
+
+	login := func(id string) string {
+		claims := token.Claims{
+			StandardClaims: jwt.StandardClaims{Id: id, Audience: "remark", Issuer: "remark",
+				ExpiresAt: time.Now().Add(time.Minute).Unix(), NotBefore: time.Now().Add(-time.Minute).Unix()},
+			User: &token.User{ID: "github_dev", Name: "developer one"},
+		}
+		tkn, err := app.restSrv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, err)
+		return tkn
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	defer client.CloseIdleConnections()
+	userStatus := func(tkn string) int {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/api/v1/user?site=remark", port), http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("X-JWT", tkn)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	first, second := login("session-1"), login("session-2")
+	assert.Equal(t, http.StatusOK, userStatus(first))
+	assert.Equal(t, http.StatusOK, userStatus(second))
+
+	third := login("session-3")
+	assert.Equal(t, http.StatusUnauthorized, userStatus(first), "the first session revoked by the third login")
+	assert.Equal(t, http.StatusOK, userStatus(second))
+	assert.Equal(t, http.StatusOK, userStatus(third))
+}
+
+func TestServerApp_ProviderTTL(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Auth.TTL.ProviderJWT = []string{"anonymous=1m", "bad", "github=xyz"}
+		o.Auth.TTL.ProviderCookie = []string{"anonymous = 1h"}
+		return o
+	})
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+	defer func() {
+		cancel()
+		app.Wait()
+	}()
+
+	// login sets token the way providers do, returns claims of the issued token
+	login := func(userID string) token.Claims {
+		claims := token.Claims{
+			StandardClaims: jwt.StandardClaims{Id: "session-" + userID, Audience: "remark", Issuer: "remark",
+				NotBefore: time.Now().Add(-time.Minute).Unix()},
+			User: &token.User{ID: userID, Name: "user " + userID},
+		}
+		rr := httptest.NewRecorder()
+		_, err := app.restSrv.Authenticator.TokenService().Set(rr, claims)
+		require.NoError(t, err)
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "JWT" {
+				res, err := app.restSrv.Authenticator.TokenService().Parse(c.Value)
+				require.NoError(t, err)
+				return res
+			}
+		}
+		t.Fatalf("no token set for %s", userID)
+		return token.Claims{}
+	}
+
+	anon, oauth := login("anonymous_123"), login("github_123")
+	assert.InDelta(t, time.Now().Add(time.Minute).Unix(), anon.ExpiresAt, 2)
+	assert.InDelta(t, time.Now().Add(5*time.Minute).Unix(), oauth.ExpiresAt, 2, "global jwt ttl for oauth")
+	assert.Less(t, anon.ExpiresAt, oauth.ExpiresAt, "anonymous session expires faster")
+	assert.NotEmpty(t, anon.User.StrAttr("session_start"))
+	assert.Empty(t, oauth.User.StrAttr("session_start"))
+}
+
+func TestServerApp_DryRunClaimsUpd(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.AvatarFallback = []string{"identicon"} // provider's avatar replaced with identicon on login
+		return o
+	})
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+	defer func() {
+		cancel()
+		app.Wait()
+	}()
+
+	proxy := app.restSrv.Authenticator.AvatarProxy()
+	avatarID, err := proxy.Store.Put("github_dev", strings.NewReader("provider's avatar"))
+	require.NoError(t, err)
+	claims := token.Claims{StandardClaims: jwt.StandardClaims{Audience: "remark"},
+		User: &token.User{ID: "github_dev", Name: "developer one", Picture: proxy.URL + proxy.RoutePath + "/" + avatarID}}
+
+	res := app.restSrv.ApplyClaimsUpd(claims)
+	assert.Equal(t, claims.User.Picture, res.User.Picture, "avatar not resolved on dry run")
+	assert.False(t, res.User.IsAdmin())
+	rd, _, err := proxy.Store.Get(avatarID)
+	require.NoError(t, err)
+	defer rd.Close()
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	assert.Equal(t, "provider's avatar", string(data), "stored avatar not changed")
+}
+
+func TestServerApp_OEmbed(t *testing.T) {
+	opts := ServerCommand{}
+	p := flags.NewParser(&opts, flags.Default)
+	_, err := p.ParseArgs([]string{"--admin-passwd=password"})
+	require.NoError(t, err)
+	assert.Nil(t, opts.makeOEmbed(), "link previews disabled by default")
+
+	opts.OEmbed.Sites = []string{"remark"}
+	opts.OEmbed.Providers = []string{"vimeo", "unknown"}
+	opts.OEmbed.Limit = 2
+	previewer := opts.makeOEmbed()
+	require.IsType(t, &service.OEmbed{}, previewer)
+	res := previewer.(*service.OEmbed)
+	assert.Equal(t, []string{"remark"}, res.Sites)
+	require.Len(t, res.Providers, 1)
+	assert.Equal(t, "vimeo", res.Providers[0].Name)
+	assert.Equal(t, 2, res.Limit)
+}
+
 func TestServerApp_WithSSL(t *testing.T) {
 	opts := ServerCommand{}
 	sslPort := chooseRandomUnusedPort()
@@ -682,6 +894,73 @@ func TestServerAuthHooks(t *testing.T) {
 	client.CloseIdleConnections()
 }
 
+func TestServerApp_Metrics(t *testing.T) {
+	port := chooseRandomUnusedPort()
+	app, ctx, cancel := prepServerApp(t, func(o ServerCommand) ServerCommand {
+		o.Port = port
+		o.Metrics = true
+		return o
+	})
+
+	go func() { _ = app.run(ctx) }()
+	waitForHTTPServerStart(port)
+
+	claims := token.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  "remark",
+			Issuer:    "remark",
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+		},
+		User: &token.User{ID: "github_dev", Name: "developer one"},
+	}
+	tk, err := app.restSrv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+
+	client := http.Client{Timeout: 10 * time.Second}
+	defer client.CloseIdleConnections()
+	send := func(method, url, body string, auth func(r *http.Request)) string {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d%s", port, url), strings.NewReader(body))
+		require.NoError(t, err)
+		auth(req)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.True(t, resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated, string(b))
+		return string(b)
+	}
+	user := func(r *http.Request) { r.Header.Set("X-JWT", tk) }
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "password") }
+	none := func(*http.Request) {}
+
+	metrics := send(http.MethodGet, "/metrics", "", none)
+	assert.Contains(t, metrics, "remark42_comments_created_total 0\n")
+	assert.Contains(t, metrics, `remark42_tokens_total{event="issued"} 1`+"\n")
+
+	body := send(http.MethodPost, "/api/v1/comment",
+		`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark"}}`, user)
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	send(http.MethodPost, "/api/v1/comment",
+		`{"text": "test 456", "locator":{"url": "https://radio-t.com/blah1", "site": "remark"}}`, admin)
+	send(http.MethodPut, "/api/v1/vote/"+c.ID+"?site=remark&url=https://radio-t.com/blah1&vote=1", "", admin)
+	send(http.MethodDelete, "/api/v1/admin/comment/"+c.ID+"?site=remark&url=https://radio-t.com/blah1", "", admin)
+
+	metrics = send(http.MethodGet, "/metrics", "", none)
+	assert.Contains(t, metrics, "remark42_comments_created_total 2\n")
+	assert.Contains(t, metrics, "remark42_comments_deleted_total 1\n")
+	assert.Contains(t, metrics, "remark42_votes_total 1\n")
+	assert.Contains(t, metrics, `remark42_tokens_total{event="parsed"} 1`+"\n")
+	assert.Contains(t, metrics, `remark42_tokens_total{event="expired"} 0`+"\n")
+	assert.Contains(t, metrics, `remark42_comment_create_duration_seconds_bucket{le="+Inf"} 2`+"\n")
+	assert.Contains(t, metrics, "remark42_comment_create_duration_seconds_count 2\n")
+
+	cancel()
+	app.Wait()
+}
+
 func TestServerCommand_parseSameSite(t *testing.T) {
 	tbl := []struct {
 		inp string
@@ -693,6 +972,7 @@ func TestServerCommand_parseSameSite(t *testing.T) {
 		{"none", http.SameSiteNoneMode},
 		{"lax", http.SameSiteLaxMode},
 		{"strict", http.SameSiteStrictMode},
+		{"auto", http.SameSiteLaxMode},
 	}
 
 	cmd := ServerCommand{}
@@ -704,6 +984,35 @@ func TestServerCommand_parseSameSite(t *testing.T) {
 	}
 }
 
+func TestServerCommand_makeAudienceReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := ServerCommand{}
+	auds, err := cmd.makeAudienceReader(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, auds, "any audience allowed if not set")
+
+	file := filepath.Join(t.TempDir(), "auds.txt")
+	cmd.Auth.Audiences.File = file
+	_, err = cmd.makeAudienceReader(ctx)
+	assert.ErrorContains(t, err, "can't read allowed audiences")
+
+	require.NoError(t, os.WriteFile(file, []byte("remark\nsite1"), 0o600))
+	cmd.Auth.Audiences.Refresh = 10 * time.Millisecond
+	auds, err = cmd.makeAudienceReader(ctx)
+	require.NoError(t, err)
+	res, err := auds.Get()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remark", "site1"}, res)
+
+	require.NoError(t, os.WriteFile(file, []byte("site2"), 0o600))
+	require.Eventually(t, func() bool {
+		res, _ = auds.Get()
+		return assert.ObjectsAreEqual([]string{"site2"}, res)
+	}, time.Second, 10*time.Millisecond)
+}
+
 func Test_splitAtCommas(t *testing.T) {
 	tbl := []struct {
 		inp string
@@ -726,6 +1035,117 @@ func Test_splitAtCommas(t *testing.T) {
 	}
 }
 
+func Test_getAllowedOrigins(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getAllowedOrigins())
+
+	cmd.AllowedOrigins = []string{"https://example.com", "site1=https://site1.com", " site1 = https://www.site1.com ",
+		"site2=*", ""}
+	assert.Equal(t, map[string][]string{
+		"*":     {"https://example.com"},
+		"site1": {"https://site1.com", "https://www.site1.com"},
+		"site2": {"*"},
+	}, cmd.getAllowedOrigins())
+}
+
+func Test_getHiddenUserFields(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getHiddenUserFields())
+
+	cmd.HiddenUserFields = []string{"picture", "site1=id", " site1 = name ", "site2=email", "bad", ""}
+	assert.Equal(t, map[string][]string{"*": {"picture"}, "site1": {"id", "name"}}, cmd.getHiddenUserFields())
+}
+
+func Test_getClaimsMapping(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getClaimsMapping())
+
+	cmd.Auth.ClaimsMap = []string{"github:display_name=name", " github : login = name ", "oidc:avatar_url=picture",
+		"oidc:mail=email", "bad", "github:login", ":field=name", ""}
+	assert.Equal(t, map[string][]rest.ClaimMapping{
+		"github": {{Field: "display_name", User: "name"}, {Field: "login", User: "name"}},
+		"oidc":   {{Field: "avatar_url", User: "picture"}},
+	}, cmd.getClaimsMapping())
+}
+
+func Test_getMaxImages(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getMaxImages())
+
+	cmd.MaxImages = []string{"5", "site1=0", " site2 = 3 ", "site3=bad", "site4=-1", ""}
+	assert.Equal(t, map[string]int{"*": 5, "site1": 0, "site2": 3}, cmd.getMaxImages())
+}
+
+func Test_getReviewExpiry(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getReviewExpiry())
+
+	cmd.Review.Expiry = []string{"publish", "site1=reject", " site2 = Publish ", "site3=drop", ""}
+	assert.Equal(t, map[string]string{"*": "publish", "site1": "reject", "site2": "publish"}, cmd.getReviewExpiry())
+}
+
+func Test_getReplyEdit(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getReplyEdit())
+
+	cmd.ReplyEdit = []string{"delete", "site1=lock", " site2 = Allow ", "site3=edit", ""}
+	assert.Equal(t, map[string]string{"*": "delete", "site1": "lock", "site2": "allow"}, cmd.getReplyEdit())
+}
+
+func Test_getAllowedScripts(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getAllowedScripts())
+
+	cmd.AllowedScripts = []string{"latin", "site1=Cyrillic", " site1 = latin ", "site2=Klingon", ""}
+	assert.Equal(t, map[string][]string{"*": {"Latin"}, "site1": {"Cyrillic", "Latin"}}, cmd.getAllowedScripts())
+}
+
+func Test_getBlockedNames(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getBlockedNames())
+
+	cmd.BlockedNames = []string{"admin", "site1=troll", " site1 = bad name ", "site2=", ""}
+	assert.Equal(t, map[string][]string{"*": {"admin"}, "site1": {"troll", "bad name"}}, cmd.getBlockedNames())
+}
+
+func Test_getHoneypotFields(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Nil(t, cmd.getHoneypotFields())
+
+	cmd.Honeypot = []string{"website", "site1=url", " site2 = phone ", "site3=", ""}
+	assert.Equal(t, map[string]string{"*": "website", "site1": "url", "site2": "phone"}, cmd.getHoneypotFields())
+}
+
+func Test_getFederatedIssuers(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Equal(t, map[string]string{}, cmd.getFederatedIssuers())
+
+	cmd.Auth.Federated = []string{"https://idp1.example.com=https://idp1.example.com/jwks",
+		" https://idp2.example.com/ = https://idp2.example.com/keys?v=2 ", "https://idp3.example.com", "=https://x.example.com/jwks", ""}
+	assert.Equal(t, map[string]string{"https://idp1.example.com": "https://idp1.example.com/jwks",
+		"https://idp2.example.com/": "https://idp2.example.com/keys?v=2"}, cmd.getFederatedIssuers())
+}
+
+func Test_getNotifyLocales(t *testing.T) {
+	cmd := ServerCommand{}
+	assert.Equal(t, map[string]string{}, cmd.getNotifyLocales())
+
+	cmd.Notify.Email.Locales = []string{"de", "site1=ru", " site2 = en ", "site3=", ""}
+	assert.Equal(t, map[string]string{"*": "de", "site1": "ru", "site2": "en"}, cmd.getNotifyLocales())
+}
+
+func Test_getRetentionAges(t *testing.T) {
+	cmd := ServerCommand{Sites: []string{"site1", "site2", "site3"}}
+	assert.Equal(t, map[string]time.Duration{}, cmd.getRetentionAges())
+
+	cmd.Retention.Age = []string{"site1=720h", " site2 = 24h ", "site4=bad", "site3=-1h", ""}
+	assert.Equal(t, map[string]time.Duration{"site1": 720 * time.Hour, "site2": 24 * time.Hour}, cmd.getRetentionAges())
+
+	cmd.Retention.Age = []string{"8760h", "site1=720h"}
+	assert.Equal(t, map[string]time.Duration{"site1": 720 * time.Hour, "site2": 8760 * time.Hour, "site3": 8760 * time.Hour},
+		cmd.getRetentionAges())
+}
+
 func Test_getAllowedDomains(t *testing.T) {
 	tbl := []struct {
 		s              ServerCommand
@@ -793,7 +1213,6 @@ func prepServerApp(t *testing.T, fn func(o ServerCommand) ServerCommand) (*serve
 	_, err := p.ParseArgs([]string{"--admin-passwd=password", "--site=remark"})
 	require.NoError(t, err)
 	cmd.Avatar.FS.Path, cmd.Avatar.Type, cmd.BackupLocation, cmd.Image.FS.Path = "/tmp/remark42_test", "fs", "/tmp/remark42_test", "/tmp/remark42_test"
-	cmd.Store.Bolt.Path = fmt.Sprintf("/tmp/%d", cmd.Port)
 	cmd.Store.Bolt.Timeout = 10 * time.Second
 	cmd.Auth.Apple.CID, cmd.Auth.Apple.KID, cmd.Auth.Apple.TID = "cid", "kid", "tid"
 	cmd.Auth.Apple.PrivateKeyFilePath = "testdata/apple.p8"
@@ -825,6 +1244,7 @@ func prepServerApp(t *testing.T, fn func(o ServerCommand) ServerCommand) (*serve
 	cmd.emailMsgTemplatePath = "../../templates/email_reply.html.tmpl"
 	cmd.emailVerificationTemplatePath = "../../templates/email_confirmation_subscription.html.tmpl"
 	cmd = fn(cmd)
+	cmd.Store.Bolt.Path = fmt.Sprintf("/tmp/%d", cmd.Port) // set after fn to get a separate store for each port
 
 	app, ctx, cancel := createAppFromCmd(t, cmd)
 
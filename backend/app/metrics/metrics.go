@@ -0,0 +1,198 @@
+// Package metrics collects counters and histograms of comments, auth tokens and notifications
+// and exposes them in Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are upper bounds of histogram buckets in seconds, same as default of Prometheus client
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects operations counters and durations. All methods are safe to call on nil Metrics,
+// doing nothing, so users don't need to check if metrics enabled.
+type Metrics struct {
+	commentsCreated, commentsDeleted, votes   *counter
+	tokensIssued, tokensParsed, tokensExpired *counter
+	notifySent, notifyFailed                  *counter
+	createDuration, notifyDuration            *histogram
+
+	families []family // in exposition order
+}
+
+// family is a named metric with its series, shares help and type
+type family struct {
+	name, help, kind string
+	series           []series
+}
+
+// series writes its samples in text format
+type series interface {
+	write(w io.Writer, name string)
+}
+
+// counter is a monotonic counter, labels set in text format, like `event="issued"`
+type counter struct {
+	labels string
+	value  uint64
+}
+
+// histogram counts observations in buckets with cumulative sum
+type histogram struct {
+	buckets []float64
+
+	lock   sync.Mutex
+	counts []uint64 // per bucket, not cumulative, the last one for +Inf
+	sum    float64
+}
+
+// New makes Metrics with all counters set to zero
+func New() *Metrics {
+	m := Metrics{
+		commentsCreated: &counter{},
+		commentsDeleted: &counter{},
+		votes:           &counter{},
+		tokensIssued:    &counter{labels: `event="issued"`},
+		tokensParsed:    &counter{labels: `event="parsed"`},
+		tokensExpired:   &counter{labels: `event="expired"`},
+		notifySent:      &counter{labels: `result="sent"`},
+		notifyFailed:    &counter{labels: `result="failed"`},
+		createDuration:  newHistogram(DefaultBuckets),
+		notifyDuration:  newHistogram(DefaultBuckets),
+	}
+	m.families = []family{
+		{"remark42_comments_created_total", "Comments created.", "counter", []series{m.commentsCreated}},
+		{"remark42_comments_deleted_total", "Comments deleted.", "counter", []series{m.commentsDeleted}},
+		{"remark42_votes_total", "Votes for comments.", "counter", []series{m.votes}},
+		{"remark42_tokens_total", "Auth tokens issued on login or refresh, parsed on auth requests and found expired.", "counter",
+			[]series{m.tokensIssued, m.tokensParsed, m.tokensExpired}},
+		{"remark42_notifications_total", "Notifications sent to destinations, by result.", "counter",
+			[]series{m.notifySent, m.notifyFailed}},
+		{"remark42_comment_create_duration_seconds", "Duration of comment creation in the store.", "histogram",
+			[]series{m.createDuration}},
+		{"remark42_notification_duration_seconds", "Duration of notification delivery to a destination.", "histogram",
+			[]series{m.notifyDuration}},
+	}
+	return &m
+}
+
+// CommentCreated counts created comment with the time it took
+func (m *Metrics) CommentCreated(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.commentsCreated.inc()
+	m.createDuration.observe(d.Seconds())
+}
+
+// CommentDeleted counts deleted comment
+func (m *Metrics) CommentDeleted() {
+	if m == nil {
+		return
+	}
+	m.commentsDeleted.inc()
+}
+
+// Voted counts vote for a comment
+func (m *Metrics) Voted() {
+	if m == nil {
+		return
+	}
+	m.votes.inc()
+}
+
+// TokenIssued counts auth token issued on login or refresh
+func (m *Metrics) TokenIssued() {
+	if m == nil {
+		return
+	}
+	m.tokensIssued.inc()
+}
+
+// TokenParsed counts auth token parsed on request, expired tokens counted separately as well
+func (m *Metrics) TokenParsed(expired bool) {
+	if m == nil {
+		return
+	}
+	m.tokensParsed.inc()
+	if expired {
+		m.tokensExpired.inc()
+	}
+}
+
+// Notified counts notification sent to a destination, failed if err not nil, with the time it took
+func (m *Metrics) Notified(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.notifyFailed.inc()
+	} else {
+		m.notifySent.inc()
+	}
+	m.notifyDuration.observe(d.Seconds())
+}
+
+// ServeHTTP responds with all metrics in Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	buf := bytes.Buffer{}
+	if m != nil {
+		for _, f := range m.families {
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+			for _, s := range f.series {
+				s.write(&buf, f.name)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (c *counter) inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *counter) write(w io.Writer, name string) {
+	if c.labels != "" {
+		name += "{" + c.labels + "}"
+	}
+	fmt.Fprintf(w, "%s %d\n", name, atomic.LoadUint64(&c.value))
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.sum += v
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+			return
+		}
+	}
+	h.counts[len(h.buckets)]++
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var total uint64
+	for i, b := range h.buckets {
+		total += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), total)
+	}
+	total += h.counts[len(h.buckets)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, total)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, total)
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.CommentCreated(3 * time.Millisecond)
+	m.CommentCreated(200 * time.Millisecond)
+	m.CommentCreated(20 * time.Second)
+	m.CommentDeleted()
+	m.Voted()
+	m.Voted()
+	m.TokenIssued()
+	m.TokenParsed(false)
+	m.TokenParsed(true)
+	m.Notified(time.Millisecond, nil)
+	m.Notified(time.Millisecond, errors.New("failed"))
+
+	out := scrape(t, m)
+	for _, line := range []string{
+		"# HELP remark42_comments_created_total Comments created.",
+		"# TYPE remark42_comments_created_total counter",
+		"remark42_comments_created_total 3",
+		"remark42_comments_deleted_total 1",
+		"remark42_votes_total 2",
+		`remark42_tokens_total{event="issued"} 1`,
+		`remark42_tokens_total{event="parsed"} 2`,
+		`remark42_tokens_total{event="expired"} 1`,
+		`remark42_notifications_total{result="sent"} 1`,
+		`remark42_notifications_total{result="failed"} 1`,
+		"# TYPE remark42_comment_create_duration_seconds histogram",
+		`remark42_comment_create_duration_seconds_bucket{le="0.005"} 1`,
+		`remark42_comment_create_duration_seconds_bucket{le="0.1"} 1`,
+		`remark42_comment_create_duration_seconds_bucket{le="0.25"} 2`,
+		`remark42_comment_create_duration_seconds_bucket{le="10"} 2`,
+		`remark42_comment_create_duration_seconds_bucket{le="+Inf"} 3`,
+		"remark42_comment_create_duration_seconds_sum 20.203",
+		"remark42_comment_create_duration_seconds_count 3",
+		`remark42_notification_duration_seconds_bucket{le="0.005"} 2`,
+		"remark42_notification_duration_seconds_count 2",
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.CommentCreated(time.Second)
+		m.CommentDeleted()
+		m.Voted()
+		m.TokenIssued()
+		m.TokenParsed(true)
+		m.Notified(time.Second, nil)
+	})
+	assert.Equal(t, "", scrape(t, m))
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(b)
+}
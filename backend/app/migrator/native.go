@@ -1,7 +1,6 @@
 package migrator
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -33,39 +32,25 @@ type meta struct {
 }
 
 // Export all comments to writer as json strings. Each comment is one string, separated by "\n"
-// The final file is a valid json
+// The final file is a valid json. Comments written one by one as read from the store, so export of a large site
+// doesn't load all comments in memory and reading from the store paced by the writer.
 func (n *Native) Export(w io.Writer, siteID string) (size int, err error) {
 	if err = n.exportMeta(siteID, w); err != nil {
 		return 0, fmt.Errorf("failed to export meta for site %s: %w", siteID, err)
 	}
 
-	topics, err := n.DataStore.List(siteID, 0, 0)
-	if err != nil {
-		return 0, err
-	}
-
-	log.Printf("[DEBUG] exporting %d topics", len(topics))
 	commentsCount := 0
-	for i := len(topics) - 1; i >= 0; i-- { // topics from List sorted in opposite direction
-		topic := topics[i]
-		comments, e := n.DataStore.Find(store.Locator{SiteID: siteID, URL: topic.URL}, "time", adminUser)
-		if e != nil {
-			return commentsCount, e
-		}
-
-		for _, comment := range comments {
-			buf := &bytes.Buffer{}
-			enc := json.NewEncoder(buf)
-			enc.SetEscapeHTML(false)
-
-			if err = enc.Encode(comment); err != nil {
-				return commentsCount, fmt.Errorf("can't marshal %v: %w", comments, err)
-			}
-			if _, err = w.Write(buf.Bytes()); err != nil {
-				return commentsCount, fmt.Errorf("can't write comment data: %w", err)
-			}
-			commentsCount++
+	enc := json.NewEncoder(w) // writes each encoded comment with a single Write call
+	enc.SetEscapeHTML(false)
+	err = n.DataStore.IterateComments(siteID, adminUser, func(comment store.Comment) error {
+		if e := enc.Encode(comment); e != nil {
+			return fmt.Errorf("can't write comment %s: %w", comment.ID, e)
 		}
+		commentsCount++
+		return nil
+	})
+	if err != nil {
+		return commentsCount, err
 	}
 	log.Printf("[DEBUG] exported %d comments", commentsCount)
 	return commentsCount, nil
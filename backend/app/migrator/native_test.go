@@ -67,6 +67,69 @@ func TestNative_Export(t *testing.T) {
 	assert.Equal(t, "some text, <a href=\"http://radio-t.com\" rel=\"nofollow\">link</a>", comments[0].Text)
 }
 
+func TestNative_ExportStreaming(t *testing.T) {
+	const total = 100000
+	st := &iteratingStore{total: total}
+	w := &countingWriter{store: st}
+	r := Native{DataStore: st}
+
+	size, err := r.Export(w, "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, total, size)
+	assert.Equal(t, total+1, w.lines, "meta and all comments written")
+	assert.Equal(t, 1, w.maxPending, "each comment written before the next one read from store")
+
+	// export stopped on write error
+	st = &iteratingStore{total: total}
+	w = &countingWriter{store: st, failAfter: 1000}
+	size, err = (&Native{DataStore: st}).Export(w, "radio-t")
+	require.Error(t, err)
+	assert.Equal(t, 999, size)
+	assert.Equal(t, 1000, st.produced, "no more comments read after failed write")
+}
+
+// iteratingStore generates comments on the fly, never keeping more than one in memory
+type iteratingStore struct {
+	Store
+	total    int
+	produced int
+}
+
+func (s *iteratingStore) Metas(string) ([]service.UserMetaData, []service.PostMetaData, error) {
+	return []service.UserMetaData{}, []service.PostMetaData{}, nil
+}
+
+func (s *iteratingStore) IterateComments(siteID string, _ store.User, fn func(store.Comment) error) error {
+	for i := 0; i < s.total; i++ {
+		s.produced++
+		c := store.Comment{ID: fmt.Sprintf("id-%d", i), Text: "some text", Locator: store.Locator{SiteID: siteID, URL: "https://radio-t.com"}}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingWriter counts written lines and tracks comments read from store but not written yet
+type countingWriter struct {
+	store      *iteratingStore
+	failAfter  int
+	lines      int
+	maxPending int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.failAfter > 0 && w.lines == w.failAfter {
+		return 0, fmt.Errorf("write failed")
+	}
+	// comments read from store but not written yet, including the one being written, the first line is meta
+	if pending := w.store.produced - (w.lines - 1); w.lines > 0 && pending > w.maxPending {
+		w.maxPending = pending
+	}
+	w.lines += bytes.Count(p, []byte("\n"))
+	return len(p), nil
+}
+
 func TestNative_Import(t *testing.T) {
 	b, teardown := prep(t) // write 2 comments
 	defer teardown()
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// digestSendTimeout limits sending of a single digest, it's not bound to any request context
+const digestSendTimeout = time.Minute
+
+// defaultUserDigestInterval used for users preferring digest if neither DigestInterval nor UserDigestInterval set
+const defaultUserDigestInterval = 24 * time.Hour
+
+// digestKey identifies recipient of the digest. Digests collected per site as unsubscribe link and locale are per site,
+// admin copies collected separately from user's notifications
+type digestKey struct {
+	siteID   string
+	email    string
+	forAdmin bool
+}
+
+// digest keeps notifications for a recipient collected during the digest interval
+type digest struct {
+	items []msgTmplData
+	ids   map[string]bool // comment links already in the digest, to skip duplicates
+	timer *time.Timer
+}
+
+// digestTmplData store data for digest message template execution
+type digestTmplData struct {
+	Comments        []msgTmplData
+	Email           string
+	UnsubscribeLink string
+	ForAdmin        bool
+
+	catalog catalog // localized messages for T and FormatDate
+}
+
+// T returns localized message by key, formatted with args
+func (d digestTmplData) T(key string, args ...interface{}) string {
+	return d.catalog.message(key, args...)
+}
+
+// FormatDate returns time formatted with the locale's date format
+func (d digestTmplData) FormatDate(t time.Time) string {
+	return d.catalog.formatDate(t)
+}
+
+// addToDigest queues notification about the comment for the recipient. The first notification of the digest
+// starts the timer, the digest sent when interval passed. Repeated notifications about the same comment ignored.
+func (e *Email) addToDigest(req Request, email string, forAdmin bool, interval time.Duration) error {
+	data, _, err := e.buildTmplData(req, email, forAdmin)
+	if err != nil {
+		return err
+	}
+	key := digestKey{siteID: req.Comment.Locator.SiteID, email: email, forAdmin: forAdmin}
+
+	e.digestLock.Lock()
+	defer e.digestLock.Unlock()
+	d, ok := e.digests[key]
+	if !ok {
+		d = &digest{ids: map[string]bool{}}
+		d.timer = time.AfterFunc(interval, func() { e.sendDigest(key) })
+		e.digests[key] = d
+	}
+	if d.ids[data.CommentLink] {
+		return nil
+	}
+	d.ids[data.CommentLink] = true
+	d.items = append(d.items, data)
+	log.Printf("[DEBUG] comment id %s added to digest for %s, %d comments", req.Comment.ID, email, len(d.items))
+	return nil
+}
+
+// digestInterval returns interval of digests, DigestInterval if set, and interval for users preferring digest otherwise
+func (e *Email) digestInterval() time.Duration {
+	if e.DigestInterval > 0 {
+		return e.DigestInterval
+	}
+	if e.UserDigestInterval > 0 {
+		return e.UserDigestInterval
+	}
+	return defaultUserDigestInterval
+}
+
+// Flush sends all pending digests without waiting for the end of digest interval
+func (e *Email) Flush() {
+	e.digestLock.Lock()
+	keys := make([]digestKey, 0, len(e.digests))
+	for k, d := range e.digests {
+		d.timer.Stop()
+		keys = append(keys, k)
+	}
+	e.digestLock.Unlock()
+
+	for _, k := range keys {
+		e.sendDigest(k)
+	}
+}
+
+// sendDigest builds and sends the pending digest for the recipient, if any
+func (e *Email) sendDigest(key digestKey) {
+	e.digestLock.Lock()
+	d, ok := e.digests[key]
+	delete(e.digests, key)
+	e.digestLock.Unlock()
+	if !ok || len(d.items) == 0 {
+		return
+	}
+
+	msg, err := e.buildDigestMessage(key, d.items)
+	if err != nil {
+		log.Printf("[WARN] can't build digest for %s, %v", key.email, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), digestSendTimeout)
+	defer cancel()
+	log.Printf("[DEBUG] send digest via %s to %s, %d comments", e, key.email, len(d.items))
+	if err = e.send(ctx, key.email, msg); err != nil {
+		log.Printf("[WARN] problem sending email digest to %q: %v", key.email, err)
+	}
+}
+
+// buildDigestMessage generates digest message with all comments using e.digestTmpl
+func (e *Email) buildDigestMessage(key digestKey, items []msgTmplData) (commentMessage, error) {
+	cat := e.siteCatalog(key.siteID)
+	data := digestTmplData{Comments: items, Email: key.email, ForAdmin: key.forAdmin, catalog: cat}
+	if !key.forAdmin {
+		data.UnsubscribeLink = items[len(items)-1].UnsubscribeLink
+	}
+
+	msg := bytes.Buffer{}
+	if err := e.digestTmpl.Execute(&msg, data); err != nil {
+		return commentMessage{}, fmt.Errorf("error executing template to build digest message: %w", err)
+	}
+	return commentMessage{
+		subject:         cat.message("subject_digest", len(items)),
+		body:            msg.String(),
+		unsubscribeLink: data.UnsubscribeLink,
+	}, nil
+}
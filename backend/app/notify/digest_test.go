@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ntf "github.com/go-pkgz/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestEmail_Digest(t *testing.T) {
+	email, sent := prepDigestEmail(t, 200*time.Millisecond)
+	email.AdminEmails = []string{"admin@example.org"}
+
+	parent := store.Comment{ID: "p1", User: store.User{ID: "999", Name: "parent_user"}, Text: "parent text",
+		Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post1"}}
+	reply := func(id, text string) Request {
+		return Request{
+			Comment: store.Comment{ID: id, ParentID: "p1", User: store.User{ID: "1", Name: "user" + id}, Text: text,
+				PostTitle: "post title", Locator: parent.Locator},
+			parent: parent,
+			Emails: []string{"parent@example.org"},
+		}
+	}
+
+	require.NoError(t, email.Send(context.Background(), reply("c1", "first reply")))
+	require.NoError(t, email.Send(context.Background(), reply("c2", "second reply")))
+	require.NoError(t, email.Send(context.Background(), reply("c2", "second reply")), "duplicate ignored")
+	mention := reply("c3", "@parent_user third")
+	mention.Emails = nil
+	mention.Mentions = []Mention{{UserID: "999", Email: "parent@example.org"}}
+	require.NoError(t, email.Send(context.Background(), mention))
+	assert.Empty(t, sent(), "nothing sent before the end of digest interval")
+
+	require.Eventually(t, func() bool { return len(sent()) == 2 }, 2*time.Second, 10*time.Millisecond)
+	msgs := sent()
+	user, admin := msgs["parent@example.org"], msgs["admin@example.org"]
+	require.Len(t, user, 1, "all notifications coalesced into a single digest")
+	require.Len(t, admin, 1, "admin digest sent separately")
+
+	assert.Equal(t, "3 new comments", user[0].subject)
+	assert.Contains(t, user[0].body, "3 new comments since the last notification")
+	for _, s := range []string{"first reply", "second reply", "@parent_user third",
+		"New reply from userc1 on your comment to «post title»", "New reply from userc2 on your comment",
+		"userc3 mentioned you in a comment", "https://example.com/post1#remark42__comment-c1",
+		"https://example.com/post1#remark42__comment-c2", "https://example.com/post1#remark42__comment-c3"} {
+		assert.Contains(t, user[0].body, s)
+	}
+	assert.Equal(t, 1, strings.Count(user[0].body, "second reply"), "duplicate listed once")
+	assert.Equal(t, "https://remark42.com/email/unsubscribe.html?site=remark&tkn=token", user[0].unsubscribeLink)
+	assert.Contains(t, user[0].body, user[0].unsubscribeLink)
+
+	assert.Equal(t, "3 new comments", admin[0].subject)
+	assert.Contains(t, admin[0].body, "New comment from userc1 on your site")
+	assert.Empty(t, admin[0].unsubscribeLink)
+
+	// next notification starts new digest
+	require.NoError(t, email.Send(context.Background(), reply("c4", "fourth reply")))
+	require.Eventually(t, func() bool { return len(sent()["parent@example.org"]) == 2 }, 2*time.Second, 10*time.Millisecond)
+	user = sent()["parent@example.org"]
+	assert.Equal(t, "1 new comments", user[1].subject)
+	assert.Contains(t, user[1].body, "fourth reply")
+	assert.NotContains(t, user[1].body, "first reply")
+}
+
+func TestEmail_DigestFlush(t *testing.T) {
+	email, sent := prepDigestEmail(t, time.Hour)
+	for i := 0; i < 3; i++ {
+		req := Request{Comment: store.Comment{ID: fmt.Sprintf("c%d", i), User: store.User{Name: "user"},
+			Text: fmt.Sprintf("comment %d", i), Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post1"}},
+			Emails: []string{"user@example.org"}}
+		if i == 2 {
+			req.Comment.Locator.SiteID = "other" // digests collected per site
+		}
+		require.NoError(t, email.Send(context.Background(), req))
+	}
+	assert.Empty(t, sent())
+
+	svc := NewService(nil, 1, email)
+	svc.Close()
+	user := sent()["user@example.org"]
+	require.Len(t, user, 2, "pending digests sent on close")
+	bodies := user[0].body + user[1].body
+	for i := 0; i < 3; i++ {
+		assert.Contains(t, bodies, fmt.Sprintf("comment %d", i))
+	}
+
+	email.Flush()
+	assert.Len(t, sent()["user@example.org"], 2, "nothing left to send")
+}
+
+func TestEmail_DigestPreferred(t *testing.T) {
+	email, sent := prepDigestEmail(t, 0)
+	assert.Equal(t, defaultUserDigestInterval, email.digestInterval())
+	email.UserDigestInterval = time.Hour
+	assert.Equal(t, time.Hour, email.digestInterval())
+
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	for i := 0; i < 2; i++ {
+		req := Request{Comment: store.Comment{ID: fmt.Sprintf("c%d", i), User: store.User{Name: "user"},
+			Text: fmt.Sprintf("comment %d", i), Locator: locator},
+			Emails:   []string{"reply@example.org", "digest@example.org"},
+			Mentions: []Mention{{UserID: "u1", Email: "mention@example.org", Digest: true}},
+			Digests:  []string{"digest@example.org"}}
+		require.NoError(t, email.Send(context.Background(), req))
+	}
+	assert.Len(t, sent()["reply@example.org"], 2, "sent immediately")
+	assert.Empty(t, sent()["digest@example.org"])
+	assert.Empty(t, sent()["mention@example.org"])
+
+	email.Flush()
+	for _, to := range []string{"digest@example.org", "mention@example.org"} {
+		msgs := sent()[to]
+		require.Len(t, msgs, 1, to)
+		assert.Equal(t, "2 new comments", msgs[0].subject)
+		assert.Contains(t, msgs[0].body, "comment 0")
+		assert.Contains(t, msgs[0].body, "comment 1")
+	}
+}
+
+func TestEmail_DigestErrors(t *testing.T) {
+	_, err := NewEmail(EmailParams{DigestInterval: time.Minute, DigestTemplatePath: "testdata/no-such-file.tmpl"}, ntf.SMTPParams{})
+	assert.ErrorContains(t, err, "can't read digest template")
+	_, err = NewEmail(EmailParams{DigestInterval: time.Minute, DigestTemplatePath: "testdata/bad.html.tmpl"}, ntf.SMTPParams{})
+	assert.ErrorContains(t, err, "can't parse digest template")
+
+	email, sent := prepDigestEmail(t, time.Hour)
+	req := Request{Comment: store.Comment{ID: "c1"}, parent: store.Comment{User: store.User{ID: "error"}},
+		Emails: []string{"user@example.org"}}
+	assert.ErrorContains(t, email.Send(context.Background(), req), "token generation error")
+	email.Flush()
+	assert.Empty(t, sent())
+}
+
+// prepDigestEmail makes email notifier in digest mode, with sent messages collected by recipient
+func prepDigestEmail(t *testing.T, interval time.Duration) (*Email, func() map[string][]commentMessage) {
+	email, err := NewEmail(EmailParams{
+		From:           "from@example.org",
+		UnsubscribeURL: "https://remark42.com/email/unsubscribe.html",
+		TokenGenFn:     TokenGenFn,
+		DigestInterval: interval,
+	}, ntf.SMTPParams{})
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	sent := map[string][]commentMessage{}
+	email.send = func(_ context.Context, to string, msg commentMessage) error {
+		lock.Lock()
+		defer lock.Unlock()
+		sent[to] = append(sent[to], msg)
+		return nil
+	}
+	return email, func() map[string][]commentMessage {
+		lock.Lock()
+		defer lock.Unlock()
+		res := map[string][]commentMessage{}
+		for k, v := range sent {
+			res[k] = append([]commentMessage{}, v...)
+		}
+		return res
+	}
+}
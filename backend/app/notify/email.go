@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -18,15 +23,27 @@ import (
 
 // EmailParams contain settings for email notifications
 type EmailParams struct {
-	From                     string   // from email address
-	AdminEmails              []string // administrator emails to send copy of comment notification to
-	MsgTemplatePath          string   // path to request message template
-	VerificationSubject      string   // verification message sub
-	VerificationTemplatePath string   // path to verification template
-	SubscribeURL             string   // full subscribe handler URL
-	UnsubscribeURL           string   // full unsubscribe handler URL
-
-	TokenGenFn func(userID, email, site string) (string, error) // Unsubscribe token generation function
+	From                     string            // from email address
+	AdminEmails              []string          // administrator emails to send copy of comment notification to
+	MsgTemplatePath          string            // path to request message template
+	VerificationSubject      string            // verification message sub
+	VerificationTemplatePath string            // path to verification template
+	SubscribeURL             string            // full subscribe handler URL
+	UnsubscribeURL           string            // full unsubscribe handler URL
+	UnsubscribeThreadURL     string            // full post notifications unsubscribe handler URL, optional
+	UnfollowURL              string            // full unsubscribe handler URL for anonymous followers of the post
+	SiteTemplatesDir         string            // directory with per-site message templates overrides, optional
+	Locales                  map[string]string // notification locales by site ID, AllSitesLocale key for all sites, "en" by default
+	DigestInterval           time.Duration     // if set, notifications collected and sent to each recipient as a single digest once per interval
+	UserDigestInterval       time.Duration     // digest interval for users preferring digest if DigestInterval not set, 24h by default
+	DigestTemplatePath       string            // path to digest message template
+	SMTPMaxIdle              time.Duration     // if set, SMTP connection kept between messages till idle for longer, otherwise new connection for each message
+	ThreadRate               int               // max notifications per minute about a thread, thread switched to digest mode if exceeded, no limit if 0
+	ThreadCooldown           time.Duration     // period of digest mode for the thread exceeded ThreadRate, 15m by default
+
+	TokenGenFn       func(userID, email, site string) (string, error)          // Unsubscribe token generation function
+	ThreadTokenGenFn func(userID, email, site, postURL string) (string, error) // Post unsubscribe token generation function
+	FollowTokenGenFn func(email, site, postURL string) (string, error)         // Follow token generation function, followers not notified if not set
 }
 
 // Email implements notify.Destination for email
@@ -36,24 +53,61 @@ type Email struct {
 	EmailParams
 	msgTmpl    *template.Template // parsed request message template
 	verifyTmpl *template.Template // parsed verification message template
+
+	digestTmpl *template.Template // parsed digest message template
+
+	siteTmpls map[string]siteTemplates // per-site message templates overrides, by site ID
+	catalogs  map[string]catalog       // localized messages, by locale
+
+	send func(ctx context.Context, email string, msg commentMessage) error // sends built message, replaced in tests
+	pool *smtpPool                                                         // kept SMTP connection, nil if SMTPMaxIdle not set
+
+	digestLock sync.Mutex
+	digests    map[digestKey]*digest // pending digests, by recipient
+
+	threadsLock    sync.Mutex
+	threads        map[string]*threadRate // notification rates of threads, by site and post url
+	threadsCleanup time.Time              // last cleanup of threads
+}
+
+// siteTemplates keeps per-site overrides for the comment notification, nil template means default one used
+type siteTemplates struct {
+	subject *template.Template // parsed message subject template
+	msg     *template.Template // parsed request message template
 }
 
 // msgTmplData store data for message from request template execution
 type msgTmplData struct {
-	UserName          string
-	UserPicture       string
-	CommentText       string
-	CommentLink       string
-	CommentDate       time.Time
-	ParentUserName    string
-	ParentUserPicture string
-	ParentCommentText string
-	ParentCommentLink string
-	ParentCommentDate time.Time
-	PostTitle         string
-	Email             string
-	UnsubscribeLink   string
-	ForAdmin          bool
+	UserName              string
+	UserPicture           string
+	CommentText           string
+	CommentLink           string
+	CommentDate           time.Time
+	ParentUserName        string
+	ParentUserPicture     string
+	ParentCommentText     string
+	ParentCommentLink     string
+	ParentCommentDate     time.Time
+	PostTitle             string
+	PostURL               string
+	Email                 string
+	UnsubscribeLink       string
+	UnsubscribeThreadLink string
+	ForAdmin              bool
+	ForMention            bool
+	ForFollower           bool
+
+	catalog catalog // localized messages for T and FormatDate
+}
+
+// T returns localized message by key, formatted with args
+func (d msgTmplData) T(key string, args ...interface{}) string {
+	return d.catalog.message(key, args...)
+}
+
+// FormatDate returns time formatted with the locale's date format
+func (d msgTmplData) FormatDate(t time.Time) string {
+	return d.catalog.formatDate(t)
 }
 
 // verifyTmplData store data for verification message template execution
@@ -70,6 +124,11 @@ const (
 	defaultEmailTimeout                  = 10 * time.Second
 	defaultEmailTemplatePath             = "email_reply.html.tmpl"
 	defaultEmailVerificationTemplatePath = "email_confirmation_subscription.html.tmpl"
+	defaultEmailDigestTemplatePath       = "email_digest.html.tmpl"
+
+	// file names of per-site overrides, located in EmailParams.SiteTemplatesDir/{siteID}/
+	siteMsgTemplateFile     = "email_reply.html.tmpl"
+	siteSubjectTemplateFile = "email_reply_subject.tmpl"
 )
 
 // NewEmail makes new Email object, returns error in case of e.MsgTemplate or e.VerificationTemplate parsing error
@@ -79,7 +138,12 @@ func NewEmail(emailParams EmailParams, smtpParams ntf.SMTPParams) (*Email, error
 		smtpParams.TimeOut = defaultEmailTimeout
 	}
 
-	res := Email{Email: ntf.NewEmail(smtpParams), EmailParams: emailParams}
+	res := Email{Email: ntf.NewEmail(smtpParams), EmailParams: emailParams, digests: map[digestKey]*digest{},
+		threads: map[string]*threadRate{}}
+	res.send = res.sendMessage
+	if emailParams.SMTPMaxIdle > 0 {
+		res.pool = newSMTPPool(smtpParams, emailParams.SMTPMaxIdle)
+	}
 
 	if res.VerificationSubject == "" {
 		res.VerificationSubject = defaultVerificationSubject
@@ -91,8 +155,8 @@ func NewEmail(emailParams EmailParams, smtpParams ntf.SMTPParams) (*Email, error
 		return nil, fmt.Errorf("can't set templates: %w", err)
 	}
 
-	log.Printf("[DEBUG] Create new email notifier for server %s with user %s, timeout=%s",
-		res.Host, res.Username, res.TimeOut)
+	log.Printf("[DEBUG] Create new email notifier for server %s with user %s, timeout=%s, digest=%s",
+		res.Host, res.Username, res.TimeOut, res.DigestInterval)
 
 	return &res, nil
 }
@@ -109,6 +173,10 @@ func (e *Email) setTemplates() error {
 		e.MsgTemplatePath = defaultEmailTemplatePath
 	}
 
+	if e.DigestTemplatePath == "" {
+		e.DigestTemplatePath = defaultEmailDigestTemplatePath
+	}
+
 	if msgTmplFile, err = templates.Read(e.MsgTemplatePath); err != nil {
 		return fmt.Errorf("can't read message template: %w", err)
 	}
@@ -121,12 +189,83 @@ func (e *Email) setTemplates() error {
 	if e.verifyTmpl, err = template.New("verifyTmpl").Parse(string(verifyTmplFile)); err != nil {
 		return fmt.Errorf("can't parse verification template: %w", err)
 	}
+	// digest template loaded in any case, as users may prefer digest even if it's not the default
+	digestTmplFile, err := templates.Read(e.DigestTemplatePath)
+	if err != nil {
+		return fmt.Errorf("can't read digest template: %w", err)
+	}
+	if e.digestTmpl, err = template.New("digestTmpl").Parse(string(digestTmplFile)); err != nil {
+		return fmt.Errorf("can't parse digest template: %w", err)
+	}
+
+	if e.catalogs, err = loadCatalogs(e.Locales); err != nil {
+		return fmt.Errorf("can't load notification catalogs: %w", err)
+	}
+
+	if e.SiteTemplatesDir != "" {
+		if e.siteTmpls, err = loadSiteTemplates(e.SiteTemplatesDir, e.catalogs[defaultLocale]); err != nil {
+			return fmt.Errorf("can't load site templates: %w", err)
+		}
+	}
 
 	return nil
 }
 
+// loadSiteTemplates reads per-site templates from {dir}/{siteID}/ subdirectories. Each site can override
+// message subject, body or both. All found templates are parsed and executed with sample data to make sure
+// they are valid and use only fields available for message templates
+func loadSiteTemplates(dir string, cat catalog) (map[string]siteTemplates, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't read directory %s: %w", dir, err)
+	}
+
+	res := map[string]siteTemplates{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		siteID := entry.Name()
+		st := siteTemplates{}
+		if st.subject, err = readSiteTemplate(filepath.Join(dir, siteID, siteSubjectTemplateFile), cat); err != nil {
+			return nil, fmt.Errorf("bad subject template for site %s: %w", siteID, err)
+		}
+		if st.msg, err = readSiteTemplate(filepath.Join(dir, siteID, siteMsgTemplateFile), cat); err != nil {
+			return nil, fmt.Errorf("bad message template for site %s: %w", siteID, err)
+		}
+		if st.subject == nil && st.msg == nil {
+			continue
+		}
+		log.Printf("[INFO] custom email templates for site %s, subject: %v, message: %v", siteID, st.subject != nil, st.msg != nil)
+		res[siteID] = st
+	}
+	return res, nil
+}
+
+// readSiteTemplate parses and validates a template file. Returns nil template if file doesn't exist
+func readSiteTemplate(path string, cat catalog) (*template.Template, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse template: %w", err)
+	}
+	sample := msgTmplData{UserName: "user", CommentText: "text", ParentCommentText: "parent text", PostTitle: "title", catalog: cat}
+	if err = tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("can't execute template: %w", err)
+	}
+	return tmpl, nil
+}
+
 // Send email about comment reply to Request.Emails and Email.AdminEmails
-// if they're set.
+// if they're set. In digest mode messages queued and sent later, once per DigestInterval for each recipient.
+// Users preferring digest, listed in Request.Digests, get digests even if DigestInterval not set.
+// Notifications about the thread exceeded ThreadRate queued to digests till the end of ThreadCooldown.
 // Thread safe
 func (e *Email) Send(ctx context.Context, req Request) error {
 	select {
@@ -136,47 +275,87 @@ func (e *Email) Send(ctx context.Context, req Request) error {
 	}
 
 	result := new(multierror.Error)
+	storm := e.throttleThread(req)
 
 	for _, email := range req.Emails {
-		err := e.buildAndSendMessage(ctx, req, email, false)
+		err := e.buildAndSendMessage(ctx, req, email, false, contains(req.Digests, email), storm)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("problem sending user email notification to %q: %w", email, err))
+		}
+	}
+
+	for _, m := range req.Mentions {
+		err := e.buildAndSendMessage(ctx, req, m.Email, false, m.Digest, storm)
 		if err != nil {
-			result = multierror.Append(fmt.Errorf("problem sending user email notification to %q: %w", email, err))
+			result = multierror.Append(result, fmt.Errorf("problem sending mention email notification to %q: %w", m.Email, err))
+		}
+	}
+
+	if e.FollowTokenGenFn != nil {
+		for _, email := range req.Followers {
+			err := e.buildAndSendMessage(ctx, req, email, false, false, storm)
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("problem sending follower email notification to %q: %w", email, err))
+			}
 		}
 	}
 
 	for _, email := range e.AdminEmails {
-		err := e.buildAndSendMessage(ctx, req, email, true)
+		err := e.buildAndSendMessage(ctx, req, email, true, false, storm)
 		if err != nil {
-			result = multierror.Append(fmt.Errorf("problem sending admin email notification to %q: %w", email, err))
+			result = multierror.Append(result, fmt.Errorf("problem sending admin email notification to %q: %w", email, err))
 		}
 	}
 
 	return result.ErrorOrNil()
 }
 
-func (e *Email) buildAndSendMessage(ctx context.Context, req Request, email string, forAdmin bool) error {
+func (e *Email) buildAndSendMessage(ctx context.Context, req Request, email string, forAdmin, digest, storm bool) error {
+	if e.DigestInterval > 0 || digest {
+		return e.addToDigest(req, email, forAdmin, e.digestInterval())
+	}
+	if storm {
+		return e.addToDigest(req, email, forAdmin, e.threadCooldown())
+	}
 	log.Printf("[DEBUG] send notification via %s, comment id %s", e, req.Comment.ID)
 	msg, err := e.buildMessageFromRequest(req, email, forAdmin)
 	if err != nil {
 		return err
 	}
+	return e.send(ctx, email, msg)
+}
 
+// sendMessage sends built comment message to email, retrying on failure
+func (e *Email) sendMessage(ctx context.Context, email string, msg commentMessage) error {
 	return repeater.NewDefault(5, time.Millisecond*250).Do(
 		ctx,
 		func() error {
-			return e.Email.Send(
-				ctx,
-				fmt.Sprintf("mailto:%s?from=%s&unsubscribeLink=%s&subject=%s",
-					email,
-					e.From,
-					url.QueryEscape(msg.unsubscribeLink),
-					url.QueryEscape(msg.subject),
-				),
-				msg.body,
-			)
+			return e.deliver(ctx, email, msg.subject, msg.unsubscribeLink, msg.body)
 		})
 }
 
+// deliver sends text to email over the kept connection if SMTPMaxIdle set, or over the new one otherwise
+func (e *Email) deliver(ctx context.Context, email, subject, unsubscribeLink, text string) error {
+	if e.pool == nil {
+		return e.Email.Send(
+			ctx,
+			fmt.Sprintf("mailto:%s?from=%s&unsubscribeLink=%s&subject=%s",
+				email,
+				e.From,
+				url.QueryEscape(unsubscribeLink),
+				url.QueryEscape(subject),
+			),
+			text,
+		)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return e.pool.send(text, e.From, email, subject, unsubscribeLink)
+	}
+}
+
 // SendVerification email verification VerificationRequest.Email if it's set.
 // Thread safe
 func (e *Email) SendVerification(ctx context.Context, req VerificationRequest) error {
@@ -191,7 +370,7 @@ func (e *Email) SendVerification(ctx context.Context, req VerificationRequest) e
 	}
 
 	log.Printf("[DEBUG] send verification via %s, user %s", e, req.User)
-	msg, err := e.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	msg, err := e.buildVerificationMessage(req)
 	if err != nil {
 		return err
 	}
@@ -199,27 +378,23 @@ func (e *Email) SendVerification(ctx context.Context, req VerificationRequest) e
 	return repeater.NewDefault(5, time.Millisecond*250).Do(
 		ctx,
 		func() error {
-			return e.Email.Send(
-				ctx,
-				fmt.Sprintf("mailto:%s?from=%s&subject=%s",
-					req.Email,
-					e.From,
-					url.QueryEscape(e.VerificationSubject),
-				),
-				msg,
-			)
+			return e.deliver(ctx, req.Email, e.VerificationSubject, "", msg)
 		})
 }
 
 // buildVerificationMessage generates verification email message based on given input
-func (e *Email) buildVerificationMessage(user, email, token, site string) (string, error) {
+func (e *Email) buildVerificationMessage(req VerificationRequest) (string, error) {
+	subscribeURL := e.SubscribeURL
+	if req.SubscribeURL != "" {
+		subscribeURL = req.SubscribeURL
+	}
 	msg := bytes.Buffer{}
 	err := e.verifyTmpl.Execute(&msg, verifyTmplData{
-		User:         user,
-		Token:        token,
-		Email:        email,
-		Site:         site,
-		SubscribeURL: e.SubscribeURL,
+		User:         req.User,
+		Token:        req.Token,
+		Email:        req.Email,
+		Site:         req.SiteID,
+		SubscribeURL: subscribeURL,
 	})
 	if err != nil {
 		return "", fmt.Errorf("error executing template to build verification message: %w", err)
@@ -235,35 +410,110 @@ type commentMessage struct {
 
 // buildMessageFromRequest generates email message based on Request using e.MsgTemplate
 func (e *Email) buildMessageFromRequest(req Request, email string, forAdmin bool) (commentMessage, error) {
-	subject := "New reply to your comment"
-	if forAdmin {
-		subject = "New comment to your site"
+	tmplData, unsubscribeThreadLink, err := e.buildTmplData(req, email, forAdmin)
+	if err != nil {
+		return commentMessage{}, err
+	}
+
+	cat := tmplData.catalog
+	subject := cat.message("subject_reply")
+	switch {
+	case forAdmin:
+		subject = cat.message("subject_admin")
+	case tmplData.ForMention:
+		subject = cat.message("subject_mention")
+	case tmplData.ForFollower:
+		subject = cat.message("subject_follow")
 	}
 	if req.Comment.PostTitle != "" {
-		subject += fmt.Sprintf(" for %q", req.Comment.PostTitle)
+		subject = cat.message("subject_post", subject, req.Comment.PostTitle)
 	}
 
-	token, err := e.TokenGenFn(req.parent.User.ID, email, req.Comment.Locator.SiteID)
+	msgTmpl := e.msgTmpl
+	siteTmpl := e.siteTmpls[req.Comment.Locator.SiteID]
+	if siteTmpl.msg != nil {
+		msgTmpl = siteTmpl.msg
+	}
+	if siteTmpl.subject != nil {
+		subj := bytes.Buffer{}
+		if err = siteTmpl.subject.Execute(&subj, tmplData); err != nil {
+			return commentMessage{}, fmt.Errorf("error executing template to build comment reply subject: %w", err)
+		}
+		subject = strings.TrimSpace(subj.String())
+	}
+
+	msg := bytes.Buffer{}
+	err = msgTmpl.Execute(&msg, tmplData)
 	if err != nil {
-		return commentMessage{}, fmt.Errorf("error creating token for unsubscribe link: %w", err)
+		return commentMessage{}, fmt.Errorf("error executing template to build comment reply message: %w", err)
+	}
+	// one-click unsubscribe header mutes the post only, if possible
+	unsubscribeLink := tmplData.UnsubscribeLink
+	if unsubscribeThreadLink != "" {
+		unsubscribeLink = unsubscribeThreadLink
+	}
+	return commentMessage{
+		subject:         subject,
+		body:            msg.String(),
+		unsubscribeLink: unsubscribeLink,
+	}, err
+}
+
+// buildTmplData makes message template data for the comment from Request, as well as post unsubscribe link
+func (e *Email) buildTmplData(req Request, email string, forAdmin bool) (msgTmplData, string, error) {
+	// mentioned user gets own message, unsubscribe links made for the mentioned user and not the parent comment author
+	userID, forMention := req.parent.User.ID, false
+	for _, m := range req.Mentions {
+		if !forAdmin && m.Email == email {
+			userID, forMention = m.UserID, true
+			break
+		}
+	}
+
+	// anonymous follower of the post, not notified as a user, gets the post unfollow link only
+	forFollower := !forAdmin && !forMention && e.FollowTokenGenFn != nil && contains(req.Followers, email) && !contains(req.Emails, email)
+
+	unsubscribeLink, unsubscribeThreadLink := "", ""
+	if forFollower {
+		token, err := e.FollowTokenGenFn(email, req.Comment.Locator.SiteID, req.Comment.Locator.URL)
+		if err != nil {
+			return msgTmplData{}, "", fmt.Errorf("error creating token for unfollow link: %w", err)
+		}
+		unsubscribeLink = e.UnfollowURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
+	} else {
+		token, err := e.TokenGenFn(userID, email, req.Comment.Locator.SiteID)
+		if err != nil {
+			return msgTmplData{}, "", fmt.Errorf("error creating token for unsubscribe link: %w", err)
+		}
+		unsubscribeLink = e.UnsubscribeURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
+	}
+	if !forAdmin && !forFollower && e.UnsubscribeThreadURL != "" && e.ThreadTokenGenFn != nil {
+		threadToken, err := e.ThreadTokenGenFn(userID, email, req.Comment.Locator.SiteID, req.Comment.Locator.URL)
+		if err != nil {
+			return msgTmplData{}, "", fmt.Errorf("error creating token for post unsubscribe link: %w", err)
+		}
+		unsubscribeThreadLink = e.UnsubscribeThreadURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + threadToken
 	}
-	unsubscribeLink := e.UnsubscribeURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
 	if forAdmin {
 		unsubscribeLink = ""
 	}
 
 	commentURLPrefix := req.Comment.Locator.URL + uiNav
-	msg := bytes.Buffer{}
 	tmplData := msgTmplData{
-		UserName:        req.Comment.User.Name,
-		UserPicture:     req.Comment.User.Picture,
-		CommentText:     req.Comment.Text,
-		CommentLink:     commentURLPrefix + req.Comment.ID,
-		CommentDate:     req.Comment.Timestamp,
-		PostTitle:       req.Comment.PostTitle,
-		Email:           email,
-		UnsubscribeLink: unsubscribeLink,
-		ForAdmin:        forAdmin,
+		UserName:              req.Comment.User.Name,
+		UserPicture:           req.Comment.User.Picture,
+		CommentText:           req.Comment.Text,
+		CommentLink:           commentURLPrefix + req.Comment.ID,
+		CommentDate:           req.Comment.Timestamp,
+		PostTitle:             req.Comment.PostTitle,
+		PostURL:               req.Comment.Locator.URL,
+		Email:                 email,
+		UnsubscribeLink:       unsubscribeLink,
+		UnsubscribeThreadLink: unsubscribeThreadLink,
+		ForAdmin:              forAdmin,
+		ForMention:            forMention,
+		ForFollower:           forFollower,
+		catalog:               e.siteCatalog(req.Comment.Locator.SiteID),
 	}
 	// in case of message to admin, parent message might be empty
 	if req.Comment.ParentID != "" {
@@ -273,13 +523,17 @@ func (e *Email) buildMessageFromRequest(req Request, email string, forAdmin bool
 		tmplData.ParentCommentLink = commentURLPrefix + req.parent.ID
 		tmplData.ParentCommentDate = req.parent.Timestamp
 	}
-	err = e.msgTmpl.Execute(&msg, tmplData)
-	if err != nil {
-		return commentMessage{}, fmt.Errorf("error executing template to build comment reply message: %w", err)
+	return tmplData, unsubscribeThreadLink, nil
+}
+
+// siteCatalog returns catalog for the site's locale, falls back to the default locale
+func (e *Email) siteCatalog(siteID string) catalog {
+	locale, ok := e.Locales[siteID]
+	if !ok {
+		locale = e.Locales[AllSitesLocale]
 	}
-	return commentMessage{
-		subject:         subject,
-		body:            msg.String(),
-		unsubscribeLink: unsubscribeLink,
-	}, err
+	if c, ok := e.catalogs[locale]; ok {
+		return c
+	}
+	return e.catalogs[defaultLocale]
 }
@@ -3,10 +3,14 @@ package notify
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"text/template"
+	"time"
 
 	ntf "github.com/go-pkgz/notify"
+	"github.com/hashicorp/go-multierror"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -85,6 +89,24 @@ func Test_initTemplatesErr(t *testing.T) {
 				MsgTemplatePath:          "testdata/bad.html.tmpl",
 			},
 		},
+		{
+			name:    "with error on parse site template",
+			errText: "can't load site templates: bad message template for site bad-site: can't parse template",
+			emailParams: EmailParams{
+				VerificationTemplatePath: "testdata/verification.html.tmpl",
+				MsgTemplatePath:          "testdata/msg.html.tmpl",
+				SiteTemplatesDir:         "testdata/site_templates_bad",
+			},
+		},
+		{
+			name:    "with wrong site templates dir",
+			errText: "can't load site templates: can't read directory testdata/notfound",
+			emailParams: EmailParams{
+				VerificationTemplatePath: "testdata/verification.html.tmpl",
+				MsgTemplatePath:          "testdata/msg.html.tmpl",
+				SiteTemplatesDir:         "testdata/notfound",
+			},
+		},
 	}
 
 	for _, d := range testSet {
@@ -191,6 +213,274 @@ admin@example.org
 	assert.Empty(t, msg.unsubscribeLink)
 }
 
+func TestEmail_UnsubscribeThreadLink(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		UnsubscribeURL:           "https://remark42.com/email/unsubscribe.html",
+		UnsubscribeThreadURL:     "https://remark42.com/email/unsubscribe-post.html",
+		TokenGenFn:               TokenGenFn,
+		ThreadTokenGenFn: func(user, _, _, postURL string) (string, error) {
+			return "thread-token-" + user + "-" + postURL, nil
+		},
+	}, ntf.SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1",
+			Locator: store.Locator{SiteID: "remark", URL: "post1"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+		Emails: []string{"test@example.org"},
+	}
+	msg, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Equal(t, "https://remark42.com/email/unsubscribe-post.html?site=remark&tkn=thread-token-999-post1", msg.unsubscribeLink,
+		"one-click unsubscribe header mutes the post")
+	assert.Contains(t, msg.body, "Unsubscribe link: https://remark42.com/email/unsubscribe.html?site=remark&tkn=token")
+
+	// no thread link for admin
+	msg, err = email.buildMessageFromRequest(req, "admin@example.org", true)
+	require.NoError(t, err)
+	assert.Empty(t, msg.unsubscribeLink)
+
+	email.ThreadTokenGenFn = func(_, _, _, _ string) (string, error) { return "", fmt.Errorf("err") }
+	_, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	assert.EqualError(t, err, "error creating token for post unsubscribe link: err")
+}
+
+func TestEmail_Mention(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                 "from@example.org",
+		UnsubscribeURL:       "https://remark42.com/email/unsubscribe.html",
+		UnsubscribeThreadURL: "https://remark42.com/email/unsubscribe-post.html",
+		TokenGenFn:           func(user, _, _ string) (string, error) { return "token-" + user, nil },
+		ThreadTokenGenFn: func(user, _, _, postURL string) (string, error) {
+			return "thread-token-" + user + "-" + postURL, nil
+		},
+	}, ntf.SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1", PostTitle: "test_title",
+			Text: "<p>@mentioned_user hi</p>", Locator: store.Locator{SiteID: "remark", URL: "post1"}},
+		parent:   store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+		Emails:   []string{"test@example.org"},
+		Mentions: []Mention{{UserID: "555", Email: "mentioned@example.org"}},
+	}
+
+	msg, err := email.buildMessageFromRequest(req, "mentioned@example.org", false)
+	require.NoError(t, err)
+	assert.Equal(t, `You were mentioned in a comment for "test_title"`, msg.subject)
+	assert.Equal(t, "https://remark42.com/email/unsubscribe-post.html?site=remark&tkn=thread-token-555-post1", msg.unsubscribeLink)
+	assert.Contains(t, msg.body, "test_user mentioned you in a comment to «test_title»")
+	assert.Contains(t, msg.body, "https://remark42.com/email/unsubscribe.html?site=remark&tkn=token-555")
+	assert.NotContains(t, msg.body, "for parent_user")
+
+	// reply notification is not changed
+	msg, err = email.buildMessageFromRequest(req, "test@example.org", false)
+	require.NoError(t, err)
+	assert.Equal(t, `New reply to your comment for "test_title"`, msg.subject)
+	assert.Equal(t, "https://remark42.com/email/unsubscribe-post.html?site=remark&tkn=thread-token-999-post1", msg.unsubscribeLink)
+}
+
+func TestEmail_Follower(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                 "from@example.org",
+		UnsubscribeURL:       "https://remark42.com/email/unsubscribe.html",
+		UnsubscribeThreadURL: "https://remark42.com/email/unsubscribe-post.html",
+		UnfollowURL:          "https://remark42.com/email/unfollow.html",
+		TokenGenFn:           func(user, _, _ string) (string, error) { return "token-" + user, nil },
+		FollowTokenGenFn: func(email, _, postURL string) (string, error) {
+			return "follow-token-" + email + "-" + postURL, nil
+		},
+	}, ntf.SMTPParams{})
+	require.NoError(t, err)
+	sent := map[string]commentMessage{}
+	email.send = func(_ context.Context, to string, msg commentMessage) error {
+		sent[to] = msg
+		return nil
+	}
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1", PostTitle: "test_title",
+			Text: "<p>hi</p>", Locator: store.Locator{SiteID: "remark", URL: "post1"}},
+		parent:    store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+		Emails:    []string{"test@example.org"},
+		Followers: []string{"reader@example.org"},
+	}
+
+	require.NoError(t, email.Send(context.Background(), req))
+	require.Len(t, sent, 2)
+	msg := sent["reader@example.org"]
+	assert.Equal(t, `New comment in the thread you follow for "test_title"`, msg.subject)
+	assert.Equal(t, "https://remark42.com/email/unfollow.html?site=remark&tkn=follow-token-reader@example.org-post1", msg.unsubscribeLink)
+	assert.Contains(t, msg.body, "New comment from test_user in the thread you follow to «test_title»")
+	assert.Contains(t, msg.body, "https://remark42.com/email/unfollow.html?site=remark&tkn=follow-token-reader@example.org-post1")
+	assert.NotContains(t, msg.body, "unsubscribe.html", "no user unsubscribe links for follower")
+	assert.NotContains(t, msg.body, "unsubscribe-post.html")
+	assert.NotContains(t, msg.body, "for parent_user")
+	assert.Equal(t, `New reply to your comment for "test_title"`, sent["test@example.org"].subject, "reply notification is not changed")
+
+	// followers not notified without follow token generation
+	email.FollowTokenGenFn = nil
+	sent = map[string]commentMessage{}
+	require.NoError(t, email.Send(context.Background(), req))
+	assert.Len(t, sent, 1)
+	assert.Contains(t, sent, "test@example.org")
+}
+
+func TestEmail_SendErrors(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                 "from@example.org",
+		UnsubscribeURL:       "https://remark42.com/email/unsubscribe.html",
+		UnsubscribeThreadURL: "https://remark42.com/email/unsubscribe-post.html",
+		UnfollowURL:          "https://remark42.com/email/unfollow.html",
+		AdminEmails:          []string{"admin@example.org"},
+		TokenGenFn:           func(user, _, _ string) (string, error) { return "token-" + user, nil },
+		ThreadTokenGenFn:     func(user, _, _, _ string) (string, error) { return "thread-token-" + user, nil },
+		FollowTokenGenFn:     func(email, _, _ string) (string, error) { return "follow-token-" + email, nil },
+	}, ntf.SMTPParams{})
+	require.NoError(t, err)
+	email.send = func(_ context.Context, to string, _ commentMessage) error { return fmt.Errorf("can't send to %s", to) }
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1", PostTitle: "test_title",
+			Text: "<p>hi</p>", Locator: store.Locator{SiteID: "remark", URL: "post1"}},
+		parent:    store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+		Emails:    []string{"test@example.org"},
+		Mentions:  []Mention{{UserID: "555", Email: "mentioned@example.org"}},
+		Followers: []string{"reader@example.org"},
+	}
+
+	err = email.Send(context.Background(), req)
+	require.Error(t, err)
+	merr := &multierror.Error{}
+	require.ErrorAs(t, err, &merr)
+	assert.Len(t, merr.Errors, 4, "all errors reported")
+	for _, addr := range []string{"test@example.org", "mentioned@example.org", "reader@example.org", "admin@example.org"} {
+		assert.Contains(t, err.Error(), "can't send to "+addr)
+	}
+}
+
+func TestEmail_SiteTemplates(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		SiteTemplatesDir:         "testdata/site_templates",
+		UnsubscribeURL:           "https://remark42.com/api/v1/email/unsubscribe",
+		TokenGenFn:               TokenGenFn,
+	}, ntf.SMTPParams{})
+	require.NoError(t, err)
+	assert.Len(t, email.siteTmpls, 2)
+
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1", PostTitle: "test_title",
+			Text: "some text", Locator: store.Locator{SiteID: "custom-site", URL: "https://example.com/post"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+		Emails: []string{"test@example.org"},
+	}
+
+	// both subject and message overridden
+	msg, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Equal(t, "Custom Site: test_user replied on test_title", msg.subject)
+	assert.Equal(t, `<h1>Custom Site</h1>
+test_user replied to «test_title» (https://example.com/post): some text
+Link: https://example.com/post#remark42__comment-999
+Unsubscribe: https://remark42.com/api/v1/email/unsubscribe?site=custom-site&tkn=token
+`, msg.body)
+
+	// only subject overridden, default message
+	req.Comment.Locator.SiteID = "subj-only"
+	msg, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Equal(t, "[subj-only] new reply from test_user", msg.subject)
+	assert.Contains(t, msg.body, "New reply from test_user on your comment to «test_title»")
+
+	// no overrides for the site
+	req.Comment.Locator.SiteID = "other"
+	msg, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Equal(t, `New reply to your comment for "test_title"`, msg.subject)
+	assert.Contains(t, msg.body, "New reply from test_user on your comment to «test_title»")
+}
+
+func TestEmail_SiteTemplatesUnknownField(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "site1"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site1", "email_reply_subject.tmpl"), []byte("{{.Secret}}"), 0o600))
+
+	_, err := NewEmail(EmailParams{
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		SiteTemplatesDir:         dir,
+	}, ntf.SMTPParams{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad subject template for site site1: can't execute template")
+	assert.Contains(t, err.Error(), "can't evaluate field Secret")
+}
+
+func TestEmail_Locales(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		Locales: map[string]string{"site-ru": "ru", "site-bad": "xx", "site-invalid": "../en", AllSitesLocale: "de"},
+	}, ntf.SMTPParams{})
+	require.NoError(t, err)
+	email.TokenGenFn = TokenGenFn
+	email.UnsubscribeURL = "https://remark42.com/api/v1/email/unsubscribe"
+
+	ts := time.Date(2022, 3, 14, 15, 9, 0, 0, time.UTC)
+	makeReq := func(siteID string) Request {
+		return Request{
+			Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1",
+				PostTitle: "test_title", Timestamp: ts, Locator: store.Locator{SiteID: siteID, URL: "https://example.com"}},
+			parent: store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}, Text: "parent text", Timestamp: ts},
+			Emails: []string{"test@example.org"},
+		}
+	}
+
+	msg, err := email.buildMessageFromRequest(makeReq("site-ru"), "test@example.org", false)
+	require.NoError(t, err)
+	assert.Equal(t, `Новый ответ на ваш комментарий к "test_title"`, msg.subject)
+	assert.Contains(t, msg.body, "Новый ответ от test_user на ваш комментарий к «test_title»")
+	assert.Contains(t, msg.body, "14.03.2022 в 15:09")
+	assert.Contains(t, msg.body, ">Отписаться</a>")
+	assert.NotContains(t, msg.body, "Unsubscribe")
+
+	msg, err = email.buildMessageFromRequest(makeReq("site-other"), "test@example.org", false)
+	require.NoError(t, err)
+	assert.Equal(t, `Neue Antwort auf Ihren Kommentar zu "test_title"`, msg.subject, "locale for all sites")
+	assert.Contains(t, msg.body, "14.03.2022 um 15:09")
+
+	for _, siteID := range []string{"site-bad", "site-invalid"} {
+		msg, err = email.buildMessageFromRequest(makeReq(siteID), "test@example.org", false)
+		require.NoError(t, err)
+		assert.Equal(t, `New reply to your comment for "test_title"`, msg.subject, "fallback to english for %s", siteID)
+		assert.Contains(t, msg.body, "New reply from test_user on your comment to «test_title»")
+		assert.Contains(t, msg.body, "14.03.2022 at 15:09")
+		assert.Contains(t, msg.body, ">Unsubscribe</a>")
+	}
+
+	// default english without locales set
+	email, err = NewEmail(EmailParams{}, ntf.SMTPParams{})
+	require.NoError(t, err)
+	email.TokenGenFn = TokenGenFn
+	msg, err = email.buildMessageFromRequest(makeReq("site-ru"), "test@example.org", true)
+	require.NoError(t, err)
+	assert.Equal(t, `New comment to your site for "test_title"`, msg.subject)
+	assert.Contains(t, msg.body, "New comment from test_user on your site to «test_title»")
+}
+
+func Test_loadCatalog(t *testing.T) {
+	c, err := loadCatalog("de", catalog{"show": "fallback show", "extra": "extra %s"})
+	require.NoError(t, err)
+	assert.Equal(t, "Anzeigen", c.message("show"))
+	assert.Equal(t, "extra blah", c.message("extra", "blah"), "missing message taken from fallback")
+	assert.Equal(t, "unknown", c.message("unknown"))
+
+	_, err = loadCatalog("xx", nil)
+	assert.Error(t, err)
+	_, err = loadCatalog("../../etc/passwd", nil)
+	assert.EqualError(t, err, `invalid locale "../../etc/passwd"`)
+}
+
 func TestEmail_SendVerification(t *testing.T) {
 	email, err := NewEmail(EmailParams{
 		From:                     "from@example.org",
@@ -218,7 +508,7 @@ func TestEmail_SendVerification(t *testing.T) {
 	assert.EqualError(t, email.SendVerification(ctx, req), "sending message to \"test_username\" aborted due to canceled context")
 
 	// test buildVerificationMessage separately for message text
-	res, err := email.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	res, err := email.buildVerificationMessage(req)
 	assert.NoError(t, err)
 	assert.Equal(t, res, `Confirmation for test_username on site remark
 Token:secret_
@@ -228,7 +518,7 @@ Sent to test@example.org
 	assert.Contains(t, res, `secret_`)
 	assert.NotContains(t, res, `https://example.org/`)
 	email.SubscribeURL = "https://example.org/subscribe.html?token="
-	res, err = email.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	res, err = email.buildVerificationMessage(req)
 	assert.NoError(t, err)
 	assert.Equal(t, res, `Confirmation for test_username on site remark
 Subscribe url: https://example.org/subscribe.html?token=secret_
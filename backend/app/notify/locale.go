@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/templates"
+)
+
+// defaultLocale used for sites without locale set and for messages missing in the locale's catalog
+const defaultLocale = "en"
+
+// AllSitesLocale is the EmailParams.Locales key for locale of all sites without own locale set
+const AllSitesLocale = "*"
+
+var localeRe = regexp.MustCompile(`^[a-zA-Z]{2,3}([_-][a-zA-Z0-9]{2,8})*$`)
+
+// catalog keeps localized notification messages by key, messages are fmt format strings
+type catalog map[string]string
+
+// message returns localized message by key formatted with args, or the key itself for unknown message
+func (c catalog) message(key string, args ...interface{}) string {
+	msg, ok := c[key]
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// formatDate formats time with the locale's date format
+func (c catalog) formatDate(t time.Time) string {
+	return t.Format(c.message("date_format"))
+}
+
+// loadCatalog reads catalog of the locale from locales/{locale}.json template,
+// messages missing in the catalog taken from the fallback one
+func loadCatalog(locale string, fallback catalog) (catalog, error) {
+	if !localeRe.MatchString(locale) {
+		return nil, fmt.Errorf("invalid locale %q", locale)
+	}
+	data, err := templates.Read("locales/" + locale + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("can't read catalog for locale %s: %w", locale, err)
+	}
+	messages := catalog{}
+	if err = json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("can't parse catalog for locale %s: %w", locale, err)
+	}
+	res := catalog{}
+	for k, v := range fallback {
+		res[k] = v
+	}
+	for k, v := range messages {
+		res[k] = v
+	}
+	return res, nil
+}
+
+// loadCatalogs makes catalogs of all locales used by sites. Default locale is always loaded and used
+// for locales without catalog, so missing catalog doesn't prevent notifications from being sent
+func loadCatalogs(locales map[string]string) (map[string]catalog, error) {
+	def, err := loadCatalog(defaultLocale, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]catalog{defaultLocale: def}
+	for siteID, locale := range locales {
+		if _, ok := res[locale]; ok {
+			continue
+		}
+		c, e := loadCatalog(locale, def)
+		if e != nil {
+			log.Printf("[WARN] no notifications catalog for site %s, %s used: %v", siteID, defaultLocale, e)
+			c = def
+		}
+		res[locale] = c
+	}
+	return res, nil
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"regexp"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Mention is a user mentioned in the comment with @username
+type Mention struct {
+	UserID string
+	Email  string
+	Digest bool // user prefers digest of notifications
+}
+
+var (
+	mentionRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.\-]+)`)
+	codeRe    = regexp.MustCompile(`(?is)<pre[^>]*>.*?</pre>|<code[^>]*>.*?</code>`)
+	tagRe     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parseMentions returns usernames mentioned in the comment html with @username.
+// Mentions inside code blocks and inline code are ignored.
+func parseMentions(html string) []string {
+	text := codeRe.ReplaceAllString(html, " ")
+	text = tagRe.ReplaceAllString(text, " ")
+
+	result := []string{}
+	for _, m := range mentionRe.FindAllStringSubmatch(text, -1) {
+		if name := strings.TrimRight(m[1], ".-"); name != "" {
+			result = append(result, name)
+		}
+	}
+	return deduplicateStrings(result)
+}
+
+// mentionMatch checks if username from @username mention matches user name. Spaces in the name can be
+// replaced with underscores in the mention, as mention can't contain spaces
+func mentionMatch(mention, userName string) bool {
+	return strings.EqualFold(mention, userName) || strings.EqualFold(mention, strings.ReplaceAll(userName, " ", "_"))
+}
+
+// getMentions resolves users mentioned in the comment to the authors of the comments in the same post,
+// and returns emails and telegrams of them. Users without notifications set, unsubscribed from the post,
+// with disabled notifications about mentions or already notified about the reply are skipped,
+// as well as the author of the comment.
+func (s *Service) getMentions(req Request) (mentions []Mention, telegrams []string) {
+	names := parseMentions(req.Comment.Text)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	comments, err := s.dataService.Find(req.Comment.Locator, "time", store.User{})
+	if err != nil {
+		log.Printf("[WARN] can't get comments for mentions in %s, %v", req.Comment.Locator.URL, err)
+		return nil, nil
+	}
+
+	users := map[string]bool{}
+	for _, c := range comments {
+		if c.Deleted || c.User.ID == req.Comment.User.ID || users[c.User.ID] {
+			continue
+		}
+		for _, name := range names {
+			if mentionMatch(name, c.User.Name) {
+				users[c.User.ID] = true
+				break
+			}
+		}
+	}
+
+	for userID := range users {
+		if s.dataService.IsUnsubscribed(req.Comment.Locator, userID) {
+			continue
+		}
+		prefs := s.notifyPrefs(req.Comment.Locator.SiteID, userID)
+		if !prefs.Mentions {
+			continue
+		}
+		if email, e := s.dataService.GetUserEmail(req.Comment.Locator.SiteID, userID); e == nil && email != "" && !contains(req.Emails, email) {
+			mentions = append(mentions, Mention{UserID: userID, Email: email, Digest: prefs.Digest})
+		}
+		if tg, e := s.dataService.GetUserTelegram(req.Comment.Locator.SiteID, userID); e == nil && tg != "" && !contains(req.Telegrams, tg) {
+			telegrams = append(telegrams, tg)
+		}
+	}
+	return mentions, telegrams
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
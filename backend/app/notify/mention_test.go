@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestParseMentions(t *testing.T) {
+	tbl := []struct {
+		text string
+		res  []string
+	}{
+		{"<p>hi @user1, how are you?</p>", []string{"user1"}},
+		{"<p>@user1 and @user_2.</p>", []string{"user1", "user_2"}},
+		{"<p>@user1 @user1</p>", []string{"user1"}},
+		{"<p>@Имя</p>", []string{"Имя"}},
+		{"<p>write to me@example.com</p>", []string{}},
+		{"<p>no mentions @ all</p>", []string{}},
+		{"<p>inline <code>@user1</code> code</p>", []string{}},
+		{"<pre><code>func() { // @user1\n}</code></pre><p>@user2</p>", []string{"user2"}},
+		{`<p><a href="https://example.com/@user1">link</a></p>`, []string{}},
+	}
+	for i, tt := range tbl {
+		assert.ElementsMatch(t, tt.res, parseMentions(tt.text), "case #%d: %s", i, tt.text)
+	}
+}
+
+func TestMentionMatch(t *testing.T) {
+	assert.True(t, mentionMatch("user1", "user1"))
+	assert.True(t, mentionMatch("User1", "user1"))
+	assert.True(t, mentionMatch("developer_one", "developer one"))
+	assert.False(t, mentionMatch("developer", "developer one"))
+	assert.False(t, mentionMatch("user1", ""))
+}
+
+func TestService_Mentions(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}, unsubscribed: map[string]bool{}}
+	locator := store.Locator{SiteID: "site", URL: "https://example.com/post"}
+
+	dataStore.data["p1"] = store.Comment{ID: "p1", Locator: locator, User: store.User{ID: "u1", Name: "user one"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", Locator: locator, User: store.User{ID: "u2", Name: "user2"}}
+	dataStore.data["p3"] = store.Comment{ID: "p3", Locator: locator, User: store.User{ID: "u3", Name: "user3"}}
+	dataStore.data["p4"] = store.Comment{ID: "p4", Locator: locator, User: store.User{ID: "u4", Name: "user4"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+	dataStore.userDetails["u2"] = "u2@example.com"
+	dataStore.userDetails["u3"] = "u3@example.com"
+	dataStore.unsubscribed["u3"] = true
+
+	s := NewService(dataStore, 1, dest)
+	defer s.Close()
+
+	submit := func(c store.Comment) Request {
+		s.Submit(Request{Comment: c})
+		time.Sleep(time.Millisecond * 110)
+		res := dest.Get()
+		require.NotEmpty(t, res)
+		return res[len(res)-1]
+	}
+
+	// valid mention notifies
+	req := submit(store.Comment{ID: "c1", Locator: locator, Text: "<p>hi @user_one and @User2!</p>", User: store.User{ID: "u5"}})
+	assert.ElementsMatch(t, []Mention{{UserID: "u1", Email: "u1@example.com"}, {UserID: "u2", Email: "u2@example.com"}}, req.Mentions)
+	assert.ElementsMatch(t, []string{"u1@example.com", "u2@example.com"}, req.Telegrams)
+
+	// mention in a code block ignored
+	req = submit(store.Comment{ID: "c2", Locator: locator, Text: "<pre><code>@user2</code></pre><p>see <code>@user_one</code></p>",
+		User: store.User{ID: "u5"}})
+	assert.Empty(t, req.Mentions)
+	assert.Empty(t, req.Telegrams)
+
+	// unknown username left as plain text
+	req = submit(store.Comment{ID: "c3", Locator: locator, Text: "<p>hi @unknown</p>", User: store.User{ID: "u5"}})
+	assert.Empty(t, req.Mentions)
+	assert.Equal(t, "<p>hi @unknown</p>", req.Comment.Text)
+
+	// unsubscribed, without notifications and self mentions skipped
+	req = submit(store.Comment{ID: "c4", Locator: locator, Text: "<p>@user3 @user4 @user2</p>", User: store.User{ID: "u2"}})
+	assert.Empty(t, req.Mentions)
+	assert.Empty(t, req.Telegrams)
+
+	// parent comment author notified about the reply only
+	req = submit(store.Comment{ID: "c5", ParentID: "p2", Locator: locator, Text: "<p>@user2 @user_one</p>", User: store.User{ID: "u5"}})
+	assert.Equal(t, []string{"u2@example.com"}, req.Emails)
+	assert.Equal(t, []Mention{{UserID: "u1", Email: "u1@example.com"}}, req.Mentions)
+	assert.ElementsMatch(t, []string{"u1@example.com", "u2@example.com"}, req.Telegrams)
+}
@@ -4,16 +4,21 @@ package notify
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	log "github.com/go-pkgz/lgr"
 
+	"github.com/umputun/remark42/backend/app/metrics"
 	"github.com/umputun/remark42/backend/app/store"
 )
 
 // Service delivers notifications to multiple destinations
 type Service struct {
+	Metrics *metrics.Metrics // optional, counts notifications sent to destinations
+
 	dataService       Store
 	destinations      []Destination
 	queue             chan Request
@@ -31,11 +36,20 @@ type Destination interface {
 	SendVerification(context.Context, VerificationRequest) error
 }
 
+// flusher implemented by destinations collecting notifications to send them later, like email digest
+type flusher interface {
+	Flush()
+}
+
 // Store defines the minimal interface accessing stored comments used by notifier
 type Store interface {
 	Get(locator store.Locator, id string, user store.User) (store.Comment, error)
+	Find(locator store.Locator, sort string, user store.User) ([]store.Comment, error)
 	GetUserEmail(siteID, userID string) (string, error)
 	GetUserTelegram(siteID, userID string) (string, error)
+	IsUnsubscribed(locator store.Locator, userID string) bool
+	Followers(locator store.Locator) ([]string, error)
+	GetNotifyPrefs(siteID, userID string) (store.NotifyPrefs, error)
 }
 
 // used for email and telegram retrieval from user details
@@ -47,6 +61,9 @@ type Request struct {
 	parent    store.Comment
 	Emails    []string
 	Telegrams []string
+	Mentions  []Mention // users mentioned in the comment and not notified about the reply
+	Followers []string  // emails of anonymous followers of the post, not notified otherwise
+	Digests   []string  // emails of notified users preferring digest of notifications
 }
 
 // VerificationRequest notification for user
@@ -55,6 +72,8 @@ type VerificationRequest struct {
 	User   string
 	Email  string // if set, send email only
 	Token  string
+
+	SubscribeURL string // confirmation link the token appended to, EmailParams.SubscribeURL used if not set
 }
 
 const defaultQueueSize = 100
@@ -91,8 +110,15 @@ func (s *Service) Submit(req Request) {
 			req.parent = p
 			req.Emails = s.getNotificationTargets(req, p, s.dataService.GetUserEmail)
 			req.Telegrams = s.getNotificationTargets(req, p, s.dataService.GetUserTelegram)
+			req.Digests = s.getNotificationTargets(req, p, s.digestEmail)
 		}
 	}
+	if s.dataService != nil {
+		mentions, telegrams := s.getMentions(req)
+		req.Mentions = mentions
+		req.Telegrams = append(req.Telegrams, telegrams...)
+		req.Followers = s.getFollowers(req)
+	}
 	select {
 	case s.queue <- req:
 	default:
@@ -103,15 +129,16 @@ func (s *Service) Submit(req Request) {
 // getNotificationTargets returns list of notification targets (like email or telegram username) for users
 // interested in notifications for provided comment.
 // Targets are not added to the returned list in case the original message
-// is from the same user as the notification receiver.
-// Results are deduplicated.
+// is from the same user as the notification receiver, the receiver unsubscribed from the post
+// or disabled notifications about replies. Results are deduplicated.
 func (s *Service) getNotificationTargets(
 	req Request,
 	notifyComment store.Comment,
 	getUserDetail getUserDetail,
 ) (result []string) {
 	// add current user email only if the user is not the one who wrote the original comment
-	if notifyComment.User.ID != req.Comment.User.ID {
+	if notifyComment.User.ID != req.Comment.User.ID && !s.dataService.IsUnsubscribed(req.Comment.Locator, notifyComment.User.ID) &&
+		s.notifyPrefs(req.Comment.Locator.SiteID, notifyComment.User.ID).Replies {
 		detail, err := getUserDetail(req.Comment.Locator.SiteID, notifyComment.User.ID)
 		if err != nil {
 			log.Printf("[WARN] can't read notification detail for %s, %v", notifyComment.User.ID, err)
@@ -128,6 +155,51 @@ func (s *Service) getNotificationTargets(
 	return deduplicateStrings(result)
 }
 
+// notifyPrefs returns notification preferences of the user, defaults if they can't be read
+func (s *Service) notifyPrefs(siteID, userID string) store.NotifyPrefs {
+	prefs, err := s.dataService.GetNotifyPrefs(siteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't read notification preferences for %s, %v", userID, err)
+		return store.DefaultNotifyPrefs
+	}
+	return prefs
+}
+
+// digestEmail returns email of the user preferring digest of notifications, empty for other users
+func (s *Service) digestEmail(siteID, userID string) (string, error) {
+	if !s.notifyPrefs(siteID, userID).Digest {
+		return "", nil
+	}
+	return s.dataService.GetUserEmail(siteID, userID)
+}
+
+// getFollowers returns emails of anonymous followers of the post. Followers already notified about the comment
+// as authors of parent comments or mentioned users are skipped, as well as the author of the comment.
+func (s *Service) getFollowers(req Request) (result []string) {
+	followers, err := s.dataService.Followers(req.Comment.Locator)
+	if err != nil {
+		log.Printf("[WARN] can't get followers of %s, %v", req.Comment.Locator.URL, err)
+		return nil
+	}
+	if len(followers) == 0 {
+		return nil
+	}
+	authorEmail, _ := s.dataService.GetUserEmail(req.Comment.Locator.SiteID, req.Comment.User.ID)
+	for _, email := range followers {
+		if strings.EqualFold(email, authorEmail) || contains(req.Emails, email) {
+			continue
+		}
+		mentioned := false
+		for _, m := range req.Mentions {
+			mentioned = mentioned || m.Email == email
+		}
+		if !mentioned {
+			result = append(result, email)
+		}
+	}
+	return result
+}
+
 // SubmitVerification to internal channel if not busy, drop if can't send
 func (s *Service) SubmitVerification(req VerificationRequest) {
 	if len(s.destinations) == 0 || atomic.LoadUint32(&s.closed) != 0 {
@@ -154,6 +226,11 @@ func (s *Service) Close() {
 		close(s.verificationQueue)
 		s.cancel()
 		<-s.ctx.Done()
+		for _, d := range s.destinations {
+			if f, ok := d.(flusher); ok {
+				f.Flush() // send collected notifications, nothing will trigger it after close
+			}
+		}
 	}
 	atomic.StoreUint32(&s.closed, 1)
 }
@@ -170,9 +247,12 @@ func (s *Service) do() {
 			wg.Add(len(s.destinations))
 			for _, dest := range s.destinations {
 				go func(d Destination) {
-					if err := d.Send(s.ctx, c); err != nil {
+					st := time.Now()
+					err := d.Send(s.ctx, c)
+					if err != nil {
 						log.Printf("[WARN] failed to send to %s, %s", d, err)
 					}
+					s.Metrics.Notified(time.Since(st), err)
 					wg.Done()
 				}(dest)
 			}
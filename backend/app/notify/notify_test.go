@@ -197,6 +197,111 @@ func TestService_EmailRetrieval(t *testing.T) {
 	s.Close()
 }
 
+func TestService_EmailUnsubscribed(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{},
+		unsubscribed: map[string]bool{}}
+
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}}
+	dataStore.data["p3"] = store.Comment{ID: "p3", ParentID: "p2", User: store.User{ID: "u3"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+	dataStore.userDetails["u2"] = "u2@example.com"
+	dataStore.unsubscribed["u1"] = true
+
+	s := NewService(dataStore, 1, dest)
+	defer s.Close()
+
+	s.Submit(Request{Comment: dataStore.data["p3"]})
+	time.Sleep(time.Millisecond * 110)
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, []string{"u2@example.com"}, destRes[0].Emails, "u1 unsubscribed from the post")
+}
+
+func TestService_NotifyPrefs(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{},
+		prefs: map[string]store.NotifyPrefs{
+			"u1": {Replies: false, Mentions: true},
+			"u2": {Replies: true, Mentions: false},
+			"u3": {Replies: true, Mentions: true, Digest: true},
+		}}
+
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1", Name: "user1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", User: store.User{ID: "u2", Name: "user2"}}
+	dataStore.data["p3"] = store.Comment{ID: "p3", User: store.User{ID: "u3", Name: "user3"}}
+	dataStore.data["p4"] = store.Comment{ID: "p4", User: store.User{ID: "u4", Name: "user4"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+	dataStore.userDetails["u2"] = "u2@example.com"
+	dataStore.userDetails["u3"] = "u3@example.com"
+	dataStore.userDetails["u4"] = "u4@example.com"
+
+	s := NewService(dataStore, 1, dest)
+	defer s.Close()
+
+	submit := func(c store.Comment) Request {
+		s.Submit(Request{Comment: c})
+		time.Sleep(time.Millisecond * 110)
+		res := dest.Get()
+		require.NotEmpty(t, res)
+		return res[len(res)-1]
+	}
+
+	// replies disabled, mention still notifies
+	req := submit(store.Comment{ID: "c1", ParentID: "p1", Text: "<p>@user1 see</p>", User: store.User{ID: "u4"}})
+	assert.Empty(t, req.Emails, "u1 disabled replies")
+	assert.Equal(t, []Mention{{UserID: "u1", Email: "u1@example.com"}}, req.Mentions)
+	assert.Equal(t, []string{"u1@example.com"}, req.Telegrams, "telegram for the mention only")
+
+	// mentions disabled, reply still notifies
+	req = submit(store.Comment{ID: "c2", ParentID: "p2", Text: "<p>@user2 see</p>", User: store.User{ID: "u4"}})
+	assert.Equal(t, []string{"u2@example.com"}, req.Emails)
+	assert.Empty(t, req.Mentions, "u2 disabled mentions")
+	req = submit(store.Comment{ID: "c3", Text: "<p>@user2 see</p>", User: store.User{ID: "u4"}})
+	assert.Empty(t, req.Mentions, "u2 disabled mentions")
+	assert.Empty(t, req.Telegrams, "u2 disabled mentions")
+
+	// digest preferred
+	req = submit(store.Comment{ID: "c4", ParentID: "p3", Text: "<p>@user4 see</p>", User: store.User{ID: "u1"}})
+	assert.Equal(t, []string{"u3@example.com"}, req.Emails)
+	assert.Equal(t, []string{"u3@example.com"}, req.Digests)
+	assert.Equal(t, []Mention{{UserID: "u4", Email: "u4@example.com"}}, req.Mentions, "u4 with default preferences")
+	req = submit(store.Comment{ID: "c5", Text: "<p>@user3 see</p>", User: store.User{ID: "u1"}})
+	assert.Equal(t, []Mention{{UserID: "u3", Email: "u3@example.com", Digest: true}}, req.Mentions)
+	assert.Empty(t, req.Digests)
+}
+
+func TestService_Followers(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{},
+		followers: []string{"reader@example.com", "u1@example.com", "u3@example.com"}}
+
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	dataStore.data["p2"] = store.Comment{ID: "p2", ParentID: "p1", User: store.User{ID: "u2"}}
+	dataStore.data["p3"] = store.Comment{ID: "p3", User: store.User{ID: "u3"}}
+	dataStore.userDetails["u1"] = "u1@example.com"
+	dataStore.userDetails["u3"] = "u3@example.com"
+
+	s := NewService(dataStore, 1, dest)
+	defer s.Close()
+
+	s.Submit(Request{Comment: dataStore.data["p2"]})
+	time.Sleep(time.Millisecond * 110)
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes))
+	assert.Equal(t, []string{"u1@example.com"}, destRes[0].Emails)
+	assert.Equal(t, []string{"reader@example.com", "u3@example.com"}, destRes[0].Followers, "u1 notified as parent author")
+
+	s.Submit(Request{Comment: dataStore.data["p3"]})
+	time.Sleep(time.Millisecond * 110)
+	destRes = dest.Get()
+	require.Equal(t, 2, len(destRes))
+	assert.Empty(t, destRes[1].Emails)
+	assert.Equal(t, []string{"reader@example.com", "u1@example.com"}, destRes[1].Followers, "u3 is the comment author")
+}
+
 func TestService_Recursive(t *testing.T) {
 	dest := &MockDest{id: 1}
 	dataStore := &mockStore{data: map[string]store.Comment{}, userDetails: map[string]string{}}
@@ -278,8 +383,11 @@ func TestService_Nop(t *testing.T) {
 }
 
 type mockStore struct {
-	data        map[string]store.Comment
-	userDetails map[string]string
+	data         map[string]store.Comment
+	userDetails  map[string]string
+	unsubscribed map[string]bool // keyed by user id
+	followers    []string
+	prefs        map[string]store.NotifyPrefs // keyed by user id
 }
 
 func (m mockStore) getUserDetail(userID string) (string, error) {
@@ -298,6 +406,14 @@ func (m mockStore) Get(_ store.Locator, id string, _ store.User) (store.Comment,
 	return res, nil
 }
 
+func (m mockStore) Find(_ store.Locator, _ string, _ store.User) ([]store.Comment, error) {
+	res := make([]store.Comment, 0, len(m.data))
+	for _, c := range m.data {
+		res = append(res, c)
+	}
+	return res, nil
+}
+
 func (m mockStore) GetUserEmail(_, userID string) (string, error) {
 	return m.getUserDetail(userID)
 }
@@ -305,3 +421,18 @@ func (m mockStore) GetUserEmail(_, userID string) (string, error) {
 func (m mockStore) GetUserTelegram(_, userID string) (string, error) {
 	return m.getUserDetail(userID)
 }
+
+func (m mockStore) IsUnsubscribed(_ store.Locator, userID string) bool {
+	return m.unsubscribed[userID]
+}
+
+func (m mockStore) Followers(_ store.Locator) ([]string, error) {
+	return m.followers, nil
+}
+
+func (m mockStore) GetNotifyPrefs(_, userID string) (store.NotifyPrefs, error) {
+	if prefs, ok := m.prefs[userID]; ok {
+		return prefs, nil
+	}
+	return store.DefaultNotifyPrefs, nil
+}
@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/email"
+	log "github.com/go-pkgz/lgr"
+	ntf "github.com/go-pkgz/notify"
+)
+
+// smtpConn is a subset of smtp.Client used by smtpPool
+type smtpConn interface {
+	email.SMTPClient
+	Noop() error
+	Reset() error
+}
+
+// smtpPool sends messages over the kept SMTP connection, instead of the new connection for each message.
+// Connection checked with NOOP before reuse and closed if idle for more than maxIdle, as servers drop
+// idle connections. Messages sent one at a time.
+type smtpPool struct {
+	sender  *email.Sender            // builds and sends messages over pooledClient
+	dial    func() (smtpConn, error) // makes new connection
+	maxIdle time.Duration            // connection not reused if idle for longer
+
+	lock    sync.Mutex // held for the whole message send
+	conn    smtpConn   // kept connection, nil if closed
+	authed  bool       // AUTH passed on conn
+	lastUse time.Time  // time of the last message sent over conn
+}
+
+// newSMTPPool makes smtpPool for the server set by params, with the same sender options as ntf.NewEmail uses
+func newSMTPPool(params ntf.SMTPParams, maxIdle time.Duration) *smtpPool {
+	p := &smtpPool{maxIdle: maxIdle}
+	p.dial = func() (smtpConn, error) { return dialSMTP(params) }
+
+	opts := []email.Option{email.SMTP(pooledClient{pool: p})}
+	if params.Username != "" {
+		opts = append(opts, email.Auth(params.Username, params.Password))
+	}
+	if params.LoginAuth {
+		opts = append(opts, email.LoginAuth())
+	}
+	if params.ContentType != "" {
+		opts = append(opts, email.ContentType(params.ContentType))
+	}
+	if params.Charset != "" {
+		opts = append(opts, email.Charset(params.Charset))
+	}
+	if params.Port != 0 {
+		opts = append(opts, email.Port(params.Port))
+	}
+	p.sender = email.NewSender(params.Host, opts...)
+	return p
+}
+
+// send sends text to the recipient over the kept connection, making the new one if needed
+func (p *smtpPool) send(text, from, to, subject, unsubscribeLink string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.conn != nil && time.Since(p.lastUse) > p.maxIdle {
+		log.Printf("[DEBUG] smtp connection idle since %s, reconnect", p.lastUse.Format(time.RFC3339))
+		p.closeConn()
+	}
+	if p.conn != nil {
+		if err := p.conn.Noop(); err != nil {
+			log.Printf("[DEBUG] smtp connection failed health check, reconnect, %v", err)
+			p.closeConn()
+		}
+	}
+	if p.conn == nil {
+		conn, err := p.dial()
+		if err != nil {
+			return fmt.Errorf("failed to make smtp connection: %w", err)
+		}
+		p.conn, p.authed = conn, false
+	}
+
+	params := email.Params{From: from, To: []string{to}, Subject: subject, UnsubscribeLink: unsubscribeLink}
+	if err := p.sender.Send(text, params); err != nil {
+		return err
+	}
+	p.lastUse = time.Now()
+	return nil
+}
+
+// closeConn closes kept connection, should be called under lock
+func (p *smtpPool) closeConn() {
+	if p.conn == nil {
+		return
+	}
+	if err := p.conn.Close(); err != nil {
+		log.Printf("[DEBUG] can't close smtp connection, %v", err)
+	}
+	p.conn, p.authed = nil, false
+}
+
+// pooledClient is email.SMTPClient over the pool's connection, used by the pool's sender under the pool lock.
+// Quit resets the session instead of closing connection, so the connection kept for the next message,
+// and authentication made once per connection.
+type pooledClient struct {
+	pool *smtpPool
+}
+
+func (c pooledClient) Auth(a smtp.Auth) error {
+	if c.pool.authed {
+		return nil
+	}
+	if err := c.pool.conn.Auth(a); err != nil {
+		return err
+	}
+	c.pool.authed = true
+	return nil
+}
+
+func (c pooledClient) Mail(from string) error        { return c.pool.conn.Mail(from) }
+func (c pooledClient) Rcpt(to string) error          { return c.pool.conn.Rcpt(to) }
+func (c pooledClient) Data() (io.WriteCloser, error) { return c.pool.conn.Data() }
+func (c pooledClient) Quit() error                   { return c.pool.conn.Reset() }
+func (c pooledClient) Close() error                  { c.pool.closeConn(); return nil }
+
+// dialSMTP makes connection to SMTP server the same way email.Sender does
+func dialSMTP(params ntf.SMTPParams) (smtpConn, error) {
+	port := params.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(params.Host, fmt.Sprintf("%d", port))
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: params.InsecureSkipVerify, // #nosec G402
+		ServerName:         params.Host,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	var conn net.Conn
+	var err error
+	if params.TLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: params.TimeOut}, "tcp", addr, tlsConf)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, params.TimeOut)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial smtp %s: %w", addr, err)
+	}
+
+	c, err := smtp.NewClient(conn, params.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to make smtp client for %s: %w", addr, err)
+	}
+	if params.StartTLS && !params.TLS {
+		if err = c.StartTLS(tlsConf); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	return c, nil
+}
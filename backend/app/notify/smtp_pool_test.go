@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ntf "github.com/go-pkgz/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSMTPPool_Reuse(t *testing.T) {
+	srv := newMockSMTPServer(t)
+	pool := newSMTPPool(srv.params(), time.Minute)
+
+	for _, to := range []string{"user1@example.org", "user2@example.org", "user3@example.org"} {
+		require.NoError(t, pool.send("text for "+to, "from@example.org", to, "subj", ""))
+	}
+	assert.Equal(t, 1, srv.connections(), "connection reused")
+	msgs := srv.messages()
+	require.Len(t, msgs, 3)
+	assert.Contains(t, msgs[0], "To: user1@example.org")
+	assert.Contains(t, msgs[2], "text for user3@example.org")
+	assert.Equal(t, 3, srv.count("RSET"), "session reset after each message")
+	assert.Equal(t, 2, srv.count("NOOP"), "connection checked before reuse")
+	assert.Equal(t, 0, srv.count("QUIT"))
+}
+
+func TestSMTPPool_IdleExpired(t *testing.T) {
+	srv := newMockSMTPServer(t)
+	pool := newSMTPPool(srv.params(), 50*time.Millisecond)
+
+	require.NoError(t, pool.send("text", "from@example.org", "user1@example.org", "subj", ""))
+	require.NoError(t, pool.send("text", "from@example.org", "user2@example.org", "subj", ""))
+	assert.Equal(t, 1, srv.connections())
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, pool.send("text", "from@example.org", "user3@example.org", "subj", ""))
+	assert.Equal(t, 2, srv.connections(), "connection recreated after idle expiry")
+	assert.Len(t, srv.messages(), 3)
+}
+
+func TestSMTPPool_HealthCheck(t *testing.T) {
+	srv := newMockSMTPServer(t)
+	pool := newSMTPPool(srv.params(), time.Minute)
+
+	require.NoError(t, pool.send("text", "from@example.org", "user1@example.org", "subj", ""))
+	srv.dropConnections()
+	require.NoError(t, pool.send("text", "from@example.org", "user2@example.org", "subj", ""))
+	assert.Equal(t, 2, srv.connections(), "dropped connection replaced")
+	assert.Len(t, srv.messages(), 2)
+
+	srv.lis.Close()
+	srv.dropConnections()
+	assert.Error(t, pool.send("text", "from@example.org", "user3@example.org", "subj", ""))
+}
+
+func TestEmail_SMTPMaxIdle(t *testing.T) {
+	srv := newMockSMTPServer(t)
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		SMTPMaxIdle:              time.Minute,
+	}, srv.params())
+	require.NoError(t, err)
+	require.NotNil(t, email.pool)
+
+	req := VerificationRequest{SiteID: "remark", User: "test_username", Token: "secret_", Email: "test@example.org"}
+	require.NoError(t, email.SendVerification(context.Background(), req))
+	require.NoError(t, email.SendVerification(context.Background(), req))
+	assert.Equal(t, 1, srv.connections())
+	msgs := srv.messages()
+	require.Len(t, msgs, 2)
+	assert.Contains(t, msgs[1], "Subject: Email verification")
+	assert.Contains(t, msgs[1], "Token:secret_")
+
+	email, err = NewEmail(EmailParams{}, srv.params())
+	require.NoError(t, err)
+	assert.Nil(t, email.pool, "no kept connection by default")
+}
+
+// mockSMTPServer is a minimal SMTP server accepting all messages
+type mockSMTPServer struct {
+	lis net.Listener
+
+	lock     sync.Mutex
+	conns    []net.Conn
+	msgs     []string
+	commands map[string]int
+}
+
+func newMockSMTPServer(t *testing.T) *mockSMTPServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &mockSMTPServer{lis: lis, commands: map[string]int{}}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			srv.lock.Lock()
+			srv.conns = append(srv.conns, conn)
+			srv.lock.Unlock()
+			go srv.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = lis.Close()
+		srv.dropConnections()
+	})
+	return srv
+}
+
+func (s *mockSMTPServer) params() ntf.SMTPParams {
+	addr := s.lis.Addr().(*net.TCPAddr)
+	return ntf.SMTPParams{Host: addr.IP.String(), Port: addr.Port, TimeOut: time.Second}
+}
+
+func (s *mockSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(msg string) { _, _ = conn.Write([]byte(msg + "\r\n")) }
+	reply("220 localhost mock smtp")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+		s.lock.Lock()
+		s.commands[cmd]++
+		s.lock.Unlock()
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			reply("354 go ahead")
+			msg := strings.Builder{}
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.lock.Lock()
+			s.msgs = append(s.msgs, msg.String())
+			s.lock.Unlock()
+			reply("250 accepted")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default: // MAIL, RCPT, RSET and NOOP
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *mockSMTPServer) dropConnections() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+func (s *mockSMTPServer) connections() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.conns)
+}
+
+func (s *mockSMTPServer) messages() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.msgs...)
+}
+
+func (s *mockSMTPServer) count(cmd string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.commands[cmd]
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// throttleWindow is the period ThreadRate counted for
+const throttleWindow = time.Minute
+
+// defaultThreadCooldown used for the thread in digest mode if ThreadCooldown not set
+const defaultThreadCooldown = 15 * time.Minute
+
+// threadRate keeps notifications count of the thread for the current window
+type threadRate struct {
+	start      time.Time // start of the current window
+	count      int       // notifications about the thread in the window
+	digestTill time.Time // thread in digest mode till, zero if not
+}
+
+// throttleThread counts notification about the comment's thread and reports if notifications about the thread
+// should go to digest. Thread with more than ThreadRate notifications per minute switched to digest mode
+// for ThreadCooldown, after it notifications sent as usual. Always false if ThreadRate not set.
+func (e *Email) throttleThread(req Request) bool {
+	if e.ThreadRate <= 0 {
+		return false
+	}
+	key := req.Comment.Locator.SiteID + "::" + req.Comment.Locator.URL
+	now := time.Now()
+
+	e.threadsLock.Lock()
+	defer e.threadsLock.Unlock()
+	if now.Sub(e.threadsCleanup) >= throttleWindow {
+		e.cleanupThreads(now)
+		e.threadsCleanup = now
+	}
+
+	r, ok := e.threads[key]
+	if !ok {
+		r = &threadRate{start: now}
+		e.threads[key] = r
+	}
+	if !r.digestTill.IsZero() {
+		if now.Before(r.digestTill) {
+			return true
+		}
+		log.Printf("[INFO] notifications about %s sent as usual after cool-down", key)
+		r.digestTill, r.start, r.count = time.Time{}, now, 0
+	}
+	if now.Sub(r.start) >= throttleWindow {
+		r.start, r.count = now, 0
+	}
+	r.count++
+	if r.count <= e.ThreadRate {
+		return false
+	}
+	r.digestTill = now.Add(e.threadCooldown())
+	log.Printf("[INFO] more than %d notifications per minute about %s, digest mode till %s",
+		e.ThreadRate, key, r.digestTill.Format(time.RFC3339))
+	return true
+}
+
+// cleanupThreads drops threads with finished window and not in digest mode
+func (e *Email) cleanupThreads(now time.Time) {
+	for k, r := range e.threads {
+		if now.Sub(r.start) >= throttleWindow && now.After(r.digestTill) {
+			delete(e.threads, k)
+		}
+	}
+}
+
+// threadCooldown returns period of digest mode for throttled thread, ThreadCooldown if set
+func (e *Email) threadCooldown() time.Duration {
+	if e.ThreadCooldown > 0 {
+		return e.ThreadCooldown
+	}
+	return defaultThreadCooldown
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestEmail_ThreadThrottle(t *testing.T) {
+	email, sent := prepDigestEmail(t, 0)
+	email.ThreadRate, email.ThreadCooldown = 2, 300*time.Millisecond
+	email.AdminEmails = []string{"admin@example.org"}
+
+	req := func(i int, url string) Request {
+		return Request{Comment: store.Comment{ID: fmt.Sprintf("c%d", i), User: store.User{Name: "user"},
+			Text: fmt.Sprintf("comment %d", i), Locator: store.Locator{SiteID: "remark", URL: url}},
+			Emails: []string{"user@example.org"}}
+	}
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, email.Send(context.Background(), req(i, "https://example.com/post1")))
+	}
+	assert.Len(t, sent()["user@example.org"], 2, "sent immediately within the rate")
+	assert.Len(t, sent()["admin@example.org"], 2)
+
+	for i := 2; i < 5; i++ {
+		require.NoError(t, email.Send(context.Background(), req(i, "https://example.com/post1")))
+	}
+	assert.Len(t, sent()["user@example.org"], 2, "rate exceeded, notifications queued to digest")
+	assert.Len(t, sent()["admin@example.org"], 2)
+
+	require.NoError(t, email.Send(context.Background(), req(10, "https://example.com/post2")))
+	assert.Len(t, sent()["user@example.org"], 3, "other thread not throttled")
+
+	require.Eventually(t, func() bool { return len(sent()["user@example.org"]) == 4 }, 2*time.Second, 10*time.Millisecond,
+		"digest sent after cool-down")
+	user := sent()["user@example.org"]
+	assert.Equal(t, "3 new comments", user[3].subject)
+	for i := 2; i < 5; i++ {
+		assert.Contains(t, user[3].body, fmt.Sprintf("comment %d", i))
+	}
+	require.Eventually(t, func() bool { return len(sent()["admin@example.org"]) == 4 }, 2*time.Second, 10*time.Millisecond)
+
+	// normal rate resumes after cool-down
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, email.Send(context.Background(), req(5, "https://example.com/post1")))
+	user = sent()["user@example.org"]
+	require.Len(t, user, 5, "sent immediately after cool-down")
+	assert.Contains(t, user[4].body, "comment 5")
+}
+
+func TestEmail_ThreadThrottleDisabled(t *testing.T) {
+	email, sent := prepDigestEmail(t, 0)
+	assert.Equal(t, defaultThreadCooldown, email.threadCooldown())
+	for i := 0; i < 10; i++ {
+		req := Request{Comment: store.Comment{ID: fmt.Sprintf("c%d", i), User: store.User{Name: "user"},
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post1"}}, Emails: []string{"user@example.org"}}
+		require.NoError(t, email.Send(context.Background(), req))
+	}
+	assert.Len(t, sent()["user@example.org"], 10)
+	assert.Empty(t, email.threads)
+}
@@ -0,0 +1,216 @@
+package providers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/golang-jwt/jwt"
+)
+
+// FederatedName is the prefix of ids of users authenticated with tokens of federated issuers
+const FederatedName = "federated"
+
+// jwksRefreshInterval limits reload of issuer's keys on token signed by unknown key
+const jwksRefreshInterval = time.Minute
+
+// signingMethods lists asymmetric algorithms accepted for tokens verified with JWKS keys
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// jwk is a single key of JWKS, only public RSA and EC keys supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwks is a cached set of issuer's signing keys loaded from JWKS url
+type jwks struct {
+	url    string
+	client *http.Client
+
+	lock    sync.Mutex
+	keys    map[string]crypto.PublicKey // by key id
+	updated time.Time
+}
+
+// FederatedVerifier verifies tokens of several trusted issuers. The issuer claim of the token selects
+// JWKS of the issuer to look for the signing key, tokens of unknown issuers rejected.
+type FederatedVerifier struct {
+	issuers map[string]*jwks // by issuer
+}
+
+// NewFederatedVerifier makes verifier for issuers with their JWKS urls, keys loaded on the first use
+func NewFederatedVerifier(issuers map[string]string, client *http.Client) *FederatedVerifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res := FederatedVerifier{issuers: map[string]*jwks{}}
+	for iss, url := range issuers {
+		res.issuers[strings.TrimSuffix(iss, "/")] = newJWKS(url, client)
+	}
+	return &res
+}
+
+// User verifies token signature with the key of token's issuer, as well as expiration and audience presence.
+// Returns user made from the token claims, with audience set to token's audience.
+func (f *FederatedVerifier) User(ctx context.Context, raw string) (token.User, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: signingMethods}
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		// claims are decoded before the key lookup, but not verified yet
+		iss, _ := claims["iss"].(string)
+		keys, ok := f.issuers[strings.TrimSuffix(iss, "/")]
+		if !ok {
+			return nil, fmt.Errorf("unknown issuer %q", iss)
+		}
+		kid, _ := t.Header["kid"].(string)
+		return keys.key(ctx, kid)
+	})
+	if err != nil {
+		return token.User{}, fmt.Errorf("can't verify token: %w", err)
+	}
+
+	data := provider.UserData(claims)
+	aud, _ := claims["aud"].(string)
+	switch {
+	case !claims.VerifyExpiresAt(time.Now().Unix(), true):
+		return token.User{}, fmt.Errorf("token expired")
+	case aud == "":
+		return token.User{}, fmt.Errorf("token without audience")
+	case data.Value("sub") == "":
+		return token.User{}, fmt.Errorf("token without subject")
+	}
+
+	// subject is unique within the issuer only
+	u := claimsUser(FederatedName, strings.TrimSuffix(data.Value("iss"), "/")+"/"+data.Value("sub"), data)
+	u.Audience = aud
+	return u, nil
+}
+
+func newJWKS(url string, client *http.Client) *jwks {
+	return &jwks{url: url, client: client}
+}
+
+// key returns issuer's public key by id. Keys reloaded on unknown id, as the issuer could rotate them,
+// but not more often than jwksRefreshInterval.
+func (j *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.lock.Lock()
+	k, ok := j.findKey(kid)
+	refresh := !ok && time.Since(j.updated) > jwksRefreshInterval
+	j.lock.Unlock()
+	if ok {
+		return k, nil
+	}
+	if !refresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	if err := j.load(ctx); err != nil {
+		return nil, err
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if k, ok = j.findKey(kid); !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return k, nil
+}
+
+// findKey looks for key by id, the only key used for token without key id. Should be called under lock.
+func (j *jwks) findKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	k, ok := j.keys[kid]
+	return k, ok
+}
+
+// load loads signing keys from issuer's JWKS, keys of unsupported types skipped
+func (j *jwks) load(ctx context.Context) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, http.NoBody)
+	if err == nil {
+		err = doJSON(j.client, req, &set)
+	}
+	if err != nil {
+		return fmt.Errorf("can't load keys: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			log.Printf("[WARN] key %q of %s skipped, %v", k.Kid, j.url, err)
+			continue
+		}
+		keys[k.Kid] = pk
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable keys in %s", j.url)
+	}
+
+	j.lock.Lock()
+	j.keys, j.updated = keys, time.Now()
+	j.lock.Unlock()
+	return nil
+}
+
+// publicKey makes RSA or EC public key from JWK
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid rsa key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
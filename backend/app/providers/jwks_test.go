@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederatedVerifier_User(t *testing.T) {
+	idp1, idp2 := newMockOIDC(t), newMockOIDC(t)
+	defer idp1.Close()
+	defer idp2.Close()
+	v := NewFederatedVerifier(map[string]string{idp1.issuer: idp1.URL + "/jwks", idp2.issuer + "/": idp2.URL + "/jwks"}, nil)
+	ctx := context.Background()
+
+	u1, err := v.User(ctx, idp1.idToken(t, jwt.MapClaims{"aud": "remark", "name": "user one", "email": "u1@example.com",
+		"email_verified": true}))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u1.ID, FederatedName+"_"), u1.ID)
+	assert.Equal(t, "user one", u1.Name)
+	assert.Equal(t, "u1@example.com", u1.Email)
+	assert.Equal(t, "remark", u1.Audience)
+
+	u2, err := v.User(ctx, idp2.idToken(t, jwt.MapClaims{"aud": "remark", "name": "user two"}))
+	require.NoError(t, err, "issuer configured with trailing slash")
+	assert.Equal(t, "user two", u2.Name)
+	assert.NotEqual(t, u1.ID, u2.ID, "same subject of different issuers")
+
+	_, err = v.User(ctx, idp1.idToken(t, jwt.MapClaims{"aud": "remark"}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, idp1.jwksHits(), "keys cached")
+	assert.Equal(t, 1, idp2.jwksHits(), "keys cached")
+
+	// signed by the second issuer with the same key id, but claims to be issued by the first one
+	_, err = v.User(ctx, idp2.idToken(t, jwt.MapClaims{"aud": "remark", "iss": idp1.issuer}))
+	assert.ErrorContains(t, err, "can't verify token")
+
+	idp3 := newMockOIDC(t)
+	defer idp3.Close()
+	_, err = v.User(ctx, idp3.idToken(t, jwt.MapClaims{"aud": "remark"}))
+	assert.ErrorContains(t, err, "unknown issuer")
+	assert.Equal(t, 0, idp3.jwksHits())
+
+	_, err = v.User(ctx, idp1.idToken(t, jwt.MapClaims{"aud": "remark", "exp": time.Now().Add(-time.Minute).Unix()}))
+	assert.Error(t, err)
+	_, err = v.User(ctx, idp1.idToken(t, jwt.MapClaims{"aud": ""}))
+	assert.EqualError(t, err, "token without audience")
+	_, err = v.User(ctx, idp1.idToken(t, jwt.MapClaims{"aud": "remark", "sub": ""}))
+	assert.EqualError(t, err, "token without subject")
+
+	hs, err := jwt.NewWithClaims(jwt.SigningMethodHS256, idp1.claims(jwt.MapClaims{"aud": "remark"})).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = v.User(ctx, hs)
+	assert.ErrorContains(t, err, "signing method HS256 is invalid")
+}
+
+func TestFederatedVerifier_KeysRotation(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	v := NewFederatedVerifier(map[string]string{idp.issuer: idp.URL + "/jwks"}, nil)
+	ctx := context.Background()
+
+	_, err := v.User(ctx, idp.idToken(t, jwt.MapClaims{"aud": "remark"}))
+	require.NoError(t, err)
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp.lock.Lock()
+	idp.rsaKey, idp.kid = newKey, "rsa-key-2"
+	idp.lock.Unlock()
+
+	_, err = v.User(ctx, idp.idToken(t, jwt.MapClaims{"aud": "remark"}))
+	assert.ErrorContains(t, err, `unknown key "rsa-key-2"`, "keys not reloaded too often")
+
+	v.issuers[idp.issuer].updated = time.Now().Add(-jwksRefreshInterval - time.Second)
+	_, err = v.User(ctx, idp.idToken(t, jwt.MapClaims{"aud": "remark"}))
+	assert.NoError(t, err, "keys reloaded for unknown key id")
+	assert.Equal(t, 2, idp.jwksHits())
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	_, err := jwk{Kty: "oct", Kid: "k1"}.publicKey()
+	assert.EqualError(t, err, `unsupported key type "oct"`)
+	_, err = jwk{Kty: "EC", Crv: "P-192"}.publicKey()
+	assert.EqualError(t, err, `unsupported curve "P-192"`)
+	_, err = jwk{Kty: "RSA", N: "!!", E: "AQAB"}.publicKey()
+	assert.Error(t, err)
+	_, err = jwk{Kty: "RSA", N: "", E: "AQAB"}.publicKey()
+	assert.EqualError(t, err, "invalid rsa key")
+}
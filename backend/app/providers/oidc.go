@@ -0,0 +1,422 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha1" //nolint
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// OIDCName is the name of generic OpenID Connect provider, used in auth routes and user ids
+const OIDCName = "oidc"
+
+// backChannelLogoutEvent is the event of logout token sent by the issuer on back-channel logout
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// Revoker ends sessions of the user logged out at the identity provider, only ones of the provider's session sid
+// if set, implemented by rest.SessionLimiter
+type Revoker interface {
+	RevokeLogout(userID, sid string)
+}
+
+// OIDCParams defines settings of generic OpenID Connect provider
+type OIDCParams struct {
+	Issuer      string   // issuer url, endpoints discovered from {issuer}/.well-known/openid-configuration
+	Cid         string   // client id
+	Csecret     string   // client secret
+	Scopes      []string // scopes requested in addition to openid
+	URL         string   // root url of remark42, used to make callback url
+	JWTIssuer   string   // issuer of remark42 tokens
+	JwtService  provider.TokenService
+	AvatarSaver provider.AvatarSaver
+	HTTPClient  *http.Client            // client for requests to the issuer, http.DefaultClient if not set
+	Refresher   *TokenRefresher         // optional, keeps refresh tokens issued on login
+	Attributes  provider.UserAttributes // optional, claims kept in user attributes, by claim name
+	Revoker     Revoker                 // optional, ends sessions on back-channel logout
+}
+
+// OIDC implements provider.Provider for generic OpenID Connect identity provider.
+// Endpoints discovered from the issuer, id_token signature verified with keys from issuer's JWKS.
+type OIDC struct {
+	OIDCParams
+	issuer      string
+	userInfoURL string
+	conf        oauth2.Config
+	keys        *jwks
+}
+
+// oidcDiscovery is a part of issuer's discovery document used by provider
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDC makes OpenID Connect provider, loading discovery document and signing keys of the issuer
+func NewOIDC(ctx context.Context, params OIDCParams) (*OIDC, error) {
+	if params.HTTPClient == nil {
+		params.HTTPClient = http.DefaultClient
+	}
+	res := OIDC{OIDCParams: params}
+
+	var disc oidcDiscovery
+	discURL := strings.TrimSuffix(params.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := res.getJSON(ctx, discURL, &disc); err != nil {
+		return nil, fmt.Errorf("can't load oidc discovery document: %w", err)
+	}
+	if strings.TrimSuffix(disc.Issuer, "/") != strings.TrimSuffix(params.Issuer, "/") {
+		return nil, fmt.Errorf("oidc issuer mismatch, discovered %q for %q", disc.Issuer, params.Issuer)
+	}
+	if disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete oidc discovery document %+v", disc)
+	}
+
+	res.issuer = disc.Issuer
+	res.userInfoURL = disc.UserInfoEndpoint
+	res.keys = newJWKS(disc.JWKSURI, params.HTTPClient)
+	res.conf = oauth2.Config{
+		ClientID:     params.Cid,
+		ClientSecret: params.Csecret,
+		Scopes:       append([]string{"openid"}, params.Scopes...),
+		Endpoint:     oauth2.Endpoint{AuthURL: disc.AuthorizationEndpoint, TokenURL: disc.TokenEndpoint},
+	}
+
+	if err := res.keys.load(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] oidc provider for %s, auth=%s, token=%s", res.issuer, disc.AuthorizationEndpoint, disc.TokenEndpoint)
+	return &res, nil
+}
+
+// Name returns provider name
+func (o *OIDC) Name() string { return OIDCName }
+
+// LoginHandler - GET /login?from=redirect-back-url&[site|aud]=siteID&session=1&noava=1
+func (o *OIDC) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, nonce := uuid.New().String(), uuid.New().String()
+
+	aud := r.URL.Query().Get("site") // legacy, for back compat
+	if aud == "" {
+		aud = r.URL.Query().Get("aud")
+	}
+
+	claims := token.Claims{
+		Handshake: &token.Handshake{
+			State: state,
+			From:  r.URL.Query().Get("from"),
+			ID:    nonce, // handshake id keeps nonce expected in id_token
+		},
+		SessionOnly: r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0",
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Audience:  aud,
+			ExpiresAt: time.Now().Add(30 * time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+		},
+		NoAva: r.URL.Query().Get("noava") == "1",
+	}
+
+	if _, err := o.JwtService.Set(w, claims); err != nil {
+		sendError(w, r, http.StatusInternalServerError, err, "failed to set token")
+		return
+	}
+
+	conf := o.conf
+	conf.RedirectURL = o.callbackURL(r.URL.Path)
+	http.Redirect(w, r, conf.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce)), http.StatusFound)
+}
+
+// AuthHandler exchanges code for tokens, verifies id_token and redirects to "from" url with user's token set
+// GET /callback
+func (o *OIDC) AuthHandler(w http.ResponseWriter, r *http.Request) {
+	oauthClaims, _, err := o.JwtService.Get(r)
+	if err != nil {
+		sendError(w, r, http.StatusInternalServerError, err, "failed to get token")
+		return
+	}
+	if oauthClaims.Handshake == nil || oauthClaims.Handshake.ID == "" {
+		sendError(w, r, http.StatusForbidden, fmt.Errorf("no handshake"), "invalid handshake token")
+		return
+	}
+	if st := oauthClaims.Handshake.State; st == "" || st != r.URL.Query().Get("state") {
+		sendError(w, r, http.StatusForbidden, fmt.Errorf("state mismatch"), "unexpected state")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, o.HTTPClient)
+	conf := o.conf
+	conf.RedirectURL = o.callbackURL(r.URL.Path)
+	tok, err := conf.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		sendError(w, r, http.StatusInternalServerError, err, "exchange failed")
+		return
+	}
+
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		sendError(w, r, http.StatusInternalServerError, fmt.Errorf("no id_token in token response"), "exchange failed")
+		return
+	}
+	data, err := o.verifyIDToken(ctx, rawIDToken, oauthClaims.Handshake.ID)
+	if err != nil {
+		sendError(w, r, http.StatusForbidden, err, "invalid id_token")
+		return
+	}
+
+	client := conf.Client(ctx, tok)
+	o.addUserInfo(ctx, client, data)
+
+	u := o.mapUser(data)
+	// login time and provider's session id identify the session on back-channel logout
+	u.SetStrAttr(rest.SessionLoginAttr, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	if sid := data.Value("sid"); sid != "" {
+		u.SetStrAttr(rest.SessionSIDAttr, sid)
+	}
+	if o.Refresher != nil && tok.RefreshToken != "" {
+		if err = o.Refresher.Save(u.ID, tok.RefreshToken); err != nil {
+			log.Printf("[WARN] can't save refresh token for %s, %v", u.ID, err)
+		}
+	}
+	if oauthClaims.NoAva {
+		u.Picture = "" // reset picture on no avatar request
+	}
+	if o.AvatarSaver != nil {
+		if u.Picture, err = o.AvatarSaver.Put(u, client); err != nil {
+			sendError(w, r, http.StatusInternalServerError, err, "failed to save avatar to proxy")
+			return
+		}
+	}
+
+	claims := token.Claims{
+		User: &u,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:   o.JWTIssuer,
+			Id:       uuid.New().String(),
+			Audience: oauthClaims.Audience,
+		},
+		SessionOnly: oauthClaims.SessionOnly,
+		NoAva:       oauthClaims.NoAva,
+	}
+	if _, err = o.JwtService.Set(w, claims); err != nil {
+		sendError(w, r, http.StatusInternalServerError, err, "failed to set token")
+		return
+	}
+
+	if oauthClaims.Handshake.From != "" {
+		http.Redirect(w, r, oauthClaims.Handshake.From, http.StatusTemporaryRedirect)
+		return
+	}
+	R.RenderJSON(w, &u)
+}
+
+// LogoutHandler - GET /logout
+func (o *OIDC) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := o.JwtService.Get(r); err != nil {
+		sendError(w, r, http.StatusForbidden, err, "logout not allowed")
+		return
+	}
+	o.JwtService.Reset(w)
+}
+
+// BackChannelLogoutHandler ends sessions of the user logged out at the issuer, with logout token sent by the issuer
+// in logout_token form value. Sessions revoked by Revoker, nothing revoked if it's not set.
+// POST /backchannel-logout
+func (o *OIDC) BackChannelLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	raw := r.PostFormValue("logout_token")
+	if raw == "" {
+		sendError(w, r, http.StatusBadRequest, fmt.Errorf("no logout_token"), "invalid_request")
+		return
+	}
+	sub, sid, err := o.verifyLogoutToken(r.Context(), raw)
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err, "invalid_request")
+		return
+	}
+	userID := ""
+	if sub != "" {
+		userID = claimsUser(OIDCName, sub, provider.UserData{}).ID
+	}
+	if o.Revoker != nil {
+		o.Revoker.RevokeLogout(userID, sid)
+	}
+	log.Printf("[INFO] oidc back-channel logout of %q, session %q", userID, sid)
+	w.WriteHeader(http.StatusOK)
+}
+
+// RefreshToken gets new tokens from the issuer with refresh token, implements RefreshSource
+func (o *OIDC) RefreshToken(ctx context.Context, refreshToken string) (string, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, o.HTTPClient)
+	tok, err := o.conf.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return "", err
+	}
+	return tok.RefreshToken, nil
+}
+
+// verifyIDToken checks id_token signature with issuer's keys, as well as issuer, audience, expiration and nonce.
+// Returns claims of the token.
+func (o *OIDC) verifyIDToken(ctx context.Context, raw, nonce string) (provider.UserData, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: signingMethods}
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't verify id_token: %w", err)
+	}
+
+	switch {
+	case !claims.VerifyIssuer(o.issuer, true):
+		return nil, fmt.Errorf("id_token issuer mismatch, %v", claims["iss"])
+	case !claims.VerifyAudience(o.Cid, true):
+		return nil, fmt.Errorf("id_token audience mismatch, %v", claims["aud"])
+	case !claims.VerifyExpiresAt(time.Now().Unix(), true):
+		return nil, fmt.Errorf("id_token expired")
+	case claims["nonce"] != nonce:
+		return nil, fmt.Errorf("id_token nonce mismatch")
+	case claims["sub"] == nil || claims["sub"] == "":
+		return nil, fmt.Errorf("id_token without subject")
+	}
+	return provider.UserData(claims), nil
+}
+
+// verifyLogoutToken checks logout token signature with issuer's keys, as well as issuer, audience, issue time
+// and logout event. Logout token has subject, session id or both, and never has nonce. Returns subject and session id.
+func (o *OIDC) verifyLogoutToken(ctx context.Context, raw string) (sub, sid string, err error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: signingMethods}
+	_, err = parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("can't verify logout token: %w", err)
+	}
+
+	events, _ := claims["events"].(map[string]interface{})
+	_, isLogout := events[backChannelLogoutEvent].(map[string]interface{})
+	sub, _ = claims["sub"].(string)
+	sid, _ = claims["sid"].(string)
+	switch {
+	case !claims.VerifyIssuer(o.issuer, true):
+		return "", "", fmt.Errorf("logout token issuer mismatch, %v", claims["iss"])
+	case !claims.VerifyAudience(o.Cid, true):
+		return "", "", fmt.Errorf("logout token audience mismatch, %v", claims["aud"])
+	case claims["iat"] == nil:
+		return "", "", fmt.Errorf("logout token without issue time")
+	case !isLogout:
+		return "", "", fmt.Errorf("logout token without logout event")
+	case claims["nonce"] != nil:
+		return "", "", fmt.Errorf("logout token with nonce")
+	case sub == "" && sid == "":
+		return "", "", fmt.Errorf("logout token without subject and session id")
+	}
+	return sub, sid, nil
+}
+
+// addUserInfo adds claims from userinfo endpoint missing in id_token. Userinfo is optional,
+// so failure to get it doesn't fail the login and claims from id_token used as is.
+func (o *OIDC) addUserInfo(ctx context.Context, client *http.Client, data provider.UserData) {
+	if o.userInfoURL == "" {
+		return
+	}
+	info := provider.UserData{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.userInfoURL, http.NoBody)
+	if err == nil {
+		err = doJSON(client, req, &info)
+	}
+	if err != nil {
+		log.Printf("[WARN] can't get oidc user info, %v", err)
+		return
+	}
+	if info.Value("sub") != data.Value("sub") {
+		log.Printf("[WARN] oidc user info ignored, subject %q doesn't match id_token %q", info.Value("sub"), data.Value("sub"))
+		return
+	}
+	for k, v := range info {
+		if _, ok := data[k]; !ok {
+			data[k] = v
+		}
+	}
+}
+
+// mapUser makes user from standard claims, with attributes kept from claims
+func (o *OIDC) mapUser(data provider.UserData) token.User {
+	u := claimsUser(OIDCName, data.Value("sub"), data)
+	for k, v := range o.Attributes {
+		u.SetStrAttr(v, data.Value(k))
+	}
+	return u
+}
+
+// claimsUser makes user from standard claims. Email set only if verified by the issuer.
+func claimsUser(prefix, subject string, data provider.UserData) token.User {
+	u := token.User{
+		// hash subject with provider name to avoid collision if same id returned by other provider
+		ID:      prefix + "_" + token.HashID(sha1.New(), subject),
+		Picture: data.Value("picture"),
+	}
+	for _, k := range []string{"name", "preferred_username", "nickname"} {
+		if u.Name = data.Value(k); u.Name != "" {
+			break
+		}
+	}
+	if u.Name == "" {
+		u.Name = "noname_" + u.ID[len(prefix)+1:len(prefix)+5]
+	}
+	if data.Value("email_verified") == "true" {
+		u.Email = data.Value("email")
+	}
+	return u
+}
+
+// callbackURL makes callback url from login or callback path, i.e. /auth/oidc/login -> {URL}/auth/oidc/callback
+func (o *OIDC) callbackURL(path string) string {
+	elems := strings.Split(path, "/")
+	return strings.TrimSuffix(o.URL, "/") + strings.TrimSuffix(strings.Join(elems[:len(elems)-1], "/"), "/") + "/callback"
+}
+
+func (o *OIDC) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	return doJSON(o.HTTPClient, req, v)
+}
+
+// doJSON makes request and decodes json response
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d from %s, %s", resp.StatusCode, req.URL, string(body))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func sendError(w http.ResponseWriter, r *http.Request, code int, err error, details string) {
+	R.SendErrorJSON(w, r, log.Default(), code, err, details)
+}
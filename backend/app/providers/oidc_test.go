@@ -0,0 +1,518 @@
+package providers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+func TestOIDC_Login(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	o, jwtSvc := idp.provider(t)
+	assert.Equal(t, "oidc", o.Name())
+
+	// login redirects to issuer's authorization endpoint with state and nonce
+	rr := httptest.NewRecorder()
+	o.LoginHandler(rr, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?site=remark&from=http://example.com/post", http.NoBody))
+	require.Equal(t, http.StatusFound, rr.Code)
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, idp.URL+"/authorize", loc.Scheme+"://"+loc.Host+loc.Path)
+	assert.Equal(t, "cid", loc.Query().Get("client_id"))
+	assert.Equal(t, "code", loc.Query().Get("response_type"))
+	assert.Equal(t, "openid profile email", loc.Query().Get("scope"))
+	assert.Equal(t, "http://remark.example.com/auth/oidc/callback", loc.Query().Get("redirect_uri"))
+	state, nonce := loc.Query().Get("state"), loc.Query().Get("nonce")
+	require.NotEmpty(t, state)
+	require.NotEmpty(t, nonce)
+	handshake := rr.Result().Cookies()
+
+	// issuer authenticates the user and redirects back with code, token exchanged for id_token
+	idp.setIDToken("code-123", idp.idToken(t, jwt.MapClaims{"nonce": nonce, "name": "John Doe",
+		"email": "john@example.com", "email_verified": true, "picture": "http://example.com/pic.png"}))
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=code-123&state="+state, http.NoBody)
+	for _, c := range handshake {
+		req.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	o.AuthHandler(rr, req)
+	require.Equal(t, http.StatusTemporaryRedirect, rr.Code, rr.Body.String())
+	assert.Equal(t, "http://example.com/post", rr.Header().Get("Location"))
+
+	var jwtCookie string
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c.Value
+		}
+	}
+	claims, err := jwtSvc.Parse(jwtCookie)
+	require.NoError(t, err)
+	assert.Equal(t, "remark42", claims.Issuer)
+	assert.Equal(t, "remark", claims.Audience)
+	assert.Nil(t, claims.Handshake)
+	require.NotNil(t, claims.User)
+	assert.Equal(t, "oidc_"+token.HashID(sha1.New(), "user-1"), claims.User.ID)
+	assert.Equal(t, "John Doe", claims.User.Name)
+	assert.Equal(t, "john@example.com", claims.User.Email)
+	assert.Equal(t, "http://example.com/pic.png", claims.User.Picture)
+	assert.Equal(t, "Bearer access-code-123", idp.userInfoAuth(), "userinfo requested with access token")
+}
+
+func TestOIDC_BackChannelLogout(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	o, jwtSvc := idp.provider(t)
+	sessions := &rest.SessionLimiter{TTL: time.Hour}
+	o.Revoker = sessions
+
+	login := func(sid string) token.Claims {
+		rr := httptest.NewRecorder()
+		o.LoginHandler(rr, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?site=remark", http.NoBody))
+		loc, err := url.Parse(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		code := "code-" + sid
+		idp.setIDToken(code, idp.idToken(t, jwt.MapClaims{"nonce": loc.Query().Get("nonce"), "sid": sid}))
+		req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code="+code+"&state="+loc.Query().Get("state"), http.NoBody)
+		for _, c := range rr.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		rr = httptest.NewRecorder()
+		o.AuthHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "JWT" {
+				claims, err := jwtSvc.Parse(c.Value)
+				require.NoError(t, err)
+				return claims
+			}
+		}
+		require.Fail(t, "no token issued")
+		return token.Claims{}
+	}
+	logout := func(logoutToken string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/auth/oidc/backchannel-logout",
+			strings.NewReader(url.Values{"logout_token": {logoutToken}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		o.BackChannelLogoutHandler(rr, req)
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+		return rr
+	}
+	event := map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}}
+
+	s1, s2 := login("sid-1"), login("sid-2")
+	assert.Equal(t, "sid-1", s1.User.StrAttr(rest.SessionSIDAttr))
+	assert.NotEmpty(t, s1.User.StrAttr(rest.SessionLoginAttr))
+	assert.False(t, sessions.Revoked(s1))
+
+	// invalid logout tokens rejected, nothing revoked
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims(jwt.MapClaims{"events": event, "sid": "sid-1"}))
+	forged.Header["kid"] = "rsa-key"
+	forgedToken, err := forged.SignedString(otherKey)
+	require.NoError(t, err)
+	tbl := []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"garbage", "not-a-token"},
+		{"bad signature", forgedToken},
+		{"other issuer", idp.idToken(t, jwt.MapClaims{"events": event, "sid": "sid-1", "iss": "http://other.example.com"})},
+		{"other client", idp.idToken(t, jwt.MapClaims{"events": event, "sid": "sid-1", "aud": "other"})},
+		{"expired", idp.idToken(t, jwt.MapClaims{"events": event, "sid": "sid-1", "exp": time.Now().Add(-time.Minute).Unix()})},
+		{"no issue time", idp.idToken(t, jwt.MapClaims{"events": event, "sid": "sid-1", "iat": nil})},
+		{"no event", idp.idToken(t, jwt.MapClaims{"sid": "sid-1"})},
+		{"id token", idp.idToken(t, jwt.MapClaims{"events": event, "sid": "sid-1", "nonce": "123"})},
+		{"no subject and sid", idp.idToken(t, jwt.MapClaims{"events": event, "sub": nil})},
+	}
+	for _, tt := range tbl {
+		rr := logout(tt.token)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.name)
+		assert.Contains(t, rr.Body.String(), "invalid_request", tt.name)
+	}
+	assert.False(t, sessions.Revoked(s1))
+	assert.False(t, sessions.Revoked(s2))
+
+	// logout of the provider's session revokes this session only
+	rr := logout(idp.idToken(t, jwt.MapClaims{"events": event, "sid": "sid-1"}))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.True(t, sessions.Revoked(s1))
+	assert.False(t, sessions.Revoked(s2))
+
+	// logout of the user without sid revokes all sessions logged in before it
+	rr = logout(idp.idToken(t, jwt.MapClaims{"events": event}))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.True(t, sessions.Revoked(s2))
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, sessions.Revoked(login("sid-3")), "new login not affected")
+}
+
+func TestOIDC_UserInfo(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	o, _ := idp.provider(t)
+
+	idp.userInfo = map[string]interface{}{"sub": "user-1", "preferred_username": "jdoe", "email": "jdoe@example.com"}
+	data := idp.verify(t, o, jwt.MapClaims{"nonce": "n1"}, "n1")
+	o.addUserInfo(context.Background(), http.DefaultClient, data)
+	u := o.mapUser(data)
+	assert.Equal(t, "jdoe", u.Name, "name from userinfo")
+	assert.Equal(t, "", u.Email, "email not verified")
+
+	idp.userInfo = map[string]interface{}{"sub": "user-2", "name": "Spoofed"}
+	data = idp.verify(t, o, jwt.MapClaims{"nonce": "n1", "name": "John"}, "n1")
+	o.addUserInfo(context.Background(), http.DefaultClient, data)
+	assert.Equal(t, "John", o.mapUser(data).Name, "userinfo of other subject ignored")
+
+	data = idp.verify(t, o, jwt.MapClaims{"nonce": "n1", "sub": "user-3"}, "n1")
+	o.addUserInfo(context.Background(), http.DefaultClient, data)
+	u = o.mapUser(data)
+	assert.True(t, strings.HasPrefix(u.Name, "noname_"), u.Name)
+
+	o.Attributes = provider.UserAttributes{"display": "claim_display"}
+	data = idp.verify(t, o, jwt.MapClaims{"nonce": "n1", "sub": "user-4", "display": "Display Name"}, "n1")
+	u = o.mapUser(data)
+	assert.Equal(t, "Display Name", u.StrAttr("claim_display"), "nonstandard claim kept in attributes")
+}
+
+func TestOIDC_VerifyIDToken(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	o, _ := idp.provider(t)
+	ctx := context.Background()
+
+	_, err := o.verifyIDToken(ctx, idp.idToken(t, jwt.MapClaims{"nonce": "n1"}), "n1")
+	assert.NoError(t, err)
+
+	tbl := []struct {
+		name   string
+		claims jwt.MapClaims
+		err    string
+	}{
+		{"wrong nonce", jwt.MapClaims{"nonce": "n2"}, "id_token nonce mismatch"},
+		{"no nonce", jwt.MapClaims{}, "id_token nonce mismatch"},
+		{"wrong issuer", jwt.MapClaims{"nonce": "n1", "iss": "http://evil.example.com"}, "id_token issuer mismatch"},
+		{"wrong audience", jwt.MapClaims{"nonce": "n1", "aud": "other-client"}, "id_token audience mismatch"},
+		{"audience list", jwt.MapClaims{"nonce": "n1", "aud": []string{"other", "cid"}}, ""},
+		{"expired", jwt.MapClaims{"nonce": "n1", "exp": time.Now().Add(-time.Minute).Unix()}, "Token is expired"},
+		{"no subject", jwt.MapClaims{"nonce": "n1", "sub": ""}, "id_token without subject"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := o.verifyIDToken(ctx, idp.idToken(t, tt.claims), "n1")
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	// signed by unknown key
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims(jwt.MapClaims{"nonce": "n1"}))
+	tok.Header["kid"] = "rsa-key"
+	raw, err := tok.SignedString(otherKey)
+	require.NoError(t, err)
+	_, err = o.verifyIDToken(ctx, raw, "n1")
+	assert.ErrorContains(t, err, "verification error")
+
+	// unsigned token rejected
+	raw, err = jwt.NewWithClaims(jwt.SigningMethodNone, idp.claims(jwt.MapClaims{"nonce": "n1"})).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = o.verifyIDToken(ctx, raw, "n1")
+	assert.Error(t, err)
+
+	// HMAC signed with client secret rejected
+	raw, err = jwt.NewWithClaims(jwt.SigningMethodHS256, idp.claims(jwt.MapClaims{"nonce": "n1"})).SignedString([]byte("csecret"))
+	require.NoError(t, err)
+	_, err = o.verifyIDToken(ctx, raw, "n1")
+	assert.Error(t, err)
+
+	// EC key from JWKS
+	ecTok := jwt.NewWithClaims(jwt.SigningMethodES256, idp.claims(jwt.MapClaims{"nonce": "n1"}))
+	ecTok.Header["kid"] = "ec-key"
+	raw, err = ecTok.SignedString(idp.ecKey)
+	require.NoError(t, err)
+	_, err = o.verifyIDToken(ctx, raw, "n1")
+	assert.NoError(t, err)
+}
+
+func TestOIDC_KeysRotation(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	o, _ := idp.provider(t)
+	ctx := context.Background()
+	assert.Equal(t, 1, idp.jwksHits())
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp.lock.Lock()
+	idp.rsaKey, idp.kid = newKey, "rsa-key-2"
+	idp.lock.Unlock()
+
+	_, err = o.verifyIDToken(ctx, idp.idToken(t, jwt.MapClaims{"nonce": "n1"}), "n1")
+	assert.ErrorContains(t, err, `unknown key "rsa-key-2"`, "keys not reloaded too often")
+	assert.Equal(t, 1, idp.jwksHits())
+
+	o.keys.updated = time.Now().Add(-jwksRefreshInterval - time.Second)
+	_, err = o.verifyIDToken(ctx, idp.idToken(t, jwt.MapClaims{"nonce": "n1"}), "n1")
+	assert.NoError(t, err, "keys reloaded for unknown key id")
+	assert.Equal(t, 2, idp.jwksHits())
+}
+
+func TestOIDC_AuthHandlerRejects(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	o, _ := idp.provider(t)
+
+	login := func() (state, nonce string, cookies []*http.Cookie) {
+		rr := httptest.NewRecorder()
+		o.LoginHandler(rr, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?site=remark", http.NoBody))
+		loc, err := url.Parse(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		return loc.Query().Get("state"), loc.Query().Get("nonce"), rr.Result().Cookies()
+	}
+	callback := func(query string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query, http.NoBody)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		o.AuthHandler(rr, req)
+		return rr
+	}
+
+	state, nonce, cookies := login()
+	assert.Equal(t, http.StatusInternalServerError, callback("code=c1&state="+state, nil).Code, "no handshake token")
+	assert.Equal(t, http.StatusForbidden, callback("code=c1&state=bad", cookies).Code, "state mismatch")
+
+	idp.setIDToken("c1", idp.idToken(t, jwt.MapClaims{"nonce": "other-nonce"}))
+	assert.Equal(t, http.StatusForbidden, callback("code=c1&state="+state, cookies).Code, "nonce mismatch")
+
+	_, _, otherCookies := login()
+	idp.setIDToken("c2", idp.idToken(t, jwt.MapClaims{"nonce": nonce}))
+	assert.Equal(t, http.StatusForbidden, callback("code=c2&state="+state, otherCookies).Code, "handshake of other login")
+
+	assert.Equal(t, http.StatusInternalServerError, callback("code=unknown&state="+state, cookies).Code, "exchange failed")
+
+	rr := callback("code=c2&state="+state, cookies)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"id":"oidc_`)
+}
+
+func TestNewOIDC_Errors(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+
+	_, err := NewOIDC(context.Background(), OIDCParams{Issuer: idp.URL + "/other", Cid: "cid"})
+	assert.ErrorContains(t, err, "can't load oidc discovery document")
+
+	idp.lock.Lock()
+	idp.issuer = "http://evil.example.com"
+	idp.lock.Unlock()
+	_, err = NewOIDC(context.Background(), OIDCParams{Issuer: idp.URL, Cid: "cid"})
+	assert.ErrorContains(t, err, "oidc issuer mismatch")
+}
+
+// mockOIDC is a minimal OpenID Connect issuer with discovery, JWKS, token and userinfo endpoints
+type mockOIDC struct {
+	*httptest.Server
+	lock      sync.Mutex
+	issuer    string
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+	kid       string
+	idTokens  map[string]string // by code
+	userInfo  map[string]interface{}
+	jwksCount int
+	lastAuth  string
+
+	refreshTokens map[string]bool // valid refresh tokens
+	refreshCount  int
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	m := &mockOIDC{rsaKey: rsaKey, ecKey: ecKey, kid: "rsa-key", idTokens: map[string]string{},
+		refreshTokens: map[string]bool{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		writeJSON(t, w, map[string]string{
+			"issuer":                 m.issuer,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"userinfo_endpoint":      m.URL + "/userinfo",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		m.jwksCount++
+		b64 := base64.RawURLEncoding.EncodeToString
+		writeJSON(t, w, map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": m.kid, "use": "sig", "alg": "RS256",
+				"n": b64(m.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(m.rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-key", "crv": "P-256", "x": b64(m.ecKey.X.Bytes()), "y": b64(m.ecKey.Y.Bytes())},
+			{"kty": "RSA", "kid": "enc-key", "use": "enc", "n": b64(m.rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		cid, csec, _ := r.BasicAuth()
+		if cid != "cid" || csec != "csecret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.PostForm.Get("grant_type") == "refresh_token" {
+			// refresh token rotated on each use
+			rt := r.PostForm.Get("refresh_token")
+			if !m.refreshTokens[rt] {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			m.refreshCount++
+			delete(m.refreshTokens, rt)
+			newRT := fmt.Sprintf("refresh-%d", m.refreshCount)
+			m.refreshTokens[newRT] = true
+			writeJSON(t, w, map[string]interface{}{"access_token": "access-" + newRT, "token_type": "Bearer",
+				"expires_in": 3600, "refresh_token": newRT})
+			return
+		}
+		code := r.PostForm.Get("code")
+		idToken, ok := m.idTokens[code]
+		if !ok || r.PostForm.Get("redirect_uri") != "http://remark.example.com/auth/oidc/callback" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		m.refreshTokens["refresh-"+code] = true
+		writeJSON(t, w, map[string]interface{}{"access_token": "access-" + code, "token_type": "Bearer",
+			"expires_in": 3600, "id_token": idToken, "refresh_token": "refresh-" + code})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		m.lastAuth = r.Header.Get("Authorization")
+		if m.userInfo == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(t, w, m.userInfo)
+	})
+	m.Server = httptest.NewServer(mux)
+	m.issuer = m.URL
+	return m
+}
+
+func (m *mockOIDC) provider(t *testing.T) (*OIDC, *token.Service) {
+	jwtSvc := token.NewService(token.Opts{
+		SecretReader:   token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		TokenDuration:  time.Hour,
+		CookieDuration: time.Hour * 24,
+		Issuer:         "remark42",
+		DisableXSRF:    true,
+	})
+	o, err := NewOIDC(context.Background(), OIDCParams{Issuer: m.URL, Cid: "cid", Csecret: "csecret",
+		Scopes: []string{"profile", "email"}, URL: "http://remark.example.com", JWTIssuer: "remark42", JwtService: jwtSvc})
+	require.NoError(t, err)
+	return o, jwtSvc
+}
+
+// claims returns standard id_token claims overridden by provided ones
+func (m *mockOIDC) claims(override jwt.MapClaims) jwt.MapClaims {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := jwt.MapClaims{"iss": m.issuer, "aud": "cid", "sub": "user-1",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix()}
+	for k, v := range override {
+		res[k] = v
+	}
+	return res
+}
+
+func (m *mockOIDC) idToken(t *testing.T, override jwt.MapClaims) string {
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims(override))
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	tok.Header["kid"] = m.kid
+	res, err := tok.SignedString(m.rsaKey)
+	require.NoError(t, err)
+	return res
+}
+
+func (m *mockOIDC) verify(t *testing.T, o *OIDC, override jwt.MapClaims, nonce string) provider.UserData {
+	data, err := o.verifyIDToken(context.Background(), m.idToken(t, override), nonce)
+	require.NoError(t, err)
+	return data
+}
+
+func (m *mockOIDC) setIDToken(code, idToken string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.idTokens[code] = idToken
+}
+
+func (m *mockOIDC) jwksHits() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.jwksCount
+}
+
+func (m *mockOIDC) refreshes() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.refreshCount
+}
+
+func (m *mockOIDC) revokeRefreshTokens() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.refreshTokens = map[string]bool{}
+}
+
+func (m *mockOIDC) userInfoAuth() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lastAuth
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
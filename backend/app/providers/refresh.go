@@ -0,0 +1,259 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNoRefreshToken returned by RefreshStore for user without stored refresh token
+var ErrNoRefreshToken = errors.New("no refresh token")
+
+// refreshDedupTTL is how long result of refresh kept for the expired remark42 token,
+// to refresh upstream token once for concurrent requests made with the same token
+const refreshDedupTTL = time.Minute
+
+const refreshTokensBucket = "refresh_tokens"
+
+// RefreshStore keeps encrypted refresh tokens of users by user id
+type RefreshStore interface {
+	Get(userID string) ([]byte, error)
+	Set(userID string, data []byte) error
+}
+
+// RefreshSource implemented by providers able to refresh upstream tokens
+type RefreshSource interface {
+	Name() string
+	// RefreshToken gets new upstream tokens with the refresh token and returns refresh token to use next time
+	RefreshToken(ctx context.Context, refreshToken string) (string, error)
+}
+
+// TokenRefresher keeps refresh tokens issued by upstream providers and renews upstream tokens
+// when remark42 token expired. Session of the user with stored refresh token allowed to be extended
+// only if the upstream refresh succeeded, users without stored refresh token are not affected.
+type TokenRefresher struct {
+	store   RefreshStore
+	aead    cipher.AEAD
+	timeout time.Duration
+
+	lock      sync.Mutex               // guards maps below, not held during upstream refresh
+	sources   map[string]RefreshSource // by provider name
+	refreshed map[string]refreshResult // by expired remark42 token
+	users     map[string]*userLock     // by user id, kept while refresh of the user in progress or awaited
+}
+
+type refreshResult struct {
+	err error
+	ts  time.Time
+}
+
+// userLock serializes refreshes of the user, refs is the number of refreshes holding or waiting for the lock
+type userLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewTokenRefresher makes refresher with tokens encrypted by the key derived from secret
+func NewTokenRefresher(store RefreshStore, secret string, timeout time.Duration) (*TokenRefresher, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("empty secret")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("can't make cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("can't make gcm: %w", err)
+	}
+	return &TokenRefresher{store: store, aead: aead, timeout: timeout,
+		sources: map[string]RefreshSource{}, refreshed: map[string]refreshResult{}, users: map[string]*userLock{}}, nil
+}
+
+// Add registers provider able to refresh upstream tokens
+func (t *TokenRefresher) Add(src RefreshSource) {
+	t.lock.Lock()
+	t.sources[src.Name()] = src
+	t.lock.Unlock()
+}
+
+// Save encrypts and stores refresh token of the user
+func (t *TokenRefresher) Save(userID, refreshToken string) error {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("can't make nonce: %w", err)
+	}
+	data := t.aead.Seal(nonce, nonce, []byte(refreshToken), []byte(userID))
+	return t.store.Set(userID, data)
+}
+
+// load reads and decrypts refresh token of the user
+func (t *TokenRefresher) load(userID string) (string, error) {
+	data, err := t.store.Get(userID)
+	if err != nil {
+		return "", err
+	}
+	ns := t.aead.NonceSize()
+	if len(data) < ns {
+		return "", fmt.Errorf("invalid refresh token data")
+	}
+	res, err := t.aead.Open(nil, data[:ns], data[ns:], []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("can't decrypt refresh token: %w", err)
+	}
+	return string(res), nil
+}
+
+// Refresh renews upstream tokens for expired remark42 token tkn with claims. Returns error if refresh
+// token of the user stored but upstream refused to refresh it, which means session shouldn't be extended.
+func (t *TokenRefresher) Refresh(tkn string, claims token.Claims) error {
+	if claims.User == nil || claims.ExpiresAt == 0 || time.Now().Unix() <= claims.ExpiresAt {
+		return nil // not expired, nothing to refresh
+	}
+
+	userID := claims.User.ID
+	src, res, ok := t.lookup(tkn, userID)
+	if ok {
+		return res.err
+	}
+	if src == nil {
+		return nil // provider doesn't support refresh
+	}
+
+	// refreshes of the user serialized, so rotated refresh token never used concurrently,
+	// while refreshes of other users not blocked by the upstream call
+	ul := t.lockUser(userID)
+	defer t.unlockUser(userID, ul)
+
+	// concurrent request with the same token could refresh it while this one waited for the lock
+	if _, res, ok = t.lookup(tkn, userID); ok {
+		return res.err
+	}
+	err := t.refresh(src, userID)
+	t.lock.Lock()
+	t.refreshed[tkn] = refreshResult{err: err, ts: time.Now()}
+	t.lock.Unlock()
+	return err
+}
+
+// lookup returns refresh source of the user and result of the refresh made for the token, if any.
+// Results of refreshes older than refreshDedupTTL dropped.
+func (t *TokenRefresher) lookup(tkn, userID string) (src RefreshSource, res refreshResult, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for k, v := range t.refreshed {
+		if time.Since(v.ts) > refreshDedupTTL {
+			delete(t.refreshed, k)
+		}
+	}
+	res, ok = t.refreshed[tkn]
+	return t.sources[strings.Split(userID, "_")[0]], res, ok
+}
+
+// lockUser acquires lock of the user, made on the first use and dropped by unlockUser once not used
+func (t *TokenRefresher) lockUser(userID string) *userLock {
+	t.lock.Lock()
+	ul, ok := t.users[userID]
+	if !ok {
+		ul = &userLock{}
+		t.users[userID] = ul
+	}
+	ul.refs++
+	t.lock.Unlock()
+	ul.Lock()
+	return ul
+}
+
+func (t *TokenRefresher) unlockUser(userID string, ul *userLock) {
+	ul.Unlock()
+	t.lock.Lock()
+	if ul.refs--; ul.refs == 0 {
+		delete(t.users, userID)
+	}
+	t.lock.Unlock()
+}
+
+// refresh gets new upstream tokens for the user and stores rotated refresh token
+func (t *TokenRefresher) refresh(src RefreshSource, userID string) error {
+	rt, err := t.load(userID)
+	if errors.Is(err, ErrNoRefreshToken) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't load refresh token for %s: %w", userID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	newRT, err := src.RefreshToken(ctx, rt)
+	if err != nil {
+		return fmt.Errorf("upstream refresh for %s failed: %w", userID, err)
+	}
+	if newRT != "" && newRT != rt {
+		if err = t.Save(userID, newRT); err != nil {
+			log.Printf("[WARN] can't save rotated refresh token for %s, %v", userID, err)
+		}
+	}
+	log.Printf("[DEBUG] upstream token refreshed for %s", userID)
+	return nil
+}
+
+// BoltRefreshStore implements RefreshStore with bolt
+type BoltRefreshStore struct {
+	db *bolt.DB
+}
+
+// NewBoltRefreshStore makes persistent refresh tokens store in bolt file
+func NewBoltRefreshStore(fileName string, options bolt.Options) (*BoltRefreshStore, error) {
+	db, err := bolt.Open(fileName, 0o600, &options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make refresh tokens store %s: %w", fileName, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(refreshTokensBucket))
+		return e
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create bucket %s: %w", refreshTokensBucket, err)
+	}
+	return &BoltRefreshStore{db: db}, nil
+}
+
+// Get returns stored data of the user or ErrNoRefreshToken
+func (b *BoltRefreshStore) Get(userID string) (res []byte, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(refreshTokensBucket)).Get([]byte(userID))
+		if v == nil {
+			return ErrNoRefreshToken
+		}
+		res = append([]byte{}, v...)
+		return nil
+	})
+	return res, err
+}
+
+// Set stores data of the user
+func (b *BoltRefreshStore) Set(userID string, data []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(refreshTokensBucket)).Put([]byte(userID), data)
+	})
+}
+
+// Close closes underlying bolt db
+func (b *BoltRefreshStore) Close() error {
+	return b.db.Close()
+}
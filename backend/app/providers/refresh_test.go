@@ -0,0 +1,284 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/token"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestTokenRefresher_Refresh(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	store, refresher := prepRefresher(t)
+	o, jwtSvc := idp.provider(t)
+	o.Refresher = refresher
+	refresher.Add(o)
+
+	claims := oidcLogin(t, idp, o, jwtSvc)
+	userID := claims.User.ID
+
+	// refresh token stored encrypted
+	data, err := store.Get(userID)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "refresh-code-1")
+	rt, err := refresher.load(userID)
+	require.NoError(t, err)
+	assert.Equal(t, "refresh-code-1", rt)
+
+	// not expired token doesn't cause refresh
+	claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	require.NoError(t, refresher.Refresh("tkn-1", claims))
+	assert.Equal(t, 0, idp.refreshes())
+
+	// expired token refreshed upstream, rotated refresh token stored
+	claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	require.NoError(t, refresher.Refresh("tkn-1", claims))
+	assert.Equal(t, 1, idp.refreshes())
+	rt, err = refresher.load(userID)
+	require.NoError(t, err)
+	assert.Equal(t, "refresh-1", rt)
+
+	// concurrent requests with the same expired token refreshed once
+	require.NoError(t, refresher.Refresh("tkn-1", claims))
+	assert.Equal(t, 1, idp.refreshes())
+
+	require.NoError(t, refresher.Refresh("tkn-2", claims))
+	assert.Equal(t, 2, idp.refreshes())
+
+	// upstream refused to refresh
+	idp.revokeRefreshTokens()
+	err = refresher.Refresh("tkn-3", claims)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "upstream refresh for "+userID+" failed")
+	assert.Error(t, refresher.Refresh("tkn-3", claims), "failed result kept for the token")
+
+	// users of other providers and without stored token not affected
+	other := token.Claims{User: &token.User{ID: "github_123"}, StandardClaims: jwt.StandardClaims{ExpiresAt: claims.ExpiresAt}}
+	assert.NoError(t, refresher.Refresh("tkn-4", other))
+	other.User.ID = "oidc_123"
+	assert.NoError(t, refresher.Refresh("tkn-5", other))
+
+	// token encrypted for another user can't be used
+	require.NoError(t, store.Set("oidc_123", data))
+	err = refresher.Refresh("tkn-6", other)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't decrypt refresh token")
+}
+
+func TestTokenRefresher_Session(t *testing.T) {
+	idp := newMockOIDC(t)
+	defer idp.Close()
+	_, refresher := prepRefresher(t)
+
+	authenticator := auth.NewService(auth.Opts{
+		SecretReader:   token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		TokenDuration:  time.Hour,
+		CookieDuration: time.Hour * 24,
+		Issuer:         "remark42",
+		URL:            "http://remark.example.com",
+		DisableXSRF:    true,
+		Validator: token.ValidatorFunc(func(tkn string, claims token.Claims) bool {
+			return refresher.Refresh(tkn, claims) == nil
+		}),
+	})
+	o, err := NewOIDC(context.Background(), OIDCParams{Issuer: idp.URL, Cid: "cid", Csecret: "csecret",
+		URL: "http://remark.example.com", JWTIssuer: "remark42", JwtService: authenticator.TokenService(), Refresher: refresher})
+	require.NoError(t, err)
+	refresher.Add(o)
+	authenticator.AddCustomHandler(o)
+
+	claims := oidcLogin(t, idp, o, authenticator.TokenService())
+	claims.ExpiresAt = time.Now().Add(-time.Minute).Unix() // remark42 token expired
+	expired, err := authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+
+	m := authenticator.Middleware()
+	h := m.Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, e := token.GetUserInfo(r)
+		require.NoError(t, e)
+		_, _ = w.Write([]byte(u.ID))
+	}))
+	call := func(tkn string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.AddCookie(&http.Cookie{Name: "JWT", Value: tkn})
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// upstream refresh extends the session with new token
+	rr := call(expired)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, claims.User.ID, rr.Body.String())
+	assert.Equal(t, 1, idp.refreshes())
+	var renewed string
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "JWT" {
+			renewed = c.Value
+		}
+	}
+	require.NotEmpty(t, renewed)
+	renewedClaims, err := authenticator.TokenService().Parse(renewed)
+	require.NoError(t, err)
+	assert.True(t, renewedClaims.ExpiresAt > time.Now().Unix())
+
+	// failed upstream refresh ends the session
+	idp.revokeRefreshTokens()
+	claims.Id = "other-session"
+	expired, err = authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	rr = call(expired)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "JWT" {
+			assert.Equal(t, "", c.Value, "token reset")
+		}
+	}
+}
+
+func TestTokenRefresher_PerUser(t *testing.T) {
+	_, refresher := prepRefresher(t)
+	src := &blockingSource{release: make(chan struct{}), calls: map[string]int{}}
+	refresher.Add(src)
+	require.NoError(t, refresher.Save("blocking_slow", "rt-slow"))
+	require.NoError(t, refresher.Save("blocking_fast", "rt-fast"))
+
+	expired := func(userID string) token.Claims {
+		return token.Claims{User: &token.User{ID: userID}, StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix()}}
+	}
+
+	var wg sync.WaitGroup
+	for _, tkn := range []string{"tkn-slow-1", "tkn-slow-1", "tkn-slow-2"} {
+		wg.Add(1)
+		go func(tkn string) {
+			defer wg.Done()
+			assert.NoError(t, refresher.Refresh(tkn, expired("blocking_slow")))
+		}(tkn)
+	}
+	time.Sleep(50 * time.Millisecond) // let slow refresh start and block upstream
+
+	done := make(chan error)
+	go func() { done <- refresher.Refresh("tkn-fast", expired("blocking_fast")) }()
+	select {
+	case err := <-done:
+		require.NoError(t, err, "refresh of another user not blocked by upstream call of the slow one")
+	case <-time.After(time.Second):
+		t.Fatal("refresh of another user blocked")
+	}
+
+	close(src.release)
+	wg.Wait()
+	assert.Equal(t, map[string]int{"rt-slow": 1, "rt-slow-next": 1, "rt-fast": 1}, src.calls,
+		"the same token refreshed once, refreshes of the user serialized with rotated token")
+	assert.Equal(t, 1, src.maxActive, "no concurrent refreshes of the user")
+	assert.Empty(t, refresher.users, "locks of users dropped")
+}
+
+// blockingSource rotates refresh token adding "-next" to it, blocks refreshes of slow user until released
+type blockingSource struct {
+	release   chan struct{}
+	lock      sync.Mutex
+	calls     map[string]int // by refresh token
+	active    int            // refreshes of slow user in progress
+	maxActive int
+}
+
+func (b *blockingSource) Name() string { return "blocking" }
+
+func (b *blockingSource) RefreshToken(_ context.Context, refreshToken string) (string, error) {
+	slow := strings.HasPrefix(refreshToken, "rt-slow")
+	b.lock.Lock()
+	b.calls[refreshToken]++
+	if slow {
+		b.active++
+		b.maxActive = max(b.maxActive, b.active)
+	}
+	b.lock.Unlock()
+
+	if slow {
+		<-b.release
+		b.lock.Lock()
+		b.active--
+		b.lock.Unlock()
+	}
+	return refreshToken + "-next", nil
+}
+
+func TestBoltRefreshStore(t *testing.T) {
+	store, err := NewBoltRefreshStore(filepath.Join(t.TempDir(), "refresh.db"), bolt.Options{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.Get("user1")
+	assert.ErrorIs(t, err, ErrNoRefreshToken)
+	require.NoError(t, store.Set("user1", []byte("data1")))
+	res, err := store.Get("user1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data1"), res)
+	require.NoError(t, store.Set("user1", []byte("data2")))
+	res, err = store.Get("user1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data2"), res)
+
+	_, err = NewBoltRefreshStore(filepath.Join(t.TempDir(), "no-such-dir", "refresh.db"), bolt.Options{})
+	assert.Error(t, err)
+}
+
+func TestNewTokenRefresher(t *testing.T) {
+	_, err := NewTokenRefresher(nil, "", time.Second)
+	assert.EqualError(t, err, "empty secret")
+}
+
+func prepRefresher(t *testing.T) (*BoltRefreshStore, *TokenRefresher) {
+	dbFile := filepath.Join(t.TempDir(), "refresh.db")
+	store, err := NewBoltRefreshStore(dbFile, bolt.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, store.Close())
+		_ = os.Remove(dbFile)
+	})
+	refresher, err := NewTokenRefresher(store, "secret", time.Second*5)
+	require.NoError(t, err)
+	return store, refresher
+}
+
+// oidcLogin makes login with code "code-1" and returns claims of the token set by the provider
+func oidcLogin(t *testing.T, idp *mockOIDC, o *OIDC, jwtSvc *token.Service) token.Claims {
+	rr := httptest.NewRecorder()
+	o.LoginHandler(rr, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?site=remark", http.NoBody))
+	require.Equal(t, http.StatusFound, rr.Code)
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	idp.setIDToken("code-1", idp.idToken(t, jwt.MapClaims{"nonce": loc.Query().Get("nonce"), "name": "John Doe"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=code-1&state="+loc.Query().Get("state"), http.NoBody)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	o.AuthHandler(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "JWT" {
+			claims, e := jwtSvc.Parse(c.Value)
+			require.NoError(t, e)
+			return claims
+		}
+	}
+	t.Fatal("no JWT cookie set")
+	return token.Claims{}
+}
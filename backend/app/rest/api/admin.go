@@ -1,21 +1,26 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/token"
 	cache "github.com/go-pkgz/lcw/v2"
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
 )
 
 // admin provides router for all requests available for admin users only
@@ -25,10 +30,27 @@ type admin struct {
 	authenticator *auth.Service
 	readOnlyAge   int
 	migrator      *Migrator
+	keyRotator    keyRotator
+	keyGrace      time.Duration // default grace period of the previous key on rotation
+	flagScore     int           // comments with score at or below are listed as flagged
+	preview       previewGuard  // issues preview tokens of unpublished pages
+	modLog        *service.ModLog
+	claimsUpd     token.ClaimsUpdater // applied to claims on dry run, nil if not set
+	validator     token.Validator     // checks inspected token the same way as auth does, nil if not set
+	audNormalizer func(string) string // optional, normalizes site id of inspected token
+}
+
+// keyRotator rotates signing keys of sites, nil if rotation disabled
+type keyRotator interface {
+	Rotate(siteID string, grace time.Duration) (adminstore.KeyStatus, error)
+	Promote(siteID string) (adminstore.KeyStatus, error)
+	Status(siteID string) (adminstore.KeyStatus, error)
+	PreviousKey(siteID string) (string, error)
 }
 
 type adminStore interface {
 	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
+	Restore(locator store.Locator, commentID string) (store.Comment, error)
 	DeleteUser(siteID, userID string, mode store.DeleteMode) error
 	DeleteUserDetail(siteID, userID string, detail engine.UserDetail) error
 	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
@@ -37,9 +59,19 @@ type adminStore interface {
 	BlockedUsers(siteID string) ([]store.BlockedUser, error)
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
 	SetTitle(locator store.Locator, commentID string) (comment store.Comment, err error)
+	CommentHistory(locator store.Locator, commentID string) ([]store.CommentVersion, error)
 	SetVerified(siteID, userID string, status bool) error
 	SetReadOnly(locator store.Locator, status bool) error
+	SetVotingFrozen(siteID string, status bool) error
 	SetPin(locator store.Locator, commentID string, status bool) error
+	SetModPin(locator store.Locator, commentID string, status bool) error
+	SetHidden(locator store.Locator, commentID string, hidden bool) error
+	SetLocked(locator store.Locator, commentID string, status bool) error
+	GetUserEmail(siteID, userID string) (string, error)
+	GetUserTelegram(siteID, userID string) (string, error)
+	UserVotes(siteID, userID string) ([]service.UserVote, error)
+	MergeUsers(siteID, fromID, toID string) (service.MergeReport, error)
+	ModerationList(siteID string, filter service.ModerationFilter, user store.User) ([]store.Comment, int, error)
 }
 
 // DELETE /comment/{id}?site=siteID&url=post-url - removes comment
@@ -53,26 +85,73 @@ func (a *admin) deleteCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete comment", rest.ErrInternal)
 		return
 	}
+	logModeration(a.modLog, service.ModLogEntry{SiteID: locator.SiteID, Action: service.ModActionDelete,
+		Moderator: rest.MustGetUserInfo(r).ID, CommentID: id, URL: locator.URL})
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"id": id, "locator": locator})
 }
 
-// DELETE /user/{userid}?site=side-id - delete all user comments for requested userid
+// PUT /restore/{id}?site=siteID&url=post-url - restores comment deleted by moderator from trash
+func (a *admin) restoreCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	log.Printf("[INFO] restore comment %s", id)
+
+	comment, err := a.dataService.Restore(locator, id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, service.ErrNotTrashed) {
+			code = http.StatusBadRequest
+		}
+		rest.SendErrorJSON(w, r, code, err, "can't restore comment", rest.ErrActionRejected)
+		return
+	}
+	logModeration(a.modLog, service.ModLogEntry{SiteID: locator.SiteID, Action: service.ModActionRestore,
+		Moderator: rest.MustGetUserInfo(r).ID, UserID: comment.User.ID, CommentID: id, URL: locator.URL})
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	render.JSON(w, r, comment)
+}
+
+// DELETE /user/{userid}?site=side-id&mode=[hard|anonymize] - delete all user comments for requested userid.
+// In anonymize mode comments kept with the text, but author replaced with "deleted user"
 func (a *admin) deleteUserCtrl(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userid")
 	siteID := r.URL.Query().Get("site")
-	log.Printf("[INFO] delete all user comments for %s, site %s", userID, siteID)
+	mode, err := parseDeleteMode(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse delete mode", rest.ErrDecode)
+		return
+	}
+	log.Printf("[INFO] delete all user comments for %s, site %s, mode %d", userID, siteID, mode)
 
-	if err := a.dataService.DeleteUser(siteID, userID, store.HardDelete); err != nil {
+	if err := a.dataService.DeleteUser(siteID, userID, mode); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete user", rest.ErrInternal)
 		return
 	}
+	logModeration(a.modLog, service.ModLogEntry{SiteID: siteID, Action: service.ModActionDeleteUser,
+		Moderator: rest.MustGetUserInfo(r).ID, UserID: userID})
 	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID})
 }
 
+// PUT /user/{userid}/merge?site=site-id&into=user-id - merge duplicate identity of the user into another one,
+// comments and votes reattributed to the surviving identity with duplicate votes dropped. Returns merge report
+func (a *admin) mergeUserCtrl(w http.ResponseWriter, r *http.Request) {
+	fromID, toID := chi.URLParam(r, "userid"), r.URL.Query().Get("into")
+	siteID := r.URL.Query().Get("site")
+	log.Printf("[INFO] merge user %s into %s, site %s", fromID, toID, siteID)
+
+	res, err := a.dataService.MergeUsers(siteID, fromID, toID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't merge users", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(fromID, toID, siteID, lastCommentsScope))
+	render.JSON(w, r, res)
+}
+
 // GET /user/{userid}?site=side-id - get user info for requested userid
 func (a *admin) getUserInfoCtrl(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userid")
@@ -88,10 +167,30 @@ func (a *admin) getUserInfoCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, ucomments[0].User)
 }
 
+// GET /userdata/{userid}?site=siteID - exports all data about the user on user's behalf, same as user's /userdata.
+// User info taken from the last comment of the user
+func (a *admin) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+	log.Printf("[INFO] export all data for user %s, site %s, requested by %s", userID, siteID, rest.MustGetUserInfo(r).ID)
+
+	user := store.User{ID: userID, SiteID: siteID}
+	if ucomments, err := a.dataService.User(siteID, userID, 1, 0, store.User{}); err == nil && len(ucomments) > 0 {
+		user = ucomments[0].User
+		user.SiteID = siteID
+	}
+	writeUserData(w, r, a.dataService, siteID, user)
+}
+
 // GET /deleteme?token=jwt - delete all user comments and details by user's request. Gets info about deleted used from provided token
 // request made GET to allow direct click from the email sent by user
 func (a *admin) deleteMeRequestCtrl(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
+	mode, err := parseDeleteMode(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse delete mode", rest.ErrDecode)
+		return
+	}
 
 	claims, err := a.authenticator.TokenService().Parse(token)
 	if err != nil {
@@ -113,7 +212,7 @@ func (a *admin) deleteMeRequestCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = a.dataService.DeleteUser(claims.Audience, claims.User.ID, store.HardDelete); err != nil {
+	if err = a.dataService.DeleteUser(claims.Audience, claims.User.ID, mode); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete user", rest.ErrNoAccess)
 		return
 	}
@@ -148,6 +247,11 @@ func (a *admin) setBlockCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set blocking status", rest.ErrActionRejected)
 		return
 	}
+	action := service.ModActionUnblock
+	if blockStatus {
+		action = service.ModActionBlock
+	}
+	logModeration(a.modLog, service.ModLogEntry{SiteID: siteID, Action: action, Moderator: rest.MustGetUserInfo(r).ID, UserID: userID})
 
 	// delete comments for permanently blocked user.
 	if blockStatus && ttl == time.Duration(0) {
@@ -170,6 +274,90 @@ func (a *admin) blockedUsersCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, users)
 }
 
+// GET /comments?site=siteID&status=[published|pending|deleted|flagged]&user=id&from=unix_ts_msec&to=unix_ts_msec&limit=N&skip=M
+// lists comments of the site for moderation, newest first. All filters are optional, from is inclusive and to is exclusive.
+// Pending are comments held for review, flagged are published comments with score at or below low score threshold.
+func (a *admin) listCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	filter := service.ModerationFilter{
+		Status:    r.URL.Query().Get("status"),
+		UserID:    r.URL.Query().Get("user"),
+		FlagScore: a.flagScore,
+	}
+
+	parseNum := func(key string) (int64, error) {
+		v := r.URL.Query().Get(key)
+		if v == "" {
+			return 0, nil
+		}
+		res, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || res < 0 {
+			return 0, fmt.Errorf("invalid %s %q", key, v)
+		}
+		return res, nil
+	}
+	nums := map[string]int64{}
+	for _, key := range []string{"from", "to", "limit", "skip"} {
+		n, err := parseNum(key)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse query", rest.ErrDecode)
+			return
+		}
+		nums[key] = n
+	}
+	if nums["from"] > 0 {
+		filter.From = time.UnixMilli(nums["from"])
+	}
+	if nums["to"] > 0 {
+		filter.To = time.UnixMilli(nums["to"])
+	}
+	filter.Limit, filter.Skip = int(nums["limit"]), int(nums["skip"])
+
+	comments, total, err := a.dataService.ModerationList(siteID, filter, rest.MustGetUserInfo(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't list comments", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"comments": comments, "count": total})
+}
+
+// GET /modlog?site=siteID&from=unix_ts_msec&to=unix_ts_msec - exports moderation log of the site, oldest first.
+// Filters are optional, from is inclusive and to is exclusive.
+func (a *admin) modLogCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.modLog == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("moderation log disabled"),
+			"can't export moderation log", rest.ErrActionRejected)
+		return
+	}
+	var from, to time.Time
+	for key, ts := range map[string]*time.Time{"from": &from, "to": &to} {
+		v := r.URL.Query().Get(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid %s %q", key, v), "can't parse query", rest.ErrDecode)
+			return
+		}
+		*ts = time.UnixMilli(n)
+	}
+
+	entries, err := a.modLog.Find(r.URL.Query().Get("site"), from, to)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't export moderation log", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+// logModeration records moderation action, failure only logged as the action already made
+func logModeration(modLog *service.ModLog, e service.ModLogEntry) {
+	if err := modLog.Add(e); err != nil {
+		log.Printf("[WARN] can't record moderation action %s by %s, %v", e.Action, e.Moderator, err)
+	}
+}
+
 // PUT /readonly?site=siteID&url=post-url&ro=1 - set or reset read-only status for the post
 func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
@@ -197,6 +385,70 @@ func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"locator": locator, "read-only": roStatus})
 }
 
+// PUT /voting?site=siteID&frozen=1 - freeze or unfreeze voting for the whole site, existing scores kept intact
+func (a *admin) setVotingFrozenCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	frozen := r.URL.Query().Get("frozen") == "1"
+
+	if err := a.dataService.SetVotingFrozen(siteID, frozen); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set voting status", rest.ErrSiteNotFound)
+		return
+	}
+	log.Printf("[INFO] voting for site %s frozen: %v", siteID, frozen)
+	render.JSON(w, r, R.JSON{"site": siteID, "voting_frozen": frozen})
+}
+
+// GET /key?site=siteID - get status of signing key rotation for the site
+func (a *admin) keyStatusCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.keyRotator == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("rejected"), "key rotation disabled", rest.ErrActionRejected)
+		return
+	}
+	status, err := a.keyRotator.Status(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get key status", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, status)
+}
+
+// PUT /key/rotate?site=siteID&grace=24h - make new signing key for the site, the current key is valid for
+// verification during the grace period. Grace period of key rotation options used if not set
+func (a *admin) rotateKeyCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.keyRotator == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("rejected"), "key rotation disabled", rest.ErrActionRejected)
+		return
+	}
+	grace := a.keyGrace
+	if g := r.URL.Query().Get("grace"); g != "" {
+		var err error
+		if grace, err = time.ParseDuration(g); err != nil || grace < 0 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid grace period %q", g), "can't parse grace", rest.ErrDecode)
+			return
+		}
+	}
+	status, err := a.keyRotator.Rotate(r.URL.Query().Get("site"), grace)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't rotate key", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, status)
+}
+
+// PUT /key/promote?site=siteID - end the grace period of the previous signing key
+func (a *admin) promoteKeyCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.keyRotator == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("rejected"), "key rotation disabled", rest.ErrActionRejected)
+		return
+	}
+	status, err := a.keyRotator.Promote(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't promote key", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, status)
+}
+
 // PUT /title/{id}?site=siteID&url=post-url - set comment PostTitle to page's title
 func (a *admin) setTitleCtrl(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -214,6 +466,19 @@ func (a *admin) setTitleCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"id": id, "locator": locator})
 }
 
+// GET /history/{id}?site=siteID&url=post-url - get all versions of the comment, from the oldest to the current one
+func (a *admin) commentHistoryCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	versions, err := a.dataService.CommentHistory(locator, id)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment history", rest.ErrCommentNotFound)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": id, "versions": versions})
+}
+
 // PUT /verify?site=siteID&url=post-url&ro=1 - set or reset read-only status for the post
 func (a *admin) setVerifyCtrl(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userid")
@@ -228,17 +493,89 @@ func (a *admin) setVerifyCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"user": userID, "verified": verifyStatus})
 }
 
-// PUT /pin/{id}?site=siteID&url=post-url&pin=1
-// mark/unmark comment as a special
+// PUT /pin/{id}?site=siteID&url=post-url&pin=1&mod=1
+// mark/unmark comment as a special. With mod=1 pin affects order for moderators only
 func (a *admin) setPinCtrl(w http.ResponseWriter, r *http.Request) {
 	commentID := chi.URLParam(r, "id")
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
 	pinStatus := r.URL.Query().Get("pin") == "1"
+	modOnly := pinStatus && r.URL.Query().Get("mod") == "1"
 
-	if err := a.dataService.SetPin(locator, commentID, pinStatus); err != nil {
+	setPin := a.dataService.SetPin
+	if modOnly {
+		setPin = a.dataService.SetModPin
+	}
+	if err := setPin(locator, commentID, pinStatus); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set pin status", rest.ErrActionRejected)
 		return
 	}
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
-	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "pin": pinStatus})
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "pin": pinStatus, "mod": modOnly})
+}
+
+// PUT /hide/{id}?site=siteID&url=post-url&hide=1 - hides comment from readers or shows it back with hide=0.
+// Reports of the comment reset on show.
+func (a *admin) setHiddenCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	hidden := r.URL.Query().Get("hide") == "1"
+
+	if err := a.dataService.SetHidden(locator, commentID, hidden); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set hidden status", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, lastCommentsScope, locator.SiteID))
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "hidden": hidden})
+}
+
+// PUT /lock/{id}?site=siteID&url=post-url&lock=1 - locks thread started by the comment or unlocks it with lock=0.
+// Replies, votes and edits rejected in locked thread, new top-level comments of the post still allowed.
+func (a *admin) setLockedCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	locked := r.URL.Query().Get("lock") == "1"
+
+	if err := a.dataService.SetLocked(locator, commentID, locked); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set lock status", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, lastCommentsScope))
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "locked": locked})
+}
+
+// parseDeleteMode returns user deletion mode from mode query param, hard delete by default
+func parseDeleteMode(r *http.Request) (store.DeleteMode, error) {
+	switch r.URL.Query().Get("mode") {
+	case "", "hard":
+		return store.HardDelete, nil
+	case "anonymize":
+		return store.AnonymizeDelete, nil
+	default:
+		return store.HardDelete, fmt.Errorf("unknown delete mode %q", r.URL.Query().Get("mode"))
+	}
+}
+
+// GET /preview?site=siteID&url=post-url&ttl=2h - make preview token for the unpublished page,
+// default lifetime used if ttl not set. Returns token and its expiration
+func (a *admin) previewTokenCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, url := r.URL.Query().Get("site"), r.URL.Query().Get("url")
+	if !a.preview.restricted(url) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("%s is not unpublished page", url),
+			"preview not required", rest.ErrActionRejected)
+		return
+	}
+	var ttl time.Duration
+	if t := r.URL.Query().Get("ttl"); t != "" {
+		var err error
+		if ttl, err = time.ParseDuration(t); err != nil || ttl <= 0 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid ttl %q", t), "can't parse ttl", rest.ErrDecode)
+			return
+		}
+	}
+	tkn, expires, err := a.preview.issue(siteID, url, ttl)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't make preview token", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"token": tkn, "expires": expires.UTC(), "url": url})
 }
@@ -9,18 +9,25 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/provider"
 	"github.com/go-pkgz/auth/token"
 	cache "github.com/go-pkgz/lcw/v2"
 	R "github.com/go-pkgz/rest"
 	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
 
+	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
 
@@ -107,6 +114,60 @@ func TestAdmin_Delete(t *testing.T) {
 		{URL: "https://radio-t.com/blah2", Count: 0}}, j)
 }
 
+func TestAdmin_Restore(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.TrashTTL = time.Hour
+
+	c1 := store.Comment{Text: "test test #1", User: store.User{ID: "id", Name: "name"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	id1 := addComment(t, c1, ts)
+
+	restore := func(id string) (int, store.Comment) {
+		req, err := http.NewRequest(http.MethodPut,
+			fmt.Sprintf("%s/api/v1/admin/restore/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id), http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		c := store.Comment{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		return resp.StatusCode, c
+	}
+
+	code, _ := restore(id1)
+	assert.Equal(t, http.StatusBadRequest, code, "not deleted")
+
+	req, err := http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, code := getWithDevAuth(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "test test #1", "trash not shown to readers")
+	body, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "test test #1", "trash shown to admins")
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/restore/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1), http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	code, c := restore(id1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, id1, c.ID)
+	assert.Equal(t, "<p>test test #1</p>\n", c.Text)
+	assert.False(t, c.Deleted)
+
+	res, code := get(t, ts.URL+"/api/v1/count?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, res, `"count":1`)
+}
+
 func TestAdmin_Title(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -152,6 +213,56 @@ func TestAdmin_Title(t *testing.T) {
 	assert.Equal(t, "post1 blah 123", cr.PostTitle)
 }
 
+func TestAdmin_MergeUser(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", User: store.User{ID: "email_1", Name: "name"}, Locator: locator})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "test test #2", User: store.User{ID: "github_1", Name: "name gh"}, Locator: locator})
+	require.NoError(t, err)
+	id3, err := srv.DataService.Create(store.Comment{Text: "test test #3", User: store.User{ID: "other", Name: "other"}, Locator: locator})
+	require.NoError(t, err)
+	for _, u := range []string{"email_1", "github_1"} {
+		_, err = srv.DataService.Vote(service.VoteReq{Locator: locator, CommentID: id3, UserID: u, Val: true})
+		require.NoError(t, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/user/email_1/merge?site=remark42&into=github_1", http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	res := service.MergeReport{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, service.MergeReport{SiteID: "remark42", FromID: "email_1", ToID: "github_1", Comments: 1, Collapsed: 1}, res)
+
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Len(t, comments.Comments, 3)
+	for _, c := range comments.Comments {
+		switch c.ID {
+		case id1, id2:
+			assert.Equal(t, "github_1", c.User.ID)
+			assert.Equal(t, "name gh", c.User.Name)
+		case id3:
+			assert.Equal(t, 1, c.Score, "duplicate vote collapsed")
+		}
+	}
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/user/github_1/merge?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no target user")
+}
+
 func TestAdmin_DeleteUser(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -205,6 +316,394 @@ func TestAdmin_DeleteUser(t *testing.T) {
 	assert.True(t, cmntWithInfo.Comments[2].Deleted)
 }
 
+func TestAdmin_DeleteUserAnonymize(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{ID: "c1", Text: "test test #1", User: store.User{ID: "id1", Name: "name1"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	c2 := store.Comment{ID: "c2", Text: "test test #2", User: store.User{ID: "id2", Name: "name2"}, ParentID: "c1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	c3 := store.Comment{ID: "c3", Text: "test test #3", User: store.User{ID: "id1", Name: "name1"}, ParentID: "c2",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	for _, c := range []store.Comment{c1, c2, c3} {
+		_, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/user/id2?site=remark42&mode=bad", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "unknown mode rejected")
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/user/id2?site=remark42&mode=anonymize", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time&format=tree")
+	require.Equal(t, http.StatusOK, code)
+	tree := treeWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	assert.Equal(t, 3, tree.Info.Count, "count not changed")
+	require.Equal(t, 1, len(tree.Nodes))
+	require.Equal(t, 1, len(tree.Nodes[0].Replies), "thread structure kept")
+	anonymized := tree.Nodes[0].Replies[0].Comment
+	assert.Equal(t, "c2", anonymized.ID)
+	assert.Equal(t, "test test #2", anonymized.Text, "text kept")
+	assert.False(t, anonymized.Deleted)
+	assert.Equal(t, store.User{Name: "deleted user", ID: "deleted"}, anonymized.User)
+	assert.Equal(t, "name1", tree.Nodes[0].Comment.User.Name, "other user's comments untouched")
+	require.Equal(t, 1, len(tree.Nodes[0].Replies[0].Replies))
+	assert.Equal(t, "name1", tree.Nodes[0].Replies[0].Replies[0].Comment.User.Name)
+}
+
+func TestAdmin_CommentHistory(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.EditHistory = 5
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id, err := srv.DataService.Create(store.Comment{Text: "original text", User: store.User{ID: "id1", Name: "name1"},
+		Locator: locator})
+	require.NoError(t, err)
+	_, err = srv.DataService.EditComment(locator, id, service.EditRequest{Text: "edited text", Summary: "fix"})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet,
+		fmt.Sprintf("%s/api/v1/admin/history/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id), http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code := getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/history/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id))
+	require.Equal(t, http.StatusOK, code, body)
+	res := struct {
+		ID       string                 `json:"id"`
+		Versions []store.CommentVersion `json:"versions"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, id, res.ID)
+	require.Equal(t, 2, len(res.Versions))
+	assert.Equal(t, "original text", res.Versions[0].Text)
+	assert.Equal(t, "edited text", res.Versions[1].Text)
+	assert.Equal(t, "fix", res.Versions[1].Summary)
+
+	// public response shows the current version only
+	body, code = get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id))
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "history")
+	assert.NotContains(t, body, "original text")
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/history/bad-id?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAdmin_InspectToken(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.TokenValidator = token.ValidatorFunc(func(_ string, claims token.Claims) bool {
+			return claims.User.Audience != "" && claims.User.ID != "provider1_blocked"
+		})
+	})
+	defer teardown()
+
+	inspect := func(tkn, site string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/token/inspect?site="+site, http.NoBody)
+		require.NoError(t, err)
+		if tkn != "" {
+			req.Header.Set("X-Inspect-Token", tkn)
+		}
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := inspect(devToken, "remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, res["valid"])
+	assert.Nil(t, res["stage"])
+	assert.Equal(t, "remark42", res["aud"])
+	assert.Equal(t, "HS256", res["alg"])
+	assert.Equal(t, "current", res["key"])
+	assert.Equal(t, "2090-01-27T09:17:02Z", res["expires"])
+	assert.Equal(t, "ignore", res["secret_aud"])
+	assert.Equal(t, []interface{}{"decode: token decoded, signed with HS256", "parse: signature verified with current key",
+		`audience: issued for site "remark42"`, "user: provider1_dev (developer one)", "validator: accepted by auth validator",
+		"expiration: expires at 2090-01-27T09:17:02Z"}, res["steps"])
+	assert.Equal(t, "provider1_dev", res["claims"].(map[string]interface{})["user"].(map[string]interface{})["id"])
+
+	claims := token.Claims{
+		StandardClaims: jwt.StandardClaims{Id: "random id", Audience: "remark42", Issuer: "remark42",
+			ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+		User: &token.User{ID: "provider1_dev", Name: "developer one"},
+	}
+	expiredToken, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	code, res = inspect(expiredToken, "remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, res["valid"])
+	assert.Equal(t, "expiration", res["stage"])
+	assert.Contains(t, res["reason"], "token expired at")
+	assert.Len(t, res["steps"], 6, "stopped on expiration")
+
+	claims.Audience, claims.ExpiresAt = "other-site", time.Now().Add(time.Hour).Unix()
+	otherSiteToken, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	_, res = inspect(otherSiteToken, "remark42")
+	assert.Equal(t, false, res["valid"])
+	assert.Equal(t, "audience", res["stage"])
+	assert.Equal(t, `token issued for site "other-site", not "remark42"`, res["reason"])
+	assert.Equal(t, "other-site", res["aud"])
+
+	claims.Audience = "remark42"
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("wrong secret"))
+	require.NoError(t, err)
+	_, res = inspect(forged, "remark42")
+	assert.Equal(t, false, res["valid"])
+	assert.Equal(t, "parse", res["stage"])
+	assert.Contains(t, res["reason"], "signature is invalid")
+	assert.Nil(t, res["key"])
+
+	claims.User.ID = "provider1_blocked"
+	blockedToken, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	_, res = inspect(blockedToken, "remark42")
+	assert.Equal(t, false, res["valid"])
+	assert.Equal(t, "validator", res["stage"], "checked by validator of auth")
+
+	previewToken, _, err := srv.preview.issue("remark42", "https://radio-t.com/blah1", 0)
+	require.NoError(t, err)
+	_, res = inspect(previewToken, "")
+	assert.Equal(t, false, res["valid"])
+	assert.Equal(t, "user", res["stage"], "preview token is not a session token")
+
+	_, res = inspect("bad token", "remark42")
+	assert.Equal(t, "decode", res["stage"])
+	assert.Nil(t, res["claims"])
+
+	code, res = inspect("", "remark42")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, float64(rest.ErrInvalidToken), res["code"])
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/token/inspect?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("X-Inspect-Token", devToken)
+	requireAdminOnly(t, req)
+}
+
+func TestRest_ApplyClaimsUpd(t *testing.T) {
+	srv := Rest{}
+	claims := token.Claims{StandardClaims: jwt.StandardClaims{Audience: "remark42"},
+		User: &token.User{ID: "github_user", Name: "user", Attributes: map[string]interface{}{"a": "b"}}}
+	assert.Equal(t, claims, srv.ApplyClaimsUpd(claims), "claims as is without updater")
+
+	srv.ClaimsUpdater = token.ClaimsUpdFunc(func(c token.Claims) token.Claims {
+		c.User.Name = strings.ToUpper(c.User.Name)
+		c.User.SetBoolAttr("blocked", c.User.ID == "github_user")
+		delete(c.User.Attributes, "a")
+		c.ExpiresAt = 100
+		return c
+	})
+	res := srv.ApplyClaimsUpd(claims)
+	assert.Equal(t, "USER", res.User.Name)
+	assert.True(t, res.User.BoolAttr("blocked"))
+	assert.Equal(t, map[string]interface{}{"blocked": true}, res.User.Attributes)
+	assert.Equal(t, int64(100), res.ExpiresAt)
+
+	assert.Equal(t, "user", claims.User.Name, "original claims not changed")
+	assert.Equal(t, map[string]interface{}{"a": "b"}, claims.User.Attributes)
+	assert.Equal(t, int64(0), claims.ExpiresAt)
+}
+
+func TestAdmin_DryRunClaims(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.adminRest.claimsUpd = token.ClaimsUpdFunc(func(c token.Claims) token.Claims {
+		c.User.SetAdmin(c.Audience == "remark42" && c.User.ID == "provider1_dev")
+		c.User.Email = "dev@example.com"
+		return c
+	})
+
+	dryRun := func(site, body string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/claims/dry-run?site="+site, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := dryRun("remark42", `{"aud": "other", "user": {"id": "provider1_dev", "name": "developer one"}}`)
+	require.Equal(t, http.StatusOK, code)
+	before, after := res["before"].(map[string]interface{}), res["after"].(map[string]interface{})
+	assert.Equal(t, "remark42", before["aud"], "audience set to site")
+	assert.Equal(t, map[string]interface{}{"id": "provider1_dev", "name": "developer one", "picture": "", "aud": "remark42"},
+		before["user"])
+	assert.Equal(t, map[string]interface{}{"id": "provider1_dev", "name": "developer one", "picture": "", "aud": "remark42",
+		"email": "dev@example.com", "attrs": map[string]interface{}{"admin": true}}, after["user"])
+
+	code, res = dryRun("remark42", `{"aud": "remark42"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "claims without user", res["details"])
+	code, _ = dryRun("", `{"user": {"id": "provider1_dev"}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = dryRun("remark42", `{bad json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAdmin_ModLog(t *testing.T) {
+	modLog := &service.ModLog{Path: filepath.Join(t.TempDir(), "moderation.log")}
+	ts, srv, teardown := startupT(t, func(srv *Rest) { srv.ModLog = modLog })
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "c1", Locator: locator, User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "c2", Locator: locator, User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+
+	send := func(method, url, body string) {
+		req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode, url)
+	}
+	send(http.MethodDelete, "/api/v1/admin/comment/"+id1+"?site=remark42&url=https://radio-t.com/blah", "")
+	send(http.MethodPut, "/api/v1/admin/user/user1?site=remark42&block=1&ttl=10m", "")
+	send(http.MethodPut, "/api/v1/comment/"+id2+"?site=remark42&url=https://radio-t.com/blah",
+		`{"text":"edited", "reason":"offensive language"}`)
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	send(http.MethodPut, "/api/v1/admin/user/user1?site=remark42&block=0", "")
+
+	export := func(query string) (entries []service.ModLogEntry) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/modlog?site=remark42"+query, http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+		return entries
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/modlog?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	entries := export("")
+	require.Len(t, entries, 4)
+	moderator := "github_ef0f706a7"
+	assert.Equal(t, service.ModActionDelete, entries[0].Action)
+	assert.Equal(t, id1, entries[0].CommentID)
+	assert.Equal(t, moderator, entries[0].Moderator)
+	assert.Equal(t, service.ModActionBlock, entries[1].Action)
+	assert.Equal(t, "user1", entries[1].UserID)
+	assert.Equal(t, service.ModLogEntry{Time: entries[2].Time, SiteID: "remark42", Action: service.ModActionEdit, Moderator: moderator,
+		UserID: "user2", CommentID: id2, URL: "https://radio-t.com/blah", Reason: "offensive language"}, entries[2])
+	assert.Equal(t, service.ModActionUnblock, entries[3].Action)
+
+	entries = export(fmt.Sprintf("&from=%d", since.UnixMilli()))
+	require.Len(t, entries, 1, "filtered by from")
+	assert.Equal(t, service.ModActionUnblock, entries[0].Action)
+	entries = export(fmt.Sprintf("&to=%d", since.UnixMilli()))
+	assert.Len(t, entries, 3, "filtered by to")
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/modlog?site=remark42&from=bad", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	srv.adminRest.modLog = nil
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdmin_Lock(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	root, err := srv.DataService.Create(store.Comment{Text: "root", Locator: locator, User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err)
+	reply, err := srv.DataService.Create(store.Comment{Text: "reply", ParentID: root, Locator: locator,
+		User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err)
+
+	lock := func(val int) int {
+		req, err := http.NewRequest(http.MethodPut,
+			fmt.Sprintf("%s/api/v1/admin/lock/%s?site=remark42&url=https://radio-t.com/blah&lock=%d", ts.URL, root, val), http.NoBody)
+		require.NoError(t, err)
+		requireAdminOnly(t, req)
+		req.SetBasicAuth("admin", "password")
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	send := func(method, url, body string) (code int, res R.JSON) {
+		req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+	postReply := func() (code int, res R.JSON) {
+		return send(http.MethodPost, "/api/v1/comment",
+			`{"text": "new reply", "pid": "`+reply+`", "locator":{"url": "https://radio-t.com/blah", "site": "remark42"}}`)
+	}
+
+	assert.Equal(t, http.StatusOK, lock(1))
+
+	body, code := get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, root))
+	assert.Equal(t, http.StatusOK, code)
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	assert.True(t, c.Locked, "locked state visible")
+
+	code, res := postReply()
+	assert.Equal(t, http.StatusForbidden, code, "reply to locked thread rejected")
+	assert.Equal(t, float64(rest.ErrThreadLocked), res["code"])
+	assert.Equal(t, "thread locked", res["details"])
+
+	code, res = send(http.MethodPut, "/api/v1/vote/"+reply+"?site=remark42&url=https://radio-t.com/blah&vote=1", "")
+	assert.Equal(t, http.StatusForbidden, code, "vote in locked thread rejected")
+	assert.Equal(t, float64(rest.ErrThreadLocked), res["code"])
+
+	code, res = send(http.MethodPost, "/api/v1/comment",
+		`{"text": "new thread", "locator":{"url": "https://radio-t.com/blah", "site": "remark42"}}`)
+	assert.Equal(t, http.StatusCreated, code, "other threads not affected")
+	code, res = send(http.MethodPut, "/api/v1/comment/"+res["id"].(string)+"?site=remark42&url=https://radio-t.com/blah",
+		`{"text": "new thread edited"}`)
+	assert.Equal(t, http.StatusOK, code, res)
+
+	assert.Equal(t, http.StatusOK, lock(0))
+	code, res = postReply()
+	assert.Equal(t, http.StatusCreated, code, "reply allowed after unlock")
+	code, res = send(http.MethodPut, "/api/v1/comment/"+res["id"].(string)+"?site=remark42&url=https://radio-t.com/blah",
+		`{"text": "new reply edited"}`)
+	assert.Equal(t, http.StatusOK, code, res)
+	code, _ = send(http.MethodPut, "/api/v1/vote/"+reply+"?site=remark42&url=https://radio-t.com/blah&vote=1", "")
+	assert.Equal(t, http.StatusOK, code, "vote allowed after unlock")
+}
+
 func TestAdmin_Pin(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -241,6 +740,16 @@ func TestAdmin_Pin(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, cr.Pin)
 
+	// pinned comment listed first even for reverse time sort
+	addComment(t, store.Comment{Text: "test test #3", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+	body, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=-time")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Equal(t, 3, len(comments.Comments))
+	assert.Equal(t, id1, comments.Comments[0].ID)
+	assert.True(t, comments.Comments[0].Pin)
+
 	code = pin(-1)
 	assert.Equal(t, http.StatusOK, code)
 	body, code = get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
@@ -251,6 +760,56 @@ func TestAdmin_Pin(t *testing.T) {
 	assert.False(t, cr.Pin)
 }
 
+func TestAdmin_ModPin(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+
+	client := http.Client{}
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/pin/%s?site=remark42&url=https://radio-t.com/blah&pin=1&mod=1", ts.URL, id1), http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	req.SetBasicAuth("admin", "password")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Contains(t, string(body), `"mod":true`)
+
+	find := func(auth bool) commentsWithInfo {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=-time", http.NoBody)
+		require.NoError(t, err)
+		if auth {
+			req.SetBasicAuth("admin", "password")
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		comments := commentsWithInfo{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&comments))
+		require.Equal(t, 2, len(comments.Comments))
+		return comments
+	}
+
+	comments := find(false)
+	assert.NotEqual(t, id1, comments.Comments[0].ID, "public listing not reordered")
+	assert.Equal(t, id1, comments.Comments[1].ID)
+	assert.False(t, comments.Comments[1].Pin)
+
+	comments = find(true)
+	assert.Equal(t, id1, comments.Comments[0].ID, "pinned first for moderators")
+	assert.True(t, comments.Comments[0].Pin)
+	assert.True(t, comments.Comments[0].PinModOnly)
+}
+
 func TestAdmin_Block(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -364,6 +923,87 @@ func TestAdmin_Block(t *testing.T) {
 	assert.False(t, srv.adminRest.dataService.IsBlocked("remark42", "user2"))
 }
 
+func TestAdmin_ListComments(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.adminRest.flagScore = -1
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah-moderation"}
+	ts1, ts2, ts3 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []store.Comment{
+		{ID: "c1", Text: "comment 1", Timestamp: ts1, User: store.User{ID: "user1", Name: "user1 name"}},
+		{ID: "c2", Text: "comment 2", Timestamp: ts2, User: store.User{ID: "user2", Name: "user2 name"}},
+		{ID: "c3", Text: "comment 3", Timestamp: ts3, User: store.User{ID: "user1", Name: "user1 name"}},
+	} {
+		c.Locator = locator
+		_, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, srv.DataService.Delete(locator, "c3", store.SoftDelete))
+	_, err := srv.DataService.Vote(service.VoteReq{Locator: locator, CommentID: "c2", UserID: "user3", Val: false})
+	require.NoError(t, err)
+
+	list := func(query string) (ids []string, count int) {
+		time.Sleep(100 * time.Millisecond) // admin routes limited to 10 requests per second
+		res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/comments?site=remark42"+query)
+		require.Equal(t, http.StatusOK, code, res)
+		resp := struct {
+			Comments []store.Comment `json:"comments"`
+			Count    int             `json:"count"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(res), &resp))
+		ids = []string{}
+		for _, c := range resp.Comments {
+			ids = append(ids, c.ID)
+		}
+		return ids, resp.Count
+	}
+
+	tbl := []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"c3", "c2", "c1"}},
+		{"&status=published", []string{"c2", "c1"}},
+		{"&status=deleted", []string{"c3"}},
+		{"&status=flagged", []string{"c2"}},
+		{"&status=pending", []string{}},
+		{"&user=user1", []string{"c3", "c1"}},
+		{"&user=user1&status=published", []string{"c1"}},
+		{fmt.Sprintf("&from=%d", ts2.UnixMilli()), []string{"c3", "c2"}},
+		{fmt.Sprintf("&from=%d&to=%d", ts1.UnixMilli(), ts3.UnixMilli()), []string{"c2", "c1"}},
+		{fmt.Sprintf("&from=%d&to=%d&user=user2&status=flagged", ts1.UnixMilli(), ts3.UnixMilli()), []string{"c2"}},
+		{fmt.Sprintf("&to=%d&status=deleted", ts3.UnixMilli()), []string{}},
+	}
+	for _, tt := range tbl {
+		ids, count := list(tt.query)
+		assert.Equal(t, tt.ids, ids, tt.query)
+		assert.Equal(t, len(tt.ids), count, tt.query)
+	}
+
+	// paginated
+	ids, count := list("&limit=2")
+	assert.Equal(t, []string{"c3", "c2"}, ids)
+	assert.Equal(t, 3, count)
+	ids, count = list("&limit=2&skip=2")
+	assert.Equal(t, []string{"c1"}, ids)
+	assert.Equal(t, 3, count)
+
+	// bad filters rejected
+	for _, query := range []string{"&status=bad", "&from=yesterday", "&limit=-1"} {
+		time.Sleep(100 * time.Millisecond)
+		res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/comments?site=remark42"+query)
+		assert.Equal(t, http.StatusBadRequest, code, query+" "+res)
+	}
+
+	// non-moderators denied
+	res, code := getWithDevAuth(t, ts.URL+"/api/v1/admin/comments?site=remark42")
+	assert.Equal(t, http.StatusForbidden, code, res)
+	res, code = get(t, ts.URL+"/api/v1/admin/comments?site=remark42")
+	assert.Equal(t, http.StatusUnauthorized, code, res)
+}
+
 func TestAdmin_BlockedList(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -497,6 +1137,60 @@ func TestAdmin_ReadOnly(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, resp.StatusCode)
 }
 
+func TestAdmin_VotingFrozen(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	id := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+
+	setFrozen := func(frozen int, token string) int {
+		req, err := http.NewRequest(http.MethodPut,
+			fmt.Sprintf("%s/api/v1/admin/voting?site=remark42&frozen=%d", ts.URL, frozen), http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, token)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	vote := func(val int) (code int, body string) {
+		req, err := http.NewRequest(http.MethodPut,
+			fmt.Sprintf("%s/api/v1/vote/%s?site=remark42&url=https://radio-t.com/blah&vote=%d", ts.URL, id, val), http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, dev2Token)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(b)
+	}
+
+	code, _ := vote(1)
+	assert.Equal(t, http.StatusOK, code)
+
+	assert.Equal(t, http.StatusUnauthorized, setFrozen(1, ""), "non-admin user")
+	assert.Equal(t, http.StatusOK, setFrozen(1, adminUmputunToken))
+	assert.True(t, srv.DataService.IsVotingFrozen("remark42"))
+
+	code, body := vote(-1)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, body, `"code":26`)
+	assert.Contains(t, body, "voting frozen")
+
+	cfg, code := get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, cfg, `"voting_frozen":true`)
+
+	c, err := srv.DataService.Get(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score, "score kept intact")
+
+	assert.Equal(t, http.StatusOK, setFrozen(0, adminUmputunToken))
+	assert.False(t, srv.DataService.IsVotingFrozen("remark42"))
+	code, _ = vote(-1)
+	assert.Equal(t, http.StatusOK, code, "voting resumed")
+}
+
 func TestAdmin_ReadOnlyNoComments(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -832,6 +1526,44 @@ func TestAdmin_DeleteMeRequestFailed(t *testing.T) {
 	assert.True(t, strings.Contains(string(b), "can't use provided token"))
 }
 
+func TestAdmin_UserAllData(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	_, err := srv.DataService.Create(store.Comment{ID: "c1", Text: "test test #1", Locator: locator,
+		User: store.User{Name: "user1 name", ID: "user1", IP: "127.0.0.1"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{ID: "c2", Text: "test test #2", Locator: locator,
+		User: store.User{Name: "user2", ID: "user2"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.Vote(service.VoteReq{Locator: locator, CommentID: "c2", UserID: "user1", Val: false})
+	require.NoError(t, err)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/userdata/user1?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	ungzReader, err := gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	data, err := io.ReadAll(ungzReader)
+	require.NoError(t, err)
+
+	parsed := struct {
+		Info     store.User         `json:"info"`
+		Comments []store.Comment    `json:"comments"`
+		Votes    []service.UserVote `json:"votes"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &parsed), string(data))
+	assert.Equal(t, store.User{Name: "user1 name", ID: "user1", SiteID: "remark42"}, parsed.Info, "user info without ip")
+	require.Equal(t, 1, len(parsed.Comments))
+	assert.Equal(t, "test test #1", parsed.Comments[0].Text)
+	assert.Equal(t, []service.UserVote{{Locator: locator, CommentID: "c2", Value: false}}, parsed.Votes)
+	assert.NotContains(t, string(data), "test test #2")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/userdata/user1?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+}
+
 func TestAdmin_GetUserInfo(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -861,3 +1593,117 @@ func TestAdmin_GetUserInfo(t *testing.T) {
 	_, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/user/userX?site=remark42&url=https://radio-t.com/blah", ts.URL))
 	assert.Equal(t, http.StatusBadRequest, code, "no info about user")
 }
+
+func TestAdmin_KeyRotation(t *testing.T) {
+	ts, _, teardown := startupT(t, func(srv *Rest) {
+		kr, err := adminstore.NewKeyRotator(adminstore.NewStaticKeyStore("secret"), false,
+			filepath.Join(t.TempDir(), "keys.db"), bolt.Options{})
+		require.NoError(t, err)
+		t.Cleanup(func() { assert.NoError(t, kr.Close()) })
+		srv.KeyRotator, srv.KeyGrace = kr, time.Hour
+		srv.Authenticator = auth.NewService(auth.Opts{
+			AdminPasswd:  "password",
+			SecretReader: token.SecretFunc(kr.Key),
+			AvatarStore:  avatar.NewLocalFS(t.TempDir()),
+		})
+		srv.Authenticator.AddDirectProvider("provider1", provider.CredCheckerFunc(func(_, _ string) (bool, error) {
+			return true, nil
+		}))
+	})
+	defer teardown()
+
+	userInfo := func(tkn string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/user?site=remark42", http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+	keyAction := func(method, action string) (st adminstore.KeyStatus, code int) {
+		req, err := http.NewRequest(method, ts.URL+"/api/v1/admin/key"+action, http.NoBody)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		}
+		return st, resp.StatusCode
+	}
+
+	resp, _ := userInfo(devToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	st, code := keyAction("GET", "?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, st.Rotated)
+
+	_, code = keyAction("PUT", "/rotate?site=remark42&grace=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+	st, code = keyAction("PUT", "/rotate?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, st.Rotated)
+	assert.True(t, st.Overlap)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), st.GraceUntil, time.Minute, "default grace used")
+
+	// token signed with the old key verified during the grace period and reissued with the new one
+	resp, body := userInfo(devToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"id":"provider1_dev"`)
+	var reissued string
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			reissued = c.Value
+		}
+	}
+	require.NotEmpty(t, reissued)
+	assert.NotEqual(t, devToken, reissued)
+	resp, _ = userInfo(reissued)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	for _, c := range resp.Cookies() {
+		assert.NotEqual(t, "JWT", c.Name, "token signed with the current key not reissued")
+	}
+
+	// token signed with unknown key rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &token.Claims{StandardClaims: jwt.StandardClaims{Audience: "remark42",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(), Issuer: "remark42"}, User: &token.User{ID: "provider1_dev", Name: "dev"}})
+	forgedTkn, err := forged.SignedString([]byte("other secret"))
+	require.NoError(t, err)
+	resp, _ = userInfo(forgedTkn)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// promoted, old key not valid anymore
+	st, code = keyAction("PUT", "/promote?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, st.Rotated)
+	assert.False(t, st.Overlap)
+	resp, _ = userInfo(devToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = userInfo(reissued)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// rotation without grace period
+	st, code = keyAction("PUT", "/rotate?site=remark42&grace=0s")
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, st.Overlap)
+	resp, _ = userInfo(reissued)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAdmin_KeyRotationDisabled(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	for _, r := range []struct{ method, path string }{{"GET", ""}, {"PUT", "/rotate"}, {"PUT", "/promote"}} {
+		req, err := http.NewRequest(r.method, ts.URL+"/api/v1/admin/key"+r.path+"?site=remark42", http.NoBody)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, r.path)
+		require.NoError(t, resp.Body.Close())
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// BreakerParams defines global circuit breaker of comment creation, tripped when comments posted to all sites
+// come faster than the limit, sheds the load rejecting new comments till the end of cool-down
+type BreakerParams struct {
+	Limit    int           // max comments created within Window on all sites, breaker disabled if 0
+	Window   time.Duration // period of Limit, 1m if not set
+	Cooldown time.Duration // how long new comments rejected after the breaker tripped, 5m if not set
+}
+
+const (
+	breakerDefaultWindow   = time.Minute
+	breakerDefaultCooldown = 5 * time.Minute
+)
+
+// createBreaker counts comment creation requests of all sites in a sliding window and rejects them for
+// cool-down once the limit exceeded. Counted requests dropped on trip, so the breaker recovers after cool-down.
+type createBreaker struct {
+	params       BreakerParams
+	lock         sync.Mutex
+	hits         []time.Time // times of requests within the window, oldest first
+	trippedUntil time.Time
+}
+
+// newCreateBreaker makes breaker with defaults applied, nil if disabled
+func newCreateBreaker(params BreakerParams) *createBreaker {
+	if params.Limit <= 0 {
+		return nil
+	}
+	if params.Window <= 0 {
+		params.Window = breakerDefaultWindow
+	}
+	if params.Cooldown <= 0 {
+		params.Cooldown = breakerDefaultCooldown
+	}
+	return &createBreaker{params: params}
+}
+
+// hit counts request made at the given time, returns time left till the end of cool-down if the breaker tripped.
+// Requests rejected during cool-down are not counted.
+func (b *createBreaker) hit(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if now.Before(b.trippedUntil) {
+		return b.trippedUntil.Sub(now)
+	}
+
+	from, i := now.Add(-b.params.Window), 0
+	for i < len(b.hits) && !b.hits[i].After(from) {
+		i++
+	}
+	b.hits = append(b.hits[i:], now)
+	if len(b.hits) <= b.params.Limit {
+		return 0
+	}
+
+	b.hits, b.trippedUntil = nil, now.Add(b.params.Cooldown)
+	log.Printf("[WARN] comment creation suspended for %v, more than %d comments in %v", b.params.Cooldown,
+		b.params.Limit, b.params.Window)
+	return b.params.Cooldown
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateBreaker_Hit(t *testing.T) {
+	b := newCreateBreaker(BreakerParams{Limit: 3, Window: time.Minute, Cooldown: 5 * time.Minute})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), b.hit(now.Add(time.Duration(i)*time.Second)), "hit %d within the limit", i)
+	}
+	assert.Equal(t, time.Duration(0), b.hit(now.Add(time.Minute)), "the first hit out of the window")
+	assert.Equal(t, 5*time.Minute, b.hit(now.Add(time.Minute)), "tripped over the limit")
+	assert.Equal(t, 4*time.Minute, b.hit(now.Add(2*time.Minute)), "rejected during cool-down")
+
+	recovered := now.Add(6 * time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), b.hit(recovered.Add(time.Duration(i)*time.Second)), "hit %d after cool-down", i)
+	}
+	assert.Equal(t, 5*time.Minute, b.hit(recovered.Add(3*time.Second)), "tripped again")
+}
+
+func TestCreateBreaker_Disabled(t *testing.T) {
+	b := newCreateBreaker(BreakerParams{})
+	assert.Nil(t, b)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, time.Duration(0), b.hit(time.Now()))
+	}
+
+	b = newCreateBreaker(BreakerParams{Limit: 1})
+	assert.Equal(t, BreakerParams{Limit: 1, Window: breakerDefaultWindow, Cooldown: breakerDefaultCooldown}, b.params)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/token"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// ApplyClaimsUpd runs configured ClaimsUpdater on the copy of claims and returns the result, the same claims
+// the issued token would have. Nothing is issued, made for inspection of the updater. Claims returned
+// as is if ClaimsUpdater not set.
+func (s *Rest) ApplyClaimsUpd(claims token.Claims) token.Claims {
+	return applyClaimsUpd(s.ClaimsUpdater, claims)
+}
+
+func applyClaimsUpd(upd token.ClaimsUpdater, claims token.Claims) token.Claims {
+	res := cloneClaims(claims)
+	if upd == nil {
+		return res
+	}
+	return upd.Update(res)
+}
+
+// cloneClaims makes a copy of claims with the user and its attributes copied, updaters change the user in place
+func cloneClaims(claims token.Claims) token.Claims {
+	if claims.User == nil {
+		return claims
+	}
+	user := *claims.User
+	if claims.User.Attributes != nil {
+		user.Attributes = make(map[string]interface{}, len(claims.User.Attributes))
+		for k, v := range claims.User.Attributes {
+			user.Attributes[k] = v
+		}
+	}
+	claims.User = &user
+	return claims
+}
+
+// POST /claims/dry-run?site=siteID - apply claims updater to claims passed in the body, for the site
+// set as claims audience. Responds with claims before and after the update, no token issued
+func (a *admin) dryRunClaimsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	if siteID == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("no site"), "site not set", rest.ErrSiteNotFound)
+		return
+	}
+	claims := token.Claims{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind claims", rest.ErrDecode)
+		return
+	}
+	if claims.User == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("no user"), "claims without user", rest.ErrDecode)
+		return
+	}
+	claims.Audience, claims.User.Audience = siteID, siteID
+
+	render.JSON(w, r, struct {
+		Before token.Claims `json:"before"`
+		After  token.Claims `json:"after"`
+	}{Before: claims, After: applyClaimsUpd(a.claimsUpd, claims)})
+}
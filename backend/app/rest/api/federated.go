@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// federatedVerifier verifies tokens issued by trusted third-party issuers
+type federatedVerifier interface {
+	User(ctx context.Context, raw string) (token.User, error)
+}
+
+// federatedAuth wraps auth middleware to accept tokens of federated issuers passed as "Authorization: Bearer <token>".
+// Requests without bearer token handled by the wrapped middleware. Request with invalid bearer token rejected
+// if auth required, otherwise it proceeds without user.
+func (s *Rest) federatedAuth(mdw func(http.Handler) http.Handler, reqAuth bool) func(http.Handler) http.Handler {
+	if s.Federated == nil {
+		return mdw
+	}
+	return func(next http.Handler) http.Handler {
+		regular := mdw(next)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				regular.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := s.Federated.User(r.Context(), strings.TrimSpace(raw))
+			if err == nil && s.AudNormalizer != nil {
+				user.Audience = s.AudNormalizer(user.Audience)
+			}
+			if err == nil && s.DataService.IsBlocked(user.Audience, user.ID) {
+				err = fmt.Errorf("user %s blocked on %s", user.ID, user.Audience)
+			}
+			if err != nil {
+				log.Printf("[DEBUG] federated auth failed, %v", err)
+				if reqAuth {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user.SetAdmin(s.DataService.IsAdmin(user.Audience, user.ID))
+			rest.SetEmailVerified(&user)
+			next.ServeHTTP(w, token.SetUserInfo(r, user))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRest_FederatedAuth(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.Federated = mockFederated{
+			"issuer1-token": {ID: "federated_user1", Name: "user one", Audience: "remark42"},
+			"issuer2-token": {ID: "federated_user2", Name: "user two", Audience: "remark42"},
+			"other-token":   {ID: "federated_user3", Name: "user three", Audience: "other-site"},
+		}
+	})
+	defer teardown()
+
+	send := func(method, url, bearer, body string) (code int, resp string) {
+		req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		r, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, r.Body.Close())
+		return r.StatusCode, string(b)
+	}
+
+	code, body := send(http.MethodGet, "/api/v1/user?site=remark42", "issuer1-token", "")
+	require.Equal(t, http.StatusOK, code, body)
+	user := token.User{}
+	require.NoError(t, json.Unmarshal([]byte(body), &user))
+	assert.Equal(t, "federated_user1", user.ID)
+	assert.Equal(t, "user one", user.Name)
+
+	code, body = send(http.MethodPost, "/api/v1/comment", "issuer2-token",
+		`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	require.Equal(t, http.StatusCreated, code, body)
+	assert.Contains(t, body, `"id":"federated_user2"`)
+
+	code, body = send(http.MethodGet, "/api/v1/user?site=remark42", "unknown-issuer-token", "")
+	assert.Equal(t, http.StatusUnauthorized, code, body)
+	code, body = send(http.MethodGet, "/api/v1/user?site=remark42", "other-token", "")
+	assert.Equal(t, http.StatusForbidden, code, body, "token of other site")
+
+	// open routes proceed without user on invalid token
+	code, body = send(http.MethodGet, "/api/v1/config?site=remark42", "unknown-issuer-token", "")
+	assert.Equal(t, http.StatusOK, code, body)
+
+	// regular tokens still accepted
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42")
+	assert.Equal(t, http.StatusOK, code, body)
+
+	require.NoError(t, srv.DataService.SetBlock("remark42", "federated_user1", true, 0))
+	code, body = send(http.MethodGet, "/api/v1/user?site=remark42", "issuer1-token", "")
+	assert.Equal(t, http.StatusUnauthorized, code, body, "blocked user")
+}
+
+// mockFederated verifies tokens from the list only
+type mockFederated map[string]token.User
+
+func (m mockFederated) User(_ context.Context, raw string) (token.User, error) {
+	if u, ok := m[raw]; ok {
+		return u, nil
+	}
+	return token.User{}, errors.New("unknown issuer")
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// AllSitesHoneypot is the HoneypotFields key for the field used on all sites without own field
+const AllSitesHoneypot = "*"
+
+// honeypot is a hidden field of anonymous comment form per site, left empty by people and filled by naive bots
+type honeypot map[string]string
+
+// field returns honeypot field name for the site, empty if not set
+func (h honeypot) field(siteID string) string {
+	if f, ok := h[siteID]; ok {
+		return f
+	}
+	return h[AllSitesHoneypot]
+}
+
+// filled checks if honeypot field of the site set to non-empty value in the posted json body
+func (h honeypot) filled(siteID string, body []byte) bool {
+	field := h.field(siteID)
+	if field == "" {
+		return false
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	switch v := fields[field].(type) {
+	case nil:
+		return false
+	case string:
+		return strings.TrimSpace(v) != ""
+	case bool:
+		return v
+	default:
+		return true
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoneypot(t *testing.T) {
+	h := honeypot{"site1": "website", AllSitesHoneypot: "url"}
+	assert.Equal(t, "website", h.field("site1"))
+	assert.Equal(t, "url", h.field("site2"), "field for all sites")
+	assert.Equal(t, "", honeypot(nil).field("site1"))
+
+	tbl := []struct {
+		site, body string
+		filled     bool
+	}{
+		{"site1", `{"text": "hi", "website": "https://example.com"}`, true},
+		{"site1", `{"text": "hi", "website": 1}`, true},
+		{"site1", `{"text": "hi", "website": true}`, true},
+		{"site1", `{"text": "hi", "website": false}`, false},
+		{"site1", `{"text": "hi", "website": " "}`, false},
+		{"site1", `{"text": "hi", "website": null}`, false},
+		{"site1", `{"text": "hi"}`, false},
+		{"site1", `{"text": "hi", "url": "https://example.com"}`, false},
+		{"site2", `{"text": "hi", "url": "https://example.com"}`, true},
+		{"site1", `bad json`, false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.filled, h.filled(tt.site, []byte(tt.body)), "%s: %s", tt.site, tt.body)
+	}
+	assert.False(t, honeypot(nil).filled("site1", []byte(`{"website": "x"}`)), "disabled")
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// LiveParams defines live updates of posts, new comments streamed to readers with server-sent events
+type LiveParams struct {
+	Enabled        bool
+	MaxConnections int           // max number of connected readers, unlimited if 0
+	MaxPerIP       int           // max number of connections from the same ip, unlimited if 0
+	Timeout        time.Duration // max duration of connection, reader reconnects after it, unlimited if 0
+	Heartbeat      time.Duration // interval of keep-alive comments detecting gone readers, 30s by default
+}
+
+const (
+	liveBufferSize       = 16 // new comments queued per connection, slow reader disconnected on overflow
+	liveDefaultHeartbeat = 30 * time.Second
+)
+
+var (
+	errLiveMaxConnections = errors.New("too many live connections")
+	errLiveMaxPerIP       = errors.New("too many live connections from the same ip")
+)
+
+// liveHub is in-memory pub/sub delivering new comments to readers subscribed to the post
+type liveHub struct {
+	params LiveParams
+
+	lock   sync.Mutex
+	subs   map[store.Locator]map[*liveSub]struct{}
+	perIP  map[string]int
+	total  int
+	closed bool
+}
+
+// liveSub is a single reader subscribed to the post, ch closed on unsubscribe, overflow or hub close
+type liveSub struct {
+	ch chan store.Comment
+	ip string
+}
+
+func newLiveHub(params LiveParams) *liveHub {
+	if params.Heartbeat <= 0 {
+		params.Heartbeat = liveDefaultHeartbeat
+	}
+	return &liveHub{params: params, subs: map[store.Locator]map[*liveSub]struct{}{}, perIP: map[string]int{}}
+}
+
+// subscribe adds reader with the ip to the post, rejects it if connection limits reached
+func (h *liveHub) subscribe(locator store.Locator, ip string) (*liveSub, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return nil, errors.New("live updates closed")
+	}
+	if h.params.MaxConnections > 0 && h.total >= h.params.MaxConnections {
+		return nil, errLiveMaxConnections
+	}
+	if h.params.MaxPerIP > 0 && h.perIP[ip] >= h.params.MaxPerIP {
+		return nil, errLiveMaxPerIP
+	}
+
+	sub := &liveSub{ch: make(chan store.Comment, liveBufferSize), ip: ip}
+	if h.subs[locator] == nil {
+		h.subs[locator] = map[*liveSub]struct{}{}
+	}
+	h.subs[locator][sub] = struct{}{}
+	h.perIP[ip]++
+	h.total++
+	return sub, nil
+}
+
+// unsubscribe removes reader from the post, does nothing if already removed
+func (h *liveHub) unsubscribe(locator store.Locator, sub *liveSub) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.remove(locator, sub)
+}
+
+// subscribed checks if the post has any readers, to skip preparation of comments nobody waits for
+func (h *liveHub) subscribed(locator store.Locator) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subs[locator]) > 0
+}
+
+// publish sends comment to readers of its post without blocking, readers not keeping up are disconnected
+func (h *liveHub) publish(comment store.Comment) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for sub := range h.subs[comment.Locator] {
+		select {
+		case sub.ch <- comment:
+		default:
+			log.Printf("[DEBUG] live reader of %s from %s disconnected, too slow", comment.Locator.URL, sub.ip)
+			h.remove(comment.Locator, sub)
+		}
+	}
+}
+
+// close disconnects all readers and rejects new ones, called on shutdown
+func (h *liveHub) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.closed = true
+	for locator, subs := range h.subs {
+		for sub := range subs {
+			h.remove(locator, sub)
+		}
+	}
+}
+
+// remove deletes reader and closes its channel, should be called under lock
+func (h *liveHub) remove(locator store.Locator, sub *liveSub) {
+	if _, ok := h.subs[locator][sub]; !ok {
+		return
+	}
+	delete(h.subs[locator], sub)
+	if len(h.subs[locator]) == 0 {
+		delete(h.subs, locator)
+	}
+	if h.perIP[sub.ip]--; h.perIP[sub.ip] <= 0 {
+		delete(h.perIP, sub.ip)
+	}
+	h.total--
+	close(sub.ch)
+}
+
+// GET /stream?site=siteID&url=post-url - streams new comments of the post as server-sent events,
+// each one as "comment" event with the comment in data. Stream ends on timeout or if reader doesn't keep up,
+// reader expected to reconnect, as EventSource does by default.
+func (s *public) liveCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if locator.SiteID == "" || locator.URL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing site or url"), "can't stream comments", rest.ErrDecode)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, errors.New("streaming not supported"), "can't stream comments", rest.ErrInternal)
+		return
+	}
+
+	ip := strings.Split(r.RemoteAddr, ":")[0]
+	sub, err := s.live.subscribe(locator, ip)
+	if err != nil {
+		code := http.StatusServiceUnavailable
+		if errors.Is(err, errLiveMaxPerIP) {
+			code = http.StatusTooManyRequests
+		}
+		rest.SendErrorJSON(w, r, code, err, "can't stream comments", rest.ErrActionRejected)
+		return
+	}
+	defer s.live.unsubscribe(locator, sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering by nginx
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	var timeout <-chan time.Time
+	if s.live.params.Timeout > 0 {
+		timer := time.NewTimer(s.live.params.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	heartbeat := time.NewTicker(s.live.params.Heartbeat)
+	defer heartbeat.Stop()
+
+	user := rest.GetUserOrEmpty(r)
+	for {
+		select {
+		case <-r.Context().Done(): // reader disconnected
+			return
+		case <-timeout:
+			return
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case comment, ok := <-sub.ch:
+			if !ok { // dropped by hub
+				return
+			}
+			comment = s.userFields.comments([]store.Comment{comment}, user)[0]
+			data, e := json.Marshal(comment)
+			if e != nil {
+				log.Printf("[WARN] can't marshal live comment %s, %v", comment.ID, e)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "id: %s\nevent: comment\ndata: %s\n\n", comment.ID, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// publishLive sends created comment to live readers of its post, the same way as seen by anonymous reader
+func (s *private) publishLive(comment store.Comment) {
+	if s.live == nil || !s.live.subscribed(comment.Locator) {
+		return
+	}
+	c, err := s.dataService.Get(comment.Locator, comment.ID, store.User{})
+	if err != nil {
+		log.Printf("[WARN] can't load comment %s for live readers, %v", comment.ID, err)
+		return
+	}
+	s.live.publish(c)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRest_LiveStream(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.Live = LiveParams{Enabled: true, MaxConnections: 10, MaxPerIP: 1}
+	})
+	defer teardown()
+
+	resp, err := http.Get(ts.URL + "/api/v1/stream?site=remark42&url=https://radio-t.com/blah-live")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	rd := bufio.NewReader(resp.Body)
+	assert.Equal(t, ": connected", readEvent(t, rd)[0])
+
+	// second connection from the same ip rejected
+	resp2, err := http.Get(ts.URL + "/api/v1/stream?site=remark42&url=https://radio-t.com/blah-other")
+	require.NoError(t, err)
+	require.NoError(t, resp2.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, resp2.StatusCode)
+
+	// comment to other post not streamed
+	addComment(t, store.Comment{Text: "other post", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah-other"}}, ts)
+	id := addComment(t, store.Comment{Text: "live comment", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah-live"}}, ts)
+
+	event := readEvent(t, rd)
+	require.Len(t, event, 3, event)
+	assert.Equal(t, "id: "+id, event[0])
+	assert.Equal(t, "event: comment", event[1])
+	require.True(t, strings.HasPrefix(event[2], "data: "), event[2])
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(event[2], "data: ")), &c))
+	assert.Equal(t, id, c.ID)
+	assert.Equal(t, "<p>live comment</p>\n", c.Text)
+	assert.Equal(t, "https://radio-t.com/blah-live", c.Locator.URL)
+	assert.Empty(t, c.User.IP, "comment as seen by anonymous reader")
+
+	// disconnected reader unsubscribed
+	require.NoError(t, resp.Body.Close())
+	assert.Eventually(t, func() bool {
+		return !srv.live.subscribed(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah-live"})
+	}, time.Second, 10*time.Millisecond)
+
+	resp, err = http.Get(ts.URL + "/api/v1/stream?site=remark42")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "url required")
+}
+
+func TestRest_LiveStreamTimeout(t *testing.T) {
+	ts, _, teardown := startupT(t, func(srv *Rest) {
+		srv.Live = LiveParams{Enabled: true, Timeout: 200 * time.Millisecond, Heartbeat: 50 * time.Millisecond}
+	})
+	defer teardown()
+
+	resp, err := http.Get(ts.URL + "/api/v1/stream?site=remark42&url=https://radio-t.com/blah-live")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	st := time.Now()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.True(t, time.Since(st) < time.Second, "stream ended on timeout")
+	assert.Contains(t, string(body), ": connected\n\n")
+	assert.Contains(t, string(body), ": heartbeat\n\n")
+}
+
+func TestRest_LiveStreamDisabled(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	resp, err := http.Get(ts.URL + "/api/v1/stream?site=remark42&url=https://radio-t.com/blah-live")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestLiveHub(t *testing.T) {
+	h := newLiveHub(LiveParams{MaxConnections: 2})
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+
+	slow, err := h.subscribe(locator, "127.0.0.1")
+	require.NoError(t, err)
+	fast, err := h.subscribe(locator, "127.0.0.1")
+	require.NoError(t, err)
+	_, err = h.subscribe(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/other"}, "127.0.0.2")
+	assert.ErrorIs(t, err, errLiveMaxConnections)
+
+	// slow reader dropped on overflow, fast one keeps receiving
+	for i := 0; i <= liveBufferSize; i++ {
+		h.publish(store.Comment{ID: fmt.Sprintf("id-%d", i), Locator: locator})
+		<-fast.ch
+	}
+	for i := 0; i < liveBufferSize; i++ {
+		c := <-slow.ch
+		assert.Equal(t, fmt.Sprintf("id-%d", i), c.ID)
+	}
+	_, ok := <-slow.ch
+	assert.False(t, ok, "slow reader dropped")
+	h.unsubscribe(locator, slow) // no-op for dropped reader
+	assert.Equal(t, 1, h.total)
+	assert.Equal(t, map[string]int{"127.0.0.1": 1}, h.perIP)
+
+	h.close()
+	_, ok = <-fast.ch
+	assert.False(t, ok, "readers disconnected on close")
+	assert.False(t, h.subscribed(locator))
+	_, err = h.subscribe(locator, "127.0.0.1")
+	assert.Error(t, err)
+}
+
+// readEvent reads lines of the next server-sent event
+func readEvent(t *testing.T, rd *bufio.Reader) (lines []string) {
+	for {
+		line, err := rd.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// AllSitesStrictOrigin is the StrictOrigin element requiring matching origin on all sites
+const AllSitesStrictOrigin = "*"
+
+// originPolicy checks Origin of comments posted to sites with strict origin. Comment accepted from the host
+// of the post, remark42 own origin and origins allowed for the site.
+type originPolicy struct {
+	sites   []string                         // sites with strict origin, AllSitesStrictOrigin for all
+	allowed func(siteID, origin string) bool // origins accepted regardless of the post's host
+}
+
+// check returns error if the site has strict origin and the request's Origin doesn't match the post
+func (p originPolicy) check(r *http.Request, locator store.Locator) error {
+	if !p.strict(locator.SiteID) {
+		return nil
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		return fmt.Errorf("no origin of comment to %s", locator.URL)
+	}
+	o, err := url.Parse(origin)
+	if err != nil || o.Host == "" {
+		return fmt.Errorf("bad origin %q", origin)
+	}
+	if post, e := url.Parse(locator.URL); e == nil && strings.EqualFold(post.Host, o.Host) {
+		return nil
+	}
+	if p.allowed != nil && p.allowed(locator.SiteID, origin) {
+		return nil
+	}
+	return fmt.Errorf("origin %s doesn't match post %s", origin, locator.URL)
+}
+
+// strict checks if the site accepts comments only from origin matching the post
+func (p originPolicy) strict(siteID string) bool {
+	for _, s := range p.sites {
+		if s == siteID || s == AllSitesStrictOrigin {
+			return true
+		}
+	}
+	return false
+}
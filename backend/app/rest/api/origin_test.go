@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestOriginPolicy_Check(t *testing.T) {
+	p := originPolicy{sites: []string{"radio-t"}, allowed: func(siteID, origin string) bool {
+		return siteID == "radio-t" && origin == "https://remark42.radio-t.com"
+	}}
+	locator := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/2024/01/01/podcast-1/"}
+
+	tbl := []struct {
+		origin  string
+		locator store.Locator
+		ok      bool
+	}{
+		{"https://radio-t.com", locator, true},
+		{"https://RADIO-T.com", locator, true},
+		{"https://remark42.radio-t.com", locator, true},
+		{"http://radio-t.com:8080", locator, false},
+		{"https://example.com", locator, false},
+		{"", locator, false},
+		{"null", locator, false},
+		{"https://example.com", store.Locator{SiteID: "other", URL: "https://other.com/blah"}, true},
+	}
+
+	for i, tt := range tbl {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/comment", http.NoBody)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		err := p.check(r, tt.locator)
+		assert.Equal(t, tt.ok, err == nil, "case #%d %s, %v", i, tt.origin, err)
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// PreviewParams defines unpublished pages, comments of them accessible to admins and holders of preview token only
+type PreviewParams struct {
+	URLs []string      // url prefixes of unpublished pages, like https://staging.example.com/
+	TTL  time.Duration // default lifetime of preview token, 24h if not set
+}
+
+const (
+	previewAudPrefix  = "preview:" // audience of preview token is the site id with this prefix
+	previewDefaultTTL = 24 * time.Hour
+	previewHeader     = "X-Preview-Token"
+	previewQueryParam = "preview"
+)
+
+// TrimPreviewAud returns site id of preview token audience, other audiences returned as is.
+// Preview tokens signed with the key of their site.
+func TrimPreviewAud(aud string) string {
+	return strings.TrimPrefix(aud, previewAudPrefix)
+}
+
+// previewGuard issues preview tokens and checks them on access to comments of unpublished pages.
+// Tokens made by auth token service with audience of the site scoped to preview, and the page url as subject,
+// so they are not accepted as user tokens and user tokens are not accepted as preview ones.
+type previewGuard struct {
+	params        PreviewParams
+	tokens        *token.Service
+	audNormalizer func(string) string // optional, normalizes site id
+}
+
+// restricted checks if url is one of unpublished pages
+func (p previewGuard) restricted(url string) bool {
+	return hasAnyPrefix(url, p.params.URLs)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// issue makes preview token for the page of the site, default ttl used if ttl is 0
+func (p previewGuard) issue(siteID, url string, ttl time.Duration) (tkn string, expires time.Time, err error) {
+	if ttl == 0 {
+		ttl = p.params.TTL
+	}
+	if ttl == 0 {
+		ttl = previewDefaultTTL
+	}
+	expires = time.Now().Add(ttl).Truncate(time.Second)
+	claims := token.Claims{StandardClaims: jwt.StandardClaims{
+		Id:        uuid.New().String(),
+		Audience:  previewAudPrefix + p.siteID(siteID),
+		Subject:   url,
+		Issuer:    "remark42",
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: expires.Unix(),
+	}}
+	if tkn, err = p.tokens.Token(claims); err != nil {
+		return "", time.Time{}, fmt.Errorf("can't make preview token: %w", err)
+	}
+	return tkn, expires, nil
+}
+
+// check verifies access to comments of the page. Pages other than unpublished, as well as admins, allowed
+// without token. Token passed in X-Preview-Token header or preview query parameter.
+func (p previewGuard) check(r *http.Request, locator store.Locator) error {
+	if !p.restricted(locator.URL) {
+		return nil
+	}
+	if user, err := rest.GetUserInfo(r); err == nil && user.Admin {
+		return nil
+	}
+	tkn := r.Header.Get(previewHeader)
+	if tkn == "" {
+		tkn = r.URL.Query().Get(previewQueryParam)
+	}
+	if tkn == "" {
+		return fmt.Errorf("no preview token for %s", locator.URL)
+	}
+	claims, err := p.tokens.Parse(tkn)
+	if err != nil {
+		return fmt.Errorf("invalid preview token: %w", err)
+	}
+	switch {
+	case p.tokens.IsExpired(claims):
+		return fmt.Errorf("preview token expired")
+	case claims.Audience != previewAudPrefix+p.siteID(locator.SiteID):
+		return fmt.Errorf("preview token not for site %s", locator.SiteID)
+	case claims.Subject != locator.URL:
+		return fmt.Errorf("preview token not for %s", locator.URL)
+	}
+	return nil
+}
+
+// excluded returns url prefixes of unpublished pages hidden from the user in lists of comments and posts
+// across pages of the site, nil for admins. Preview token grants access to the single page and doesn't open
+// unpublished pages in such lists.
+func (p previewGuard) excluded(r *http.Request) []string {
+	if len(p.params.URLs) == 0 {
+		return nil
+	}
+	if user, err := rest.GetUserInfo(r); err == nil && user.Admin {
+		return nil
+	}
+	return p.params.URLs
+}
+
+// comments filters out comments of unpublished pages hidden from the user
+func (p previewGuard) comments(r *http.Request, comments []store.Comment) []store.Comment {
+	excluded := p.excluded(r)
+	if len(excluded) == 0 {
+		return comments
+	}
+	return filterComments(comments, func(c store.Comment) bool { return !hasAnyPrefix(c.Locator.URL, excluded) })
+}
+
+// posts filters out unpublished pages hidden from the user
+func (p previewGuard) posts(r *http.Request, posts []store.PostInfo) []store.PostInfo {
+	excluded := p.excluded(r)
+	if len(excluded) == 0 {
+		return posts
+	}
+	res := make([]store.PostInfo, 0, len(posts))
+	for _, post := range posts {
+		if !hasAnyPrefix(post.URL, excluded) {
+			res = append(res, post)
+		}
+	}
+	return res
+}
+
+// handler is a middleware checking access to comments of the page set by site and url query parameters
+func (p previewGuard) handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+		if err := p.check(r, locator); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, err, "preview token required", rest.ErrPreviewDenied)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (p previewGuard) siteID(siteID string) string {
+	if p.audNormalizer != nil {
+		return p.audNormalizer(siteID)
+	}
+	return siteID
+}
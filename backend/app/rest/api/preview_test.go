@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	R "github.com/go-pkgz/rest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRest_PreviewToken(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.Preview.URLs = []string{"https://staging.radio-t.com/"}
+	})
+	defer teardown()
+
+	const page = "https://staging.radio-t.com/p1"
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/preview?site=remark42&ttl=1h&url="+url.QueryEscape(page), http.NoBody)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	req.SetBasicAuth("admin", "password")
+	resp, err := sendReq(t, req, "")
+	require.NoError(t, err)
+	res := struct {
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+		URL     string    `json:"url"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, page, res.URL)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), res.Expires, 5*time.Second)
+
+	find := func(page, previewToken string) (code int, body R.JSON) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url="+url.QueryEscape(page), http.NoBody)
+		require.NoError(t, err)
+		if previewToken != "" {
+			req.Header.Set("X-Preview-Token", previewToken)
+		}
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	postComment := func(page, previewToken string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment?preview="+previewToken,
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "`+page+`", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	t.Run("valid token grants access", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, postComment(page, res.Token))
+		code, body := find(page, res.Token)
+		assert.Equal(t, http.StatusOK, code, body)
+		assert.Len(t, body["comments"], 1)
+	})
+
+	t.Run("no token", func(t *testing.T) {
+		code, body := find(page, "")
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Equal(t, "preview token required", body["details"])
+		assert.Equal(t, float64(rest.ErrPreviewDenied), body["code"])
+		assert.Equal(t, http.StatusForbidden, postComment(page, ""))
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		expired, _, err := srv.preview.issue("remark42", page, -time.Minute)
+		require.NoError(t, err)
+		code, _ := find(page, expired)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Equal(t, http.StatusForbidden, postComment(page, expired))
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		other, _, err := srv.preview.issue("remark42", "https://staging.radio-t.com/p2", 0)
+		require.NoError(t, err)
+		for name, tkn := range map[string]string{"other page": other, "user token": devToken, "garbage": "blah"} {
+			code, _ := find(page, tkn)
+			assert.Equal(t, http.StatusForbidden, code, name)
+		}
+	})
+
+	t.Run("published page and admin", func(t *testing.T) {
+		code, _ := find("https://radio-t.com/blah1", "")
+		assert.Equal(t, http.StatusOK, code)
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url="+url.QueryEscape(page), http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "admin allowed without token")
+
+		req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/preview?site=remark42&url=https://radio-t.com/blah1", http.NoBody)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err = sendReq(t, req, "")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no preview for published page")
+	})
+}
+
+func TestRest_PreviewHiddenInLists(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.Preview.URLs = []string{"https://staging.radio-t.com/"}
+	})
+	defer teardown()
+
+	const page = "https://staging.radio-t.com/p1"
+	previewToken, _, err := srv.preview.issue("remark42", page, 0)
+	require.NoError(t, err)
+
+	for _, u := range []string{page, "https://radio-t.com/blah1"} {
+		req, e := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment?preview="+previewToken,
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "`+u+`", "site": "remark42"}}`))
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, devToken)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusCreated, resp.StatusCode, u)
+	}
+
+	get := func(path, tkn string) string {
+		req, e := http.NewRequest(http.MethodGet, ts.URL+path, http.NoBody)
+		require.NoError(t, e)
+		req.Header.Set("X-Preview-Token", previewToken) // doesn't open unpublished page in lists
+		resp, e := sendReq(t, req, tkn)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		body, e := io.ReadAll(resp.Body)
+		require.NoError(t, e)
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+		return string(body)
+	}
+
+	paths := []string{"/api/v1/last/10?site=remark42", "/api/v1/list?site=remark42", "/api/v1/search?site=remark42&query=test",
+		"/api/v1/comments?site=remark42&user=provider1_dev", "/api/v1/rss/site?site=remark42", "/api/v1/sitemap.xml?site=remark42"}
+	for _, path := range paths {
+		body := get(path, devToken)
+		assert.Contains(t, body, "https://radio-t.com/blah1", path)
+		assert.NotContains(t, body, page, "unpublished page hidden from user, %s", path)
+		assert.Contains(t, get(path, adminUmputunToken), page, "unpublished page shown to admin, %s", path)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/counts?site=remark42",
+		strings.NewReader(`["`+page+`","https://radio-t.com/blah1"]`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, "")
+	require.NoError(t, err)
+	counts := []store.PostInfo{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&counts))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com/blah1", Count: 1}}, counts)
+}
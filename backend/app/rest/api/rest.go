@@ -5,10 +5,12 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
 	"net/mail"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
@@ -16,21 +18,26 @@ import (
 	"time"
 
 	"github.com/didip/tollbooth/v7"
+	"github.com/didip/tollbooth/v7/limiter"
 	"github.com/didip/tollbooth_chi"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
 	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/token"
 	"github.com/go-pkgz/lcw/v2"
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 	"github.com/go-pkgz/rest/logger"
+	"github.com/golang-jwt/jwt"
 
+	"github.com/umputun/remark42/backend/app/metrics"
 	"github.com/umputun/remark42/backend/app/notify"
 	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/rest/proxy"
 	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
@@ -41,6 +48,9 @@ type Rest struct {
 
 	DataService      *service.DataStore
 	Authenticator    *auth.Service
+	ClaimsUpdater    token.ClaimsUpdater // claims updater of Authenticator without side effects of issuing a token, for dry runs
+	TokenValidator   token.Validator     // validator of Authenticator, applied on token inspection
+	OIDCLogout       http.HandlerFunc    // back-channel logout of OIDC provider, not routed if nil
 	Cache            LoadingCache
 	ImageProxy       *proxy.Image
 	CommentFormatter *store.CommentFormatter
@@ -48,12 +58,25 @@ type Rest struct {
 	NotifyService    *notify.Service
 	TelegramService  telegramService
 	ImageService     *image.Service
+	KeyRotator       *adminstore.KeyRotator // rotates signing keys, nil if disabled
+	Federated        federatedVerifier      // verifies bearer tokens of federated issuers, nil if disabled
+	Metrics          *metrics.Metrics       // exposed on /metrics in Prometheus text format, nil if disabled
+	ModLog           *service.ModLog        // audit trail of moderation actions, nil if disabled
+
+	Sites []string // served sites, checked by readiness probe
+
+	HiddenUserFields map[string][]string // author fields hidden from readers other than admins and the author, per site
+	HoneypotFields   map[string]string   // hidden field of anonymous comment form per site, comments with the field filled dropped
+	ConfirmedEmail   []string            // sites accepting comments only from users with confirmed email, AllSitesConfirmedEmail for all
+	StrictOrigin     []string            // sites accepting comments only from origin of the post's host, AllSitesStrictOrigin for all
 
 	AnonVote        bool
 	WebRoot         string
 	WebFS           embed.FS
 	RemarkURL       string
 	ReadOnlyAge     int
+	MaxTreeComments int // soft cap of comments in tree format, unlimited if 0
+	MaxCountsPosts  int // max posts in one request of comment counts, unlimited if 0
 	SharedSecret    string
 	ScoreThresholds struct {
 		Low      int
@@ -66,11 +89,29 @@ type Rest struct {
 	SimpleView                 bool
 	ProxyCORS                  bool
 	SendJWTHeader              bool
-	AllowedAncestors           []string // sets Content-Security-Policy "frame-ancestors ..."
+	AllowedAncestors           []string            // sets Content-Security-Policy "frame-ancestors ..."
+	AllowedOrigins             map[string][]string // CORS allowed origins per site, all origins allowed if empty
+	SameSiteAuto               bool                // set SameSite of cookies per request, None for cross-site and Lax otherwise
+	JWTCookieReadable          bool                // dev only, JWT cookie issued without HttpOnly to be readable from JS
+	SiteJWTCookies             bool                // JWT cookie named per site, for multiple sites on the same domain
+	AvatarUpload               bool                // registered users allowed to upload own avatar in place of the provider's one
+	AudNormalizer              func(string) string // optional, normalizes site id of requests and audience of tokens
 	SubscribersOnly            bool
 	DisableSignature           bool // prevent signature from being added to headers
 	DisableFancyTextFormatting bool // disables SmartyPants in the comment text rendering of the posted comments
 
+	RealIP rest.RealIP // derives client IP from the trusted proxy header
+
+	KeyGrace time.Duration // default grace period of the previous signing key on rotation
+
+	Live LiveParams // live updates of posts with server-sent events
+	live *liveHub
+
+	Preview PreviewParams // unpublished pages, comments accessible with preview token only
+	preview previewGuard
+
+	Breaker BreakerParams // global circuit breaker of comment creation, disabled if Limit is 0
+
 	SSLConfig   SSLConfig
 	httpsServer *http.Server
 	httpServer  *http.Server
@@ -89,15 +130,63 @@ type LoadingCache interface {
 	Close() error
 }
 
+// AllSitesOrigins is the AllowedOrigins key for origins allowed for all sites
+const AllSitesOrigins = "*"
+
+// AllSitesConfirmedEmail is the ConfirmedEmail element requiring confirmed email on all sites
+const AllSitesConfirmedEmail = "*"
+
+// AllSitesUserFields is the HiddenUserFields key for fields hidden on all sites without own settings
+const AllSitesUserFields = "*"
+
+// author fields which can be hidden with HiddenUserFields
+const (
+	UserFieldID      = "id"
+	UserFieldName    = "name"
+	UserFieldPicture = "picture"
+)
+
 const hardBodyLimit = 1024 * 64 // limit size of body
 
 const lastCommentsScope = "last"
 
+const userDataLimit = 0.1 // rate limit for user data export, one per 10s, as export goes through all comments of the site
+
+const searchLimit = 2 // rate limit for search, as it goes through all comments of the site
+
+const maxSearchResults = 100 // max number of comments returned by search
+
 type commentsWithInfo struct {
-	Comments []store.Comment `json:"comments"`
-	Info     store.PostInfo  `json:"info,omitempty"`
+	Comments   []store.Comment `json:"comments"`
+	Info       store.PostInfo  `json:"info,omitempty"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type collapsedWithInfo struct {
+	Comments []service.CollapsedNode `json:"comments"`
+	Info     *store.PostInfo         `json:"info,omitempty"`
+}
+
+// commentWithAncestors keeps comment with its ancestors, ordered from the root comment to the direct parent
+type commentWithAncestors struct {
+	Comment   store.Comment   `json:"comment"`
+	Ancestors []store.Comment `json:"ancestors"`
 }
 
+type markedComment struct {
+	store.Comment
+	New bool `json:"new"`
+}
+
+type commentsWithMarker struct {
+	Comments []markedComment `json:"comments"`
+	NewCount int             `json:"new_count"`
+	Info     store.PostInfo  `json:"info"`
+	Marker   string          `json:"marker"`
+}
+
+const markerCookieAge = 365 * 24 * time.Hour
+
 type treeWithInfo struct {
 	*service.Tree
 	Info store.PostInfo `json:"info,omitempty"`
@@ -170,6 +259,9 @@ func (s *Rest) Shutdown() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 	s.lock.Lock()
+	if s.live != nil {
+		s.live.close() // live streams never end on their own
+	}
 	if s.httpServer != nil {
 		if err := s.httpServer.Shutdown(ctx); err != nil {
 			log.Printf("[DEBUG] http shutdown error, %s", err)
@@ -199,26 +291,53 @@ func (s *Rest) makeHTTPServer(address string, port int, router http.Handler) *ht
 
 func (s *Rest) routes() chi.Router {
 	router := chi.NewRouter()
-	router.Use(middleware.Throttle(1000), middleware.RealIP, R.Recoverer(log.Default()))
+	router.Use(middleware.Throttle(1000), s.RealIP.Handler, R.Recoverer(log.Default()))
 	if !s.DisableSignature {
 		router.Use(R.AppInfo("remark42", "umputun", s.Version))
 	}
 	router.Use(R.Ping)
+	if s.SameSiteAuto {
+		router.Use(rest.SameSiteAuto)
+	}
+	if s.AudNormalizer != nil {
+		router.Use(rest.NormalizeAud(s.AudNormalizer))
+	}
+	if s.SiteJWTCookies { // after site id normalized and before cookies of the site changed by other middlewares
+		router.Use(rest.SiteJWTCookie(jwtCookieName, xsrfCookieName))
+	}
+	if s.JWTCookieReadable {
+		log.Print("[WARN] JWT cookie is not HttpOnly and readable by any script on the page, don't use it in production!")
+		router.Use(rest.JWTCookieReadable(jwtCookieName))
+	}
+	if s.KeyRotator != nil {
+		router.Use(s.reissuePreviousKeyToken)
+	}
 
+	if s.Live.Enabled {
+		s.live = newLiveHub(s.Live)
+	}
+	s.preview = previewGuard{params: s.Preview, tokens: s.Authenticator.TokenService(), audNormalizer: s.AudNormalizer}
 	s.pubRest, s.privRest, s.adminRest, s.rssRest = s.controllerGroups() // assign controllers for groups
 
 	if s.ProxyCORS {
 		log.Printf("[WARN] internal CORS disabled")
 	} else {
-		corsMiddleware := cors.New(cors.Options{
+		corsOpts := cors.Options{
 			AllowedOrigins:   []string{"*"},
 			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-XSRF-Token", "X-JWT"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-XSRF-Token", "X-JWT", "X-Preview-Token", "X-Inspect-Token"},
 			ExposedHeaders:   []string{"Authorization"},
 			AllowCredentials: true,
 			MaxAge:           300,
-		})
-		router.Use(corsMiddleware.Handler)
+		}
+		if len(s.AllowedOrigins) > 0 {
+			log.Printf("[INFO] CORS allowed origins %+v", s.AllowedOrigins)
+			corsOpts.AllowedOrigins, corsOpts.AllowOriginFunc = nil, s.originAllowed
+		}
+		router.Use(cors.New(corsOpts).Handler)
+		if len(s.AllowedOrigins) > 0 {
+			router.Use(s.rejectUnknownOrigin)
+		}
 	}
 
 	if len(s.AllowedAncestors) > 0 {
@@ -233,41 +352,82 @@ func (s *Rest) routes() chi.Router {
 
 	router.Group(func(r chi.Router) {
 		r.Use(middleware.Timeout(5 * time.Second))
-		r.Use(logInfoWithBody, tollbooth_chi.LimitHandler(tollbooth.NewLimiter(2, nil)), middleware.NoCache)
+		r.Use(logInfoWithBody, tollbooth_chi.LimitHandler(newLimiter(2)), middleware.NoCache)
 		r.Use(validEmailAuth()) // reject suspicious email logins
+		r.Use(s.tokenInBody)    // issued token returned in json body on request
 		r.Mount("/auth", authHandler)
 	})
 
+	if s.OIDCLogout != nil {
+		// called by the issuer server to server, logout token not logged
+		router.Group(func(r chi.Router) {
+			r.Use(middleware.Timeout(5*time.Second), tollbooth_chi.LimitHandler(newLimiter(10)), middleware.NoCache)
+			r.Post("/auth/oidc/backchannel-logout", s.OIDCLogout)
+		})
+	}
+
 	router.Group(func(r chi.Router) {
 		r.Use(middleware.Timeout(5 * time.Second))
-		r.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(100, nil)))
+		r.Use(tollbooth_chi.LimitHandler(newLimiter(100)))
 		r.Mount("/avatar", avatarHandler)
 	})
 
+	// liveness and readiness probes and metrics, not rate limited as called by orchestrator and monitoring
+	router.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(5*time.Second), middleware.NoCache)
+		r.Get("/healthz", s.healthzCtrl)
+		r.Get("/readyz", s.readyzCtrl)
+		if s.Metrics != nil {
+			r.Method(http.MethodGet, "/metrics", s.Metrics)
+		}
+	})
+
 	authMiddleware := s.Authenticator.Middleware()
+	authRequired, authTrace := s.federatedAuth(authMiddleware.Auth, true), s.federatedAuth(authMiddleware.Trace, false)
 
 	// api routes
 	router.Route("/api/v1", func(rapi chi.Router) {
 		rapi.Group(func(rava chi.Router) {
 			rava.Use(middleware.Timeout(5 * time.Second))
-			rava.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(100, nil)))
+			rava.Use(tollbooth_chi.LimitHandler(newLimiter(100)))
 			rava.Mount("/avatar", avatarHandler)
 		})
 
+		// live updates, long-living streams not limited by timeout
+		if s.live != nil {
+			rapi.Group(func(rlive chi.Router) {
+				rlive.Use(tollbooth_chi.LimitHandler(newLimiter(10)))
+				rlive.Use(authTrace, s.preview.handler, middleware.NoCache)
+				rlive.Get("/stream", s.pubRest.liveCtrl)
+			})
+		}
+
+		// token check, without auth middleware as it refreshes expired token and sets cookies
+		rapi.Group(func(rtoken chi.Router) {
+			rtoken.Use(middleware.Timeout(5 * time.Second))
+			rtoken.Use(tollbooth_chi.LimitHandler(newLimiter(10)), middleware.NoCache)
+			rtoken.Get("/token/check", s.tokenCheckCtrl)
+		})
+
 		// open routes
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
-			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			ropen.Use(authMiddleware.Trace, middleware.NoCache, logInfoWithBody)
+			ropen.Use(tollbooth_chi.LimitHandler(newLimiter(10)))
+			ropen.Use(authTrace, s.preview.handler, middleware.NoCache, logInfoWithBody)
 			ropen.Get("/config", s.configCtrl)
 			ropen.Get("/find", s.pubRest.findCommentsCtrl)
 			ropen.Get("/id/{id}", s.pubRest.commentByIDCtrl)
+			ropen.Get("/replies/{id}", s.pubRest.repliesCtrl)
+			ropen.Get("/ancestors/{id}", s.pubRest.ancestorsCtrl)
 			ropen.Get("/comments", s.pubRest.findUserCommentsCtrl)
+			ropen.With(tollbooth_chi.LimitHandler(newLimiter(searchLimit))).Get("/search", s.pubRest.searchCtrl)
 			ropen.Get("/last/{limit}", s.pubRest.lastCommentsCtrl)
 			ropen.Get("/count", s.pubRest.countCtrl)
 			ropen.Post("/counts", s.pubRest.countMultiCtrl)
 			ropen.Get("/list", s.pubRest.listCtrl)
+			ropen.Get("/sitemap.xml", s.pubRest.sitemapCtrl)
 			ropen.Get("/info", s.pubRest.infoCtrl)
+			ropen.Get("/new", s.pubRest.newCommentsCtrl)
 			ropen.Get("/img", s.ImageProxy.Handler)
 
 			ropen.Route("/rss", func(rrss chi.Router) {
@@ -280,38 +440,57 @@ func (s *Rest) routes() chi.Router {
 		// open routes, cached
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
-			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			ropen.Use(authMiddleware.Trace, logInfoWithBody)
+			ropen.Use(tollbooth_chi.LimitHandler(newLimiter(10)))
+			ropen.Use(authTrace, logInfoWithBody)
 			ropen.Get("/picture/{user}/{id}", s.pubRest.loadPictureCtrl)
 			ropen.Get("/qr/telegram", s.pubRest.telegramQrCtrl)
+			ropen.Post("/review", s.privRest.reviewHeldCtrl)
+			ropen.Post("/email/follow", s.privRest.followCtrl)
 		})
 
 		// protected routes, require auth
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(30 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			rauth.Use(authMiddleware.Auth, matchSiteID, middleware.NoCache, logInfoWithBody)
+			rauth.Use(tollbooth_chi.LimitHandler(newLimiter(10)))
+			rauth.Use(authRequired, s.matchSiteID, middleware.NoCache, logInfoWithBody)
 			rauth.Get("/user", s.privRest.userInfoCtrl)
-			rauth.Get("/userdata", s.privRest.userAllDataCtrl)
+			rauth.With(tollbooth_chi.LimitHandler(newLimiter(userDataLimit))).
+				Get("/userdata", s.privRest.userAllDataCtrl)
 		})
 
 		// admin routes, require auth and admin users only
 		rapi.Route("/admin", func(radmin chi.Router) {
 			radmin.Use(middleware.Timeout(30 * time.Second))
-			radmin.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			radmin.Use(authMiddleware.Auth, authMiddleware.AdminOnly, matchSiteID)
+			radmin.Use(tollbooth_chi.LimitHandler(newLimiter(10)))
+			radmin.Use(authRequired, authMiddleware.AdminOnly, s.matchSiteID)
 			radmin.Use(middleware.NoCache, logInfoWithBody)
 
 			radmin.Delete("/comment/{id}", s.adminRest.deleteCommentCtrl)
+			radmin.Put("/restore/{id}", s.adminRest.restoreCommentCtrl)
 			radmin.Put("/user/{userid}", s.adminRest.setBlockCtrl)
 			radmin.Delete("/user/{userid}", s.adminRest.deleteUserCtrl)
 			radmin.Get("/user/{userid}", s.adminRest.getUserInfoCtrl)
+			radmin.Put("/user/{userid}/merge", s.adminRest.mergeUserCtrl)
+			radmin.With(tollbooth_chi.LimitHandler(newLimiter(userDataLimit))).
+				Get("/userdata/{userid}", s.adminRest.userAllDataCtrl)
 			radmin.Get("/deleteme", s.adminRest.deleteMeRequestCtrl)
 			radmin.Put("/verify/{userid}", s.adminRest.setVerifyCtrl)
 			radmin.Put("/pin/{id}", s.adminRest.setPinCtrl)
+			radmin.Put("/hide/{id}", s.adminRest.setHiddenCtrl)
+			radmin.Put("/lock/{id}", s.adminRest.setLockedCtrl)
 			radmin.Get("/blocked", s.adminRest.blockedUsersCtrl)
 			radmin.Put("/readonly", s.adminRest.setReadOnlyCtrl)
+			radmin.Put("/voting", s.adminRest.setVotingFrozenCtrl)
 			radmin.Put("/title/{id}", s.adminRest.setTitleCtrl)
+			radmin.Get("/history/{id}", s.adminRest.commentHistoryCtrl)
+			radmin.Get("/comments", s.adminRest.listCommentsCtrl)
+			radmin.Get("/modlog", s.adminRest.modLogCtrl)
+			radmin.Get("/key", s.adminRest.keyStatusCtrl)
+			radmin.Put("/key/rotate", s.adminRest.rotateKeyCtrl)
+			radmin.Put("/key/promote", s.adminRest.promoteKeyCtrl)
+			radmin.Get("/preview", s.adminRest.previewTokenCtrl)
+			radmin.Get("/token/inspect", s.adminRest.inspectTokenCtrl)
+			radmin.Post("/claims/dry-run", s.adminRest.dryRunClaimsCtrl)
 
 			// migrator
 			radmin.Get("/export", s.adminRest.migrator.exportCtrl)
@@ -324,14 +503,20 @@ func (s *Rest) routes() chi.Router {
 		// protected routes, throttled to 10/s by default, controlled by external UpdateLimiter param
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
-			rauth.Use(authMiddleware.Auth, matchSiteID, subscribersOnly(s.SubscribersOnly))
+			rauth.Use(tollbooth_chi.LimitHandler(newLimiter(s.updateLimiter())))
+			rauth.Use(authRequired, s.matchSiteID, subscribersOnly(s.SubscribersOnly), s.preview.handler)
 			rauth.Use(middleware.NoCache, logInfoWithBody)
 
 			rauth.Put("/comment/{id}", s.privRest.updateCommentCtrl)
+			rauth.Put("/comment/{id}/redact", s.privRest.redactCommentCtrl)
 			rauth.Post("/preview", s.privRest.previewCommentCtrl)
 			rauth.Post("/comment", s.privRest.createCommentCtrl)
 			rauth.Put("/vote/{id}", s.privRest.voteCtrl)
+			rauth.Put("/react/{id}", s.privRest.reactCtrl)
+			rauth.Put("/report/{id}", s.privRest.reportCtrl)
+			rauth.Get("/draft", s.privRest.getDraftCtrl)
+			rauth.Put("/draft", s.privRest.saveDraftCtrl)
+			rauth.Delete("/draft", s.privRest.deleteDraftCtrl)
 			rauth.With(rejectAnonUser).Post("/deleteme", s.privRest.deleteMeCtrl)
 			rauth.With(rejectAnonUser).Get("/email", s.privRest.getEmailCtrl)
 			rauth.With(rejectAnonUser).Post("/email/subscribe", s.privRest.sendEmailConfirmationCtrl)
@@ -339,25 +524,34 @@ func (s *Rest) routes() chi.Router {
 			rauth.With(rejectAnonUser).Delete("/email", s.privRest.deleteEmailCtrl)
 			rauth.With(rejectAnonUser).Get("/telegram/subscribe", s.privRest.telegramSubscribeCtrl)
 			rauth.With(rejectAnonUser).Delete("/telegram", s.privRest.deleteTelegramCtrl)
+			rauth.With(rejectAnonUser).Get("/notify/prefs", s.privRest.getNotifyPrefsCtrl)
+			rauth.With(rejectAnonUser).Put("/notify/prefs", s.privRest.setNotifyPrefsCtrl)
 		})
 
 		// protected routes, anonymous rejected
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
-			rauth.Use(authMiddleware.Auth, rejectAnonUser, matchSiteID)
+			rauth.Use(tollbooth_chi.LimitHandler(newLimiter(s.updateLimiter())))
+			rauth.Use(authRequired, rejectAnonUser, s.matchSiteID)
 			rauth.Use(logger.New(logger.Log(log.Default()), logger.Prefix("[DEBUG]"), logger.IPfn(ipFn)).Handler)
 			rauth.Post("/picture", s.privRest.savePictureCtrl)
+			rauth.Post("/user/avatar", s.privRest.uploadAvatarCtrl)
 		})
 	})
 
 	// open routes on root level
 	router.Group(func(rroot chi.Router) {
 		rroot.Use(middleware.Timeout(10 * time.Second))
-		rroot.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(50, nil)))
+		rroot.Use(tollbooth_chi.LimitHandler(newLimiter(50)))
 		rroot.Get("/robots.txt", s.pubRest.robotsCtrl)
 		rroot.Get("/email/unsubscribe.html", s.privRest.emailUnsubscribeCtrl)
 		rroot.Post("/email/unsubscribe.html", s.privRest.emailUnsubscribeCtrl)
+		rroot.Get("/email/unsubscribe-post.html", s.privRest.emailUnsubscribePostCtrl)
+		rroot.Post("/email/unsubscribe-post.html", s.privRest.emailUnsubscribePostCtrl)
+		rroot.Get("/email/follow.html", s.privRest.followConfirmCtrl)
+		rroot.Post("/email/follow.html", s.privRest.followConfirmCtrl)
+		rroot.Get("/email/unfollow.html", s.privRest.unfollowCtrl)
+		rroot.Post("/email/unfollow.html", s.privRest.unfollowCtrl)
 	})
 
 	// file server for static content from s.WebRoot on path /web
@@ -372,6 +566,19 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		imageService:     s.ImageService,
 		commentFormatter: s.CommentFormatter,
 		readOnlyAge:      s.ReadOnlyAge,
+		markerSecret:     s.SharedSecret,
+		remarkURL:        s.RemarkURL,
+		sitemapPageSize:  maxSitemapURLs,
+		maxTreeComments:  s.MaxTreeComments,
+		maxCountsPosts:   s.MaxCountsPosts,
+		userFields:       userFieldsFilter{hidden: s.HiddenUserFields, secret: s.SharedSecret},
+		live:             s.live,
+		preview:          s.preview,
+	}
+
+	var avatarUpload *rest.AvatarUpload // nil if upload disabled
+	if s.AvatarUpload {
+		avatarUpload = &rest.AvatarUpload{Proxy: s.Authenticator.AvatarProxy()}
 	}
 
 	privGrp := private{
@@ -385,7 +592,17 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		telegramService:            s.TelegramService,
 		remarkURL:                  s.RemarkURL,
 		anonVote:                   s.AnonVote,
+		emailNotifications:         s.EmailNotifications,
 		disableFancyTextFormatting: s.DisableFancyTextFormatting,
+		audNormalizer:              s.AudNormalizer,
+		live:                       s.live,
+		honeypot:                   s.HoneypotFields,
+		confirmedEmail:             s.ConfirmedEmail,
+		origin:                     originPolicy{sites: s.StrictOrigin, allowed: s.siteOriginAllowed},
+		preview:                    s.preview,
+		modLog:                     s.ModLog,
+		avatarUpload:               avatarUpload,
+		breaker:                    newCreateBreaker(s.Breaker),
 	}
 
 	admGrp := admin{
@@ -394,11 +611,23 @@ func (s *Rest) controllerGroups() (public, private, admin, rss) {
 		cache:         s.Cache,
 		authenticator: s.Authenticator,
 		readOnlyAge:   s.ReadOnlyAge,
+		keyGrace:      s.KeyGrace,
+		flagScore:     s.ScoreThresholds.Low,
+		preview:       s.preview,
+		modLog:        s.ModLog,
+		claimsUpd:     s.ClaimsUpdater,
+		validator:     s.TokenValidator,
+		audNormalizer: s.AudNormalizer,
+	}
+	if s.KeyRotator != nil { // avoid typed nil in the interface
+		admGrp.keyRotator = s.KeyRotator
 	}
 
 	rssGrp := rss{
 		dataService: s.DataService,
 		cache:       s.Cache,
+		userFields:  userFieldsFilter{hidden: s.HiddenUserFields, secret: s.SharedSecret},
+		preview:     s.preview,
 	}
 
 	return pubGrp, privGrp, admGrp, rssGrp
@@ -413,6 +642,80 @@ func (s *Rest) updateLimiter() float64 {
 	return lmt
 }
 
+// newLimiter makes rate limiter keyed by r.RemoteAddr only, as it is already set to the client IP
+// by RealIP middleware. Default tollbooth lookups trust X-Forwarded-For and X-Real-IP from any peer.
+func newLimiter(maxRate float64) *limiter.Limiter {
+	return tollbooth.NewLimiter(maxRate, nil).SetIPLookups([]string{"RemoteAddr"})
+}
+
+// GET /healthz - liveness probe, responds while the server is running without checking backends
+func (s *Rest) healthzCtrl(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, R.JSON{"status": "ok"})
+}
+
+// GET /readyz - readiness probe, checks comment store and signing keys backend for all sites.
+// Responds with 503 if any of them is down, errors logged but not exposed.
+func (s *Rest) readyzCtrl(w http.ResponseWriter, r *http.Request) {
+	keys := func(siteID string) (string, error) { return s.DataService.AdminStore.Key(siteID) }
+	if s.KeyRotator != nil {
+		keys = s.KeyRotator.Key
+	}
+
+	checks := R.JSON{"store": "ok", "secrets": "ok"}
+	ready := true
+	for _, siteID := range s.Sites {
+		if _, err := s.DataService.List(siteID, 1, 0); err != nil {
+			log.Printf("[WARN] readiness check of store for %s failed, %v", siteID, err)
+			checks["store"], ready = "down", false
+		}
+		if _, err := keys(siteID); err != nil {
+			log.Printf("[WARN] readiness check of secrets for %s failed, %v", siteID, err)
+			checks["secrets"], ready = "down", false
+		}
+	}
+
+	checks["status"] = "ok"
+	if !ready {
+		checks["status"] = "down"
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, checks)
+}
+
+// GET /token/check - checks token passed in X-JWT header or jwt query param and returns its info.
+// Expired token reported as invalid, token not refreshed and no cookies set.
+func (s *Rest) tokenCheckCtrl(w http.ResponseWriter, r *http.Request) {
+	tkn := r.Header.Get("X-JWT")
+	if tkn == "" {
+		tkn = r.URL.Query().Get("jwt")
+	}
+	if tkn == "" {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, fmt.Errorf("no token"), "token not passed", rest.ErrInvalidToken)
+		return
+	}
+	claims, err := s.Authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, err, "invalid token", rest.ErrInvalidToken)
+		return
+	}
+
+	expires := time.Unix(claims.ExpiresAt, 0).UTC()
+	ttl := time.Until(expires)
+	expired := s.Authenticator.TokenService().IsExpired(claims)
+	res := R.JSON{
+		"valid":   !expired && claims.User != nil && !claims.User.BoolAttr("blocked"),
+		"expired": expired,
+		"expires": expires,
+		"ttl":     int64(max(ttl, 0).Seconds()),
+		"aud":     claims.Audience,
+	}
+	if claims.User != nil {
+		res["user"] = R.JSON{"id": claims.User.ID, "name": claims.User.Name, "admin": claims.User.IsAdmin(),
+			"blocked": claims.User.BoolAttr("blocked")}
+	}
+	render.JSON(w, r, res)
+}
+
 // GET /config?site=siteID - returns configuration
 func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
@@ -441,6 +744,10 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		SimpleView            bool     `json:"simple_view"`
 		SendJWTHeader         bool     `json:"send_jwt_header"`
 		SubscribersOnly       bool     `json:"subscribers_only"`
+		VotingFrozen          bool     `json:"voting_frozen"`
+		HoneypotField         string   `json:"honeypot_field,omitempty"`
+		ConfirmedEmailOnly    bool     `json:"confirmed_email_only"`
+		AvatarUpload          bool     `json:"avatar_upload"`
 	}{
 		Version:               s.Version,
 		EditDuration:          int(s.DataService.EditDuration.Seconds()),
@@ -461,6 +768,10 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		SimpleView:            s.SimpleView,
 		SendJWTHeader:         s.SendJWTHeader,
 		SubscribersOnly:       s.SubscribersOnly,
+		VotingFrozen:          s.DataService.IsVotingFrozen(siteID),
+		HoneypotField:         honeypot(s.HoneypotFields).field(siteID),
+		ConfirmedEmailOnly:    confirmedEmailOnly(s.ConfirmedEmail, siteID),
+		AvatarUpload:          s.AvatarUpload,
 	}
 
 	cnf.Auth = []string{}
@@ -491,7 +802,7 @@ func addFileServer(r chi.Router, embedFS embed.FS, webRoot, version string) {
 	webFS = http.StripPrefix("/web", webFS)
 	r.Get("/web", http.RedirectHandler("/web/", http.StatusMovedPermanently).ServeHTTP)
 
-	r.With(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(20, nil)),
+	r.With(tollbooth_chi.LimitHandler(newLimiter(20)),
 		middleware.Timeout(10*time.Second),
 		cacheControl(time.Hour, version),
 	).Get("/web/*", func(w http.ResponseWriter, r *http.Request) {
@@ -535,6 +846,46 @@ func URLKey(r *http.Request) string {
 	return key
 }
 
+// userFieldsFilter hides author fields configured in Rest.HiddenUserFields from readers
+type userFieldsFilter struct {
+	hidden map[string][]string
+	secret string
+}
+
+// comments hides author fields of comments, unless the reader is admin or the author
+func (f userFieldsFilter) comments(comments []store.Comment, reader store.User) []store.Comment {
+	if len(f.hidden) == 0 || reader.Admin {
+		return comments
+	}
+	for i, c := range comments {
+		if reader.ID != "" && reader.ID == c.User.ID {
+			continue
+		}
+		comments[i].User = f.user(c.Locator.SiteID, c.User)
+	}
+	return comments
+}
+
+// user hides fields of the user for the site. Hidden id replaced by the hash stable for the site,
+// so comments of the same author still can be grouped by readers.
+func (f userFieldsFilter) user(siteID string, u store.User) store.User {
+	fields, ok := f.hidden[siteID]
+	if !ok {
+		fields = f.hidden[AllSitesUserFields]
+	}
+	for _, field := range fields {
+		switch field {
+		case UserFieldID:
+			u.ID = "hidden_" + store.HashValue(siteID+"::"+u.ID, f.secret)[:12]
+		case UserFieldName:
+			u.Name = "hidden"
+		case UserFieldPicture:
+			u.Picture = ""
+		}
+	}
+	return u
+}
+
 // URLKeyWithUser gets url from request to use it as cache key and attaching user ID
 // admins will have different keys in order to prevent leak of admin-only data to regular users
 func URLKeyWithUser(r *http.Request) string {
@@ -550,6 +901,82 @@ func URLKeyWithUser(r *http.Request) string {
 	return key
 }
 
+// reissuePreviousKeyToken accepts tokens signed with the previous key during the grace period of key rotation.
+// Such token reissued with the current key, replaced in the request and sent back to the client
+func (s *Rest) reissuePreviousKeyToken(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		tknSvc := s.Authenticator.TokenService()
+		tkn, src := "", ""
+		if q := r.URL.Query().Get(tknSvc.JWTQuery); q != "" {
+			tkn, src = q, "query"
+		} else if h := r.Header.Get(tknSvc.JWTHeaderKey); h != "" {
+			tkn, src = h, "header"
+		} else if c, err := r.Cookie(tknSvc.JWTCookieName); err == nil {
+			tkn, src = c.Value, "cookie"
+		}
+		if tkn == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := tknSvc.Parse(tkn); err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := parsePreviousKey(tkn, s.KeyRotator.PreviousKey)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		newTkn, err := tknSvc.Token(claims)
+		if err != nil {
+			log.Printf("[WARN] can't reissue token signed with previous key, %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch src {
+		case "query":
+			q := r.URL.Query()
+			q.Set(tknSvc.JWTQuery, newTkn)
+			r.URL.RawQuery = q.Encode()
+		case "header":
+			r.Header.Set(tknSvc.JWTHeaderKey, newTkn)
+		case "cookie":
+			cookies := r.Cookies()
+			r.Header.Del("Cookie")
+			for _, c := range cookies {
+				if c.Name == tknSvc.JWTCookieName {
+					c.Value = newTkn
+				}
+				r.AddCookie(c)
+			}
+		}
+		if src != "query" { // replace token kept by the client
+			if _, err = tknSvc.Set(w, claims); err != nil {
+				log.Printf("[WARN] can't set reissued token, %v", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// parsePreviousKey parses token signed with the previous key of its site, expired tokens accepted
+// as they handled by auth middleware
+func parsePreviousKey(tkn string, previousKey func(siteID string) (string, error)) (token.Claims, error) {
+	claims := token.Claims{}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(tkn, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		key, e := previousKey(claims.Audience)
+		return []byte(key), e
+	})
+	return claims, err
+}
+
 // rejectAnonUser is a middleware rejecting anonymous users
 func rejectAnonUser(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
@@ -568,8 +995,9 @@ func rejectAnonUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-// matchSiteID is a middleware rejecting users with mismatch between site param and and User.SiteID
-func matchSiteID(next http.Handler) http.Handler {
+// matchSiteID is a middleware rejecting users with mismatch between site param and and User.SiteID.
+// Site param normalized by AudNormalizer middleware already, User.SiteID normalized here for tokens issued before.
+func (s *Rest) matchSiteID(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user, err := rest.GetUserInfo(r)
 		if err != nil {
@@ -583,8 +1011,11 @@ func matchSiteID(next http.Handler) http.Handler {
 			return
 		}
 
-		siteID := r.URL.Query().Get("site")
-		if siteID != "" && user.SiteID != siteID {
+		siteID, userSiteID := r.URL.Query().Get("site"), user.SiteID
+		if s.AudNormalizer != nil {
+			userSiteID = s.AudNormalizer(userSiteID)
+		}
+		if siteID != "" && userSiteID != siteID {
 			http.Error(w, "Access denied", http.StatusForbidden)
 			return
 		}
@@ -634,6 +1065,74 @@ func frameAncestors(hosts []string) func(http.Handler) http.Handler {
 	}
 }
 
+// originAllowed checks request's origin against allowed origins of the site from "site" query param,
+// origins listed for all sites and remark42 own origin. Request without site allowed from origin of any site,
+// used by CORS for reading responses, state-changing requests checked by rejectUnknownOrigin.
+func (s *Rest) originAllowed(r *http.Request, origin string) bool {
+	if siteID := r.URL.Query().Get("site"); siteID != "" {
+		return s.siteOriginAllowed(siteID, origin)
+	}
+	for siteID := range s.AllowedOrigins {
+		if s.siteOriginAllowed(siteID, origin) {
+			return true
+		}
+	}
+	return s.siteOriginAllowed("", origin)
+}
+
+// siteOriginAllowed checks origin against allowed origins of the site, origins listed for all sites and remark42
+// own origin. Only origins listed for all sites and remark42 own origin allowed if siteID not set.
+func (s *Rest) siteOriginAllowed(siteID, origin string) bool {
+	origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+	if origin == "" {
+		return false
+	}
+	if u, err := url.Parse(s.RemarkURL); err == nil && strings.EqualFold(u.Scheme+"://"+u.Host, origin) {
+		return true
+	}
+
+	match := func(origins []string) bool {
+		for _, o := range origins {
+			if o == "*" || strings.TrimSuffix(strings.ToLower(o), "/") == origin {
+				return true
+			}
+		}
+		return false
+	}
+
+	if match(s.AllowedOrigins[AllSitesOrigins]) {
+		return true
+	}
+	return siteID != "" && match(s.AllowedOrigins[siteID])
+}
+
+// rejectUnknownOrigin is a middleware rejecting state-changing requests from origins not allowed by CORS settings.
+// CORS headers only prevent browser from reading the response, but the request itself is processed.
+// Site of the request taken from "site" or "aud" query param, request without site accepted from origins listed
+// for all sites and remark42 own origin only, as origin of one site can't change data of another one. Protected
+// routes also match the site to the user's token, see matchSiteID, and the token to the site of posted comment.
+// Requests without Origin header are not made by browser from another site and passed as is.
+func (s *Rest) rejectUnknownOrigin(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		siteID := r.URL.Query().Get("site")
+		if siteID == "" {
+			siteID = r.URL.Query().Get("aud")
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && !s.siteOriginAllowed(siteID, origin) {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("origin %s not allowed for site %q", origin, siteID),
+				"origin not allowed", rest.ErrActionRejected)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
 // subscribersOnly is a middleware rejecting non-paid_sub users
 func subscribersOnly(enable bool) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
@@ -655,6 +1154,16 @@ func subscribersOnly(enable bool) func(http.Handler) http.Handler {
 	}
 }
 
+// confirmedEmailOnly checks if the site accepts comments only from users with confirmed email
+func confirmedEmailOnly(sites []string, siteID string) bool {
+	for _, s := range sites {
+		if s == siteID || s == AllSitesConfirmedEmail {
+			return true
+		}
+	}
+	return false
+}
+
 // validEmailAuth is a middleware for auth endpoints for email method.
 // it rejects login request if user, site or email are suspicious
 func validEmailAuth() func(http.Handler) http.Handler {
@@ -714,12 +1223,28 @@ func parseError(err error, defaultCode int) (code int) {
 		code = rest.ErrVoteMax
 	case strings.Contains(err.Error(), "minimal score reached for comment"):
 		code = rest.ErrVoteMinScore
+	case errors.Is(err, service.ErrVotingFrozen):
+		code = rest.ErrVotingFrozen
+	case errors.Is(err, service.ErrThreadLocked):
+		code = rest.ErrThreadLocked
 
 	// edit errors
 	case strings.HasPrefix(err.Error(), "too late to edit"):
 		code = rest.ErrCommentEditExpired
 	case strings.HasPrefix(err.Error(), "parent comment with reply can't be edited"):
 		code = rest.ErrCommentEditChanged
+
+	// validation errors
+	case errors.Is(err, service.ErrCommentTooShort):
+		code = rest.ErrCommentTooShort
+	case errors.Is(err, service.ErrCommentTooLong):
+		code = rest.ErrCommentTooLong
+	case errors.Is(err, service.ErrTooManyImages):
+		code = rest.ErrTooManyImages
+	case errors.Is(err, service.ErrBlockedName):
+		code = rest.ErrBlockedName
+	case errors.Is(err, service.ErrScriptNotAllowed):
+		code = rest.ErrScriptNotAllowed
 	}
 
 	return code
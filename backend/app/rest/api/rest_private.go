@@ -10,7 +10,11 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"math"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +26,7 @@ import (
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/umputun/remark42/backend/app/notify"
@@ -44,7 +49,17 @@ type private struct {
 	telegramService            telegramService
 	remarkURL                  string
 	anonVote                   bool
-	disableFancyTextFormatting bool // disables SmartyPants in the comment text rendering of the posted comments
+	emailNotifications         bool                // email notifications enabled for users, required to follow posts by email
+	disableFancyTextFormatting bool                // disables SmartyPants in the comment text rendering of the posted comments
+	audNormalizer              func(string) string // optional, normalizes site id of posted comments
+	live                       *liveHub            // new comments published to live readers, nil if disabled
+	honeypot                   honeypot            // hidden field of anonymous comment form per site
+	confirmedEmail             []string            // sites accepting comments only from users with confirmed email
+	origin                     originPolicy        // checks origin of comments posted to sites with strict origin
+	preview                    previewGuard        // checks preview token for comments of unpublished pages
+	modLog                     *service.ModLog     // records moderator's edits and reviews, nil if disabled
+	avatarUpload               *rest.AvatarUpload  // stores avatars uploaded by users, nil if disabled
+	breaker                    *createBreaker      // suspends comment creation on all sites under flood, nil if disabled
 }
 
 // telegramService is a subset of Telegram service used for setting up user telegram notifications
@@ -57,19 +72,34 @@ type telegramService interface {
 type privStore interface {
 	Create(comment store.Comment) (commentID string, err error)
 	EditComment(locator store.Locator, commentID string, req service.EditRequest) (comment store.Comment, err error)
+	Redact(locator store.Locator, commentID, userID string) (store.Comment, error)
 	Vote(req service.VoteReq) (comment store.Comment, err error)
+	React(req service.ReactReq) (comment store.Comment, err error)
+	Report(locator store.Locator, commentID string, user store.User) (comment store.Comment, err error)
 	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
 	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
 	GetUserEmail(siteID, userID string) (string, error)
 	SetUserEmail(siteID, userID, value string) (string, error)
 	GetUserTelegram(siteID, userID string) (string, error)
 	SetUserTelegram(siteID, userID, value string) (string, error)
+	GetNotifyPrefs(siteID, userID string) (store.NotifyPrefs, error)
+	SetNotifyPrefs(siteID, userID string, prefs store.NotifyPrefs) (store.NotifyPrefs, error)
 	DeleteUserDetail(siteID, userID string, detail engine.UserDetail) error
+	SetUnsubscribed(locator store.Locator, userID string, status bool) error
+	Follow(locator store.Locator, email string) error
+	Unfollow(locator store.Locator, email string) error
+	SaveDraft(locator store.Locator, userID, text string) (service.Draft, error)
+	GetDraft(locator store.Locator, userID string) (service.Draft, bool)
+	DeleteDraft(locator store.Locator, userID string)
+	ReviewHeld(siteID, commentID, token string, approve bool) (store.Comment, error)
+	ReviewReported(locator store.Locator, commentID, token string, approve bool) (store.Comment, error)
 	ValidateComment(c *store.Comment) error
+	ValidateRendered(c *store.Comment) error
 	IsVerified(siteID, userID string) bool
 	IsReadOnly(locator store.Locator) bool
 	IsBlocked(siteID, userID string) bool
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
+	UserVotes(siteID, userID string) ([]service.UserVote, error)
 }
 
 // POST /preview, body is a comment, returns rendered html
@@ -85,12 +115,16 @@ func (s *private) previewCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	comment.User = user
 	comment.Orig = comment.Text
 	if err := s.dataService.ValidateComment(&comment); err != nil {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentValidation)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", parseError(err, rest.ErrCommentValidation))
 		return
 	}
 
 	comment = s.commentFormatter.Format(comment, s.disableFancyTextFormatting)
 	comment.Sanitize()
+	if err := s.dataService.ValidateRendered(&comment); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", parseError(err, rest.ErrCommentValidation))
+		return
+	}
 
 	// check if images are valid, omit proxied images as they are lazy-loaded
 	for _, id := range s.imageService.ExtractNonProxiedPictures(comment.Text) {
@@ -106,13 +140,27 @@ func (s *private) previewCommentCtrl(w http.ResponseWriter, r *http.Request) {
 
 // POST /comment - adds comment, resets all immutable fields
 func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	// load shed before anything else, admins not counted and not suspended
+	if !rest.MustGetUserInfo(r).Admin {
+		if wait := s.breaker.hit(time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			rest.SendErrorJSON(w, r, http.StatusServiceUnavailable, fmt.Errorf("comment creation suspended"),
+				"too many comments, try later", rest.ErrCommentsSuspended)
+			return
+		}
+	}
+
 	comment := store.Comment{}
-	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &comment); err != nil {
+	body := bytes.Buffer{} // raw body kept to check honeypot field
+	if err := render.DecodeJSON(io.TeeReader(http.MaxBytesReader(w, r.Body, hardBodyLimit), &body), &comment); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind comment", rest.ErrDecode)
 		return
 	}
 
 	user := rest.MustGetUserInfo(r)
+	if s.audNormalizer != nil {
+		comment.Locator.SiteID, user.SiteID = s.audNormalizer(comment.Locator.SiteID), s.audNormalizer(user.SiteID)
+	}
 	if user.ID != "admin" && user.SiteID != comment.Locator.SiteID {
 		rest.SendErrorJSON(w, r, http.StatusForbidden,
 			fmt.Errorf("site mismatch, %q not allowed to post to %s", user.SiteID, comment.Locator.SiteID), "invalid site",
@@ -120,16 +168,50 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !user.Admin && !user.EmailVerified && confirmedEmailOnly(s.confirmedEmail, comment.Locator.SiteID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("email of %s not confirmed", user.ID),
+			"confirm your email to comment", rest.ErrEmailNotConfirmed)
+		return
+	}
+
+	if err := s.origin.check(r, comment.Locator); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "origin doesn't match the post", rest.ErrOriginMismatch)
+		return
+	}
+
+	if err := s.preview.check(r, comment.Locator); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "preview token required", rest.ErrPreviewDenied)
+		return
+	}
+
 	comment.PrepareUntrusted() // clean all fields user not supposed to set
 	comment.User = user
+
+	// bot filled hidden field, comment dropped but responded as created to not reveal the trap
+	if strings.HasPrefix(user.ID, "anonymous_") && s.honeypot.filled(comment.Locator.SiteID, body.Bytes()) {
+		log.Printf("[INFO] comment from %s to %s dropped, honeypot field filled", user.ID, comment.Locator.URL)
+		comment.ID, comment.Timestamp, comment.Orig = uuid.New().String(), time.Now(), comment.Text
+		comment = s.commentFormatter.Format(comment, s.disableFancyTextFormatting)
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, &comment)
+		return
+	}
 	comment.User.IP = strings.Split(r.RemoteAddr, ":")[0]
 
 	comment.Orig = comment.Text // original comment text, prior to md render
+	// language not declared by client detected by the language preferred in the browser
+	if comment.Lang == "" {
+		comment.Lang = service.LangFromHeader(r.Header.Get("Accept-Language"))
+	}
 	if err := s.dataService.ValidateComment(&comment); err != nil {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentValidation)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", parseError(err, rest.ErrCommentValidation))
 		return
 	}
 	comment = s.commentFormatter.Format(comment, s.disableFancyTextFormatting)
+	if err := s.dataService.ValidateRendered(&comment); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", parseError(err, rest.ErrCommentValidation))
+		return
+	}
 
 	// check if images are valid, omit proxied images as they are lazy-loaded
 	for _, id := range s.imageService.ExtractNonProxiedPictures(comment.Text) {
@@ -156,6 +238,32 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentRestrictWords)
 		return
 	}
+	if errors.Is(err, service.ErrAnonNameReserved) {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "name is taken by another anonymous user", rest.ErrAnonNameReserved)
+		return
+	}
+	if errors.Is(err, service.ErrThreadLocked) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "thread locked", rest.ErrThreadLocked)
+		return
+	}
+	if errors.Is(err, service.ErrQuoteNotFound) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid quote", rest.ErrQuoteNotFound)
+		return
+	}
+	if cooldownErr := (service.CooldownError{}); errors.As(err, &cooldownErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(cooldownErr.Wait.Seconds()))))
+		rest.SendErrorJSON(w, r, http.StatusTooManyRequests, err, "comment posted too soon", rest.ErrCommentCooldown)
+		return
+	}
+	if errors.Is(err, service.ErrCommentHeld) {
+		// comment not stored until approved, respond with accepted comment as is
+		s.dataService.DeleteDraft(comment.Locator, comment.User.ID)
+		comment.ID, comment.User.IP = id, ""
+		log.Printf("[DEBUG] comment %s held for review", id)
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, &comment)
+		return
+	}
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't save comment", rest.ErrInternal)
 		return
@@ -170,9 +278,12 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
 		Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
 
+	s.dataService.DeleteDraft(comment.Locator, comment.User.ID)
+
 	if s.notifyService != nil {
 		s.notifyService.Submit(notify.Request{Comment: finalComment})
 	}
+	s.publishLive(finalComment)
 
 	log.Printf("[DEBUG] created comment %+v", finalComment)
 
@@ -180,11 +291,143 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, &finalComment)
 }
 
+// POST /review?site=siteID&id=commentID&tkn=token&action=approve|reject - approves or rejects comment held for review.
+// Comment hidden after reports reviewed the same way, with url=post-url added.
+// Called by external review system with callback url sent to the review webhook
+func (s *private) reviewHeldCtrl(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	action := query.Get("action")
+	if action != "approve" && action != "reject" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("unknown action %q", action),
+			"action should be approve or reject", rest.ErrDecode)
+		return
+	}
+
+	var comment store.Comment
+	var err error
+	if query.Get("url") != "" {
+		locator := store.Locator{SiteID: query.Get("site"), URL: query.Get("url")}
+		comment, err = s.dataService.ReviewReported(locator, query.Get("id"), query.Get("tkn"), action == "approve")
+		if errors.Is(err, service.ErrNotHidden) {
+			rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't review comment", rest.ErrCommentNotFound)
+			return
+		}
+	} else {
+		comment, err = s.dataService.ReviewHeld(query.Get("site"), query.Get("id"), query.Get("tkn"), action == "approve")
+	}
+	if errors.Is(err, service.ErrReviewToken) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "can't review comment", rest.ErrNoAccess)
+		return
+	}
+	if errors.Is(err, service.ErrHeldNotFound) {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't review comment", rest.ErrCommentNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrAnonNameReserved) {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "name is taken by another anonymous user", rest.ErrAnonNameReserved)
+		return
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't review comment", rest.ErrInternal)
+		return
+	}
+	modAction := service.ModActionReject
+	if action == "approve" {
+		modAction = service.ModActionApprove
+	}
+	logModeration(s.modLog, service.ModLogEntry{SiteID: query.Get("site"), Action: modAction, Moderator: service.ModReviewer,
+		UserID: comment.User.ID, CommentID: query.Get("id"), URL: comment.Locator.URL})
+
+	if query.Get("url") != "" { // reported comment shown back or deleted, already notified about when created
+		s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
+			Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
+		render.JSON(w, r, R.JSON{"id": comment.ID, "action": action})
+		return
+	}
+	if action == "approve" {
+		s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
+			Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
+		if s.notifyService != nil {
+			s.notifyService.Submit(notify.Request{Comment: comment})
+		}
+	}
+	render.JSON(w, r, R.JSON{"id": comment.ID, "action": action})
+}
+
+// OnHeldExpired records the action on held comment not reviewed in time, published comment handled
+// the same way as approved by reviewer. Set as HeldExpired of DataStore.
+func (s *Rest) OnHeldExpired(comment store.Comment, published bool) {
+	modAction := service.ModActionReject
+	if published {
+		modAction = service.ModActionApprove
+	}
+	logModeration(s.ModLog, service.ModLogEntry{SiteID: comment.Locator.SiteID, Action: modAction, Moderator: service.ModExpiry,
+		UserID: comment.User.ID, CommentID: comment.ID, URL: comment.Locator.URL})
+	if !published {
+		return
+	}
+	if s.Cache != nil {
+		s.Cache.Flush(cache.Flusher(comment.Locator.SiteID).
+			Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
+	}
+	if s.NotifyService != nil {
+		s.NotifyService.Submit(notify.Request{Comment: comment})
+	}
+}
+
+// GET /draft?site=siteID&url=post-url - get user's comment draft for the post
+func (s *private) getDraftCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	draft, ok := s.dataService.GetDraft(locator, user.ID)
+	if !ok {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, fmt.Errorf("no draft for %s", locator.URL), "draft not found", rest.ErrCommentNotFound)
+		return
+	}
+	render.JSON(w, r, draft)
+}
+
+// PUT /draft?site=siteID&url=post-url - save user's comment draft for the post, body is {"text": "draft text"}
+func (s *private) saveDraftCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if locator.URL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("empty url"), "missing post url", rest.ErrDecode)
+		return
+	}
+
+	req := struct {
+		Text string `json:"text"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind draft", rest.ErrDecode)
+		return
+	}
+
+	draft, err := s.dataService.SaveDraft(locator, user.ID, req.Text)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't save draft", rest.ErrCommentValidation)
+		return
+	}
+	render.JSON(w, r, draft)
+}
+
+// DELETE /draft?site=siteID&url=post-url - remove user's comment draft for the post
+func (s *private) deleteDraftCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	s.dataService.DeleteDraft(locator, user.ID)
+	render.JSON(w, r, R.JSON{"deleted": true})
+}
+
 // PUT /comment/{id}?site=siteID&url=post-url - update comment
+// admins can edit comments of other users, with reason required
 func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	edit := struct {
 		Text    string
 		Summary string
+		Reason  string
 		Delete  bool
 	}{}
 
@@ -206,18 +449,33 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if currComment.User.ID != user.ID {
+	if currComment.User.ID != user.ID && !user.Admin {
 		rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("rejected"),
 			"can not edit comments for other users", rest.ErrNoAccess)
 		return
 	}
 
 	editReq := service.EditRequest{
-		Text:    s.commentFormatter.FormatText(edit.Text, s.disableFancyTextFormatting),
+		Text:    s.commentFormatter.FormatSiteText(locator.SiteID, edit.Text, s.disableFancyTextFormatting),
 		Orig:    edit.Text,
 		Summary: edit.Summary,
 		Delete:  edit.Delete,
 		Admin:   user.Admin,
+		UserID:  user.ID,
+		Reason:  edit.Reason,
+	}
+
+	if !edit.Delete {
+		// validate edited comment the same way as the new one
+		edited := currComment
+		edited.Orig, edited.Text = editReq.Orig, editReq.Text
+		if err = s.dataService.ValidateComment(&edited); err == nil {
+			err = s.dataService.ValidateRendered(&edited)
+		}
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", parseError(err, rest.ErrCommentValidation))
+			return
+		}
 	}
 
 	res, err := s.dataService.EditComment(locator, id, editReq)
@@ -225,6 +483,15 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentValidation)
 		return
 	}
+	if errors.Is(err, service.ErrEditReasonRequired) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "edit reason required", rest.ErrEditReasonRequired)
+		return
+	}
+
+	if errors.Is(err, service.ErrThreadLocked) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "thread locked", rest.ErrThreadLocked)
+		return
+	}
 
 	if err != nil {
 		code := parseError(err, rest.ErrCommentRejected)
@@ -232,6 +499,44 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if currComment.User.ID != user.ID { // moderator's action on comment of other user
+		action := service.ModActionEdit
+		if edit.Delete {
+			action = service.ModActionDelete
+		}
+		logModeration(s.modLog, service.ModLogEntry{SiteID: locator.SiteID, Action: action, Moderator: user.ID,
+			UserID: currComment.User.ID, CommentID: id, URL: locator.URL, Reason: strings.TrimSpace(edit.Reason)})
+	}
+
+	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, user.ID, currComment.User.ID))
+	render.JSON(w, r, res)
+}
+
+// PUT /comment/{id}/redact?site=siteID&url=post-url - clears text of user's own comment, keeping the comment with replies
+func (s *private) redactCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+
+	log.Printf("[DEBUG] redact comment %s by %s", id, user.ID)
+
+	currComment, err := s.dataService.Get(locator, id, rest.GetUserOrEmpty(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't find comment", rest.ErrCommentNotFound)
+		return
+	}
+	if currComment.User.ID != user.ID {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("rejected"),
+			"can not redact comments of other users", rest.ErrNoAccess)
+		return
+	}
+
+	res, err := s.dataService.Redact(locator, id, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't redact comment", rest.ErrCommentRejected)
+		return
+	}
+
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, user.ID))
 	render.JSON(w, r, res)
 }
@@ -287,14 +592,87 @@ func (s *private) voteCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 	comment, err := s.dataService.Vote(req)
 	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrVotingFrozen) || errors.Is(err, service.ErrThreadLocked) {
+			status = http.StatusForbidden
+		}
 		code := parseError(err, rest.ErrVoteRejected)
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't vote for comment", code)
+		rest.SendErrorJSON(w, r, status, err, "can't vote for comment", code)
 		return
 	}
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
 	render.JSON(w, r, R.JSON{"id": comment.ID, "score": comment.Score})
 }
 
+// PUT /react/{id}?site=siteID&url=post-url&emoji=👍&set=1 - adds emoji reaction to comment, set=0 removes it
+func (s *private) reactCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	if !s.anonVote && strings.HasPrefix(user.ID, "anonymous_") { // anonymous reactions allowed the same way as votes
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	log.Printf("[DEBUG] react to comment %s", id)
+
+	if s.isReadOnly(locator) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("rejected"), "old post, read-only", rest.ErrReadOnly)
+		return
+	}
+
+	// check if user blocked
+	if s.dataService.IsBlocked(locator.SiteID, user.ID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("rejected"), "user blocked", rest.ErrUserBlocked)
+		return
+	}
+
+	req := service.ReactReq{
+		Locator:   locator,
+		CommentID: id,
+		UserID:    user.ID,
+		Emoji:     r.URL.Query().Get("emoji"),
+		Set:       r.URL.Query().Get("set") != "0",
+	}
+	comment, err := s.dataService.React(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrThreadLocked) {
+			status = http.StatusForbidden
+		}
+		rest.SendErrorJSON(w, r, status, err, "can't react to comment", parseError(err, rest.ErrActionRejected))
+		return
+	}
+	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
+	render.JSON(w, r, R.JSON{"id": comment.ID, "reactions": comment.Reactions})
+}
+
+// PUT /report/{id}?site=siteID&url=post-url - reports abusive comment, repeated reports of the same user counted once.
+// Comment hidden pending review once reported by enough users.
+func (s *private) reportCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	if !s.anonVote && strings.HasPrefix(user.ID, "anonymous_") { // anonymous reports allowed the same way as votes
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	log.Printf("[DEBUG] report comment %s", id)
+
+	// check if user blocked
+	if s.dataService.IsBlocked(locator.SiteID, user.ID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("rejected"), "user blocked", rest.ErrUserBlocked)
+		return
+	}
+
+	comment, err := s.dataService.Report(locator, id, user)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't report comment", parseError(err, rest.ErrActionRejected))
+		return
+	}
+	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, lastCommentsScope, comment.User.ID, locator.SiteID))
+	render.JSON(w, r, R.JSON{"id": comment.ID, "hidden": comment.Hidden})
+}
+
 // getEmailCtrl gets email address for authenticated user.
 // GET /email?site=siteID
 func (s *private) getEmailCtrl(w http.ResponseWriter, r *http.Request) {
@@ -509,6 +887,7 @@ func (s *private) setEmail(w http.ResponseWriter, r *http.Request, userID, siteI
 		return
 	}
 	claims.User.Email = address
+	rest.SetEmailVerified(claims.User)
 	if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
 		return
@@ -574,12 +953,175 @@ func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 	if claims.User != nil && claims.User.Email != "" {
 		claims.User.Email = ""
+		rest.SetEmailVerified(claims.User)
 		if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
 			rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
 			return
 		}
 	}
 
+	renderUnsubscribed(w, r)
+}
+
+// GET/POST /email/unsubscribe-post.html?site=siteID&tkn=jwt - mute email notifications about replies for the post.
+// Doesn't require login, user, email and post are taken from the signed token. Repeated requests are not rejected.
+func (s *private) emailUnsubscribePostCtrl(w http.ResponseWriter, r *http.Request) {
+	tkn := r.URL.Query().Get("tkn")
+	if tkn == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest, fmt.Errorf("missing parameter"), "token parameter is required", rest.ErrInternal)
+		return
+	}
+
+	confClaims, err := s.authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, err, "failed to verify confirmation token", rest.ErrInternal)
+		return
+	}
+
+	if s.authenticator.TokenService().IsExpired(confClaims) {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, fmt.Errorf("expired"), "failed to verify confirmation token", rest.ErrInternal)
+		return
+	}
+
+	// Handshake.ID is "unsub-post::" + user.ID + "::" + address + "::" + post url, tokens of other purpose rejected
+	if confClaims.Handshake == nil {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest, fmt.Errorf("no handshake"), "invalid handshake token", rest.ErrInternal)
+		return
+	}
+	handshakeID, ok := strings.CutPrefix(confClaims.Handshake.ID, "unsub-post::")
+	elems := strings.SplitN(handshakeID, "::", 3)
+	if !ok || len(elems) != 3 || elems[0] == "" || elems[2] == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest, fmt.Errorf("%s", confClaims.Handshake.ID), "invalid handshake token", rest.ErrInternal)
+		return
+	}
+	userID, postURL := elems[0], elems[2]
+	locator := store.Locator{SiteID: confClaims.Audience, URL: postURL}
+
+	log.Printf("[DEBUG] unsubscribe user %s from post %s", userID, postURL)
+	if err = s.dataService.SetUnsubscribed(locator, userID, true); err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't unsubscribe from the post", rest.ErrInternal)
+		return
+	}
+	renderUnsubscribed(w, r)
+}
+
+// POST /email/follow?site=siteID&url=post-url - sends link to follow the post to email, body is {"email": "address"}.
+// Doesn't require login, subscription made once the link opened. Followers get notifications about all new comments
+// of the post, follow token from the link gives no other privileges.
+func (s *private) followCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if !s.emailNotifications {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("email notifications disabled"), "can't follow the post", rest.ErrActionRejected)
+		return
+	}
+	req := struct {
+		Email string `json:"email"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse request body", rest.ErrDecode)
+		return
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid email address", rest.ErrDecode)
+		return
+	}
+	if locator.URL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("missing parameter"), "url parameter is required", rest.ErrDecode)
+		return
+	}
+	if _, err = s.dataService.Info(locator, s.readOnlyAge); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't follow unknown post", rest.ErrPostNotFound)
+		return
+	}
+
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: "follow::" + addr.Address + "::" + locator.URL},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  locator.SiteID,
+			ExpiresAt: time.Now().Add(30 * time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+	tkn, err := s.authenticator.TokenService().Token(claims)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to make follow token", rest.ErrInternal)
+		return
+	}
+
+	s.notifyService.SubmitVerification(notify.VerificationRequest{
+		SiteID:       locator.SiteID,
+		User:         addr.Address,
+		Email:        addr.Address,
+		Token:        tkn,
+		SubscribeURL: s.remarkURL + "/email/follow.html?site=" + url.QueryEscape(locator.SiteID) + "&tkn=",
+	})
+	render.JSON(w, r, R.JSON{"email": addr.Address, "url": locator.URL, "updated": false})
+}
+
+// GET/POST /email/follow.html?site=siteID&tkn=jwt - confirms following of the post by email from the signed token
+func (s *private) followConfirmCtrl(w http.ResponseWriter, r *http.Request) {
+	email, locator, err := s.parseFollowToken(r.URL.Query().Get("tkn"))
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, err, "failed to verify follow token", rest.ErrInternal)
+		return
+	}
+
+	log.Printf("[DEBUG] follow post %s by email", locator.URL)
+	if err = s.dataService.Follow(locator, email); err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't follow the post", rest.ErrInternal)
+		return
+	}
+	renderEmailPage(w, r, "email_follow.html.tmpl")
+}
+
+// GET/POST /email/unfollow.html?site=siteID&tkn=jwt - stops notifications about the post for email from the signed token.
+// Repeated requests are not rejected.
+func (s *private) unfollowCtrl(w http.ResponseWriter, r *http.Request) {
+	email, locator, err := s.parseFollowToken(r.URL.Query().Get("tkn"))
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, err, "failed to verify follow token", rest.ErrInternal)
+		return
+	}
+
+	log.Printf("[DEBUG] unfollow post %s by email", locator.URL)
+	if err = s.dataService.Unfollow(locator, email); err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't unfollow the post", rest.ErrInternal)
+		return
+	}
+	renderUnsubscribed(w, r)
+}
+
+// parseFollowToken returns email and post of the follow token, Handshake.ID is "follow::" + address + "::" + post url
+func (s *private) parseFollowToken(tkn string) (email string, locator store.Locator, err error) {
+	if tkn == "" {
+		return "", store.Locator{}, fmt.Errorf("missing token")
+	}
+	claims, err := s.authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		return "", store.Locator{}, err
+	}
+	if s.authenticator.TokenService().IsExpired(claims) {
+		return "", store.Locator{}, fmt.Errorf("expired")
+	}
+	if claims.Handshake == nil {
+		return "", store.Locator{}, fmt.Errorf("no handshake")
+	}
+	elems := strings.SplitN(claims.Handshake.ID, "::", 3)
+	if len(elems) != 3 || elems[0] != "follow" || elems[1] == "" || elems[2] == "" {
+		return "", store.Locator{}, fmt.Errorf("invalid handshake %s", claims.Handshake.ID)
+	}
+	return elems[1], store.Locator{SiteID: claims.Audience, URL: elems[2]}, nil
+}
+
+// renderUnsubscribed renders successful unsubscription page
+func renderUnsubscribed(w http.ResponseWriter, r *http.Request) {
+	renderEmailPage(w, r, "email_unsubscribe.html.tmpl")
+}
+
+// renderEmailPage renders static page opened by the link from email
+func renderEmailPage(w http.ResponseWriter, r *http.Request, name string) {
 	// MustExecute behaves like template.Execute, but panics if an error occurs.
 	MustExecute := func(tmpl *template.Template, wr io.Writer, data interface{}) {
 		if err := tmpl.Execute(wr, data); err != nil {
@@ -593,8 +1135,8 @@ func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 		return string(file)
 	}
-	tmplstr := MustRead("email_unsubscribe.html.tmpl")
-	tmpl := template.Must(template.New("unsubscribe").Parse(tmplstr))
+	tmplstr := MustRead(name)
+	tmpl := template.Must(template.New(name).Parse(tmplstr))
 	msg := bytes.Buffer{}
 	MustExecute(tmpl, &msg, nil)
 	render.HTML(w, r, msg.String())
@@ -641,16 +1183,97 @@ func (s *private) deleteTelegramCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"deleted": true})
 }
 
-// GET /userdata?site=siteID - exports all data about the user as a json with user info and list of all comments
+// GET /notify/prefs?site=siteID - get user's notification preferences for the site
+func (s *private) getNotifyPrefsCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	prefs, err := s.dataService.GetNotifyPrefs(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get notification preferences", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, prefs)
+}
+
+// PUT /notify/prefs?site=siteID - set user's notification preferences for the site,
+// body is {"replies": true, "mentions": true, "digest": false}, omitted fields keep current values
+func (s *private) setNotifyPrefsCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	prefs, err := s.dataService.GetNotifyPrefs(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get notification preferences", rest.ErrInternal)
+		return
+	}
+	if err = render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &prefs); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind notification preferences", rest.ErrDecode)
+		return
+	}
+	log.Printf("[DEBUG] set notification preferences for user %s to %+v", user.ID, prefs)
+
+	if prefs, err = s.dataService.SetNotifyPrefs(siteID, user.ID, prefs); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set notification preferences", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, prefs)
+}
+
+// GET /userdata?site=siteID - exports all data about the user as a gzipped json with user info, comments, details and votes
 func (s *private) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 	user := rest.MustGetUserInfo(r)
+	writeUserData(w, r, s.dataService, siteID, user)
+}
+
+// userDataStore defines methods needed to export all data of the user
+type userDataStore interface {
+	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
+	GetUserEmail(siteID, userID string) (string, error)
+	GetUserTelegram(siteID, userID string) (string, error)
+	UserVotes(siteID, userID string) ([]service.UserVote, error)
+}
+
+// writeUserData sends all data of the user for the site as a gzipped json attachment. Only user's own data included:
+// user info, comments made by the user, user's email and telegram, and votes made by the user.
+// Comments requested and sent in pages of 100.
+func writeUserData(w http.ResponseWriter, r *http.Request, ds userDataStore, siteID string, user store.User) {
 	userB, err := json.Marshal(&user)
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't marshal user info", rest.ErrInternal)
 		return
 	}
 
+	details := struct {
+		Email    string `json:"email,omitempty"`
+		Telegram string `json:"telegram,omitempty"`
+	}{}
+	if details.Email, err = ds.GetUserEmail(siteID, user.ID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get user email", rest.ErrInternal)
+		return
+	}
+	if details.Telegram, err = ds.GetUserTelegram(siteID, user.ID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get user telegram", rest.ErrInternal)
+		return
+	}
+	detailsB, err := json.Marshal(details)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't marshal user details", rest.ErrInternal)
+		return
+	}
+
+	votes, err := ds.UserVotes(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get user votes", rest.ErrInternal)
+		return
+	}
+	votesB, err := json.Marshal(votes)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't marshal user votes", rest.ErrInternal)
+		return
+	}
+
 	exportFile := fmt.Sprintf("%s-%s-%s.json.gz", siteID, user.ID, time.Now().Format("20060102"))
 	w.Header().Set("Content-Type", "application/gzip")
 	w.Header().Set("Content-Disposition", "attachment;filename="+exportFile)
@@ -667,29 +1290,39 @@ func (s *private) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var merr error
-	merr = multierror.Append(merr, write([]byte(`{"info": `)))     // send user prefix
-	merr = multierror.Append(merr, write(userB))                   // send user info
-	merr = multierror.Append(merr, write([]byte(`, "comments":`))) // send comments prefix
+	merr = multierror.Append(merr, write([]byte(`{"info": `)))      // send user prefix
+	merr = multierror.Append(merr, write(userB))                    // send user info
+	merr = multierror.Append(merr, write([]byte(`, "comments":[`))) // send comments prefix
 
 	// get comments in 100 in each paginated request
+	count := 0
 	for i := 0; i < 100; i++ {
-		comments, errUser := s.dataService.User(siteID, user.ID, 100, i*100, rest.GetUserOrEmpty(r))
+		comments, errUser := ds.User(siteID, user.ID, 100, i*100, user)
 		if errUser != nil {
 			rest.SendErrorJSON(w, r, http.StatusInternalServerError, errUser, "can't get user comments", rest.ErrInternal)
 			return
 		}
-		b, errUser := json.Marshal(comments)
-		if errUser != nil {
-			rest.SendErrorJSON(w, r, http.StatusInternalServerError, errUser, "can't marshal user comments", rest.ErrInternal)
-			return
+		for _, c := range comments {
+			b, errUser := json.Marshal(c)
+			if errUser != nil {
+				rest.SendErrorJSON(w, r, http.StatusInternalServerError, errUser, "can't marshal user comments", rest.ErrInternal)
+				return
+			}
+			if count > 0 {
+				merr = multierror.Append(merr, write([]byte(`,`)))
+			}
+			merr = multierror.Append(merr, write(b))
+			count++
 		}
-
-		merr = multierror.Append(merr, write(b))
 		if len(comments) != 100 {
 			break
 		}
 	}
 
+	merr = multierror.Append(merr, write([]byte(`], "details": `)))
+	merr = multierror.Append(merr, write(detailsB))
+	merr = multierror.Append(merr, write([]byte(`, "votes": `)))
+	merr = multierror.Append(merr, write(votesB))
 	merr = multierror.Append(merr, write([]byte(`}`)))
 	if merr.(*multierror.Error).ErrorOrNil() != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, merr, "can't write user info", rest.ErrInternal)
@@ -754,6 +1387,47 @@ func (s *private) savePictureCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"id": id})
 }
 
+// POST /user/avatar?site=siteID - uploads avatar of the user in place of the provider's one, multipart form
+// with "file" field. Sets the token with the new avatar and returns its url.
+func (s *private) uploadAvatarCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.avatarUpload == nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, fmt.Errorf("avatar upload disabled"), "avatar upload disabled", rest.ErrActionRejected)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, rest.MaxAvatarUploadSize+hardBodyLimit)
+	if err := r.ParseMultipartForm(rest.MaxAvatarUploadSize); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse multipart form", rest.ErrDecode)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get avatar file from the request", rest.ErrDecode)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	claims, _, err := s.authenticator.TokenService().Get(r)
+	if err != nil || claims.User == nil {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, err, "can't get token", rest.ErrNoAccess)
+		return
+	}
+	picture, err := s.avatarUpload.Put(&claims, file)
+	if err != nil {
+		if errors.Is(err, rest.ErrNoAvatar) {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, err, "avatar disabled for the user", rest.ErrActionRejected)
+			return
+		}
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't save avatar", rest.ErrDecode)
+		return
+	}
+	if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"picture": picture})
+}
+
 func (s *private) isReadOnly(locator store.Locator) bool {
 	if s.readOnlyAge > 0 {
 		// check RO by age
@@ -11,7 +11,9 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -25,9 +27,11 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/rest/proxy"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/service"
 )
 
 // gopher png for test, from https://golang.org/src/image/png/example_test.go
@@ -56,6 +60,290 @@ func TestRest_Create(t *testing.T) {
 	assert.True(t, len(c["id"].(string)) > 8)
 }
 
+func TestRest_CreateReservedAnonName(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.ReserveAnonNames = true
+
+	anonToken := func(id, name string) string {
+		claims := token.Claims{
+			User: &token.User{ID: id, Name: name},
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "remark42",
+				ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+				NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+				Issuer:    "remark42",
+			},
+		}
+		tkn, err := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, err)
+		return tkn
+	}
+
+	postComment := func(tkn string) (code int, body string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(b)
+	}
+
+	code, _ := postComment(anonToken("anonymous_1", "John Doe"))
+	assert.Equal(t, http.StatusCreated, code, "name reserved on first use")
+	code, _ = postComment(anonToken("anonymous_1", "John Doe"))
+	assert.Equal(t, http.StatusCreated, code, "original client reuses the name")
+
+	code, body := postComment(anonToken("anonymous_2", "John Doe"))
+	assert.Equal(t, http.StatusConflict, code, "name taken by another client")
+	assert.Equal(t, `{"code":21,"details":"name is taken by another anonymous user","error":"anonymous name reserved by another user"}`+"\n", body)
+}
+
+func TestRest_CreateHoneypot(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.HoneypotFields = map[string]string{"remark42": "website"}
+	})
+	defer teardown()
+
+	postComment := func(tkn, honeypot string) (code int, body string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}`+honeypot+`}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(b)
+	}
+	count := func() int {
+		comments, _ := srv.DataService.Find(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, "time", store.User{})
+		return len(comments) // no post stored until the first comment created
+	}
+
+	// filled honeypot silently drops the comment
+	code, body := postComment(anonToken, `, "website": "https://spam.example.com"`)
+	require.Equal(t, http.StatusCreated, code, body)
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	assert.NotEmpty(t, c.ID, "looks like created comment")
+	assert.Equal(t, "<p>test 123</p>\n", c.Text)
+	assert.Equal(t, 0, count(), "comment dropped")
+	body, code = get(t, ts.URL+"/api/v1/id/"+c.ID+"?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusNotFound, code, body)
+
+	// empty honeypot proceeds
+	code, body = postComment(anonToken, `, "website": ""`)
+	assert.Equal(t, http.StatusCreated, code, body)
+	assert.Equal(t, 1, count())
+	code, body = postComment(anonToken, "")
+	assert.Equal(t, http.StatusCreated, code, body)
+	assert.Equal(t, 2, count())
+
+	// checked for anonymous users only
+	code, body = postComment(devToken, `, "website": "https://example.com"`)
+	assert.Equal(t, http.StatusCreated, code, body)
+	assert.Equal(t, 3, count())
+
+	cfg, code := get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, cfg, `"honeypot_field":"website"`)
+}
+
+func TestRest_CreateEmailVerified(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	makeToken := func(id string, emailVerified bool) string {
+		claims := token.Claims{
+			User: &token.User{ID: id, Name: id, Attributes: map[string]interface{}{"email_verified": emailVerified}},
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "remark42",
+				ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+				NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+				Issuer:    "remark42",
+			},
+		}
+		tkn, err := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, err)
+		return tkn
+	}
+
+	postComment := func(tkn, body string) R.JSON {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		res := R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return res
+	}
+
+	c := postComment(makeToken("email_confirmed", true), `{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	assert.Equal(t, true, c["user"].(map[string]interface{})["email_verified"], "confirmed user carries the flag")
+
+	c = postComment(makeToken("github_other", false), `{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	assert.Nil(t, c["user"].(map[string]interface{})["email_verified"], "not confirmed user has no flag")
+
+	c = postComment(makeToken("github_other", false),
+		`{"text": "test 123", "user": {"id": "github_other", "email_verified": true}, "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	assert.Nil(t, c["user"].(map[string]interface{})["email_verified"], "client can't set the flag")
+
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Equal(t, 3, len(comments.Comments))
+	for _, c := range comments.Comments {
+		assert.Equal(t, c.User.ID == "email_confirmed", c.User.EmailVerified, "flag in author metadata for %s", c.User.ID)
+	}
+}
+
+func TestRest_CreateConfirmedEmailOnly(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.ConfirmedEmail = []string{"remark42"}
+	})
+	defer teardown()
+
+	makeToken := func(id string, emailVerified bool) string {
+		claims := token.Claims{
+			User: &token.User{ID: id, Name: id, Attributes: map[string]interface{}{"email_verified": emailVerified}},
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "remark42",
+				ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+				NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+				Issuer:    "remark42",
+			},
+		}
+		tkn, err := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, err)
+		return tkn
+	}
+
+	postComment := func(tkn string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := postComment(makeToken("email_confirmed", true))
+	assert.Equal(t, http.StatusCreated, code, res)
+
+	code, res = postComment(makeToken("github_other", false))
+	assert.Equal(t, http.StatusForbidden, code, res)
+	assert.Equal(t, "confirm your email to comment", res["details"])
+	assert.Equal(t, float64(rest.ErrEmailNotConfirmed), res["code"])
+
+	code, res = postComment(anonToken)
+	assert.Equal(t, http.StatusForbidden, code, res, "anonymous user has no confirmed email")
+
+	cfg, code := get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, cfg, `"confirmed_email_only":true`)
+
+	srv.privRest.confirmedEmail = []string{"other"}
+	code, res = postComment(makeToken("github_other", false))
+	assert.Equal(t, http.StatusCreated, code, res, "not required for the site")
+	srv.privRest.confirmedEmail = []string{AllSitesConfirmedEmail}
+	code, res = postComment(makeToken("github_other", false))
+	assert.Equal(t, http.StatusForbidden, code, res, "required for all sites")
+}
+
+func TestRest_CreateBreaker(t *testing.T) {
+	ts, _, teardown := startupT(t, func(srv *Rest) {
+		srv.Breaker = BreakerParams{Limit: 2, Window: time.Minute, Cooldown: 500 * time.Millisecond}
+	})
+	defer teardown()
+
+	postComment := func(tkn string) (code int, retry string, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, resp.Header.Get("Retry-After"), res
+	}
+
+	for i := 0; i < 2; i++ {
+		code, _, res := postComment(devToken)
+		assert.Equal(t, http.StatusCreated, code, "comment %d within the limit, %v", i, res)
+	}
+	code, retry, res := postComment(devToken)
+	assert.Equal(t, http.StatusServiceUnavailable, code, "tripped over the limit, %v", res)
+	assert.Equal(t, float64(rest.ErrCommentsSuspended), res["code"])
+	assert.Equal(t, "1", retry)
+	code, _, res = postComment(devToken)
+	assert.Equal(t, http.StatusServiceUnavailable, code, "rejected during cool-down, %v", res)
+	code, _, res = postComment(adminUmputunToken)
+	assert.Equal(t, http.StatusCreated, code, "admin not suspended, %v", res)
+
+	time.Sleep(600 * time.Millisecond)
+	code, _, res = postComment(devToken)
+	assert.Equal(t, http.StatusCreated, code, "recovered after cool-down, %v", res)
+}
+
+func TestRest_CreateStrictOrigin(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.StrictOrigin = []string{"remark42"}
+	})
+	defer teardown()
+
+	postComment := func(origin string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := postComment("https://radio-t.com")
+	assert.Equal(t, http.StatusCreated, code, "origin of the post's host, %v", res)
+	code, res = postComment("https://Radio-T.com")
+	assert.Equal(t, http.StatusCreated, code, "host compared case-insensitive, %v", res)
+
+	code, res = postComment("https://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, code, res)
+	assert.Equal(t, "origin doesn't match the post", res["details"])
+	assert.Equal(t, float64(rest.ErrOriginMismatch), res["code"])
+	code, res = postComment("")
+	assert.Equal(t, http.StatusForbidden, code, "no origin, %v", res)
+	code, res = postComment("null")
+	assert.Equal(t, http.StatusForbidden, code, "opaque origin, %v", res)
+
+	srv.AllowedOrigins = map[string][]string{"remark42": {"https://mirror.radio-t.com"}}
+	code, res = postComment("https://mirror.radio-t.com")
+	assert.Equal(t, http.StatusCreated, code, "allowed origin of the site, %v", res)
+	srv.AllowedOrigins = nil
+
+	srv.privRest.origin.sites = []string{"other"}
+	code, res = postComment("https://evil.example.com")
+	assert.Equal(t, http.StatusCreated, code, "not required for the site, %v", res)
+	srv.privRest.origin.sites = []string{AllSitesStrictOrigin}
+	code, res = postComment("https://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, code, "required for all sites, %v", res)
+}
+
 // based on issue https://github.com/umputun/remark42/issues/1292
 func TestRest_CreateFilteredCode(t *testing.T) {
 	ts, _, teardown := startupT(t)
@@ -228,6 +516,241 @@ func TestRest_CreateTooBig(t *testing.T) {
 	assert.Equal(t, "can't bind comment", c["details"])
 }
 
+func TestRest_CommentSizeLimits(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.MinCommentSize = 3
+	srv.DataService.MaxCommentSize = 10
+
+	createComment := func(text string) (R.JSON, int) {
+		resp, err := post(t, ts.URL+"/api/v1/comment",
+			fmt.Sprintf(`{"text": %q, "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`, text))
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		c := R.JSON{}
+		require.NoError(t, json.Unmarshal(b, &c))
+		return c, resp.StatusCode
+	}
+
+	c, code := createComment("   ")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "empty comment text", c["error"])
+	assert.Equal(t, float64(22), c["code"])
+
+	c, code = createComment("Щ😀")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "comment text is smaller than min allowed size 3 (2)", c["error"])
+	assert.Equal(t, float64(22), c["code"])
+
+	c, code = createComment("ЩЩЩЩЩ😀😀😀😀😀Щ")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "comment text exceeded max allowed size 10 (11)", c["error"])
+	assert.Equal(t, float64(23), c["code"])
+
+	c, code = createComment("ЩЩЩЩЩ😀😀😀😀😀")
+	assert.Equal(t, http.StatusCreated, code, "10 characters allowed, even with 30 bytes")
+	id := c["id"].(string)
+
+	// rendered comment limit
+	srv.DataService.MaxRenderedSize = 30
+	c, code = createComment("**bold**")
+	assert.Equal(t, http.StatusCreated, code, "<p><strong>bold</strong></p>\\n is 29 characters")
+	c, code = createComment("**bolder**")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "rendered comment exceeded max allowed size 30 (31)", c["error"])
+	assert.Equal(t, float64(23), c["code"])
+
+	// edit enforces the same limits
+	updateComment := func(body string) (R.JSON, int) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+			strings.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		c := R.JSON{}
+		require.NoError(t, json.Unmarshal(b, &c))
+		return c, resp.StatusCode
+	}
+
+	c, code = updateComment(`{"text":"ab"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "comment text is smaller than min allowed size 3 (2)", c["error"])
+	assert.Equal(t, float64(22), c["code"])
+
+	c, code = updateComment(`{"text":"ЩЩЩЩЩЩЩЩЩЩЩ"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "comment text exceeded max allowed size 10 (11)", c["error"])
+	assert.Equal(t, float64(23), c["code"])
+
+	c, code = updateComment(`{"text":"**ЩЩЩЩЩЩ**"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "rendered comment exceeded max allowed size 30 (31)", c["error"])
+	assert.Equal(t, float64(23), c["code"])
+
+	c, code = updateComment(`{"text":"ЩЩЩЩЩЩЩЩЩЩ"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ЩЩЩЩЩЩЩЩЩЩ", c["orig"])
+
+	_, code = updateComment(`{"delete":true}`)
+	assert.Equal(t, http.StatusOK, code, "delete not validated")
+}
+
+func TestRest_CreateWithImagesLimit(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.MaxImages = map[string]int{"remark42": 2}
+
+	createComment := func(text string) (R.JSON, int) {
+		resp, err := post(t, ts.URL+"/api/v1/comment",
+			fmt.Sprintf(`{"text": %q, "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`, text))
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		c := R.JSON{}
+		require.NoError(t, json.Unmarshal(b, &c))
+		return c, resp.StatusCode
+	}
+
+	c, code := createComment("two images ![](https://example.com/1.png) and ![](https://example.com/2.png)")
+	require.Equal(t, http.StatusCreated, code, "at the limit")
+	id := c["id"].(string)
+
+	uploaded := srv.RemarkURL + "/api/v1/picture/dev/pic.png"
+	c, code = createComment("three images ![](https://example.com/1.png) ![](https://example.com/2.png) ![](" + uploaded + ")")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "comment exceeded max allowed number of images 2 (3)", c["error"])
+	assert.Equal(t, float64(24), c["code"])
+
+	// edit enforces the same limit
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"![](https://example.com/1.png) ![](https://example.com/2.png) ![](https://example.com/3.png)"}`))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, string(b))
+	assert.Contains(t, string(b), "comment exceeded max allowed number of images 2 (3)")
+
+	// other sites not limited
+	srv.DataService.MaxImages = map[string]int{"other": 0}
+	_, code = createComment("three images ![](https://example.com/1.png) ![](https://example.com/2.png) ![](https://example.com/3.png)")
+	assert.Equal(t, http.StatusCreated, code)
+}
+
+func TestRest_CreateWithBlockedName(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.BlockedNames = map[string][]string{"remark42": {"dеvеl0pеr"}} // with cyrillic "е"
+
+	body := `{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken) // user "developer one"
+	require.NoError(t, err)
+	c := R.JSON{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, `user name is not allowed: "developer one"`, c["error"])
+	assert.Equal(t, float64(25), c["code"])
+
+	// admin not checked
+	resp, err = post(t, ts.URL+"/api/v1/comment", body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestRest_CreateWithAllowedScripts(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.AllowedScripts = map[string][]string{"remark42": {"Latin"}}
+
+	createComment := func(text string) (R.JSON, int) {
+		resp, err := post(t, ts.URL+"/api/v1/comment",
+			fmt.Sprintf(`{"text": %q, "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`, text))
+		require.NoError(t, err)
+		c := R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		require.NoError(t, resp.Body.Close())
+		return c, resp.StatusCode
+	}
+
+	_, code := createComment("**Nice** post, thanks! 👍 see https://example.com")
+	assert.Equal(t, http.StatusCreated, code)
+
+	c, code := createComment("buy cheap 手表 here")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "comment contains characters of not allowed script: Han", c["error"])
+	assert.Equal(t, float64(32), c["code"])
+}
+
+func TestRest_CreateWithCooldown(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.ThreadCooldown = time.Minute
+
+	create := func() (R.JSON, *http.Response) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		c := R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		require.NoError(t, resp.Body.Close())
+		return c, resp
+	}
+
+	_, resp := create()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	c, resp := create()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "comment posted too soon after the previous one, wait 1m0s", c["error"])
+	assert.Equal(t, float64(33), c["code"])
+}
+
+func TestRest_CreateWithQuote(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.MaxQuoteSize = 100
+
+	pid := addComment(t, store.Comment{Text: "the **quick** brown fox", Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}}, ts)
+
+	create := func(quote string) (R.JSON, *http.Response) {
+		body := fmt.Sprintf(`{"text": "reply", "pid": %q, "quote": %q, "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`, pid, quote)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		c := R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		require.NoError(t, resp.Body.Close())
+		return c, resp
+	}
+
+	c, resp := create("quick brown")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "quick brown", c["quote"], "genuine quote of rendered text stored")
+
+	c, resp = create("slow brown")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "quote not found in the parent comment", c["error"])
+	assert.Equal(t, float64(34), c["code"])
+}
+
 func TestRest_CreateWithRestrictedWord(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -248,6 +771,91 @@ func TestRest_CreateWithRestrictedWord(t *testing.T) {
 	assert.Equal(t, "invalid comment", c["details"])
 }
 
+func TestRest_CreateHeldForReview(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	callbacks := make(chan string, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Comment  store.Comment `json:"comment"`
+			Callback string        `json:"callback"`
+		}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "What the duck is that?", req.Comment.Orig)
+		cb, err := url.Parse(req.Callback)
+		assert.NoError(t, err)
+		callbacks <- ts.URL + cb.Path + "?" + cb.RawQuery
+	}))
+	defer webhook.Close()
+	srv.DataService.Reviewer = &service.ReviewWebhook{URL: webhook.URL, RemarkURL: srv.RemarkURL}
+
+	create := func(text string) (store.Comment, int) {
+		resp, err := post(t, ts.URL+"/api/v1/comment",
+			`{"text": "`+text+`", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		c := store.Comment{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		return c, resp.StatusCode
+	}
+	review := func(callback, action string) (R.JSON, int) {
+		resp, err := post(t, callback+"&action="+action, "")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res := R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return res, resp.StatusCode
+	}
+	listed := func() []string {
+		res := []string{}
+		body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+		require.Equal(t, http.StatusOK, code)
+		comments := commentsWithInfo{}
+		require.NoError(t, json.Unmarshal([]byte(body), &comments))
+		for _, c := range comments.Comments {
+			res = append(res, c.ID)
+		}
+		return res
+	}
+
+	// comments with restricted words held and not listed
+	held1, code := create("What the duck is that?")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.NotEmpty(t, held1.ID)
+	assert.Empty(t, held1.User.IP)
+	callback1 := <-callbacks
+	held2, code := create("What the duck is that?")
+	assert.Equal(t, http.StatusAccepted, code)
+	callback2 := <-callbacks
+	assert.Empty(t, listed())
+
+	// invalid review requests
+	res, code := review(callback1, "maybe")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, float64(rest.ErrDecode), res["code"])
+	res, code = review(strings.Replace(callback1, "tkn=", "tkn=bad", 1), "approve")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "invalid review token", res["error"])
+	assert.Empty(t, listed())
+
+	// approved comment listed, rejected dropped
+	res, code = review(callback1, "approve")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, R.JSON{"id": held1.ID, "action": "approve"}, res)
+	res, code = review(callback2, "reject")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, R.JSON{"id": held2.ID, "action": "reject"}, res)
+	assert.Equal(t, []string{held1.ID}, listed())
+
+	// already reviewed
+	time.Sleep(time.Second) // avoid hitting rate limiter
+	res, code = review(callback2, "approve")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, float64(rest.ErrCommentNotFound), res["code"])
+	assert.Equal(t, []string{held1.ID}, listed())
+}
+
 func TestRest_CreateRelativeURL(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -660,6 +1268,141 @@ func TestRest_UpdateNotOwner(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, b.StatusCode, string(body), "update is not json")
 }
 
+func TestRest_UpdateModerator(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, User: store.User{ID: "xyz", Name: "xyz"}}
+	id1, err := srv.DataService.Create(c1)
+	require.NoError(t, err)
+
+	update := func(body string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id1+
+			"?site=remark42&url=https://radio-t.com/blah1", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := update(`{"text":"updated text", "summary":"my edit"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "moderator's edit without reason rejected")
+	assert.Equal(t, float64(rest.ErrEditReasonRequired), res["code"])
+	assert.Equal(t, "edit reason required", res["details"])
+
+	code, res = update(`{"text":"updated text", "summary":"my edit", "reason":"offensive language"}`)
+	assert.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, "<p>updated text</p>\n", res["text"])
+	assert.Equal(t, "offensive language", res["edit"].(map[string]interface{})["reason"])
+
+	versions, err := srv.DataService.CommentHistory(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, id1)
+	require.NoError(t, err)
+	require.NotEmpty(t, versions)
+	assert.Equal(t, "offensive language", versions[len(versions)-1].Reason)
+}
+
+func TestRest_Redact(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", Locator: locator,
+		User: store.User{ID: "provider1_dev", Name: "developer one"}})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "reply", ParentID: id1, Locator: locator,
+		User: store.User{ID: "xyz", Name: "xyz"}})
+	require.NoError(t, err)
+
+	redact := func(id, token string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"/redact?site=remark42&url=https://radio-t.com/blah1", http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, token)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := redact(id2, devToken)
+	assert.Equal(t, http.StatusForbidden, code, "other user's comment can't be redacted")
+	assert.Equal(t, float64(rest.ErrNoAccess), res["code"])
+	code, _ = redact(id1, adminUmputunToken)
+	assert.Equal(t, http.StatusForbidden, code, "admin can't redact other user's comment")
+
+	code, res = redact(id1, devToken)
+	assert.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, "", res["text"])
+	assert.Equal(t, true, res["redacted"])
+
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Len(t, comments.Comments, 2, "redacted comment and reply kept")
+	for _, c := range comments.Comments {
+		if c.ID == id1 {
+			assert.True(t, c.Redacted)
+			assert.False(t, c.Deleted)
+			assert.Equal(t, "", c.Text)
+			assert.Equal(t, "provider1_dev", c.User.ID, "author kept")
+			continue
+		}
+		assert.Equal(t, id1, c.ParentID)
+		assert.Equal(t, "reply", c.Text)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id1+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"updated text"}`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "redacted comment can't be edited")
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestRest_UpdateReplied(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", Locator: locator,
+		User: store.User{ID: "provider1_dev", Name: "developer one"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{Text: "reply", ParentID: id1, Locator: locator,
+		User: store.User{ID: "xyz", Name: "xyz"}})
+	require.NoError(t, err)
+
+	update := func(body, tkn string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id1+
+			"?site=remark42&url=https://radio-t.com/blah1", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := update(`{"text":"updated text"}`, devToken)
+	assert.Equal(t, http.StatusBadRequest, code, "author's edit of replied comment rejected, %v", res)
+	assert.Equal(t, float64(rest.ErrCommentEditChanged), res["code"])
+
+	code, res = update(`{"text":"updated text", "reason":"offensive language"}`, adminUmputunToken)
+	assert.Equal(t, http.StatusOK, code, "moderator's edit allowed, %v", res)
+	assert.Equal(t, "<p>updated text</p>\n", res["text"])
+
+	srv.DataService.ReplyEdit = map[string]string{"remark42": service.ReplyEditAllow}
+	code, res = update(`{"text":"updated again"}`, devToken)
+	assert.Equal(t, http.StatusOK, code, "allowed by the site's policy, %v", res)
+	assert.Equal(t, "<p>updated again</p>\n", res["text"])
+}
+
 func TestRest_UpdateWrongAud(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -707,6 +1450,188 @@ func TestRest_UpdateWithRestrictedWords(t *testing.T) {
 	assert.Equal(t, "invalid comment", c["details"])
 }
 
+func TestRest_VoteSelf(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	id := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts) // posted with devToken
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/vote/%s?site=remark42&url=https://radio-t.com/blah&vote=1", ts.URL, id), http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), `"code":13`, "vote for own comment rejected")
+
+	resp, err = sendReq(t, req, dev2Token)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "vote of another user allowed")
+}
+
+func TestRest_Report(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	callbacks := make(chan string, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Comment  store.Comment `json:"comment"`
+			Callback string        `json:"callback"`
+			Reported bool          `json:"reported"`
+		}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.True(t, req.Reported)
+		assert.Equal(t, "<p>abusive text</p>\n", req.Comment.Text)
+		cb, err := url.Parse(req.Callback)
+		assert.NoError(t, err)
+		callbacks <- ts.URL + cb.Path + "?" + cb.RawQuery
+	}))
+	defer webhook.Close()
+	srv.DataService.Reviewer = &service.ReviewWebhook{URL: webhook.URL, RemarkURL: srv.RemarkURL}
+	srv.DataService.ReportThreshold = 2
+
+	id := addComment(t, store.Comment{Text: "abusive text",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}, ts)
+
+	report := func(tkn string) (R.JSON, int) {
+		req, err := http.NewRequest(http.MethodPut,
+			fmt.Sprintf("%s/api/v1/report/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id), http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res := R.JSON{}
+		_ = json.NewDecoder(resp.Body).Decode(&res) // not json for rejected auth
+		return res, resp.StatusCode
+	}
+	find := func() store.Comment {
+		body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain")
+		require.Equal(t, http.StatusOK, code)
+		comments := commentsWithInfo{}
+		require.NoError(t, json.Unmarshal([]byte(body), &comments))
+		require.Len(t, comments.Comments, 1)
+		return comments.Comments[0]
+	}
+
+	res, code := report(devToken)
+	assert.Equal(t, http.StatusBadRequest, code, "own comment can't be reported")
+	assert.Equal(t, "can't report comment", res["details"])
+	_, code = report("")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// repeated reports of the same user counted once
+	for i := 0; i < 2; i++ {
+		res, code = report(dev2Token)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, R.JSON{"id": id, "hidden": false}, res)
+	}
+	assert.Equal(t, "<p>abusive text</p>\n", find().Text)
+	assert.Empty(t, callbacks, "moderators not notified below threshold")
+
+	// hidden at threshold and moderators notified
+	res, code = report(emailUserToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, R.JSON{"id": id, "hidden": true}, res)
+	c := find()
+	assert.True(t, c.Hidden)
+	assert.Empty(t, c.Text, "text hidden from readers")
+	assert.Empty(t, c.Reports)
+	var callback string
+	select {
+	case callback = <-callbacks:
+	case <-time.After(time.Second):
+		t.Fatal("no review request sent")
+	}
+	assert.Contains(t, callback, "url=https%3A%2F%2Fradio-t.com%2Fblah")
+
+	// shown back once approved by moderator
+	resp, err := post(t, callback+"&action=approve", "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	c = find()
+	assert.False(t, c.Hidden)
+	assert.Equal(t, "<p>abusive text</p>\n", c.Text)
+	resp, err = post(t, callback+"&action=approve", "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "already reviewed")
+
+	// hidden and shown back by admin
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/hide/%s?site=remark42&url=https://radio-t.com/blah&hide=1", ts.URL, id), http.NoBody)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, find().Hidden)
+	time.Sleep(100 * time.Millisecond) // admin routes rate limited
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/hide/%s?site=remark42&url=https://radio-t.com/blah&hide=0", ts.URL, id), http.NoBody)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, find().Hidden)
+
+	_, code = report(anonToken)
+	assert.Equal(t, http.StatusForbidden, code, "anonymous reports not allowed without anonymous votes")
+}
+
+func TestRest_React(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+
+	react := func(id, emoji, set string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/v1/react/%s?site=remark42&url=https://radio-t.com/blah&emoji=%s&set=%s",
+			ts.URL, id, url.QueryEscape(emoji), set), http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, dev2Token)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := react(id2, "👍", "1")
+	assert.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, map[string]interface{}{"👍": 1.0}, res["reactions"])
+	code, res = react(id2, "👍", "1")
+	assert.Equal(t, http.StatusBadRequest, code, "repeated reaction rejected, %v", res)
+	code, res = react(id2, "not emoji", "1")
+	assert.Equal(t, http.StatusBadRequest, code, "invalid reaction rejected, %v", res)
+	code, res = react(id1, "🎉", "1")
+	assert.Equal(t, http.StatusOK, code, res)
+	code, res = react(id1, "🎉", "0")
+	assert.Equal(t, http.StatusOK, code, res)
+	assert.Nil(t, res["reactions"], "reaction removed")
+
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain&sort=-reactions")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	require.Len(t, comments.Comments, 2)
+	assert.Equal(t, id2, comments.Comments[0].ID, "most reacted first")
+	assert.Equal(t, map[string]int{"👍": 1}, comments.Comments[0].Reactions)
+	assert.Nil(t, comments.Comments[0].Reacted, "users reacted hidden")
+	assert.Equal(t, id1, comments.Comments[1].ID)
+}
+
 func TestRest_Vote(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -945,6 +1870,173 @@ func TestRest_EmailAndTelegram(t *testing.T) {
 	}
 }
 
+func TestRest_EmailUnsubscribePost(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	makeToken := func(handshakeID string, expiresAt time.Time) string {
+		claims := token.Claims{
+			Handshake: &token.Handshake{ID: handshakeID},
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "remark42",
+				ExpiresAt: expiresAt.Unix(),
+				NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+				Issuer:    "remark42",
+			},
+		}
+		tkn, err := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, err)
+		return tkn
+	}
+	postURL := "https://radio-t.com/blah1"
+	locator := store.Locator{SiteID: "remark42", URL: postURL}
+	goodToken := makeToken("unsub-post::provider1_dev::good@example.com::"+postURL, time.Now().Add(10*time.Minute))
+
+	// tampered token, payload changed without re-signing
+	parts := strings.Split(goodToken, ".")
+	require.Len(t, parts, 3)
+	tamperedToken := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"remark42","handshake":{"id":"other::bad@example.com::`+postURL+`"}}`)) + "." + parts[2]
+
+	var testData = []struct {
+		description  string
+		url          string
+		method       string
+		responseCode int
+	}{
+		{description: "no token", url: "/email/unsubscribe-post.html?site=remark42", method: http.MethodPost, responseCode: http.StatusBadRequest},
+		{description: "wrong token", url: "/email/unsubscribe-post.html?site=remark42&tkn=jwt", method: http.MethodGet, responseCode: http.StatusForbidden},
+		{description: "tampered token", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + tamperedToken, method: http.MethodGet, responseCode: http.StatusForbidden},
+		{description: "expired token", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + makeToken("unsub-post::provider1_dev::good@example.com::"+postURL, time.Now().Add(-time.Minute)), method: http.MethodGet, responseCode: http.StatusForbidden},
+		{description: "token without post", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + makeToken("unsub-post::provider1_dev::good@example.com", time.Now().Add(time.Minute)), method: http.MethodGet, responseCode: http.StatusBadRequest},
+		{description: "token without purpose", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + makeToken("provider1_dev::good@example.com::"+postURL, time.Now().Add(time.Minute)), method: http.MethodGet, responseCode: http.StatusBadRequest},
+		{description: "follow token", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + makeToken("follow::good@example.com::"+postURL, time.Now().Add(time.Minute)), method: http.MethodGet, responseCode: http.StatusBadRequest},
+		{description: "good token", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + goodToken, method: http.MethodPost, responseCode: http.StatusOK},
+		{description: "good token second time", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + goodToken, method: http.MethodPost, responseCode: http.StatusOK},
+		{description: "good token with get", url: "/email/unsubscribe-post.html?site=remark42&tkn=" + goodToken, method: http.MethodGet, responseCode: http.StatusOK},
+	}
+	client := http.Client{}
+	defer client.CloseIdleConnections()
+	for _, x := range testData {
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			req, err := http.NewRequest(x.method, ts.URL+x.url, http.NoBody)
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.NoError(t, resp.Body.Close())
+			assert.Equal(t, x.responseCode, resp.StatusCode, string(body))
+			if x.responseCode == http.StatusOK {
+				assert.Contains(t, string(body), "Successfully unsubscribed")
+			}
+		})
+	}
+
+	assert.True(t, srv.DataService.IsUnsubscribed(locator, "provider1_dev"))
+	assert.False(t, srv.DataService.IsUnsubscribed(locator, "other"))
+	assert.False(t, srv.DataService.IsUnsubscribed(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}, "provider1_dev"))
+}
+
+func TestRest_FollowByEmail(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+	srv.privRest.emailNotifications = true
+
+	postURL := "https://radio-t.com/blah-follow"
+	locator := store.Locator{SiteID: "remark42", URL: postURL}
+	parentID := addComment(t, store.Comment{Text: "first comment", Locator: locator}, ts)
+
+	follow := func(site, url, body string) (string, int) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/email/follow?site="+site+"&url="+url, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(b), resp.StatusCode
+	}
+	page := func(path, tkn string) (string, int) {
+		return get(t, ts.URL+path+"?site=remark42&tkn="+tkn)
+	}
+
+	// follow request sends confirmation link to the email
+	res, code := follow("remark42", postURL, `{"email":"reader@example.com"}`)
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, `{"email":"reader@example.com","updated":false,"url":"https://radio-t.com/blah-follow"}`+"\n", res)
+	time.Sleep(time.Millisecond * 30)
+	require.Len(t, mockDestination.GetVerify(), 1)
+	verification := mockDestination.GetVerify()[0]
+	assert.Equal(t, "reader@example.com", verification.Email)
+	assert.Equal(t, "remark42", verification.SiteID)
+	assert.Equal(t, srv.RemarkURL+"/email/follow.html?site=remark42&tkn=", verification.SubscribeURL)
+	followToken := verification.Token
+
+	_, code = follow("remark42", postURL, `{"email":"bad email"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = follow("remark42", "https://radio-t.com/unknown", `{"email":"reader@example.com"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	srv.privRest.emailNotifications = false
+	_, code = follow("remark42", postURL, `{"email":"reader@example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "email notifications disabled")
+	srv.privRest.emailNotifications = true
+	time.Sleep(time.Millisecond * 30)
+	assert.Len(t, mockDestination.GetVerify(), 1, "no confirmation for rejected requests")
+
+	// follow token gives no session privileges
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/user?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, followToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// not confirmed follower not notified
+	addComment(t, store.Comment{Text: "reply before confirmation", ParentID: parentID, Locator: locator}, ts)
+	time.Sleep(time.Millisecond * 30)
+	require.Len(t, mockDestination.Get(), 2)
+	assert.Empty(t, mockDestination.Get()[1].Followers)
+
+	// confirmed follower notified about new comments
+	res, code = page("/email/follow.html", followToken)
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Contains(t, res, "Successfully subscribed to new comments")
+	addComment(t, store.Comment{Text: "reply after confirmation", ParentID: parentID, Locator: locator}, ts)
+	time.Sleep(time.Millisecond * 30)
+	require.Len(t, mockDestination.Get(), 3)
+	assert.Equal(t, []string{"reader@example.com"}, mockDestination.Get()[2].Followers)
+
+	// other tokens rejected
+	_, code = page("/email/follow.html", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	_, code = page("/email/unfollow.html", "bad-token")
+	assert.Equal(t, http.StatusForbidden, code)
+	claims := token.Claims{
+		Handshake:      &token.Handshake{ID: "provider1_dev::reader@example.com::" + postURL},
+		StandardClaims: jwt.StandardClaims{Audience: "remark42", ExpiresAt: time.Now().Add(time.Minute).Unix(), Issuer: "remark42"},
+	}
+	unsubscribeToken, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	_, code = page("/email/unfollow.html", unsubscribeToken)
+	assert.Equal(t, http.StatusForbidden, code, "post unsubscribe token is not a follow token")
+
+	// unfollow stops notifications, repeated requests are not rejected
+	res, code = page("/email/unfollow.html", followToken)
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Contains(t, res, "Successfully unsubscribed")
+	_, code = page("/email/unfollow.html", followToken)
+	assert.Equal(t, http.StatusOK, code)
+	addComment(t, store.Comment{Text: "reply after unfollow", ParentID: parentID, Locator: locator}, ts)
+	time.Sleep(time.Millisecond * 30)
+	require.Len(t, mockDestination.Get(), 4)
+	assert.Empty(t, mockDestination.Get()[3].Followers)
+}
+
 func TestRest_EmailNotification(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -1457,7 +2549,7 @@ func TestRest_UserAllDataManyComments(t *testing.T) {
 	c := store.Comment{User: user, Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
 		URL: "https://radio-t.com/blah1"}, Timestamp: time.Date(2018, 5, 27, 1, 14, 10, 0, time.Local)}
 
-	for i := 0; i < 51; i++ {
+	for i := 0; i < 151; i++ {
 		c.ID = fmt.Sprintf("id-%03d", i)
 		c.Timestamp = c.Timestamp.Add(time.Second)
 		_, err := srv.DataService.Create(c)
@@ -1481,7 +2573,73 @@ func TestRest_UserAllDataManyComments(t *testing.T) {
 	strUngzBody := string(ungzBody)
 	assert.True(t, strings.HasPrefix(strUngzBody,
 		`{"info": {"name":"developer one","id":"provider1_dev","picture":"http://example.com/pic.png","ip":"127.0.0.1","admin":false,"site_id":"remark42"}, "comments":[{`))
-	assert.Equal(t, 51, strings.Count(strUngzBody, `"text":`), "51 comments inside")
+	assert.Equal(t, 151, strings.Count(strUngzBody, `"text":`), "151 comments inside")
+
+	parsed := struct {
+		Comments []store.Comment `json:"comments"`
+	}{}
+	require.NoError(t, json.Unmarshal(ungzBody, &parsed), "valid json with comments from multiple pages")
+	assert.Equal(t, 151, len(parsed.Comments))
+}
+
+func TestRest_UserAllDataContent(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	dev := store.User{ID: "provider1_dev", Name: "developer one"}
+	dev2 := store.User{ID: "provider1_dev2", Name: "developer two"}
+	_, err := srv.DataService.Create(store.Comment{ID: "c1", User: dev, Text: "dev comment", Locator: locator})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{ID: "c2", User: dev2, Text: "dev2 comment", Locator: locator})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{ID: "c3", User: dev2, Text: "dev2 other comment", Locator: locator})
+	require.NoError(t, err)
+	_, err = srv.DataService.Vote(service.VoteReq{Locator: locator, CommentID: "c2", UserID: dev.ID, Val: true})
+	require.NoError(t, err)
+	_, err = srv.DataService.Vote(service.VoteReq{Locator: locator, CommentID: "c1", UserID: dev2.ID, Val: false})
+	require.NoError(t, err)
+	_, err = srv.DataService.SetUserEmail("remark42", dev.ID, "dev@example.com")
+	require.NoError(t, err)
+	_, err = srv.DataService.SetUserEmail("remark42", dev2.ID, "dev2@example.com")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/userdata?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "remark42-provider1_dev-")
+	ungzReader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(ungzReader)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	parsed := struct {
+		Info     store.User         `json:"info"`
+		Comments []store.Comment    `json:"comments"`
+		Details  map[string]string  `json:"details"`
+		Votes    []service.UserVote `json:"votes"`
+	}{}
+	require.NoError(t, json.Unmarshal(body, &parsed), string(body))
+	assert.Equal(t, "provider1_dev", parsed.Info.ID)
+	require.Equal(t, 1, len(parsed.Comments))
+	assert.Equal(t, "c1", parsed.Comments[0].ID)
+	assert.Nil(t, parsed.Comments[0].Votes, "voters of user's comments not exported")
+	assert.Equal(t, map[string]string{"email": "dev@example.com"}, parsed.Details)
+	assert.Equal(t, []service.UserVote{{Locator: locator, CommentID: "c2", Value: true}}, parsed.Votes)
+	assert.NotContains(t, string(body), "dev2 comment")
+	assert.NotContains(t, string(body), "dev2@example.com")
+	assert.NotContains(t, string(body), "provider1_dev2")
+
+	// export is rate limited
+	req, err = http.NewRequest("GET", ts.URL+"/api/v1/userdata?site=remark42", http.NoBody)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
 }
 
 func TestRest_DeleteMe(t *testing.T) {
@@ -1592,6 +2750,80 @@ func TestRest_SavePictureCtrl(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
 
+func TestRest_UploadAvatarCtrl(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) {
+		srv.AvatarUpload = true
+	})
+	defer teardown()
+
+	upload := func(tkn string) *http.Response {
+		bodyBuf := &bytes.Buffer{}
+		bodyWriter := multipart.NewWriter(bodyBuf)
+		fileWriter, err := bodyWriter.CreateFormFile("file", "avatar.png")
+		require.NoError(t, err)
+		_, err = io.Copy(fileWriter, gopherPNG())
+		require.NoError(t, err)
+		require.NoError(t, bodyWriter.Close())
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/user/avatar?site=remark42", bodyBuf)
+		require.NoError(t, err)
+		req.Header.Add("Content-Type", bodyWriter.FormDataContentType())
+		req.Header.Add("X-JWT", tkn)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := upload(devToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	res := R.JSON{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	picture, ok := res["picture"].(string)
+	require.True(t, ok, res)
+
+	// token set with uploaded avatar
+	jwtSet := false
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			claims, e := srv.Authenticator.TokenService().Parse(c.Value)
+			require.NoError(t, e)
+			assert.Equal(t, picture, claims.User.Picture)
+			jwtSet = true
+		}
+	}
+	assert.True(t, jwtSet)
+
+	// uploaded avatar served
+	resp, err := http.Get(ts.URL + "/api/v1/avatar/" + path.Base(picture))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", http.DetectContentType(body))
+
+	// user logged in with noava can't upload avatar
+	noAvaToken, err := srv.Authenticator.TokenService().Token(token.Claims{
+		User: &token.User{ID: "provider1_dev", Name: "developer one"}, NoAva: true,
+		StandardClaims: jwt.StandardClaims{Audience: "remark42", Issuer: "remark42",
+			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(), NotBefore: time.Now().Add(-1 * time.Minute).Unix()},
+	})
+	require.NoError(t, err)
+	resp = upload(noAvaToken)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = upload(anonToken)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "anonymous user can't upload avatar")
+
+	srv.privRest.avatarUpload = nil
+	resp = upload(devToken)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "upload disabled")
+}
+
 func TestRest_CreateWithPictures(t *testing.T) {
 	ts, svc, teardown := startupT(t)
 	defer func() {
@@ -1692,3 +2924,142 @@ func (m *mockTelegram) CheckToken(string, string) (telegram, site string, err er
 	}
 	return "good_telegram", m.site, nil
 }
+
+func TestRest_Draft(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	draftURL := ts.URL + "/api/v1/draft?site=remark42&url=https://radio-t.com/blah1"
+	saveDraft := func(tkn, text string) int {
+		req, err := http.NewRequest(http.MethodPut, draftURL, strings.NewReader(`{"text":"`+text+`"}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	body, code := getWithDevAuth(t, draftURL)
+	assert.Equal(t, http.StatusNotFound, code, body)
+
+	assert.Equal(t, http.StatusOK, saveDraft(devToken, "draft text"))
+	body, code = getWithDevAuth(t, draftURL)
+	require.Equal(t, http.StatusOK, code, body)
+	draft := service.Draft{}
+	require.NoError(t, json.Unmarshal([]byte(body), &draft))
+	assert.Equal(t, "draft text", draft.Text)
+	assert.False(t, draft.Timestamp.IsZero())
+
+	// overwrite
+	assert.Equal(t, http.StatusOK, saveDraft(devToken, "updated draft text"))
+	body, code = getWithDevAuth(t, draftURL)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &draft))
+	assert.Equal(t, "updated draft text", draft.Text)
+
+	// other user can't read the draft, and has own one
+	body, code = getWithDev2Auth(t, draftURL)
+	assert.Equal(t, http.StatusNotFound, code, body)
+	assert.Equal(t, http.StatusOK, saveDraft(dev2Token, "dev2 draft"))
+	body, code = getWithDev2Auth(t, draftURL)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &draft))
+	assert.Equal(t, "dev2 draft", draft.Text)
+	body, code = getWithDevAuth(t, draftURL)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &draft))
+	assert.Equal(t, "updated draft text", draft.Text, "not changed by other user")
+
+}
+
+func TestRest_DraftDelete(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	draftURL := ts.URL + "/api/v1/draft?site=remark42&url=https://radio-t.com/blah1"
+	saveDraft := func(tkn, text string) int {
+		req, err := http.NewRequest(http.MethodPut, draftURL, strings.NewReader(`{"text":"`+text+`"}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, saveDraft(devToken, "draft text"))
+	assert.Equal(t, http.StatusOK, saveDraft(dev2Token, "dev2 draft"))
+
+	// draft of other post
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/draft?site=remark42&url=https://radio-t.com/blah2")
+	assert.Equal(t, http.StatusNotFound, code, body)
+
+	// no auth
+	body, code = get(t, draftURL)
+	assert.Equal(t, http.StatusUnauthorized, code, body)
+
+	// posted comment removes the draft
+	addComment(t, store.Comment{Text: "test 123", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+	body, code = getWithDevAuth(t, draftURL)
+	assert.Equal(t, http.StatusNotFound, code, body)
+	_, code = getWithDev2Auth(t, draftURL)
+	assert.Equal(t, http.StatusOK, code, "other user's draft kept")
+
+	// delete
+	req, err := http.NewRequest(http.MethodDelete, draftURL, http.NoBody)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, dev2Token)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, code = getWithDev2Auth(t, draftURL)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// too large
+	assert.Equal(t, http.StatusBadRequest, saveDraft(devToken, strings.Repeat("x", 5000)))
+}
+
+func TestRest_NotifyPrefs(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	prefsURL := ts.URL + "/api/v1/notify/prefs?site=remark42"
+	setPrefs := func(tkn, body string) (int, string) {
+		req, err := http.NewRequest(http.MethodPut, prefsURL, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(b)
+	}
+
+	body, code := getWithDevAuth(t, prefsURL)
+	require.Equal(t, http.StatusOK, code, body)
+	prefs := store.NotifyPrefs{}
+	require.NoError(t, json.Unmarshal([]byte(body), &prefs))
+	assert.Equal(t, store.DefaultNotifyPrefs, prefs)
+
+	code, body = setPrefs(devToken, `{"replies": false}`)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &prefs))
+	assert.Equal(t, store.NotifyPrefs{Replies: false, Mentions: true}, prefs, "omitted fields kept")
+
+	code, body = setPrefs(devToken, `{"digest": true}`)
+	require.Equal(t, http.StatusOK, code, body)
+	body, code = getWithDevAuth(t, prefsURL)
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &prefs))
+	assert.Equal(t, store.NotifyPrefs{Replies: false, Mentions: true, Digest: true}, prefs)
+
+	stored, err := srv.DataService.GetNotifyPrefs("remark42", "provider1_dev")
+	require.NoError(t, err)
+	assert.Equal(t, prefs, stored)
+
+	code, _ = setPrefs(devToken, `{bad`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = setPrefs(anonToken, `{"replies": false}`)
+	assert.Equal(t, http.StatusForbidden, code, "anonymous users rejected")
+	code, _ = setPrefs("", `{"replies": false}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
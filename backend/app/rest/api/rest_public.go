@@ -2,11 +2,16 @@ package api
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/sha1" // nolint
 	"encoding/base64"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -30,15 +35,27 @@ type public struct {
 	readOnlyAge      int
 	commentFormatter *store.CommentFormatter
 	imageService     *image.Service
+	markerSecret     string
+	remarkURL        string
+	sitemapPageSize  int
+	maxTreeComments  int // soft cap of comments in tree format, see service.Tree.Cap
+	maxCountsPosts   int // max posts in one request of comment counts, unlimited if 0
+	userFields       userFieldsFilter
+	live             *liveHub // nil if live updates disabled
+	preview          previewGuard
 }
 
+var errParentNotFound = errors.New("parent comment not found")
+
 type pubStore interface {
 	Create(comment store.Comment) (commentID string, err error)
 	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+	GetWithAncestors(locator store.Locator, commentID string, user store.User) (store.Comment, []store.Comment, error)
 	FindSince(locator store.Locator, sort string, user store.User, since time.Time) ([]store.Comment, error)
 	Last(siteID string, limit int, since time.Time, user store.User) ([]store.Comment, error)
 	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
 	UserCount(siteID, userID string) (int, error)
+	Search(req service.SearchRequest, user store.User) ([]service.SearchResult, error)
 	Count(locator store.Locator) (int, error)
 	List(siteID string, limit, skip int) ([]store.PostInfo, error)
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
@@ -48,9 +65,15 @@ type pubStore interface {
 	Counts(siteID string, postIDs []string) ([]store.PostInfo, error)
 }
 
-// GET /find?site=siteID&url=post-url&format=[tree|plain]&sort=[+/-time|+/-score|+/-controversy]&view=[user|all]&since=unix_ts_msec
+// GET /find?site=siteID&url=post-url&format=[tree|plain|collapsed]&sort=[+/-time|+/-score|+/-controversy|+/-reactions|+/-reactions:emoji]&view=[user|all]&since=unix_ts_msec&limit=N&cursor=next_cursor
 // find comments for given post. Returns in tree or plain formats, sorted
 //
+// Collapsed format returns top-level comments only, each with the number of direct and all replies,
+// replies loaded with /replies/{id} on demand.
+//
+// Plain format paginated with limit, next_cursor of the response passed as cursor to get the next page.
+// Cursor points to the last comment of the page, so pages don't shift when new comments added.
+//
 // When `url` parameter is not set (e.g. request is for site-wide comments), does not return deleted comments.
 func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
@@ -66,9 +89,17 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	format := r.URL.Query().Get("format")
-	if format == "tree" {
+	if format == "tree" || format == "collapsed" {
 		since = time.Time{} // since doesn't make sense for tree
 	}
+	lang := r.URL.Query().Get("lang")
+	limit, cursor := 0, r.URL.Query().Get("cursor")
+	if v := r.URL.Query().Get("limit"); v != "" && format != "tree" && format != "collapsed" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v), "can't parse limit", rest.ErrDecode)
+			return
+		}
+	}
 
 	log.Printf("[DEBUG] get comments for %+v, sort %s, format %s, since %v", locator, sort, format, since)
 
@@ -78,14 +109,19 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		if e != nil {
 			comments = []store.Comment{} // error should clear comments and continue for post info
 		}
-		comments = s.applyView(comments, view)
+		comments = service.FilterLang(comments, lang)
+		var nextCursor string
+		if comments, nextCursor, e = service.PageComments(comments, sort, cursor, limit, locator.URL != ""); e != nil {
+			return nil, e
+		}
+		comments = s.userFields.comments(s.applyView(comments, view), rest.GetUserOrEmpty(r))
 
 		var commentsInfo store.PostInfo
 		if info, ee := s.dataService.Info(locator, s.readOnlyAge); ee == nil {
 			commentsInfo = info
 		}
 
-		if !since.IsZero() { // if since is set, number of comments can be different from total in the DB
+		if !since.IsZero() || lang != "" { // if since or lang is set, number of comments can be different from total in the DB
 			commentsInfo.Count = 0
 			for _, c := range comments {
 				if !c.Deleted {
@@ -103,17 +139,27 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		switch format {
 		case "tree":
 			withInfo := treeWithInfo{Tree: service.MakeTree(comments, sort), Info: commentsInfo}
+			if omitted := withInfo.Cap(s.maxTreeComments); omitted > 0 {
+				log.Printf("[DEBUG] %d replies of the oldest threads omitted from tree of %+v", omitted, locator)
+			}
 			if withInfo.Nodes == nil { // eliminate json nil serialization
 				withInfo.Nodes = []*service.Node{}
 			}
 			b, e = encodeJSONWithHTML(withInfo)
+		case "collapsed":
+			withInfo := collapsedWithInfo{Comments: service.MakeTree(comments, sort).Collapse(), Info: &commentsInfo}
+			b, e = encodeJSONWithHTML(withInfo)
 		default:
-			withInfo := commentsWithInfo{Comments: comments, Info: commentsInfo}
+			withInfo := commentsWithInfo{Comments: comments, Info: commentsInfo, NextCursor: nextCursor}
 			b, e = encodeJSONWithHTML(withInfo)
 		}
 		return b, e
 	})
 
+	if errors.Is(err, service.ErrInvalidCursor) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't use cursor", rest.ErrDecode)
+		return
+	}
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't find comments", rest.ErrCommentNotFound)
 		return
@@ -124,6 +170,63 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /new?site=siteID&url=post-url&last_seen=unix_ts_msec&marker=signed-marker
+// returns comments for the post sorted by time, with comments created after the last visit flagged as new.
+// Last visit taken from last_seen parameter, signed marker parameter or marker cookie, in this order.
+// Response includes new marker for the current visit, and the same marker set as a cookie.
+func (s *public) newCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if locator.URL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("missing url"), "can't get new comments", rest.ErrPostNotFound)
+		return
+	}
+
+	lastSeen, err := s.parseLastSeen(r, locator)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse last seen", rest.ErrCommentNotFound)
+		return
+	}
+
+	comments, err := s.dataService.FindSince(locator, "+time", rest.GetUserOrEmpty(r), time.Time{})
+	if err != nil {
+		comments = []store.Comment{}
+	}
+	comments = s.userFields.comments(comments, rest.GetUserOrEmpty(r))
+
+	visitTS := time.Now()
+	res := commentsWithMarker{Comments: make([]markedComment, 0, len(comments)), Marker: s.makeMarker(locator, visitTS)}
+	for _, c := range comments {
+		isNew := !lastSeen.IsZero() && !c.Deleted && c.Timestamp.After(lastSeen)
+		if isNew {
+			res.NewCount++
+		}
+		if !c.Deleted {
+			res.Info.Count++
+		}
+		res.Comments = append(res.Comments, markedComment{Comment: c, New: isNew})
+	}
+	res.Info.URL = locator.URL
+	res.Info.ReadOnly = s.dataService.IsReadOnly(locator)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     markerCookieName(locator),
+		Value:    res.Marker,
+		Path:     "/api/v1/new",
+		MaxAge:   int(markerCookieAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	data, err := encodeJSONWithHTML(res)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't encode new comments", rest.ErrInternal)
+		return
+	}
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render new comments for post %+v", locator)
+	}
+}
+
 // GET /info?site=siteID&url=post-url - get info about the post
 func (s *public) infoCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
@@ -164,7 +267,8 @@ func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(lastCommentsScope)
+	// keyed by user, as author fields hidden from other readers are shown to the author
+	key := cache.NewKey(siteID).ID(URLKeyWithUser(r)).Scopes(lastCommentsScope)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
 		comments, e := s.dataService.Last(siteID, limit, sinceTime, rest.GetUserOrEmpty(r))
 		if e != nil {
@@ -172,7 +276,8 @@ func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 		// filter deleted from last comments view. Blocked marked as deleted and will sneak in without
 		filterDeleted := filterComments(comments, func(c store.Comment) bool { return !c.Deleted })
-		return encodeJSONWithHTML(filterDeleted)
+		filterDeleted = s.preview.comments(r, filterDeleted)
+		return encodeJSONWithHTML(s.userFields.comments(filterDeleted, rest.GetUserOrEmpty(r)))
 	})
 
 	if err != nil {
@@ -185,7 +290,8 @@ func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GET /id/{id}?site=siteID&url=post-url - gets a comment by id
+// GET /id/{id}?site=siteID&url=post-url - gets a comment by id, used to resolve permalinks.
+// The id is kept on edits, deleted comment returned as a tombstone with deleted flag set and no text.
 func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	siteID := r.URL.Query().Get("site")
@@ -195,9 +301,10 @@ func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 
 	comment, err := s.dataService.Get(store.Locator{SiteID: siteID, URL: url}, id, rest.GetUserOrEmpty(r))
 	if err != nil {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment by id", rest.ErrCommentNotFound)
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get comment by id", rest.ErrCommentNotFound)
 		return
 	}
+	comment = s.userFields.comments([]store.Comment{comment}, rest.GetUserOrEmpty(r))[0]
 	render.Status(r, http.StatusOK)
 
 	if err = R.RenderJSONWithHTML(w, r, comment); err != nil {
@@ -205,6 +312,66 @@ func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /ancestors/{id}?site=siteID&url=post-url - gets a comment by id with its ancestors up to the root comment,
+// to render the context of deep-linked reply. Deleted ancestors returned as tombstones with deleted flag set and no text.
+func (s *public) ancestorsCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	user := rest.GetUserOrEmpty(r)
+
+	log.Printf("[DEBUG] get comment %s with ancestors for %+v", id, locator)
+
+	comment, ancestors, err := s.dataService.GetWithAncestors(locator, id, user)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get comment by id", rest.ErrCommentNotFound)
+		return
+	}
+	comment = s.userFields.comments([]store.Comment{comment}, user)[0]
+	ancestors = s.userFields.comments(ancestors, user)
+
+	render.Status(r, http.StatusOK)
+	if err = R.RenderJSONWithHTML(w, r, commentWithAncestors{Comment: comment, Ancestors: ancestors}); err != nil {
+		log.Printf("[WARN] can't render comment %s with ancestors for post %+v", id, locator)
+	}
+}
+
+// GET /replies/{id}?site=siteID&url=post-url - returns direct replies to the comment, sorted by time.
+// Each reply comes with the number of its own replies, same way as top-level comments of find with format=collapsed,
+// to load long threads level by level.
+func (s *public) repliesCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	log.Printf("[DEBUG] get replies to %s for %+v", id, locator)
+
+	key := cache.NewKey(locator.SiteID).ID(URLKeyWithUser(r)).Scopes(locator.SiteID, locator.URL)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		comments, e := s.dataService.FindSince(locator, "time", rest.GetUserOrEmpty(r), time.Time{})
+		if e != nil {
+			return nil, e
+		}
+		comments = s.userFields.comments(comments, rest.GetUserOrEmpty(r))
+		replies, ok := service.MakeTree(comments, "time").CollapseReplies(id)
+		if !ok {
+			return nil, errParentNotFound
+		}
+		return encodeJSONWithHTML(collapsedWithInfo{Comments: replies})
+	})
+
+	if errors.Is(err, errParentNotFound) {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get replies", rest.ErrCommentNotFound)
+		return
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get replies", rest.ErrCommentNotFound)
+		return
+	}
+
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render replies to %s for post %+v", id, locator)
+	}
+}
+
 // GET /comments?site=siteID&user=id&limit=123&skip=10 - returns comments for given userID
 func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user")
@@ -238,11 +405,12 @@ func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 			return nil, e
 		}
 		comments = filterComments(comments, func(c store.Comment) bool { return !c.Deleted })
+		comments = s.preview.comments(r, comments)
 		count, e := s.dataService.UserCount(siteID, userID)
 		if e != nil {
 			return nil, e
 		}
-		resp.Comments, resp.Count = comments, count
+		resp.Comments, resp.Count = s.userFields.comments(comments, rest.GetUserOrEmpty(r)), count
 		return encodeJSONWithHTML(resp)
 	})
 
@@ -255,6 +423,50 @@ func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /search?site=siteID&query=words&user=id&limit=N - search comments of the site with all words of the query
+// in the text, newest first. Optional user limits results to comments of the author. Each comment returned
+// with snippet of the text around the match, matched words wrapped in <mark> tags.
+func (s *public) searchCtrl(w http.ResponseWriter, r *http.Request) {
+	req := service.SearchRequest{
+		SiteID: r.URL.Query().Get("site"),
+		Query:  r.URL.Query().Get("query"),
+		UserID: r.URL.Query().Get("user"),
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("no query"), "search query required", rest.ErrDecode)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+	req.Limit = limit
+	req.ExcludeURLs = s.preview.excluded(r)
+
+	key := cache.NewKey(req.SiteID).ID(URLKeyWithUser(r)).Scopes(req.SiteID)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		results, e := s.dataService.Search(req, rest.GetUserOrEmpty(r))
+		if e != nil {
+			return nil, e
+		}
+		comments := make([]store.Comment, len(results))
+		for i := range results {
+			comments[i] = results[i].Comment
+		}
+		for i, c := range s.userFields.comments(comments, rest.GetUserOrEmpty(r)) {
+			results[i].User = c.User
+		}
+		return encodeJSONWithHTML(R.JSON{"comments": results, "count": len(results)})
+	})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't search comments", rest.ErrActionRejected)
+		return
+	}
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render search results for site %s", req.SiteID)
+	}
+}
+
 // GET /count?site=siteID&url=post-url - get number of comments for given post
 func (s *public) countCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
@@ -275,6 +487,11 @@ func (s *public) countMultiCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get list of posts from request", rest.ErrSiteNotFound)
 		return
 	}
+	if s.maxCountsPosts > 0 && len(posts) > s.maxCountsPosts {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("%d posts requested, max %d", len(posts), s.maxCountsPosts),
+			"too many posts in request", rest.ErrTooManyPosts)
+		return
+	}
 
 	// key could be long for multiple posts, make it sha1
 	k := URLKey(r) + strings.Join(posts, ",")
@@ -287,7 +504,7 @@ func (s *public) countMultiCtrl(w http.ResponseWriter, r *http.Request) {
 		if e != nil {
 			return nil, e
 		}
-		return encodeJSONWithHTML(counts)
+		return encodeJSONWithHTML(s.preview.posts(r, counts))
 	})
 
 	if err != nil {
@@ -318,7 +535,7 @@ func (s *public) listCtrl(w http.ResponseWriter, r *http.Request) {
 		if e != nil {
 			return nil, e
 		}
-		return encodeJSONWithHTML(posts)
+		return encodeJSONWithHTML(s.preview.posts(r, posts))
 	})
 
 	if err != nil {
@@ -331,6 +548,129 @@ func (s *public) listCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// maxSitemapURLs is the limit of URLs in a single sitemap defined by sitemaps protocol
+const maxSitemapURLs = 50000
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// GET /sitemap.xml?site=siteID&page=N - sitemap of commented pages of the site, lastmod is the time of the latest comment.
+// Site with more pages than fits in a single sitemap gets sitemap index pointing to the numbered pages of the sitemap.
+func (s *public) sitemapCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	page := 0
+	if p := r.URL.Query().Get("page"); p != "" {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 1 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid page %q", p), "can't get sitemap", rest.ErrDecode)
+			return
+		}
+		page = v
+	}
+
+	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		posts, e := s.dataService.List(siteID, 0, 0)
+		if e != nil {
+			return nil, e
+		}
+		return s.sitemap(siteID, s.preview.posts(r, posts), page)
+	})
+
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get sitemap for "+siteID, rest.ErrSiteNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		log.Printf("[WARN] failed to send response to %s, %s", r.RemoteAddr, err)
+	}
+}
+
+// sitemap makes sitemap xml for the requested page. Pages without visible comments and with non-http urls skipped.
+// Zero page means the whole sitemap, or sitemap index if number of urls exceeds the page size.
+func (s *public) sitemap(siteID string, posts []store.PostInfo, page int) ([]byte, error) {
+	items := make([]store.PostInfo, 0, len(posts))
+	for _, p := range posts {
+		if p.Count <= 0 {
+			continue
+		}
+		if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		items = append(items, p)
+	}
+	// sort by url to keep the same urls on the same page
+	sort.Slice(items, func(i, j int) bool { return items[i].URL < items[j].URL })
+
+	pageSize := s.sitemapPageSize
+	if pageSize <= 0 {
+		pageSize = maxSitemapURLs
+	}
+
+	if page == 0 && len(items) > pageSize {
+		index := sitemapIndex{Xmlns: sitemapNS}
+		for n := 1; (n-1)*pageSize < len(items); n++ {
+			lastMod := time.Time{}
+			for _, p := range items[(n-1)*pageSize : min(n*pageSize, len(items))] {
+				if p.LastTS.After(lastMod) {
+					lastMod = p.LastTS
+				}
+			}
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{
+				Loc:     fmt.Sprintf("%s/api/v1/sitemap.xml?site=%s&page=%d", s.remarkURL, url.QueryEscape(siteID), n),
+				LastMod: sitemapTime(lastMod),
+			})
+		}
+		return sitemapXML(index)
+	}
+
+	if page > 0 {
+		from, to := min((page-1)*pageSize, len(items)), min(page*pageSize, len(items))
+		items = items[from:to]
+	}
+
+	urlSet := sitemapURLSet{Xmlns: sitemapNS, URLs: []sitemapURL{}}
+	for _, p := range items {
+		urlSet.URLs = append(urlSet.URLs, sitemapURL{Loc: p.URL, LastMod: sitemapTime(p.LastTS)})
+	}
+	return sitemapXML(urlSet)
+}
+
+func sitemapXML(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal sitemap: %w", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// sitemapTime formats time in W3C datetime format used by sitemaps, empty for zero time
+func sitemapTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 // GET /picture/{user}/{id} - get picture
 func (s *public) loadPictureCtrl(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "user") + "/" + chi.URLParam(r, "id")
@@ -361,7 +701,7 @@ func (s *public) loadPictureCtrl(w http.ResponseWriter, r *http.Request) {
 // GET /robots.txt
 func (s *public) robotsCtrl(w http.ResponseWriter, r *http.Request) {
 	allowed := []string{"/find", "/last", "/id", "/count", "/counts", "/list", "/config", "/user",
-		"/img", "/avatar", "/picture"}
+		"/img", "/avatar", "/picture", "/sitemap.xml"}
 	for i := range allowed {
 		allowed[i] = "Allow: /api/v1" + allowed[i]
 	}
@@ -432,3 +772,59 @@ func (s *public) parseSince(r *http.Request) (time.Time, error) {
 	}
 	return sinceTS, nil
 }
+
+// parseLastSeen returns time of the last visit of the post, from last_seen parameter, marker parameter or marker cookie.
+// Zero time returned for the first visit, invalid or foreign marker treated as no marker.
+func (s *public) parseLastSeen(r *http.Request, locator store.Locator) (time.Time, error) {
+	if lastSeen := r.URL.Query().Get("last_seen"); lastSeen != "" {
+		unixTS, e := strconv.ParseInt(lastSeen, 10, 64)
+		if e != nil {
+			return time.Time{}, fmt.Errorf("can't translate last_seen parameter: %w", e)
+		}
+		return time.UnixMilli(unixTS), nil
+	}
+
+	marker := r.URL.Query().Get("marker")
+	if marker == "" {
+		if c, e := r.Cookie(markerCookieName(locator)); e == nil {
+			marker = c.Value
+		}
+	}
+	if marker == "" {
+		return time.Time{}, nil
+	}
+	ts, ok := s.checkMarker(locator, marker)
+	if !ok {
+		log.Printf("[DEBUG] invalid last seen marker for %+v", locator)
+		return time.Time{}, nil
+	}
+	return ts, nil
+}
+
+// makeMarker makes signed marker of the visit in "unix_ts_msec.signature" format.
+// Marker signed with the shared secret and bound to the post, so it can be checked by any instance without server-side state
+func (s *public) makeMarker(locator store.Locator, ts time.Time) string {
+	msec := strconv.FormatInt(ts.UnixMilli(), 10)
+	return msec + "." + store.HashValue(locator.SiteID+"::"+locator.URL+"::"+msec, s.markerSecret)
+}
+
+// checkMarker validates signature of the marker and returns time of the visit from it
+func (s *public) checkMarker(locator store.Locator, marker string) (time.Time, bool) {
+	elems := strings.SplitN(marker, ".", 2)
+	if len(elems) != 2 {
+		return time.Time{}, false
+	}
+	msec, err := strconv.ParseInt(elems[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !hmac.Equal([]byte(s.makeMarker(locator, time.UnixMilli(msec))), []byte(marker)) {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(msec), true
+}
+
+// markerCookieName makes name of the marker cookie for the post, as each post has its own marker
+func markerCookieName(locator store.Locator) string {
+	return "remark42-seen-" + store.EncodeID(locator.SiteID + "::" + locator.URL)[:12]
+}
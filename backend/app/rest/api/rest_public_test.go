@@ -12,12 +12,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-pkgz/auth/token"
 	cache "github.com/go-pkgz/lcw/v2"
 	R "github.com/go-pkgz/rest"
+	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/umputun/remark42/backend/app/rest"
 	"github.com/umputun/remark42/backend/app/store"
+	adminstore "github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
 
@@ -53,6 +57,91 @@ func TestRest_PingNoSignature(t *testing.T) {
 	assert.Equal(t, "", resp.Header.Get("App-Name"))
 }
 
+func TestRest_HealthReady(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) { srv.Sites = []string{"remark42"} })
+	defer teardown()
+
+	body, code := get(t, ts.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+	body, code = get(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok","store":"ok","secrets":"ok"}`, body)
+
+	// secrets backend down
+	astore := srv.DataService.AdminStore
+	srv.DataService.AdminStore = adminstore.NewStaticKeyStore("")
+	body, code = get(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.JSONEq(t, `{"status":"down","store":"ok","secrets":"down"}`, body)
+	srv.DataService.AdminStore = astore
+
+	// store down, liveness not affected
+	require.NoError(t, srv.DataService.Engine.Close())
+	body, code = get(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.JSONEq(t, `{"status":"down","store":"down","secrets":"ok"}`, body)
+	body, code = get(t, ts.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+}
+
+func TestRest_TokenCheck(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	check := func(tkn string) (code int, res R.JSON) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/token/check", http.NoBody)
+		require.NoError(t, err)
+		if tkn != "" {
+			req.Header.Set("X-JWT", tkn)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Empty(t, resp.Cookies(), "no cookies set")
+		res = R.JSON{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := check(devToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, res["valid"])
+	assert.Equal(t, false, res["expired"])
+	assert.Equal(t, "remark42", res["aud"])
+	assert.Greater(t, res["ttl"], float64(0))
+	assert.Equal(t, "2090-01-27T09:17:02Z", res["expires"])
+	assert.Equal(t, map[string]interface{}{"id": "provider1_dev", "name": "developer one", "admin": false, "blocked": false}, res["user"])
+
+	expiredToken, err := srv.Authenticator.TokenService().Token(token.Claims{
+		StandardClaims: jwt.StandardClaims{Id: "random id", Audience: "remark42", Issuer: "remark42",
+			ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+		User: &token.User{ID: "provider1_dev", Name: "developer one"},
+	})
+	require.NoError(t, err)
+	code, res = check(expiredToken)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, res["valid"], "expired token not valid")
+	assert.Equal(t, true, res["expired"])
+	assert.Equal(t, float64(0), res["ttl"])
+
+	code, res = check("bad token")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, float64(rest.ErrInvalidToken), res["code"])
+	assert.Equal(t, "invalid token", res["details"])
+	assert.Contains(t, res["error"], "can't parse token")
+
+	code, res = check("")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, float64(rest.ErrInvalidToken), res["code"])
+	assert.Equal(t, "token not passed", res["details"])
+
+	body, code := get(t, ts.URL+"/api/v1/token/check?jwt="+devToken)
+	assert.Equal(t, http.StatusOK, code, "token passed in query")
+	assert.Contains(t, body, `"valid":true`)
+}
+
 func TestRest_Preview(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -241,6 +330,232 @@ func TestRest_Find(t *testing.T) {
 	assert.False(t, tree.Info.ReadOnly, "post is fresh")
 }
 
+func TestRest_FindLang(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.DefaultLang = "en"
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+
+	idDe := addComment(t, store.Comment{Text: "hallo", Lang: "de", Locator: locator}, ts)
+	idPt := addComment(t, store.Comment{Text: "olá", Lang: "pt_BR", Locator: locator}, ts)
+	idDefault := addComment(t, store.Comment{Text: "hello", Locator: locator}, ts)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+		strings.NewReader(`{"text": "bonjour", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+	require.NoError(t, err)
+	req.Header.Set("Accept-Language", "fr-CA,fr;q=0.9,en;q=0.8")
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	find := func(query string) commentsWithInfo {
+		res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&sort=+time"+query)
+		require.Equal(t, http.StatusOK, code)
+		comments := commentsWithInfo{}
+		require.NoError(t, json.Unmarshal([]byte(res), &comments))
+		return comments
+	}
+
+	all := find("")
+	require.Len(t, all.Comments, 4, "all comments without filter")
+	assert.Equal(t, "de", all.Comments[0].Lang)
+	assert.Equal(t, "pt-br", all.Comments[1].Lang, "declared language normalized")
+	assert.Equal(t, "en", all.Comments[2].Lang, "site default language")
+	assert.Equal(t, "fr-ca", all.Comments[3].Lang, "detected by Accept-Language")
+	assert.Equal(t, 4, all.Info.Count)
+
+	pt := find("&lang=pt")
+	require.Len(t, pt.Comments, 1)
+	assert.Equal(t, idPt, pt.Comments[0].ID, "more specific tag matched")
+	assert.Equal(t, 1, pt.Info.Count)
+
+	en := find("&lang=EN")
+	require.Len(t, en.Comments, 1)
+	assert.Equal(t, idDefault, en.Comments[0].ID)
+
+	tree := treeWithInfo{}
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&lang=de")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	require.Len(t, tree.Nodes, 1)
+	assert.Equal(t, idDe, tree.Nodes[0].Comment.ID)
+
+	assert.Empty(t, find("&lang=ja").Comments)
+}
+
+func TestRest_FindCollapsed(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah-collapsed"}
+	id1 := addComment(t, store.Comment{Text: "top #1", Locator: locator}, ts)
+	id11 := addComment(t, store.Comment{Text: "reply #11", ParentID: id1, Locator: locator}, ts)
+	id12 := addComment(t, store.Comment{Text: "reply #12", ParentID: id1, Locator: locator}, ts)
+	id111 := addComment(t, store.Comment{Text: "reply #111", ParentID: id11, Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "top #2", Locator: locator}, ts)
+
+	// top-level comments with reply counts only
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah-collapsed&format=collapsed&limit=1")
+	require.Equal(t, http.StatusOK, code, res)
+	collapsed := collapsedWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &collapsed))
+	require.Len(t, collapsed.Comments, 2, "limit ignored")
+	assert.Equal(t, id1, collapsed.Comments[0].Comment.ID)
+	assert.Equal(t, 2, collapsed.Comments[0].Replies)
+	assert.Equal(t, 3, collapsed.Comments[0].Total)
+	assert.Equal(t, id2, collapsed.Comments[1].Comment.ID)
+	assert.Equal(t, 0, collapsed.Comments[1].Replies)
+	require.NotNil(t, collapsed.Info)
+	assert.Equal(t, 5, collapsed.Info.Count)
+	assert.NotContains(t, res, "reply #11", "replies not included")
+
+	// replies loaded level by level
+	repliesURL := func(id string) string {
+		return ts.URL + "/api/v1/replies/" + id + "?site=remark42&url=https://radio-t.com/blah-collapsed"
+	}
+	res, code = get(t, repliesURL(id1))
+	require.Equal(t, http.StatusOK, code, res)
+	replies := collapsedWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &replies))
+	require.Len(t, replies.Comments, 2)
+	assert.Equal(t, id11, replies.Comments[0].Comment.ID)
+	assert.Equal(t, "<p>reply #11</p>\n", replies.Comments[0].Comment.Text)
+	assert.Equal(t, 1, replies.Comments[0].Replies)
+	assert.Equal(t, id12, replies.Comments[1].Comment.ID)
+	assert.Equal(t, 0, replies.Comments[1].Total)
+	assert.Nil(t, replies.Info)
+	assert.NotContains(t, res, "reply #111", "nested replies not included")
+
+	res, code = get(t, repliesURL(id11))
+	require.Equal(t, http.StatusOK, code, res)
+	replies = collapsedWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &replies))
+	require.Len(t, replies.Comments, 1)
+	assert.Equal(t, id111, replies.Comments[0].Comment.ID)
+
+	res, code = get(t, repliesURL(id2))
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, `{"comments":[]}`+"\n", res)
+
+	res, code = get(t, repliesURL("bad-id"))
+	assert.Equal(t, http.StatusNotFound, code, res)
+}
+
+func TestRest_Ancestors(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah-ancestors"}
+	id1 := addComment(t, store.Comment{Text: "top #1", Locator: locator}, ts)
+	id11 := addComment(t, store.Comment{Text: "reply #11", ParentID: id1, Locator: locator}, ts)
+	id111 := addComment(t, store.Comment{Text: "reply #111", ParentID: id11, Locator: locator}, ts)
+	id1111 := addComment(t, store.Comment{Text: "reply #1111", ParentID: id111, Locator: locator}, ts)
+	addComment(t, store.Comment{Text: "reply #12", ParentID: id1, Locator: locator}, ts)
+	require.NoError(t, srv.DataService.Delete(locator, id11, store.SoftDelete))
+
+	ancestorsURL := func(id string) string {
+		return ts.URL + "/api/v1/ancestors/" + id + "?site=remark42&url=https://radio-t.com/blah-ancestors"
+	}
+	res, code := get(t, ancestorsURL(id1111))
+	require.Equal(t, http.StatusOK, code, res)
+	resp := commentWithAncestors{}
+	require.NoError(t, json.Unmarshal([]byte(res), &resp))
+	assert.Equal(t, id1111, resp.Comment.ID)
+	assert.Equal(t, "<p>reply #1111</p>\n", resp.Comment.Text)
+	require.Len(t, resp.Ancestors, 3)
+	assert.Equal(t, id1, resp.Ancestors[0].ID)
+	assert.Equal(t, "<p>top #1</p>\n", resp.Ancestors[0].Text)
+	assert.Equal(t, id11, resp.Ancestors[1].ID)
+	assert.True(t, resp.Ancestors[1].Deleted)
+	assert.Empty(t, resp.Ancestors[1].Text, "deleted ancestor as tombstone")
+	assert.Equal(t, id111, resp.Ancestors[2].ID)
+	assert.Equal(t, "<p>reply #111</p>\n", resp.Ancestors[2].Text)
+	assert.NotContains(t, res, "reply #12", "siblings not included")
+
+	res, code = get(t, ancestorsURL(id1))
+	require.Equal(t, http.StatusOK, code, res)
+	resp = commentWithAncestors{}
+	require.NoError(t, json.Unmarshal([]byte(res), &resp))
+	assert.Equal(t, id1, resp.Comment.ID)
+	assert.Empty(t, resp.Ancestors)
+	assert.Contains(t, res, `"ancestors":[]`)
+
+	res, code = get(t, ancestorsURL("bad-id"))
+	assert.Equal(t, http.StatusNotFound, code, res)
+}
+
+func TestRest_FindPaging(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	postURL := "https://radio-t.com/blah-paging"
+	ids := []string{}
+	for i := 0; i < 5; i++ {
+		c := store.Comment{Text: fmt.Sprintf("test test #%d", i), Locator: store.Locator{SiteID: "remark42", URL: postURL}}
+		ids = append(ids, addComment(t, c, ts))
+	}
+
+	getPage := func(sort, cursor string) commentsWithInfo {
+		res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url="+postURL+"&sort="+sort+"&limit=2&cursor="+cursor)
+		require.Equal(t, http.StatusOK, code, res)
+		comments := commentsWithInfo{}
+		require.NoError(t, json.Unmarshal([]byte(res), &comments))
+		assert.Equal(t, len(ids), comments.Info.Count, "count of all comments")
+		return comments
+	}
+	pageIDs := func(comments commentsWithInfo) (res []string) {
+		for _, c := range comments.Comments {
+			res = append(res, c.ID)
+		}
+		return res
+	}
+
+	// newest first, comment added between pages doesn't shift the next page
+	page := getPage("-time", "")
+	assert.Equal(t, []string{ids[4], ids[3]}, pageIDs(page))
+	require.NotEmpty(t, page.NextCursor)
+	newID := addComment(t, store.Comment{Text: "new one", Locator: store.Locator{SiteID: "remark42", URL: postURL}}, ts)
+	ids = append(ids, newID)
+	page = getPage("-time", page.NextCursor)
+	assert.Equal(t, []string{ids[2], ids[1]}, pageIDs(page))
+	page = getPage("-time", page.NextCursor)
+	assert.Equal(t, []string{ids[0]}, pageIDs(page))
+	assert.Empty(t, page.NextCursor, "last page")
+
+	// oldest first, new comment at the end
+	page = getPage("+time", "")
+	assert.Equal(t, []string{ids[0], ids[1]}, pageIDs(page))
+	newID = addComment(t, store.Comment{Text: "newer one", Locator: store.Locator{SiteID: "remark42", URL: postURL}}, ts)
+	ids = append(ids, newID)
+	all := pageIDs(page)
+	for page.NextCursor != "" {
+		page = getPage("+time", page.NextCursor)
+		all = append(all, pageIDs(page)...)
+	}
+	assert.Equal(t, ids, all)
+
+	time.Sleep(time.Second) // avoid hitting rate limiter
+
+	// without limit all comments returned
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url="+postURL)
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	assert.Len(t, comments.Comments, len(ids))
+	assert.Empty(t, comments.NextCursor)
+
+	// cursor of another sort and broken cursor rejected
+	page = getPage("-time", "")
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url="+postURL+"&sort=-score&limit=2&cursor="+page.NextCursor)
+	assert.Equal(t, http.StatusBadRequest, code, res)
+	assert.Contains(t, res, `"code":2`)
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url="+postURL+"&limit=2&cursor=bad")
+	assert.Equal(t, http.StatusBadRequest, code, res)
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url="+postURL+"&limit=-1")
+	assert.Equal(t, http.StatusBadRequest, code, res)
+}
+
 func TestRest_FindAge(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -315,6 +630,66 @@ func TestRest_FindReadOnly(t *testing.T) {
 	assert.False(t, tree.Info.ReadOnly, "post is writable")
 }
 
+func TestRest_FindTreeCapped(t *testing.T) {
+	ts, srv, teardown := startupT(t, func(srv *Rest) { srv.MaxTreeComments = 50 })
+	defer teardown()
+
+	// 10 threads with 30 replies each, 310 comments
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 10; i++ {
+		parentID, err := srv.DataService.Create(store.Comment{Text: fmt.Sprintf("thread #%d", i), Locator: locator,
+			Timestamp: start.Add(time.Duration(i) * time.Minute), User: store.User{ID: "u1"}})
+		require.NoError(t, err)
+		for j := 0; j < 30; j++ {
+			_, err = srv.DataService.Create(store.Comment{Text: fmt.Sprintf("reply #%d-%d", i, j), ParentID: parentID, Locator: locator,
+				Timestamp: start.Add(time.Duration(i)*time.Minute + time.Duration(j+1)*time.Second), User: store.User{ID: "u2"}})
+			require.NoError(t, err)
+		}
+	}
+
+	var count func(nodes []*service.Node) int
+	count = func(nodes []*service.Node) int {
+		res := len(nodes)
+		for _, n := range nodes {
+			res += count(n.Replies)
+		}
+		return res
+	}
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&sort=-time")
+	require.Equal(t, http.StatusOK, code, res)
+	tree := treeWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	require.Len(t, tree.Nodes, 10, "all threads returned")
+	assert.LessOrEqual(t, count(tree.Nodes), 50, "tree capped")
+	assert.Equal(t, 310, tree.Info.Count)
+	omitted := 0
+	for _, n := range tree.Nodes {
+		omitted += n.Omitted
+	}
+	assert.Equal(t, 310, count(tree.Nodes)+omitted, "omitted replies counted")
+	assert.Equal(t, "thread #9", tree.Nodes[0].Comment.Text)
+	assert.Len(t, tree.Nodes[0].Replies, 30, "the newest thread returned in full")
+	assert.Equal(t, "thread #0", tree.Nodes[9].Comment.Text)
+	assert.Empty(t, tree.Nodes[9].Replies)
+	assert.Equal(t, 30, tree.Nodes[9].Omitted, "the oldest thread collapsed")
+
+	// omitted replies loaded on demand
+	res, code = get(t, ts.URL+"/api/v1/replies/"+tree.Nodes[9].Comment.ID+"?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, res)
+	replies := collapsedWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &replies))
+	assert.Len(t, replies.Comments, 30)
+
+	// plain format not capped
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	plain := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &plain))
+	assert.Len(t, plain.Comments, 310)
+}
+
 func TestRest_FindUserView(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -435,6 +810,49 @@ func TestRest_Last(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, code)
 }
 
+func TestRest_Search(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	for _, c := range []store.Comment{
+		{Text: "golang generics", User: store.User{ID: "user1", Name: "user1"}},
+		{Text: "rust **generics**", User: store.User{ID: "user2", Name: "user2"}},
+		{Text: "nothing here", User: store.User{ID: "user1", Name: "user1"}},
+	} {
+		c.Locator = store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+		c.Text = srv.CommentFormatter.FormatText(c.Text, true)
+		_, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+	}
+
+	search := func(query string) (code int, res R.JSON) {
+		body, code := get(t, ts.URL+"/api/v1/search?site=remark42&"+query)
+		res = R.JSON{}
+		require.NoError(t, json.Unmarshal([]byte(body), &res), body)
+		return code, res
+	}
+
+	code, res := search("query=GENERICS")
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, 2.0, res["count"])
+	comments := res["comments"].([]interface{})
+	require.Len(t, comments, 2)
+	assert.Equal(t, "rust <mark>generics</mark>", comments[0].(map[string]interface{})["snippet"])
+	assert.Equal(t, "golang <mark>generics</mark>", comments[1].(map[string]interface{})["snippet"])
+
+	code, res = search("query=generics&user=user1")
+	require.Equal(t, http.StatusOK, code, res)
+	comments = res["comments"].([]interface{})
+	require.Len(t, comments, 1, "comments of the author only")
+	c := comments[0].(map[string]interface{})
+	assert.Equal(t, "user1", c["user"].(map[string]interface{})["id"])
+	assert.Equal(t, "<p>golang generics</p>\n", c["text"])
+
+	time.Sleep(time.Second) // search rate limited
+	code, _ = search("query=")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
 func TestRest_FindUserComments(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -694,6 +1112,108 @@ func TestPublic_FindCommentsCtrl_ConsistentCount(t *testing.T) {
 	}
 }
 
+func TestRest_CommentByIDPermalink(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+	permalink := func(id string) string {
+		return fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah1", ts.URL, id)
+	}
+	update := func(id, body string) {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+			strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// edited comment resolved by the same id
+	update(id1, `{"text":"edited text"}`)
+	res, code := get(t, permalink(id1))
+	require.Equal(t, http.StatusOK, code, res)
+	comment := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comment))
+	assert.Equal(t, id1, comment.ID)
+	assert.Equal(t, "<p>edited text</p>\n", comment.Text)
+	assert.NotNil(t, comment.Edit)
+
+	// soft-deleted comment resolved to a tombstone
+	update(id2, `{"delete":true}`)
+	res, code = get(t, permalink(id2))
+	require.Equal(t, http.StatusOK, code, res)
+	comment = store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comment))
+	assert.Equal(t, id2, comment.ID)
+	assert.Equal(t, locator, comment.Locator)
+	assert.True(t, comment.Deleted)
+	assert.Empty(t, comment.Text)
+	assert.Empty(t, comment.Orig)
+
+	// unknown comment not found
+	res, code = get(t, permalink("unknown-id"))
+	assert.Equal(t, http.StatusNotFound, code, res)
+}
+
+func TestRest_HiddenUserFields(t *testing.T) {
+	ts, _, teardown := startupT(t, func(srv *Rest) {
+		srv.HiddenUserFields = map[string][]string{"remark42": {UserFieldID, UserFieldName, UserFieldPicture}}
+	})
+	defer teardown()
+
+	id := addComment(t, store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+
+	findUser := func(body string) store.User {
+		comments := commentsWithInfo{}
+		require.NoError(t, json.Unmarshal([]byte(body), &comments), body)
+		require.Len(t, comments.Comments, 1)
+		return comments.Comments[0].User
+	}
+
+	// reader gets hidden author fields
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	u := findUser(body)
+	assert.True(t, strings.HasPrefix(u.ID, "hidden_"), u.ID)
+	assert.NotContains(t, u.ID, "provider1_dev")
+	assert.Equal(t, "hidden", u.Name)
+	assert.Empty(t, u.Picture)
+	hiddenID := u.ID
+
+	body, code = get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah1", ts.URL, id))
+	require.Equal(t, http.StatusOK, code)
+	c := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	assert.Equal(t, hiddenID, c.User.ID, "hidden id is stable")
+	assert.Equal(t, "hidden", c.User.Name)
+
+	body, code = get(t, ts.URL+"/api/v1/last/10?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "provider1_dev")
+	assert.NotContains(t, body, "developer one")
+
+	body, code = get(t, ts.URL+"/api/v1/rss/site?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "developer one")
+
+	// moderator gets full author fields
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	u = findUser(body)
+	assert.Equal(t, "provider1_dev", u.ID)
+	assert.Equal(t, "developer one", u.Name)
+
+	// author gets own fields
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "provider1_dev", findUser(body).ID)
+}
+
 func TestRest_UserInfo(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -774,6 +1294,37 @@ func TestRest_Counts(t *testing.T) {
 	assert.NoError(t, resp.Body.Close())
 }
 
+func TestRest_CountsMaxPosts(t *testing.T) {
+	ts, _, teardown := startupT(t, func(srv *Rest) {
+		srv.MaxCountsPosts = 3
+	})
+	defer teardown()
+
+	addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+	addComment(t, store.Comment{Text: "test test #2", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}, ts)
+	addComment(t, store.Comment{Text: "test test #3", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}, ts)
+
+	resp, err := post(t, ts.URL+"/api/v1/counts?site=remark42",
+		`["https://radio-t.com/blah1","https://radio-t.com/blah2","https://radio-t.com/blah3"]`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	j := []store.PostInfo{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&j))
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com/blah1", Count: 1}, {URL: "https://radio-t.com/blah2", Count: 2},
+		{URL: "https://radio-t.com/blah3", Count: 0}}, j, "max posts allowed")
+
+	resp, err = post(t, ts.URL+"/api/v1/counts?site=remark42",
+		`["https://radio-t.com/blah1","https://radio-t.com/blah2","https://radio-t.com/blah3","https://radio-t.com/blah4"]`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	res := R.JSON{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, float64(rest.ErrTooManyPosts), res["code"])
+	assert.Equal(t, "too many posts in request", res["details"])
+}
+
 func TestRest_List(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -834,6 +1385,92 @@ func TestRest_ListWithSkipAndLimit(t *testing.T) {
 	assert.Equal(t, 3, pi[1].Count)
 }
 
+func TestRest_Sitemap(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.pubRest.cache = cache.NewScache[[]byte](cache.NewNopCache[[]byte]())
+
+	ts1 := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	ts2 := time.Date(2022, 2, 1, 10, 0, 0, 0, time.UTC)
+	ts3 := time.Date(2022, 3, 1, 10, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	user := store.User{ID: "id1", Name: "name1"}
+	create := func(url string, tm time.Time) string {
+		id, err := srv.DataService.Create(store.Comment{Text: "text", User: user, Timestamp: tm,
+			Locator: store.Locator{SiteID: "remark42", URL: url}})
+		require.NoError(t, err)
+		return id
+	}
+	create("https://radio-t.com/blah2", ts1)
+	create("https://radio-t.com/blah1", ts1)
+	create("https://radio-t.com/blah1", ts3) // newest comment sets lastmod
+	create("https://radio-t.com/blah3", ts2)
+	create("not-a-url", ts2)
+	deletedID := create("https://radio-t.com/deleted", ts2)
+	require.NoError(t, srv.DataService.Delete(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/deleted"},
+		deletedID, store.SoftDelete))
+
+	resp, err := http.Get(ts.URL + "/api/v1/sitemap.xml?site=remark42")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://radio-t.com/blah1</loc>
+    <lastmod>2022-03-01T07:00:00Z</lastmod>
+  </url>
+  <url>
+    <loc>https://radio-t.com/blah2</loc>
+    <lastmod>2022-01-01T10:00:00Z</lastmod>
+  </url>
+  <url>
+    <loc>https://radio-t.com/blah3</loc>
+    <lastmod>2022-02-01T10:00:00Z</lastmod>
+  </url>
+</urlset>`, string(body))
+
+	// paginated with sitemap index
+	srv.pubRest.sitemapPageSize = 2
+	body2, code := get(t, ts.URL+"/api/v1/sitemap.xml?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>https://demo.remark42.com/api/v1/sitemap.xml?site=remark42&amp;page=1</loc>
+    <lastmod>2022-03-01T07:00:00Z</lastmod>
+  </sitemap>
+  <sitemap>
+    <loc>https://demo.remark42.com/api/v1/sitemap.xml?site=remark42&amp;page=2</loc>
+    <lastmod>2022-02-01T10:00:00Z</lastmod>
+  </sitemap>
+</sitemapindex>`, body2)
+
+	body2, code = get(t, ts.URL+"/api/v1/sitemap.xml?site=remark42&page=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body2, "<loc>https://radio-t.com/blah3</loc>")
+	assert.NotContains(t, body2, "<loc>https://radio-t.com/blah1</loc>")
+
+	body2, code = get(t, ts.URL+"/api/v1/sitemap.xml?site=remark42&page=3")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body2, "<url>", "page out of range is empty")
+
+	// newer comment updates lastmod
+	create("https://radio-t.com/blah3", ts3.Add(time.Hour))
+	body2, code = get(t, ts.URL+"/api/v1/sitemap.xml?site=remark42&page=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body2, "<lastmod>2022-03-01T08:00:00Z</lastmod>")
+
+	_, code = get(t, ts.URL+"/api/v1/sitemap.xml?site=remark42&page=0")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = get(t, ts.URL+"/api/v1/sitemap.xml?site=remark42&page=blah")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = get(t, ts.URL+"/api/v1/sitemap.xml?site=remark42-BLAH")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
 func TestRest_Config(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -931,6 +1568,99 @@ func TestRest_Info(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, code)
 }
 
+func TestRest_NewComments(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	user := store.User{ID: "user1", Name: "user name 1"}
+	tss := []time.Time{time.Date(2018, 5, 27, 1, 14, 10, 0, time.Local), time.Date(2018, 5, 27, 1, 14, 20, 0, time.Local),
+		time.Date(2018, 5, 27, 1, 14, 25, 0, time.Local)}
+	ids := make([]string, len(tss))
+	for i, cts := range tss {
+		id, err := srv.DataService.Create(store.Comment{User: user, Text: fmt.Sprintf("test test #%d", i), Locator: locator, Timestamp: cts})
+		require.NoError(t, err)
+		ids[i] = id
+	}
+
+	newFlags := func(resp commentsWithMarker) map[string]bool {
+		res := map[string]bool{}
+		for _, c := range resp.Comments {
+			res[c.ID] = c.New
+		}
+		return res
+	}
+
+	getNew := func(query string, cookie *http.Cookie) (resp commentsWithMarker, code int, cookies []*http.Cookie) {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/new?site=remark42&url=https://radio-t.com/blah1"+query, http.NoBody)
+		require.NoError(t, err)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		r, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer r.Body.Close()
+		if r.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&resp))
+		}
+		return resp, r.StatusCode, r.Cookies()
+	}
+
+	t.Run("first visit, nothing new", func(t *testing.T) {
+		resp, code, cookies := getNew("", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 3, len(resp.Comments))
+		assert.Equal(t, 3, resp.Info.Count)
+		assert.Equal(t, 0, resp.NewCount)
+		assert.Equal(t, map[string]bool{ids[0]: false, ids[1]: false, ids[2]: false}, newFlags(resp))
+		require.Equal(t, 1, len(cookies))
+		assert.Equal(t, markerCookieName(locator), cookies[0].Name)
+		assert.Equal(t, resp.Marker, cookies[0].Value)
+		_, ok := srv.pubRest.checkMarker(locator, resp.Marker)
+		assert.True(t, ok)
+	})
+
+	t.Run("last seen timestamp", func(t *testing.T) {
+		resp, code, _ := getNew(fmt.Sprintf("&last_seen=%d", tss[1].UnixMilli()), nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, resp.NewCount)
+		assert.Equal(t, map[string]bool{ids[0]: false, ids[1]: false, ids[2]: true}, newFlags(resp))
+	})
+
+	t.Run("marker cookie", func(t *testing.T) {
+		marker := srv.pubRest.makeMarker(locator, tss[0].Add(time.Second))
+		resp, code, _ := getNew("", &http.Cookie{Name: markerCookieName(locator), Value: marker})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2, resp.NewCount)
+		assert.Equal(t, map[string]bool{ids[0]: false, ids[1]: true, ids[2]: true}, newFlags(resp))
+	})
+
+	t.Run("marker param", func(t *testing.T) {
+		marker := srv.pubRest.makeMarker(locator, tss[1].Add(time.Second))
+		resp, code, _ := getNew("&marker="+marker, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, resp.NewCount)
+		assert.Equal(t, map[string]bool{ids[0]: false, ids[1]: false, ids[2]: true}, newFlags(resp))
+	})
+
+	t.Run("forged and foreign markers ignored", func(t *testing.T) {
+		forged := fmt.Sprintf("%d.%s", tss[0].UnixMilli(), "bad-signature")
+		resp, code, _ := getNew("&marker="+forged, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 0, resp.NewCount)
+
+		foreign := srv.pubRest.makeMarker(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}, tss[0])
+		resp, code, _ = getNew("&marker="+foreign, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 0, resp.NewCount)
+	})
+
+	t.Run("bad last seen", func(t *testing.T) {
+		_, code, _ := getNew("&last_seen=bad", nil)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
 func TestRest_Robots(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -940,5 +1670,5 @@ func TestRest_Robots(t *testing.T) {
 	assert.Equal(t, "User-agent: *\nDisallow: /auth/\nDisallow: /api/\nAllow: /api/v1/find\n"+
 		"Allow: /api/v1/last\nAllow: /api/v1/id\nAllow: /api/v1/count\nAllow: /api/v1/counts\n"+
 		"Allow: /api/v1/list\nAllow: /api/v1/config\nAllow: /api/v1/user\nAllow: /api/v1/img\n"+
-		"Allow: /api/v1/avatar\nAllow: /api/v1/picture\n", body)
+		"Allow: /api/v1/avatar\nAllow: /api/v1/picture\nAllow: /api/v1/sitemap.xml\n", body)
 }
@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -22,6 +23,7 @@ import (
 	"github.com/go-pkgz/auth/token"
 	cache "github.com/go-pkgz/lcw/v2"
 	R "github.com/go-pkgz/rest"
+	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	bolt "go.etcd.io/bbolt"
@@ -215,6 +217,49 @@ func TestRest_rejectAnonUser(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "real user")
 }
 
+func TestRest_AudNormalizer(t *testing.T) {
+	getUser := func(ts *httptest.Server, site, tkn string) int {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/user?site="+url.QueryEscape(site), http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	ts, _, teardown := startupT(t)
+	assert.Equal(t, http.StatusForbidden, getUser(ts, "Remark42 ", devToken), "mismatched without normalization")
+	teardown()
+
+	ts, srv, teardown := startupT(t, func(srv *Rest) { srv.AudNormalizer = rest.LowerTrimAud })
+	defer teardown()
+	assert.Equal(t, http.StatusOK, getUser(ts, "Remark42 ", devToken))
+	assert.Equal(t, http.StatusOK, getUser(ts, "remark42", devToken))
+	assert.Equal(t, http.StatusForbidden, getUser(ts, "remark43", devToken))
+
+	// token signed with non-normalized audience
+	claims := token.Claims{
+		StandardClaims: jwt.StandardClaims{Audience: " ReMark42", Issuer: "remark42", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		User:           &token.User{ID: "provider1_dev", Name: "developer one"},
+	}
+	tkn, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, getUser(ts, "remark42", tkn))
+
+	// comment posted to non-normalized site
+	body := `{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "Remark42 "}}`
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/comment", strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	comments, err := srv.DataService.Find(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, "time", store.User{})
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	assert.Equal(t, "remark42", comments[0].Locator.SiteID)
+}
+
 func Test_URLKey(t *testing.T) {
 	tbl := []struct {
 		url  string
@@ -346,6 +391,106 @@ func TestRest_frameAncestors(t *testing.T) {
 	}
 }
 
+func TestRest_AllowedOrigins(t *testing.T) {
+	ts, _, teardown := startupT(t, func(srv *Rest) {
+		srv.AllowedOrigins = map[string][]string{"remark42": {"https://allowed.example.com"}}
+	})
+	defer teardown()
+
+	postComment := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment?site=remark42",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	resp := postComment("https://allowed.example.com")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "https://allowed.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	resp = postComment("https://demo.remark42.com")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "remark42 own origin allowed")
+
+	resp = postComment("https://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// write request without site not accepted from origin of the site, as it may change data of any site
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+		strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+	require.NoError(t, err)
+	req.Header.Set("Origin", "https://allowed.example.com")
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "no site")
+
+	// read requests from unlisted origin processed, but without CORS headers browser won't let it to read the response
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "https://evil.example.com")
+	resp, err = sendReq(t, req, "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, ts.URL+"/api/v1/comment?site=remark42", http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+	resp = preflight("https://allowed.example.com")
+	assert.Equal(t, "https://allowed.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST", resp.Header.Get("Access-Control-Allow-Methods"))
+	resp = preflight("https://evil.example.com")
+	assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestRest_originAllowed(t *testing.T) {
+	srv := Rest{RemarkURL: "https://demo.remark42.com", AllowedOrigins: map[string][]string{
+		AllSitesOrigins: {"https://all.example.com"},
+		"site1":         {"https://site1.example.com/"},
+		"site2":         {"*"},
+	}}
+
+	tbl := []struct {
+		url, origin string
+		res         bool
+	}{
+		{"/api/v1/comment?site=site1", "https://site1.example.com", true},
+		{"/api/v1/comment?site=site1", "https://SITE1.example.com/", true},
+		{"/api/v1/comment?site=site1", "https://all.example.com", true},
+		{"/api/v1/comment?site=site1", "https://demo.remark42.com", true},
+		{"/api/v1/comment?site=site1", "http://site1.example.com", false},
+		{"/api/v1/comment?site=site3", "https://site1.example.com", false},
+		{"/api/v1/comment?site=site3", "https://all.example.com", true},
+		{"/api/v1/comment?site=site2", "https://any.example.com", true},
+		{"/auth/logout", "https://site1.example.com", true},
+		{"/api/v1/comment?site=site1", "", false},
+	}
+
+	for i, tt := range tbl {
+		tt := tt
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, http.NoBody)
+			assert.Equal(t, tt.res, srv.originAllowed(req, tt.origin))
+		})
+	}
+	assert.False(t, srv.siteOriginAllowed("", "https://site1.example.com"), "origin of the site not allowed without site")
+	assert.True(t, srv.siteOriginAllowed("", "https://all.example.com"))
+	assert.True(t, srv.siteOriginAllowed("", "https://demo.remark42.com"))
+}
+
 func TestRest_subscribersOnly(t *testing.T) {
 	paidSubUser := &token.User{}
 	paidSubUser.SetPaidSub(true)
@@ -16,6 +16,8 @@ import (
 type rss struct {
 	dataService rssStore
 	cache       LoadingCache
+	userFields  userFieldsFilter
+	preview     previewGuard
 }
 
 type rssStore interface {
@@ -38,7 +40,7 @@ func (s *rss) postCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
 	key := cache.NewKey(locator.SiteID).ID(URLKey(r)).Scopes(locator.SiteID, locator.URL)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.Find(locator, "-time", rest.GetUserOrEmpty(r))
+		comments, e := s.dataService.Find(locator, "-time", store.User{})
 		if e != nil {
 			return nil, e
 		}
@@ -68,10 +70,11 @@ func (s *rss) siteCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
 	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID, lastCommentsScope)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.Last(siteID, maxRssItems, time.Time{}, rest.GetUserOrEmpty(r))
+		comments, e := s.dataService.Last(siteID, maxRssItems, time.Time{}, store.User{})
 		if e != nil {
 			return nil, e
 		}
+		comments = s.preview.comments(r, comments)
 
 		feed, e := s.toRssFeed(r.URL.Query().Get("site"), comments, "site comment for "+siteID)
 		if e != nil {
@@ -104,7 +107,9 @@ func (s *rss) repliesCtrl(w http.ResponseWriter, r *http.Request) {
 		if e != nil {
 			return nil, fmt.Errorf("can't get last comments: %w", e)
 		}
+		replies = s.preview.comments(r, replies)
 
+		userName = s.userFields.user(siteID, store.User{ID: userID, Name: userName}).Name
 		feed, e := s.toRssFeed(siteID, replies, "replies to "+userName)
 		if e != nil {
 			return nil, e
@@ -141,7 +146,7 @@ func (s *rss) toRssFeed(url string, comments []store.Comment, description string
 	}
 
 	feed.Items = []*feeds.Item{}
-	for i, c := range comments {
+	for i, c := range s.userFields.comments(comments, store.User{}) {
 		f := feeds.Item{
 			Title:       c.User.Name,
 			Link:        &feeds.Link{Href: c.Locator.URL + uiNav + c.ID},
@@ -154,7 +159,8 @@ func (s *rss) toRssFeed(url string, comments []store.Comment, description string
 			// add indication to parent comment
 			parentComment, err := s.dataService.Get(c.Locator, c.ParentID, store.User{})
 			if err == nil {
-				f.Title = fmt.Sprintf("%s > %s", c.User.Name, parentComment.User.Name)
+				parentUser := s.userFields.user(parentComment.Locator.SiteID, parentComment.User)
+				f.Title = fmt.Sprintf("%s > %s", c.User.Name, parentUser.Name)
 				f.Description = f.Description + "<blockquote><p>" + parentComment.Snippet(300) + "</p></blockquote>"
 			} else {
 				log.Printf("[WARN] failed to get info about parent comment, %s", err)
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+)
+
+// jwtCookieName, xsrfCookieName and jwtHeaderKey are defaults of auth token service, used to pick up the issued token
+const (
+	jwtCookieName  = "JWT"
+	xsrfCookieName = "XSRF-TOKEN"
+	jwtHeaderKey   = "X-JWT"
+)
+
+// tokenResponse is a login response with the issued token, for clients which can't read cookies
+type tokenResponse struct {
+	Token   string      `json:"token"`
+	Expires time.Time   `json:"expires"`
+	User    *token.User `json:"user,omitempty"`
+}
+
+// tokenInBody is a middleware for login handlers returning the issued token and its expiration in JSON body,
+// for clients unable to read cookies, like mobile apps. Requested with "Accept: application/json" header or
+// token_json=1 query parameter. Cookies are set as well, responses without token passed as is.
+func (s *Rest) tokenInBody(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token_json") != "1" && !strings.Contains(r.Header.Get("Accept"), "application/json") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &bufferedWriter{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		tkn := issuedToken(rec.header)
+		if tkn == "" {
+			rec.writeTo(w)
+			return
+		}
+		claims, err := s.Authenticator.TokenService().Parse(tkn)
+		if err != nil {
+			log.Printf("[WARN] can't parse issued token, %v", err)
+			rec.writeTo(w)
+			return
+		}
+
+		for k, v := range rec.header {
+			switch k {
+			case "Location", "Content-Length", "Content-Type":
+				continue // login redirect replaced by json response
+			}
+			w.Header()[k] = v
+		}
+		render.JSON(w, r, tokenResponse{Token: tkn, Expires: time.Unix(claims.ExpiresAt, 0).UTC(), User: claims.User})
+	}
+	return http.HandlerFunc(fn)
+}
+
+// issuedToken returns token set by the response in JWT cookie or header, empty if not set or cookie reset
+func issuedToken(header http.Header) string {
+	if tkn := header.Get(jwtHeaderKey); tkn != "" {
+		return tkn
+	}
+	for _, c := range (&http.Response{Header: header}).Cookies() {
+		if c.Name == jwtCookieName && c.Value != "" && c.MaxAge >= 0 {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// bufferedWriter keeps the response to alter it before sending
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) Header() http.Header { return b.header }
+
+func (b *bufferedWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedWriter) WriteHeader(status int) { b.status = status }
+
+// writeTo sends kept response as is
+func (b *bufferedWriter) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRest_TokenInBody(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	login := func(query, accept string) (resp *http.Response, body []byte) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/auth/provider1/login?user=dev&passwd=password&aud=remark42"+query, http.NoBody)
+		require.NoError(t, err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		client := http.Client{Timeout: 5 * time.Second}
+		defer client.CloseIdleConnections()
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		body, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		return resp, body
+	}
+
+	// regular response without request, auth routes limited to 2 requests per second
+	_, body := login("", "")
+	res := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(body, &res))
+	assert.NotContains(t, res, "token")
+	assert.Equal(t, "dev", res["name"])
+
+	for _, tt := range []struct{ name, query, accept string }{
+		{name: "accept header", accept: "application/json"},
+		{name: "query flag", query: "&token_json=1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			time.Sleep(time.Second)
+			resp, body := login(tt.query, tt.accept)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			res := tokenResponse{}
+			require.NoError(t, json.Unmarshal(body, &res))
+			require.NotEmpty(t, res.Token)
+			assert.WithinDuration(t, time.Now().Add(15*time.Minute), res.Expires, time.Minute, "default token ttl")
+			require.NotNil(t, res.User)
+			assert.Equal(t, "provider1_", res.User.ID[:10])
+
+			claims, err := srv.Authenticator.TokenService().Parse(res.Token)
+			require.NoError(t, err)
+			assert.Equal(t, "remark42", claims.Audience)
+			assert.Equal(t, res.User.ID, claims.User.ID)
+
+			hasCookie := false
+			for _, c := range resp.Cookies() {
+				hasCookie = hasCookie || (c.Name == "JWT" && c.Value == res.Token)
+			}
+			assert.True(t, hasCookie, "cookie set as well")
+
+			// token from the body accepted by api
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/user?site=remark42", http.NoBody)
+			require.NoError(t, err)
+			r, err := sendReq(t, req, res.Token)
+			require.NoError(t, err)
+			require.NoError(t, r.Body.Close())
+			assert.Equal(t, http.StatusOK, r.StatusCode)
+		})
+	}
+
+	// responses without token passed as is
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/auth/logout?token_json=1", http.NoBody)
+	require.NoError(t, err)
+	r, err := sendReq(t, req, "")
+	require.NoError(t, err)
+	b, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	assert.NotContains(t, string(b), `"token"`)
+}
+
+func TestRest_SiteJWTCookies(t *testing.T) {
+	ts, _, teardown := startupT(t, func(srv *Rest) {
+		srv.SiteJWTCookies = true
+	})
+	defer teardown()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := http.Client{Timeout: 5 * time.Second, Jar: jar} // the same domain for both sites
+	defer client.CloseIdleConnections()
+
+	for i, site := range []string{"remark42", "site2"} {
+		if i > 0 {
+			time.Sleep(time.Second) // auth routes limited to 2 requests per second
+		}
+		resp, e := client.Get(ts.URL + "/auth/provider1/login?user=dev&passwd=password&aud=" + site)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	cookies := map[string]string{}
+	for _, c := range jar.Cookies(u) {
+		cookies[c.Name] = c.Value
+	}
+	assert.NotContains(t, cookies, "JWT")
+	assert.NotContains(t, cookies, "XSRF-TOKEN")
+
+	// both sites logged in at once, each with its own token and xsrf cookie read by the widget of the site
+	for _, site := range []string{"remark42", "site2"} {
+		require.NotEmpty(t, cookies["XSRF-TOKEN_"+site], site)
+		req, e := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/user?site="+site, http.NoBody)
+		require.NoError(t, e)
+		req.Header.Set("X-XSRF-TOKEN", cookies["XSRF-TOKEN_"+site])
+		resp, e := client.Do(req)
+		require.NoError(t, e)
+		user := store.User{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, site)
+		assert.Equal(t, site, user.SiteID)
+	}
+	assert.NotEqual(t, cookies["XSRF-TOKEN_remark42"], cookies["XSRF-TOKEN_site2"])
+}
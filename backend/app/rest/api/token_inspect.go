@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/token"
+	"github.com/golang-jwt/jwt"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// inspectTokenHeader passes token to inspect, header used as query and body of admin requests are logged
+const inspectTokenHeader = "X-Inspect-Token"
+
+// tokenReport describes how the token would be handled by auth, made for debugging of auth configuration.
+// Token checked by the token service and the validator of auth, the first failed check stops inspection.
+type tokenReport struct {
+	Valid     bool          `json:"valid"`
+	Stage     string        `json:"stage,omitempty"`      // failed check: decode, parse, audience, user, validator or expiration
+	Reason    string        `json:"reason,omitempty"`     // why the check failed
+	Steps     []string      `json:"steps"`                // passed checks and the failed one, in order
+	Alg       string        `json:"alg,omitempty"`        // signing method of the token
+	Aud       string        `json:"aud,omitempty"`        // site of the token
+	SecretAud string        `json:"secret_aud,omitempty"` // audience the secret requested for, site or shared secret id
+	Key       string        `json:"key,omitempty"`        // key verified the signature, "current" or "previous", the key itself never reported
+	Expires   time.Time     `json:"expires,omitempty"`
+	Claims    *token.Claims `json:"claims,omitempty"`
+}
+
+// fail sets the failed check and its reason
+func (t *tokenReport) fail(stage, reason string) tokenReport {
+	t.Stage, t.Reason = stage, reason
+	t.Steps = append(t.Steps, stage+": "+reason)
+	return *t
+}
+
+// pass adds passed check
+func (t *tokenReport) pass(stage, details string) {
+	t.Steps = append(t.Steps, stage+": "+details)
+}
+
+// inspectToken checks token of the site, all sites if not set, without making a session. Token parsed by the token
+// service of auth, with the previous key during key rotation, and checked by the validator of auth, the same way as
+// auth middleware does, so the validator may refresh upstream token of expired one.
+func (a *admin) inspectToken(tkn, siteID string) tokenReport {
+	res := tokenReport{Steps: []string{}}
+	tknSvc := a.authenticator.TokenService()
+
+	// decoded without verification to report the token details, even if parsing fails
+	unverified := token.Claims{}
+	parsed, _, err := (&jwt.Parser{}).ParseUnverified(tkn, &unverified)
+	if err != nil {
+		return res.fail("decode", fmt.Sprintf("can't decode token, %v", err))
+	}
+	res.Claims, res.Aud, res.Alg = &unverified, unverified.Audience, parsed.Method.Alg()
+	res.Expires = time.Unix(unverified.ExpiresAt, 0).UTC()
+	res.SecretAud = "ignore"
+	if tknSvc.AudSecrets {
+		res.SecretAud = unverified.Audience
+	}
+	res.pass("decode", "token decoded, signed with "+res.Alg)
+
+	claims, err := tknSvc.Parse(tkn)
+	res.Key = "current"
+	if err != nil && a.keyRotator != nil {
+		if prev, e := parsePreviousKey(tkn, a.keyRotator.PreviousKey); e == nil {
+			claims, err, res.Key = prev, nil, "previous"
+		}
+	}
+	if err != nil {
+		res.Key = ""
+		return res.fail("parse", err.Error())
+	}
+	res.Claims = &claims
+	res.pass("parse", fmt.Sprintf("signature verified with %s key", res.Key))
+
+	aud := claims.Audience
+	if a.audNormalizer != nil {
+		aud = a.audNormalizer(aud)
+	}
+	if siteID != "" && aud != siteID {
+		return res.fail("audience", fmt.Sprintf("token issued for site %q, not %q", claims.Audience, siteID))
+	}
+	res.pass("audience", fmt.Sprintf("issued for site %q", claims.Audience))
+
+	if claims.Handshake != nil {
+		return res.fail("user", "handshake token, not a session token")
+	}
+	if claims.User == nil {
+		return res.fail("user", "token has no user, not a session token")
+	}
+	res.pass("user", fmt.Sprintf("%s (%s)", claims.User.ID, claims.User.Name))
+
+	if claims.User.Audience == "" { // set by auth token service on Get from request
+		claims.User.Audience = claims.Audience
+	}
+	if a.validator != nil && !a.validator.Validate(tkn, claims) {
+		return res.fail("validator", "rejected by auth validator: user blocked, session revoked or expired "+
+			"by provider's ttl, or upstream token not refreshed")
+	}
+	res.pass("validator", "accepted by auth validator")
+
+	if tknSvc.IsExpired(claims) {
+		return res.fail("expiration", fmt.Sprintf("token expired at %s, refreshed by auth if session cookie not expired",
+			res.Expires.Format(time.RFC3339)))
+	}
+	res.pass("expiration", "expires at "+res.Expires.Format(time.RFC3339))
+
+	res.Valid = true
+	return res
+}
+
+// GET /token/inspect?site=siteID - inspect token passed in X-Inspect-Token header, reports how auth would handle it
+func (a *admin) inspectTokenCtrl(w http.ResponseWriter, r *http.Request) {
+	tkn := r.Header.Get(inspectTokenHeader)
+	if tkn == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("no token"), inspectTokenHeader+" header not set", rest.ErrInvalidToken)
+		return
+	}
+	render.JSON(w, r, a.inspectToken(tkn, r.URL.Query().Get("site")))
+}
@@ -0,0 +1,145 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// defaultAudienceInterval used by AudienceWatcher if Interval not set
+const defaultAudienceInterval = time.Minute
+
+// NormalizeAud returns middleware normalizing site id passed in "site" and "aud" query params with normalize func.
+// Site id of the login request becomes audience of the issued token, so tokens signed and site ids compared
+// with their audience get the same normalized form, i.e. "App Prod " and "app prod" resolve to the same site.
+func NormalizeAud(normalize func(string) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			changed := false
+			for _, param := range []string{"site", "aud"} {
+				if !q.Has(param) {
+					continue
+				}
+				if v := normalize(q.Get(param)); v != q.Get(param) {
+					q.Set(param, v)
+					changed = true
+				}
+			}
+			if changed {
+				r.URL.RawQuery = q.Encode()
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// LowerTrimAud is audience normalizer trimming spaces and lowering the case
+func LowerTrimAud(aud string) string {
+	return strings.ToLower(strings.TrimSpace(aud))
+}
+
+// AudienceWatcher implements token.Audience with allowed audiences re-read every Interval from File,
+// or from Env variable if File not set, so allowed sites changed without restart. Malformed update,
+// unreadable or without any audience, ignored and the last good list kept.
+type AudienceWatcher struct {
+	File     string        // file with allowed audiences separated by new lines or commas, lines starting with # ignored
+	Env      string        // name of env variable with comma separated allowed audiences
+	Interval time.Duration // re-read interval, 1m by default
+
+	lock sync.RWMutex
+	auds []string
+}
+
+// Get returns the last good list of allowed audiences, error if never loaded
+func (w *AudienceWatcher) Get() ([]string, error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if len(w.auds) == 0 {
+		return nil, fmt.Errorf("allowed audiences not loaded")
+	}
+	return w.auds, nil
+}
+
+// Load reads allowed audiences and replaces the current list, the list kept as is on error
+func (w *AudienceWatcher) Load() error {
+	var data string
+	switch {
+	case w.File != "":
+		b, err := os.ReadFile(w.File)
+		if err != nil {
+			return fmt.Errorf("can't read allowed audiences: %w", err)
+		}
+		data = string(b)
+	case w.Env != "":
+		data = os.Getenv(w.Env)
+	default:
+		return fmt.Errorf("neither file nor env variable of allowed audiences set")
+	}
+
+	auds, err := parseAudiences(data)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if strings.Join(auds, ",") != strings.Join(w.auds, ",") {
+		log.Printf("[INFO] allowed audiences set to %v", auds)
+	}
+	w.auds = auds
+	return nil
+}
+
+// Run re-reads allowed audiences every Interval till context canceled
+func (w *AudienceWatcher) Run(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultAudienceInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Load(); err != nil {
+				log.Printf("[WARN] allowed audiences not updated, %v", err)
+			}
+		}
+	}
+}
+
+// parseAudiences splits list of audiences by new lines and commas, skipping comment lines and empty values.
+// List without any audience or with control characters in audience is malformed.
+func parseAudiences(data string) ([]string, error) {
+	res := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, aud := range strings.Split(line, ",") {
+			aud = strings.TrimSpace(aud)
+			if aud == "" {
+				continue
+			}
+			if strings.IndexFunc(aud, unicode.IsControl) >= 0 {
+				return nil, fmt.Errorf("malformed audience %q", aud)
+			}
+			res = append(res, aud)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no allowed audiences")
+	}
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeAud(t *testing.T) {
+	var query string
+	handler := NormalizeAud(LowerTrimAud)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+
+	tbl := []struct {
+		query, res string
+	}{
+		{"site=App+Prod+&url=https://example.com/Post", "site=app+prod&url=https%3A%2F%2Fexample.com%2FPost"},
+		{"aud=%20App%20Prod&from=https://example.com", "aud=app+prod&from=https%3A%2F%2Fexample.com"},
+		{"site=app+prod&url=https://example.com/Post", "site=app+prod&url=https://example.com/Post"},
+		{"url=https://example.com/Post", "url=https://example.com/Post"},
+	}
+	for _, tt := range tbl {
+		req := httptest.NewRequest("GET", "/api/v1/find?"+tt.query, http.NoBody)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, tt.res, query, tt.query)
+	}
+}
+
+func TestLowerTrimAud(t *testing.T) {
+	assert.Equal(t, LowerTrimAud("app prod"), LowerTrimAud("App Prod "))
+	assert.Equal(t, "remark", LowerTrimAud("\tReMark\n"))
+	assert.Equal(t, "", LowerTrimAud(" "))
+}
+
+func TestAudienceWatcher_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "auds.txt")
+	require.NoError(t, os.WriteFile(file, []byte("# allowed sites\nremark, site1\n\nsite2\n"), 0o600))
+
+	w := &AudienceWatcher{File: file, Interval: 10 * time.Millisecond}
+	_, err := w.Get()
+	assert.EqualError(t, err, "allowed audiences not loaded")
+	require.NoError(t, w.Load())
+	auds, err := w.Get()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remark", "site1", "site2"}, auds)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	require.NoError(t, os.WriteFile(file, []byte("remark,site3"), 0o600))
+	require.Eventually(t, func() bool {
+		auds, _ = w.Get()
+		return assert.ObjectsAreEqual([]string{"remark", "site3"}, auds)
+	}, time.Second, 10*time.Millisecond, "file change updates allowed audiences")
+
+	// malformed updates don't wipe the last good list
+	for _, data := range []string{"", "# no sites\n , \n", "remark,site\x00bad"} {
+		require.NoError(t, os.WriteFile(file, []byte(data), 0o600))
+		assert.Error(t, w.Load(), data)
+		time.Sleep(30 * time.Millisecond)
+		auds, err = w.Get()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"remark", "site3"}, auds, data)
+	}
+	require.NoError(t, os.Remove(file))
+	assert.ErrorContains(t, w.Load(), "can't read allowed audiences")
+	auds, err = w.Get()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remark", "site3"}, auds)
+}
+
+func TestAudienceWatcher_Env(t *testing.T) {
+	t.Setenv("TEST_REMARK_AUDS", "remark,site1")
+	w := &AudienceWatcher{Env: "TEST_REMARK_AUDS", Interval: 10 * time.Millisecond}
+	require.NoError(t, w.Load())
+	auds, err := w.Get()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"remark", "site1"}, auds)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	t.Setenv("TEST_REMARK_AUDS", "site2")
+	require.Eventually(t, func() bool {
+		auds, _ = w.Get()
+		return assert.ObjectsAreEqual([]string{"site2"}, auds)
+	}, time.Second, 10*time.Millisecond)
+
+	t.Setenv("TEST_REMARK_AUDS", " ")
+	assert.EqualError(t, w.Load(), "no allowed audiences")
+	auds, err = w.Get()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"site2"}, auds)
+
+	assert.Error(t, (&AudienceWatcher{}).Load())
+}
@@ -0,0 +1,146 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/logger"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+)
+
+// Avatar fallback chain steps
+const (
+	AvatarProvider  = "provider"  // avatar from the auth provider
+	AvatarGravatar  = "gravatar"  // Gravatar picture by user's email
+	AvatarIdenticon = "identicon" // generated identicon, always used as the last resort
+)
+
+// avatarResolvedAttr set on user in the token once avatar fallback chain applied, prevents checks on each refresh
+const avatarResolvedAttr = "ava_resolved"
+
+// AvatarFallback updates user's avatar following the fallback chain: avatar from the auth provider,
+// Gravatar by user's email and identicon. Auth library puts identicon in case provider returns no avatar,
+// and AvatarFallback replaces it with the next available step of the chain.
+type AvatarFallback struct {
+	Proxy       *avatar.Proxy
+	Chain       []string                           // enabled steps, identicon used if none of them available
+	GravatarURL func(email string) (string, error) // returns gravatar picture url, avatar.GetGravatarURL by default
+}
+
+// Update applies fallback chain to the user's avatar. Made to be called from token.ClaimsUpdFunc,
+// after the user email is set. NoAva claim skips the chain, keeping identicon set by auth library.
+func (a *AvatarFallback) Update(c token.Claims) token.Claims {
+	if a == nil || a.Proxy == nil || c.User == nil || c.User.Picture == "" || c.User.BoolAttr(avatarResolvedAttr) {
+		return c
+	}
+	if c.NoAva {
+		c.User.SetBoolAttr(avatarResolvedAttr, true)
+		return c
+	}
+	if a.enabled(AvatarProvider) && !a.enabled(AvatarGravatar) {
+		return c // nothing to change, auth library already uses provider's avatar or identicon
+	}
+
+	isIdenticon, err := a.isIdenticon(c.User.ID, path.Base(c.User.Picture))
+	if err != nil {
+		log.Printf("[WARN] can't check avatar for %s, %v", c.User.ID, err)
+		return c
+	}
+
+	if !isIdenticon && a.enabled(AvatarProvider) {
+		c.User.SetBoolAttr(avatarResolvedAttr, true)
+		return c
+	}
+
+	if a.enabled(AvatarGravatar) {
+		if c.User.Email == "" && isIdenticon {
+			return c // not resolved, email can be set later
+		}
+		if picture, ok := a.gravatar(c.User.ID, c.User.Email); ok {
+			c.User.Picture = picture
+			c.User.SetBoolAttr(avatarResolvedAttr, true)
+			return c
+		}
+	}
+
+	if !isIdenticon { // provider's avatar not allowed, replace with identicon
+		picture, e := a.Proxy.Put(token.User{ID: c.User.ID}, nil)
+		if e != nil {
+			log.Printf("[WARN] can't set identicon for %s, %v", c.User.ID, e)
+			return c
+		}
+		c.User.Picture = picture
+	}
+	c.User.SetBoolAttr(avatarResolvedAttr, true)
+	return c
+}
+
+// gravatar stores Gravatar picture for given email. Returns false if no Gravatar found
+func (a *AvatarFallback) gravatar(userID, email string) (picture string, ok bool) {
+	if email == "" {
+		return "", false
+	}
+	gravatarURL := a.GravatarURL
+	if gravatarURL == nil {
+		gravatarURL = avatar.GetGravatarURL
+	}
+	gURL, err := gravatarURL(email)
+	if err != nil {
+		log.Printf("[DEBUG] no gravatar for %s, %v", userID, err)
+		return "", false
+	}
+	picture, err = a.Proxy.Put(token.User{ID: userID, Picture: gURL}, &http.Client{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Printf("[WARN] can't save gravatar for %s, %v", userID, err)
+		return "", false
+	}
+	return picture, true
+}
+
+// isIdenticon checks if stored avatar is the identicon generated for the user
+func (a *AvatarFallback) isIdenticon(userID, avatarID string) (bool, error) {
+	rd, _, err := a.Proxy.Store.Get(avatarID)
+	if err != nil {
+		return false, fmt.Errorf("can't load avatar %s: %w", avatarID, err)
+	}
+	defer rd.Close() // nolint
+	stored, err := io.ReadAll(rd)
+	if err != nil {
+		return false, fmt.Errorf("can't read avatar %s: %w", avatarID, err)
+	}
+
+	// generate identicon with the same proxy settings to compare stored and generated data
+	identicon := &identiconStore{NoOp: avatar.NewNoOp()}
+	gen := avatar.Proxy{L: logger.NoOp, Store: identicon, ResizeLimit: a.Proxy.ResizeLimit}
+	if _, err = gen.Put(token.User{ID: userID}, nil); err != nil {
+		return false, fmt.Errorf("can't generate identicon for %s: %w", userID, err)
+	}
+	return bytes.Equal(stored, identicon.data), nil
+}
+
+func (a *AvatarFallback) enabled(step string) bool {
+	for _, s := range a.Chain {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// identiconStore keeps the last put avatar in memory, used to get generated identicon data
+type identiconStore struct {
+	*avatar.NoOp
+	data []byte
+}
+
+// Put saves avatar data in memory
+func (s *identiconStore) Put(_ string, reader io.Reader) (avatarID string, err error) {
+	s.data, err = io.ReadAll(reader)
+	return "identicon", err
+}
@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/logger"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvatarFallback_Update(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/provider.png":
+			_, _ = w.Write([]byte("provider-avatar"))
+		case "/gravatar.png":
+			_, _ = w.Write([]byte("gravatar-avatar"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	gravatarURL := func(email string) (string, error) {
+		if email == "user@example.com" {
+			return ts.URL + "/gravatar.png", nil
+		}
+		return "", fmt.Errorf("404 Not Found")
+	}
+
+	// login makes proxied avatar the same way auth providers do, with identicon for empty picture
+	login := func(t *testing.T, proxy *avatar.Proxy, picture, email string, noAva bool) token.Claims {
+		u := token.User{ID: "user1", Name: "user one", Picture: picture, Email: email}
+		pic, err := proxy.Put(u, http.DefaultClient)
+		require.NoError(t, err)
+		u.Picture = pic
+		return token.Claims{User: &u, NoAva: noAva}
+	}
+	stored := func(t *testing.T, proxy *avatar.Proxy, c token.Claims) string {
+		rd, _, err := proxy.Store.Get("b3daa77b4c04a9551b8781d03191fe098f325e67.image")
+		require.NoError(t, err)
+		defer rd.Close()
+		data, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		assert.Equal(t, "http://localhost:8080/api/v1/avatar/b3daa77b4c04a9551b8781d03191fe098f325e67.image", c.User.Picture)
+		return string(data)
+	}
+	identicon := func(t *testing.T) string {
+		data, err := avatar.GenerateAvatar("user1")
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	tbl := []struct {
+		name     string
+		chain    []string
+		picture  string
+		email    string
+		noAva    bool
+		resolved bool
+		avatar   string
+	}{
+		{name: "provider avatar", chain: []string{AvatarProvider, AvatarGravatar, AvatarIdenticon},
+			picture: ts.URL + "/provider.png", email: "user@example.com", resolved: true, avatar: "provider-avatar"},
+		{name: "no provider avatar, gravatar", chain: []string{AvatarProvider, AvatarGravatar, AvatarIdenticon},
+			email: "user@example.com", resolved: true, avatar: "gravatar-avatar"},
+		{name: "provider avatar not allowed, gravatar", chain: []string{AvatarGravatar, AvatarIdenticon},
+			picture: ts.URL + "/provider.png", email: "user@example.com", resolved: true, avatar: "gravatar-avatar"},
+		{name: "no provider avatar, no gravatar, identicon", chain: []string{AvatarProvider, AvatarGravatar, AvatarIdenticon},
+			email: "other@example.com", resolved: true, avatar: "identicon"},
+		{name: "no provider avatar, no email yet", chain: []string{AvatarProvider, AvatarGravatar, AvatarIdenticon},
+			resolved: false, avatar: "identicon"},
+		{name: "provider avatar not allowed, no gravatar, identicon", chain: []string{AvatarGravatar, AvatarIdenticon},
+			picture: ts.URL + "/provider.png", email: "other@example.com", resolved: true, avatar: "identicon"},
+		{name: "identicon only", chain: []string{AvatarIdenticon},
+			picture: ts.URL + "/provider.png", email: "user@example.com", resolved: true, avatar: "identicon"},
+		{name: "default chain keeps provider avatar", chain: []string{AvatarProvider, AvatarIdenticon},
+			picture: ts.URL + "/provider.png", email: "user@example.com", resolved: false, avatar: "provider-avatar"},
+		{name: "no avatar requested", chain: []string{AvatarProvider, AvatarGravatar, AvatarIdenticon},
+			picture: ts.URL + "/provider.png", email: "user@example.com", noAva: true, resolved: true, avatar: "identicon"},
+	}
+
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := &avatar.Proxy{L: logger.NoOp, Store: avatar.NewLocalFS(t.TempDir()),
+				URL: "http://localhost:8080", RoutePath: "/api/v1/avatar"}
+			af := AvatarFallback{Proxy: proxy, Chain: tt.chain, GravatarURL: gravatarURL}
+
+			picture := tt.picture
+			if tt.noAva {
+				picture = "" // auth provider resets picture on no avatar request
+			}
+			c := af.Update(login(t, proxy, picture, tt.email, tt.noAva))
+			assert.Equal(t, tt.resolved, c.User.BoolAttr(avatarResolvedAttr))
+
+			expected := tt.avatar
+			if expected == "identicon" {
+				expected = identicon(t)
+			}
+			assert.Equal(t, expected, stored(t, proxy, c))
+
+			// resolved avatar is not checked again
+			if tt.resolved {
+				assert.Equal(t, c, af.Update(c))
+			}
+		})
+	}
+}
+
+func TestAvatarFallback_UpdateNoop(t *testing.T) {
+	c := token.Claims{User: &token.User{ID: "user1", Picture: "http://example.com/pic.png"}}
+
+	var af *AvatarFallback
+	assert.Equal(t, c, af.Update(c), "nil fallback")
+	assert.Equal(t, c, (&AvatarFallback{Chain: []string{AvatarGravatar}}).Update(c), "no proxy")
+
+	proxy := &avatar.Proxy{L: logger.NoOp, Store: avatar.NewLocalFS(t.TempDir())}
+	af = &AvatarFallback{Proxy: proxy, Chain: []string{AvatarGravatar}}
+	assert.Equal(t, token.Claims{}, af.Update(token.Claims{}), "no user")
+	res := af.Update(c)
+	assert.False(t, res.User.BoolAttr(avatarResolvedAttr), "avatar can't be loaded")
+}
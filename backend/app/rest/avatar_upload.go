@@ -0,0 +1,131 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // the same hash avatar store uses for ids
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // support gif avatars decoding
+	_ "image/jpeg" // support jpeg avatars decoding
+	"image/png"
+	"io"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // support webp avatars decoding
+)
+
+// MaxAvatarUploadSize limits size of avatar uploaded by the user
+const MaxAvatarUploadSize = 2 * 1024 * 1024
+
+const (
+	maxAvatarUploadSide       = 4096 // max width and height of uploaded image, larger rejected before decoding
+	defaultAvatarUploadResize = 300  // resize limit of uploaded avatar if the proxy doesn't set one
+)
+
+// avatarUploadAttr set on user in the token once uploaded avatar checked, prevents store lookups on each refresh
+const avatarUploadAttr = "ava_upload"
+
+// ErrNoAvatar returned on upload of avatar by user with NoAva claim
+var ErrNoAvatar = errors.New("avatar disabled for the user")
+
+// AvatarUpload keeps avatars uploaded by users, replacing the provider's avatar. Uploaded avatar stored apart
+// from the provider's one, as the latter is saved again on each login, and picked up by Update after login.
+type AvatarUpload struct {
+	Proxy *avatar.Proxy
+}
+
+// Put resizes uploaded avatar to the proxy's resize limit, or to defaultAvatarUploadResize if not set, and stores it
+// as png, returns url of the stored avatar. Images with sides over maxAvatarUploadSide rejected without decoding.
+// The user's claims updated to use the avatar, claims with NoAva rejected.
+func (a *AvatarUpload) Put(c *token.Claims, reader io.Reader) (string, error) {
+	if c.NoAva {
+		return "", ErrNoAvatar
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, MaxAvatarUploadSize))
+	if err != nil {
+		return "", fmt.Errorf("can't read avatar image: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("can't decode avatar image: %w", err)
+	}
+	if cfg.Width > maxAvatarUploadSide || cfg.Height > maxAvatarUploadSide {
+		return "", fmt.Errorf("avatar image %dx%d is too large, max %dx%d", cfg.Width, cfg.Height,
+			maxAvatarUploadSide, maxAvatarUploadSide)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("can't decode avatar image: %w", err)
+	}
+	limit := a.Proxy.ResizeLimit
+	if limit <= 0 {
+		limit = defaultAvatarUploadResize
+	}
+	src = resizeAvatar(src, limit)
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, src); err != nil {
+		return "", fmt.Errorf("can't encode avatar: %w", err)
+	}
+	avatarID, err := a.Proxy.Store.Put(uploadKey(c.User.ID), &buf)
+	if err != nil {
+		return "", fmt.Errorf("can't save avatar of %s: %w", c.User.ID, err)
+	}
+	c.User.Picture = a.url(avatarID)
+	c.User.SetBoolAttr(avatarUploadAttr, true)
+	log.Printf("[INFO] avatar of %s uploaded to %s", c.User.ID, avatarID)
+	return c.User.Picture, nil
+}
+
+// Update sets avatar uploaded by the user in place of the avatar set on login. Made to be called from
+// token.ClaimsUpdFunc, after AvatarFallback.Update. NoAva claim keeps identicon set by auth library.
+func (a *AvatarUpload) Update(c token.Claims) token.Claims {
+	if a == nil || a.Proxy == nil || c.User == nil || c.User.BoolAttr(avatarUploadAttr) {
+		return c
+	}
+	c.User.SetBoolAttr(avatarUploadAttr, true)
+	if c.NoAva {
+		return c
+	}
+	avatarID := uploadedAvatarID(c.User.ID)
+	rd, _, err := a.Proxy.Store.Get(avatarID)
+	if err != nil {
+		return c // nothing uploaded
+	}
+	_ = rd.Close()
+	c.User.Picture = a.url(avatarID)
+	return c
+}
+
+func (a *AvatarUpload) url(avatarID string) string {
+	return a.Proxy.URL + a.Proxy.RoutePath + "/" + avatarID
+}
+
+// uploadKey is the avatar store key of avatar uploaded by the user, the user id is the key of the provider's one
+func uploadKey(userID string) string {
+	return "upload::" + userID
+}
+
+// uploadedAvatarID returns id of uploaded avatar made by avatar store from the key, sha1 of the key with .image suffix
+func uploadedAvatarID(userID string) string {
+	return token.HashID(sha1.New(), uploadKey(userID)) + ".image" //nolint:gosec // the same hash avatar store uses
+}
+
+// resizeAvatar scales image down to the limit of the biggest side, preserving aspect ratio
+func resizeAvatar(src image.Image, limit int) image.Image {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= limit && h <= limit || w <= 0 || h <= 0 {
+		return src
+	}
+	newW, newH := w*limit/h, limit
+	if w > h {
+		newW, newH = limit, h*limit/w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/logger"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvatarUpload(t *testing.T) {
+	proxy := &avatar.Proxy{L: logger.NoOp, Store: avatar.NewLocalFS(t.TempDir()), URL: "http://localhost:8080",
+		RoutePath: "/api/v1/avatar", ResizeLimit: 10}
+	upload := &AvatarUpload{Proxy: proxy}
+
+	// login puts the provider's avatar or identicon the same way auth providers do
+	login := func(noAva bool) token.Claims {
+		u := token.User{ID: "user1", Name: "user one"}
+		pic, err := proxy.Put(u, http.DefaultClient)
+		require.NoError(t, err)
+		u.Picture = pic
+		return token.Claims{User: &u, NoAva: noAva}
+	}
+	stored := func(picture string) []byte {
+		rd, _, err := proxy.Store.Get(path.Base(picture))
+		require.NoError(t, err)
+		defer rd.Close()
+		data, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		return data
+	}
+
+	c := login(false)
+	loginPicture := c.User.Picture
+	assert.Equal(t, loginPicture, upload.Update(c).User.Picture, "nothing uploaded")
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100, 50))))
+	c = login(false)
+	picture, err := upload.Put(&c, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, picture, c.User.Picture)
+	assert.NotEqual(t, loginPicture, picture)
+	assert.True(t, strings.HasPrefix(picture, "http://localhost:8080/api/v1/avatar/"))
+	img, _, err := image.Decode(bytes.NewReader(stored(picture)))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 5), img.Bounds(), "resized to the limit")
+
+	// uploaded avatar replaces the provider's one on next login, checked once
+	c = upload.Update(login(false))
+	assert.Equal(t, picture, c.User.Picture)
+	assert.True(t, c.User.BoolAttr(avatarUploadAttr))
+	c.User.Picture = "changed"
+	assert.Equal(t, "changed", upload.Update(c).User.Picture)
+
+	// noava keeps identicon
+	c = upload.Update(login(true))
+	assert.Equal(t, loginPicture, c.User.Picture)
+	isIdenticon, err := (&AvatarFallback{Proxy: proxy}).isIdenticon("user1", path.Base(c.User.Picture))
+	require.NoError(t, err)
+	assert.True(t, isIdenticon)
+	_, err = upload.Put(&c, bytes.NewReader(stored(picture)))
+	assert.ErrorIs(t, err, ErrNoAvatar)
+
+	_, err = upload.Put(&token.Claims{User: &token.User{ID: "user1"}}, strings.NewReader("not an image"))
+	assert.Error(t, err)
+
+	buf.Reset()
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, maxAvatarUploadSide+1, 1))))
+	_, err = upload.Put(&token.Claims{User: &token.User{ID: "user1"}}, &buf)
+	assert.EqualError(t, err, "avatar image 4097x1 is too large, max 4096x4096")
+
+	// default resize limit used if proxy doesn't resize
+	buf.Reset()
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1000, 500))))
+	c = login(false)
+	picture, err = (&AvatarUpload{Proxy: &avatar.Proxy{L: logger.NoOp, Store: proxy.Store}}).Put(&c, &buf)
+	require.NoError(t, err)
+	img, _, err = image.Decode(bytes.NewReader(stored(picture)))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, defaultAvatarUploadResize, defaultAvatarUploadResize/2), img.Bounds())
+
+	var nilUpload *AvatarUpload
+	assert.Equal(t, "changed", nilUpload.Update(token.Claims{User: &token.User{Picture: "changed"}}).User.Picture)
+}
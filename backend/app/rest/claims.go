@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+)
+
+// User fields which can be set from auth provider's fields with ClaimsMapper
+const (
+	ClaimName    = "name"
+	ClaimPicture = "picture"
+)
+
+// claimAttrPrefix starts user attributes keeping provider's fields till ClaimsMapper.Update
+const claimAttrPrefix = "claim_"
+
+// ClaimMapping maps field of the user info returned by auth provider to the user field
+type ClaimMapping struct {
+	Field string // field of provider's user info
+	User  string // user field, ClaimName or ClaimPicture
+}
+
+// ClaimsMapper sets user fields from auth provider's fields configured per provider, for providers
+// returning name or avatar in non-standard fields. Provider's fields passed to user attributes
+// by the auth library with UserAttributes and moved to the user fields by Update.
+type ClaimsMapper struct {
+	Mappings map[string][]ClaimMapping // by provider name, the first non-empty field wins for the same user field
+	Proxy    *avatar.Proxy             // stores mapped picture, picture url used as is if not set
+}
+
+// UserAttributes returns provider's fields to be kept in user attributes on login with the provider
+func (m *ClaimsMapper) UserAttributes(providerName string) provider.UserAttributes {
+	res := provider.UserAttributes{}
+	if m == nil {
+		return res
+	}
+	for _, cm := range m.Mappings[providerName] {
+		res[cm.Field] = claimAttrPrefix + cm.Field
+	}
+	return res
+}
+
+// Update sets user fields from provider's fields kept in user attributes and removes these attributes,
+// so fields mapped once on login. Made to be called from token.ClaimsUpdFunc, before AvatarFallback.Update.
+func (m *ClaimsMapper) Update(c token.Claims) token.Claims {
+	if m == nil || c.User == nil || len(c.User.Attributes) == 0 {
+		return c
+	}
+	providerName, _, ok := strings.Cut(c.User.ID, "_")
+	if !ok {
+		return c
+	}
+	mapped := map[string]bool{}
+	for _, cm := range m.Mappings[providerName] {
+		val := strings.TrimSpace(c.User.StrAttr(claimAttrPrefix + cm.Field))
+		delete(c.User.Attributes, claimAttrPrefix+cm.Field)
+		if val == "" || mapped[cm.User] {
+			continue
+		}
+		switch cm.User {
+		case ClaimName:
+			c.User.Name = val
+		case ClaimPicture:
+			picture, err := m.picture(c.User.ID, val)
+			if err != nil {
+				log.Printf("[WARN] can't save mapped avatar of %s, %v", c.User.ID, err)
+				continue
+			}
+			c.User.Picture = picture
+		default:
+			continue
+		}
+		mapped[cm.User] = true
+	}
+	return c
+}
+
+// picture returns avatar url for picture url, stored by proxy if set
+func (m *ClaimsMapper) picture(userID, pictureURL string) (string, error) {
+	if m.Proxy == nil {
+		return pictureURL, nil
+	}
+	return m.Proxy.Put(token.User{ID: userID, Picture: pictureURL}, &http.Client{Timeout: 5 * time.Second})
+}
@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/logger"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimsMapper_Update(t *testing.T) {
+	m := ClaimsMapper{Mappings: map[string][]ClaimMapping{
+		"github": {{Field: "display_name", User: ClaimName}, {Field: "login", User: ClaimName}, {Field: "avatar", User: ClaimPicture}},
+		"oidc":   {{Field: "nick", User: ClaimName}, {Field: "bad", User: "email"}},
+	}}
+	assert.Equal(t, provider.UserAttributes{"display_name": "claim_display_name", "login": "claim_login", "avatar": "claim_avatar"},
+		m.UserAttributes("github"))
+	assert.Equal(t, provider.UserAttributes{}, m.UserAttributes("google"))
+
+	// login sets attributes the same way auth providers do
+	login := func(id string, data provider.UserData) token.Claims {
+		u := token.User{ID: id, Name: "standard name", Picture: "http://example.com/standard.png"}
+		u.SetBoolAttr("admin", false)
+		for k, v := range m.UserAttributes(id[:len(id)-len("_123")]) {
+			u.SetStrAttr(v, data.Value(k))
+		}
+		return token.Claims{User: &u}
+	}
+
+	tbl := []struct {
+		name          string
+		id            string
+		data          provider.UserData
+		userName, pic string
+	}{
+		{name: "first mapped field", id: "github_123",
+			data:     provider.UserData{"display_name": "Display Name", "login": "login1", "avatar": "http://example.com/ava.png"},
+			userName: "Display Name", pic: "http://example.com/ava.png"},
+		{name: "next field if first empty", id: "github_123", data: provider.UserData{"display_name": " ", "login": "login1"},
+			userName: "login1", pic: "http://example.com/standard.png"},
+		{name: "no mapped fields", id: "github_123", data: provider.UserData{"name": "other"},
+			userName: "standard name", pic: "http://example.com/standard.png"},
+		{name: "unknown user field ignored", id: "oidc_123", data: provider.UserData{"nick": "nick1", "bad": "bad@example.com"},
+			userName: "nick1", pic: "http://example.com/standard.png"},
+		{name: "provider without mapping", id: "google_123", data: provider.UserData{"display_name": "Display Name"},
+			userName: "standard name", pic: "http://example.com/standard.png"},
+	}
+
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := m.Update(login(tt.id, tt.data))
+			assert.Equal(t, tt.userName, c.User.Name)
+			assert.Equal(t, tt.pic, c.User.Picture)
+			assert.Equal(t, map[string]interface{}{"admin": false}, c.User.Attributes, "mapped attributes removed")
+			assert.Equal(t, tt.userName, m.Update(c).User.Name, "no changes on refresh")
+		})
+	}
+
+	assert.Equal(t, token.Claims{}, m.Update(token.Claims{}))
+	var nilMapper *ClaimsMapper
+	assert.Equal(t, provider.UserAttributes{}, nilMapper.UserAttributes("github"))
+	c := token.Claims{User: &token.User{ID: "github_123", Name: "name"}}
+	assert.Equal(t, c, nilMapper.Update(c))
+}
+
+func TestClaimsMapper_UpdateWithProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ava.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("mapped-avatar"))
+	}))
+	defer ts.Close()
+
+	proxy := &avatar.Proxy{L: logger.NoOp, Store: avatar.NewLocalFS(t.TempDir()),
+		URL: "http://localhost:8080", RoutePath: "/api/v1/avatar"}
+	m := ClaimsMapper{Proxy: proxy, Mappings: map[string][]ClaimMapping{"github": {{Field: "avatar", User: ClaimPicture}}}}
+
+	u := token.User{ID: "github_123", Name: "name", Picture: "http://localhost:8080/api/v1/avatar/old.image"}
+	u.SetStrAttr("claim_avatar", ts.URL+"/ava.png")
+	c := m.Update(token.Claims{User: &u})
+	require.True(t, strings.HasPrefix(c.User.Picture, "http://localhost:8080/api/v1/avatar/"), c.User.Picture)
+	rd, _, err := proxy.Store.Get(path.Base(c.User.Picture))
+	require.NoError(t, err)
+	defer rd.Close()
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	assert.Equal(t, "mapped-avatar", string(data))
+
+	// identicon used if mapped avatar can't be loaded, the same way as for provider's avatar
+	u = token.User{ID: "github_123", Name: "name", Picture: "http://localhost:8080/api/v1/avatar/old.image"}
+	u.SetStrAttr("claim_avatar", ts.URL+"/bad.png")
+	c = m.Update(token.Claims{User: &u})
+	rd2, _, err := proxy.Store.Get(path.Base(c.User.Picture))
+	require.NoError(t, err)
+	defer rd2.Close()
+	data, err = io.ReadAll(rd2)
+	require.NoError(t, err)
+	identicon, err := avatar.GenerateAvatar("github_123")
+	require.NoError(t, err)
+	assert.Equal(t, identicon, data)
+	assert.Empty(t, c.User.Attributes)
+}
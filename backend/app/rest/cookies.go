@@ -0,0 +1,174 @@
+package rest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SameSiteAuto is a middleware setting SameSite attribute of cookies issued by the wrapped handler
+// depending on the request context reported by the browser in Sec-Fetch-Site header.
+// Cross-site requests, i.e. from the comments widget embedded to another site, get SameSite=None with Secure
+// forced, as browsers reject None cookies without it. Same-site requests get SameSite=Lax.
+// Cookies left as issued if the header is not sent, i.e. by older browsers.
+func SameSiteAuto(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		mode, ok := sameSiteFor(r.Header.Get("Sec-Fetch-Site"))
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&cookieWriter{ResponseWriter: w, update: func(c *http.Cookie) {
+			c.SameSite = mode
+			if mode == http.SameSiteNoneMode {
+				c.Secure = true
+			}
+		}}, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// JWTCookieReadable returns middleware issuing JWT cookie with given name without HttpOnly attribute,
+// making it readable from JS. Made for debugging of embedded widget in development only, as it exposes the token
+// to any script running on the page.
+func JWTCookieReadable(cookieName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&cookieWriter{ResponseWriter: w, update: func(c *http.Cookie) {
+				if c.Name == cookieName {
+					c.HttpOnly = false
+				}
+			}}, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// SiteJWTCookie returns middleware keeping JWT cookies of each site, i.e. JWT and XSRF ones, under their own names,
+// see SiteCookieName, so sites served from the same domain don't overwrite and read tokens of each other. Site id
+// taken from "site" or "aud" query param, requests without it left as is. The site's cookies passed to the wrapped
+// handler under the names from cookieNames, and cookies of these names set or reset by the handler issued under
+// the site's names. Cookies of these names sent by the browser are never passed along with site id,
+// as they can be issued for another site.
+func SiteJWTCookie(cookieNames ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			siteID := r.URL.Query().Get("site")
+			if siteID == "" {
+				siteID = r.URL.Query().Get("aud")
+			}
+			if siteID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			siteCookies := make(map[string]string, len(cookieNames))  // site's cookie name by the name of cookie
+			plainCookies := make(map[string]string, len(cookieNames)) // name of cookie by the site's cookie name
+			for _, name := range cookieNames {
+				siteCookies[name] = SiteCookieName(name, siteID)
+				plainCookies[siteCookies[name]] = name
+			}
+			cookies := r.Cookies()
+			r.Header.Del("Cookie")
+			for _, c := range cookies {
+				if _, ok := siteCookies[c.Name]; ok {
+					continue
+				}
+				if name, ok := plainCookies[c.Name]; ok {
+					c.Name = name
+				}
+				r.AddCookie(c)
+			}
+			next.ServeHTTP(&cookieWriter{ResponseWriter: w, update: func(c *http.Cookie) {
+				if name, ok := siteCookies[c.Name]; ok {
+					c.Name = name
+				}
+			}}, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// SiteCookieName returns name of the site's cookie, cookieName_siteID, with characters not allowed
+// in cookie name replaced by underscore
+func SiteCookieName(cookieName, siteID string) string {
+	return cookieName + "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, siteID)
+}
+
+// sameSiteFor returns SameSite mode for Sec-Fetch-Site value, false for missing or unknown value
+func sameSiteFor(fetchSite string) (http.SameSite, bool) {
+	switch fetchSite {
+	case "cross-site":
+		return http.SameSiteNoneMode, true
+	case "same-site", "same-origin", "none": // "none" is user-initiated navigation, i.e. link opened from bookmarks
+		return http.SameSiteLaxMode, true
+	default:
+		return http.SameSiteDefaultMode, false
+	}
+}
+
+// cookieWriter rewrites Set-Cookie headers right before they sent
+type cookieWriter struct {
+	http.ResponseWriter
+	update      func(c *http.Cookie)
+	wroteHeader bool
+}
+
+// WriteHeader updates cookies and sends the status code
+func (w *cookieWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.updateCookies()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write updates cookies if headers are not sent yet and writes the data
+func (w *cookieWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered data to the client, used by streaming responses
+func (w *cookieWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes connection hijacking to the wrapped writer
+func (w *cookieWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer doesn't support hijacking")
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *cookieWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *cookieWriter) updateCookies() {
+	header := w.Header()
+	cookies := (&http.Response{Header: http.Header{"Set-Cookie": header.Values("Set-Cookie")}}).Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+	header.Del("Set-Cookie")
+	for _, c := range cookies {
+		w.update(c)
+		header.Add("Set-Cookie", c.String())
+	}
+}
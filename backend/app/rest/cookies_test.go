@@ -0,0 +1,200 @@
+package rest
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameSiteAuto(t *testing.T) {
+	handler := SameSiteAuto(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "JWT", Value: "token", Path: "/", HttpOnly: true, MaxAge: 3600})
+		http.SetCookie(w, &http.Cookie{Name: "XSRF-TOKEN", Value: "xsrf", Path: "/", Expires: time.Unix(0, 0),
+			MaxAge: -1, SameSite: http.SameSiteStrictMode})
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	tbl := []struct {
+		fetchSite string
+		sameSite  http.SameSite
+		secure    bool
+	}{
+		{fetchSite: "cross-site", sameSite: http.SameSiteNoneMode, secure: true},
+		{fetchSite: "same-site", sameSite: http.SameSiteLaxMode},
+		{fetchSite: "same-origin", sameSite: http.SameSiteLaxMode},
+		{fetchSite: "none", sameSite: http.SameSiteLaxMode},
+	}
+
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.fetchSite, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth/dev/login", http.NoBody)
+			req.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "ok", rr.Body.String())
+
+			cookies := rr.Result().Cookies()
+			require.Len(t, cookies, 2)
+			assert.Equal(t, "JWT", cookies[0].Name)
+			assert.Equal(t, "token", cookies[0].Value)
+			assert.True(t, cookies[0].HttpOnly)
+			assert.Equal(t, 3600, cookies[0].MaxAge)
+			assert.Equal(t, "XSRF-TOKEN", cookies[1].Name)
+			assert.Equal(t, -1, cookies[1].MaxAge)
+			for _, c := range cookies {
+				assert.Equal(t, tt.sameSite, c.SameSite, c.Name)
+				assert.Equal(t, tt.secure, c.Secure, c.Name)
+			}
+		})
+	}
+}
+
+func TestSameSiteAuto_Unchanged(t *testing.T) {
+	handler := SameSiteAuto(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "JWT", Value: "token", SameSite: http.SameSiteStrictMode})
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for _, fetchSite := range []string{"", "unknown"} {
+		req := httptest.NewRequest("GET", "/auth/dev/login", http.NoBody)
+		if fetchSite != "" {
+			req.Header.Set("Sec-Fetch-Site", fetchSite)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		cookies := rr.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite, "cookie kept as issued for %q", fetchSite)
+		assert.False(t, cookies[0].Secure)
+	}
+}
+
+func TestSameSiteAuto_NoCookies(t *testing.T) {
+	handler := SameSiteAuto(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+	}))
+	req := httptest.NewRequest("GET", "/api/v1/stream", http.NoBody)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.True(t, rr.Flushed)
+	assert.Empty(t, rr.Result().Cookies())
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+}
+
+func TestJWTCookieReadable(t *testing.T) {
+	issue := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "JWT", Value: "token", Path: "/", HttpOnly: true, MaxAge: 3600})
+		http.SetCookie(w, &http.Cookie{Name: "XSRF-TOKEN", Value: "xsrf", Path: "/", MaxAge: 3600})
+		http.SetCookie(w, &http.Cookie{Name: "other", Value: "val", HttpOnly: true})
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	tbl := []struct {
+		readable bool
+		handler  http.Handler
+	}{
+		{readable: false, handler: issue},
+		{readable: true, handler: JWTCookieReadable("JWT")(issue)},
+	}
+
+	for _, tt := range tbl {
+		req := httptest.NewRequest("GET", "/auth/dev/login", http.NoBody)
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+		assert.Equal(t, "ok", rr.Body.String())
+
+		cookies := rr.Result().Cookies()
+		require.Len(t, cookies, 3)
+		assert.Equal(t, "JWT", cookies[0].Name)
+		assert.Equal(t, "token", cookies[0].Value)
+		assert.Equal(t, 3600, cookies[0].MaxAge)
+		assert.Equal(t, !tt.readable, cookies[0].HttpOnly, "JWT cookie HttpOnly unless readable")
+		assert.False(t, cookies[1].HttpOnly, "XSRF cookie never HttpOnly")
+		assert.True(t, cookies[2].HttpOnly, "other cookies unchanged")
+	}
+}
+
+func TestSiteJWTCookie(t *testing.T) {
+	// handler acts as auth, issues token and xsrf cookies of the site on login, resets them on logout
+	// and reports the token and xsrf it got
+	handler := SiteJWTCookie("JWT", "XSRF-TOKEN")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/login":
+			site := r.URL.Query().Get("site")
+			http.SetCookie(w, &http.Cookie{Name: "JWT", Value: "token-" + site, Path: "/", MaxAge: 3600})
+			http.SetCookie(w, &http.Cookie{Name: "XSRF-TOKEN", Value: "xsrf-" + site, Path: "/", MaxAge: 3600})
+			_, _ = w.Write([]byte("token-" + site + ",xsrf-" + site))
+			return
+		case "/auth/logout":
+			http.SetCookie(w, &http.Cookie{Name: "JWT", Value: "", Path: "/", MaxAge: -1})
+			http.SetCookie(w, &http.Cookie{Name: "XSRF-TOKEN", Value: "", Path: "/", MaxAge: -1})
+		}
+		tkn, xsrf := "", ""
+		if c, err := r.Cookie("JWT"); err == nil {
+			tkn = c.Value
+		}
+		if c, err := r.Cookie("XSRF-TOKEN"); err == nil {
+			xsrf = c.Value
+		}
+		_, _ = w.Write([]byte(tkn + "," + xsrf))
+	}))
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := ts.Client()
+	client.Jar = jar // the same domain for both sites
+	get := func(path string) string {
+		resp, e := client.Get(ts.URL + path)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		body, e := io.ReadAll(resp.Body)
+		require.NoError(t, e)
+		return string(body)
+	}
+
+	assert.Equal(t, "token-site1,xsrf-site1", get("/auth/login?site=site1"))
+	assert.Equal(t, ",", get("/api/v1/user?site=site2"), "token of site1 not read by site2")
+	assert.Equal(t, "token-site2,xsrf-site2", get("/auth/login?site=site2"))
+	assert.Equal(t, "token-site1,xsrf-site1", get("/api/v1/user?site=site1"), "cookies of site1 not overwritten by site2")
+	assert.Equal(t, "token-site2,xsrf-site2", get("/api/v1/user?aud=site2"))
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	cookies := map[string]string{}
+	for _, c := range jar.Cookies(u) {
+		cookies[c.Name] = c.Value
+	}
+	assert.Equal(t, map[string]string{"JWT_site1": "token-site1", "XSRF-TOKEN_site1": "xsrf-site1",
+		"JWT_site2": "token-site2", "XSRF-TOKEN_site2": "xsrf-site2"}, cookies, "both sites logged in at once")
+
+	get("/auth/logout?site=site1")
+	assert.Equal(t, ",", get("/api/v1/user?site=site1"), "cookies of site1 reset")
+	assert.Equal(t, "token-site2,xsrf-site2", get("/api/v1/user?site=site2"), "cookies of site2 kept")
+
+	// plain cookies passed as is without site and never passed with site
+	jar.SetCookies(u, []*http.Cookie{{Name: "JWT", Value: "plain", Path: "/"}, {Name: "XSRF-TOKEN", Value: "xsrf", Path: "/"}})
+	assert.Equal(t, "plain,xsrf", get("/api/v1/ping"))
+	assert.Equal(t, "token-site2,xsrf-site2", get("/api/v1/user?site=site2"))
+	assert.Equal(t, ",", get("/api/v1/user?site=site1"))
+}
+
+func TestSiteCookieName(t *testing.T) {
+	assert.Equal(t, "JWT_remark", SiteCookieName("JWT", "remark"))
+	assert.Equal(t, "JWT_my-site.com", SiteCookieName("JWT", "my-site.com"))
+	assert.Equal(t, "JWT_my_site__1_", SiteCookieName("JWT", "my site;=1ы"))
+	assert.NotEqual(t, SiteCookieName("JWT", "site1"), SiteCookieName("JWT", "site2"))
+}
@@ -40,6 +40,23 @@ const (
 	ErrAssetNotFound        = 18 // requested file not found
 	ErrCommentRestrictWords = 19 // restricted words in a comment
 	ErrImgNotFound          = 20 // posted image not found in the storage
+	ErrAnonNameReserved     = 21 // anonymous name reserved by another user
+	ErrCommentTooShort      = 22 // comment text empty or smaller than min size
+	ErrCommentTooLong       = 23 // comment text or rendered comment exceeded max size
+	ErrTooManyImages        = 24 // comment has more images than allowed
+	ErrBlockedName          = 25 // user name matches blocked names
+	ErrVotingFrozen         = 26 // voting frozen for the site
+	ErrEmailNotConfirmed    = 27 // user's email not confirmed, required by the site
+	ErrPreviewDenied        = 28 // preview token missing or invalid for unpublished page
+	ErrEditReasonRequired   = 29 // moderator's edit of other user's comment without reason
+	ErrThreadLocked         = 30 // thread locked by moderator
+	ErrInvalidToken         = 31 // token missing, malformed or not signed by the site's key
+	ErrScriptNotAllowed     = 32 // comment has characters of the script not allowed for the site
+	ErrCommentCooldown      = 33 // comment posted too soon after the previous one to the same post
+	ErrQuoteNotFound        = 34 // quote of the reply not found in the parent comment
+	ErrOriginMismatch       = 35 // comment posted from origin not matching the post's host
+	ErrCommentsSuspended    = 36 // comment creation suspended by global circuit breaker
+	ErrTooManyPosts         = 37 // request of comment counts has more posts than allowed
 )
 
 // errTmplData store data for error message
@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/go-pkgz/auth/token"
+)
+
+// IssuerAttr is the user attribute with fingerprint of the instance issued the token
+const IssuerAttr = "issuer"
+
+// bootIDFile keeps id of the current boot on linux, missing on other systems
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// IssuerStamp sets fingerprint of the instance issuing the token in IssuerAttr of the user, so admins can tell
+// which node issued the token. Fingerprint is a short hash of the host name and boot id, neither revealed by it.
+type IssuerStamp struct {
+	Fingerprint string
+}
+
+// NewIssuerStamp makes IssuerStamp with fingerprint of the current instance
+func NewIssuerStamp() *IssuerStamp {
+	host, _ := os.Hostname()
+	bootID, _ := os.ReadFile(bootIDFile)
+	return &IssuerStamp{Fingerprint: issuerFingerprint(host, strings.TrimSpace(string(bootID)))}
+}
+
+// Update sets issuer fingerprint of the token. Made to be called from token.ClaimsUpdFunc, on new token
+// and on refresh, so refreshed token has fingerprint of the instance refreshed it. Does nothing for nil stamp.
+func (s *IssuerStamp) Update(c token.Claims) token.Claims {
+	if s == nil || c.User == nil {
+		return c
+	}
+	c.User.SetStrAttr(IssuerAttr, s.Fingerprint)
+	return c
+}
+
+// issuerFingerprint returns the first 12 hex chars of sha256 of host name and boot id
+func issuerFingerprint(host, bootID string) string {
+	h := sha256.Sum256([]byte("remark42-issuer::" + host + "::" + bootID))
+	return hex.EncodeToString(h[:])[:12]
+}
@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuerStamp(t *testing.T) {
+	stamp := NewIssuerStamp()
+	assert.Regexp(t, "^[0-9a-f]{12}$", stamp.Fingerprint)
+	assert.Equal(t, stamp.Fingerprint, NewIssuerStamp().Fingerprint, "the same fingerprint of the same instance")
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	assert.NotContains(t, stamp.Fingerprint, host)
+
+	assert.Equal(t, issuerFingerprint("host1", "boot1"), issuerFingerprint("host1", "boot1"))
+	assert.NotEqual(t, issuerFingerprint("host1", "boot1"), issuerFingerprint("host2", "boot1"))
+	assert.NotEqual(t, issuerFingerprint("host1", "boot1"), issuerFingerprint("host1", "boot2"), "differs after reboot")
+
+	// tokens issued by the same instance have the same fingerprint
+	svc := token.NewService(token.Opts{
+		SecretReader: token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		ClaimsUpd:    token.ClaimsUpdFunc(stamp.Update),
+	})
+	parse := func(id, userID string) token.Claims {
+		tkn, err := svc.Token(token.Claims{User: &token.User{ID: userID},
+			StandardClaims: jwt.StandardClaims{Id: id, Audience: "remark", ExpiresAt: 4102444800}})
+		require.NoError(t, err)
+		claims, err := svc.Parse(tkn)
+		require.NoError(t, err)
+		return claims
+	}
+	c1, c2 := parse("t1", "user1"), parse("t2", "user2")
+	assert.Equal(t, stamp.Fingerprint, c1.User.StrAttr(IssuerAttr))
+	assert.Equal(t, c1.User.StrAttr(IssuerAttr), c2.User.StrAttr(IssuerAttr))
+
+	var nilStamp *IssuerStamp
+	assert.Equal(t, "", nilStamp.Update(token.Claims{User: &token.User{ID: "user1"}}).User.StrAttr(IssuerAttr))
+	assert.Nil(t, stamp.Update(token.Claims{}).User)
+}
@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP derives the real client IP from the proxy header. The header is trusted only if the immediate peer
+// is one of the trusted proxies, otherwise it can be spoofed by the client and ignored.
+// Empty Header means X-Real-IP, with fallback to X-Forwarded-For.
+type RealIP struct {
+	Trusted []*net.IPNet
+	Header  string
+}
+
+// ParseTrustedProxies makes list of networks from CIDRs or single IPs
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(list))
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy ip %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %q: %w", v, err)
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+// Handler is a middleware replacing r.RemoteAddr with the client IP from the trusted proxy header
+func (p *RealIP) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if ip := p.ClientIP(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ClientIP returns the client IP from the proxy header if the request came from the trusted proxy.
+// X-Forwarded-For chain is walked from the right, skipping trusted proxies, as only the rightmost
+// untrusted entry is the one added by the trusted proxy. Returns empty string if the header not trusted or not set.
+func (p *RealIP) ClientIP(r *http.Request) string {
+	if !p.isTrusted(peerIP(r.RemoteAddr)) {
+		return ""
+	}
+
+	headers := []string{p.Header}
+	if p.Header == "" {
+		headers = []string{"X-Real-IP", "X-Forwarded-For"}
+	}
+
+	for _, h := range headers {
+		val := r.Header.Get(h)
+		if val == "" {
+			continue
+		}
+		if !strings.EqualFold(h, "X-Forwarded-For") {
+			if ip := net.ParseIP(strings.TrimSpace(val)); ip != nil {
+				return ip.String()
+			}
+			continue
+		}
+		if ip := p.forwardedFor(val); ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// forwardedFor returns the rightmost untrusted IP from X-Forwarded-For chain,
+// or the leftmost one if all of them are trusted
+func (p *RealIP) forwardedFor(val string) string {
+	parts := strings.Split(val, ",")
+	var ip net.IP
+	for i := len(parts) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(parts[i]))
+		if ip == nil {
+			return "" // broken chain can't be trusted
+		}
+		if !p.isTrusted(ip) {
+			return ip.String()
+		}
+	}
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func (p *RealIP) isTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range p.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP extracts IP from remote address, with or without port
+func peerIP(remoteAddr string) net.IP {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(remoteAddr)
+}
@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	res, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 1.2.3.4 ", "", "::1"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, "10.0.0.0/8", res[0].String())
+	assert.Equal(t, "1.2.3.4/32", res[1].String())
+	assert.Equal(t, "::1/128", res[2].String())
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/99"})
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"not-ip"})
+	assert.Error(t, err)
+}
+
+func TestRealIP_ClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	require.NoError(t, err)
+
+	tbl := []struct {
+		name    string
+		peer    string
+		header  string
+		headers map[string]string
+		res     string
+	}{
+		{name: "no headers", peer: "10.0.0.1:1234", res: ""},
+		{name: "spoofed xff from untrusted peer", peer: "1.2.3.4:1234",
+			headers: map[string]string{"X-Forwarded-For": "5.6.7.8"}, res: ""},
+		{name: "spoofed real ip from untrusted peer", peer: "1.2.3.4:1234",
+			headers: map[string]string{"X-Real-IP": "5.6.7.8"}, res: ""},
+		{name: "xff from trusted peer", peer: "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "5.6.7.8"}, res: "5.6.7.8"},
+		{name: "xff chain with spoofed entry", peer: "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 10.0.0.2"}, res: "5.6.7.8"},
+		{name: "xff chain of trusted proxies", peer: "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, res: "10.0.0.3"},
+		{name: "broken xff", peer: "10.0.0.1:1234",
+			headers: map[string]string{"X-Forwarded-For": "5.6.7.8, blah"}, res: ""},
+		{name: "real ip preferred by default", peer: "127.0.0.1:1234",
+			headers: map[string]string{"X-Real-IP": "1.1.1.1", "X-Forwarded-For": "5.6.7.8"}, res: "1.1.1.1"},
+		{name: "peer without port", peer: "127.0.0.1",
+			headers: map[string]string{"X-Real-IP": "1.1.1.1"}, res: "1.1.1.1"},
+		{name: "custom header", peer: "10.0.0.1:1234", header: "CF-Connecting-IP",
+			headers: map[string]string{"CF-Connecting-IP": "2001:db8::1", "X-Real-IP": "1.1.1.1"}, res: "2001:db8::1"},
+		{name: "custom header not set", peer: "10.0.0.1:1234", header: "CF-Connecting-IP",
+			headers: map[string]string{"X-Real-IP": "1.1.1.1"}, res: ""},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			r.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			p := RealIP{Trusted: trusted, Header: tt.header}
+			assert.Equal(t, tt.res, p.ClientIP(r))
+		})
+	}
+}
+
+func TestRealIP_Handler(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	p := RealIP{Trusted: trusted, Header: "X-Forwarded-For"}
+
+	var remoteAddr string
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	r.RemoteAddr = "1.2.3.4:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "1.2.3.4:1234", remoteAddr, "spoofed header ignored")
+
+	r = httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "5.6.7.8", remoteAddr, "header from trusted proxy honored")
+}
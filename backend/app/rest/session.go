@@ -0,0 +1,217 @@
+package rest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+)
+
+// attributes of users logged in with identity provider supporting back-channel logout, see RevokeLogout
+const (
+	SessionSIDAttr   = "provider_sid"   // session id of the identity provider
+	SessionLoginAttr = "provider_login" // login time, unix milliseconds
+)
+
+// SessionLimiter keeps active sessions of users and limits their number. Session is identified by the token id,
+// kept by the auth library on refresh, so refreshed token stays in the same session. Issuing a new session
+// beyond MaxSessions revokes the oldest sessions of the user. Sessions ended by the identity provider revoked
+// with RevokeLogout regardless of MaxSessions. Sessions kept in memory and reset on restart.
+type SessionLimiter struct {
+	MaxSessions int           // max active sessions per user and site, unlimited if 0
+	TTL         time.Duration // sessions not seen for TTL forgotten, should be not less than auth cookie TTL
+
+	lock     sync.Mutex
+	sessions map[string]map[string]session // by site and user, by token id
+	revoked  map[string]time.Time          // revoked token ids with revoke time
+	logouts  map[string]time.Time          // logouts of identity provider by "sid:"+session id or "user:"+user id
+}
+
+type session struct {
+	created time.Time
+	seen    time.Time
+}
+
+// Register adds session of the token to the user's sessions and revokes the oldest sessions over the limit.
+// Made to be called from token.ClaimsUpdFunc, on new token and on refresh.
+func (l *SessionLimiter) Register(c token.Claims) {
+	if l == nil || l.MaxSessions <= 0 || c.User == nil || c.Id == "" {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.cleanup(now)
+	if _, ok := l.revoked[c.Id]; ok {
+		return // refresh doesn't bring revoked session back
+	}
+	if l.sessions == nil {
+		l.sessions = map[string]map[string]session{}
+	}
+	key := c.Audience + "::" + c.User.ID
+	userSessions, ok := l.sessions[key]
+	if !ok {
+		userSessions = map[string]session{}
+		l.sessions[key] = userSessions
+	}
+	s, ok := userSessions[c.Id]
+	if !ok {
+		s.created = now
+	}
+	s.seen = now
+	userSessions[c.Id] = s
+
+	if len(userSessions) <= l.MaxSessions {
+		return
+	}
+	ids := make([]string, 0, len(userSessions))
+	for id := range userSessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return userSessions[ids[i]].created.Before(userSessions[ids[j]].created) })
+	if l.revoked == nil {
+		l.revoked = map[string]time.Time{}
+	}
+	for _, id := range ids[:len(ids)-l.MaxSessions] {
+		delete(userSessions, id)
+		l.revoked[id] = now
+		log.Printf("[INFO] session %s of %s revoked, over %d sessions limit", id, c.User.ID, l.MaxSessions)
+	}
+}
+
+// Revoked checks if session of the token revoked. Made to be called from token.ValidatorFunc.
+func (l *SessionLimiter) Revoked(c token.Claims) bool {
+	if l == nil || c.Id == "" {
+		return false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.revoked[c.Id]; ok {
+		return true
+	}
+	return l.loggedOut(c)
+}
+
+// RevokeLogout revokes sessions ended by the identity provider, made for back-channel logout. Sessions with
+// the provider's session id revoked if sid set, all sessions of the user logged in before the logout otherwise.
+// Sessions matched by SessionSIDAttr and SessionLoginAttr of the user set on login with the provider.
+func (l *SessionLimiter) RevokeLogout(userID, sid string) {
+	if l == nil || (userID == "" && sid == "") {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.cleanup(now)
+	if l.logouts == nil {
+		l.logouts = map[string]time.Time{}
+	}
+	if sid != "" {
+		l.logouts["sid:"+sid] = now
+		log.Printf("[INFO] sessions of provider's session %s revoked by logout", sid)
+		return
+	}
+	l.logouts["user:"+userID] = now
+	log.Printf("[INFO] sessions of %s revoked by logout", userID)
+}
+
+// loggedOut checks if session of the token ended by the identity provider. Session of the user logged out
+// without session id revoked if logged in before the logout, or if login time unknown.
+// Should be called under lock.
+func (l *SessionLimiter) loggedOut(c token.Claims) bool {
+	if len(l.logouts) == 0 || c.User == nil {
+		return false
+	}
+	if sid := c.User.StrAttr(SessionSIDAttr); sid != "" {
+		if _, ok := l.logouts["sid:"+sid]; ok {
+			return true
+		}
+	}
+	ts, ok := l.logouts["user:"+c.User.ID]
+	if !ok {
+		return false
+	}
+	login, err := strconv.ParseInt(c.User.StrAttr(SessionLoginAttr), 10, 64)
+	return err != nil || login <= ts.UnixMilli()
+}
+
+// cleanup removes sessions not seen for TTL, revoked ids and logouts older than TTL, as their tokens can't be refreshed anymore.
+// Should be called under lock.
+func (l *SessionLimiter) cleanup(now time.Time) {
+	if l.TTL <= 0 {
+		return
+	}
+	for key, userSessions := range l.sessions {
+		for id, s := range userSessions {
+			if now.Sub(s.seen) > l.TTL {
+				delete(userSessions, id)
+			}
+		}
+		if len(userSessions) == 0 {
+			delete(l.sessions, key)
+		}
+	}
+	for id, ts := range l.revoked {
+		if now.Sub(ts) > l.TTL {
+			delete(l.revoked, id)
+		}
+	}
+	for key, ts := range l.logouts {
+		if now.Sub(ts) > l.TTL {
+			delete(l.logouts, key)
+		}
+	}
+}
+
+// ProviderTTL overrides token and session durations for users of the particular auth provider, set by the provider
+// name, i.e. prefix of user id like "anonymous" or "github". Durations not set for the provider are global ones.
+// Session cookie duration is set globally by the auth library, so session limited by the session start kept in
+// user attributes, and the provider's session can't be longer than the global one.
+type ProviderTTL struct {
+	JWT    map[string]time.Duration // token duration by provider
+	Cookie map[string]time.Duration // max session duration by provider, limited by the global auth cookie duration
+}
+
+const sessionStartAttr = "session_start"
+
+// Update sets expiration of the token by the provider's token duration and keeps session start for the provider
+// with limited session. Made to be called from token.ClaimsUpdFunc, on new token and on refresh.
+func (p *ProviderTTL) Update(c token.Claims) token.Claims {
+	if p == nil || c.User == nil || c.Id == "" { // tokens without id are not session tokens, like delete_me
+		return c
+	}
+	provider := userProvider(c.User.ID)
+	if ttl := p.JWT[provider]; ttl > 0 {
+		c.ExpiresAt = time.Now().Add(ttl).Unix()
+	}
+	if ttl := p.Cookie[provider]; ttl > 0 && c.User.StrAttr(sessionStartAttr) == "" {
+		c.User.SetStrAttr(sessionStartAttr, strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	return c
+}
+
+// Expired checks if session of the token lasts longer than the provider's session duration.
+// Made to be called from token.ValidatorFunc.
+func (p *ProviderTTL) Expired(c token.Claims) bool {
+	if p == nil || c.User == nil || c.Id == "" {
+		return false
+	}
+	ttl := p.Cookie[userProvider(c.User.ID)]
+	if ttl <= 0 {
+		return false
+	}
+	start, err := strconv.ParseInt(c.User.StrAttr(sessionStartAttr), 10, 64)
+	if err != nil {
+		return false // token made before the limit set, session start will be set on refresh
+	}
+	return time.Since(time.Unix(start, 0)) > ttl
+}
+
+// userProvider returns name of auth provider from the user id made by the auth library as provider_hash
+func userProvider(userID string) string {
+	return strings.SplitN(userID, "_", 2)[0]
+}
@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionLimiter(t *testing.T) {
+	claims := func(id, userID string) token.Claims {
+		return token.Claims{User: &token.User{ID: userID}, StandardClaims: jwt.StandardClaims{Id: id, Audience: "remark"}}
+	}
+	l := &SessionLimiter{MaxSessions: 2, TTL: time.Hour}
+
+	l.Register(claims("s1", "user1"))
+	l.Register(claims("s2", "user1"))
+	l.Register(claims("o1", "user2"))
+	l.Register(claims("s1", "user1")) // refresh of the first session doesn't make it newer
+	assert.False(t, l.Revoked(claims("s1", "user1")))
+	assert.False(t, l.Revoked(claims("s2", "user1")))
+
+	l.Register(claims("s3", "user1"))
+	assert.True(t, l.Revoked(claims("s1", "user1")), "the oldest session revoked by the third one")
+	assert.False(t, l.Revoked(claims("s2", "user1")))
+	assert.False(t, l.Revoked(claims("s3", "user1")))
+	assert.False(t, l.Revoked(claims("o1", "user2")), "sessions of other users not affected")
+
+	l.Register(claims("s1", "user1")) // refresh of revoked session
+	assert.True(t, l.Revoked(claims("s1", "user1")))
+	assert.False(t, l.Revoked(claims("s2", "user1")), "revoked session not registered back")
+
+	l.lock.Lock()
+	for id := range l.revoked {
+		l.revoked[id] = time.Now().Add(-2 * time.Hour)
+	}
+	l.lock.Unlock()
+	l.Register(claims("o2", "user2"))
+	assert.False(t, l.Revoked(claims("s1", "user1")), "revoked ids forgotten after ttl")
+
+	unlimited := &SessionLimiter{}
+	for _, id := range []string{"s1", "s2", "s3"} {
+		unlimited.Register(claims(id, "user1"))
+	}
+	assert.False(t, unlimited.Revoked(claims("s1", "user1")))
+
+	var nilLimiter *SessionLimiter
+	nilLimiter.Register(claims("s1", "user1"))
+	assert.False(t, nilLimiter.Revoked(claims("s1", "user1")))
+}
+
+func TestSessionLimiter_RevokeLogout(t *testing.T) {
+	claims := func(id, userID, sid string, login time.Time) token.Claims {
+		u := &token.User{ID: userID}
+		if sid != "" {
+			u.SetStrAttr(SessionSIDAttr, sid)
+		}
+		if !login.IsZero() {
+			u.SetStrAttr(SessionLoginAttr, strconv.FormatInt(login.UnixMilli(), 10))
+		}
+		return token.Claims{User: u, StandardClaims: jwt.StandardClaims{Id: id, Audience: "remark"}}
+	}
+	l := &SessionLimiter{TTL: time.Hour} // revoked on logout without sessions limit
+	before := time.Now().Add(-time.Minute)
+
+	l.RevokeLogout("", "sid-1")
+	assert.True(t, l.Revoked(claims("s1", "user1", "sid-1", before)))
+	assert.False(t, l.Revoked(claims("s2", "user1", "sid-2", before)), "other session of the user not affected")
+	assert.False(t, l.Revoked(claims("s3", "user1", "", before)))
+
+	l.RevokeLogout("user1", "")
+	assert.True(t, l.Revoked(claims("s2", "user1", "sid-2", before)))
+	assert.True(t, l.Revoked(claims("s3", "user1", "", time.Time{})), "session with unknown login time revoked")
+	assert.False(t, l.Revoked(claims("s4", "user1", "sid-4", time.Now().Add(time.Minute))), "login after logout")
+	assert.False(t, l.Revoked(claims("o1", "user2", "", before)), "sessions of other users not affected")
+
+	l.lock.Lock()
+	for key := range l.logouts {
+		l.logouts[key] = time.Now().Add(-2 * time.Hour)
+	}
+	l.lock.Unlock()
+	l.RevokeLogout("user3", "")
+	assert.False(t, l.Revoked(claims("s1", "user1", "sid-1", before)), "logouts forgotten after ttl")
+
+	var nilLimiter *SessionLimiter
+	nilLimiter.RevokeLogout("user1", "sid-1")
+	assert.False(t, nilLimiter.Revoked(claims("s1", "user1", "sid-1", before)))
+}
+
+func TestProviderTTL(t *testing.T) {
+	claims := func(id, userID string) token.Claims {
+		return token.Claims{User: &token.User{ID: userID}, StandardClaims: jwt.StandardClaims{Id: id, Audience: "remark",
+			ExpiresAt: time.Now().Add(5 * time.Minute).Unix()}}
+	}
+	p := &ProviderTTL{JWT: map[string]time.Duration{"anonymous": time.Minute}, Cookie: map[string]time.Duration{"anonymous": time.Hour}}
+
+	anon := p.Update(claims("s1", "anonymous_123"))
+	assert.InDelta(t, time.Now().Add(time.Minute).Unix(), anon.ExpiresAt, 1, "anonymous token shorter")
+	assert.NotEmpty(t, anon.User.StrAttr(sessionStartAttr))
+	assert.False(t, p.Expired(anon))
+
+	oauth := p.Update(claims("s2", "github_123"))
+	assert.InDelta(t, time.Now().Add(5*time.Minute).Unix(), oauth.ExpiresAt, 1, "global duration for other providers")
+	assert.Empty(t, oauth.User.StrAttr(sessionStartAttr))
+	assert.False(t, p.Expired(oauth))
+
+	start := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	anon.User.SetStrAttr(sessionStartAttr, start)
+	refreshed := p.Update(anon)
+	assert.Equal(t, start, refreshed.User.StrAttr(sessionStartAttr), "session start kept on refresh")
+	assert.True(t, p.Expired(refreshed), "anonymous session over the provider's ttl")
+
+	noID := p.Update(claims("", "anonymous_123"))
+	assert.InDelta(t, time.Now().Add(5*time.Minute).Unix(), noID.ExpiresAt, 1, "tokens without id not changed")
+
+	var nilTTL *ProviderTTL
+	assert.Equal(t, claims("s1", "anonymous_123").ExpiresAt, nilTTL.Update(claims("s1", "anonymous_123")).ExpiresAt)
+	assert.False(t, nilTTL.Expired(refreshed))
+}
@@ -3,6 +3,7 @@ package rest
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/go-pkgz/auth/token"
 
@@ -27,15 +28,17 @@ func GetUserInfo(r *http.Request) (user store.User, err error) {
 	}
 
 	return store.User{
-		Name:     u.Name,
-		ID:       u.ID,
-		IP:       u.IP,
-		Picture:  u.Picture,
-		Admin:    u.IsAdmin(),
-		Verified: u.BoolAttr("verified"),
-		Blocked:  u.BoolAttr("blocked"),
-		SiteID:   u.Audience,
-		PaidSub:  u.IsPaidSub(),
+		Name:          u.Name,
+		ID:            u.ID,
+		IP:            u.IP,
+		Picture:       u.Picture,
+		Admin:         u.IsAdmin(),
+		Verified:      u.BoolAttr("verified"),
+		Blocked:       u.BoolAttr("blocked"),
+		SiteID:        u.Audience,
+		PaidSub:       u.IsPaidSub(),
+		EmailVerified: u.BoolAttr("email_verified"),
+		Trust:         u.StrAttr("trust"),
 	}, nil
 }
 
@@ -57,8 +60,10 @@ func SetUserInfo(r *http.Request, user store.User) *http.Request {
 		IP:       user.IP,
 		Audience: user.SiteID,
 		Attributes: map[string]interface{}{
-			"blocked":  user.Blocked,
-			"verified": user.Verified,
+			"blocked":        user.Blocked,
+			"verified":       user.Verified,
+			"email_verified": user.EmailVerified,
+			"trust":          user.Trust,
 		},
 	}
 	u.SetAdmin(user.Admin)
@@ -66,3 +71,10 @@ func SetUserInfo(r *http.Request, user store.User) *http.Request {
 
 	return token.SetUserInfo(r, u)
 }
+
+// SetEmailVerified stamps email_verified attribute into the token user. Users logged in with email provider
+// confirmed the address on login, other users confirmed it by subscribing to email notifications.
+// Should be called on the server side only, on each token update, so the client can't set it.
+func SetEmailVerified(u *token.User) {
+	u.SetBoolAttr("email_verified", u.Email != "" || strings.HasPrefix(u.ID, "email_"))
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/go-pkgz/auth/token"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/umputun/remark42/backend/app/store"
@@ -19,6 +20,28 @@ func TestUser_GetUserInfo(t *testing.T) {
 	u, err := GetUserInfo(r)
 	assert.NoError(t, err)
 	assert.Equal(t, store.User{Name: "test", ID: "id", SiteID: "test"}, u)
+
+	r = SetUserInfo(r, store.User{Name: "test", ID: "id", SiteID: "test", EmailVerified: true, Trust: "trusted"})
+	u, err = GetUserInfo(r)
+	assert.NoError(t, err)
+	assert.Equal(t, store.User{Name: "test", ID: "id", SiteID: "test", EmailVerified: true, Trust: "trusted"}, u)
+}
+
+func TestUser_SetEmailVerified(t *testing.T) {
+	tbl := []struct {
+		user     token.User
+		verified bool
+	}{
+		{user: token.User{ID: "github_123"}, verified: false},
+		{user: token.User{ID: "github_123", Email: "user@example.com"}, verified: true},
+		{user: token.User{ID: "email_123"}, verified: true},
+		{user: token.User{ID: "anonymous_123"}, verified: false},
+		{user: token.User{ID: "github_123", Attributes: map[string]interface{}{"email_verified": true}}, verified: false},
+	}
+	for i, tt := range tbl {
+		SetEmailVerified(&tt.user)
+		assert.Equal(t, tt.verified, tt.user.BoolAttr("email_verified"), "case %d", i)
+	}
 }
 
 func TestUser_MustGetUserInfo(t *testing.T) {
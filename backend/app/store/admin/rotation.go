@@ -0,0 +1,206 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNoPreviousKey returned by KeyRotator.PreviousKey if key not rotated or grace period is over
+var ErrNoPreviousKey = errors.New("no previous key")
+
+const keysBucket = "keys"
+
+// sharedKeyID is the id of the rotation for all sites, used if keys are not per site
+const sharedKeyID = "*"
+
+// KeyRotator keeps signing keys rotated by admins. The rotated key replaces the key of the Store for signing,
+// the previous key remains valid for verification during the grace period. Key rotation made for all sites
+// at once unless keys are per site, as the same key used to sign tokens of all sites.
+type KeyRotator struct {
+	store   Store // store with configured keys, used till the first rotation
+	db      *bolt.DB
+	perSite bool
+	lock    sync.Mutex
+	now     func() time.Time
+}
+
+// KeyStatus is the state of key rotation for a site, without the keys
+type KeyStatus struct {
+	Rotated    bool      `json:"rotated"`               // key rotated at least once, configured key not used
+	RotatedAt  time.Time `json:"rotated_at,omitempty"`  // time of the last rotation
+	Overlap    bool      `json:"overlap"`               // previous key still valid for verification
+	GraceUntil time.Time `json:"grace_until,omitempty"` // end of the overlap window
+	PerSite    bool      `json:"per_site"`              // rotation affects the site only, otherwise all sites
+}
+
+// keyRotation is the stored state of the rotation
+type keyRotation struct {
+	Key         string    `json:"key"`
+	PreviousKey string    `json:"previous_key,omitempty"`
+	RotatedAt   time.Time `json:"rotated_at"`
+	GraceUntil  time.Time `json:"grace_until,omitempty"`
+}
+
+// NewKeyRotator makes KeyRotator for keys of the store with rotated keys kept in bolt db file
+func NewKeyRotator(store Store, perSite bool, fileName string, options bolt.Options) (*KeyRotator, error) {
+	db, err := bolt.Open(fileName, 0o600, &options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make keys store %s: %w", fileName, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(keysBucket))
+		return e
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create bucket %s: %w", keysBucket, err)
+	}
+	return &KeyRotator{store: store, db: db, perSite: perSite, now: time.Now}, nil
+}
+
+// Key returns the current signing key for the site, rotated one or configured in the store
+func (k *KeyRotator) Key(siteID string) (string, error) {
+	rot, ok, err := k.load(siteID)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return k.store.Key(siteID)
+	}
+	return rot.Key, nil
+}
+
+// PreviousKey returns the key replaced by the last rotation, if the grace period is not over
+func (k *KeyRotator) PreviousKey(siteID string) (string, error) {
+	rot, ok, err := k.load(siteID)
+	if err != nil {
+		return "", err
+	}
+	if !ok || rot.PreviousKey == "" || !k.now().Before(rot.GraceUntil) {
+		return "", ErrNoPreviousKey
+	}
+	return rot.PreviousKey, nil
+}
+
+// Rotate makes new signing key for the site. The current key kept valid for verification
+// for the grace period, key replaced by earlier rotation is dropped.
+func (k *KeyRotator) Rotate(siteID string, grace time.Duration) (KeyStatus, error) {
+	if grace < 0 {
+		return KeyStatus{}, fmt.Errorf("negative grace period %v", grace)
+	}
+	key, err := newKey()
+	if err != nil {
+		return KeyStatus{}, err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	current, err := k.Key(siteID)
+	if err != nil {
+		return KeyStatus{}, fmt.Errorf("can't get current key: %w", err)
+	}
+	now := k.now()
+	rot := keyRotation{Key: key, RotatedAt: now}
+	if grace > 0 {
+		rot.PreviousKey, rot.GraceUntil = current, now.Add(grace)
+	}
+	if err = k.save(siteID, rot); err != nil {
+		return KeyStatus{}, err
+	}
+	log.Printf("[INFO] signing key rotated for %s, previous key valid till %v", k.id(siteID), rot.GraceUntil)
+	return k.status(rot, true), nil
+}
+
+// Promote ends the grace period of the previous key, tokens signed with it are not valid anymore
+func (k *KeyRotator) Promote(siteID string) (KeyStatus, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	rot, ok, err := k.load(siteID)
+	if err != nil {
+		return KeyStatus{}, err
+	}
+	if !ok {
+		return k.status(rot, false), nil
+	}
+	rot.PreviousKey, rot.GraceUntil = "", time.Time{}
+	if err = k.save(siteID, rot); err != nil {
+		return KeyStatus{}, err
+	}
+	log.Printf("[INFO] signing key promoted for %s", k.id(siteID))
+	return k.status(rot, true), nil
+}
+
+// Status returns the state of key rotation for the site
+func (k *KeyRotator) Status(siteID string) (KeyStatus, error) {
+	rot, ok, err := k.load(siteID)
+	if err != nil {
+		return KeyStatus{}, err
+	}
+	return k.status(rot, ok), nil
+}
+
+// Close closes underlying bolt db
+func (k *KeyRotator) Close() error {
+	return k.db.Close()
+}
+
+func (k *KeyRotator) status(rot keyRotation, rotated bool) KeyStatus {
+	res := KeyStatus{Rotated: rotated, PerSite: k.perSite}
+	if !rotated {
+		return res
+	}
+	res.RotatedAt = rot.RotatedAt
+	if rot.PreviousKey != "" && k.now().Before(rot.GraceUntil) {
+		res.Overlap, res.GraceUntil = true, rot.GraceUntil
+	}
+	return res
+}
+
+// id returns id of the rotation for the site, the shared one if keys are not per site
+func (k *KeyRotator) id(siteID string) string {
+	if !k.perSite {
+		return sharedKeyID
+	}
+	return siteID
+}
+
+func (k *KeyRotator) load(siteID string) (res keyRotation, ok bool, err error) {
+	err = k.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(keysBucket)).Get([]byte(k.id(siteID)))
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &res)
+	})
+	if err != nil {
+		return keyRotation{}, false, fmt.Errorf("can't load key rotation for %s: %w", siteID, err)
+	}
+	return res, ok, nil
+}
+
+func (k *KeyRotator) save(siteID string, rot keyRotation) error {
+	data, err := json.Marshal(rot)
+	if err != nil {
+		return fmt.Errorf("can't marshal key rotation: %w", err)
+	}
+	return k.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(keysBucket)).Put([]byte(k.id(siteID)), data)
+	})
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't make key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
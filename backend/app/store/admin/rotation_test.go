@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestKeyRotator_Rotate(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "keys.db")
+	kr, err := NewKeyRotator(NewStaticKeyStore("key123"), true, fileName, bolt.Options{})
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	kr.now = func() time.Time { return now }
+
+	// not rotated, configured key used
+	key, err := kr.Key("site1")
+	require.NoError(t, err)
+	assert.Equal(t, "key123", key)
+	_, err = kr.PreviousKey("site1")
+	assert.ErrorIs(t, err, ErrNoPreviousKey)
+	st, err := kr.Status("site1")
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatus{PerSite: true}, st)
+
+	// rotated, previous key valid during grace period
+	st, err = kr.Rotate("site1", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatus{Rotated: true, RotatedAt: now, Overlap: true, GraceUntil: now.Add(time.Hour), PerSite: true}, st)
+	key1, err := kr.Key("site1")
+	require.NoError(t, err)
+	assert.NotEqual(t, "key123", key1)
+	assert.Len(t, key1, 43)
+	prev, err := kr.PreviousKey("site1")
+	require.NoError(t, err)
+	assert.Equal(t, "key123", prev)
+
+	// other sites not affected
+	key, err = kr.Key("site2")
+	require.NoError(t, err)
+	assert.Equal(t, "key123", key)
+
+	// grace period is over
+	now = now.Add(time.Hour)
+	_, err = kr.PreviousKey("site1")
+	assert.ErrorIs(t, err, ErrNoPreviousKey)
+	st, err = kr.Status("site1")
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatus{Rotated: true, RotatedAt: now.Add(-time.Hour), PerSite: true}, st)
+
+	// rotated again, the last key becomes previous
+	_, err = kr.Rotate("site1", time.Hour)
+	require.NoError(t, err)
+	key2, err := kr.Key("site1")
+	require.NoError(t, err)
+	assert.NotEqual(t, key1, key2)
+	prev, err = kr.PreviousKey("site1")
+	require.NoError(t, err)
+	assert.Equal(t, key1, prev)
+
+	// promoted, previous key not valid
+	st, err = kr.Promote("site1")
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatus{Rotated: true, RotatedAt: now, PerSite: true}, st)
+	_, err = kr.PreviousKey("site1")
+	assert.ErrorIs(t, err, ErrNoPreviousKey)
+	key, err = kr.Key("site1")
+	require.NoError(t, err)
+	assert.Equal(t, key2, key)
+
+	// promote of not rotated site does nothing
+	st, err = kr.Promote("site2")
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatus{PerSite: true}, st)
+
+	// rotation without grace period drops the current key at once
+	_, err = kr.Rotate("site2", 0)
+	require.NoError(t, err)
+	_, err = kr.PreviousKey("site2")
+	assert.ErrorIs(t, err, ErrNoPreviousKey)
+	_, err = kr.Rotate("site2", -time.Second)
+	assert.EqualError(t, err, "negative grace period -1s")
+
+	// rotated keys persisted
+	require.NoError(t, kr.Close())
+	kr, err = NewKeyRotator(NewStaticKeyStore("key123"), true, fileName, bolt.Options{})
+	require.NoError(t, err)
+	defer kr.Close()
+	key, err = kr.Key("site1")
+	require.NoError(t, err)
+	assert.Equal(t, key2, key)
+}
+
+func TestKeyRotator_Shared(t *testing.T) {
+	kr, err := NewKeyRotator(NewStaticKeyStore("key123"), false, filepath.Join(t.TempDir(), "keys.db"), bolt.Options{})
+	require.NoError(t, err)
+	defer kr.Close()
+
+	st, err := kr.Rotate("site1", time.Hour)
+	require.NoError(t, err)
+	assert.False(t, st.PerSite)
+	key1, err := kr.Key("site1")
+	require.NoError(t, err)
+	key2, err := kr.Key("site2")
+	require.NoError(t, err)
+	assert.Equal(t, key1, key2, "same key for all sites")
+	assert.NotEqual(t, "key123", key1)
+	prev, err := kr.PreviousKey("ignore")
+	require.NoError(t, err)
+	assert.Equal(t, "key123", prev)
+}
+
+func TestKeyRotator_Errors(t *testing.T) {
+	_, err := NewKeyRotator(NewStaticKeyStore("key123"), true, filepath.Join(t.TempDir(), "no-such-dir", "keys.db"), bolt.Options{})
+	assert.Error(t, err)
+
+	kr, err := NewKeyRotator(NewStaticKeyStore(""), true, filepath.Join(t.TempDir(), "keys.db"), bolt.Options{})
+	require.NoError(t, err)
+	defer kr.Close()
+	_, err = kr.Key("site1")
+	assert.EqualError(t, err, "empty key for static key store")
+	_, err = kr.Rotate("site1", time.Hour)
+	assert.EqualError(t, err, "can't get current key: empty key for static key store")
+}
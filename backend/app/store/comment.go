@@ -20,15 +20,35 @@ type Comment struct {
 	Locator     Locator                `json:"locator"`
 	Score       int                    `json:"score"`
 	Votes       map[string]bool        `json:"votes,omitempty"`
-	VotedIPs    map[string]VotedIPInfo `json:"voted_ips,omitempty"` // voted ips (hashes) with TS
-	Vote        int                    `json:"vote"`                // vote for the current user, -1/1/0.
+	VotedIPs    map[string]VotedIPInfo `json:"voted_ips,omitempty"`    // voted ips (hashes) with TS
+	VoteWeights map[string]int         `json:"vote_weights,omitempty"` // weights of votes other than 1, by user id
+	Vote        int                    `json:"vote"`                   // vote for the current user, -1/1/0.
 	Controversy float64                `json:"controversy,omitempty"`
+	Reactions   map[string]int         `json:"reactions,omitempty" bson:"reactions,omitempty"` // emoji to number of reactions
+	Reacted     map[string][]string    `json:"reacted,omitempty" bson:"reacted,omitempty"`     // emoji reacted by user id, hidden from readers
 	Timestamp   time.Time              `json:"time" bson:"time"`
 	Edit        *Edit                  `json:"edit,omitempty" bson:"edit,omitempty"` // pointer to have empty default in json response
 	Pin         bool                   `json:"pin,omitempty" bson:"pin,omitempty"`
+	PinModOnly  bool                   `json:"pin_mod_only,omitempty" bson:"pin_mod_only,omitempty"` // pin affects order for moderators only
+	Locked      bool                   `json:"locked,omitempty" bson:"locked,omitempty"`             // thread started by the comment locked by moderator
 	Deleted     bool                   `json:"delete,omitempty" bson:"delete"`
+	Redacted    bool                   `json:"redacted,omitempty" bson:"redacted,omitempty"` // text removed by the author, replies and authorship kept
 	Imported    bool                   `json:"imported,omitempty" bson:"imported"`
 	PostTitle   string                 `json:"title,omitempty" bson:"title"`
+	Quote       string                 `json:"quote,omitempty" bson:"quote,omitempty"`     // excerpt of the parent comment quoted in reply, plain text
+	Lang        string                 `json:"lang,omitempty" bson:"lang,omitempty"`       // language tag like "en" or "pt-br", declared by client or detected
+	History     []CommentVersion       `json:"history,omitempty" bson:"history,omitempty"` // prior versions, for moderators only
+	Reports     map[string]bool        `json:"reports,omitempty" bson:"reports,omitempty"` // ids of users reported the comment, for moderators only
+	Hidden      bool                   `json:"hidden,omitempty" bson:"hidden,omitempty"`   // hidden from readers pending review after reports
+	Trashed     *Trashed               `json:"trashed,omitempty" bson:"trashed,omitempty"` // deleted comment kept for restore, for moderators only
+	Depth       int                    `json:"depth,omitempty" bson:"-"`                   // level in the comments tree, 0 for root, computed on read
+	Collapsed   bool                   `json:"collapsed,omitempty" bson:"-"`               // score below collapse threshold, computed on read
+}
+
+// Trashed keeps comment deleted by moderator as it was before deletion, till restored or purged
+type Trashed struct {
+	Timestamp time.Time `json:"time" bson:"time"` // time of deletion
+	Comment   Comment   `json:"comment"`
 }
 
 // Locator keeps site and url of the post
@@ -41,6 +61,16 @@ type Locator struct {
 type Edit struct {
 	Timestamp time.Time `json:"time" bson:"time"`
 	Summary   string    `json:"summary"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"` // set on moderator's edit of other user's comment
+}
+
+// CommentVersion is a version of the comment text kept in the edit history
+type CommentVersion struct {
+	Text      string    `json:"text"`
+	Orig      string    `json:"orig,omitempty"`
+	Timestamp time.Time `json:"time" bson:"time"` // time the version was created, by post or edit
+	Summary   string    `json:"summary,omitempty"`
+	Reason    string    `json:"reason,omitempty"` // moderator's edit reason
 }
 
 // PostInfo holds summary for given post url
@@ -70,8 +100,9 @@ type DeleteMode int
 
 // DeleteMode enum
 const (
-	SoftDelete DeleteMode = 0
-	HardDelete DeleteMode = 1
+	SoftDelete      DeleteMode = 0
+	HardDelete      DeleteMode = 1
+	AnonymizeDelete DeleteMode = 2 // keeps comment text, replaces user info with "deleted user"
 )
 
 // Maximum length for URL text shortening.
@@ -85,12 +116,24 @@ func (c *Comment) PrepareUntrusted() {
 	c.Timestamp = time.Time{} // reset time, force auto-gen
 	c.Votes = make(map[string]bool)
 	c.VotedIPs = make(map[string]VotedIPInfo)
+	c.VoteWeights = nil
 	c.Score = 0
 	c.Controversy = 0
+	c.Reactions = nil
+	c.Reacted = nil
 	c.Edit = nil
+	c.History = nil
+	c.Reports = nil
+	c.Hidden = false
+	c.Trashed = nil
 	c.Pin = false
+	c.PinModOnly = false
+	c.Locked = false
 	c.Deleted = false
+	c.Redacted = false
 	c.Imported = false
+	c.Depth = 0
+	c.Collapsed = false
 }
 
 // SetDeleted clears comment info, reset to deleted state. hard flag will clear all user info as well
@@ -101,9 +144,17 @@ func (c *Comment) SetDeleted(mode DeleteMode) {
 	c.Controversy = 0
 	c.Votes = map[string]bool{}
 	c.VotedIPs = make(map[string]VotedIPInfo)
+	c.VoteWeights = nil
+	c.Reactions = nil
+	c.Reacted = nil
 	c.Edit = nil
+	c.History = nil
+	c.Reports = nil
+	c.Hidden = false
+	c.Trashed = nil
 	c.Deleted = true
 	c.Pin = false
+	c.PinModOnly = false
 
 	if mode == HardDelete {
 		c.User.Name = "deleted"
@@ -113,6 +164,41 @@ func (c *Comment) SetDeleted(mode DeleteMode) {
 	}
 }
 
+// SetRedacted clears text of the comment on author's request, keeping user info, votes and place in the thread
+func (c *Comment) SetRedacted() {
+	c.Text = ""
+	c.Orig = ""
+	c.Edit = nil
+	c.History = nil
+	c.Redacted = true
+}
+
+// Version returns the current version of the comment text
+func (c *Comment) Version() CommentVersion {
+	res := CommentVersion{Text: c.Text, Orig: c.Orig, Timestamp: c.Timestamp}
+	if c.Edit != nil {
+		res.Timestamp, res.Summary, res.Reason = c.Edit.Timestamp, c.Edit.Summary, c.Edit.Reason
+	}
+	return res
+}
+
+// Anonymize replaces user info with "deleted user", keeping comment text and thread structure
+func (c *Comment) Anonymize() {
+	c.User = User{Name: "deleted user", ID: "deleted"}
+}
+
+// ReactionsCount returns number of reactions with given emoji, or total number of all reactions for empty emoji
+func (c *Comment) ReactionsCount(emoji string) int {
+	if emoji != "" {
+		return c.Reactions[emoji]
+	}
+	res := 0
+	for _, v := range c.Reactions {
+		res += v
+	}
+	return res
+}
+
 // Sanitize clean dangerous html/js from the comment.
 // Comment.Orig which is used to store the original comment text is not sanitized
 // as we expect to never render it as HTML and render Comment.Text instead
@@ -128,6 +214,9 @@ func (c *Comment) Sanitize() {
 		"|vi|vm|l|ld|s|sa|sb|sc|dl|sd|s2|se|sh|si|sx|sr|s1|ss|m|mb|mf|mh|mi|il" +
 		"|mo|o|ow|p|c|ch|cm|cp|cpf|c1|cs|g|gd|ge|gr|gh|gi|go|gp|gs|gu|gt|gl)$"
 	p.AllowAttrs("class").Matching(regexp.MustCompile(codeSpanClassRegex)).OnElements("span")
+	// preview cards of links made by oEmbed previewer
+	p.AllowAttrs("class").Matching(regexp.MustCompile("^oembed-preview$")).OnElements("div")
+	p.AllowAttrs("class").Matching(regexp.MustCompile("^oembed-(title|provider)$")).OnElements("span")
 	p.AllowAttrs("loading").Matching(regexp.MustCompile("^(lazy|eager)$")).OnElements("img")
 	c.Text = p.Sanitize(c.Text)
 	c.User.ID = template.HTMLEscapeString(c.User.ID)
@@ -135,6 +224,7 @@ func (c *Comment) Sanitize() {
 	c.User.Picture = c.SanitizeAsURL(c.User.Picture)
 	c.Locator.URL = c.SanitizeAsURL(c.Locator.URL)
 	c.PostTitle = c.SanitizeText(c.PostTitle)
+	c.Quote = c.SanitizeText(c.Quote)
 }
 
 // Snippet from comment's text
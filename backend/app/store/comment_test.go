@@ -100,6 +100,10 @@ func TestComment_Sanitize(t *testing.T) {
 			inp: Comment{Text: `<blockquote class="twitter-tweet"><p lang="es" dir="ltr">Silicon iMac Concept<a href="https://t.co/7ga95QxVXn">https://t.co/7ga95QxVXn</a> by <a href="https://twitter.com/marcsheep?ref_src=twsrc%5Etfw">@marcsheep</a> <a href="https://t.co/ULnVpG8w55">pic.twitter.com/ULnVpG8w55</a></p>&mdash; Andreas Storm (@avstorm) <a href="https://twitter.com/avstorm/status/1325693387798933504?ref_src=twsrc%5Etfw">November 9, 2020</a></blockquote> <script async src="https://platform.twitter.com/widgets.js" charset="utf-8"></script>`, PostTitle: "Twitter quote"},
 			out: Comment{Text: `<blockquote class="twitter-tweet"><p lang="es" dir="ltr">Silicon iMac Concept<a href="https://t.co/7ga95QxVXn" rel="nofollow">https://t.co/7ga95QxVXn</a> by <a href="https://twitter.com/marcsheep?ref_src=twsrc%5Etfw" rel="nofollow">@marcsheep</a> <a href="https://t.co/ULnVpG8w55" rel="nofollow">pic.twitter.com/ULnVpG8w55</a></p>— Andreas Storm (@avstorm) <a href="https://twitter.com/avstorm/status/1325693387798933504?ref_src=twsrc%5Etfw" rel="nofollow">November 9, 2020</a></blockquote> `, PostTitle: "Twitter quote"},
 		},
+		{
+			inp: Comment{Text: `<div class="oembed-preview"><a href="https://youtu.be/123"><img src="https://i.ytimg.com/vi/123/hq.jpg" alt="" loading="lazy"/><span class="oembed-title">Title</span></a><span class="oembed-provider">YouTube</span></div><div class="other"><span class="oembed-other">x</span></div>`},
+			out: Comment{Text: `<div class="oembed-preview"><a href="https://youtu.be/123" rel="nofollow"><img src="https://i.ytimg.com/vi/123/hq.jpg" alt="" loading="lazy"/><span class="oembed-title">Title</span></a><span class="oembed-provider">YouTube</span></div><div><span>x</span></div>`},
+		},
 	}
 
 	for n, tt := range tbl {
@@ -123,7 +127,11 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 		Timestamp:   time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:       map[string]bool{"uu": true},
 		Controversy: 123,
+		Reactions:   map[string]int{"👍": 3},
 		Imported:    true,
+		History:     []CommentVersion{{Text: "fake"}},
+		VoteWeights: map[string]int{"uu": 100},
+		Reacted:     map[string][]string{"uu": {"👍"}},
 	}
 
 	comment.PrepareUntrusted()
@@ -138,9 +146,21 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 	assert.Equal(t, make(map[string]VotedIPInfo), comment.VotedIPs)
 	assert.Equal(t, User{ID: "username"}, comment.User)
 	assert.Equal(t, 0., comment.Controversy)
+	assert.Nil(t, comment.Reactions)
+	assert.Nil(t, comment.History)
+	assert.Nil(t, comment.VoteWeights)
+	assert.Nil(t, comment.Reacted)
 	assert.Equal(t, false, comment.Imported)
 }
 
+func TestComment_ReactionsCount(t *testing.T) {
+	comment := Comment{Reactions: map[string]int{"👍": 3, "🎉": 2}}
+	assert.Equal(t, 5, comment.ReactionsCount(""))
+	assert.Equal(t, 3, comment.ReactionsCount("👍"))
+	assert.Equal(t, 0, comment.ReactionsCount("😄"))
+	assert.Equal(t, 0, (&Comment{}).ReactionsCount(""))
+}
+
 func TestComment_SetDeleted(t *testing.T) {
 	comment := Comment{
 		Text:      `blah`,
@@ -153,6 +173,7 @@ func TestComment_SetDeleted(t *testing.T) {
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
 		Pin:       true,
+		History:   []CommentVersion{{Text: "old blah"}},
 	}
 
 	comment.SetDeleted(SoftDelete)
@@ -164,6 +185,7 @@ func TestComment_SetDeleted(t *testing.T) {
 	assert.Equal(t, 0, comment.Score)
 	assert.True(t, comment.Deleted)
 	assert.Nil(t, comment.Edit)
+	assert.Nil(t, comment.History)
 	assert.False(t, comment.Pin)
 	assert.Equal(t, User{Name: "username", ID: "userid", Picture: "pic", Admin: false, Blocked: false, IP: "123"}, comment.User)
 }
@@ -195,6 +217,64 @@ func TestComment_SetDeletedHard(t *testing.T) {
 	assert.Equal(t, User{Name: "deleted", ID: "deleted", Picture: "", Admin: false, Blocked: false, IP: ""}, comment.User)
 }
 
+func TestComment_SetRedacted(t *testing.T) {
+	comment := Comment{
+		Text:      `<p>blah</p>`,
+		Orig:      "blah",
+		User:      User{ID: "userid", Name: "username", IP: "123", Picture: "pic"},
+		ParentID:  "p123",
+		ID:        "123",
+		Score:     10,
+		Votes:     map[string]bool{"uu": true},
+		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
+		Edit:      &Edit{Summary: "fix"},
+		History:   []CommentVersion{{Text: "old blah"}},
+	}
+
+	comment.SetRedacted()
+
+	assert.Equal(t, "", comment.Text)
+	assert.Equal(t, "", comment.Orig)
+	assert.True(t, comment.Redacted)
+	assert.False(t, comment.Deleted)
+	assert.Nil(t, comment.Edit)
+	assert.Nil(t, comment.History)
+	assert.Equal(t, 10, comment.Score)
+	assert.Equal(t, "p123", comment.ParentID)
+	assert.Equal(t, User{ID: "userid", Name: "username", IP: "123", Picture: "pic"}, comment.User)
+}
+
+func TestComment_Version(t *testing.T) {
+	ts := time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local)
+	comment := Comment{Text: "<p>blah</p>", Orig: "blah", Timestamp: ts}
+	assert.Equal(t, CommentVersion{Text: "<p>blah</p>", Orig: "blah", Timestamp: ts}, comment.Version())
+
+	comment.Edit = &Edit{Timestamp: ts.Add(time.Minute), Summary: "fix"}
+	assert.Equal(t, CommentVersion{Text: "<p>blah</p>", Orig: "blah", Timestamp: ts.Add(time.Minute), Summary: "fix"},
+		comment.Version(), "edit time and summary used for edited comment")
+}
+
+func TestComment_Anonymize(t *testing.T) {
+	comment := Comment{
+		Text:      `blah`,
+		User:      User{ID: "userid", Name: "username", IP: "123", Picture: "pic", Verified: true},
+		ParentID:  "p123",
+		ID:        "123",
+		Locator:   Locator{SiteID: "site", URL: "url"},
+		Score:     10,
+		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
+		Votes:     map[string]bool{"uu": true},
+	}
+
+	comment.Anonymize()
+
+	assert.Equal(t, "blah", comment.Text, "text kept")
+	assert.Equal(t, "p123", comment.ParentID, "thread structure kept")
+	assert.Equal(t, 10, comment.Score)
+	assert.False(t, comment.Deleted)
+	assert.Equal(t, User{Name: "deleted user", ID: "deleted"}, comment.User)
+}
+
 func TestComment_Snippet(t *testing.T) {
 	tbl := []struct {
 		limit int
@@ -31,16 +31,20 @@ type BoltDB struct {
 
 const (
 	// top level buckets
-	postsBucketName       = "posts"
-	lastBucketName        = "last"
-	userBucketName        = "users"
-	userDetailsBucketName = "user_details"
-	blocksBucketName      = "block"
-	infoBucketName        = "info"
-	readonlyBucketName    = "readonly"
-	verifiedBucketName    = "verified"
+	postsBucketName        = "posts"
+	lastBucketName         = "last"
+	userBucketName         = "users"
+	userDetailsBucketName  = "user_details"
+	blocksBucketName       = "block"
+	infoBucketName         = "info"
+	readonlyBucketName     = "readonly"
+	verifiedBucketName     = "verified"
+	unsubscribedBucketName = "unsubscribed"
+	votingFrozenBucketName = "voting_frozen"
 
 	tsNano = "2006-01-02T15:04:05.000000000Z07:00"
+
+	iterateBatch = 100 // number of comments read in a single transaction by Iterate
 )
 
 // BoltSite defines single site param
@@ -61,7 +65,8 @@ func NewBoltDB(options bolt.Options, sites ...BoltSite) (*BoltDB, error) {
 
 		// make top-level buckets
 		topBuckets := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName,
-			blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName}
+			blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName, unsubscribedBucketName,
+			votingFrozenBucketName}
 		err = db.Update(func(tx *bolt.Tx) error {
 			for _, bktName := range topBuckets {
 				if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
@@ -193,6 +198,152 @@ func (b *BoltDB) Find(req FindRequest) (comments []store.Comment, err error) {
 	return SortComments(comments, req.Sort), nil
 }
 
+// Reassign sets new author of all user's comments, references moved to the new user's bucket.
+// References of the new user with the same timestamp as moved ones are kept.
+func (b *BoltDB) Reassign(req ReassignRequest) (count int, err error) {
+	if req.UserID == "" || req.NewUser.ID == "" {
+		return 0, fmt.Errorf("both user ids required")
+	}
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		usersBkt := tx.Bucket([]byte(userBucketName))
+		fromBkt := usersBkt.Bucket([]byte(req.UserID))
+		if fromBkt == nil {
+			return nil // no comments
+		}
+		toBkt, e := b.getUserBucket(tx, req.NewUser.ID)
+		if e != nil {
+			return e
+		}
+		e = fromBkt.ForEach(func(k, ref []byte) error {
+			url, id, e := b.parseRef(ref)
+			if e != nil {
+				return e
+			}
+			postBkt, e := b.getPostBucket(tx, url)
+			if e != nil {
+				return e
+			}
+			comment := store.Comment{}
+			if e = b.load(postBkt, id, &comment); e != nil {
+				return fmt.Errorf("can't load key %s from bucket %s: %w", id, url, e)
+			}
+			ip := comment.User.IP
+			comment.User = req.NewUser
+			comment.User.IP = ip
+			if e = b.save(postBkt, id, comment); e != nil {
+				return fmt.Errorf("can't save comment %s: %w", id, e)
+			}
+			count++
+			if toBkt.Get(k) != nil {
+				return nil
+			}
+			return toBkt.Put(k, ref)
+		})
+		if e != nil {
+			return e
+		}
+		return usersBkt.DeleteBucket([]byte(req.UserID))
+	})
+	return count, err
+}
+
+// Restore saves deleted comment back, with mutable fields of the passed comment. Comment counted for the post
+// and listed in last comments again.
+func (b *BoltDB) Restore(comment store.Comment) error {
+	bdb, err := b.db(comment.Locator.SiteID)
+	if err != nil {
+		return err
+	}
+
+	return bdb.Update(func(tx *bolt.Tx) error {
+		postBkt, e := b.getPostBucket(tx, comment.Locator.URL)
+		if e != nil {
+			return e
+		}
+		cur := store.Comment{}
+		if e = b.load(postBkt, comment.ID, &cur); e != nil {
+			return fmt.Errorf("can't load key %s from bucket %s: %w", comment.ID, comment.Locator.URL, e)
+		}
+		if !cur.Deleted {
+			return fmt.Errorf("comment %s not deleted", comment.ID)
+		}
+
+		// preserve immutable fields, the same as Update does
+		comment.ParentID, comment.Locator, comment.Timestamp, comment.User = cur.ParentID, cur.Locator, cur.Timestamp, cur.User
+		comment.Deleted = false
+		if e = b.save(postBkt, comment.ID, comment); e != nil {
+			return fmt.Errorf("can't save restored comment %s: %w", comment.ID, e)
+		}
+		if _, e = b.count(tx, comment.Locator.URL, 1); e != nil {
+			return fmt.Errorf("failed to increment count for %s: %w", comment.Locator, e)
+		}
+		lastBkt := tx.Bucket([]byte(lastBucketName))
+		if e = lastBkt.Put([]byte(comment.Timestamp.Format(tsNano)), b.makeRef(comment)); e != nil {
+			return fmt.Errorf("can't put reference %s to %s: %w", comment.ID, lastBucketName, e)
+		}
+		return nil
+	})
+}
+
+// Iterate calls fn for each comment of the post in order of comment ids, stops on the first error returned by fn.
+// Comments read in batches and fn called outside of transaction, so slow consumer doesn't block the store.
+func (b *BoltDB) Iterate(req FindRequest, fn func(store.Comment) error) error {
+	if req.Locator.SiteID == "" || req.Locator.URL == "" {
+		return fmt.Errorf("site and post url required for iteration")
+	}
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return err
+	}
+
+	var lastKey []byte // key of the last read comment, next batch starts after it
+	for {
+		batch, read := make([]store.Comment, 0, iterateBatch), 0
+		err = bdb.View(func(tx *bolt.Tx) error {
+			bucket, e := b.getPostBucket(tx, req.Locator.URL)
+			if e != nil {
+				return e
+			}
+			c := bucket.Cursor()
+			k, v := c.First()
+			if lastKey != nil {
+				if k, v = c.Seek(lastKey); bytes.Equal(k, lastKey) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && read < iterateBatch; k, v = c.Next() {
+				read++
+				comment := store.Comment{}
+				if e = json.Unmarshal(v, &comment); e != nil {
+					return fmt.Errorf("failed to unmarshal: %w", e)
+				}
+				if req.Since.IsZero() || comment.Timestamp.After(req.Since) {
+					batch = append(batch, comment)
+				}
+				lastKey = append(lastKey[:0], k...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, comment := range batch {
+			if err = fn(comment); err != nil {
+				return err
+			}
+		}
+		if read < iterateBatch {
+			return nil
+		}
+	}
+}
+
 // Flag sets and gets flag values
 func (b *BoltDB) Flag(req FlagRequest) (val bool, err error) {
 	if req.Update == FlagNonSet { // read flag value, no update requested
@@ -208,7 +359,7 @@ func (b *BoltDB) Flag(req FlagRequest) (val bool, err error) {
 // and all site's details listing under the same function (and not to extend interface by two separate functions).
 func (b *BoltDB) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
 	switch req.Detail {
-	case UserEmail, UserTelegram:
+	case UserEmail, UserTelegram, UserAnonName, UserNotifyPrefs:
 		if req.UserID == "" {
 			return nil, fmt.Errorf("userid cannot be empty in request for single detail")
 		}
@@ -539,10 +690,7 @@ func (b *BoltDB) checkFlag(req FlagRequest) (val bool) {
 		return false
 	}
 
-	key := req.Locator.URL
-	if req.UserID != "" {
-		key = req.UserID
-	}
+	key := b.flagKey(req)
 
 	if req.Flag == Blocked {
 		var blocked bool
@@ -582,10 +730,7 @@ func (b *BoltDB) setFlag(req FlagRequest) (res bool, err error) {
 		return false, e
 	}
 
-	key := req.Locator.URL
-	if req.UserID != "" {
-		key = req.UserID
-	}
+	key := b.flagKey(req)
 
 	err = bdb.Update(func(tx *bolt.Tx) error {
 		var bucket *bolt.Bucket
@@ -623,6 +768,21 @@ func (b *BoltDB) setFlag(req FlagRequest) (res bool, err error) {
 	return res, err
 }
 
+// flagKey makes the key flag stored with. Post flags keyed by url, user flags by user id
+// and per-post user flags, like unsubscribed, by combination of both
+func (b *BoltDB) flagKey(req FlagRequest) string {
+	if req.Flag == Unsubscribed {
+		return fmt.Sprintf("%s!!%s", req.Locator.URL, req.UserID)
+	}
+	if req.Flag == VotingFrozen {
+		return req.Locator.SiteID
+	}
+	if req.UserID != "" {
+		return req.UserID
+	}
+	return req.Locator.URL
+}
+
 func (b *BoltDB) flagBucket(tx *bolt.Tx, flag Flag) (bkt *bolt.Bucket, err error) {
 	switch flag {
 	case ReadOnly:
@@ -631,6 +791,10 @@ func (b *BoltDB) flagBucket(tx *bolt.Tx, flag Flag) (bkt *bolt.Bucket, err error
 		bkt = tx.Bucket([]byte(blocksBucketName))
 	case Verified:
 		bkt = tx.Bucket([]byte(verifiedBucketName))
+	case Unsubscribed:
+		bkt = tx.Bucket([]byte(unsubscribedBucketName))
+	case VotingFrozen:
+		bkt = tx.Bucket([]byte(votingFrozenBucketName))
 	default:
 		return nil, fmt.Errorf("unsupported flag %v", flag)
 	}
@@ -659,6 +823,10 @@ func (b *BoltDB) getUserDetail(req UserDetailRequest) (result []UserDetailEntry,
 				result = []UserDetailEntry{{UserID: req.UserID, Email: entry.Email}}
 			case UserTelegram:
 				result = []UserDetailEntry{{UserID: req.UserID, Telegram: entry.Telegram}}
+			case UserAnonName:
+				result = []UserDetailEntry{{UserID: req.UserID, AnonName: entry.AnonName}}
+			case UserNotifyPrefs:
+				result = []UserDetailEntry{{UserID: req.UserID, NotifyPrefs: entry.NotifyPrefs}}
 			}
 		}
 		return nil
@@ -701,6 +869,10 @@ func (b *BoltDB) setUserDetail(req UserDetailRequest) (result []UserDetailEntry,
 		entry.Email = req.Update
 	case UserTelegram:
 		entry.Telegram = req.Update
+	case UserAnonName:
+		entry.AnonName = req.Update
+	case UserNotifyPrefs:
+		entry.NotifyPrefs = req.Update
 	}
 
 	err = bdb.Update(func(tx *bolt.Tx) error {
@@ -722,9 +894,9 @@ func (b *BoltDB) listDetails(loc store.Locator) (result []UserDetailEntry, err e
 	}
 
 	err = bdb.View(func(tx *bolt.Tx) error {
-		var entry UserDetailEntry
 		bucket := tx.Bucket([]byte(userDetailsBucketName))
 		return bucket.ForEach(func(_, value []byte) error {
+			var entry UserDetailEntry
 			if err = json.Unmarshal(value, &entry); err != nil {
 				return fmt.Errorf("failed to unmarshal entry: %w", e)
 			}
@@ -763,6 +935,10 @@ func (b *BoltDB) deleteUserDetail(bdb *bolt.DB, userID string, userDetail UserDe
 		entry.Email = ""
 	case UserTelegram:
 		entry.Telegram = ""
+	case UserAnonName:
+		entry.AnonName = ""
+	case UserNotifyPrefs:
+		entry.NotifyPrefs = ""
 	case AllUserDetails:
 		entry = UserDetailEntry{UserID: userID}
 	}
@@ -800,6 +976,15 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 			return fmt.Errorf("can't load key %s from bucket %s: %w", commentID, locator.URL, e)
 		}
 
+		// anonymized comment stays visible with the text, only user info cleared
+		if mode == store.AnonymizeDelete {
+			comment.Anonymize()
+			if e = b.save(postBkt, commentID, comment); e != nil {
+				return fmt.Errorf("can't save anonymized comment for key %s from bucket %s: %w", commentID, locator.URL, e)
+			}
+			return nil
+		}
+
 		if !comment.Deleted {
 			// decrement comments count for post url
 			if _, e = b.count(tx, comment.Locator.URL, -1); e != nil {
@@ -850,6 +1035,7 @@ func (b *BoltDB) deleteAll(bdb *bolt.DB, siteID string) error {
 
 // deleteUser removes all comments and details for given user. Everything will be market as deleted
 // and user name and userID will be changed to "deleted". Also removes from last and from user buckets.
+// In anonymize mode comments kept with the text and user info replaced by "deleted user".
 func (b *BoltDB) deleteUser(bdb *bolt.DB, siteID, userID string, mode store.DeleteMode) error {
 	// get list of all comments outside of transaction loop
 	posts, err := b.Info(InfoRequest{Locator: store.Locator{SiteID: siteID}})
@@ -898,8 +1084,8 @@ func (b *BoltDB) deleteUser(bdb *bolt.DB, siteID, userID string, mode store.Dele
 		}
 	}
 
-	// delete user bucket in hard mode
-	if mode == store.HardDelete {
+	// delete user bucket in hard and anonymize modes, as comments don't belong to the user anymore
+	if mode == store.HardDelete || mode == store.AnonymizeDelete {
 		err = bdb.Update(func(tx *bolt.Tx) error {
 			usersBkt := tx.Bucket([]byte(userBucketName))
 			if usersBkt != nil {
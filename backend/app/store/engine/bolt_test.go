@@ -189,6 +189,135 @@ func TestBoltDB_FindInPostSince(t *testing.T) {
 	assert.Equal(t, 0, len(res))
 }
 
+func TestBoltDB_Reassign(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "other", Locator: loc, User: store.User{ID: "user2", Name: "other name"},
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local)})
+	require.NoError(t, err)
+	require.NoError(t, b.Update(store.Comment{ID: "id-1", Locator: loc, Text: "updated"}))
+
+	count, err := b.Reassign(ReassignRequest{Locator: loc, UserID: "user1", NewUser: store.User{ID: "user2", Name: "new name"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	res, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user2"})
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	for _, c := range res {
+		assert.Equal(t, "user2", c.User.ID)
+	}
+	c, err := b.Get(getReq(loc, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "new name", c.User.Name)
+	assert.Equal(t, "updated", c.Text)
+
+	_, err = b.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1"})
+	assert.EqualError(t, err, "no comments for user user1 in store for radio-t site")
+
+	count, err = b.Reassign(ReassignRequest{Locator: loc, UserID: "user1", NewUser: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "nothing left")
+
+	_, err = b.Reassign(ReassignRequest{Locator: loc, UserID: "user2"})
+	assert.Error(t, err)
+}
+
+func TestBoltDB_Restore(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	orig, err := b.Get(getReq(loc, "id-2"))
+	require.NoError(t, err)
+
+	assert.Error(t, b.Restore(orig), "not deleted")
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc, CommentID: "id-2", DeleteMode: store.SoftDelete}))
+	count, err := b.Count(FindRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	orig.ParentID = "changed"
+	require.NoError(t, b.Restore(orig))
+	c, err := b.Get(getReq(loc, "id-2"))
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+	assert.Equal(t, "some text2", c.Text)
+	assert.Equal(t, "", c.ParentID, "immutable field kept")
+	count, err = b.Count(FindRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	last, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Sort: "-time", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, last, 2)
+	assert.Equal(t, "id-2", last[0].ID, "listed in last comments")
+
+	assert.Error(t, b.Restore(store.Comment{ID: "id-3", Locator: loc}), "not found")
+}
+
+func TestBoltDB_Iterate(t *testing.T) {
+	_ = os.Remove(testDB)
+	b, err := NewBoltDB(bolt.Options{}, BoltSite{FileName: testDB, SiteID: "radio-t"})
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, b.Close())
+		_ = os.Remove(testDB)
+	}()
+
+	c := store.Comment{
+		Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User:    store.User{ID: "user1", Name: "user name"},
+	}
+
+	// write 250 comments, more than a few batches
+	for i := 0; i < 250; i++ {
+		c.ID = fmt.Sprintf("id-%03d", i)
+		c.Timestamp = time.Date(2017, 12, 20, 15, 18, 0, 0, time.Local).Add(time.Duration(i) * time.Second)
+		_, err = b.Create(c)
+		require.NoError(t, err)
+	}
+
+	req := FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}}
+	ids := []string{}
+	err = b.Iterate(req, func(c store.Comment) error {
+		ids = append(ids, c.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 250, len(ids))
+	for i, id := range ids {
+		assert.Equal(t, fmt.Sprintf("id-%03d", i), id, "each comment once, in order of ids")
+	}
+
+	// since filters comments, but doesn't stop iteration
+	req.Since = time.Date(2017, 12, 20, 15, 18, 0, 0, time.Local).Add(149 * time.Second)
+	count := 0
+	err = b.Iterate(req, func(c store.Comment) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 100, count)
+
+	// stops on the first error
+	count = 0
+	err = b.Iterate(FindRequest{Locator: req.Locator}, func(c store.Comment) error {
+		if count++; count == 120 {
+			return fmt.Errorf("write failed")
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "write failed")
+	assert.Equal(t, 120, count)
+
+	err = b.Iterate(FindRequest{Locator: store.Locator{URL: "https://radio-t.com/bad", SiteID: "radio-t"}},
+		func(store.Comment) error { return nil })
+	assert.EqualError(t, err, "no bucket https://radio-t.com/bad in store")
+	err = b.Iterate(FindRequest{Locator: store.Locator{SiteID: "radio-t"}}, func(store.Comment) error { return nil })
+	assert.Error(t, err, "post url required")
+}
+
 func TestBoltDB_FindForUser(t *testing.T) {
 	var b, teardown = prep(t)
 	defer teardown()
@@ -536,6 +665,40 @@ func TestBolt_FlagVerified(t *testing.T) {
 	assert.NoError(t, setVerified("radio-t", "u3", FlagFalse))
 }
 
+func TestBolt_FlagUnsubscribed(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	isUnsubscribed := func(url, user string) bool {
+		req := FlagRequest{Flag: Unsubscribed, Locator: store.Locator{SiteID: "radio-t", URL: url}, UserID: user}
+		v, err := b.Flag(req)
+		require.NoError(t, err)
+		return v
+	}
+
+	setUnsubscribed := func(url, user string, status FlagStatus) error {
+		req := FlagRequest{Flag: Unsubscribed, Locator: store.Locator{SiteID: "radio-t", URL: url}, UserID: user, Update: status}
+		_, err := b.Flag(req)
+		return err
+	}
+
+	assert.False(t, isUnsubscribed("https://radio-t.com", "u1"))
+
+	assert.NoError(t, setUnsubscribed("https://radio-t.com", "u1", FlagTrue))
+	assert.NoError(t, setUnsubscribed("https://radio-t.com", "u1", FlagTrue), "repeated set is fine")
+	assert.True(t, isUnsubscribed("https://radio-t.com", "u1"))
+	assert.False(t, isUnsubscribed("https://radio-t.com/2", "u1"), "other post not affected")
+	assert.False(t, isUnsubscribed("https://radio-t.com", "u2"), "other user not affected")
+
+	// user-level flags not affected
+	v, err := b.Flag(FlagRequest{Flag: Verified, Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1"})
+	require.NoError(t, err)
+	assert.False(t, v)
+
+	assert.NoError(t, setUnsubscribed("https://radio-t.com", "u1", FlagFalse))
+	assert.False(t, isUnsubscribed("https://radio-t.com", "u1"))
+}
+
 func TestBolt_FlagListVerified(t *testing.T) {
 	b, teardown := prep(t)
 	defer teardown()
@@ -664,6 +827,56 @@ func TestBoltDB_UserDetail(t *testing.T) {
 	}
 }
 
+func TestBoltDB_UserDetailAnonName(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	result, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "anonymous_1", Detail: UserAnonName, Update: "John Doe"})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "anonymous_1", AnonName: "John Doe"}}, result)
+	_, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u2", Detail: UserEmail, Update: "other@example.com"})
+	require.NoError(t, err)
+
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "anonymous_1", Detail: UserAnonName})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "anonymous_1", AnonName: "John Doe"}}, result)
+
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []UserDetailEntry{{UserID: "anonymous_1", AnonName: "John Doe"},
+		{UserID: "u2", Email: "other@example.com"}}, result, "details of one user not leaked to another")
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "anonymous_1", UserDetail: UserAnonName}))
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "anonymous_1", Detail: UserAnonName})
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestBoltDB_UserDetailNotifyPrefs(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+	result, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserNotifyPrefs, Update: `{"replies":false}`})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "u1@example.com", NotifyPrefs: `{"replies":false}`}}, result)
+
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserNotifyPrefs})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", NotifyPrefs: `{"replies":false}`}}, result)
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserNotifyPrefs}))
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserNotifyPrefs})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1"}}, result)
+	result, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "u1", Email: "u1@example.com"}}, result, "other details kept")
+}
+
 func TestBolt_DeleteComment(t *testing.T) {
 	b, teardown := prep(t)
 	defer teardown()
@@ -840,6 +1053,41 @@ func TestBoltAdmin_DeleteUserHard(t *testing.T) {
 	assert.EqualError(t, err, `site "radio-t-bad" not found`)
 }
 
+func TestBoltAdmin_DeleteUserAnonymize(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	_, err := b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Detail: UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+
+	err = b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", DeleteMode: store.AnonymizeDelete})
+	require.NoError(t, err)
+
+	comments, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, Sort: "time"})
+	assert.NoError(t, err)
+	require.Equal(t, 2, len(comments), "2 comments kept")
+	for _, c := range comments {
+		assert.Equal(t, store.User{Name: "deleted user", ID: "deleted"}, c.User)
+		assert.False(t, c.Deleted)
+		assert.NotEmpty(t, c.Text, "text kept")
+	}
+
+	c, err := b.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, c, "count not changed")
+
+	_, err = b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Limit: 5})
+	assert.EqualError(t, err, "no comments for user user1 in store")
+
+	comments, err = b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Sort: "time"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(comments), "anonymized comments in last comments")
+
+	details, err := b.UserDetail(UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Empty(t, details, "user details removed")
+}
+
 func TestBoltAdmin_DeleteUserSoft(t *testing.T) {
 	b, teardown := prep(t)
 	defer teardown()
@@ -34,6 +34,21 @@ type Interface interface {
 	Close() error // close storage engine
 }
 
+// Iterator is implemented by engines able to iterate over comments of the post without loading all of them in memory
+type Iterator interface {
+	Iterate(req FindRequest, fn func(store.Comment) error) error // iterate post comments, stops on the first fn error
+}
+
+// Reassigner is implemented by engines able to change the author of user's comments
+type Reassigner interface {
+	Reassign(req ReassignRequest) (int, error) // set new author of all user's comments, returns number of comments changed
+}
+
+// Restorer is implemented by engines able to bring back deleted comment, with post's count and last comments
+type Restorer interface {
+	Restore(comment store.Comment) error // save deleted comment as not deleted, fails if the comment not deleted
+}
+
 // GetRequest is the input for Get func
 type GetRequest struct {
 	Locator   store.Locator `json:"locator"`
@@ -67,6 +82,13 @@ type DeleteRequest struct {
 	DeleteMode store.DeleteMode `json:"del_mode"`
 }
 
+// ReassignRequest is the input for Reassign operation
+type ReassignRequest struct {
+	Locator store.Locator `json:"locator"`  // site of the users
+	UserID  string        `json:"user_id"`  // the current author of comments
+	NewUser store.User    `json:"new_user"` // new author, ip of comments kept
+}
+
 // Flag defines type of binary attribute
 type Flag string
 
@@ -82,9 +104,11 @@ const (
 
 // Enum of all flags
 const (
-	ReadOnly = Flag("readonly")
-	Verified = Flag("verified")
-	Blocked  = Flag("blocked")
+	ReadOnly     = Flag("readonly")
+	Verified     = Flag("verified")
+	Blocked      = Flag("blocked")
+	Unsubscribed = Flag("unsubscribed")  // user's flag for the post, requires both UserID and Locator.URL
+	VotingFrozen = Flag("voting_frozen") // site's flag, requires Locator.SiteID only
 )
 
 // All possible user details
@@ -93,6 +117,10 @@ const (
 	UserEmail = UserDetail("email")
 	// UserTelegram is a user telegram
 	UserTelegram = UserDetail("telegram")
+	// UserAnonName is a name reserved by anonymous user
+	UserAnonName = UserDetail("anon_name")
+	// UserNotifyPrefs is a user notification preferences, json-encoded store.NotifyPrefs
+	UserNotifyPrefs = UserDetail("notify_prefs")
 	// AllUserDetails used for listing and deletion requests
 	AllUserDetails = UserDetail("all")
 )
@@ -111,9 +139,11 @@ type UserDetail string
 
 // UserDetailEntry contains single user details entry
 type UserDetailEntry struct {
-	UserID   string `json:"user_id"`            // duplicate user's id to use this structure not only embedded but separately
-	Email    string `json:"email,omitempty"`    // UserEmail
-	Telegram string `json:"telegram,omitempty"` // UserTelegram
+	UserID      string `json:"user_id"`                // duplicate user's id to use this structure not only embedded but separately
+	Email       string `json:"email,omitempty"`        // UserEmail
+	Telegram    string `json:"telegram,omitempty"`     // UserTelegram
+	AnonName    string `json:"anon_name,omitempty"`    // UserAnonName
+	NotifyPrefs string `json:"notify_prefs,omitempty"` // UserNotifyPrefs
 }
 
 // UserDetailRequest is the input for both get/set for details, like email
@@ -133,6 +163,10 @@ const (
 // SortComments is for engines can't sort data internally
 func SortComments(comments []store.Comment, sortFld string) []store.Comment {
 	sort.Slice(comments, func(i, j int) bool {
+		if less, ok := ReactionsLess(comments[i], comments[j], sortFld); ok {
+			return less
+		}
+
 		switch sortFld {
 		case "+time", "-time", "time", "+active", "-active", "active":
 			if strings.HasPrefix(sortFld, "-") {
@@ -170,3 +204,21 @@ func SortComments(comments []store.Comment, sortFld string) []store.Comment {
 	})
 	return comments
 }
+
+// ReactionsLess compares comments for "reactions" (total number) and "reactions:<emoji>" (number of given emoji)
+// sort fields, with +/- prefix. Ties broken by time. Returns ok=false if sortFld is not a reactions sort
+func ReactionsLess(c1, c2 store.Comment, sortFld string) (less, ok bool) {
+	fld := strings.TrimLeft(sortFld, "+-")
+	if fld != "reactions" && !strings.HasPrefix(fld, "reactions:") {
+		return false, false
+	}
+	emoji := strings.TrimPrefix(strings.TrimPrefix(fld, "reactions"), ":")
+	r1, r2 := c1.ReactionsCount(emoji), c2.ReactionsCount(emoji)
+	if r1 == r2 {
+		return c1.Timestamp.Before(c2.Timestamp), true
+	}
+	if strings.HasPrefix(sortFld, "-") {
+		return r1 > r2, true
+	}
+	return r1 < r2, true
+}
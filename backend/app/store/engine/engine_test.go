@@ -53,3 +53,41 @@ func TestEngine_sortComments(t *testing.T) {
 	assert.Equal(t, "1", cc[2].ID)
 	assert.Equal(t, "4", cc[3].ID)
 }
+
+func TestEngine_sortCommentsByReactions(t *testing.T) {
+	cc := []store.Comment{
+		{ID: "1", Reactions: map[string]int{"👍": 2, "😄": 1}, Timestamp: time.Date(2018, 2, 5, 10, 1, 0, 0, time.Local)},
+		{ID: "2", Reactions: map[string]int{"😄": 3}, Timestamp: time.Date(2018, 2, 5, 10, 2, 0, 0, time.Local)},
+		{ID: "3", Timestamp: time.Date(2018, 2, 5, 10, 3, 0, 0, time.Local)},
+		{ID: "4", Reactions: map[string]int{"👍": 5}, Timestamp: time.Date(2018, 2, 5, 10, 4, 0, 0, time.Local)},
+		{ID: "5", Reactions: map[string]int{"👍": 1, "😄": 2}, Timestamp: time.Date(2018, 2, 5, 10, 5, 0, 0, time.Local)},
+	}
+
+	SortComments(cc, "-reactions")
+	assert.Equal(t, []string{"4", "1", "2", "5", "3"}, commentIDs(cc), "ties broken by time")
+
+	SortComments(cc, "+reactions")
+	assert.Equal(t, []string{"3", "1", "2", "5", "4"}, commentIDs(cc), "ties broken by time")
+
+	SortComments(cc, "-reactions:😄")
+	assert.Equal(t, []string{"2", "5", "1", "3", "4"}, commentIDs(cc))
+
+	SortComments(cc, "reactions:👍")
+	assert.Equal(t, []string{"2", "3", "5", "1", "4"}, commentIDs(cc))
+
+	// tie-break is stable regardless of the initial order
+	for i := 0; i < 10; i++ {
+		cc[0], cc[len(cc)-1] = cc[len(cc)-1], cc[0]
+		cc[1], cc[2] = cc[2], cc[1]
+		SortComments(cc, "-reactions")
+		assert.Equal(t, []string{"4", "1", "2", "5", "3"}, commentIDs(cc))
+	}
+}
+
+func commentIDs(cc []store.Comment) []string {
+	res := make([]string, 0, len(cc))
+	for _, c := range cc {
+		res = append(res, c.ID)
+	}
+	return res
+}
@@ -0,0 +1,795 @@
+package engine
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	_ "github.com/lib/pq" // postgres driver
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Postgres implements store.Interface with PostgreSQL, all sites share the same database. Thread safe.
+// There are 4 tables, each keyed by site:
+//   - comments keeps the whole comment as jsonb, with url, user id, timestamp and deleted status
+//     extracted to columns for indexed lookups by post, by user and for the last comments
+//   - posts keeps number of comments and first/last comment timestamps for each post url
+//   - flags keeps flags like blocked, verified and read-only, key is user id, post url or both
+//   - user_details keeps email, telegram and reserved anonymous name of users
+type Postgres struct {
+	db    *sql.DB
+	sites map[string]bool
+}
+
+// PostgresOptions defines connection pool params
+type PostgresOptions struct {
+	MaxOpenConns    int           // max number of open connections, 0 means unlimited
+	MaxIdleConns    int           // max number of idle connections, 0 means database/sql default
+	ConnMaxLifetime time.Duration // max time connection may be reused, 0 means forever
+}
+
+// postgresMigrations applied in order, each one once. Never change existing migrations, add new one instead
+var postgresMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS comments (
+		site TEXT NOT NULL,
+		url TEXT NOT NULL,
+		id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		ts TIMESTAMPTZ NOT NULL,
+		deleted BOOLEAN NOT NULL DEFAULT FALSE,
+		data JSONB NOT NULL,
+		PRIMARY KEY (site, url, id)
+	);
+	CREATE INDEX IF NOT EXISTS comments_site_ts_idx ON comments (site, ts);
+	CREATE INDEX IF NOT EXISTS comments_site_user_ts_idx ON comments (site, user_id, ts);
+	CREATE TABLE IF NOT EXISTS posts (
+		site TEXT NOT NULL,
+		url TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		first_ts TIMESTAMPTZ NOT NULL,
+		last_ts TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (site, url)
+	);
+	CREATE TABLE IF NOT EXISTS flags (
+		site TEXT NOT NULL,
+		flag TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (site, flag, key)
+	);
+	CREATE TABLE IF NOT EXISTS user_details (
+		site TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		telegram TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (site, user_id)
+	);`,
+	`ALTER TABLE user_details ADD COLUMN IF NOT EXISTS anon_name TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS user_details_site_anon_name_idx ON user_details (site, lower(anon_name)) WHERE anon_name <> '';`,
+	`ALTER TABLE user_details ADD COLUMN IF NOT EXISTS notify_prefs TEXT NOT NULL DEFAULT '';`,
+}
+
+// postgresMigrationsLock is the advisory lock id preventing concurrent migrations from multiple instances
+const postgresMigrationsLock = 42424242
+
+// NewPostgres makes postgres-based store for given sites and applies all pending migrations
+func NewPostgres(connURL string, options PostgresOptions, sites ...string) (*Postgres, error) {
+	log.Printf("[INFO] postgres store for sites %+v, options %+v", sites, options)
+	db, err := sql.Open("postgres", connURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	result := Postgres{db: db, sites: make(map[string]bool)}
+	if err = result.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate postgres: %w", err)
+	}
+	for _, site := range sites {
+		result.sites[site] = true
+	}
+	return &result, nil
+}
+
+// Create saves new comment to store and increments comments count for the post
+func (p *Postgres) Create(comment store.Comment) (commentID string, err error) {
+	if err = p.checkSite(comment.Locator.SiteID); err != nil {
+		return "", err
+	}
+
+	if p.checkFlag(FlagRequest{Locator: comment.Locator, Flag: ReadOnly}) {
+		return "", fmt.Errorf("post %s is read-only", comment.Locator.URL)
+	}
+
+	data, err := json.Marshal(comment)
+	if err != nil {
+		return "", fmt.Errorf("can't marshal comment: %w", err)
+	}
+
+	err = p.tx(func(tx *sql.Tx) error {
+		res, e := tx.Exec(`INSERT INTO comments (site, url, id, user_id, ts, deleted, data) VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT DO NOTHING`, comment.Locator.SiteID, comment.Locator.URL, comment.ID, comment.User.ID,
+			comment.Timestamp, comment.Deleted, data)
+		if e != nil {
+			return fmt.Errorf("failed to insert comment %s: %w", comment.ID, e)
+		}
+		if n, e := res.RowsAffected(); e != nil || n == 0 {
+			return fmt.Errorf("key %s already in store", comment.ID)
+		}
+
+		// set info with the count for post url
+		_, e = tx.Exec(`INSERT INTO posts (site, url, count, first_ts, last_ts) VALUES ($1, $2, 1, $3, $3)
+			ON CONFLICT (site, url) DO UPDATE SET count = posts.count + 1, last_ts = EXCLUDED.last_ts`,
+			comment.Locator.SiteID, comment.Locator.URL, comment.Timestamp)
+		if e != nil {
+			return fmt.Errorf("failed to set info for %s: %w", comment.Locator, e)
+		}
+		return nil
+	})
+
+	return comment.ID, err
+}
+
+// Get returns comment for locator.URL and commentID string
+func (p *Postgres) Get(req GetRequest) (comment store.Comment, err error) {
+	if err = p.checkSite(req.Locator.SiteID); err != nil {
+		return comment, err
+	}
+	row := p.db.QueryRow(`SELECT data FROM comments WHERE site = $1 AND url = $2 AND id = $3`,
+		req.Locator.SiteID, req.Locator.URL, req.CommentID)
+	return p.scanComment(row)
+}
+
+// Find returns all comments for given request and sorts results
+func (p *Postgres) Find(req FindRequest) (comments []store.Comment, err error) {
+	if err = p.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	since := sql.NullTime{Time: req.Since, Valid: !req.Since.IsZero()}
+	var rows *sql.Rows
+	switch {
+	case req.Locator.URL != "": // find post comments, i.e. for site and url
+		rows, err = p.db.Query(`SELECT data FROM comments WHERE site = $1 AND url = $2 AND ($3::timestamptz IS NULL OR ts > $3)`,
+			req.Locator.SiteID, req.Locator.URL, since)
+	case req.UserID == "": // find last comments for site
+		limit := req.Limit
+		if limit > lastLimit || limit == 0 {
+			limit = lastLimit
+		}
+		rows, err = p.db.Query(`SELECT data FROM comments WHERE site = $1 AND NOT deleted AND ($2::timestamptz IS NULL OR ts > $2)
+			ORDER BY ts DESC LIMIT $3`, req.Locator.SiteID, since, limit)
+	default: // find comments for user
+		limit := req.Limit
+		if limit == 0 || limit > userLimit {
+			limit = userLimit
+		}
+		rows, err = p.db.Query(`SELECT data FROM comments WHERE site = $1 AND user_id = $2 ORDER BY ts DESC LIMIT $3 OFFSET $4`,
+			req.Locator.SiteID, req.UserID, limit, req.Skip)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find comments: %w", err)
+	}
+
+	if comments, err = p.scanComments(rows); err != nil {
+		return nil, err
+	}
+	return SortComments(comments, req.Sort), nil
+}
+
+// Flag sets and gets flag values
+func (p *Postgres) Flag(req FlagRequest) (val bool, err error) {
+	if req.Update == FlagNonSet { // read flag value, no update requested
+		return p.checkFlag(req), nil
+	}
+
+	// write flag value
+	return p.setFlag(req)
+}
+
+// UserDetail sets or gets single detail value, or gets all details for requested site.
+func (p *Postgres) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	if err := p.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	switch req.Detail {
+	case UserEmail, UserTelegram, UserAnonName, UserNotifyPrefs:
+		if req.UserID == "" {
+			return nil, fmt.Errorf("userid cannot be empty in request for single detail")
+		}
+
+		if req.Update == "" { // read detail value, no update requested
+			return p.getUserDetail(req)
+		}
+
+		return p.setUserDetail(req)
+	case AllUserDetails:
+		// list of all details returned in case request is a read request
+		// (Update is not set) and does not have UserID
+		if req.Update == "" && req.UserID == "" { // read list of all details
+			return p.listDetails(req.Locator)
+		}
+		return nil, fmt.Errorf("unsupported request with userdetail all")
+	default:
+		return nil, fmt.Errorf("unsupported detail %q", req.Detail)
+	}
+}
+
+// Update for locator.URL with mutable part of comment
+func (p *Postgres) Update(comment store.Comment) error {
+	if err := p.checkSite(comment.Locator.SiteID); err != nil {
+		return err
+	}
+
+	return p.tx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(`SELECT data FROM comments WHERE site = $1 AND url = $2 AND id = $3 FOR UPDATE`,
+			comment.Locator.SiteID, comment.Locator.URL, comment.ID)
+		curComment, err := p.scanComment(row)
+		if err != nil {
+			return err
+		}
+
+		// preserve immutable fields
+		comment.ParentID = curComment.ParentID
+		comment.Locator = curComment.Locator
+		comment.Timestamp = curComment.Timestamp
+		comment.User = curComment.User
+		return p.saveComment(tx, comment)
+	})
+}
+
+// Restore saves deleted comment back, with mutable fields of the passed comment. Comment counted for the post again.
+func (p *Postgres) Restore(comment store.Comment) error {
+	if err := p.checkSite(comment.Locator.SiteID); err != nil {
+		return err
+	}
+
+	return p.tx(func(tx *sql.Tx) error {
+		row := tx.QueryRow(`SELECT data FROM comments WHERE site = $1 AND url = $2 AND id = $3 FOR UPDATE`,
+			comment.Locator.SiteID, comment.Locator.URL, comment.ID)
+		cur, err := p.scanComment(row)
+		if err != nil {
+			return err
+		}
+		if !cur.Deleted {
+			return fmt.Errorf("comment %s not deleted", comment.ID)
+		}
+
+		// preserve immutable fields, the same as Update does
+		comment.ParentID, comment.Locator, comment.Timestamp, comment.User = cur.ParentID, cur.Locator, cur.Timestamp, cur.User
+		comment.Deleted = false
+		if err = p.saveComment(tx, comment); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`UPDATE posts SET count = count + 1 WHERE site = $1 AND url = $2`,
+			comment.Locator.SiteID, comment.Locator.URL); err != nil {
+			return fmt.Errorf("failed to increment count for %s: %w", comment.Locator, err)
+		}
+		return nil
+	})
+}
+
+// Count returns number of comments for post or user
+func (p *Postgres) Count(req FindRequest) (count int, err error) {
+	if err = p.checkSite(req.Locator.SiteID); err != nil {
+		return 0, err
+	}
+
+	if req.Locator.URL != "" { // comment's count for post
+		err = p.db.QueryRow(`SELECT count FROM posts WHERE site = $1 AND url = $2`, req.Locator.SiteID, req.Locator.URL).Scan(&count)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return count, err
+	}
+
+	if req.UserID != "" { // comment's count for user
+		err = p.db.QueryRow(`SELECT count(*) FROM comments WHERE site = $1 AND user_id = $2`, req.Locator.SiteID, req.UserID).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("can't count comments for user %s: %w", req.UserID, err)
+		}
+		if count == 0 {
+			return 0, fmt.Errorf("no comments for user %s in store for %s site", req.UserID, req.Locator.SiteID)
+		}
+		return count, nil
+	}
+
+	return 0, fmt.Errorf("invalid count request %+v", req)
+}
+
+// Info get post(s) meta info
+func (p *Postgres) Info(req InfoRequest) ([]store.PostInfo, error) {
+	if err := p.checkSite(req.Locator.SiteID); err != nil {
+		return []store.PostInfo{}, err
+	}
+
+	if req.Locator.URL != "" { // post info
+		info := store.PostInfo{}
+		err := p.db.QueryRow(`SELECT url, count, first_ts, last_ts FROM posts WHERE site = $1 AND url = $2`,
+			req.Locator.SiteID, req.Locator.URL).Scan(&info.URL, &info.Count, &info.FirstTS, &info.LastTS)
+		if err != nil {
+			err = fmt.Errorf("can't load info for %s: %w", req.Locator.URL, err)
+		}
+
+		// set read-only from age and manual flag
+		info.ReadOnly = req.ReadOnlyAge > 0 && !info.FirstTS.IsZero() && info.FirstTS.AddDate(0, 0, req.ReadOnlyAge).Before(time.Now())
+		if !info.ReadOnly && p.checkFlag(FlagRequest{Locator: req.Locator, Flag: ReadOnly}) {
+			info.ReadOnly = true
+		}
+		return []store.PostInfo{info}, err
+	}
+
+	// site info (list)
+	limit := sql.NullInt64{Int64: int64(req.Limit), Valid: req.Limit > 0}
+	rows, err := p.db.Query(`SELECT url, count, first_ts, last_ts FROM posts WHERE site = $1 ORDER BY url DESC LIMIT $2 OFFSET $3`,
+		req.Locator.SiteID, limit, req.Skip)
+	if err != nil {
+		return nil, fmt.Errorf("can't load info for %s: %w", req.Locator.SiteID, err)
+	}
+	defer rows.Close() // nolint
+
+	list := []store.PostInfo{}
+	for rows.Next() {
+		info := store.PostInfo{}
+		if err = rows.Scan(&info.URL, &info.Count, &info.FirstTS, &info.LastTS); err != nil {
+			return nil, fmt.Errorf("can't scan info: %w", err)
+		}
+		list = append(list, info)
+	}
+	return list, rows.Err()
+}
+
+// ListFlags get list of flagged keys, like blocked & verified user
+func (p *Postgres) ListFlags(req FlagRequest) (res []interface{}, err error) {
+	if err = p.checkSite(req.Locator.SiteID); err != nil {
+		return nil, err
+	}
+
+	res = []interface{}{}
+	switch req.Flag {
+	case Verified:
+		rows, e := p.db.Query(`SELECT key FROM flags WHERE site = $1 AND flag = $2 ORDER BY key`, req.Locator.SiteID, Verified)
+		if e != nil {
+			return nil, fmt.Errorf("can't list %s flags: %w", req.Flag, e)
+		}
+		defer rows.Close() // nolint
+		for rows.Next() {
+			var key string
+			if err = rows.Scan(&key); err != nil {
+				return nil, fmt.Errorf("can't scan flag: %w", err)
+			}
+			res = append(res, key)
+		}
+		return res, rows.Err()
+	case Blocked:
+		// get user name from the last comment of the user
+		rows, e := p.db.Query(`SELECT f.key, f.value, COALESCE((SELECT c.data->'user'->>'name' FROM comments c
+			WHERE c.site = f.site AND c.user_id = f.key ORDER BY c.ts DESC LIMIT 1), '')
+			FROM flags f WHERE f.site = $1 AND f.flag = $2 AND f.value > now() ORDER BY f.key`, req.Locator.SiteID, Blocked)
+		if e != nil {
+			return nil, fmt.Errorf("can't list %s flags: %w", req.Flag, e)
+		}
+		defer rows.Close() // nolint
+		for rows.Next() {
+			user := store.BlockedUser{}
+			if err = rows.Scan(&user.ID, &user.Until, &user.Name); err != nil {
+				return nil, fmt.Errorf("can't scan blocked user: %w", err)
+			}
+			user.Until = user.Until.Local()
+			res = append(res, user)
+		}
+		return res, rows.Err()
+	}
+	return nil, fmt.Errorf("flag %s not listable", req.Flag)
+}
+
+// Delete post(s), user, comment, user details, or everything
+func (p *Postgres) Delete(req DeleteRequest) error {
+	if err := p.checkSite(req.Locator.SiteID); err != nil {
+		return err
+	}
+
+	switch {
+	case req.UserDetail != "": // delete user detail
+		return p.deleteUserDetail(p.db, req.Locator.SiteID, req.UserID, req.UserDetail)
+	case req.Locator.URL != "" && req.CommentID != "": // delete comment
+		return p.tx(func(tx *sql.Tx) error {
+			return p.deleteComment(tx, req.Locator, req.CommentID, req.DeleteMode)
+		})
+	case req.Locator.SiteID != "" && req.UserID != "" && req.CommentID == "": // delete user
+		return p.deleteUser(req.Locator.SiteID, req.UserID, req.DeleteMode)
+	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.CommentID == "" && req.UserID == "": // delete site
+		return p.deleteAll(req.Locator.SiteID)
+	}
+
+	return fmt.Errorf("invalid delete request %+v", req)
+}
+
+// Close postgres store
+func (p *Postgres) Close() error {
+	if err := p.db.Close(); err != nil {
+		return fmt.Errorf("can't close postgres: %w", err)
+	}
+	return nil
+}
+
+// migrate applies all migrations not applied yet. Advisory lock prevents concurrent runs from multiple instances
+func (p *Postgres) migrate() error {
+	return p.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, postgresMigrationsLock); err != nil {
+			return fmt.Errorf("can't lock migrations: %w", err)
+		}
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		if err != nil {
+			return fmt.Errorf("can't create migrations table: %w", err)
+		}
+
+		var version int
+		if err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+			return fmt.Errorf("can't get schema version: %w", err)
+		}
+		for i := version; i < len(postgresMigrations); i++ {
+			if _, err = tx.Exec(postgresMigrations[i]); err != nil {
+				return fmt.Errorf("can't apply migration %d: %w", i+1, err)
+			}
+			if _, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+				return fmt.Errorf("can't save migration %d: %w", i+1, err)
+			}
+			log.Printf("[INFO] postgres migration %d applied", i+1)
+		}
+		return nil
+	})
+}
+
+func (p *Postgres) checkFlag(req FlagRequest) bool {
+	var val time.Time
+	err := p.db.QueryRow(`SELECT value FROM flags WHERE site = $1 AND flag = $2 AND key = $3`,
+		req.Locator.SiteID, req.Flag, p.flagKey(req)).Scan(&val)
+	if err != nil {
+		return false
+	}
+	if req.Flag == Blocked {
+		return time.Now().Before(val)
+	}
+	return true
+}
+
+func (p *Postgres) setFlag(req FlagRequest) (res bool, err error) {
+	if err = p.checkSite(req.Locator.SiteID); err != nil {
+		return false, err
+	}
+	switch req.Flag {
+	case ReadOnly, Blocked, Verified, Unsubscribed, VotingFrozen:
+	default:
+		return false, fmt.Errorf("unsupported flag %v", req.Flag)
+	}
+
+	key := p.flagKey(req)
+	switch req.Update {
+	case FlagTrue:
+		val := time.Now()
+		if req.Flag == Blocked {
+			val = time.Now().AddDate(100, 0, 0) // permanent is 100 year
+			if req.TTL > 0 {
+				val = time.Now().Add(req.TTL)
+			}
+		}
+		_, err = p.db.Exec(`INSERT INTO flags (site, flag, key, value) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (site, flag, key) DO UPDATE SET value = EXCLUDED.value`, req.Locator.SiteID, req.Flag, key, val)
+		if err != nil {
+			return false, fmt.Errorf("failed to set flag %s for %s: %w", req.Flag, key, err)
+		}
+		return true, nil
+	case FlagFalse:
+		_, err = p.db.Exec(`DELETE FROM flags WHERE site = $1 AND flag = $2 AND key = $3`, req.Locator.SiteID, req.Flag, key)
+		if err != nil {
+			return false, fmt.Errorf("failed to clean flag %s for %s: %w", req.Flag, key, err)
+		}
+	}
+	return false, nil
+}
+
+// flagKey makes the key flag stored with. Post flags keyed by url, user flags by user id,
+// per-post user flags, like unsubscribed, by combination of both and site flags by site id
+func (p *Postgres) flagKey(req FlagRequest) string {
+	if req.Flag == Unsubscribed {
+		return fmt.Sprintf("%s!!%s", req.Locator.URL, req.UserID)
+	}
+	if req.Flag == VotingFrozen {
+		return req.Locator.SiteID
+	}
+	if req.UserID != "" {
+		return req.UserID
+	}
+	return req.Locator.URL
+}
+
+// getUserDetail returns UserDetailEntry with requested userDetail (omitting other details)
+// as an only element of the slice.
+func (p *Postgres) getUserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	var entry UserDetailEntry
+	err := p.db.QueryRow(`SELECT email, telegram, anon_name, notify_prefs FROM user_details WHERE site = $1 AND user_id = $2`,
+		req.Locator.SiteID, req.UserID).Scan(&entry.Email, &entry.Telegram, &entry.AnonName, &entry.NotifyPrefs)
+	if errors.Is(err, sql.ErrNoRows) { // return no error in case of absent entry
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't get detail %s for %s: %w", req.Detail, req.UserID, err)
+	}
+
+	switch req.Detail {
+	case UserEmail:
+		return []UserDetailEntry{{UserID: req.UserID, Email: entry.Email}}, nil
+	case UserTelegram:
+		return []UserDetailEntry{{UserID: req.UserID, Telegram: entry.Telegram}}, nil
+	case UserAnonName:
+		return []UserDetailEntry{{UserID: req.UserID, AnonName: entry.AnonName}}, nil
+	case UserNotifyPrefs:
+		return []UserDetailEntry{{UserID: req.UserID, NotifyPrefs: entry.NotifyPrefs}}, nil
+	}
+	return nil, nil
+}
+
+// setUserDetail sets requested userDetail, returning complete updated UserDetailEntry as an only
+// element of the slice in case of success
+func (p *Postgres) setUserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	column := "email"
+	switch req.Detail {
+	case UserTelegram:
+		column = "telegram"
+	case UserAnonName:
+		column = "anon_name"
+	case UserNotifyPrefs:
+		column = "notify_prefs"
+	}
+
+	entry := UserDetailEntry{UserID: req.UserID}
+	query := fmt.Sprintf(`INSERT INTO user_details (site, user_id, %[1]s) VALUES ($1, $2, $3)
+		ON CONFLICT (site, user_id) DO UPDATE SET %[1]s = EXCLUDED.%[1]s RETURNING email, telegram, anon_name, notify_prefs`, column)
+	err := p.db.QueryRow(query, req.Locator.SiteID, req.UserID, req.Update).
+		Scan(&entry.Email, &entry.Telegram, &entry.AnonName, &entry.NotifyPrefs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update detail %s for %s in %s: %w", req.Detail, req.UserID, req.Locator.SiteID, err)
+	}
+	return []UserDetailEntry{entry}, nil
+}
+
+// listDetails lists all available users details for given site
+func (p *Postgres) listDetails(loc store.Locator) (result []UserDetailEntry, err error) {
+	rows, err := p.db.Query(`SELECT user_id, email, telegram, anon_name, notify_prefs FROM user_details WHERE site = $1 ORDER BY user_id`,
+		loc.SiteID)
+	if err != nil {
+		return nil, fmt.Errorf("can't list user details for %s: %w", loc.SiteID, err)
+	}
+	defer rows.Close() // nolint
+	for rows.Next() {
+		var entry UserDetailEntry
+		if err = rows.Scan(&entry.UserID, &entry.Email, &entry.Telegram, &entry.AnonName, &entry.NotifyPrefs); err != nil {
+			return nil, fmt.Errorf("can't scan user details: %w", err)
+		}
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+// deleteUserDetail deletes requested UserDetail or whole UserDetailEntry
+func (p *Postgres) deleteUserDetail(db execer, siteID, userID string, userDetail UserDetail) error {
+	var query string
+	switch userDetail {
+	case UserEmail:
+		query = `UPDATE user_details SET email = '' WHERE site = $1 AND user_id = $2`
+	case UserTelegram:
+		query = `UPDATE user_details SET telegram = '' WHERE site = $1 AND user_id = $2`
+	case UserAnonName:
+		query = `UPDATE user_details SET anon_name = '' WHERE site = $1 AND user_id = $2`
+	case UserNotifyPrefs:
+		query = `UPDATE user_details SET notify_prefs = '' WHERE site = $1 AND user_id = $2`
+	case AllUserDetails:
+		query = `DELETE FROM user_details WHERE site = $1 AND user_id = $2`
+	default:
+		return fmt.Errorf("unsupported detail %q", userDetail)
+	}
+	if _, err := db.Exec(query, siteID, userID); err != nil {
+		return fmt.Errorf("failed to delete user detail %s for %s: %w", userDetail, userID, err)
+	}
+
+	// if entry doesn't have non-empty details, we should delete it
+	_, err := db.Exec(`DELETE FROM user_details WHERE site = $1 AND user_id = $2 AND email = '' AND telegram = '' AND anon_name = ''
+		AND notify_prefs = ''`, siteID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete empty user details for %s: %w", userID, err)
+	}
+	return nil
+}
+
+// deleteComment marks comment as deleted, clears its fields and decrements comments count for the post. Should run in tx
+func (p *Postgres) deleteComment(tx *sql.Tx, locator store.Locator, commentID string, mode store.DeleteMode) error {
+	row := tx.QueryRow(`SELECT data FROM comments WHERE site = $1 AND url = $2 AND id = $3 FOR UPDATE`,
+		locator.SiteID, locator.URL, commentID)
+	comment, err := p.scanComment(row)
+	if err != nil {
+		return err
+	}
+
+	// anonymized comment stays visible with the text, only user info cleared
+	if mode == store.AnonymizeDelete {
+		comment.Anonymize()
+		return p.saveComment(tx, comment)
+	}
+
+	if !comment.Deleted {
+		// decrement comments count for post url
+		if _, err = tx.Exec(`UPDATE posts SET count = count - 1 WHERE site = $1 AND url = $2`, locator.SiteID, locator.URL); err != nil {
+			return fmt.Errorf("failed to decrement count for %s: %w", locator, err)
+		}
+	}
+
+	// set deleted status and clear fields
+	comment.SetDeleted(mode)
+	return p.saveComment(tx, comment)
+}
+
+// deleteUser removes all comments and details for given user. Everything will be market as deleted
+// and, in hard mode, user name and userID will be changed to "deleted". In anonymize mode comments
+// kept with the text and user info replaced by "deleted user".
+func (p *Postgres) deleteUser(siteID, userID string, mode store.DeleteMode) error {
+	return p.tx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT url, id FROM comments WHERE site = $1 AND user_id = $2`, siteID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to collect list of comments for deletion: %w", err)
+		}
+		locators := []GetRequest{}
+		for rows.Next() {
+			req := GetRequest{Locator: store.Locator{SiteID: siteID}}
+			if err = rows.Scan(&req.Locator.URL, &req.CommentID); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("can't scan comment reference: %w", err)
+			}
+			locators = append(locators, req)
+		}
+		if err = rows.Close(); err != nil {
+			return fmt.Errorf("can't close rows: %w", err)
+		}
+
+		log.Printf("[DEBUG] comments for removal=%d", len(locators))
+		if len(locators) == 0 {
+			return fmt.Errorf("unknown user %s", userID)
+		}
+
+		for _, req := range locators {
+			if err = p.deleteComment(tx, req.Locator, req.CommentID, mode); err != nil {
+				return fmt.Errorf("failed to delete comment %+v: %w", req, err)
+			}
+		}
+		return p.deleteUserDetail(tx, siteID, userID, AllUserDetails)
+	})
+}
+
+// Reassign sets new author of all user's comments
+func (p *Postgres) Reassign(req ReassignRequest) (count int, err error) {
+	if req.UserID == "" || req.NewUser.ID == "" {
+		return 0, fmt.Errorf("both user ids required")
+	}
+	err = p.tx(func(tx *sql.Tx) error {
+		rows, e := tx.Query(`SELECT data FROM comments WHERE site = $1 AND user_id = $2 FOR UPDATE`, req.Locator.SiteID, req.UserID)
+		if e != nil {
+			return fmt.Errorf("failed to get comments of %s: %w", req.UserID, e)
+		}
+		comments, e := p.scanComments(rows)
+		if e != nil {
+			return e
+		}
+		for _, c := range comments {
+			ip := c.User.IP
+			c.User = req.NewUser
+			c.User.IP = ip
+			if e = p.saveComment(tx, c); e != nil {
+				return e
+			}
+		}
+		count = len(comments)
+		return nil
+	})
+	return count, err
+}
+
+// deleteAll removes all comments, posts and user details for given siteID, flags are kept
+func (p *Postgres) deleteAll(siteID string) error {
+	return p.tx(func(tx *sql.Tx) error {
+		for _, table := range []string{"comments", "posts", "user_details"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE site = $1`, siteID); err != nil {
+				return fmt.Errorf("failed to delete %s from site %s: %w", table, siteID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// saveComment updates existing comment data. User id kept in sync with comment to drop hard-deleted comments from user's list
+func (p *Postgres) saveComment(tx *sql.Tx, comment store.Comment) error {
+	data, err := json.Marshal(comment)
+	if err != nil {
+		return fmt.Errorf("can't marshal comment: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE comments SET data = $4, deleted = $5, user_id = $6 WHERE site = $1 AND url = $2 AND id = $3`,
+		comment.Locator.SiteID, comment.Locator.URL, comment.ID, data, comment.Deleted, comment.User.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save comment %s: %w", comment.ID, err)
+	}
+	return nil
+}
+
+func (p *Postgres) scanComment(row *sql.Row) (comment store.Comment, err error) {
+	var data []byte
+	if err = row.Scan(&data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return comment, fmt.Errorf("no comment in store")
+		}
+		return comment, fmt.Errorf("can't load comment: %w", err)
+	}
+	if err = json.Unmarshal(data, &comment); err != nil {
+		return comment, fmt.Errorf("failed to unmarshal: %w", err)
+	}
+	return comment, nil
+}
+
+func (p *Postgres) scanComments(rows *sql.Rows) ([]store.Comment, error) {
+	defer rows.Close() // nolint
+	comments := []store.Comment{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("can't scan comment: %w", err)
+		}
+		comment := store.Comment{}
+		if err := json.Unmarshal(data, &comment); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal: %w", err)
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// tx runs fn in transaction, rolls back on error
+func (p *Postgres) tx(fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (p *Postgres) checkSite(siteID string) error {
+	if !p.sites[siteID] {
+		return fmt.Errorf("site %q not found", siteID)
+	}
+	return nil
+}
+
+// execer is implemented by both sql.DB and sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
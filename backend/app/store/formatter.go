@@ -1,7 +1,9 @@
 package store
 
 import (
+	stdhtml "html"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/Depado/bfchroma/v2"
@@ -13,8 +15,19 @@ import (
 // CommentFormatter implements all generic formatting ops on comment
 type CommentFormatter struct {
 	converters []CommentConverter
+	Previewer  LinkPreviewer // optional, adds previews of links to formatted comments
+	PlainText  []string      // sites with comments rendered as plain text with links, without markdown, AllSitesPlainText for all
 }
 
+// AllSitesPlainText is the PlainText element making comments of all sites rendered as plain text
+const AllSitesPlainText = "*"
+
+// plainTextURL matches bare links in plain text comment, trailing punctuation excluded
+var plainTextURL = regexp.MustCompile(`https?://[^\s<>"']*[^\s<>"'.,;:!?)\]]`)
+
+// plainTextParagraph matches empty lines separating paragraphs of plain text comment
+var plainTextParagraph = regexp.MustCompile(`\n\s*\n`)
+
 // CommentConverter defines interface to convert some parts of commentHTML
 // Passed at creation time and does client-defined conversions, like image proxy link change
 type CommentConverter interface {
@@ -29,6 +42,11 @@ func (f CommentConverterFunc) Convert(text string) string {
 	return f(text)
 }
 
+// LinkPreviewer defines interface to add previews of links to commentHTML of the site
+type LinkPreviewer interface {
+	Preview(siteID, commentHTML string) string
+}
+
 // NewCommentFormatter makes CommentFormatter
 func NewCommentFormatter(converters ...CommentConverter) *CommentFormatter {
 	return &CommentFormatter{converters: converters}
@@ -36,7 +54,10 @@ func NewCommentFormatter(converters ...CommentConverter) *CommentFormatter {
 
 // Format comment fields
 func (f *CommentFormatter) Format(c Comment, raw bool) Comment {
-	c.Text = f.FormatText(c.Text, raw)
+	c.Text = f.FormatSiteText(c.Locator.SiteID, c.Text, raw)
+	if f.Previewer != nil {
+		c.Text = f.Previewer.Preview(c.Locator.SiteID, c.Text)
+	}
 	return c
 }
 
@@ -56,6 +77,59 @@ func (f *CommentFormatter) FormatText(txt string, raw bool) (res string) {
 	return res
 }
 
+// FormatSiteText formats text the way the site set, as plain text if the site is in PlainText, or as markdown
+func (f *CommentFormatter) FormatSiteText(siteID, txt string, raw bool) string {
+	if !f.isPlainText(siteID) {
+		return f.FormatText(txt, raw)
+	}
+	res := f.plainText(txt)
+	for _, conv := range f.converters {
+		res = conv.Convert(res)
+	}
+	return f.shortenAutoLinks(res, shortURLLen)
+}
+
+func (f *CommentFormatter) isPlainText(siteID string) bool {
+	for _, s := range f.PlainText {
+		if s == siteID || s == AllSitesPlainText {
+			return true
+		}
+	}
+	return false
+}
+
+// plainText converts text to html as is, with all html and markdown escaped and bare links made clickable.
+// Paragraphs separated by empty lines, line breaks kept.
+func (f *CommentFormatter) plainText(txt string) string {
+	txt = strings.TrimSpace(strings.ReplaceAll(txt, "\r\n", "\n"))
+	if txt == "" {
+		return ""
+	}
+	pars := plainTextParagraph.Split(txt, -1)
+	for i, par := range pars {
+		lines := strings.Split(strings.TrimSpace(par), "\n")
+		for j, line := range lines {
+			lines[j] = linkify(line)
+		}
+		pars[i] = "<p>" + strings.Join(lines, "<br>\n") + "</p>\n"
+	}
+	return strings.Join(pars, "\n")
+}
+
+// linkify escapes html in the line of text and wraps bare links in anchors
+func linkify(line string) string {
+	var res strings.Builder
+	last := 0
+	for _, loc := range plainTextURL.FindAllStringIndex(line, -1) {
+		link := stdhtml.EscapeString(line[loc[0]:loc[1]])
+		res.WriteString(stdhtml.EscapeString(line[last:loc[0]]))
+		res.WriteString(`<a href="` + link + `">` + link + `</a>`)
+		last = loc[1]
+	}
+	res.WriteString(stdhtml.EscapeString(line[last:]))
+	return res.String()
+}
+
 // Shortens all the automatic links in HTML: auto link has equal "href" and "text" attributes.
 func (f *CommentFormatter) shortenAutoLinks(commentHTML string, maximum int) (resHTML string) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
@@ -90,6 +90,50 @@ func TestFormatter_FormatComment(t *testing.T) {
 	assert.Equal(t, exp, f.Format(comment, false))
 }
 
+func TestFormatter_FormatCommentWithPreviewer(t *testing.T) {
+	comment := Comment{Text: "blah", Locator: Locator{SiteID: "site", URL: "http://example.com"}}
+	f := NewCommentFormatter()
+	f.Previewer = mockPreviewer{}
+	assert.Equal(t, "<p>blah</p>\n[site preview]", f.Format(comment, false).Text)
+}
+
+func TestFormatter_FormatSiteTextPlain(t *testing.T) {
+	f := NewCommentFormatter(mockConverter{})
+	f.PlainText = []string{"plain"}
+
+	tbl := []struct {
+		in, out string
+		name    string
+	}{
+		{"", "!converted", "empty"},
+		{"**xyz** _aaa_ # title", "<p>**xyz** _aaa_ # title</p>\n!converted", "markdown shown as is"},
+		{"[link](http://example.com) `code`", "<p>[link](<a href=\"http://example.com\">http://example.com</a>) `code`</p>\n!converted",
+			"markdown link not rendered"},
+		{`<b>bold</b> <img src="x.png"> "quoted"`, "<p>&lt;b&gt;bold&lt;/b&gt; &lt;img src=&#34;x.png&#34;&gt; &#34;quoted&#34;</p>\n!converted",
+			"html escaped"},
+		{"see https://example.com/page?a=1&b=2, and http://example.org.",
+			"<p>see <a href=\"https://example.com/page?a=1&amp;b=2\">https://example.com/page?a=1&amp;b=2</a>, " +
+				"and <a href=\"http://example.org\">http://example.org</a>.</p>\n!converted", "bare links"},
+		{"line1\nline2\n\n\nline3", "<p>line1<br/>\nline2</p>\n\n<p>line3</p>\n!converted", "lines and paragraphs"},
+		{"http://127.0.0.1/some-long-link/12345/678901234567890",
+			"<p><a href=\"http://127.0.0.1/some-long-link/12345/678901234567890\">http://127.0.0." +
+				"1/some-long-link/12345/6789012...</a></p>\n!converted", "long link shortened"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.out, f.FormatSiteText("plain", tt.in, false))
+		})
+	}
+
+	assert.Equal(t, "<p><strong>xyz</strong></p>\n!converted", f.FormatSiteText("other", "**xyz**", false),
+		"markdown for other sites")
+	comment := Comment{Text: "**xyz**", Locator: Locator{SiteID: "plain"}}
+	assert.Equal(t, "<p>**xyz**</p>\n!converted", f.Format(comment, false).Text)
+
+	f.PlainText = []string{AllSitesPlainText}
+	assert.Equal(t, "<p>**xyz**</p>\n!converted", f.FormatSiteText("other", "**xyz**", false))
+}
+
 func TestFormatter_ShortenAutoLinks(t *testing.T) {
 	f := NewCommentFormatter(nil)
 	tbl := []struct {
@@ -152,3 +196,9 @@ func TestCommentFormatter_lazyImage(t *testing.T) {
 		})
 	}
 }
+
+type mockPreviewer struct{}
+
+func (m mockPreviewer) Preview(siteID, commentHTML string) string {
+	return commentHTML + "[" + siteID + " preview]"
+}
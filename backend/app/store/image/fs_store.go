@@ -18,6 +18,7 @@ import (
 )
 
 // FileSystem provides image Store for local files. Saves and loads files from Location, restricts max size.
+// Images stored in flat layout, without partitions, are still loaded and can be moved to partitions with MigrateFlat.
 type FileSystem struct {
 	Location   string
 	Staging    string
@@ -54,7 +55,7 @@ func (f *FileSystem) Commit(id string) error {
 	f.moveLock.Lock()
 	defer f.moveLock.Unlock()
 	log.Printf("[DEBUG] Commit image %s", id)
-	stagingImage, permImage := f.location(f.Staging, id), f.location(f.Location, id)
+	stagingImage, permImage := f.existing(f.Staging, id), f.location(f.Location, id)
 
 	if err := os.MkdirAll(path.Dir(permImage), 0o700); err != nil {
 		return fmt.Errorf("can't make image directory: %w", err)
@@ -68,7 +69,7 @@ func (f *FileSystem) Commit(id string) error {
 
 // ResetCleanupTimer resets cleanup timer for the image
 func (f *FileSystem) ResetCleanupTimer(id string) error {
-	file := f.location(f.Staging, id)
+	file := f.existing(f.Staging, id)
 	_, err := os.Stat(file)
 	if err != nil {
 		return fmt.Errorf("can't get image stats for %s: %w", id, err)
@@ -86,10 +87,10 @@ func (f *FileSystem) ResetCleanupTimer(id string) error {
 func (f *FileSystem) Load(id string) ([]byte, error) {
 	// get image file by id. first try permanent location and if not found - staging
 	img := func(id string) (file string, err error) {
-		file = f.location(f.Location, id)
+		file = f.existing(f.Location, id)
 		_, err = os.Stat(file)
 		if err != nil {
-			file = f.location(f.Staging, id)
+			file = f.existing(f.Staging, id)
 			_, err = os.Stat(file)
 		}
 		if err != nil {
@@ -114,10 +115,10 @@ func (f *FileSystem) Load(id string) ([]byte, error) {
 func (f *FileSystem) Delete(id string) error {
 	f.moveLock.Lock()
 	defer f.moveLock.Unlock()
-	staging := f.location(f.Staging, id)
+	staging := f.existing(f.Staging, id)
 	// file doesn't exist on staging, delete from permanent location
 	if _, err := os.Stat(staging); os.IsNotExist(err) {
-		file := f.location(f.Location, id)
+		file := f.existing(f.Location, id)
 		e := os.Remove(file)
 		_ = os.Remove(path.Dir(file)) // try to remove directory
 		return e
@@ -203,14 +204,93 @@ func (f *FileSystem) location(base, id string) string {
 		return fmt.Sprintf(f.crc.mask, partition)
 	}
 
-	user, file := "unknown", id // default if no user in id
-	if elems := strings.Split(id, "/"); len(elems) == 2 {
-		user, file = elems[0], elems[1] // user in id
-	}
-
+	user, file := splitID(id)
 	if f.Partitions == 0 {
 		return path.Join(base, user, file) // avoid partition directory if 0 Partitions
 	}
 
 	return path.Join(base, user, partition(id), file)
 }
+
+// existing returns location of the image in base directory, partitioned one or flat one if the image stored
+// before partitioning. Partitioned location returned for image not found in both
+func (f *FileSystem) existing(base, id string) string {
+	file := f.location(base, id)
+	if f.Partitions == 0 || fileExists(file) {
+		return file
+	}
+	user, name := splitID(id)
+	if flat := path.Join(base, user, name); fileExists(flat) {
+		return flat
+	}
+	return file
+}
+
+// MigrateFlat moves images stored in flat layout, made with 0 Partitions, to partition subdirectories
+// of permanent and staging locations. Images loaded from both layouts, so migration is safe to run
+// on working store. Returns number of moved images
+func (f *FileSystem) MigrateFlat() (count int, err error) {
+	if f.Partitions == 0 {
+		return 0, nil
+	}
+	for _, base := range []string{f.Location, f.Staging} {
+		users, e := os.ReadDir(base)
+		if os.IsNotExist(e) {
+			continue
+		}
+		if e != nil {
+			return count, fmt.Errorf("can't read images directory %s: %w", base, e)
+		}
+		for _, user := range users {
+			if !user.IsDir() {
+				continue
+			}
+			files, e := os.ReadDir(path.Join(base, user.Name()))
+			if e != nil {
+				return count, fmt.Errorf("can't read images directory of %s: %w", user.Name(), e)
+			}
+			for _, file := range files {
+				if file.IsDir() { // partition subdirectory
+					continue
+				}
+				if e := f.moveFlat(base, user.Name()+"/"+file.Name()); e != nil {
+					return count, e
+				}
+				count++
+			}
+		}
+	}
+	if count > 0 {
+		log.Printf("[INFO] %d images moved from flat layout to partitions", count)
+	}
+	return count, nil
+}
+
+// moveFlat moves image from flat layout to its partition
+func (f *FileSystem) moveFlat(base, id string) error {
+	f.moveLock.Lock()
+	defer f.moveLock.Unlock()
+	user, name := splitID(id)
+	flat, dst := path.Join(base, user, name), f.location(base, id)
+	if err := os.MkdirAll(path.Dir(dst), 0o700); err != nil {
+		return fmt.Errorf("can't make image directory: %w", err)
+	}
+	if err := os.Rename(flat, dst); err != nil && !os.IsNotExist(err) { // image committed or deleted meanwhile
+		return fmt.Errorf("can't move image %s to partition: %w", id, err)
+	}
+	return nil
+}
+
+// splitID returns user and file name of the image id, user is "unknown" if id has no user
+func splitID(id string) (user, file string) {
+	user, file = "unknown", id // default if no user in id
+	if elems := strings.Split(id, "/"); len(elems) == 2 {
+		user, file = elems[0], elems[1] // user in id
+	}
+	return user, file
+}
+
+func fileExists(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && !info.IsDir()
+}
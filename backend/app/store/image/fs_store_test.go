@@ -201,6 +201,60 @@ func TestFsStore_location(t *testing.T) {
 	}
 }
 
+func TestFsStore_FlatLayout(t *testing.T) {
+	svc, teardown := prepareImageTest(t)
+	defer teardown()
+
+	// images stored before partitioning
+	flat := FileSystem{Location: svc.Location, Staging: svc.Staging}
+	require.NoError(t, flat.Save("user1/flat_committed.png", gopherPNGBytes()))
+	require.NoError(t, flat.Commit("user1/flat_committed.png"))
+	require.NoError(t, flat.Save("user1/flat_staging.png", gopherPNGBytes()))
+	require.NoError(t, flat.Save("user2/flat_deleted.png", gopherPNGBytes()))
+	require.NoError(t, flat.Commit("user2/flat_deleted.png"))
+	assert.FileExists(t, path.Join(svc.Location, "user1", "flat_committed.png"))
+
+	// new uploads land in partitions
+	require.NoError(t, svc.Save("user1/new.png", gopherPNGBytes()))
+	assert.Regexp(t, `^`+svc.Staging+`/user1/\d{2}/new.png$`, svc.location(svc.Staging, "user1/new.png"))
+	assert.FileExists(t, svc.location(svc.Staging, "user1/new.png"))
+	assert.NoFileExists(t, path.Join(svc.Staging, "user1", "new.png"))
+	require.NoError(t, svc.Commit("user1/new.png"))
+	assert.FileExists(t, svc.location(svc.Location, "user1/new.png"))
+
+	// both layouts readable
+	for _, id := range []string{"user1/flat_committed.png", "user1/flat_staging.png", "user1/new.png"} {
+		data, err := svc.Load(id)
+		require.NoError(t, err, id)
+		assert.Equal(t, gopherPNGBytes(), data, id)
+	}
+	require.NoError(t, svc.ResetCleanupTimer("user1/flat_staging.png"))
+	require.NoError(t, svc.Delete("user2/flat_deleted.png"))
+	_, err := svc.Load("user2/flat_deleted.png")
+	assert.Error(t, err)
+
+	count, err := svc.MigrateFlat()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.FileExists(t, svc.location(svc.Location, "user1/flat_committed.png"))
+	assert.NoFileExists(t, path.Join(svc.Location, "user1", "flat_committed.png"))
+	assert.FileExists(t, svc.location(svc.Staging, "user1/flat_staging.png"))
+	for _, id := range []string{"user1/flat_committed.png", "user1/flat_staging.png", "user1/new.png"} {
+		data, err := svc.Load(id)
+		require.NoError(t, err, id)
+		assert.Equal(t, gopherPNGBytes(), data, id)
+	}
+	require.NoError(t, svc.Commit("user1/flat_staging.png"), "migrated staging image committed")
+	assert.FileExists(t, svc.location(svc.Location, "user1/flat_staging.png"))
+
+	count, err = svc.MigrateFlat()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "nothing left to migrate")
+	count, err = flat.MigrateFlat()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "no migration without partitions")
+}
+
 func TestFsStore_Cleanup(t *testing.T) {
 	svc, teardown := prepareImageTest(t)
 	defer teardown()
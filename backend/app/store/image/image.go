@@ -13,9 +13,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"image"
-	// support gif and jpeg images decoding
-	_ "image/gif"
-	_ "image/jpeg"
+	"image/gif"
+	"image/jpeg"
 	"image/png"
 	"io"
 	"net/http"
@@ -31,6 +30,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/rs/xid"
 	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // support webp images decoding
 )
 
 // Service wraps Store with common functions needed for any store implementation
@@ -55,8 +55,16 @@ type ServiceParams struct {
 	MaxSize      int
 	MaxHeight    int
 	MaxWidth     int
+
+	MaxDimension   int      // max width or height of the image, bigger images rejected. 0 means no limit
+	AllowedTypes   []string // allowed MIME types, gif, png, jpeg and webp by default
+	RejectAnimated bool     // reject animated gif and webp images
+	ReEncode       bool     // decode and encode images again, drops metadata like EXIF
 }
 
+// defaultAllowedTypes used if ServiceParams.AllowedTypes not set
+var defaultAllowedTypes = []string{"image/gif", "image/png", "image/jpeg", "image/webp"}
+
 // StoreInfo contains image store meta information
 type StoreInfo struct {
 	FirstStagingImageTS time.Time
@@ -280,17 +288,86 @@ func (s *Service) extractImageIDs(commentHTML string, includeProxied bool) (ids
 	return ids
 }
 
-// prepareImage calls readAndValidateImage and resize on provided image.
+// prepareImage calls readAndValidateImage, checks dimensions and animation, re-encodes and resizes provided image.
 func (s *Service) prepareImage(r io.Reader) ([]byte, error) {
-	data, err := readAndValidateImage(r, s.MaxSize)
+	allowedTypes := s.AllowedTypes
+	if len(allowedTypes) == 0 {
+		allowedTypes = defaultAllowedTypes
+	}
+	data, err := readAndValidateImage(r, s.MaxSize, allowedTypes)
 	if err != nil {
 		return nil, fmt.Errorf("can't load image: %w", err)
 	}
 
+	if s.MaxDimension > 0 {
+		cfg, _, e := image.DecodeConfig(bytes.NewReader(data))
+		if e != nil {
+			return nil, fmt.Errorf("can't decode image config: %w", e)
+		}
+		if cfg.Width > s.MaxDimension || cfg.Height > s.MaxDimension {
+			return nil, fmt.Errorf("image dimensions %dx%d are too large (limit=%d)", cfg.Width, cfg.Height, s.MaxDimension)
+		}
+	}
+
+	animated := isAnimated(data)
+	if animated && s.RejectAnimated {
+		return nil, fmt.Errorf("animated images not allowed")
+	}
+
+	if s.ReEncode {
+		if data, err = reEncode(data, animated); err != nil {
+			return nil, fmt.Errorf("can't re-encode image: %w", err)
+		}
+	}
+
 	data = resize(data, s.MaxWidth, s.MaxHeight)
 	return data, nil
 }
 
+// reEncode decodes and encodes the image again, dropping all metadata (EXIF, comments and so on) and anything
+// attached to the image data. JPEG kept as JPEG, animated GIF as GIF and all other formats converted to PNG.
+func reEncode(data []byte, animated bool) ([]byte, error) {
+	var out bytes.Buffer
+	if animated && http.DetectContentType(data) == "image/gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("can't decode gif: %w", err)
+		}
+		if err = gif.EncodeAll(&out, &gif.GIF{Image: g.Image, Delay: g.Delay, Disposal: g.Disposal, LoopCount: g.LoopCount,
+			Config: g.Config, BackgroundIndex: g.BackgroundIndex}); err != nil {
+			return nil, fmt.Errorf("can't encode gif: %w", err)
+		}
+		return out.Bytes(), nil
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("can't decode image: %w", err)
+	}
+	if format == "jpeg" {
+		err = jpeg.Encode(&out, src, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&out, src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't encode %s image: %w", format, err)
+	}
+	return out.Bytes(), nil
+}
+
+// isAnimated checks if the image is animated gif or webp
+func isAnimated(data []byte) bool {
+	switch http.DetectContentType(data) {
+	case "image/gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		return err == nil && len(g.Image) > 1
+	case "image/webp":
+		// extended webp format has animation flag in VP8X chunk, "RIFF" size "WEBP" "VP8X" size flags
+		return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+	}
+	return false
+}
+
 // resize an image of supported format (PNG, JPG, GIF) to the size of "limit" px of
 // the biggest side (width or height) preserving aspect ratio.
 // Returns original data if resizing is not needed or failed.
@@ -344,11 +421,16 @@ func getProportionalSizes(srcW, srcH, limitW, limitH int) (resW, resH int) {
 	return limitW, int(propH)
 }
 
-// check if file f is a valid image format, i.e. gif, png, jpeg or webp and reads up to maxSize.
-func readAndValidateImage(r io.Reader, maxSize int) ([]byte, error) {
+// check if file f is a valid image format of allowed types, i.e. gif, png, jpeg or webp and reads up to maxSize.
+func readAndValidateImage(r io.Reader, maxSize int, allowedTypes []string) ([]byte, error) {
 	isValidImage := func(b []byte) bool {
-		ct := http.DetectContentType(b)
-		return ct == "image/gif" || ct == "image/png" || ct == "image/jpeg" || ct == "image/webp"
+		ct := http.DetectContentType(b) // uses first 512 bytes at most
+		for _, t := range allowedTypes {
+			if ct == t {
+				return true
+			}
+		}
+		return false
 	}
 
 	lr := io.LimitReader(r, int64(maxSize)+1)
@@ -362,7 +444,7 @@ func readAndValidateImage(r io.Reader, maxSize int) ([]byte, error) {
 	}
 
 	// read header first, needs it to check if data is valid png/gif/jpeg
-	if !isValidImage(data[:512]) {
+	if !isValidImage(data) {
 		return nil, fmt.Errorf("file format not allowed")
 	}
 
@@ -6,7 +6,12 @@ import (
 	"encoding/base64"
 	"fmt"
 	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -41,7 +46,7 @@ func TestService_SaveAndLoad(t *testing.T) {
 }
 
 func TestService_Resize(t *testing.T) {
-	img, err := readAndValidateImage(gopherPNG(), 1500)
+	img, err := readAndValidateImage(gopherPNG(), 1500, defaultAllowedTypes)
 	assert.NoError(t, err)
 	assert.Equal(t, 1462, len(img))
 
@@ -54,7 +59,7 @@ func TestService_ResizeJpeg(t *testing.T) {
 	defer func() { assert.NoError(t, fh.Close()) }()
 	assert.NoError(t, err)
 
-	img, err := readAndValidateImage(fh, 32000)
+	img, err := readAndValidateImage(fh, 32000, defaultAllowedTypes)
 	assert.NoError(t, err)
 	assert.Equal(t, 16756, len(img))
 
@@ -80,6 +85,71 @@ func TestService_WrongFormat(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestService_Validation(t *testing.T) {
+	store := StoreMock{SaveFunc: func(string, []byte) error { return nil }}
+
+	svc := NewService(&store, ServiceParams{MaxSize: 32000, MaxDimension: 100})
+	_, err := svc.Save("user1", bytes.NewReader(testImage(t, "png", 101, 50)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "image dimensions 101x50 are too large (limit=100)")
+	_, err = svc.Save("user1", bytes.NewReader(testImage(t, "png", 100, 100)))
+	assert.NoError(t, err)
+
+	svc = NewService(&store, ServiceParams{MaxSize: 32000, AllowedTypes: []string{"image/png"}})
+	_, err = svc.Save("user1", bytes.NewReader(testImage(t, "jpeg", 10, 10)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "file format not allowed")
+	_, err = svc.Save("user1", bytes.NewReader(testImage(t, "png", 10, 10)))
+	assert.NoError(t, err)
+
+	svc = NewService(&store, ServiceParams{MaxSize: 32000, RejectAnimated: true})
+	_, err = svc.Save("user1", bytes.NewReader(testAnimatedGIF(t)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "animated images not allowed")
+	_, err = svc.Save("user1", bytes.NewReader(testImage(t, "gif", 10, 10)))
+	assert.NoError(t, err)
+}
+
+func TestService_ReEncode(t *testing.T) {
+	var saved []byte
+	store := StoreMock{SaveFunc: func(_ string, img []byte) error {
+		saved = img
+		return nil
+	}}
+
+	// jpeg with EXIF segment, APP1 marker goes right after SOI
+	jpg := testImage(t, "jpeg", 20, 20)
+	exif := append([]byte{0xFF, 0xE1, 0x00, 0x1A}, []byte("Exif\x00\x00GPS 55.7558,37.6173")...)
+	jpg = append(append(append([]byte{}, jpg[:2]...), exif...), jpg[2:]...)
+	_, _, err := image.Decode(bytes.NewReader(jpg))
+	require.NoError(t, err, "jpeg with EXIF is valid")
+
+	svc := NewService(&store, ServiceParams{MaxSize: 32000})
+	_, err = svc.Save("user1", bytes.NewReader(jpg))
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), "Exif", "kept as is without re-encoding")
+
+	svc = NewService(&store, ServiceParams{MaxSize: 32000, ReEncode: true})
+	_, err = svc.Save("user1", bytes.NewReader(jpg))
+	require.NoError(t, err)
+	assert.NotContains(t, string(saved), "Exif")
+	assert.NotContains(t, string(saved), "GPS")
+	_, format, err := image.Decode(bytes.NewReader(saved))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	// gif converted to png, animated gif kept as gif with all frames
+	_, err = svc.Save("user1", bytes.NewReader(testImage(t, "gif", 10, 10)))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", http.DetectContentType(saved))
+
+	_, err = svc.Save("user1", bytes.NewReader(testAnimatedGIF(t)))
+	require.NoError(t, err)
+	g, err := gif.DecodeAll(bytes.NewReader(saved))
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(g.Image))
+}
+
 func TestService_ExtractPictures(t *testing.T) {
 	svc := Service{ServiceParams: ServiceParams{ImageAPI: "/blah/", ProxyAPI: "/non_existent"}}
 	html := `blah <img src="/blah/user1/pic1.png"/> foo
@@ -278,6 +348,38 @@ func TestCachedImgID(t *testing.T) {
 	assert.Equal(t, "cached_images/"+Sha1Str("example.org")+"-"+Sha1Str(imgURL), img)
 }
 
+// testImage makes image of given format and size
+func testImage(t *testing.T, format string, width, height int) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+	for x := 0; x < width; x++ {
+		img.SetColorIndex(x, x%height, uint8(x%256))
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+// testAnimatedGIF makes gif with two frames
+func testAnimatedGIF(t *testing.T) []byte {
+	frame := func(c uint8) *image.Paletted {
+		img := image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9)
+		img.SetColorIndex(5, 5, c)
+		return img
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame(1), frame(2)}, Delay: []int{10, 10}}))
+	return buf.Bytes()
+}
+
 func TestService_DoubleClose(*testing.T) {
 	store := StoreMock{}
 	svc := NewService(&store, ServiceParams{EditDuration: 20 * time.Millisecond})
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// AllSitesBlockedNames is the key of BlockedNames with names blocked for all sites
+const AllSitesBlockedNames = "*"
+
+// ErrBlockedName returned for comment of user with the name matching blocked names of the site
+var ErrBlockedName = errors.New("user name is not allowed")
+
+// confusables maps characters looking the same as latin letters, i.e. Cyrillic and Greek homoglyphs
+// and digits used in place of letters, to these letters. Both "i" and "l" mapped to "l" as they are
+// hardly distinguishable in many fonts, the same way as "1", "|" and "!".
+var confusables = map[rune]rune{
+	// digits and symbols
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '|': 'l', '!': 'l',
+	'i': 'l', 'ı': 'l',
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'і': 'l', 'ј': 'j', 'к': 'k', 'м': 'm',
+	'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'ω': 'w',
+}
+
+// IsNameBlocked checks if user name contains any of names blocked for the site or for all sites.
+// Names compared by skeletons, see nameSkeleton, so "Ádmin", "a.d.m.i.n", "4dm1n" and "аdmin"
+// with Cyrillic "а" all match blocked "admin".
+func (s *DataStore) IsNameBlocked(siteID, name string) bool {
+	if len(s.BlockedNames) == 0 {
+		return false
+	}
+	skeleton := nameSkeleton(name)
+	if skeleton == "" {
+		return false
+	}
+	for _, key := range []string{siteID, AllSitesBlockedNames} {
+		for _, blocked := range s.BlockedNames[key] {
+			if b := nameSkeleton(blocked); b != "" && strings.Contains(skeleton, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nameSkeleton makes name form used to match names visually similar to each other. Name decomposed in compatibility
+// mode, turning full-width, styled and ligature characters to plain ones, with diacritics dropped and case folded.
+// Confusable characters mapped to latin letters and everything except letters and digits removed.
+func nameSkeleton(name string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFKD.String(name) {
+		if unicode.Is(unicode.Mn, r) { // combining marks, i.e. diacritics separated by decomposition
+			continue
+		}
+		r = unicode.ToLower(r)
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
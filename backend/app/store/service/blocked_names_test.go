@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_IsNameBlocked(t *testing.T) {
+	b := DataStore{BlockedNames: map[string][]string{
+		AllSitesBlockedNames: {"admin"},
+		"radio-t":            {"Troll Face", ""},
+	}}
+
+	tbl := []struct {
+		site, name string
+		blocked    bool
+	}{
+		{"radio-t", "admin", true},
+		{"radio-t", "Site Admin", true},
+		{"other", "ADMIN", true},
+		{"radio-t", "trollface", true},
+		{"radio-t", "t.r.o.l.l_f.a.c.e", true},
+		{"other", "trollface", false},
+
+		// confusable variants
+		{"radio-t", "аdmin", true},     // cyrillic "а"
+		{"radio-t", "αdmιn", true},     // greek "α" and "ι"
+		{"radio-t", "Ádmín", true},     // diacritics
+		{"radio-t", "ａｄｍｉｎ", true},     // full-width
+		{"radio-t", "𝐚𝐝𝐦𝐢𝐧", true},     // mathematical bold
+		{"radio-t", "4dm1n", true},     // digits
+		{"radio-t", "adm|n", true},     // symbols
+		{"radio-t", "tr0llfаcе", true}, // mixed
+
+		{"radio-t", "administrator", true},
+		{"radio-t", "adamant", false},
+		{"radio-t", "user name", false},
+		{"radio-t", "", false},
+		{"radio-t", "...", false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.blocked, b.IsNameBlocked(tt.site, tt.name), "%s: %q", tt.site, tt.name)
+	}
+
+	assert.False(t, (&DataStore{}).IsNameBlocked("radio-t", "admin"), "nothing blocked by default")
+}
+
+func TestService_ValidateCommentBlockedName(t *testing.T) {
+	b := DataStore{BlockedNames: map[string][]string{"radio-t": {"troll"}}}
+	c := store.Comment{Orig: "some text", Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"},
+		User: store.User{ID: "user1", Name: "Big Trоll"}} // cyrillic "о"
+
+	err := b.ValidateComment(&c)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrBlockedName))
+	assert.EqualError(t, err, `user name is not allowed: "Big Trоll"`)
+
+	c.User.Admin = true
+	assert.NoError(t, b.ValidateComment(&c), "admin not checked")
+
+	c.User.Admin, c.Locator.SiteID = false, "other"
+	assert.NoError(t, b.ValidateComment(&c), "blocked for other site only")
+}
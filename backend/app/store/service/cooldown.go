@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// ErrCooldown returned for comment posted to the post too soon after the previous comment of the user there
+var ErrCooldown = errors.New("comment posted too soon")
+
+// CooldownError is ErrCooldown with the time left till the user allowed to comment the post again
+type CooldownError struct {
+	Wait time.Duration
+}
+
+func (e CooldownError) Error() string {
+	return fmt.Sprintf("comment posted too soon after the previous one, wait %v", e.Wait.Round(time.Second))
+}
+
+// Unwrap returns ErrCooldown, so CooldownError matches it with errors.Is
+func (e CooldownError) Unwrap() error { return ErrCooldown }
+
+// startCooldown checks if ThreadCooldown passed since the previous comment of the user to the post and starts
+// the new cooldown period. Returned function brings the previous period back, for comment not created.
+// Admins not limited. Comment times kept in memory and reset on restart.
+func (s *DataStore) startCooldown(comment store.Comment) (cancel func(), err error) {
+	if s.ThreadCooldown <= 0 || comment.User.Admin {
+		return func() {}, nil
+	}
+	s.cooldowns.Lock()
+	defer s.cooldowns.Unlock()
+	now := time.Now()
+	if s.cooldowns.last == nil {
+		s.cooldowns.last = map[string]time.Time{}
+	}
+	for k, ts := range s.cooldowns.last { // forget finished periods
+		if now.Sub(ts) >= s.ThreadCooldown {
+			delete(s.cooldowns.last, k)
+		}
+	}
+
+	key := comment.Locator.SiteID + "::" + comment.Locator.URL + "::" + comment.User.ID
+	prev, ok := s.cooldowns.last[key]
+	if ok {
+		return nil, CooldownError{Wait: s.ThreadCooldown - now.Sub(prev)}
+	}
+	s.cooldowns.last[key] = now
+	return func() {
+		s.cooldowns.Lock()
+		defer s.cooldowns.Unlock()
+		if s.cooldowns.last[key] == now {
+			delete(s.cooldowns.last, key)
+		}
+	}, nil
+}
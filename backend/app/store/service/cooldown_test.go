@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_ThreadCooldown(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), ThreadCooldown: 200 * time.Millisecond}
+	defer b.Close()
+	user := store.User{ID: "user1", Name: "user name"}
+	comment := func(url string, user store.User) store.Comment {
+		return store.Comment{Text: "some text", Locator: store.Locator{URL: url, SiteID: "radio-t"}, User: user}
+	}
+
+	_, err := b.Create(comment("https://radio-t.com", user))
+	require.NoError(t, err)
+
+	_, err = b.Create(comment("https://radio-t.com", user))
+	require.ErrorIs(t, err, ErrCooldown, "second comment to the same post rejected")
+	var cooldownErr CooldownError
+	require.ErrorAs(t, err, &cooldownErr)
+	assert.True(t, cooldownErr.Wait > 0 && cooldownErr.Wait <= 200*time.Millisecond, cooldownErr.Wait)
+
+	_, err = b.Create(comment("https://radio-t.com/other", user))
+	assert.NoError(t, err, "other post not affected")
+	_, err = b.Create(comment("https://radio-t.com", store.User{ID: "user2", Name: "other user"}))
+	assert.NoError(t, err, "other user not affected")
+	_, err = b.Create(comment("https://radio-t.com", store.User{ID: "admin", Name: "admin", Admin: true}))
+	assert.NoError(t, err)
+	_, err = b.Create(comment("https://radio-t.com", store.User{ID: "admin", Name: "admin", Admin: true}))
+	assert.NoError(t, err, "admin not limited")
+
+	time.Sleep(cooldownErr.Wait + 10*time.Millisecond)
+	_, err = b.Create(comment("https://radio-t.com", user))
+	assert.NoError(t, err, "allowed after cooldown")
+
+	dup := comment("https://radio-t.com", store.User{ID: "user3", Name: "user three"})
+	dup.ID = "id-1"
+	_, err = b.Create(dup)
+	require.Error(t, err, "comment with existing id not created")
+	_, err = b.Create(comment("https://radio-t.com", store.User{ID: "user3", Name: "user three"}))
+	assert.NoError(t, err, "not created comment doesn't start cooldown")
+}
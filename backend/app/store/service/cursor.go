@@ -0,0 +1,125 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// ErrInvalidCursor returned by PageComments for cursor which can't be decoded or made for another sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// commentsCursor is a position in the list of comments, points to the last comment of the previous page.
+// Made of the sort key of the comment, timestamp and id, so position stays the same when new comments added.
+type commentsCursor struct {
+	Sort string  `json:"s,omitempty"`
+	Pin  bool    `json:"p,omitempty"`
+	Key  float64 `json:"k,omitempty"`
+	TS   int64   `json:"t"`
+	ID   string  `json:"i"`
+}
+
+// PageComments returns up to limit comments following the cursor in order of sortMethod and the cursor of the next page,
+// empty for the last page. Empty cursor means the first page, limit <= 0 returns all comments without paging.
+// Comments ordered by sort key, ties broken by time and id, pinned comments listed first if pinFirst set.
+func PageComments(comments []store.Comment, sortMethod, cursor string, limit int, pinFirst bool) (page []store.Comment, next string, err error) {
+	if limit <= 0 {
+		return comments, "", nil
+	}
+
+	var after *commentsCursor
+	if cursor != "" {
+		c, e := decodeCursor(cursor)
+		if e != nil {
+			return nil, "", e
+		}
+		if c.Sort != sortMethod {
+			return nil, "", fmt.Errorf("%w: made for sort %q", ErrInvalidCursor, c.Sort)
+		}
+		after = &c
+	}
+
+	keys := make([]commentsCursor, len(comments))
+	idx := make([]int, len(comments))
+	for i, c := range comments {
+		keys[i] = makeCursor(c, sortMethod, pinFirst)
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return keys[idx[i]].less(keys[idx[j]]) })
+
+	page = []store.Comment{}
+	for n, i := range idx {
+		if after != nil && !after.less(keys[i]) {
+			continue
+		}
+		if len(page) == limit {
+			prev := keys[idx[n-1]]
+			return page, prev.encode(), nil
+		}
+		page = append(page, comments[i])
+	}
+	return page, "", nil
+}
+
+func makeCursor(c store.Comment, sortMethod string, pinFirst bool) commentsCursor {
+	res := commentsCursor{Sort: sortMethod, Pin: pinFirst && c.Pin, TS: c.Timestamp.UnixNano(), ID: c.ID}
+	fld := strings.TrimLeft(sortMethod, "+-")
+	switch {
+	case fld == "score":
+		res.Key = float64(c.Score)
+	case fld == "controversy":
+		res.Key = c.Controversy
+	case fld == "reactions" || strings.HasPrefix(fld, "reactions:"):
+		res.Key = float64(c.ReactionsCount(strings.TrimPrefix(strings.TrimPrefix(fld, "reactions"), ":")))
+	}
+	return res
+}
+
+// less defines the order consistent with engine.SortComments and pinned first, with id as a final tie-breaker
+func (c commentsCursor) less(o commentsCursor) bool {
+	if c.Pin != o.Pin {
+		return c.Pin
+	}
+	desc := strings.HasPrefix(c.Sort, "-")
+	fld := strings.TrimLeft(c.Sort, "+-")
+	if !c.Pin { // pinned comments ordered by time only
+		if c.Key != o.Key {
+			if desc {
+				return c.Key > o.Key
+			}
+			return c.Key < o.Key
+		}
+		if c.TS != o.TS && desc && (fld == "time" || fld == "active") {
+			return c.TS > o.TS
+		}
+	}
+	if c.TS != o.TS {
+		return c.TS < o.TS
+	}
+	return c.ID < o.ID
+}
+
+func (c commentsCursor) encode() string {
+	data, _ := json.Marshal(c) // can't fail on plain struct
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (commentsCursor, error) {
+	var res commentsCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return res, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return res, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if res.ID == "" {
+		return res, fmt.Errorf("%w: no comment id", ErrInvalidCursor)
+	}
+	return res, nil
+}
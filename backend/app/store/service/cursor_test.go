@@ -0,0 +1,136 @@
+package service
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestPageComments(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mk := func(id string, minutes, score int, controversy float64, reactions int, pin bool) store.Comment {
+		c := store.Comment{ID: id, Timestamp: ts.Add(time.Duration(minutes) * time.Minute), Score: score,
+			Controversy: controversy, Pin: pin}
+		if reactions > 0 {
+			c.Reactions = map[string]int{"👍": reactions}
+		}
+		return c
+	}
+	comments := func() []store.Comment {
+		return []store.Comment{
+			mk("c1", 1, 3, 1.5, 2, false),
+			mk("c2", 2, 1, 0.5, 0, false),
+			mk("c3", 3, 3, 1.5, 2, false),
+			mk("c4", 3, 5, 2.5, 1, false), // same time as c3
+			mk("c5", 5, 0, 0, 0, true),
+			mk("c6", 6, -1, 3.5, 4, false),
+			mk("c7", 7, 3, 1.5, 2, false),
+		}
+	}
+
+	for _, sortMethod := range []string{"", "+time", "-time", "+active", "-active", "+score", "-score",
+		"+controversy", "-controversy", "+reactions", "-reactions", "+reactions:👍", "-reactions:👍"} {
+		t.Run(sortMethod, func(t *testing.T) {
+			all, next, err := PageComments(comments(), sortMethod, "", 0, true)
+			require.NoError(t, err)
+			assert.Empty(t, next, "no paging without limit")
+			assert.Len(t, all, 7)
+
+			// pages cover all comments in the order of the sort, pinned first
+			expected, _, err := PageComments(comments(), sortMethod, "", 100, true)
+			require.NoError(t, err)
+			assert.Equal(t, "c5", expected[0].ID, "pinned first")
+			// c4 excluded as engine's order of comments with the same time is not defined
+			assert.Equal(t, ids(engine.SortComments(without(comments(), "c4", "c5"), sortMethod)),
+				ids(without(expected, "c4", "c5")), "same order as engine")
+			assert.Equal(t, ids(expected), ids(fetchPages(t, comments(), sortMethod, 2)))
+
+			// comment added between page fetches doesn't shift pages
+			page1, next, err := PageComments(comments(), sortMethod, "", 3, true)
+			require.NoError(t, err)
+			require.NotEmpty(t, next)
+			extended := append(comments(), mk("c0", 0, 10, 5, 5, false), mk("c8", 8, -5, 0, 0, false), mk("c9", 3, 3, 1.5, 2, false))
+			rest := fetchPages(t, extended, sortMethod, 2, next)
+			seen := map[string]int{}
+			for _, c := range append(page1, rest...) {
+				seen[c.ID]++
+			}
+			for _, c := range comments() {
+				assert.Equal(t, 1, seen[c.ID], "comment %s listed once", c.ID)
+			}
+			for id, n := range seen {
+				assert.Equal(t, 1, n, "comment %s duplicated", id)
+			}
+		})
+	}
+
+	t.Run("no pinned first", func(t *testing.T) {
+		res, _, err := PageComments(comments(), "-time", "", 10, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c7", "c6", "c5", "c3", "c4", "c2", "c1"}, ids(res))
+	})
+
+	t.Run("last page", func(t *testing.T) {
+		res, next, err := PageComments(comments(), "+time", "", 7, true)
+		require.NoError(t, err)
+		assert.Len(t, res, 7)
+		assert.Empty(t, next)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, next, err := PageComments(comments(), "+time", "", 2, true)
+		require.NoError(t, err)
+		_, _, err = PageComments(comments(), "-score", next, 2, true)
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		assert.EqualError(t, err, `invalid cursor: made for sort "+time"`)
+		for _, c := range []string{"!!!", "bm90LWpzb24", "e30"} {
+			_, _, err = PageComments(comments(), "+time", c, 2, true)
+			assert.ErrorIs(t, err, ErrInvalidCursor, c)
+		}
+	})
+}
+
+// fetchPages reads all pages of the comments with limit, starting from the cursor
+func fetchPages(t *testing.T, comments []store.Comment, sortMethod string, limit int, cursor ...string) []store.Comment {
+	var next string
+	if len(cursor) > 0 {
+		next = cursor[0]
+	}
+	res := []store.Comment{}
+	for i := 0; i < len(comments); i++ {
+		page, n, err := PageComments(comments, sortMethod, next, limit, true)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page), limit)
+		res = append(res, page...)
+		if n == "" {
+			return res
+		}
+		next = n
+	}
+	t.Fatalf("too many pages for %d comments", len(comments))
+	return nil
+}
+
+func without(comments []store.Comment, skip ...string) []store.Comment {
+	res := []store.Comment{}
+	for _, c := range comments {
+		if !slices.Contains(skip, c.ID) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func ids(comments []store.Comment) []string {
+	res := make([]string, len(comments))
+	for i, c := range comments {
+		res[i] = c.ID
+	}
+	return res
+}
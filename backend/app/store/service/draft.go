@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	lcw "github.com/go-pkgz/lcw/v2"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Draft is an in-progress comment saved for the user and the post
+type Draft struct {
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"time"`
+}
+
+const (
+	defaultDraftTTL = 24 * time.Hour
+	maxDrafts       = 10000
+)
+
+// SaveDraft stores comment draft of the user for the post, overwriting the previous one.
+// Drafts kept in memory and expire after DraftTTL
+func (s *DataStore) SaveDraft(locator store.Locator, userID, text string) (Draft, error) {
+	maxSize := s.MaxCommentSize
+	if maxSize <= 0 {
+		maxSize = defaultCommentMaxSize
+	}
+	if len([]rune(text)) > maxSize {
+		return Draft{}, fmt.Errorf("draft text exceeded max size %d (%d)", maxSize, len([]rune(text)))
+	}
+
+	s.initDrafts()
+	s.drafts.Lock()
+	defer s.drafts.Unlock()
+	key := draftKey(locator, userID)
+	draft := Draft{Text: text, Timestamp: time.Now()}
+	s.drafts.Delete(key)
+	_, err := s.drafts.Get(key, func() (Draft, error) { return draft, nil })
+	return draft, err
+}
+
+// GetDraft returns comment draft of the user for the post, false if not found or expired
+func (s *DataStore) GetDraft(locator store.Locator, userID string) (Draft, bool) {
+	s.initDrafts()
+	s.drafts.Lock()
+	defer s.drafts.Unlock()
+	return s.drafts.Peek(draftKey(locator, userID))
+}
+
+// DeleteDraft removes comment draft of the user for the post
+func (s *DataStore) DeleteDraft(locator store.Locator, userID string) {
+	s.initDrafts()
+	s.drafts.Lock()
+	defer s.drafts.Unlock()
+	s.drafts.Delete(draftKey(locator, userID))
+}
+
+func (s *DataStore) initDrafts() {
+	s.drafts.once.Do(func() {
+		ttl := s.DraftTTL
+		if ttl <= 0 {
+			ttl = defaultDraftTTL
+		}
+		o := lcw.NewOpts[Draft]()
+		s.drafts.LoadingCache, _ = lcw.NewExpirableCache[Draft](o.TTL(ttl), o.MaxKeys(maxDrafts))
+	})
+}
+
+// draftKey makes key for the user's draft for the post
+func draftKey(locator store.Locator, userID string) string {
+	return fmt.Sprintf("%s::%s::%s", userID, locator.SiteID, locator.URL)
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Drafts(t *testing.T) {
+	b := DataStore{MaxCommentSize: 100, DraftTTL: 100 * time.Millisecond}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, ok := b.GetDraft(locator, "user1")
+	assert.False(t, ok)
+
+	saved, err := b.SaveDraft(locator, "user1", "draft text")
+	require.NoError(t, err)
+	draft, ok := b.GetDraft(locator, "user1")
+	require.True(t, ok)
+	assert.Equal(t, saved, draft)
+	assert.Equal(t, "draft text", draft.Text)
+
+	// overwrite
+	_, err = b.SaveDraft(locator, "user1", "updated draft text")
+	require.NoError(t, err)
+	draft, ok = b.GetDraft(locator, "user1")
+	require.True(t, ok)
+	assert.Equal(t, "updated draft text", draft.Text)
+
+	// drafts are separate for users and posts
+	_, ok = b.GetDraft(locator, "user2")
+	assert.False(t, ok)
+	_, ok = b.GetDraft(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, "user1")
+	assert.False(t, ok)
+	_, ok = b.GetDraft(store.Locator{URL: "https://radio-t.com", SiteID: "other"}, "user1")
+	assert.False(t, ok)
+
+	_, err = b.SaveDraft(locator, "user1", strings.Repeat("x", 101))
+	assert.EqualError(t, err, "draft text exceeded max size 100 (101)")
+
+	b.DeleteDraft(locator, "user1")
+	_, ok = b.GetDraft(locator, "user1")
+	assert.False(t, ok)
+
+	// expired
+	_, err = b.SaveDraft(locator, "user1", "draft text")
+	require.NoError(t, err)
+	time.Sleep(150 * time.Millisecond)
+	_, ok = b.GetDraft(locator, "user1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// followerPrefix starts ids of anonymous followers, pseudo-users keeping email of the reader following the post
+const followerPrefix = "follower_"
+
+// FollowerID returns id of anonymous follower of the post with given email.
+// Post part of the id is the same for all followers of the post, to find them by prefix.
+func FollowerID(locator store.Locator, email string) string {
+	return followerPrefix + store.EncodeID(locator.URL) + "_" + store.EncodeID(strings.ToLower(email))
+}
+
+// Follow subscribes email to notifications about new comments to the post, without user account
+func (s *DataStore) Follow(locator store.Locator, email string) error {
+	if locator.URL == "" || email == "" {
+		return fmt.Errorf("post url and email are required to follow")
+	}
+	if _, err := s.SetUserEmail(locator.SiteID, FollowerID(locator, email), email); err != nil {
+		return fmt.Errorf("can't follow %s: %w", locator.URL, err)
+	}
+	return nil
+}
+
+// Unfollow removes email subscription to the post, unknown subscription is not an error
+func (s *DataStore) Unfollow(locator store.Locator, email string) error {
+	return s.DeleteUserDetail(locator.SiteID, FollowerID(locator, email), engine.AllUserDetails)
+}
+
+// Followers returns emails of anonymous followers of the post
+func (s *DataStore) Followers(locator store.Locator) ([]string, error) {
+	details, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.AllUserDetails, Locator: store.Locator{SiteID: locator.SiteID}})
+	if err != nil {
+		return nil, fmt.Errorf("can't get followers of %s: %w", locator.URL, err)
+	}
+	prefix := followerPrefix + store.EncodeID(locator.URL) + "_"
+	res := []string{}
+	for _, d := range details {
+		if strings.HasPrefix(d.UserID, prefix) && d.Email != "" {
+			res = append(res, d.Email)
+		}
+	}
+	return res, nil
+}
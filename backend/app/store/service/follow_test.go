@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Follow(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	post1 := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}
+	post2 := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}
+
+	res, err := b.Followers(post1)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	require.NoError(t, b.Follow(post1, "reader1@example.com"))
+	require.NoError(t, b.Follow(post1, "reader2@example.com"))
+	require.NoError(t, b.Follow(post1, "Reader1@example.com"), "same follower, email case ignored")
+	require.NoError(t, b.Follow(post2, "reader3@example.com"))
+	_, err = b.SetUserEmail("radio-t", "user1", "user1@example.com")
+	require.NoError(t, err)
+
+	res, err = b.Followers(post1)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Reader1@example.com", "reader2@example.com"}, res, "followers of other post and users excluded")
+	res, err = b.Followers(post2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"reader3@example.com"}, res)
+
+	require.NoError(t, b.Unfollow(post1, "reader1@example.com"))
+	require.NoError(t, b.Unfollow(post1, "unknown@example.com"))
+	res, err = b.Followers(post1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"reader2@example.com"}, res)
+
+	assert.NotEqual(t, FollowerID(post1, "reader2@example.com"), FollowerID(post2, "reader2@example.com"))
+	assert.EqualError(t, b.Follow(store.Locator{SiteID: "radio-t"}, "reader1@example.com"), "post url and email are required to follow")
+}
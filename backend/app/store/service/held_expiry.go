@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// actions on comments held for review and not reviewed within ReviewTTL
+const (
+	ExpiryReject  = "reject"  // held comment dropped
+	ExpiryPublish = "publish" // held comment saved as approved
+)
+
+// AllSitesReviewExpiry is the ReviewExpiry key for all sites without own action
+const AllSitesReviewExpiry = "*"
+
+// heldExpiry keeps expiration of the held comment and reminder sent to Reviewer before it
+type heldExpiry struct {
+	expires  time.Time
+	reminder *time.Timer
+}
+
+// expiryAction returns action on held comments of the site not reviewed in time, ExpiryReject by default
+func (s *DataStore) expiryAction(siteID string) string {
+	action, ok := s.ReviewExpiry[siteID]
+	if !ok {
+		action = s.ReviewExpiry[AllSitesReviewExpiry]
+	}
+	if action == ExpiryPublish {
+		return ExpiryPublish
+	}
+	return ExpiryReject
+}
+
+// trackHeld keeps expiration of the held comment and starts reminder timer if ReviewReminder set
+func (s *DataStore) trackHeld(key string, comment store.Comment, expires time.Time) {
+	e := heldExpiry{expires: expires}
+	if s.ReviewReminder > 0 && time.Until(expires) > s.ReviewReminder {
+		e.reminder = time.AfterFunc(time.Until(expires)-s.ReviewReminder, func() { s.remindHeld(key, comment, expires) })
+	}
+	s.held.expiry.Lock()
+	defer s.held.expiry.Unlock()
+	if s.held.expiry.deadlines == nil {
+		s.held.expiry.deadlines = map[string]heldExpiry{}
+	}
+	s.held.expiry.deadlines[key] = e
+}
+
+// remindHeld sends held comment to Reviewer again, if still held, with action to be applied on expiration
+func (s *DataStore) remindHeld(key string, comment store.Comment, expires time.Time) {
+	if _, ok := s.held.Peek(key); !ok {
+		return
+	}
+	secret, err := s.getSecret(comment.Locator.SiteID)
+	if err != nil {
+		log.Printf("[WARN] can't remind of held comment %s, %v", comment.ID, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reviewTimeout)
+	defer cancel()
+	req := ReviewRequest{Comment: comment, Token: reviewToken(comment.Locator.SiteID, comment.ID, secret),
+		Reminder: true, Expires: expires, ExpiryAction: s.expiryAction(comment.Locator.SiteID)}
+	if err = s.Reviewer.Review(ctx, req); err != nil {
+		log.Printf("[WARN] can't remind of held comment %s, %v", comment.ID, err)
+		return
+	}
+	log.Printf("[INFO] reminder of held comment %s sent, %s at %s", comment.ID, req.ExpiryAction, expires.Format(time.RFC3339))
+}
+
+// onHeldEvicted called on removal of comment from held ones. Comment removed after its expiration wasn't reviewed
+// in time, it's published or rejected by expiryAction. Comment reviewed, held again or evicted over the limit of
+// held comments removed before expiration, nothing done for it.
+func (s *DataStore) onHeldEvicted(key string, comment store.Comment) {
+	s.held.expiry.Lock()
+	e, ok := s.held.expiry.deadlines[key]
+	delete(s.held.expiry.deadlines, key)
+	s.held.expiry.Unlock()
+	if !ok {
+		return
+	}
+	if e.reminder != nil {
+		e.reminder.Stop()
+	}
+	if time.Now().Before(e.expires) {
+		return
+	}
+	go s.expireHeld(comment) // called by held cache under its lock
+}
+
+// expireHeld publishes or rejects held comment not reviewed in time, HeldExpired called after it
+// with the stored comment if published
+func (s *DataStore) expireHeld(comment store.Comment) {
+	published := s.expiryAction(comment.Locator.SiteID) == ExpiryPublish
+	if published {
+		if _, err := s.store(comment); err != nil {
+			log.Printf("[WARN] can't publish expired held comment %s, %v", comment.ID, err)
+			return
+		}
+		log.Printf("[INFO] held comment %s published, not reviewed in time", comment.ID)
+		if stored, err := s.Get(comment.Locator, comment.ID, nonAdminUser); err == nil {
+			comment = stored
+		}
+	} else {
+		log.Printf("[INFO] held comment %s rejected, not reviewed in time", comment.ID)
+	}
+	if s.HeldExpired != nil {
+		s.HeldExpired(comment, published)
+	}
+}
+
+// stopHeldExpiry stops reminders and expiration of held comments, made for Close
+func (s *DataStore) stopHeldExpiry() {
+	s.held.expiry.Lock()
+	defer s.held.expiry.Unlock()
+	for _, e := range s.held.expiry.deadlines {
+		if e.reminder != nil {
+			e.reminder.Stop()
+		}
+	}
+	s.held.expiry.deadlines = nil
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_HeldExpiryPublish(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	var lock sync.Mutex
+	expired := map[string]bool{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer, ReviewTTL: 100 * time.Millisecond,
+		ReviewExpiry:           map[string]string{"*": ExpiryPublish},
+		RestrictedWordsMatcher: NewRestrictedWordsMatcher(StaticRestrictedWordsLister{Words: []string{"duck"}}),
+		HeldExpired: func(comment store.Comment, published bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			expired[comment.ID] = published
+		}}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Create(store.Comment{ID: "c-1", Text: "what the duck", Locator: locator, User: store.User{ID: "user2"}})
+	require.ErrorIs(t, err, ErrCommentHeld)
+	reqs := reviewer.reqs()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, ExpiryPublish, reqs[0].ExpiryAction)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), reqs[0].Expires, 50*time.Millisecond)
+	assert.False(t, reqs[0].Reminder)
+	_, err = b.Get(locator, "c-1", store.User{})
+	require.Error(t, err, "not stored while held")
+
+	require.Eventually(t, func() bool {
+		_, e := b.Get(locator, "c-1", store.User{})
+		return e == nil
+	}, time.Second, 10*time.Millisecond, "published after expiration")
+	stored, err := b.Get(locator, "c-1", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "what the duck", stored.Text)
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return expired["c-1"]
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = b.ReviewHeld("radio-t", "c-1", reqs[0].Token, false)
+	assert.ErrorIs(t, err, ErrHeldNotFound, "expired comment can't be reviewed")
+}
+
+func TestService_HeldExpiryReject(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	var lock sync.Mutex
+	expired := map[string]bool{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer, ReviewTTL: 100 * time.Millisecond,
+		ReviewExpiry:           map[string]string{"*": ExpiryPublish, "radio-t": ExpiryReject},
+		RestrictedWordsMatcher: NewRestrictedWordsMatcher(StaticRestrictedWordsLister{Words: []string{"duck"}}),
+		HeldExpired: func(comment store.Comment, published bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			expired[comment.ID] = published
+		}}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Create(store.Comment{ID: "c-1", Text: "what the duck", Locator: locator, User: store.User{ID: "user2"}})
+	require.ErrorIs(t, err, ErrCommentHeld)
+	_, err = b.Create(store.Comment{ID: "c-2", Text: "duck again", Locator: locator, User: store.User{ID: "user2"}})
+	require.ErrorIs(t, err, ErrCommentHeld)
+	reqs := reviewer.reqs()
+	require.Len(t, reqs, 2)
+	assert.Equal(t, ExpiryReject, reqs[0].ExpiryAction, "site's action overrides all sites one")
+
+	// reviewed comment not affected by expiration
+	_, err = b.ReviewHeld("radio-t", "c-2", reqs[1].Token, true)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		_, ok := expired["c-1"]
+		return ok
+	}, time.Second, 10*time.Millisecond, "rejected after expiration")
+	lock.Lock()
+	assert.Equal(t, map[string]bool{"c-1": false}, expired)
+	lock.Unlock()
+	_, err = b.Get(locator, "c-1", store.User{})
+	assert.Error(t, err, "rejected comment not stored")
+	_, err = b.Get(locator, "c-2", store.User{})
+	assert.NoError(t, err, "approved comment stored")
+}
+
+func TestService_HeldExpiryReminder(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer, ReviewTTL: 200 * time.Millisecond,
+		ReviewReminder:         100 * time.Millisecond,
+		RestrictedWordsMatcher: NewRestrictedWordsMatcher(StaticRestrictedWordsLister{Words: []string{"duck"}})}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Create(store.Comment{ID: "c-1", Text: "what the duck", Locator: locator, User: store.User{ID: "user2"}})
+	require.ErrorIs(t, err, ErrCommentHeld)
+	_, err = b.Create(store.Comment{ID: "c-2", Text: "duck again", Locator: locator, User: store.User{ID: "user2"}})
+	require.ErrorIs(t, err, ErrCommentHeld)
+	require.Len(t, reviewer.reqs(), 2)
+	_, err = b.ReviewHeld("radio-t", "c-2", reviewer.reqs()[1].Token, false)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return len(reviewer.reqs()) == 3 }, time.Second, 10*time.Millisecond)
+	reqs := reviewer.reqs()
+	assert.Equal(t, "c-1", reqs[2].Comment.ID, "reminder sent for not reviewed comment only")
+	assert.True(t, reqs[2].Reminder)
+	assert.Equal(t, reqs[0].Token, reqs[2].Token)
+	assert.Equal(t, reqs[0].Expires, reqs[2].Expires)
+	assert.Equal(t, ExpiryReject, reqs[2].ExpiryAction)
+
+	time.Sleep(250 * time.Millisecond)
+	assert.Len(t, reviewer.reqs(), 3, "reminder sent once")
+	_, err = b.Get(locator, "c-1", store.User{})
+	assert.Error(t, err, "rejected by default")
+}
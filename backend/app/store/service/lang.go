@@ -0,0 +1,71 @@
+package service
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// langTag matches language tag like "en", "pt-br" or "zh-hant-tw" in lower case
+var langTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+
+// NormalizeLang returns language tag in lower case with "-" separators, i.e. "pt-br" for "pt_BR".
+// Returns empty string for invalid tag.
+func NormalizeLang(tag string) string {
+	tag = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), "_", "-")
+	if !langTag.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
+
+// LangFromHeader returns the most preferred language of Accept-Language header value, empty if not set
+func LangFromHeader(acceptLanguage string) string {
+	type langQ struct {
+		tag string
+		q   float64
+	}
+	langs := []langQ{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		elems := strings.Split(part, ";")
+		tag := NormalizeLang(elems[0])
+		if tag == "" {
+			continue // skip "*" and broken tags
+		}
+		q := 1.0
+		for _, param := range elems[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, langQ{tag: tag, q: q})
+		}
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
+
+// FilterLang returns comments in the language. Language matches the same tag and more specific ones,
+// i.e. "pt" matches "pt" and "pt-br". All comments returned if language not set.
+func FilterLang(comments []store.Comment, lang string) []store.Comment {
+	lang = NormalizeLang(lang)
+	if lang == "" {
+		return comments
+	}
+	res := []store.Comment{}
+	for _, c := range comments {
+		if c.Lang == lang || strings.HasPrefix(c.Lang, lang+"-") {
+			res = append(res, c)
+		}
+	}
+	return res
+}
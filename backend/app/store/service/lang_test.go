@@ -0,0 +1,86 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestNormalizeLang(t *testing.T) {
+	tbl := []struct{ in, out string }{
+		{"en", "en"},
+		{" EN ", "en"},
+		{"pt_BR", "pt-br"},
+		{"zh-Hant-TW", "zh-hant-tw"},
+		{"", ""},
+		{"e", ""},
+		{"english", ""},
+		{"en-", ""},
+		{"<script>", ""},
+		{"*", ""},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.out, NormalizeLang(tt.in), tt.in)
+	}
+}
+
+func TestLangFromHeader(t *testing.T) {
+	tbl := []struct{ in, out string }{
+		{"", ""},
+		{"de", "de"},
+		{"fr-CA,fr;q=0.9,en;q=0.8", "fr-ca"},
+		{"en;q=0.5, ru;q=0.8, *", "ru"},
+		{"*, de;q=0.1", "de"},
+		{"es;q=0, it;q=0.3", "it"},
+		{"ja;q=bad", "ja"},
+		{"*", ""},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.out, LangFromHeader(tt.in), tt.in)
+	}
+}
+
+func TestFilterLang(t *testing.T) {
+	comments := []store.Comment{{ID: "1", Lang: "en"}, {ID: "2", Lang: "en-gb"}, {ID: "3", Lang: "eng"}, {ID: "4"}, {ID: "5", Lang: "de"}}
+	ids := func(cc []store.Comment) (res []string) {
+		for _, c := range cc {
+			res = append(res, c.ID)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, ids(FilterLang(comments, "")), "all if not set")
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, ids(FilterLang(comments, "bad lang")), "all if invalid")
+	assert.Equal(t, []string{"1", "2"}, ids(FilterLang(comments, "EN")))
+	assert.Equal(t, []string{"2"}, ids(FilterLang(comments, "en-GB")))
+	assert.Equal(t, []string{"5"}, ids(FilterLang(comments, "de")))
+	assert.Empty(t, FilterLang(comments, "ja"))
+}
+
+func TestService_CreateWithLang(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	create := func(lang string) store.Comment {
+		id, err := b.Create(store.Comment{Text: "text", Lang: lang, Locator: locator, User: store.User{ID: "user1", Name: "user name"}})
+		require.NoError(t, err)
+		c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+		require.NoError(t, err)
+		return c
+	}
+
+	assert.Equal(t, "pt-br", create("pt_BR").Lang)
+	assert.Equal(t, "", create("").Lang, "no language without default")
+	assert.Equal(t, "", create("not a language").Lang)
+
+	b.DefaultLang = "En"
+	assert.Equal(t, "en", create("").Lang, "default language")
+	assert.Equal(t, "en", create("not a language").Lang, "default language for invalid")
+	assert.Equal(t, "de", create("de").Lang, "declared language kept")
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ErrThreadLocked returned in case of new reply, vote or edit in the thread locked by moderator.
+// Thread is a subtree of comments, the locked comment and all replies to it at any depth. New top-level comments
+// of the post and other threads not affected, read-only mode of the post used to lock the whole post.
+var ErrThreadLocked = fmt.Errorf("thread locked")
+
+// SetLocked locks or unlocks thread started by the comment, the comment itself and all replies to it at any depth
+func (s *DataStore) SetLocked(locator store.Locator, commentID string, status bool) error {
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return err
+	}
+	comment.Locked = status
+	comment.Locator = locator
+	return s.Engine.Update(comment)
+}
+
+// IsThreadLocked checks if the comment or any of its parents locked
+func (s *DataStore) IsThreadLocked(locator store.Locator, commentID string) bool {
+	visited := map[string]bool{} // protects from loops in broken parent references
+	for id := commentID; id != "" && !visited[id]; {
+		visited[id] = true
+		comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+		if err != nil {
+			return false
+		}
+		if comment.Locked {
+			return true
+		}
+		id = comment.ParentID
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_SetLocked(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	reply := store.Comment{ID: "id-3", ParentID: "id-1", Text: "reply", Locator: locator,
+		User: store.User{ID: "user2", Name: "user name 2"}}
+	_, err := b.Create(reply)
+	require.NoError(t, err)
+
+	require.NoError(t, b.SetLocked(locator, "id-1", true))
+	assert.True(t, b.IsThreadLocked(locator, "id-1"))
+	assert.True(t, b.IsThreadLocked(locator, "id-3"), "replies locked with the thread")
+	assert.False(t, b.IsThreadLocked(locator, "id-2"))
+	assert.False(t, b.IsThreadLocked(locator, "id-bad"))
+
+	_, err = b.Create(store.Comment{ID: "id-4", ParentID: "id-3", Text: "reply", Locator: locator,
+		User: store.User{ID: "user2", Name: "user name 2"}})
+	assert.ErrorIs(t, err, ErrThreadLocked)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user1", Val: true})
+	assert.ErrorIs(t, err, ErrThreadLocked)
+	_, err = b.EditComment(locator, "id-3", EditRequest{Orig: "xxx", Text: "xxx", UserID: "user2"})
+	assert.ErrorIs(t, err, ErrThreadLocked)
+
+	_, err = b.Create(store.Comment{ID: "id-5", ParentID: "id-2", Text: "reply", Locator: locator,
+		User: store.User{ID: "user2", Name: "user name 2"}})
+	assert.NoError(t, err, "other threads not affected")
+	_, err = b.Create(store.Comment{ID: "id-6", Text: "top-level", Locator: locator,
+		User: store.User{ID: "user2", Name: "user name 2"}})
+	assert.NoError(t, err, "top-level comments of the post not affected")
+	assert.False(t, b.IsThreadLocked(locator, "id-6"))
+
+	require.NoError(t, b.SetLocked(locator, "id-1", false))
+	_, err = b.Create(store.Comment{ID: "id-4", ParentID: "id-3", Text: "reply", Locator: locator,
+		User: store.User{ID: "user2", Name: "user name 2"}})
+	assert.NoError(t, err, "unlocked thread accepts replies")
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user1", Val: true})
+	assert.NoError(t, err)
+
+	assert.Error(t, b.SetLocked(locator, "id-bad", true))
+}
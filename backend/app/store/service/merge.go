@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// MergeReport is the result of merging duplicate identities of the user
+type MergeReport struct {
+	SiteID    string `json:"site"`
+	FromID    string `json:"from"`
+	ToID      string `json:"to"`
+	Comments  int    `json:"comments"`  // comments reattributed to the surviving identity
+	Votes     int    `json:"votes"`     // votes reattributed to the surviving identity
+	Collapsed int    `json:"collapsed"` // duplicate votes and votes for own comments dropped
+}
+
+// MergeUsers merges identity fromID into toID, like the same person logged in with email and later with oauth.
+// Comments of fromID reattributed to toID, with name and picture of toID if it has comments. Votes of fromID moved
+// to toID, unless toID voted for the same comment or authored it, in this case fromID's vote dropped and the score
+// corrected. Goes through all comments of the site for votes, so should be used for rare requests only.
+func (s *DataStore) MergeUsers(siteID, fromID, toID string) (MergeReport, error) {
+	res := MergeReport{SiteID: siteID, FromID: fromID, ToID: toID}
+	if fromID == "" || toID == "" || fromID == toID {
+		return res, fmt.Errorf("two different user ids required")
+	}
+	reassigner, ok := s.Engine.(engine.Reassigner)
+	if !ok {
+		return res, fmt.Errorf("merge of users not supported by the store engine")
+	}
+
+	newUser, err := s.mergedUser(siteID, fromID, toID)
+	if err != nil {
+		return res, err
+	}
+
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return res, fmt.Errorf("can't get posts for %s: %w", siteID, err)
+	}
+	for _, p := range posts {
+		locator := store.Locator{SiteID: siteID, URL: p.URL}
+		if err = s.mergeVotes(locator, fromID, toID, &res); err != nil {
+			return res, err
+		}
+	}
+
+	req := engine.ReassignRequest{Locator: store.Locator{SiteID: siteID}, UserID: fromID, NewUser: newUser}
+	if res.Comments, err = reassigner.Reassign(req); err != nil {
+		return res, fmt.Errorf("can't reassign comments of %s to %s: %w", fromID, toID, err)
+	}
+	log.Printf("[INFO] user %s merged into %s on %s, %+v", fromID, toID, siteID, res)
+	return res, nil
+}
+
+// mergedUser returns author info of toID from the last comment, or fromID's info with toID id if toID has no comments
+func (s *DataStore) mergedUser(siteID, fromID, toID string) (store.User, error) {
+	for _, id := range []string{toID, fromID} {
+		comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: id, Limit: 1})
+		if err != nil || len(comments) == 0 {
+			continue // bolt engine returns error for user without comments
+		}
+		u := comments[0].User
+		u.ID, u.IP = toID, ""
+		return u, nil
+	}
+	return store.User{}, fmt.Errorf("no comments of %s and %s on %s", fromID, toID, siteID)
+}
+
+// mergeVotes moves votes of fromID to toID for comments of the post. Duplicate votes dropped, as well as votes
+// for own comments made by one identity for comments of another one.
+func (s *DataStore) mergeVotes(locator store.Locator, fromID, toID string, res *MergeReport) error {
+	cLock := s.getScopedLocks(locator.URL) // the same lock as for voting
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+	if err != nil {
+		return fmt.Errorf("can't get comments for %s: %w", locator.URL, err)
+	}
+	for _, c := range comments {
+		_, fromVoted := c.Votes[fromID]
+		_, toVoted := c.Votes[toID]
+		own := c.User.ID == fromID || c.User.ID == toID
+		switch {
+		case fromVoted && (toVoted || own):
+			dropVote(&c, fromID)
+			res.Collapsed++
+		case fromVoted:
+			c.Votes[toID] = c.Votes[fromID]
+			if w, ok := c.VoteWeights[fromID]; ok {
+				c.VoteWeights[toID] = w
+			}
+			delete(c.Votes, fromID)
+			delete(c.VoteWeights, fromID)
+			res.Votes++
+		}
+		if toVoted && own {
+			dropVote(&c, toID)
+			res.Collapsed++
+		}
+		if !fromVoted && !(toVoted && own) {
+			continue
+		}
+		c.Controversy = s.controversy(s.upsAndDowns(c))
+		if err = s.Engine.Update(c); err != nil {
+			return fmt.Errorf("can't update votes of comment %s: %w", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// dropVote removes user's vote from the comment with its weight subtracted from the score
+func dropVote(c *store.Comment, userID string) {
+	weight, ok := c.VoteWeights[userID]
+	if !ok {
+		weight = 1
+	}
+	if c.Votes[userID] {
+		c.Score -= weight
+	} else {
+		c.Score += weight
+	}
+	delete(c.Votes, userID)
+	delete(c.VoteWeights, userID)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_MergeUsers(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	for i, u := range []store.User{{ID: "user2", Name: "user name 2"}, {ID: "user3", Name: "user name 3"}, {ID: "user3", Name: "user name 3"}} {
+		_, err := eng.Create(store.Comment{ID: []string{"id-3", "id-4", "id-5"}[i], Text: "text", Locator: locator, User: u,
+			Timestamp: time.Date(2018, 12, 20, 15, 18, 22+i, 0, time.Local)})
+		require.NoError(t, err)
+	}
+	for _, v := range []VoteReq{
+		{CommentID: "id-1", UserID: "user2", Val: true}, // becomes vote for own comment
+		{CommentID: "id-3", UserID: "user1", Val: true}, // the same
+		{CommentID: "id-4", UserID: "user1", Val: true}, // duplicate vote
+		{CommentID: "id-4", UserID: "user2", Val: true},
+		{CommentID: "id-5", UserID: "user1", Val: false}, // moved to user2
+	} {
+		v.Locator = locator
+		_, err := b.Vote(v)
+		require.NoError(t, err)
+	}
+
+	res, err := b.MergeUsers("radio-t", "user1", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, MergeReport{SiteID: "radio-t", FromID: "user1", ToID: "user2", Comments: 2, Votes: 1, Collapsed: 3}, res)
+
+	comments, err := b.User("radio-t", "user2", 0, 0, store.User{})
+	require.NoError(t, err)
+	require.Len(t, comments, 3, "all comments point to the surviving user")
+	for _, c := range comments {
+		assert.Equal(t, store.User{ID: "user2", Name: "user name 2"}, c.User)
+	}
+	count, err := b.UserCount("radio-t", "user1")
+	assert.Error(t, err, "no comments left")
+	assert.Equal(t, 0, count)
+
+	expected := map[string]struct {
+		votes map[string]bool
+		score int
+	}{
+		"id-1": {votes: nil, score: 0},
+		"id-3": {votes: nil, score: 0},
+		"id-4": {votes: map[string]bool{"user2": true}, score: 1},
+		"id-5": {votes: map[string]bool{"user2": false}, score: -1},
+	}
+	for id, exp := range expected {
+		c, e := eng.Get(engine.GetRequest{Locator: locator, CommentID: id})
+		require.NoError(t, e)
+		assert.Equal(t, exp.votes, c.Votes, id)
+		assert.Equal(t, exp.score, c.Score, id)
+	}
+
+	votes, err := b.UserVotes("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Empty(t, votes)
+
+	_, err = b.MergeUsers("radio-t", "user2", "user2")
+	assert.Error(t, err)
+	_, err = b.MergeUsers("radio-t", "unknown1", "unknown2")
+	assert.EqualError(t, err, "no comments of unknown1 and unknown2 on radio-t")
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// Statuses of comments for moderation listing
+const (
+	StatusPublished = "published" // visible comments, flagged included
+	StatusPending   = "pending"   // held for review and not stored yet, or hidden after reports
+	StatusDeleted   = "deleted"   // deleted by user or admin
+	StatusFlagged   = "flagged"   // reported or with score at or below FlagScore, i.e. downvoted by readers
+)
+
+// ModerationFilter defines comments listed for moderators, zero fields match all comments
+type ModerationFilter struct {
+	Status    string    // one of Status* constants
+	UserID    string    // author of comments
+	From      time.Time // created at or after
+	To        time.Time // created before
+	FlagScore int       // score threshold of flagged comments
+	Limit     int
+	Skip      int
+}
+
+// ModerationList returns comments of the site matching the filter, newest first, and total number of matching comments.
+// Pending comments taken from comments held for review and hidden after reports, all others from comments of all posts of the site.
+func (s *DataStore) ModerationList(siteID string, filter ModerationFilter, user store.User) ([]store.Comment, int, error) {
+	switch filter.Status {
+	case "", StatusPublished, StatusPending, StatusDeleted, StatusFlagged:
+	default:
+		return nil, 0, fmt.Errorf("unknown status %q", filter.Status)
+	}
+
+	comments := []store.Comment{}
+	posts, err := s.List(siteID, 0, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("can't list posts of %s: %w", siteID, err)
+	}
+	for _, post := range posts {
+		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: post.URL}, Sort: "-time"}
+		cc, e := s.Engine.Find(req)
+		if e != nil {
+			return nil, 0, fmt.Errorf("can't get comments of %s: %w", post.URL, e)
+		}
+		comments = append(comments, cc...)
+	}
+	held := map[string]bool{}
+	if filter.Status == "" || filter.Status == StatusPending {
+		for _, c := range s.heldComments(siteID) {
+			held[c.ID] = true
+			comments = append(comments, c)
+		}
+	}
+
+	res := []store.Comment{}
+	for _, c := range comments {
+		if filter.matches(c, held[c.ID]) {
+			res = append(res, c)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Timestamp.After(res[j].Timestamp) })
+
+	total := len(res)
+	if filter.Skip > 0 {
+		if filter.Skip >= len(res) {
+			return []store.Comment{}, total, nil
+		}
+		res = res[filter.Skip:]
+	}
+	if filter.Limit > 0 && filter.Limit < len(res) {
+		res = res[:filter.Limit]
+	}
+	return s.alterComments(res, user), total, nil
+}
+
+// matches checks if comment passes all filter conditions
+func (f ModerationFilter) matches(c store.Comment, held bool) bool {
+	if f.UserID != "" && c.User.ID != f.UserID {
+		return false
+	}
+	if !f.From.IsZero() && c.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !c.Timestamp.Before(f.To) {
+		return false
+	}
+	switch f.Status {
+	case StatusPublished:
+		return !held && !c.Deleted && !c.Hidden
+	case StatusPending:
+		return held || (c.Hidden && !c.Deleted)
+	case StatusDeleted:
+		return !held && c.Deleted
+	case StatusFlagged:
+		return !held && !c.Deleted && (c.Score <= f.FlagScore || len(c.Reports) > 0)
+	}
+	return true
+}
+
+// heldComments returns comments of the site held for review
+func (s *DataStore) heldComments(siteID string) []store.Comment {
+	s.initHeld()
+	res := []store.Comment{}
+	for _, key := range s.held.Keys() {
+		if !strings.HasPrefix(key, siteID+"::") {
+			continue
+		}
+		if c, ok := s.held.Peek(key); ok {
+			res = append(res, c)
+		}
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_ModerationList(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 from user1, posted in 2017
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: &mockReviewer{}, NewUserComments: 1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	user2 := store.User{ID: "user2", Name: "user name 2"}
+
+	for _, c := range []store.Comment{
+		{ID: "del-1", Text: "deleted", Timestamp: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "low-1", Text: "downvoted", Score: -6, Timestamp: time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		c.Locator, c.User = locator, user2
+		_, err := eng.Create(c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(locator, "del-1", store.SoftDelete))
+	_, err := b.Create(store.Comment{ID: "held-1", Text: "held", Locator: locator, User: store.User{ID: "user-new", Name: "new"}})
+	require.ErrorIs(t, err, ErrCommentHeld)
+
+	ids := func(cc []store.Comment) []string {
+		res := []string{}
+		for _, c := range cc {
+			res = append(res, c.ID)
+		}
+		return res
+	}
+	from, to := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tbl := []struct {
+		name   string
+		filter ModerationFilter
+		res    []string
+		total  int
+	}{
+		{name: "all", filter: ModerationFilter{}, res: []string{"held-1", "low-1", "del-1", "id-2", "id-1"}, total: 5},
+		{name: "published", filter: ModerationFilter{Status: StatusPublished}, res: []string{"low-1", "id-2", "id-1"}, total: 3},
+		{name: "pending", filter: ModerationFilter{Status: StatusPending}, res: []string{"held-1"}, total: 1},
+		{name: "deleted", filter: ModerationFilter{Status: StatusDeleted}, res: []string{"del-1"}, total: 1},
+		{name: "flagged", filter: ModerationFilter{Status: StatusFlagged, FlagScore: -5}, res: []string{"low-1"}, total: 1},
+		{name: "flagged with lower threshold", filter: ModerationFilter{Status: StatusFlagged, FlagScore: -10}, res: []string{}},
+		{name: "author", filter: ModerationFilter{UserID: "user1"}, res: []string{"id-2", "id-1"}, total: 2},
+		{name: "date range", filter: ModerationFilter{From: from, To: to}, res: []string{"del-1"}, total: 1},
+		{name: "from date", filter: ModerationFilter{From: from}, res: []string{"held-1", "low-1", "del-1"}, total: 3},
+		{name: "published by author", filter: ModerationFilter{Status: StatusPublished, UserID: "user2"}, res: []string{"low-1"}, total: 1},
+		{name: "deleted by other author", filter: ModerationFilter{Status: StatusDeleted, UserID: "user1"}, res: []string{}},
+		{name: "published by author in range", filter: ModerationFilter{Status: StatusPublished, UserID: "user2", To: to},
+			res: []string{}},
+		{name: "page", filter: ModerationFilter{Limit: 2, Skip: 1}, res: []string{"low-1", "del-1"}, total: 5},
+		{name: "page after the last", filter: ModerationFilter{Limit: 2, Skip: 5}, res: []string{}, total: 5},
+	}
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			res, total, err := b.ModerationList("radio-t", tt.filter, store.User{Admin: true})
+			require.NoError(t, err)
+			assert.Equal(t, tt.res, ids(res))
+			assert.Equal(t, tt.total, total)
+		})
+	}
+
+	_, _, err = b.ModerationList("radio-t", ModerationFilter{Status: "bad"}, store.User{Admin: true})
+	assert.EqualError(t, err, `unknown status "bad"`)
+	assert.Empty(t, b.heldComments("other-site"), "held comments of other sites not listed")
+	_, _, err = b.ModerationList("other-site", ModerationFilter{Status: StatusPending}, store.User{Admin: true})
+	assert.EqualError(t, err, `can't list posts of other-site: site "other-site" not found`, "pending includes stored hidden comments")
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// Moderation actions recorded in ModLog
+const (
+	ModActionDelete     = "delete"      // comment deleted by moderator
+	ModActionRestore    = "restore"     // comment deleted by moderator restored from trash
+	ModActionDeleteUser = "delete_user" // all comments of the user deleted by moderator
+	ModActionBlock      = "block"       // user blocked
+	ModActionUnblock    = "unblock"     // user unblocked
+	ModActionEdit       = "edit"        // comment of other user edited by moderator, with the reason
+	ModActionApprove    = "approve"     // comment held or hidden after reports approved by reviewer
+	ModActionReject     = "reject"      // comment held or hidden after reports rejected by reviewer
+)
+
+// ModReviewer is the moderator of actions made by external review system with review token
+const ModReviewer = "review"
+
+// ModExpiry is the moderator of actions on held comments not reviewed in time
+const ModExpiry = "expiry"
+
+// ModLogEntry is a record of the moderation action
+type ModLogEntry struct {
+	Time      time.Time `json:"time"`
+	SiteID    string    `json:"site"`
+	Action    string    `json:"action"`               // one of ModAction* constants
+	Moderator string    `json:"moderator"`            // id of the moderator, ModReviewer for review system, ModExpiry for expiration
+	UserID    string    `json:"user_id,omitempty"`    // user affected by the action
+	CommentID string    `json:"comment_id,omitempty"` // comment affected by the action
+	URL       string    `json:"url,omitempty"`        // post of the comment
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// ModLog is the audit trail of moderation actions, kept in the file as json record per line.
+// It's append-only, records never changed or removed by remark42.
+type ModLog struct {
+	Path string
+
+	lock sync.Mutex
+}
+
+// Add appends the record to the log, with the current time if not set. Nil ModLog doesn't record anything.
+func (l *ModLog) Add(e ModLogEntry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("can't marshal moderation log record: %w", err)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	fh, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) //nolint:gosec // path set by admin
+	if err != nil {
+		return fmt.Errorf("can't open moderation log %s: %w", l.Path, err)
+	}
+	if _, err = fh.Write(append(data, '\n')); err != nil {
+		_ = fh.Close()
+		return fmt.Errorf("can't write moderation log %s: %w", l.Path, err)
+	}
+	return fh.Close()
+}
+
+// Find returns records of the site made at or after from and before to, oldest first. Zero from or to not limit records.
+func (l *ModLog) Find(siteID string, from, to time.Time) ([]ModLogEntry, error) {
+	res := []ModLogEntry{}
+	if l == nil {
+		return res, nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	fh, err := os.Open(l.Path)
+	if os.IsNotExist(err) {
+		return res, nil // nothing recorded yet
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't open moderation log %s: %w", l.Path, err)
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := ModLogEntry{}
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("[WARN] can't unmarshal moderation log record %q, %v", scanner.Text(), err)
+			continue
+		}
+		if e.SiteID != siteID || (!from.IsZero() && e.Time.Before(from)) || (!to.IsZero() && !e.Time.Before(to)) {
+			continue
+		}
+		res = append(res, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read moderation log %s: %w", l.Path, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.log")
+	l := &ModLog{Path: path}
+
+	res, err := l.Find("site1", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, res, "nothing recorded yet")
+
+	ts := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	require.NoError(t, l.Add(ModLogEntry{Time: ts, SiteID: "site1", Action: ModActionDelete, Moderator: "admin1", CommentID: "c1"}))
+	require.NoError(t, l.Add(ModLogEntry{Time: ts.Add(time.Hour), SiteID: "site2", Action: ModActionBlock, Moderator: "admin1", UserID: "u1"}))
+	require.NoError(t, l.Add(ModLogEntry{Time: ts.Add(2 * time.Hour), SiteID: "site1", Action: ModActionEdit, Moderator: "admin2",
+		UserID: "u2", CommentID: "c2", Reason: "spam link"}))
+	require.NoError(t, l.Add(ModLogEntry{SiteID: "site1", Action: ModActionApprove, Moderator: ModReviewer, CommentID: "c3"}))
+
+	res, err = l.Find("site1", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	assert.Equal(t, ModLogEntry{Time: ts, SiteID: "site1", Action: ModActionDelete, Moderator: "admin1", CommentID: "c1"}, res[0])
+	assert.Equal(t, "spam link", res[1].Reason)
+	assert.WithinDuration(t, time.Now(), res[2].Time, time.Minute, "current time set if not passed")
+
+	res, err = l.Find("site1", ts.Add(time.Hour), ts.Add(3*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, res, 1, "filtered by time, from inclusive, to exclusive")
+	assert.Equal(t, ModActionEdit, res[0].Action)
+	res, err = l.Find("site1", ts, ts.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, ModActionDelete, res[0].Action)
+
+	// records appended to the existing file, broken lines skipped
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = fh.WriteString("not a json\n")
+	require.NoError(t, err)
+	require.NoError(t, fh.Close())
+	l2 := &ModLog{Path: path}
+	require.NoError(t, l2.Add(ModLogEntry{Time: ts.Add(time.Minute), SiteID: "site2", Action: ModActionUnblock, Moderator: "admin1", UserID: "u1"}))
+	res, err = l2.Find("site2", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, ModActionBlock, res[0].Action, "order of recording kept")
+	assert.Equal(t, ModActionUnblock, res[1].Action)
+
+	var nilLog *ModLog
+	assert.NoError(t, nilLog.Add(ModLogEntry{SiteID: "site1"}))
+	res, err = nilLog.Find("site1", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	assert.Error(t, (&ModLog{Path: "/dev/null/bad/path"}).Add(ModLogEntry{SiteID: "site1"}))
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// AllSitesNormalize is the NormalizeSites element enabling normalization of comments on all sites
+const AllSitesNormalize = "*"
+
+// NormalizeText removes invisible zero-width characters and applies NFKC normalization, replacing fullwidth,
+// ligature, math and other compatibility forms of characters by their plain forms. Used to catch restricted words
+// obfuscated with such characters. Zero-width joiner and non-joiner kept between non-letters, as they are parts
+// of emoji sequences.
+func NormalizeText(text string) string {
+	runes := []rune(text)
+	isLetter := func(i int) bool {
+		return i >= 0 && i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsNumber(runes[i]))
+	}
+
+	var b strings.Builder
+	b.Grow(len(text))
+	for i, r := range runes {
+		switch r {
+		case '\u200b', '\u2060', '\ufeff', '\u00ad', '\u180e': // zero-width space, word joiner, bom, soft hyphen, mongolian separator
+			continue
+		case '\u200c', '\u200d': // zero-width non-joiner and joiner
+			if isLetter(i-1) || isLetter(i+1) {
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return norm.NFKC.String(b.String())
+}
+
+// normalize normalizes text of the comment if enabled for the comment's site
+func (s *DataStore) normalize(comment store.Comment) store.Comment {
+	for _, site := range s.NormalizeSites {
+		if site == comment.Locator.SiteID || site == AllSitesNormalize {
+			comment.Text, comment.Orig = NormalizeText(comment.Text), NormalizeText(comment.Orig)
+			return comment
+		}
+	}
+	return comment
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestNormalizeText(t *testing.T) {
+	tbl := []struct {
+		in, out string
+		name    string
+	}{
+		{"", "", "empty"},
+		{"plain text", "plain text", "plain"},
+		{"ba\u200bd w\u200co\u200drd\ufeff", "bad word", "zero-width characters removed"},
+		{"soft\u00adhyphen and w\u2060joiner", "softhyphen and wjoiner", "invisible separators removed"},
+		{"ｆｕｌｌｗｉｄｔｈ ﬁne ①", "fullwidth fine 1", "compatibility forms normalized"},
+		{"\U0001F468\u200d\U0001F4BB coder", "\U0001F468\u200d\U0001F4BB coder", "joiner of emoji sequence kept"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.out, NormalizeText(tt.in))
+		})
+	}
+}
+
+func TestService_CreateNormalized(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		RestrictedWordsMatcher: NewRestrictedWordsMatcher(StaticRestrictedWordsLister{Words: []string{"duck"}})}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	comment := func(id, text string) store.Comment {
+		return store.Comment{ID: id, Text: text, Orig: text, Locator: locator, User: store.User{ID: "user1", Name: "user name"}}
+	}
+
+	_, err := b.Create(comment("id-3", "you du\u200bck"))
+	require.NoError(t, err, "obfuscated word not caught without normalization")
+
+	b.NormalizeSites = []string{"radio-t"}
+	_, err = b.Create(comment("id-4", "you du\u200bck"))
+	assert.ErrorIs(t, err, ErrRestrictedWordsFound, "zero-width obfuscated word caught")
+	_, err = b.Create(comment("id-5", "you ｄｕｃｋ"))
+	assert.ErrorIs(t, err, ErrRestrictedWordsFound, "fullwidth word caught")
+
+	id, err := b.Create(comment("id-6", "good\u200b one ﬁne"))
+	require.NoError(t, err)
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+	require.NoError(t, err)
+	assert.Equal(t, "good one fine", c.Text, "normalized text stored")
+	assert.Equal(t, "good one fine", c.Orig)
+
+	_, err = b.EditComment(locator, "id-6", EditRequest{Text: "bad d\u200duck", Orig: "bad d\u200duck", UserID: "user1"})
+	assert.ErrorIs(t, err, ErrRestrictedWordsFound, "obfuscated word caught on edit")
+
+	b.NormalizeSites = []string{"other-site"}
+	_, err = b.Create(comment("id-7", "you du\u200bck"))
+	assert.NoError(t, err, "normalization enabled for other site only")
+	b.NormalizeSites = []string{AllSitesNormalize}
+	_, err = b.Create(comment("id-8", "you du\u200bck"))
+	assert.ErrorIs(t, err, ErrRestrictedWordsFound)
+}
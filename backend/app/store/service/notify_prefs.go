@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// GetNotifyPrefs gets user notification preferences for the site, store.DefaultNotifyPrefs if not set
+func (s *DataStore) GetNotifyPrefs(siteID, userID string) (store.NotifyPrefs, error) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserNotifyPrefs,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+	})
+	if err != nil {
+		return store.DefaultNotifyPrefs, err
+	}
+	if len(res) != 1 || res[0].NotifyPrefs == "" {
+		return store.DefaultNotifyPrefs, nil
+	}
+	prefs := store.NotifyPrefs{}
+	if err = json.Unmarshal([]byte(res[0].NotifyPrefs), &prefs); err != nil {
+		log.Printf("[WARN] can't unmarshal notification preferences of %s, %v", userID, err)
+		return store.DefaultNotifyPrefs, nil
+	}
+	return prefs, nil
+}
+
+// SetNotifyPrefs sets user notification preferences for the site
+func (s *DataStore) SetNotifyPrefs(siteID, userID string, prefs store.NotifyPrefs) (store.NotifyPrefs, error) {
+	if prefs == store.DefaultNotifyPrefs { // keep user details clean of default values
+		if err := s.DeleteUserDetail(siteID, userID, engine.UserNotifyPrefs); err != nil {
+			return store.NotifyPrefs{}, err
+		}
+		return prefs, nil
+	}
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return store.NotifyPrefs{}, fmt.Errorf("can't marshal notification preferences: %w", err)
+	}
+	_, err = s.Engine.UserDetail(engine.UserDetailRequest{
+		Detail:  engine.UserNotifyPrefs,
+		Locator: store.Locator{SiteID: siteID},
+		UserID:  userID,
+		Update:  string(data),
+	})
+	if err != nil {
+		return store.NotifyPrefs{}, err
+	}
+	return prefs, nil
+}
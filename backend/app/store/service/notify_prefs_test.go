@@ -0,0 +1,61 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_NotifyPrefs(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+
+	prefs, err := b.GetNotifyPrefs("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, store.DefaultNotifyPrefs, prefs, "defaults if not set")
+
+	_, err = b.SetUserEmail("radio-t", "user1", "user1@example.com")
+	require.NoError(t, err)
+	prefs, err = b.SetNotifyPrefs("radio-t", "user1", store.NotifyPrefs{Mentions: true, Digest: true})
+	require.NoError(t, err)
+	assert.Equal(t, store.NotifyPrefs{Mentions: true, Digest: true}, prefs)
+
+	prefs, err = b.GetNotifyPrefs("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, store.NotifyPrefs{Mentions: true, Digest: true}, prefs)
+	prefs, err = b.GetNotifyPrefs("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, store.DefaultNotifyPrefs, prefs, "set for user1 only")
+
+	// defaults not kept in user details
+	prefs, err = b.SetNotifyPrefs("radio-t", "user1", store.DefaultNotifyPrefs)
+	require.NoError(t, err)
+	assert.Equal(t, store.DefaultNotifyPrefs, prefs)
+	details, err := b.Engine.UserDetail(engine.UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1",
+		Detail: engine.UserNotifyPrefs})
+	require.NoError(t, err)
+	assert.Equal(t, []engine.UserDetailEntry{{UserID: "user1"}}, details)
+	email, err := b.GetUserEmail("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "user1@example.com", email, "email kept")
+
+	// broken value ignored
+	_, err = b.Engine.UserDetail(engine.UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1",
+		Detail: engine.UserNotifyPrefs, Update: "{bad"})
+	require.NoError(t, err)
+	prefs, err = b.GetNotifyPrefs("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, store.DefaultNotifyPrefs, prefs)
+
+	_, err = b.GetNotifyPrefs("bad-site", "user1")
+	assert.Error(t, err)
+	_, err = b.SetNotifyPrefs("bad-site", "user1", store.NotifyPrefs{})
+	assert.Error(t, err)
+}
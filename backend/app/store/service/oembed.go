@@ -0,0 +1,225 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-pkgz/lcw/v2"
+	log "github.com/go-pkgz/lgr"
+)
+
+const (
+	oembedCacheMaxRecs   = 1000
+	oembedCacheTTL       = time.Hour
+	oembedMaxBody        = 64 * 1024
+	oembedDefaultLimit   = 3
+	oembedDefaultTimeout = 5 * time.Second
+)
+
+// OEmbedProvider is a whitelisted oEmbed provider, links to its hosts resolved with its endpoint
+type OEmbedProvider struct {
+	Name     string
+	Endpoint string   // oEmbed endpoint url, https only
+	Hosts    []string // hosts of links resolved by the provider
+}
+
+// OEmbedProviders is the list of known providers to pick from by name
+var OEmbedProviders = []OEmbedProvider{
+	{Name: "youtube", Endpoint: "https://www.youtube.com/oembed", Hosts: []string{"youtube.com", "www.youtube.com", "m.youtube.com", "youtu.be"}},
+	{Name: "vimeo", Endpoint: "https://vimeo.com/api/oembed.json", Hosts: []string{"vimeo.com", "www.vimeo.com", "player.vimeo.com"}},
+	{Name: "twitter", Endpoint: "https://publish.twitter.com/oembed", Hosts: []string{"twitter.com", "www.twitter.com", "mobile.twitter.com", "x.com"}},
+}
+
+// OEmbed adds preview cards for links to whitelisted oEmbed providers to rendered comments of enabled sites.
+// Only title, author and thumbnail of the response used, html of the provider is never embedded.
+// Endpoints resolved to internal addresses rejected, responses cached.
+type OEmbed struct {
+	Sites     []string         // sites with previews enabled, disabled for all sites if empty
+	Providers []OEmbedProvider // whitelisted providers
+	Limit     int              // max previews per comment, 3 by default
+
+	client *http.Client
+	cache  lcw.LoadingCache[oembedCard]
+}
+
+// oembedCard is the part of oEmbed response used for the preview, empty if link can't be resolved
+type oembedCard struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// NewOEmbed makes OEmbed for the sites and providers, with timeout of requests to providers
+func NewOEmbed(sites []string, providers []OEmbedProvider, timeout time.Duration) *OEmbed {
+	if timeout <= 0 {
+		timeout = oembedDefaultTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout, Control: rejectInternalAddr}
+	res := OEmbed{
+		Sites:     sites,
+		Providers: providers,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		},
+	}
+	var err error
+	o := lcw.NewOpts[oembedCard]()
+	res.cache, err = lcw.NewExpirableCache(o.TTL(oembedCacheTTL), o.MaxKeys(oembedCacheMaxRecs))
+	if err != nil {
+		log.Printf("[WARN] failed to make cache, caching disabled for oembed, %v", err)
+		res.cache = &lcw.Nop[oembedCard]{}
+	}
+	return &res
+}
+
+// Preview appends preview cards for links to whitelisted providers to the rendered comment html.
+// Links which can't be resolved are ignored, html returned as is for sites without previews.
+func (o *OEmbed) Preview(siteID, commentHTML string) string {
+	if !o.enabled(siteID) {
+		return commentHTML
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
+	if err != nil {
+		return commentHTML
+	}
+
+	limit := o.Limit
+	if limit <= 0 {
+		limit = oembedDefaultLimit
+	}
+	seen := map[string]bool{}
+	cards, count := strings.Builder{}, 0
+	doc.Find("a").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		href, ok := s.Attr("href")
+		if !ok || seen[href] {
+			return true
+		}
+		seen[href] = true
+		p, ok := o.provider(href)
+		if !ok {
+			return true
+		}
+		card, e := o.cache.Get(p.Name+"::"+href, func() (oembedCard, error) { return o.resolve(p, href), nil })
+		if e != nil || card.Title == "" {
+			return true
+		}
+		cards.WriteString(card.html(href))
+		count++
+		return count < limit
+	})
+	if cards.Len() == 0 {
+		return commentHTML
+	}
+	return commentHTML + cards.String()
+}
+
+// resolve gets oEmbed response for the link, returns empty card on any error as the result cached
+func (o *OEmbed) resolve(p OEmbedProvider, link string) oembedCard {
+	u, err := url.Parse(p.Endpoint)
+	if err != nil {
+		log.Printf("[WARN] invalid oembed endpoint %s of %s, %v", p.Endpoint, p.Name, err)
+		return oembedCard{}
+	}
+	q := u.Query()
+	q.Set("url", link)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	resp, err := o.client.Get(u.String())
+	if err != nil {
+		log.Printf("[DEBUG] can't resolve %s with %s, %v", link, p.Name, err)
+		return oembedCard{}
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[DEBUG] can't resolve %s with %s, status %d", link, p.Name, resp.StatusCode)
+		return oembedCard{}
+	}
+	card := oembedCard{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, oembedMaxBody)).Decode(&card); err != nil {
+		log.Printf("[DEBUG] can't decode oembed response for %s from %s, %v", link, p.Name, err)
+		return oembedCard{}
+	}
+	if card.ProviderName == "" {
+		card.ProviderName = p.Name
+	}
+	if t, e := url.Parse(card.ThumbnailURL); e != nil || t.Scheme != "https" {
+		card.ThumbnailURL = "" // not proxied, so only https allowed
+	}
+	return card
+}
+
+// provider returns whitelisted provider for the link by its host
+func (o *OEmbed) provider(link string) (OEmbedProvider, bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return OEmbedProvider{}, false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, p := range o.Providers {
+		for _, h := range p.Hosts {
+			if host == h {
+				return p, true
+			}
+		}
+	}
+	return OEmbedProvider{}, false
+}
+
+func (o *OEmbed) enabled(siteID string) bool {
+	for _, s := range o.Sites {
+		if strings.EqualFold(s, siteID) {
+			return true
+		}
+	}
+	return false
+}
+
+// html makes preview card for the link
+func (c oembedCard) html(link string) string {
+	res := strings.Builder{}
+	res.WriteString(`<div class="oembed-preview"><a href="` + template.HTMLEscapeString(link) + `">`)
+	if c.ThumbnailURL != "" {
+		res.WriteString(`<img src="` + template.HTMLEscapeString(c.ThumbnailURL) + `" alt="" loading="lazy"/>`)
+	}
+	res.WriteString(`<span class="oembed-title">` + template.HTMLEscapeString(c.Title) + `</span></a>`)
+	provider := c.ProviderName
+	if c.AuthorName != "" {
+		provider += " · " + c.AuthorName
+	}
+	res.WriteString(`<span class="oembed-provider">` + template.HTMLEscapeString(provider) + `</span></div>`)
+	return res.String()
+}
+
+// errInternalAddr returned on attempt to connect to loopback, private or otherwise non-public address
+var errInternalAddr = errors.New("internal address not allowed")
+
+// rejectInternalAddr is net.Dialer.Control rejecting connections to non-public addresses,
+// checked after name resolution, so applies to redirects and DNS names pointing to internal addresses as well
+func rejectInternalAddr(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", errInternalAddr, address)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("%w: %s", errInternalAddr, address)
+	}
+	return nil
+}
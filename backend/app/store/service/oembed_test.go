@@ -0,0 +1,117 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOEmbed_Preview(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "json", r.URL.Query().Get("format"))
+		switch r.URL.Query().Get("url") {
+		case "https://youtu.be/123":
+			_, _ = w.Write([]byte(`{"title":"Some <b>video</b>","author_name":"Author","provider_name":"YouTube",
+				"thumbnail_url":"https://i.ytimg.com/vi/123/hq.jpg","html":"<iframe src=\"https://www.youtube.com/embed/123\"></iframe>"}`))
+		case "https://youtu.be/http-thumb":
+			_, _ = w.Write([]byte(`{"title":"Other video","thumbnail_url":"http://i.ytimg.com/vi/456/hq.jpg"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	o := NewOEmbed([]string{"site1"}, []OEmbedProvider{{Name: "youtube", Endpoint: ts.URL, Hosts: []string{"youtu.be"}}}, time.Second)
+	o.client = ts.Client() // test server is on loopback address, rejected by the default client
+
+	html := `<p>see <a href="https://youtu.be/123">video</a> and <a href="https://example.com/123">link</a></p>`
+	res := o.Preview("site1", html)
+	assert.Equal(t, html+`<div class="oembed-preview"><a href="https://youtu.be/123"><img src="https://i.ytimg.com/vi/123/hq.jpg" alt="" loading="lazy"/>`+
+		`<span class="oembed-title">Some &lt;b&gt;video&lt;/b&gt;</span></a><span class="oembed-provider">YouTube · Author</span></div>`, res)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "not whitelisted link ignored")
+
+	// cached
+	assert.Equal(t, res, o.Preview("site1", html))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// not https thumbnail dropped, provider name defaults to provider
+	html = `<p><a href="https://youtu.be/http-thumb">video</a></p>`
+	assert.Equal(t, html+`<div class="oembed-preview"><a href="https://youtu.be/http-thumb"><span class="oembed-title">Other video</span></a>`+
+		`<span class="oembed-provider">youtube</span></div>`, o.Preview("site1", html))
+
+	// unresolved link ignored
+	html = `<p><a href="https://youtu.be/not-found">video</a></p>`
+	assert.Equal(t, html, o.Preview("site1", html))
+
+	// disabled for other sites
+	html = `<p><a href="https://youtu.be/123">video</a></p>`
+	assert.Equal(t, html, o.Preview("site2", html))
+}
+
+func TestOEmbed_PreviewLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"title":"video"}`))
+	}))
+	defer ts.Close()
+
+	o := NewOEmbed([]string{"site1"}, []OEmbedProvider{{Name: "youtube", Endpoint: ts.URL, Hosts: []string{"youtu.be"}}}, time.Second)
+	o.client = ts.Client()
+	o.Limit = 2
+	res := o.Preview("site1", `<a href="https://youtu.be/1">1</a><a href="https://example.com">x</a><a href="https://youtu.be/1">1</a><a href="https://youtu.be/2">2</a><a href="https://youtu.be/3">3</a>`)
+	assert.Contains(t, res, `<div class="oembed-preview"><a href="https://youtu.be/1">`)
+	assert.Contains(t, res, `<div class="oembed-preview"><a href="https://youtu.be/2">`)
+	assert.NotContains(t, res, `<div class="oembed-preview"><a href="https://youtu.be/3">`)
+}
+
+func TestOEmbed_PreviewInternalRejected(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"title":"video"}`))
+	}))
+	defer ts.Close()
+
+	o := NewOEmbed([]string{"site1"}, []OEmbedProvider{{Name: "internal", Endpoint: ts.URL, Hosts: []string{"youtu.be"}}}, time.Second)
+	html := `<p><a href="https://youtu.be/123">video</a></p>`
+	assert.Equal(t, html, o.Preview("site1", html))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls), "internal endpoint not requested")
+
+	_, err := o.client.Get(ts.URL)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errInternalAddr)
+}
+
+func TestOEmbed_rejectInternalAddr(t *testing.T) {
+	tbl := []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:80", false},
+		{"10.1.2.3:443", false},
+		{"192.168.1.1:443", false},
+		{"172.16.0.1:443", false},
+		{"169.254.169.254:80", false},
+		{"0.0.0.0:80", false},
+		{"[::1]:443", false},
+		{"[fd00::1]:443", false},
+		{"[fe80::1]:443", false},
+		{"8.8.8.8:443", true},
+		{"[2001:4860:4860::8888]:443", true},
+	}
+	for _, tt := range tbl {
+		err := rejectInternalAddr("tcp", tt.addr, nil)
+		if tt.ok {
+			assert.NoError(t, err, tt.addr)
+			continue
+		}
+		assert.ErrorIs(t, err, errInternalAddr, tt.addr)
+	}
+	assert.Error(t, rejectInternalAddr("tcp", "bad address", nil))
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ErrQuoteNotFound returned for reply with quote not found in the text of the parent comment
+var ErrQuoteNotFound = errors.New("quote not found in the parent comment")
+
+// checkQuote verifies quote of the reply is an excerpt of the parent comment's text at the reply time,
+// compared as plain text with collapsed spaces. Quote dropped if quoting disabled with MaxQuoteSize 0,
+// and cut to MaxQuoteSize characters if longer.
+func (s *DataStore) checkQuote(comment *store.Comment) error {
+	if s.MaxQuoteSize <= 0 {
+		comment.Quote = ""
+		return nil
+	}
+	quote := collapseSpaces(plainText(comment.Quote))
+	if quote == "" {
+		comment.Quote = ""
+		return nil
+	}
+	if comment.ParentID == "" {
+		return fmt.Errorf("%w: comment is not a reply", ErrQuoteNotFound)
+	}
+	parent, err := s.Engine.Get(engine.GetRequest{Locator: comment.Locator, CommentID: comment.ParentID})
+	if err != nil {
+		return fmt.Errorf("can't get parent comment %s: %w", comment.ParentID, err)
+	}
+	if parent.Deleted || !strings.Contains(collapseSpaces(plainText(parent.Text)), quote) {
+		return ErrQuoteNotFound
+	}
+	if r := []rune(quote); len(r) > s.MaxQuoteSize {
+		quote = strings.TrimSpace(string(r[:s.MaxQuoteSize])) + "..."
+	}
+	comment.Quote = comment.SanitizeText(quote)
+	return nil
+}
+
+// collapseSpaces replaces all sequences of white spaces with a single space
+func collapseSpaces(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_CreateWithQuote(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxQuoteSize: 12}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	reply := func(pid, quote string) (store.Comment, error) {
+		id, err := b.Create(store.Comment{ParentID: pid, Text: "reply", Quote: quote, Locator: locator,
+			User: store.User{ID: "user2", Name: "user name 2"}})
+		if err != nil {
+			return store.Comment{}, err
+		}
+		return b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+	}
+
+	// id-1 text is `some text, <a href="http://radio-t.com">link</a>`
+	c, err := reply("id-1", "text,  link")
+	require.NoError(t, err)
+	assert.Equal(t, "text, link", c.Quote, "genuine quote stored as plain text with collapsed spaces")
+
+	c, err = reply("id-1", "some text, link")
+	require.NoError(t, err)
+	assert.Equal(t, "some text, l...", c.Quote, "long quote cut")
+
+	c, err = reply("id-1", " ")
+	require.NoError(t, err)
+	assert.Empty(t, c.Quote)
+
+	_, err = reply("id-1", "some text2")
+	assert.ErrorIs(t, err, ErrQuoteNotFound, "fabricated quote rejected")
+	_, err = reply("id-1", "<b>radio-t.com</b>")
+	assert.ErrorIs(t, err, ErrQuoteNotFound, "markup of the parent not quoted")
+	_, err = reply("", "text")
+	assert.ErrorIs(t, err, ErrQuoteNotFound, "quote in comment which is not a reply")
+	_, err = reply("no-such-id", "text")
+	assert.ErrorContains(t, err, "can't get parent comment no-such-id")
+
+	b.MaxQuoteSize = 0
+	c, err = reply("id-1", "fabricated")
+	require.NoError(t, err)
+	assert.Empty(t, c.Quote, "quote dropped if quoting disabled")
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"unicode"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ErrInvalidReaction returned on reaction which is not an emoji
+var ErrInvalidReaction = errors.New("invalid reaction")
+
+const (
+	maxReactionSize  = 32 // max size of reaction in bytes, fits emoji with modifiers and zwj sequences
+	maxUserReactions = 5  // max number of different reactions of the user to the comment
+)
+
+// ReactReq is a request to add or remove emoji reaction of the user to the comment
+type ReactReq struct {
+	Locator   store.Locator
+	CommentID string
+	UserID    string
+	Emoji     string
+	Set       bool // add reaction if true, remove otherwise
+}
+
+// React adds or removes emoji reaction of the user to the comment. The user reacts with the same emoji once,
+// with up to maxUserReactions different emoji to the comment.
+func (s *DataStore) React(req ReactReq) (comment store.Comment, err error) {
+	if !validReaction(req.Emoji) {
+		return store.Comment{}, fmt.Errorf("%w %q", ErrInvalidReaction, req.Emoji)
+	}
+
+	cLock := s.getScopedLocks(req.Locator.URL) // get lock for URL scope
+	cLock.Lock()                               // prevents race on reactions
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: req.Locator, CommentID: req.CommentID})
+	if err != nil {
+		return comment, err
+	}
+	if s.IsThreadLocked(req.Locator, req.CommentID) {
+		return comment, fmt.Errorf("can't react to %s: %w", req.CommentID, ErrThreadLocked)
+	}
+	if comment.Deleted {
+		return comment, fmt.Errorf("comment %s deleted", req.CommentID)
+	}
+
+	reacted := comment.Reacted[req.UserID]
+	idx := slices.Index(reacted, req.Emoji)
+	switch {
+	case req.Set && idx >= 0:
+		return comment, fmt.Errorf("user %s already reacted with %s to %s", req.UserID, req.Emoji, req.CommentID)
+	case req.Set && len(reacted) >= maxUserReactions:
+		return comment, fmt.Errorf("user %s reached max %d reactions to %s", req.UserID, maxUserReactions, req.CommentID)
+	case !req.Set && idx < 0:
+		return comment, fmt.Errorf("user %s has no reaction %s to %s", req.UserID, req.Emoji, req.CommentID)
+	}
+
+	if comment.Reactions == nil {
+		comment.Reactions = map[string]int{}
+	}
+	if comment.Reacted == nil {
+		comment.Reacted = map[string][]string{}
+	}
+	if req.Set {
+		reacted = append(reacted, req.Emoji)
+		comment.Reactions[req.Emoji]++
+	} else {
+		reacted = slices.Delete(reacted, idx, idx+1)
+		if comment.Reactions[req.Emoji]--; comment.Reactions[req.Emoji] <= 0 {
+			delete(comment.Reactions, req.Emoji)
+		}
+	}
+	comment.Reacted[req.UserID] = reacted
+	if len(reacted) == 0 {
+		delete(comment.Reacted, req.UserID)
+	}
+	if len(comment.Reactions) == 0 { // not kept empty
+		comment.Reactions, comment.Reacted = nil, nil
+	}
+
+	comment.Locator = req.Locator
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	log.Printf("[DEBUG] reaction %s of %s to %s set=%v", req.Emoji, req.UserID, req.CommentID, req.Set)
+	return s.alterComment(comment, store.User{ID: req.UserID}), nil
+}
+
+// validReaction checks if reaction is a single emoji, possibly with variation selectors, skin tones and zwj sequences
+func validReaction(emoji string) bool {
+	if emoji == "" || len(emoji) > maxReactionSize {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range emoji {
+		switch {
+		case unicode.Is(unicode.So, r):
+			hasSymbol = true
+		case r == '\u200d' || unicode.In(r, unicode.Mn, unicode.Me, unicode.Sk): // zwj, variation selectors, keycaps, skin tones
+		default:
+			return false
+		}
+	}
+	return hasSymbol
+}
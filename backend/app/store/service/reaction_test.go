@@ -0,0 +1,77 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_React(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c, err := b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍", Set: true})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"👍": 1}, c.Reactions)
+	assert.Nil(t, c.Reacted, "users reacted hidden")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍", Set: true})
+	assert.EqualError(t, err, "user user2 already reacted with 👍 to id-1")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user3", Emoji: "👍", Set: true})
+	require.NoError(t, err)
+	c, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍🏽", Set: true})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"👍": 2, "👍🏽": 1}, c.Reactions)
+
+	c, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"👍": 1, "👍🏽": 1}, c.Reactions)
+	c, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍🏽"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"👍": 1}, c.Reactions, "reaction without users removed")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "👍"})
+	assert.EqualError(t, err, "user user2 has no reaction 👍 to id-1")
+
+	stored, err := b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"user3": {"👍"}}, stored.Reacted)
+	assert.Equal(t, 1, stored.ReactionsCount(""))
+
+	for _, emoji := range []string{"🎉", "😄", "🚀", "❤️", "👀"} {
+		_, err = b.React(ReactReq{Locator: locator, CommentID: "id-2", UserID: "user2", Emoji: emoji, Set: true})
+		require.NoError(t, err)
+	}
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-2", UserID: "user2", Emoji: "😕", Set: true})
+	assert.EqualError(t, err, "user user2 reached max 5 reactions to id-2")
+
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Emoji: "abc", Set: true})
+	assert.ErrorIs(t, err, ErrInvalidReaction)
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "bad", UserID: "user2", Emoji: "👍", Set: true})
+	assert.Error(t, err)
+}
+
+func TestService_ValidReaction(t *testing.T) {
+	tbl := []struct {
+		emoji string
+		ok    bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👍🏽", true},
+		{"👨‍👩‍👧", true},
+		{"", false},
+		{"a", false},
+		{"👍 ", false},
+		{"<b>", false},
+		{"👍👍👍👍👍👍👍👍👍", false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.ok, validReaction(tt.emoji), tt.emoji)
+	}
+}
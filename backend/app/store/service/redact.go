@@ -0,0 +1,30 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// Redact clears text of the comment on request of its author. Unlike delete, the comment stays in place
+// with the author shown, so replies keep their context, and it's allowed for comments with replies
+// and after the edit window. Redacted comment can't be edited back.
+func (s *DataStore) Redact(locator store.Locator, commentID, userID string) (store.Comment, error) {
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return comment, err
+	}
+	if comment.User.ID != userID {
+		return comment, fmt.Errorf("can't redact comment %s of other user", commentID)
+	}
+	if comment.Deleted {
+		return comment, fmt.Errorf("can't redact deleted comment %s", commentID)
+	}
+	comment.SetRedacted()
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, fmt.Errorf("can't redact comment %s: %w", commentID, err)
+	}
+	return comment, nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_Redact(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), AdminEdits: true}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Create(store.Comment{ID: "id-3", ParentID: "id-1", Text: "reply", Locator: locator,
+		User: store.User{ID: "user2", Name: "user name 2"}})
+	require.NoError(t, err)
+
+	_, err = b.Redact(locator, "id-1", "user2")
+	assert.EqualError(t, err, "can't redact comment id-1 of other user")
+	_, err = b.Redact(locator, "id-bad", "user1")
+	assert.Error(t, err)
+
+	res, err := b.Redact(locator, "id-1", "user1")
+	require.NoError(t, err)
+	assert.True(t, res.Redacted)
+	assert.Equal(t, "", res.Text)
+
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "", c.Text, "text cleared")
+	assert.Equal(t, "", c.Orig)
+	assert.True(t, c.Redacted)
+	assert.False(t, c.Deleted, "redacted comment not deleted")
+	assert.Equal(t, "user1", c.User.ID, "authorship kept")
+	assert.Equal(t, "user name", c.User.Name)
+
+	reply, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-3"})
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", reply.ParentID, "reply kept in the thread")
+	assert.Equal(t, "reply", reply.Text)
+	comments, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Len(t, comments, 3)
+
+	_, err = b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", UserID: "user1", Admin: true})
+	assert.EqualError(t, err, "redacted comment id-1 can't be edited")
+
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	_, err = b.Redact(locator, "id-2", "user1")
+	assert.EqualError(t, err, "can't redact deleted comment id-2")
+}
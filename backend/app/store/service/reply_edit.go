@@ -0,0 +1,37 @@
+package service
+
+// edit policies of comments with replies, moderators not restricted by them
+const (
+	ReplyEditLock   = "lock"   // comment with replies can't be edited or deleted by the author
+	ReplyEditDelete = "delete" // comment with replies can be deleted by the author, but not edited
+	ReplyEditAllow  = "allow"  // comment with replies edited and deleted as any other comment
+)
+
+// AllSitesReplyEdit is the ReplyEdit key for all sites without own policy
+const AllSitesReplyEdit = "*"
+
+// replyEditPolicy returns edit policy of comments with replies on the site, ReplyEditLock by default
+func (s *DataStore) replyEditPolicy(siteID string) string {
+	policy, ok := s.ReplyEdit[siteID]
+	if !ok {
+		policy = s.ReplyEdit[AllSitesReplyEdit]
+	}
+	switch policy {
+	case ReplyEditDelete, ReplyEditAllow:
+		return policy
+	default:
+		return ReplyEditLock
+	}
+}
+
+// replyLocked checks if the author's change of comment with replies rejected by the site's policy
+func (s *DataStore) replyLocked(siteID string, del bool) bool {
+	switch s.replyEditPolicy(siteID) {
+	case ReplyEditAllow:
+		return false
+	case ReplyEditDelete:
+		return !del
+	default:
+		return true
+	}
+}
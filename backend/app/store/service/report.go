@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ErrNotHidden returned on review of reported comment which is not hidden, i.e. already reviewed
+var ErrNotHidden = errors.New("comment is not hidden")
+
+// Report records report of abusive comment by the user, repeated reports of the same user counted once.
+// Comment hidden pending review once reported by ReportThreshold users and sent to Reviewer, if set.
+func (s *DataStore) Report(locator store.Locator, commentID string, user store.User) (comment store.Comment, err error) {
+	cLock := s.getScopedLocks(locator.URL) // get lock for URL scope
+	cLock.Lock()                           // prevents race on reporting
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return comment, err
+	}
+	if comment.User.ID == user.ID {
+		return comment, fmt.Errorf("user %s can not report his own comment %s", user.ID, commentID)
+	}
+	if comment.Deleted {
+		return comment, fmt.Errorf("comment %s deleted", commentID)
+	}
+	if comment.Reports[user.ID] {
+		return s.alterComment(comment, user), nil // already reported by the user
+	}
+
+	if comment.Reports == nil {
+		comment.Reports = map[string]bool{}
+	}
+	comment.Reports[user.ID] = true
+	hide := s.ReportThreshold > 0 && len(comment.Reports) >= s.ReportThreshold && !comment.Hidden
+	if hide {
+		comment.Hidden = true
+	}
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	log.Printf("[INFO] comment %s reported by %s, %d reports", commentID, user.ID, len(comment.Reports))
+
+	if hide {
+		log.Printf("[INFO] comment %s hidden pending review", commentID)
+		s.sendReported(comment)
+	}
+	return s.alterComment(comment, user), nil
+}
+
+// SetHidden hides comment from readers or shows it back. Reports of the comment reset on show,
+// so it can be hidden again by new reports only.
+func (s *DataStore) SetHidden(locator store.Locator, commentID string, hidden bool) error {
+	cLock := s.getScopedLocks(locator.URL)
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return err
+	}
+	comment.Hidden = hidden
+	if !hidden {
+		comment.Reports = nil
+	}
+	comment.Locator = locator
+	return s.Engine.Update(comment)
+}
+
+// ReviewReported approves or rejects comment hidden after reports, token should match one sent to Reviewer.
+// Approved comment shown back, rejected one deleted.
+func (s *DataStore) ReviewReported(locator store.Locator, commentID, token string, approve bool) (store.Comment, error) {
+	secret, err := s.getSecret(locator.SiteID)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if !hmac.Equal([]byte(token), []byte(reviewToken(locator.SiteID, commentID, secret))) {
+		return store.Comment{}, ErrReviewToken
+	}
+
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if !comment.Hidden {
+		return store.Comment{}, fmt.Errorf("%w: %s", ErrNotHidden, commentID)
+	}
+
+	if !approve {
+		if err = s.Delete(locator, commentID, store.SoftDelete); err != nil {
+			return store.Comment{}, fmt.Errorf("can't delete rejected comment %s: %w", commentID, err)
+		}
+		log.Printf("[INFO] reported comment %s rejected", commentID)
+		return comment, nil
+	}
+	if err = s.SetHidden(locator, commentID, false); err != nil {
+		return store.Comment{}, fmt.Errorf("can't show approved comment %s: %w", commentID, err)
+	}
+	log.Printf("[INFO] reported comment %s approved", commentID)
+	return s.Get(locator, commentID, nonAdminUser)
+}
+
+// sendReported sends hidden comment to Reviewer, comment stays hidden till reviewed by moderators in any case
+func (s *DataStore) sendReported(comment store.Comment) {
+	if s.Reviewer == nil {
+		return
+	}
+	secret, err := s.getSecret(comment.Locator.SiteID)
+	if err != nil {
+		log.Printf("[WARN] can't send reported comment %s for review, %v", comment.ID, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), reviewTimeout)
+	defer cancel()
+	comment.Reports = nil // reporters not exposed to the review system
+	req := ReviewRequest{Comment: comment, Token: reviewToken(comment.Locator.SiteID, comment.ID, secret), Reported: true}
+	if err = s.Reviewer.Review(ctx, req); err != nil {
+		log.Printf("[WARN] can't send reported comment %s for review, %v", comment.ID, err)
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Report(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer, ReportThreshold: 2}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	reader1, reader2 := store.User{ID: "reader1", Name: "reader 1"}, store.User{ID: "reader2", Name: "reader 2"}
+
+	_, err := b.Report(locator, "id-1", store.User{ID: "user1"})
+	assert.EqualError(t, err, "user user1 can not report his own comment id-1")
+	_, err = b.Report(locator, "id-bad", reader1)
+	assert.Error(t, err)
+
+	// repeated reports of the same user counted once
+	for i := 0; i < 3; i++ {
+		c, e := b.Report(locator, "id-1", reader1)
+		require.NoError(t, e)
+		assert.False(t, c.Hidden)
+		assert.Nil(t, c.Reports, "reporters hidden from readers")
+	}
+	c, err := eng.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"reader1": true}, c.Reports)
+	assert.False(t, c.Hidden)
+	assert.Empty(t, reviewer.reqs(), "not sent for review below threshold")
+
+	// comment hidden and sent for review at threshold
+	c, err = b.Report(locator, "id-1", reader2)
+	require.NoError(t, err)
+	assert.True(t, c.Hidden)
+	reqs := reviewer.reqs()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "id-1", reqs[0].Comment.ID)
+	assert.True(t, reqs[0].Reported)
+	assert.Nil(t, reqs[0].Comment.Reports)
+	assert.NotEmpty(t, reqs[0].Token)
+
+	// the third report doesn't send it again
+	_, err = b.Report(locator, "id-1", store.User{ID: "reader3"})
+	require.NoError(t, err)
+	assert.Len(t, reviewer.reqs(), 1)
+
+	// text of hidden comment shown to admins and the author only
+	c, err = b.Get(locator, "id-1", reader1)
+	require.NoError(t, err)
+	assert.True(t, c.Hidden)
+	assert.Empty(t, c.Text)
+	c, err = b.Get(locator, "id-1", store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, c.Text)
+	c, err = b.Get(locator, "id-1", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, c.Text)
+	assert.Len(t, c.Reports, 3, "reporters visible to admins")
+
+	// listed as pending and flagged for moderators
+	res, total, err := b.ModerationList("radio-t", ModerationFilter{Status: StatusPending}, store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, "id-1", res[0].ID)
+	_, total, err = b.ModerationList("radio-t", ModerationFilter{Status: StatusPublished}, store.User{Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total, "only id-2 published")
+
+	// shown back by admin, reports reset
+	require.NoError(t, b.SetHidden(locator, "id-1", false))
+	c, err = eng.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.False(t, c.Hidden)
+	assert.Nil(t, c.Reports)
+}
+
+func TestService_ReportWithoutThreshold(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	for _, id := range []string{"reader1", "reader2", "reader3"} {
+		c, err := b.Report(locator, "id-2", store.User{ID: id})
+		require.NoError(t, err)
+		assert.False(t, c.Hidden, "never hidden with zero threshold")
+	}
+	assert.Empty(t, reviewer.reqs())
+	res, total, err := b.ModerationList("radio-t", ModerationFilter{Status: StatusFlagged, FlagScore: -5}, store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, "id-2", res[0].ID, "reported comment flagged")
+}
+
+func TestService_ReviewReported(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer, ReportThreshold: 1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.ReviewReported(locator, "id-1", "bad", true)
+	assert.True(t, errors.Is(err, ErrReviewToken))
+
+	for _, id := range []string{"id-1", "id-2"} {
+		_, err = b.Report(locator, id, store.User{ID: "reader1"})
+		require.NoError(t, err)
+	}
+	reqs := reviewer.reqs()
+	require.Len(t, reqs, 2)
+
+	// approved comment shown back
+	c, err := b.ReviewReported(locator, "id-1", reqs[0].Token, true)
+	require.NoError(t, err)
+	assert.False(t, c.Hidden)
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, c.Text)
+	_, err = b.ReviewReported(locator, "id-1", reqs[0].Token, true)
+	assert.True(t, errors.Is(err, ErrNotHidden), "already reviewed")
+
+	// rejected comment deleted
+	_, err = b.ReviewReported(locator, "id-2", reqs[0].Token, false)
+	assert.True(t, errors.Is(err, ErrReviewToken), "token of other comment")
+	_, err = b.ReviewReported(locator, "id-2", reqs[1].Token, false)
+	require.NoError(t, err)
+	c, err = eng.Get(getReq(locator, "id-2"))
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+	assert.False(t, c.Hidden)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// RetentionReport is the result of retention policy applied to the site
+type RetentionReport struct {
+	SiteID     string `json:"site"`
+	DryRun     bool   `json:"dry_run"`
+	Purged     int    `json:"purged"`     // comments purged, tombstones included
+	Tombstones int    `json:"tombstones"` // purged comments kept in threads as tombstones for their replies
+	Anonymized int    `json:"anonymized"` // comments with user info replaced by "deleted user"
+}
+
+// Retain applies retention policy to comments of the site older than maxAge. With store.HardDelete mode text
+// and user info of such comments removed, purged parents of remaining replies shown in threads as tombstones.
+// With store.AnonymizeDelete mode text kept and user info replaced. Nothing changed in dry run, only reported.
+func (s *DataStore) Retain(siteID string, maxAge time.Duration, mode store.DeleteMode, dryRun bool) (RetentionReport, error) {
+	res := RetentionReport{SiteID: siteID, DryRun: dryRun}
+	if maxAge <= 0 {
+		return res, fmt.Errorf("invalid retention age %v", maxAge)
+	}
+	if mode != store.HardDelete && mode != store.AnonymizeDelete {
+		return res, fmt.Errorf("unsupported retention mode %d", mode)
+	}
+
+	posts, err := s.List(siteID, 0, 0)
+	if err != nil {
+		return res, fmt.Errorf("can't list posts of %s: %w", siteID, err)
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, post := range posts {
+		locator := store.Locator{SiteID: siteID, URL: post.URL}
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+		if e != nil {
+			return res, fmt.Errorf("can't get comments of %s: %w", post.URL, e)
+		}
+
+		expired := map[string]bool{}
+		for _, c := range comments {
+			if c.Deleted || !c.Timestamp.Before(cutoff) || (mode == store.AnonymizeDelete && c.User.ID == "deleted") {
+				continue
+			}
+			expired[c.ID] = true
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		replies := map[string][]store.Comment{}
+		for _, c := range comments {
+			replies[c.ParentID] = append(replies[c.ParentID], c)
+		}
+		for _, c := range comments {
+			if !expired[c.ID] {
+				continue
+			}
+			if !dryRun {
+				req := engine.DeleteRequest{Locator: locator, CommentID: c.ID, DeleteMode: mode}
+				if e = s.Engine.Delete(req); e != nil {
+					return res, fmt.Errorf("can't apply retention to comment %s: %w", c.ID, e)
+				}
+				if s.repliesCache.LoadingCache != nil {
+					s.repliesCache.Delete(c.ParentID)
+				}
+			}
+			if mode == store.AnonymizeDelete {
+				res.Anonymized++
+				continue
+			}
+			res.Purged++
+			if hasRemainingReplies(c.ID, replies, expired) {
+				res.Tombstones++
+			}
+		}
+	}
+
+	if !dryRun && res.Purged+res.Anonymized > 0 {
+		if e := s.AdminStore.OnEvent(siteID, admin.EvDelete); e != nil {
+			log.Printf("[WARN] failed to send delete event, %s", e)
+		}
+	}
+	return res, nil
+}
+
+// hasRemainingReplies checks if any reply in the subtree of the comment stays visible after retention applied
+func hasRemainingReplies(id string, replies map[string][]store.Comment, expired map[string]bool) bool {
+	for _, r := range replies[id] {
+		if (!r.Deleted && !expired[r.ID]) || hasRemainingReplies(r.ID, replies, expired) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retention applies retention policy to the site periodically
+type Retention struct {
+	DataStore *DataStore
+	SiteID    string
+	MaxAge    time.Duration
+	Mode      store.DeleteMode
+	DryRun    bool
+	Interval  time.Duration
+	OnChange  func(siteID string) // optional, called if comments changed, i.e. to flush caches
+}
+
+// Do runs retention for the site on start and with Interval after, till context canceled
+func (r Retention) Do(ctx context.Context) {
+	log.Printf("[INFO] activate retention for %s, max age %v, mode %d, dry run %v", r.SiteID, r.MaxAge, r.Mode, r.DryRun)
+	tick := time.NewTicker(r.Interval)
+	defer tick.Stop()
+
+	for {
+		r.apply()
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			log.Printf("[WARN] terminated retention for %s", r.SiteID)
+			return
+		}
+	}
+}
+
+func (r Retention) apply() {
+	report, err := r.DataStore.Retain(r.SiteID, r.MaxAge, r.Mode, r.DryRun)
+	if err != nil {
+		log.Printf("[WARN] retention for %s failed, %v", r.SiteID, err)
+		return
+	}
+	if report.DryRun {
+		log.Printf("[INFO] retention dry run for %s, would purge %d (%d tombstones), anonymize %d",
+			r.SiteID, report.Purged, report.Tombstones, report.Anonymized)
+		return
+	}
+	log.Printf("[INFO] retention for %s, purged %d (%d tombstones), anonymized %d",
+		r.SiteID, report.Purged, report.Tombstones, report.Anonymized)
+	if r.OnChange != nil && report.Purged+report.Anonymized > 0 {
+		r.OnChange(r.SiteID)
+	}
+}
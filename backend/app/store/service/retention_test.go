@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_RetainPurge(t *testing.T) {
+	eng, teardown := prepRetentionEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com/retention", SiteID: "radio-t"}
+
+	res, err := b.Retain("radio-t", 30*24*time.Hour, store.HardDelete, false)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionReport{SiteID: "radio-t", Purged: 6, Tombstones: 1}, res, "id-1, id-2 of the other post purged too")
+
+	// old comments purged, recent ones kept
+	for _, id := range []string{"old-1", "old-2", "old-3", "old-4"} {
+		c, e := eng.Get(engine.GetRequest{Locator: locator, CommentID: id})
+		require.NoError(t, e)
+		assert.True(t, c.Deleted, id)
+		assert.Empty(t, c.Text, id)
+		assert.Equal(t, "deleted", c.User.ID, id)
+	}
+	for _, id := range []string{"new-1", "new-2"} {
+		c, e := eng.Get(engine.GetRequest{Locator: locator, CommentID: id})
+		require.NoError(t, e)
+		assert.False(t, c.Deleted, id)
+	}
+
+	// thread integrity kept with the tombstone of purged parent
+	comments, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	tree := MakeTree(comments, "time")
+	require.Len(t, tree.Nodes, 2)
+	assert.Equal(t, "old-2", tree.Nodes[0].Comment.ID)
+	assert.True(t, tree.Nodes[0].Comment.Deleted)
+	require.Len(t, tree.Nodes[0].Replies, 1)
+	assert.Equal(t, "new-1", tree.Nodes[0].Replies[0].Comment.ID)
+	assert.Equal(t, "new-2", tree.Nodes[1].Comment.ID)
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// nothing left to purge
+	res, err = b.Retain("radio-t", 30*24*time.Hour, store.HardDelete, false)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionReport{SiteID: "radio-t"}, res)
+}
+
+func TestService_RetainAnonymize(t *testing.T) {
+	eng, teardown := prepRetentionEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com/retention", SiteID: "radio-t"}
+
+	res, err := b.Retain("radio-t", 30*24*time.Hour, store.AnonymizeDelete, false)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionReport{SiteID: "radio-t", Anonymized: 6}, res)
+
+	c, err := eng.Get(engine.GetRequest{Locator: locator, CommentID: "old-1"})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+	assert.Equal(t, "old text 1", c.Text)
+	assert.Equal(t, store.User{Name: "deleted user", ID: "deleted"}, c.User)
+	c, err = eng.Get(engine.GetRequest{Locator: locator, CommentID: "new-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "user2", c.User.ID)
+
+	// anonymized comments not counted again
+	res, err = b.Retain("radio-t", 30*24*time.Hour, store.AnonymizeDelete, false)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionReport{SiteID: "radio-t"}, res)
+}
+
+func TestService_RetainDryRun(t *testing.T) {
+	eng, teardown := prepRetentionEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com/retention", SiteID: "radio-t"}
+
+	res, err := b.Retain("radio-t", 30*24*time.Hour, store.HardDelete, true)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionReport{SiteID: "radio-t", DryRun: true, Purged: 6, Tombstones: 1}, res)
+	res, err = b.Retain("radio-t", 30*24*time.Hour, store.AnonymizeDelete, true)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionReport{SiteID: "radio-t", DryRun: true, Anonymized: 6}, res)
+	res, err = b.Retain("radio-t", 10*365*24*time.Hour, store.HardDelete, true)
+	require.NoError(t, err)
+	assert.Equal(t, RetentionReport{SiteID: "radio-t", DryRun: true}, res, "nothing older than 10 years")
+
+	// nothing changed
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 6, count)
+	c, err := eng.Get(engine.GetRequest{Locator: locator, CommentID: "old-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "user1", c.User.ID)
+
+	_, err = b.Retain("radio-t", 0, store.HardDelete, true)
+	assert.EqualError(t, err, "invalid retention age 0s")
+	_, err = b.Retain("radio-t", time.Hour, store.SoftDelete, true)
+	assert.EqualError(t, err, "unsupported retention mode 0")
+}
+
+func TestRetention_Do(t *testing.T) {
+	eng, teardown := prepRetentionEngine(t)
+	defer teardown()
+	var changed int32
+	r := Retention{DataStore: &DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}, SiteID: "radio-t",
+		MaxAge: 30 * 24 * time.Hour, Mode: store.HardDelete, Interval: 10 * time.Millisecond,
+		OnChange: func(siteID string) { assert.Equal(t, "radio-t", siteID); atomic.AddInt32(&changed, 1) }}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r.Do(ctx)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&changed), "changed on the first run only")
+	count, err := r.DataStore.Count(store.Locator{URL: "https://radio-t.com/retention", SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+// prepRetentionEngine makes engine with two old comments of prepStoreEngine and a post with
+// old-1 (old), old-2 (old) <- new-1, old-3 (old) <- old-4 (old), new-2
+func prepRetentionEngine(t *testing.T) (engine.Interface, func()) {
+	eng, teardown := prepStoreEngine(t)
+	locator := store.Locator{URL: "https://radio-t.com/retention", SiteID: "radio-t"}
+	old, recent := time.Now().AddDate(0, -2, 0), time.Now().Add(-time.Hour)
+	for _, c := range []store.Comment{
+		{ID: "old-1", Text: "old text 1", Timestamp: old},
+		{ID: "old-2", Text: "old text 2", Timestamp: old.Add(time.Second)},
+		{ID: "new-1", ParentID: "old-2", Text: "new text 1", Timestamp: recent, User: store.User{ID: "user2", Name: "user name 2"}},
+		{ID: "old-3", Text: "old text 3", Timestamp: old.Add(2 * time.Second)},
+		{ID: "old-4", ParentID: "old-3", Text: "old text 4", Timestamp: old.Add(3 * time.Second)},
+		{ID: "new-2", Text: "new text 2", Timestamp: recent.Add(time.Second)},
+	} {
+		c.Locator = locator
+		if c.User.ID == "" {
+			c.User = store.User{ID: "user1", Name: "user name"}
+		}
+		_, err := eng.Create(c)
+		require.NoError(t, err)
+	}
+	return eng, teardown
+}
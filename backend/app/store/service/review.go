@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	lcw "github.com/go-pkgz/lcw/v2"
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// ErrCommentHeld returned by Create for comment held for review, the comment is saved if approved by reviewer
+var ErrCommentHeld = errors.New("comment held for review")
+
+// ErrHeldNotFound returned on review of comment which is not held, already reviewed or expired
+var ErrHeldNotFound = errors.New("no comment held for review")
+
+// ErrReviewToken returned on review with token not matching the held comment
+var ErrReviewToken = errors.New("invalid review token")
+
+// ReviewRequest is a comment held for review with the token needed to approve or reject it
+type ReviewRequest struct {
+	Comment      store.Comment
+	Token        string
+	Reported     bool      // stored comment hidden after reports of readers, not the held one
+	Expires      time.Time // held comment published or rejected by ExpiryAction at this time if not reviewed
+	ExpiryAction string    // ExpiryPublish or ExpiryReject, set for held comment
+	Reminder     bool      // held comment sent again before its expiration
+}
+
+// Reviewer sends comments held by restricted words check or pre-moderation of new users for review by moderators
+type Reviewer interface {
+	Review(ctx context.Context, req ReviewRequest) error
+}
+
+const (
+	defaultReviewTTL = 72 * time.Hour
+	maxHeldComments  = 10000
+	reviewTimeout    = 10 * time.Second
+)
+
+// hold keeps the comment for review and sends it to Reviewer. The comment is not stored and not listed
+// until approved with ReviewHeld. Held comments kept in memory and expire after ReviewTTL
+func (s *DataStore) hold(comment store.Comment) (string, error) {
+	secret, err := s.getSecret(comment.Locator.SiteID)
+	if err != nil {
+		return "", err
+	}
+
+	s.initHeld()
+	key := heldKey(comment.Locator.SiteID, comment.ID)
+	expires := time.Now().Add(s.reviewTTL()) // not after expiration of the held cache entry
+	s.held.Lock()
+	s.held.Delete(key)
+	_, err = s.held.Get(key, func() (store.Comment, error) { return comment, nil })
+	s.held.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("can't hold comment %s: %w", comment.ID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reviewTimeout)
+	defer cancel()
+	req := ReviewRequest{Comment: comment, Token: reviewToken(comment.Locator.SiteID, comment.ID, secret),
+		Expires: expires, ExpiryAction: s.expiryAction(comment.Locator.SiteID)}
+	if err = s.Reviewer.Review(ctx, req); err != nil {
+		s.held.Lock()
+		s.held.Delete(key)
+		s.held.Unlock()
+		return "", fmt.Errorf("can't send comment %s for review: %w", comment.ID, err)
+	}
+	s.trackHeld(key, comment, req.Expires)
+	log.Printf("[INFO] comment %s from %s held for review", comment.ID, comment.User.ID)
+	return comment.ID, ErrCommentHeld
+}
+
+// ReviewHeld approves or rejects comment held for review, token should match one sent to Reviewer.
+// Approved comment saved and returned, rejected one dropped.
+func (s *DataStore) ReviewHeld(siteID, commentID, token string, approve bool) (store.Comment, error) {
+	secret, err := s.getSecret(siteID)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if !hmac.Equal([]byte(token), []byte(reviewToken(siteID, commentID, secret))) {
+		return store.Comment{}, ErrReviewToken
+	}
+
+	s.initHeld()
+	key := heldKey(siteID, commentID)
+	s.held.Lock()
+	comment, ok := s.held.Peek(key)
+	s.held.Delete(key)
+	s.held.Unlock()
+	if !ok {
+		return store.Comment{}, fmt.Errorf("%w: %s", ErrHeldNotFound, commentID)
+	}
+
+	if !approve {
+		log.Printf("[INFO] held comment %s rejected", commentID)
+		return comment, nil
+	}
+	if _, err = s.store(comment); err != nil {
+		return store.Comment{}, fmt.Errorf("can't save approved comment %s: %w", commentID, err)
+	}
+	log.Printf("[INFO] held comment %s approved", commentID)
+	return s.Get(comment.Locator, commentID, nonAdminUser)
+}
+
+func (s *DataStore) initHeld() {
+	s.held.once.Do(func() {
+		o := lcw.NewOpts[store.Comment]()
+		s.held.LoadingCache, _ = lcw.NewExpirableCache[store.Comment](o.TTL(s.reviewTTL()), o.MaxKeys(maxHeldComments),
+			o.OnEvicted(s.onHeldEvicted))
+	})
+}
+
+// reviewTTL returns how long comments held for review, ReviewTTL if set
+func (s *DataStore) reviewTTL() time.Duration {
+	if s.ReviewTTL > 0 {
+		return s.ReviewTTL
+	}
+	return defaultReviewTTL
+}
+
+func heldKey(siteID, commentID string) string {
+	return siteID + "::" + commentID
+}
+
+// reviewToken signs the held comment, the same token required to approve or reject it
+func reviewToken(siteID, commentID, secret string) string {
+	return store.HashValue("review::"+siteID+"::"+commentID, secret)
+}
+
+// ReviewWebhook sends comments held for review to an external system with POST request.
+// The request has the comment and the callback url to approve or reject it with POST request,
+// adding action=approve or action=reject to the query
+type ReviewWebhook struct {
+	URL       string // webhook url
+	RemarkURL string // root url of remark42, used for callback url
+	Client    *http.Client
+}
+
+// Review posts comment to the webhook, the comment is sent as is, with hashed ip
+func (w *ReviewWebhook) Review(ctx context.Context, req ReviewRequest) error {
+	callback := fmt.Sprintf("%s/api/v1/review?site=%s&id=%s&tkn=%s", w.RemarkURL, url.QueryEscape(req.Comment.Locator.SiteID),
+		url.QueryEscape(req.Comment.ID), url.QueryEscape(req.Token))
+	if req.Reported { // reported comment is stored, post url needed to find it
+		callback += "&url=" + url.QueryEscape(req.Comment.Locator.URL)
+	}
+	var expires *time.Time // not set for reported comments
+	if !req.Expires.IsZero() {
+		expires = &req.Expires
+	}
+	body, err := json.Marshal(struct {
+		Comment      store.Comment `json:"comment"`
+		Callback     string        `json:"callback"`
+		Reported     bool          `json:"reported,omitempty"`
+		Expires      *time.Time    `json:"expires,omitempty"`
+		ExpiryAction string        `json:"expiry_action,omitempty"`
+		Reminder     bool          `json:"reminder,omitempty"`
+	}{Comment: req.Comment, Callback: callback, Reported: req.Reported, Expires: expires, ExpiryAction: req.ExpiryAction,
+		Reminder: req.Reminder})
+	if err != nil {
+		return fmt.Errorf("can't marshal comment: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("can't make review request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("review request failed: %w", err)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("review webhook returned status %d: %s", resp.StatusCode, string(msg))
+	}
+	return nil
+}
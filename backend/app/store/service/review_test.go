@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_HoldForReview(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer,
+		RestrictedWordsMatcher: NewRestrictedWordsMatcher(StaticRestrictedWordsLister{Words: []string{"duck"}})}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	comment := func(id, text string) store.Comment {
+		return store.Comment{ID: id, Text: text, Locator: locator, User: store.User{ID: "user2", Name: "user name 2", IP: "127.0.0.1"}}
+	}
+
+	// comment without restricted words stored as usual
+	id, err := b.Create(comment("c-0", "clean text"))
+	require.NoError(t, err)
+	assert.Equal(t, "c-0", id)
+	assert.Empty(t, reviewer.reqs())
+
+	// comment with restricted words held, not stored and sent for review
+	for _, id := range []string{"c-1", "c-2"} {
+		res, e := b.Create(comment(id, "what the duck"))
+		assert.ErrorIs(t, e, ErrCommentHeld)
+		assert.Equal(t, id, res)
+		_, e = b.Get(locator, id, store.User{})
+		assert.Error(t, e, "held comment %s not stored", id)
+	}
+	comments, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Len(t, comments, 3, "only stored comments listed")
+	reqs := reviewer.reqs()
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "c-1", reqs[0].Comment.ID)
+	assert.Equal(t, "what the duck", reqs[0].Comment.Text)
+	assert.NotEqual(t, "127.0.0.1", reqs[0].Comment.User.IP, "ip hashed")
+	assert.NotEmpty(t, reqs[0].Token)
+	assert.NotEqual(t, reqs[0].Token, reqs[1].Token)
+
+	// wrong token rejected, comment still held
+	_, err = b.ReviewHeld("radio-t", "c-1", reqs[1].Token, true)
+	assert.ErrorIs(t, err, ErrReviewToken)
+	_, err = b.ReviewHeld("radio-t", "c-1", "", false)
+	assert.ErrorIs(t, err, ErrReviewToken)
+
+	// approved comment stored
+	approved, err := b.ReviewHeld("radio-t", "c-1", reqs[0].Token, true)
+	require.NoError(t, err)
+	assert.Equal(t, "c-1", approved.ID)
+	assert.Equal(t, "what the duck", approved.Text)
+	stored, err := b.Get(locator, "c-1", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "what the duck", stored.Text)
+
+	// rejected comment dropped
+	rejected, err := b.ReviewHeld("radio-t", "c-2", reqs[1].Token, false)
+	require.NoError(t, err)
+	assert.Equal(t, "c-2", rejected.ID)
+	_, err = b.Get(locator, "c-2", store.User{})
+	assert.Error(t, err)
+
+	// comment can be reviewed once
+	_, err = b.ReviewHeld("radio-t", "c-1", reqs[0].Token, false)
+	assert.ErrorIs(t, err, ErrHeldNotFound)
+	_, err = b.ReviewHeld("radio-t", "c-2", reqs[1].Token, true)
+	assert.ErrorIs(t, err, ErrHeldNotFound)
+	comments, err = b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Len(t, comments, 4)
+
+	// comment not held if review request failed
+	reviewer.err = errors.New("webhook failed")
+	_, err = b.Create(comment("c-3", "duck again"))
+	assert.EqualError(t, err, "can't send comment c-3 for review: webhook failed")
+	_, err = b.ReviewHeld("radio-t", "c-3", reviewToken("radio-t", "c-3", "secret 123"), true)
+	assert.ErrorIs(t, err, ErrHeldNotFound)
+}
+
+func TestService_HeldExpired(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer, ReviewTTL: 50 * time.Millisecond,
+		RestrictedWordsMatcher: NewRestrictedWordsMatcher(StaticRestrictedWordsLister{Words: []string{"duck"}})}
+
+	_, err := b.Create(store.Comment{ID: "c-1", Text: "duck", Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User: store.User{ID: "user2"}})
+	require.ErrorIs(t, err, ErrCommentHeld)
+	time.Sleep(100 * time.Millisecond)
+	_, err = b.ReviewHeld("radio-t", "c-1", reviewer.reqs()[0].Token, true)
+	assert.ErrorIs(t, err, ErrHeldNotFound)
+}
+
+func TestService_HoldNewUsers(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // user1 has two comments stored already
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer, NewUserComments: 2}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// returning user published immediately
+	id, err := b.Create(store.Comment{ID: "c-1", Text: "text", Locator: locator, User: store.User{ID: "user1", Name: "user name"}})
+	require.NoError(t, err)
+	assert.Equal(t, "c-1", id)
+	assert.Empty(t, reviewer.reqs())
+
+	// new user held
+	newUser := store.User{ID: "user-new", Name: "new user"}
+	_, err = b.Create(store.Comment{ID: "c-2", Text: "text", Locator: locator, User: newUser})
+	assert.ErrorIs(t, err, ErrCommentHeld)
+	reqs := reviewer.reqs()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "c-2", reqs[0].Comment.ID)
+	_, err = b.Get(locator, "c-2", store.User{})
+	assert.Error(t, err)
+
+	// new admin published immediately
+	_, err = b.Create(store.Comment{ID: "c-3", Text: "text", Locator: locator, User: store.User{ID: "admin-new", Admin: true}})
+	require.NoError(t, err)
+
+	// new user published after the first comments approved
+	for i, id := range []string{"c-2", "c-4"} {
+		if i > 0 {
+			_, err = b.Create(store.Comment{ID: id, Text: "text", Locator: locator, User: newUser})
+			require.ErrorIs(t, err, ErrCommentHeld)
+		}
+		reqs = reviewer.reqs()
+		_, err = b.ReviewHeld("radio-t", id, reqs[len(reqs)-1].Token, true)
+		require.NoError(t, err)
+	}
+	_, err = b.Create(store.Comment{ID: "c-5", Text: "text", Locator: locator, User: newUser})
+	require.NoError(t, err)
+	assert.Len(t, reviewer.reqs(), 2)
+
+	// not held without reviewer
+	b.Reviewer = nil
+	_, err = b.Create(store.Comment{ID: "c-6", Text: "text", Locator: locator, User: store.User{ID: "user-other"}})
+	require.NoError(t, err)
+}
+
+func TestReviewWebhook_Review(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var err error
+		body, err = io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("bad gateway"))
+		}
+	}))
+	defer ts.Close()
+
+	wh := ReviewWebhook{URL: ts.URL, RemarkURL: "https://remark42.example.com"}
+	req := ReviewRequest{Comment: store.Comment{ID: "c-1", Text: "some text",
+		Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}}, Token: "tkn+1"}
+	require.NoError(t, wh.Review(context.Background(), req))
+
+	res := struct {
+		Comment  store.Comment `json:"comment"`
+		Callback string        `json:"callback"`
+	}{}
+	require.NoError(t, json.Unmarshal(body, &res))
+	assert.Equal(t, "c-1", res.Comment.ID)
+	assert.Equal(t, "some text", res.Comment.Text)
+	cb, err := url.Parse(res.Callback)
+	require.NoError(t, err)
+	assert.Equal(t, "remark42.example.com", cb.Host)
+	assert.Equal(t, "/api/v1/review", cb.Path)
+	assert.Equal(t, url.Values{"site": {"radio-t"}, "id": {"c-1"}, "tkn": {"tkn+1"}}, cb.Query())
+
+	req.Reported = true
+	require.NoError(t, wh.Review(context.Background(), req))
+	require.NoError(t, json.Unmarshal(body, &res))
+	cb, err = url.Parse(res.Callback)
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"site": {"radio-t"}, "id": {"c-1"}, "tkn": {"tkn+1"}, "url": {"https://radio-t.com"}}, cb.Query(),
+		"url of stored reported comment added")
+	req.Reported = false
+
+	expires := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	req.Expires, req.ExpiryAction, req.Reminder = expires, ExpiryPublish, true
+	require.NoError(t, wh.Review(context.Background(), req))
+	held := struct {
+		Expires      time.Time `json:"expires"`
+		ExpiryAction string    `json:"expiry_action"`
+		Reminder     bool      `json:"reminder"`
+	}{}
+	require.NoError(t, json.Unmarshal(body, &held))
+	assert.Equal(t, expires, held.Expires)
+	assert.Equal(t, ExpiryPublish, held.ExpiryAction)
+	assert.True(t, held.Reminder)
+	req.Expires, req.ExpiryAction, req.Reminder = time.Time{}, "", false
+
+	wh.URL = ts.URL + "?fail=1"
+	assert.EqualError(t, wh.Review(context.Background(), req), "review webhook returned status 502: bad gateway")
+}
+
+type mockReviewer struct {
+	lock     sync.Mutex
+	requests []ReviewRequest
+	err      error
+}
+
+func (m *mockReviewer) Review(_ context.Context, req ReviewRequest) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.requests = append(m.requests, req)
+	return nil
+}
+
+func (m *mockReviewer) reqs() []ReviewRequest {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]ReviewRequest{}, m.requests...)
+}
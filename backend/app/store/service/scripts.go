@@ -0,0 +1,56 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// AllSitesAllowedScripts is the key of AllowedScripts with scripts allowed for sites without their own list
+const AllSitesAllowedScripts = "*"
+
+// ErrScriptNotAllowed returned for comment with letters of the script not allowed for the site
+var ErrScriptNotAllowed = errors.New("comment contains characters of not allowed script")
+
+// checkScripts checks if all characters of the rendered comment belong to the scripts allowed for the site.
+// Characters common to all scripts, like digits, punctuation, symbols and emoji, as well as combining
+// characters and zero-width joiners, are always allowed. Html tags and links are not checked.
+func (s *DataStore) checkScripts(siteID, commentHTML string) error {
+	allowed, ok := s.AllowedScripts[siteID]
+	if !ok {
+		allowed, ok = s.AllowedScripts[AllSitesAllowedScripts]
+	}
+	if !ok || len(allowed) == 0 {
+		return nil
+	}
+	tables := make([]*unicode.RangeTable, 0, len(allowed)+2)
+	tables = append(tables, unicode.Common, unicode.Inherited)
+	for _, name := range allowed {
+		if t, found := unicode.Scripts[name]; found {
+			tables = append(tables, t)
+		}
+	}
+
+	for _, word := range strings.Fields(plainText(commentHTML)) {
+		if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
+			continue // links shown as is, can't be in the script of the site
+		}
+		for _, r := range word {
+			if !unicode.In(r, tables...) {
+				return fmt.Errorf("%w: %s", ErrScriptNotAllowed, scriptName(r))
+			}
+		}
+	}
+	return nil
+}
+
+// scriptName returns name of the script of the character, empty if not found
+func scriptName(r rune) string {
+	for name, t := range unicode.Scripts {
+		if unicode.Is(t, r) {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_ValidateRenderedScripts(t *testing.T) {
+	b := DataStore{}
+	assert.NoError(t, b.ValidateRendered(&store.Comment{Text: "<p>你好 привет</p>", Locator: store.Locator{SiteID: "radio-t"}}),
+		"all scripts allowed by default")
+
+	b.AllowedScripts = map[string][]string{AllSitesAllowedScripts: {"Latin"}, "radio-t": {"Latin", "Cyrillic"}, "open": {}}
+	tbl := []struct {
+		site, text string
+		err        string
+	}{
+		{"radio-t", "<p>Hello, привет!</p>", ""},
+		{"radio-t", "<p>Ünïcödé café, naïve</p>", ""},
+		{"radio-t", "<p>emoji 👍🏽 👨‍👩‍👧 ❤️ and 123 … «quotes» — $5 ½</p>", ""},
+		{"radio-t", `<p>see <a href="https://example.com/中文">https://example.com/中文</a></p>`, ""},
+		{"radio-t", "<p><strong>ｆｕｌｌｗｉｄｔｈ</strong></p>", ""},
+		{"radio-t", "<p>cheap watches 便宜的手表</p>", "comment contains characters of not allowed script: Han"},
+		{"radio-t", "<p>γεια</p>", "comment contains characters of not allowed script: Greek"},
+		{"other", "<p>привет</p>", "comment contains characters of not allowed script: Cyrillic"},
+		{"other", "<p>hello 😀</p>", ""},
+		{"open", "<p>你好</p>", ""},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.text, func(t *testing.T) {
+			err := b.ValidateRendered(&store.Comment{Text: tt.text, Locator: store.Locator{SiteID: tt.site}})
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrScriptNotAllowed)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// SearchRequest defines search of comments of the site by words of comment text
+type SearchRequest struct {
+	SiteID string
+	Query  string // words to find, comment matches if its text contains all of them, case-insensitive
+	UserID string // author of comments, all authors if not set
+	Limit  int    // max number of results, all if 0
+
+	ExcludeURLs []string // url prefixes of pages excluded from search
+	MaxScanned  int      // max number of comments scanned, from the latest commented posts, searchMaxScanned if 0
+}
+
+// SearchResult is the comment found by search, with a fragment of its text around the match
+type SearchResult struct {
+	store.Comment
+	Snippet string `json:"snippet"` // html escaped text fragment with matched words wrapped in <mark> and </mark>
+}
+
+const (
+	searchMaxScanned    = 10000 // default max number of comments scanned by search
+	searchSnippetLen    = 200   // max size of snippet in runes
+	searchSnippetBefore = 60    // runes of text kept before the first match
+	markOpen, markClose = "<mark>", "</mark>"
+)
+
+// Search returns comments of the site matching the request, newest first. Deleted and hidden comments skipped.
+// Goes through comments of the site post by post with Engine.Find, so works the same way for all engines,
+// starting from the latest commented post and stopping once MaxScanned comments scanned.
+func (s *DataStore) Search(req SearchRequest, user store.User) ([]SearchResult, error) {
+	words := strings.Fields(strings.ToLower(req.Query))
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	posts, err := s.List(req.SiteID, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("can't list posts of %s: %w", req.SiteID, err)
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].LastTS.After(posts[j].LastTS) })
+	maxScanned := req.MaxScanned
+	if maxScanned <= 0 {
+		maxScanned = searchMaxScanned
+	}
+
+	comments := []store.Comment{}
+	texts := map[string]string{} // plain text of matched comments, by id
+	scanned := 0
+	for _, post := range posts {
+		if scanned >= maxScanned {
+			break
+		}
+		if excludedURL(post.URL, req.ExcludeURLs) {
+			continue
+		}
+		cc, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: req.SiteID, URL: post.URL}, Sort: "-time"})
+		if e != nil {
+			return nil, fmt.Errorf("can't get comments of %s: %w", post.URL, e)
+		}
+		if len(cc) > maxScanned-scanned { // newest comments of the post kept
+			cc = cc[:maxScanned-scanned]
+		}
+		scanned += len(cc)
+		for _, c := range cc {
+			if c.Deleted || c.Hidden || (req.UserID != "" && c.User.ID != req.UserID) {
+				continue
+			}
+			text := plainText(c.Text)
+			if !containsAll(strings.ToLower(text), words) {
+				continue
+			}
+			texts[c.ID] = text
+			comments = append(comments, c)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].Timestamp.After(comments[j].Timestamp) })
+	if req.Limit > 0 && req.Limit < len(comments) {
+		comments = comments[:req.Limit]
+	}
+
+	res := make([]SearchResult, 0, len(comments))
+	for _, c := range s.alterComments(comments, user) {
+		res = append(res, SearchResult{Comment: c, Snippet: highlight(texts[c.ID], words)})
+	}
+	return res, nil
+}
+
+func excludedURL(url string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(url, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// plainText returns text of html comment without tags and entities
+func plainText(text string) string {
+	clean := bluemonday.StrictPolicy().Sanitize(strings.ReplaceAll(text, "\n", " "))
+	return strings.TrimSpace(html.UnescapeString(clean))
+}
+
+func containsAll(text string, words []string) bool {
+	for _, w := range words {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight returns html escaped fragment of the text around the first match of any of the words, lower case,
+// with all the words matched in the fragment wrapped in mark tags
+func highlight(text string, words []string) string {
+	orig := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(orig) { // lower case of some runes has different size, can't map positions
+		orig = lower
+	}
+	wordRunes := make([][]rune, 0, len(words))
+	for _, w := range words {
+		wordRunes = append(wordRunes, []rune(w))
+	}
+
+	// matchAt returns size of the longest word matched at position i, 0 if none
+	matchAt := func(i int) int {
+		size := 0
+		for _, w := range wordRunes {
+			if len(w) > size && i+len(w) <= len(lower) && string(lower[i:i+len(w)]) == string(w) {
+				size = len(w)
+			}
+		}
+		return size
+	}
+
+	start := 0
+	for i := range lower {
+		if matchAt(i) > 0 {
+			start = max(0, i-searchSnippetBefore)
+			break
+		}
+	}
+	end := min(len(orig), start+searchSnippetLen)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	for i := start; i < end; {
+		if size := matchAt(i); size > 0 {
+			b.WriteString(markOpen + html.EscapeString(string(orig[i:i+size])) + markClose)
+			i += size
+			continue
+		}
+		b.WriteString(html.EscapeString(string(orig[i])))
+		i++
+	}
+	if end < len(orig) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
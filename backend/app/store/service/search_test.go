@@ -0,0 +1,87 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Search(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+
+	ts := time.Date(2018, 12, 20, 15, 18, 22, 0, time.Local)
+	for i, c := range []store.Comment{
+		{ID: "s-1", Text: "<p>Golang <b>generics</b> are fine &amp; useful</p>", User: store.User{ID: "user2", Name: "user2"}},
+		{ID: "s-2", Text: "<p>nothing about generics for GoLang</p>", User: store.User{ID: "user3", Name: "user3"},
+			Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}},
+		{ID: "s-3", Text: "<p>golang only</p>", User: store.User{ID: "user2", Name: "user2"}},
+		{ID: "s-4", Text: "<p>golang generics, deleted</p>", User: store.User{ID: "user2", Name: "user2"}, Deleted: true},
+	} {
+		if c.Locator.URL == "" {
+			c.Locator = store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+		}
+		c.Timestamp = ts.Add(time.Duration(i) * time.Minute)
+		_, err := eng.Create(c)
+		require.NoError(t, err)
+	}
+
+	res, err := b.Search(SearchRequest{SiteID: "radio-t", Query: "generics golang"}, store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 2, "all words should match, deleted skipped")
+	assert.Equal(t, "s-2", res[0].ID, "newest first")
+	assert.Equal(t, "nothing about <mark>generics</mark> for <mark>GoLang</mark>", res[0].Snippet)
+	assert.Equal(t, "s-1", res[1].ID)
+	assert.Equal(t, "<mark>Golang</mark> <mark>generics</mark> are fine &amp; useful", res[1].Snippet)
+	assert.Empty(t, res[1].User.IP)
+
+	res, err = b.Search(SearchRequest{SiteID: "radio-t", Query: "GOLANG", UserID: "user2"}, store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 2, "comments of the author only")
+	assert.Equal(t, "s-3", res[0].ID)
+	assert.Equal(t, "s-1", res[1].ID)
+
+	res, err = b.Search(SearchRequest{SiteID: "radio-t", Query: "golang", Limit: 1}, store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "s-3", res[0].ID)
+
+	res, err = b.Search(SearchRequest{SiteID: "radio-t", Query: "generics golang", ExcludeURLs: []string{"https://radio-t.com/2"}}, store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 1, "comments of excluded page skipped")
+	assert.Equal(t, "s-1", res[0].ID)
+
+	res, err = b.Search(SearchRequest{SiteID: "radio-t", Query: "golang", MaxScanned: 3}, store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 2, "only newest comments of the latest commented post scanned")
+	assert.Equal(t, "s-3", res[0].ID)
+	assert.Equal(t, "s-1", res[1].ID)
+
+	res, err = b.Search(SearchRequest{SiteID: "radio-t", Query: "golang", UserID: "user1"}, store.User{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	_, err = b.Search(SearchRequest{SiteID: "radio-t", Query: "  "}, store.User{})
+	assert.EqualError(t, err, "empty search query")
+}
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("filler ", 30) + "needle <tag> and more text after the needle" + strings.Repeat(" tail", 40)
+
+	res := highlight(long, []string{"needle"})
+	assert.Contains(t, res, "<mark>needle</mark> &lt;tag&gt; and more text after the <mark>needle</mark>")
+	assert.Regexp(t, `^\.\.\.`, res, "text before the match cut")
+	assert.Regexp(t, `\.\.\.$`, res, "text after the snippet cut")
+
+	assert.Equal(t, "Привет, <mark>МИР</mark>", highlight("Привет, МИР", []string{"мир"}))
+	assert.Equal(t, "<mark>abc</mark>", highlight("abc", []string{"ab", "abc"}), "longest match wins")
+	assert.Equal(t, "no match", highlight("no match", []string{"xyz"}))
+}
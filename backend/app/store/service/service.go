@@ -3,6 +3,7 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"slices"
@@ -10,13 +11,16 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/go-pkgz/lcw/v2"
 	log "github.com/go-pkgz/lgr"
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
 	bf "github.com/russross/blackfriday/v2"
 
+	"github.com/umputun/remark42/backend/app/metrics"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/engine"
@@ -30,6 +34,8 @@ type DataStore struct {
 	AdminStore          admin.Store
 	MinCommentSize      int
 	MaxCommentSize      int
+	MaxRenderedSize     int // max size of rendered comment html, in characters, unlimited if 0
+	MaxQuoteSize        int // max size of parent's excerpt quoted in reply, in characters, quotes dropped if 0
 	MaxVotes            int
 	RestrictSameIPVotes struct {
 		Enabled  bool
@@ -39,7 +45,32 @@ type DataStore struct {
 	TitleExtractor         *TitleExtractor
 	RestrictedWordsMatcher *RestrictedWordsMatcher
 	ImageService           *image.Service
-	AdminEdits             bool // allow admin unlimited edits
+	AdminEdits             bool                // allow admin unlimited edits
+	EditHistory            int                 // max number of prior versions kept on edit, 0 disables history
+	DraftTTL               time.Duration       // how long comment drafts kept, 24h by default
+	TrashTTL               time.Duration       // how long comments deleted by moderators kept in trash for restore, 0 disables trash
+	ReserveAnonNames       bool                // anonymous name reserved by the first anonymous user posted with it
+	VoteWeights            VoteWeights         // optional weights of votes by voter's role and reputation, 1 per vote if not set
+	Reviewer               Reviewer            // comments with restricted words held and sent for review instead of rejection, if set
+	ReviewTTL              time.Duration       // how long comments held for review, 72h by default
+	ReviewExpiry           map[string]string   // action on held comments not reviewed within ReviewTTL per site, AllSitesReviewExpiry key for all other sites, ExpiryReject if not set
+	ReplyEdit              map[string]string   // edit policy of comments with replies per site, AllSitesReplyEdit key for all other sites, ReplyEditLock if not set
+	ReviewReminder         time.Duration       // held comment sent to Reviewer again this long before its expiration, 0 disables
+	NewUserComments        int                 // first comments of new users held for review if Reviewer set, 0 disables
+	Trust                  TrustLevels         // requirements of trusted users, not held for review with restricted words
+	MaxImages              map[string]int      // max images per comment per site, AllSitesMaxImages key for all other sites, unlimited if not set
+	ReportThreshold        int                 // number of users reported the comment to hide it pending review, 0 disables hiding
+	CollapseScore          int                 // comments with score below it marked collapsed in responses, 0 disables collapsing
+	ThreadCooldown         time.Duration       // min time between comments of the user to the same post, 0 disables
+	DefaultLang            string              // language of comments created without language, not set if empty
+	NormalizeSites         []string            // sites with text of comments normalized on create and edit, AllSitesNormalize for all
+	BlockedNames           map[string][]string // names not allowed for users per site, AllSitesBlockedNames key for all sites
+	AllowedScripts         map[string][]string // unicode scripts allowed in comments per site, AllSitesAllowedScripts key for all other sites
+	Metrics                *metrics.Metrics    // optional, counts comments created, deleted and votes
+	IPMode                 store.IPMode        // how client IP anonymized before persistence, hashed by default
+	IPSalt                 string              // optional salt of IP hash combined with site id, site's secret used if not set
+
+	HeldExpired func(comment store.Comment, published bool) // called after held comment published or rejected on expiration, optional
 
 	// granular locks
 	scopedLocks struct {
@@ -52,6 +83,32 @@ type DataStore struct {
 		lcw.LoadingCache[struct{}]
 		once sync.Once
 	}
+
+	drafts struct {
+		lcw.LoadingCache[Draft]
+		sync.Mutex
+		once sync.Once
+	}
+
+	held struct {
+		lcw.LoadingCache[store.Comment]
+		sync.Mutex
+		once   sync.Once
+		expiry struct {
+			sync.Mutex
+			deadlines map[string]heldExpiry // expiration of held comments, by held key
+		}
+	}
+
+	cooldowns struct {
+		sync.Mutex
+		last map[string]time.Time // time of the last comment by site, post url and user
+	}
+
+	anonNames struct {
+		sync.Mutex
+		sites map[string]map[string]string // user id by lower-cased reserved anonymous name, by site
+	}
 }
 
 // UserMetaData keeps info about user flags and details
@@ -79,17 +136,87 @@ const UnlimitedVotes = -1
 
 var nonAdminUser = store.User{}
 
+// ErrEditReasonRequired returned on moderator's edit of other user's comment without reason
+var ErrEditReasonRequired = fmt.Errorf("edit reason required for moderator edit")
+
 // ErrRestrictedWordsFound returned in case comment text contains restricted words
 var ErrRestrictedWordsFound = fmt.Errorf("comment contains restricted words")
 
-// Create prepares comment and forward to Interface.Create
+// ErrAnonNameReserved returned in case anonymous user name reserved by another anonymous user of the site
+var ErrAnonNameReserved = fmt.Errorf("anonymous name reserved by another user")
+
+// ErrCommentTooShort returned in case comment text is empty or smaller than min allowed size
+var ErrCommentTooShort = fmt.Errorf("comment text too short")
+
+// ErrCommentTooLong returned in case comment text or rendered comment exceeds max allowed size
+var ErrCommentTooLong = fmt.Errorf("comment text too long")
+
+// ErrTooManyImages returned in case rendered comment has more images than allowed for the site
+var ErrTooManyImages = fmt.Errorf("too many images in comment")
+
+// ErrVotingFrozen returned in case of vote while voting frozen for the site
+var ErrVotingFrozen = fmt.Errorf("voting frozen")
+
+// AllSitesMaxImages is the MaxImages key for the limit of images on all sites without own limit
+const AllSitesMaxImages = "*"
+
+// sizeError keeps detailed validation message and matches ErrCommentTooShort, ErrCommentTooLong or ErrTooManyImages with errors.Is
+type sizeError struct {
+	msg string
+	err error
+}
+
+func (e sizeError) Error() string { return e.msg }
+func (e sizeError) Unwrap() error { return e.err }
+
+// Create prepares comment and forward to Interface.Create. Comment with restricted words rejected,
+// or held for review with ErrCommentHeld returned if Reviewer set. With Reviewer, comments routed by TrustLevel
+// of the user: comments of new users held for review, comments of trusted users never held.
+// Comment posted within ThreadCooldown after the previous comment of the user to the same post rejected with CooldownError.
+// Reply quoting text not found in the parent comment rejected with ErrQuoteNotFound.
 func (s *DataStore) Create(comment store.Comment) (commentID string, err error) {
+	if comment.ParentID != "" && s.IsThreadLocked(comment.Locator, comment.ParentID) {
+		return "", ErrThreadLocked
+	}
+	cancelCooldown, err := s.startCooldown(comment)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil && !errors.Is(err, ErrCommentHeld) {
+			cancelCooldown()
+		}
+	}()
 	if comment, err = s.prepareNewComment(comment); err != nil {
 		return "", fmt.Errorf("failed to prepare comment: %w", err)
 	}
+	if err = s.checkQuote(&comment); err != nil {
+		return "", err
+	}
 
+	trust := TrustBasic
+	if s.Reviewer != nil {
+		trust = s.TrustLevel(comment.Locator.SiteID, comment.User)
+	}
 	if s.RestrictedWordsMatcher != nil && s.RestrictedWordsMatcher.Match(comment.Locator.SiteID, comment.Text) {
-		return "", ErrRestrictedWordsFound
+		if s.Reviewer == nil {
+			return "", ErrRestrictedWordsFound
+		}
+		if trust != TrustTrusted {
+			return s.hold(comment)
+		}
+		log.Printf("[INFO] comment %s of trusted user %s with restricted words not held", comment.ID, comment.User.ID)
+	}
+	if trust == TrustNew {
+		return s.hold(comment)
+	}
+	return s.store(comment)
+}
+
+// store saves prepared comment with Interface.Create
+func (s *DataStore) store(comment store.Comment) (commentID string, err error) {
+	if err = s.reserveAnonName(comment.Locator.SiteID, comment.User); err != nil {
+		return "", err
 	}
 
 	func() { // keep input title and set to extracted if missing
@@ -104,7 +231,11 @@ func (s *DataStore) Create(comment store.Comment) (commentID string, err error)
 		comment.PostTitle = title
 	}()
 
+	st := time.Now()
 	commentID, err = s.Engine.Create(comment)
+	if err == nil {
+		s.Metrics.CommentCreated(time.Since(st))
+	}
 	s.submitImages(comment)
 
 	if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvCreate); e != nil {
@@ -145,6 +276,12 @@ func (s *DataStore) FindSince(locator store.Locator, sortMethod string, user sto
 		comments = engine.SortComments(comments, sortMethod)
 	}
 
+	// pinned comments of the post listed first regardless of the sort order
+	if locator.URL != "" {
+		setDepth(comments)
+		comments = pinnedFirst(comments)
+	}
+
 	return comments, nil
 }
 
@@ -157,6 +294,35 @@ func (s *DataStore) Get(locator store.Locator, commentID string, user store.User
 	return s.alterComment(c, user), nil
 }
 
+// GetWithAncestors gets comment by id with the chain of its ancestors, from the root comment to the direct parent.
+// Ancestors altered for the user the same way as the comment, deleted ones returned as tombstones,
+// including ancestors missing in the store, so the chain is never broken.
+func (s *DataStore) GetWithAncestors(locator store.Locator, commentID string, user store.User) (comment store.Comment, ancestors []store.Comment, err error) {
+	if comment, err = s.Get(locator, commentID, user); err != nil {
+		return store.Comment{}, nil, err
+	}
+
+	ancestors = []store.Comment{}
+	visited := map[string]bool{comment.ID: true} // protects from loops in broken parent references
+	for parentID := comment.ParentID; parentID != "" && !visited[parentID]; {
+		visited[parentID] = true
+		parent, e := s.Get(locator, parentID, user)
+		if e != nil {
+			log.Printf("[DEBUG] can't get parent %s of %s, tombstone used, %v", parentID, commentID, e)
+			ancestors = append(ancestors, store.Comment{ID: parentID, Locator: locator, Deleted: true})
+			break
+		}
+		ancestors = append(ancestors, parent)
+		parentID = parent.ParentID
+	}
+	slices.Reverse(ancestors)
+	for i := range ancestors {
+		ancestors[i].Depth = i
+	}
+	comment.Depth = len(ancestors)
+	return comment, ancestors, nil
+}
+
 // Put updates comment, mutable parts only
 func (s *DataStore) Put(locator store.Locator, comment store.Comment) error {
 	comment.Locator = locator
@@ -229,8 +395,73 @@ func (s *DataStore) SetUserTelegram(siteID, userID, value string) (string, error
 	return "", nil
 }
 
+// reserveAnonName reserves name of anonymous user for the site on the first use, and rejects the name
+// with ErrAnonNameReserved if it was reserved by another anonymous user before. Names compared case-insensitive.
+// Anonymous user id made from name and client address, so the same client keeps using its name.
+// Does nothing for regular users and if ReserveAnonNames disabled.
+func (s *DataStore) reserveAnonName(siteID string, user store.User) error {
+	if !s.ReserveAnonNames || !strings.HasPrefix(user.ID, "anonymous_") {
+		return nil
+	}
+	name := strings.TrimSpace(user.Name)
+
+	s.anonNames.Lock()
+	defer s.anonNames.Unlock()
+
+	names, err := s.reservedAnonNames(siteID)
+	if err != nil {
+		return err
+	}
+	if userID, ok := names[strings.ToLower(name)]; ok {
+		if userID != user.ID {
+			return ErrAnonNameReserved
+		}
+		return nil // already reserved by this user
+	}
+
+	_, err = s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.UserAnonName, Locator: store.Locator{SiteID: siteID},
+		UserID: user.ID, Update: name})
+	if err != nil {
+		return fmt.Errorf("can't reserve anonymous name for %s: %w", user.ID, err)
+	}
+	names[strings.ToLower(name)] = user.ID
+	log.Printf("[DEBUG] anonymous name %q reserved for %s on %s", name, user.ID, siteID)
+	return nil
+}
+
+// reservedAnonNames returns index of reserved anonymous names of the site, loads it from user details
+// on the first use. Should be called with anonNames lock held.
+func (s *DataStore) reservedAnonNames(siteID string) (map[string]string, error) {
+	if names, ok := s.anonNames.sites[siteID]; ok {
+		return names, nil
+	}
+	details, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.AllUserDetails, Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, fmt.Errorf("can't get reserved anonymous names for %s: %w", siteID, err)
+	}
+	names := map[string]string{}
+	for _, d := range details {
+		if d.AnonName != "" {
+			names[strings.ToLower(d.AnonName)] = d.UserID
+		}
+	}
+	if s.anonNames.sites == nil {
+		s.anonNames.sites = map[string]map[string]string{}
+	}
+	s.anonNames.sites[siteID] = names
+	return names, nil
+}
+
+// resetAnonNames drops index of reserved anonymous names of the site, to be reloaded after user details removal
+func (s *DataStore) resetAnonNames(siteID string) {
+	s.anonNames.Lock()
+	delete(s.anonNames.sites, siteID)
+	s.anonNames.Unlock()
+}
+
 // DeleteUserDetail deletes user detail
 func (s *DataStore) DeleteUserDetail(siteID, userID string, detail engine.UserDetail) error {
+	defer s.resetAnonNames(siteID)
 	return s.Engine.Delete(engine.DeleteRequest{
 		Locator:    store.Locator{SiteID: siteID},
 		UserID:     userID,
@@ -304,29 +535,45 @@ func (s *DataStore) prepareNewComment(comment store.Comment) (store.Comment, err
 	if comment.Votes == nil {
 		comment.Votes = make(map[string]bool)
 	}
+	comment = s.normalize(comment)
 	comment.Sanitize() // clear potentially dangerous js from all parts of comment
+	// trust level of the user set in token changes over time, not stored
+	comment.User.Trust = ""
+	if comment.Lang = NormalizeLang(comment.Lang); comment.Lang == "" {
+		comment.Lang = NormalizeLang(s.DefaultLang)
+	}
 
-	secret, err := s.getSecret(comment.Locator.SiteID)
+	ip, err := s.anonymizeIP(comment.Locator.SiteID, comment.User.IP)
 	if err != nil {
-		return store.Comment{}, fmt.Errorf("can't get secret for site %s: %w", comment.Locator.SiteID, err)
+		return store.Comment{}, err
 	}
-	comment.User.HashIP(secret) // replace ip by hash
+	comment.User.IP = ip // replace ip by its anonymized form
 	return comment, nil
 }
 
 // DeleteAll removes all data from site
 func (s *DataStore) DeleteAll(siteID string) error {
+	defer s.resetAnonNames(siteID)
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}}
 	return s.Engine.Delete(req)
 }
 
 // SetPin pin/un-pin comment as special
 func (s *DataStore) SetPin(locator store.Locator, commentID string, status bool) error {
+	return s.setPin(locator, commentID, status, false)
+}
+
+// SetModPin pin/un-pin comment for moderators only, readers see the comment in its regular order
+func (s *DataStore) SetModPin(locator store.Locator, commentID string, status bool) error {
+	return s.setPin(locator, commentID, status, status)
+}
+
+func (s *DataStore) setPin(locator store.Locator, commentID string, status, modOnly bool) error {
 	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
 	if err != nil {
 		return err
 	}
-	comment.Pin = status
+	comment.Pin, comment.PinModOnly = status, modOnly
 	comment.Locator = locator
 	return s.Engine.Update(comment)
 }
@@ -340,18 +587,38 @@ type VoteReq struct {
 	Val       bool
 }
 
+// VoteWeights defines weights of votes by voter's role and reputation. Reputation is the total score of user's
+// comments on the site. Vote counts with the biggest applicable weight, or 1 if none applicable.
+type VoteWeights struct {
+	Admin           int // weight of site admin's vote
+	Verified        int // weight of verified user's vote
+	Reputation      int // weight of vote of user with reputation of ReputationScore or more
+	ReputationScore int // min reputation for Reputation weight, should be positive
+}
+
+// maxReputationComments limits number of user's last comments used to calculate reputation
+const maxReputationComments = 1000
+
 // Vote for comment by id and locator
 func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 	cLock := s.getScopedLocks(req.Locator.URL) // get lock for URL scope
 	cLock.Lock()                               // prevents race on voting
 	defer cLock.Unlock()
 
+	if s.IsVotingFrozen(req.Locator.SiteID) {
+		return store.Comment{}, fmt.Errorf("can't vote for %s: %w", req.CommentID, ErrVotingFrozen)
+	}
+
 	comment, err = s.Engine.Get(engine.GetRequest{Locator: req.Locator, CommentID: req.CommentID})
 	if err != nil {
 		return comment, err
 	}
 
-	if comment.User.ID == req.UserID && req.UserID != "dev" {
+	if s.IsThreadLocked(req.Locator, req.CommentID) {
+		return comment, fmt.Errorf("can't vote for %s: %w", req.CommentID, ErrThreadLocked)
+	}
+
+	if comment.User.ID == req.UserID {
 		return comment, fmt.Errorf("user %s can not vote for his own comment %s", req.UserID, req.CommentID)
 	}
 
@@ -364,11 +631,10 @@ func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 		return comment, fmt.Errorf("user %s already voted for %s", req.UserID, req.CommentID)
 	}
 
-	secret, err := s.getSecret(comment.Locator.SiteID)
+	userIPHash, err := s.anonymizeIP(comment.Locator.SiteID, req.UserIP)
 	if err != nil {
-		return store.Comment{}, fmt.Errorf("can't get secret for site %s: %w", comment.Locator.SiteID, err)
+		return store.Comment{}, err
 	}
-	userIPHash := store.HashValue(req.UserIP, secret)
 	if s.isSameIPVote(req, userIPHash, comment) {
 		return comment, fmt.Errorf("the same ip %s already voted for %s", userIPHash, req.CommentID)
 	}
@@ -392,22 +658,37 @@ func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 	}
 	comment.VotedIPs[userIPHash] = store.VotedIPInfo{Timestamp: time.Now(), Value: req.Val}
 
+	weight := 1
 	// reset vote if user changed to opposite. Effectively it is "forget about prev votes" to allow "+ - -" or "- + +" corrections
 	if voted && v != req.Val {
+		if w, ok := comment.VoteWeights[req.UserID]; ok {
+			weight = w // revert previous vote with the weight it was made with
+		}
 		delete(comment.Votes, req.UserID)
 		delete(comment.VotedIPs, userIPHash)
+		delete(comment.VoteWeights, req.UserID)
 	}
 
 	// add to voted map if first vote
 	if !voted {
 		comment.Votes[req.UserID] = req.Val
+		weight = s.voteWeight(comment.Locator.SiteID, req.UserID)
+		if s.PositiveScore && !req.Val && weight > comment.Score {
+			weight = comment.Score // weighted vote can't make score negative
+		}
+		if weight != 1 {
+			if comment.VoteWeights == nil {
+				comment.VoteWeights = map[string]int{}
+			}
+			comment.VoteWeights[req.UserID] = weight
+		}
 	}
 
 	// update score
 	if req.Val {
-		comment.Score++
+		comment.Score += weight
 	} else {
-		comment.Score--
+		comment.Score -= weight
 	}
 
 	comment.Vote = 0
@@ -425,7 +706,41 @@ func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 
 	comment.Controversy = s.controversy(s.upsAndDowns(comment))
 	comment.Locator = req.Locator
-	return comment, s.Engine.Update(comment)
+	if err = s.Engine.Update(comment); err == nil {
+		s.Metrics.Voted()
+	}
+	comment.History = nil // edit history available to moderators only
+	return comment, err
+}
+
+// voteWeight returns weight of the user's vote, 1 if vote weights not set or not applicable to the user
+func (s *DataStore) voteWeight(siteID, userID string) int {
+	weight := 1
+	if s.VoteWeights.Admin > weight && s.IsAdmin(siteID, userID) {
+		weight = s.VoteWeights.Admin
+	}
+	if s.VoteWeights.Verified > weight && s.IsVerified(siteID, userID) {
+		weight = s.VoteWeights.Verified
+	}
+	if s.VoteWeights.Reputation > weight && s.VoteWeights.ReputationScore > 0 &&
+		s.reputation(siteID, userID) >= s.VoteWeights.ReputationScore {
+		weight = s.VoteWeights.Reputation
+	}
+	return weight
+}
+
+// reputation returns total score of user's last comments on the site
+func (s *DataStore) reputation(siteID, userID string) (res int) {
+	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Limit: maxReputationComments, Sort: "-time"}
+	comments, err := s.Engine.Find(req)
+	if err != nil {
+		log.Printf("[WARN] can't get comments of %s for reputation, %v", userID, err)
+		return 0
+	}
+	for _, c := range comments {
+		res += c.Score
+	}
+	return res
 }
 
 func (s *DataStore) isSameIPVote(req VoteReq, userIPHash string, comment store.Comment) bool {
@@ -466,6 +781,8 @@ type EditRequest struct {
 	Summary string
 	Delete  bool
 	Admin   bool
+	UserID  string // editor, comments of other users can be edited by admins with Reason only
+	Reason  string
 }
 
 // EditComment to edit text and update Edit info
@@ -480,8 +797,8 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 			return fmt.Errorf("too late to edit %s", commentID)
 		}
 
-		// edit rejected on replayed threads
-		if s.HasReplies(comment) {
+		// author's edit rejected on replied threads, unless allowed by the site's policy
+		if !req.Admin && s.replyLocked(comment.Locator.SiteID, req.Delete) && s.HasReplies(comment) {
 			return fmt.Errorf("parent comment with reply can't be edited, %s", commentID)
 		}
 		return nil
@@ -495,6 +812,22 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 		return comment, err
 	}
 
+	if comment.Redacted && !req.Delete {
+		return comment, fmt.Errorf("redacted comment %s can't be edited", commentID)
+	}
+
+	if s.IsThreadLocked(locator, commentID) {
+		return comment, fmt.Errorf("can't edit %s: %w", commentID, ErrThreadLocked)
+	}
+
+	moderated := req.UserID != "" && req.UserID != comment.User.ID
+	if moderated && !req.Admin {
+		return comment, fmt.Errorf("can't edit comment %s of other user", commentID)
+	}
+	if moderated && strings.TrimSpace(req.Reason) == "" {
+		return comment, ErrEditReasonRequired
+	}
+
 	if req.Delete { // delete request
 		if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvDelete); e != nil {
 			log.Printf("[WARN] failed to send delete event, %s", e)
@@ -510,13 +843,25 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 		return comment, s.Engine.Delete(delReq)
 	}
 
+	edited := s.normalize(store.Comment{Text: req.Text, Orig: req.Orig, Locator: locator})
+	req.Text, req.Orig = edited.Text, edited.Orig
 	if s.RestrictedWordsMatcher != nil && s.RestrictedWordsMatcher.Match(comment.Locator.SiteID, req.Text) {
 		return comment, ErrRestrictedWordsFound
 	}
 
+	if s.EditHistory > 0 {
+		comment.History = append(comment.History, comment.Version())
+		if len(comment.History) > s.EditHistory {
+			comment.History = comment.History[len(comment.History)-s.EditHistory:]
+		}
+	}
+
 	comment.Text = req.Text
 	comment.Orig = req.Orig
 	comment.Edit = &store.Edit{Timestamp: time.Now(), Summary: req.Summary}
+	if moderated {
+		comment.Edit.Reason = strings.TrimSpace(req.Reason)
+	}
 	comment.Locator = locator
 	comment.Sanitize()
 
@@ -525,9 +870,21 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 	}
 
 	err = s.Engine.Update(comment)
+	comment.History = nil // edit history available to moderators only
 	return comment, err
 }
 
+// CommentHistory returns all versions of the comment, from the oldest to the current one
+func (s *DataStore) CommentHistory(locator store.Locator, commentID string) ([]store.CommentVersion, error) {
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]store.CommentVersion, 0, len(comment.History)+1)
+	res = append(res, comment.History...)
+	return append(res, comment.Version()), nil
+}
+
 // HasReplies checks if there is any reply to the comments
 // Loads last maxLastCommentsReply comments and compare parent id to the comment's id
 // Comments with replies cached for 5 minutes
@@ -639,19 +996,24 @@ func (s *DataStore) ValidateComment(c *store.Comment) error {
 	if s.MaxCommentSize <= 0 {
 		maxSize = defaultCommentMaxSize
 	}
-	if c.Orig == "" {
-		return fmt.Errorf("empty comment text")
+	// length counted in characters, not bytes, and whitespaces around the text don't count for min size
+	text := strings.TrimSpace(c.Orig)
+	if text == "" {
+		return sizeError{msg: "empty comment text", err: ErrCommentTooShort}
 	}
-	commentLength := len([]rune(c.Orig))
+	commentLength := utf8.RuneCountInString(c.Orig)
 	if commentLength > maxSize {
-		return fmt.Errorf("comment text exceeded max allowed size %d (%d)", maxSize, commentLength)
+		return sizeError{msg: fmt.Sprintf("comment text exceeded max allowed size %d (%d)", maxSize, commentLength), err: ErrCommentTooLong}
 	}
-	if s.MinCommentSize > 0 && commentLength < s.MinCommentSize {
-		return fmt.Errorf("comment text is smaller than min allowed size %d (%d)", s.MinCommentSize, commentLength)
+	if textLength := utf8.RuneCountInString(text); s.MinCommentSize > 0 && textLength < s.MinCommentSize {
+		return sizeError{msg: fmt.Sprintf("comment text is smaller than min allowed size %d (%d)", s.MinCommentSize, textLength), err: ErrCommentTooShort}
 	}
 	if c.User.ID == "" || c.User.Name == "" {
 		return fmt.Errorf("empty user info")
 	}
+	if !c.User.Admin && s.IsNameBlocked(c.Locator.SiteID, c.User.Name) {
+		return fmt.Errorf("%w: %q", ErrBlockedName, c.User.Name)
+	}
 
 	// for validation purposes it's not important if SmartyPants formatting is disabled or enabled,
 	// while for storing the comment that flag is set based on user preference
@@ -671,6 +1033,44 @@ func (s *DataStore) ValidateComment(c *store.Comment) error {
 	return wrongLinkError
 }
 
+// ValidateRendered checks size of the rendered comment html against MaxRenderedSize, in characters.
+// Markdown can expand a lot on rendering, so the limit on the original text alone doesn't prevent huge comments.
+// Images counted in rendered html as well, both uploaded and external ones, against MaxImages limit of the comment's site.
+// Text of the rendered comment checked against AllowedScripts of the site.
+func (s *DataStore) ValidateRendered(c *store.Comment) error {
+	if size := utf8.RuneCountInString(c.Text); s.MaxRenderedSize > 0 && size > s.MaxRenderedSize {
+		return sizeError{msg: fmt.Sprintf("rendered comment exceeded max allowed size %d (%d)", s.MaxRenderedSize, size), err: ErrCommentTooLong}
+	}
+	if maxImages, ok := s.maxImages(c.Locator.SiteID); ok {
+		if count := countImages(c.Text); count > maxImages {
+			return sizeError{msg: fmt.Sprintf("comment exceeded max allowed number of images %d (%d)", maxImages, count), err: ErrTooManyImages}
+		}
+	}
+	return s.checkScripts(c.Locator.SiteID, c.Text)
+}
+
+// maxImages returns limit of images per comment for the site, false if not limited
+func (s *DataStore) maxImages(siteID string) (int, bool) {
+	if limit, ok := s.MaxImages[siteID]; ok {
+		return limit, limit >= 0
+	}
+	limit, ok := s.MaxImages[AllSitesMaxImages]
+	return limit, ok && limit >= 0
+}
+
+// countImages returns number of img tags in comment html
+func countImages(commentHTML string) int {
+	if !strings.Contains(commentHTML, "<img") {
+		return 0
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
+	if err != nil {
+		log.Printf("[WARN] can't parse comment html to count images, %v", err)
+		return 0
+	}
+	return doc.Find("img").Length()
+}
+
 // IsAdmin checks if usesID in the list of admins
 func (s *DataStore) IsAdmin(siteID, userID string) bool {
 	admins, err := s.AdminStore.Admins(siteID)
@@ -704,6 +1104,24 @@ func (s *DataStore) SetReadOnly(locator store.Locator, status bool) error {
 	return err
 }
 
+// IsVotingFrozen checks if voting frozen for the site
+func (s *DataStore) IsVotingFrozen(siteID string) bool {
+	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.VotingFrozen}
+	frozen, err := s.Engine.Flag(req)
+	return err == nil && frozen
+}
+
+// SetVotingFrozen freezes or unfreezes voting for the site, votes already made and scores kept as is
+func (s *DataStore) SetVotingFrozen(siteID string, status bool) error {
+	frozenStatus := engine.FlagFalse
+	if status {
+		frozenStatus = engine.FlagTrue
+	}
+	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.VotingFrozen, Update: frozenStatus}
+	_, err := s.Engine.Flag(req)
+	return err
+}
+
 // IsVerified checks if user verified
 func (s *DataStore) IsVerified(siteID, userID string) bool {
 	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.Verified}
@@ -722,6 +1140,24 @@ func (s *DataStore) SetVerified(siteID, userID string, status bool) error {
 	return err
 }
 
+// IsUnsubscribed checks if user unsubscribed from notifications for the post
+func (s *DataStore) IsUnsubscribed(locator store.Locator, userID string) bool {
+	req := engine.FlagRequest{Locator: locator, UserID: userID, Flag: engine.Unsubscribed}
+	unsub, err := s.Engine.Flag(req)
+	return err == nil && unsub
+}
+
+// SetUnsubscribed set/reset unsubscribed status of user's notifications for the post
+func (s *DataStore) SetUnsubscribed(locator store.Locator, userID string, status bool) error {
+	unsubStatus := engine.FlagFalse
+	if status {
+		unsubStatus = engine.FlagTrue
+	}
+	req := engine.FlagRequest{Locator: locator, UserID: userID, Flag: engine.Unsubscribed, Update: unsubStatus}
+	_, err := s.Engine.Flag(req)
+	return err
+}
+
 // IsBlocked checks if user blocked
 func (s *DataStore) IsBlocked(siteID, userID string) bool {
 	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.Blocked}
@@ -782,7 +1218,8 @@ func (s *DataStore) Info(locator store.Locator, readonlyAge int) (store.PostInfo
 
 }
 
-// Delete comment by id
+// Delete comment by id. With TrashTTL set, soft deleted comment kept in trash and can be restored till purged,
+// its images kept till purge as well.
 func (s *DataStore) Delete(locator store.Locator, commentID string, mode store.DeleteMode) error {
 	if e := s.AdminStore.OnEvent(locator.SiteID, admin.EvDelete); e != nil {
 		log.Printf("[WARN] failed to send delete event, %s", e)
@@ -799,17 +1236,37 @@ func (s *DataStore) Delete(locator store.Locator, commentID string, mode store.D
 		s.repliesCache.Delete(comment.ParentID)
 	}
 
-	// delete images from the comment if they are not reused elsewhere in comments to the same page
+	if mode == store.SoftDelete && comment.Deleted && comment.Trashed != nil {
+		return nil // already in trash, soft delete would drop the trashed comment
+	}
+	trash := s.TrashTTL > 0 && mode == store.SoftDelete && !comment.Deleted
+	if !trash {
+		s.deleteImages(locator, comment)
+	}
+
+	req := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: mode}
+	if err = s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.Metrics.CommentDeleted()
+	if trash {
+		return s.toTrash(locator, comment)
+	}
+	return nil
+}
+
+// deleteImages deletes images of the comment if they are not reused elsewhere in comments to the same page
+func (s *DataStore) deleteImages(locator store.Locator, comment store.Comment) {
 	idsFn := func() []string { // get IDs of all images from the same URL to verify if image from deleted comment was reused
 		comments, e := s.Engine.Find(engine.FindRequest{Locator: locator})
 		if e != nil {
-			log.Printf("[WARN] can't get comments %s text for deleted comment image check, %v", comment.ID, err)
+			log.Printf("[WARN] can't get comments %s text for deleted comment image check, %v", comment.ID, e)
 			return nil
 		}
 		var imgIDs = []string{}
 		for _, cc := range comments {
 			// exclude the comment we are deleting
-			if cc.ID != commentID {
+			if cc.ID != comment.ID {
 				imgIDs = append(imgIDs, s.ImageService.ExtractPictures(cc.Text)...)
 			}
 		}
@@ -820,18 +1277,16 @@ func (s *DataStore) Delete(locator store.Locator, commentID string, mode store.D
 	for _, id := range commentImgIDs {
 		if !slices.Contains(pageImgIDs, id) {
 			if err := s.ImageService.Delete(id); err != nil {
-				log.Printf("[WARN] failed to delete image %s on comment %s deletion, %v", id, commentID, err)
+				log.Printf("[WARN] failed to delete image %s on comment %s deletion, %v", id, comment.ID, err)
 			}
 		}
 	}
 	log.Printf("[ERROR] commentImgIDs: %v, pageImgIDs: %v", commentImgIDs, pageImgIDs)
-
-	req := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: mode}
-	return s.Engine.Delete(req)
 }
 
 // DeleteUser removes all comments from user
 func (s *DataStore) DeleteUser(siteID, userID string, mode store.DeleteMode) error {
+	defer s.resetAnonNames(siteID)
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, DeleteMode: mode}
 	return s.Engine.Delete(req)
 }
@@ -842,6 +1297,43 @@ func (s *DataStore) List(siteID string, limit, skip int) ([]store.PostInfo, erro
 	return s.Engine.Info(req)
 }
 
+// IterateComments calls fn for each comment of the site, post by post starting from the oldest listed one,
+// stops on the first error returned by fn. Comments read from the engine in small batches if it supports
+// iteration and by post otherwise, so all comments of the site never loaded in memory at once.
+func (s *DataStore) IterateComments(siteID string, user store.User, fn func(store.Comment) error) error {
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return fmt.Errorf("can't get list of posts for %s: %w", siteID, err)
+	}
+
+	iter, iterable := s.Engine.(engine.Iterator)
+	for i := len(posts) - 1; i >= 0; i-- { // posts from Info sorted in opposite direction
+		locator := store.Locator{SiteID: siteID, URL: posts[i].URL}
+		if !iterable {
+			comments, e := s.Find(locator, "time", user)
+			if e != nil {
+				return e
+			}
+			for _, c := range comments {
+				if e = fn(c); e != nil {
+					return e
+				}
+			}
+			continue
+		}
+		err = iter.Iterate(engine.FindRequest{Locator: locator}, func(c store.Comment) error {
+			if c.Controversy == 0 && len(c.Votes) > 0 { // the same as in FindSince, for comments added prior to #274
+				c.Controversy = s.controversy(s.upsAndDowns(c))
+			}
+			return fn(s.alterComment(c, user))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Count gets number of comments for the post
 func (s *DataStore) Count(locator store.Locator) (int, error) {
 	req := engine.FindRequest{Locator: locator}
@@ -945,6 +1437,12 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 			_, err := s.Engine.UserDetail(req)
 			errs = multierror.Append(errs, err)
 		}
+		if um.Details.NotifyPrefs != "" {
+			req := engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, UserID: um.ID, Detail: engine.UserNotifyPrefs,
+				Update: um.Details.NotifyPrefs}
+			_, err := s.Engine.UserDetail(req)
+			errs = multierror.Append(errs, err)
+		}
 	}
 
 	return errs.ErrorOrNil()
@@ -961,6 +1459,37 @@ func (s *DataStore) User(siteID, userID string, limit, skip int, user store.User
 	return s.alterComments(comments, user), nil
 }
 
+// UserVote is a vote of the user for the comment
+type UserVote struct {
+	Locator   store.Locator `json:"locator"`
+	CommentID string        `json:"comment_id"`
+	Value     bool          `json:"value"` // true for upvote, false for downvote
+}
+
+// UserVotes returns all votes of the user across the site, sorted by post and comment time.
+// Goes through all comments of the site, as votes stored with comments, so should be used for rare requests only.
+func (s *DataStore) UserVotes(siteID, userID string) ([]UserVote, error) {
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, fmt.Errorf("can't get posts for %s: %w", siteID, err)
+	}
+
+	res := []UserVote{}
+	for _, p := range posts {
+		locator := store.Locator{SiteID: siteID, URL: p.URL}
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+		if e != nil {
+			return nil, fmt.Errorf("can't get comments for %s: %w", p.URL, e)
+		}
+		for _, c := range comments {
+			if v, ok := c.Votes[userID]; ok {
+				res = append(res, UserVote{Locator: locator, CommentID: c.ID, Value: v})
+			}
+		}
+	}
+	return res, nil
+}
+
 // UserCount is comments count by user
 func (s *DataStore) UserCount(siteID, userID string) (int, error) {
 	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID}
@@ -980,6 +1509,7 @@ func (s *DataStore) Last(siteID string, limit int, since time.Time, user store.U
 // Close store service
 func (s *DataStore) Close() error {
 	errs := new(multierror.Error)
+	s.stopHeldExpiry()
 	if s.repliesCache.LoadingCache != nil {
 		errs = multierror.Append(errs, s.repliesCache.LoadingCache.Close())
 	}
@@ -1016,6 +1546,34 @@ func (s *DataStore) getScopedLocks(id string) (lock sync.Locker) {
 	return lock
 }
 
+// pinnedFirst moves pinned comments to the top, ordered by creation time. The order of other comments is kept
+func pinnedFirst(comments []store.Comment) []store.Comment {
+	sort.SliceStable(comments, func(i, j int) bool {
+		if comments[i].Pin && comments[j].Pin {
+			return comments[i].Timestamp.Before(comments[j].Timestamp)
+		}
+		return comments[i].Pin && !comments[j].Pin
+	})
+	return comments
+}
+
+// setDepth sets depth of each comment of the post by the chain of its parents, 0 for root comments.
+// Parent missing in the list is treated as a root comment.
+func setDepth(comments []store.Comment) {
+	parents := make(map[string]string, len(comments)) // parent id by comment id
+	for _, c := range comments {
+		parents[c.ID] = c.ParentID
+	}
+	for i, c := range comments {
+		depth, pid := 0, c.ParentID
+		for pid != "" && depth < len(comments) { // depth limit protects from loops of broken data
+			depth++
+			pid = parents[pid]
+		}
+		comments[i].Depth = depth
+	}
+}
+
 func (s *DataStore) alterComments(cc []store.Comment, user store.User) (res []store.Comment) {
 	res = make([]store.Comment, len(cc))
 	for i, c := range cc {
@@ -1042,9 +1600,19 @@ func (s *DataStore) alterComment(c store.Comment, user store.User) (res store.Co
 	// hide info from non-admins
 	if !user.Admin {
 		c.User.IP = ""
+		c.Reports = nil
+		c.Trashed = nil
+		if c.Hidden && c.User.ID != user.ID { // author still sees own hidden comment
+			c.Text, c.Orig = "", ""
+		}
+		if c.PinModOnly { // not pinned for readers, so listed in the regular order
+			c.Pin, c.PinModOnly = false, false
+		}
 	}
+	c.History = nil // edit history available with CommentHistory only
 
 	c = s.prepVotes(c, user)
+	c.Collapsed = s.CollapseScore != 0 && c.Score < s.CollapseScore
 	c.Locator.URL = c.SanitizeAsURL(c.Locator.URL) // urls prior to #927
 	c.PostTitle = c.SanitizeText(c.PostTitle)
 	return c
@@ -1062,8 +1630,10 @@ func (s *DataStore) prepVotes(c store.Comment, user store.User) store.Comment {
 		}
 	}
 
-	c.Votes = nil    // hide voters list
-	c.VotedIPs = nil // hide voted ips (hashes)
+	c.Votes = nil       // hide voters list
+	c.VotedIPs = nil    // hide voted ips (hashes)
+	c.VoteWeights = nil // hide weights of voters
+	c.Reacted = nil     // hide users reacted
 	return c
 }
 
@@ -1083,3 +1653,16 @@ func (s *DataStore) getSecret(siteID string) (secret string, err error) {
 	}
 	return secret, nil
 }
+
+// anonymizeIP makes stored form of client IP with IPMode, hashed with the site's salt if IPSalt set,
+// or with the site's secret otherwise
+func (s *DataStore) anonymizeIP(siteID, ip string) (string, error) {
+	secret, err := s.getSecret(siteID)
+	if err != nil {
+		return "", fmt.Errorf("can't get secret for site %s: %w", siteID, err)
+	}
+	if s.IPSalt != "" {
+		secret = s.IPSalt + "::" + siteID
+	}
+	return store.AnonymizeIP(ip, s.IPMode, secret), nil
+}
@@ -27,6 +27,46 @@ import (
 	"github.com/umputun/remark42/backend/app/store/image"
 )
 
+func TestService_CreateReserveAnonName(t *testing.T) {
+	ks := admin.NewStaticKeyStore("secret 123")
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: ks, ReserveAnonNames: true}
+
+	makeComment := func(id, name string) store.Comment {
+		return store.Comment{Text: "text", User: store.User{IP: "192.168.1.1", ID: id, Name: name},
+			Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}}
+	}
+
+	_, err := b.Create(makeComment("anonymous_1", "John Doe"))
+	require.NoError(t, err, "first use reserves the name")
+	_, err = b.Create(makeComment("anonymous_1", "John Doe"))
+	require.NoError(t, err, "original client reuses the name")
+
+	_, err = b.Create(makeComment("anonymous_2", "john doe"))
+	assert.ErrorIs(t, err, ErrAnonNameReserved, "another client can't use the name")
+	_, err = b.Create(makeComment("anonymous_2", "Jane Doe"))
+	require.NoError(t, err, "another client can reserve another name")
+
+	restarted := DataStore{Engine: eng, AdminStore: ks, ReserveAnonNames: true}
+	_, err = restarted.Create(makeComment("anonymous_3", "JANE DOE"))
+	assert.ErrorIs(t, err, ErrAnonNameReserved, "reserved names loaded from user details")
+	require.NoError(t, restarted.DeleteUserDetail("radio-t", "anonymous_2", engine.AllUserDetails))
+	_, err = restarted.Create(makeComment("anonymous_3", "JANE DOE"))
+	require.NoError(t, err, "name released with user details")
+
+	_, err = b.Create(makeComment("github_1", "John Doe"))
+	require.NoError(t, err, "regular users not affected")
+
+	b.ReserveAnonNames = false
+	_, err = b.Create(makeComment("anonymous_3", "John Doe"))
+	require.NoError(t, err, "reservation disabled")
+
+	comments, err := b.Find(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 8, len(comments), "2 initial and 6 created comments")
+}
+
 func TestService_CreateFromEmpty(t *testing.T) {
 	ks := admin.NewStaticKeyStore("secret 123")
 	eng, teardown := prepStoreEngine(t)
@@ -172,6 +212,57 @@ func TestService_CreateFromPartialWithTitle(t *testing.T) {
 	assert.Equal(t, "post blah", res.PostTitle, "keep comment title")
 }
 
+func TestService_GetWithAncestors(t *testing.T) {
+	// two comments for https://radio-t.com, no reply
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// id-1 <- id-3 <- id-4 <- id-5
+	for i, pid := range []string{"id-1", "id-3", "id-4"} {
+		_, err := eng.Create(store.Comment{ID: fmt.Sprintf("id-%d", i+3), ParentID: pid, Text: fmt.Sprintf("reply %d", i+3),
+			Timestamp: time.Date(2018, 12, 20, 15, 18, 22+i, 0, time.Local), Locator: locator,
+			User: store.User{ID: "user2", Name: "user name 2", IP: "127.0.0.1"}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(locator, "id-3", store.SoftDelete))
+
+	c, ancestors, err := b.GetWithAncestors(locator, "id-5", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "id-5", c.ID)
+	assert.Equal(t, "reply 5", c.Text)
+	require.Len(t, ancestors, 3)
+	assert.Equal(t, "id-1", ancestors[0].ID, "root first")
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, ancestors[0].Text)
+	assert.Equal(t, "id-3", ancestors[1].ID)
+	assert.True(t, ancestors[1].Deleted, "deleted ancestor as tombstone")
+	assert.Empty(t, ancestors[1].Text)
+	assert.Equal(t, "id-4", ancestors[2].ID, "direct parent last")
+	assert.Empty(t, ancestors[2].User.IP, "altered for non-admin")
+	assert.Equal(t, 3, c.Depth)
+	for i, a := range ancestors {
+		assert.Equal(t, i, a.Depth)
+	}
+
+	c, ancestors, err = b.GetWithAncestors(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", c.ID)
+	assert.Empty(t, ancestors, "no ancestors for top-level comment")
+
+	// parent missing in the store
+	_, err = eng.Create(store.Comment{ID: "id-6", ParentID: "id-missing", Text: "orphan", Locator: locator,
+		Timestamp: time.Date(2018, 12, 20, 15, 18, 30, 0, time.Local), User: store.User{ID: "user2", Name: "user name 2"}})
+	require.NoError(t, err)
+	_, ancestors, err = b.GetWithAncestors(locator, "id-6", store.User{})
+	require.NoError(t, err)
+	require.Len(t, ancestors, 1)
+	assert.Equal(t, store.Comment{ID: "id-missing", Locator: locator, Deleted: true}, ancestors[0])
+
+	_, _, err = b.GetWithAncestors(locator, "id-bad", store.User{})
+	assert.Error(t, err)
+}
+
 func TestService_Put(t *testing.T) {
 	ks := admin.NewStaticKeyStore("secret 123")
 	eng, teardown := prepStoreEngine(t)
@@ -378,6 +469,50 @@ func TestService_Vote(t *testing.T) {
 	assert.Equal(t, map[string]store.VotedIPInfo(nil), res[0].VotedIPs, "vote reset ok")
 }
 
+func TestService_VoteOnePerUser(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Create(store.Comment{ID: "dev-comment", Text: "text", Locator: locator, User: store.User{ID: "dev", Name: "dev"}})
+	require.NoError(t, err)
+
+	// self-vote rejected for any user
+	for _, id := range []string{"id-1", "dev-comment"} {
+		c, e := b.Engine.Get(getReq(locator, id))
+		require.NoError(t, e)
+		_, err = b.Vote(VoteReq{Locator: locator, CommentID: id, UserID: c.User.ID, Val: true})
+		assert.EqualError(t, err, fmt.Sprintf("user %s can not vote for his own comment %s", c.User.ID, id))
+	}
+
+	// double vote collapsed to one
+	c, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: true})
+	assert.EqualError(t, err, "user user2 already voted for id-1")
+	c, err = b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score)
+	assert.Equal(t, map[string]bool{"user2": true}, c.Votes)
+
+	// vote toggle, opposite vote resets the previous one, and the next one sets it
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: false})
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Score)
+	assert.Equal(t, 0, len(c.Votes))
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: false})
+	require.NoError(t, err)
+	assert.Equal(t, -1, c.Score)
+	assert.Equal(t, map[string]bool{"user2": false}, c.Votes)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: false})
+	assert.Error(t, err, "can't go below one vote")
+	c, err = b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, -1, c.Score)
+}
+
 func TestService_VoteLimit(t *testing.T) {
 	eng, teardown := prepStoreEngine(t)
 	defer teardown()
@@ -401,6 +536,35 @@ func TestService_VoteLimit(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestService_UserVotes(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-2", UserID: "user2", Val: false})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user3", Val: true})
+	require.NoError(t, err)
+
+	votes, err := b.UserVotes("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, []UserVote{{Locator: locator, CommentID: "id-1", Value: true}, {Locator: locator, CommentID: "id-2", Value: false}}, votes)
+
+	votes, err = b.UserVotes("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, []UserVote{{Locator: locator, CommentID: "id-1", Value: true}}, votes)
+
+	votes, err = b.UserVotes("radio-t", "user-no-votes")
+	require.NoError(t, err)
+	assert.Empty(t, votes)
+
+	_, err = b.UserVotes("bad-site", "user2")
+	assert.Error(t, err)
+}
+
 func TestService_VotesDisabled(t *testing.T) {
 	eng, teardown := prepStoreEngine(t)
 	defer teardown()
@@ -411,6 +575,41 @@ func TestService_VotesDisabled(t *testing.T) {
 	assert.EqualError(t, err, "maximum number of votes exceeded for comment id-1")
 }
 
+func TestService_VotingFrozen(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score)
+
+	assert.False(t, b.IsVotingFrozen("radio-t"))
+	require.NoError(t, b.SetVotingFrozen("radio-t", true))
+	assert.True(t, b.IsVotingFrozen("radio-t"))
+	assert.False(t, b.IsVotingFrozen("other"), "frozen for radio-t only")
+
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user3", Val: true})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrVotingFrozen))
+	assert.EqualError(t, err, "can't vote for id-1: voting frozen")
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: false})
+	assert.ErrorIs(t, err, ErrVotingFrozen, "vote change rejected too")
+
+	c, err = b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score, "score kept while frozen")
+
+	require.NoError(t, b.SetVotingFrozen("radio-t", false))
+	assert.False(t, b.IsVotingFrozen("radio-t"))
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user3", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, c.Score, "voting resumed")
+
+	assert.Error(t, b.SetVotingFrozen("bad-site", true))
+}
+
 func TestService_VoteAggressive(t *testing.T) {
 	eng, teardown := prepStoreEngine(t)
 	defer teardown()
@@ -560,6 +759,73 @@ func TestService_VotePositive(t *testing.T) {
 	assert.Equal(t, -1, c.Score)
 }
 
+func TestService_VoteWeights(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, MaxVotes: -1,
+		AdminStore:  admin.NewStaticStore("secret 123", []string{"radio-t"}, []string{"admin1"}, "user@email.com"),
+		VoteWeights: VoteWeights{Admin: 5, Verified: 2, Reputation: 3, ReputationScore: 10}}
+	defer b.Close()
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// user1 gets reputation with the score of his comment
+	c, err := b.Engine.Get(getReq(locator, "id-2"))
+	require.NoError(t, err)
+	c.Score = 12
+	require.NoError(t, b.Engine.Update(c))
+
+	_, err = b.Engine.Create(store.Comment{ID: "id-3", Text: "text", Locator: locator, User: store.User{ID: "user9"},
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local)})
+	require.NoError(t, err)
+
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score, "new user's vote counts as 1")
+
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user1", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 4, c.Score, "high-reputation user's vote counts as 3")
+
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user1", Val: false})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score, "vote reset with the same weight")
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user1", Val: false})
+	require.NoError(t, err)
+	assert.Equal(t, -2, c.Score)
+
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "admin1", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, c.Score, "admin's vote counts as 5")
+
+	require.NoError(t, b.SetVerified("radio-t", "user3", true))
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user3", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 5, c.Score, "verified user's vote counts as 2")
+
+	c, err = b.Engine.Get(getReq(locator, "id-3"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"user1": 3, "admin1": 5, "user3": 2}, c.VoteWeights)
+	c, err = b.Get(locator, "id-3", store.User{})
+	require.NoError(t, err)
+	assert.Nil(t, c.VoteWeights, "weights hidden")
+
+	// positive score only, weighted downvote can't make score negative
+	b.PositiveScore = true
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score)
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "admin1", Val: false})
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Score)
+
+	// no weights by default
+	b.VoteWeights = VoteWeights{}
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-3", UserID: "user4", Val: true})
+	require.NoError(t, err)
+	assert.Equal(t, 6, c.Score)
+}
+
 func TestService_VoteControversy(t *testing.T) {
 	eng, teardown := prepStoreEngine(t)
 	defer teardown()
@@ -722,6 +988,35 @@ func TestService_VoteSameIP(t *testing.T) {
 	assert.Equal(t, -1, c.Score, "set to -1 score, correction vote allowed")
 }
 
+func TestService_AnonymizeIP(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1, IPMode: store.IPTruncate}
+	b.RestrictSameIPVotes.Enabled = true
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "text", Locator: locator, User: store.User{IP: "192.168.1.1", ID: "user", Name: "name"}})
+	require.NoError(t, err)
+	res, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.0", res.User.IP, "stored truncated")
+
+	c, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-2", UserID: "user2", UserIP: "10.0.0.1", Val: true})
+	require.NoError(t, err)
+	assert.Contains(t, c.VotedIPs, "10.0.0.0")
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-2", UserID: "user3", UserIP: "10.0.0.2", Val: true})
+	assert.EqualError(t, err, "the same ip 10.0.0.0 already voted for id-2")
+
+	// hash with salt is the same for all keys of the site
+	b.IPMode, b.IPSalt = store.IPHash, "salt"
+	id, err = b.Create(store.Comment{Text: "text", Locator: locator, User: store.User{IP: "192.168.1.1", ID: "user", Name: "name"}})
+	require.NoError(t, err)
+	res, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+	require.NoError(t, err)
+	assert.Equal(t, store.HashValue("192.168.1.1", "salt::radio-t"), res.User.IP)
+	assert.NotEqual(t, store.HashValue("192.168.1.1", "secret 123"), res.User.IP)
+}
+
 func TestService_VoteSameIPWithDuration(t *testing.T) {
 	eng, teardown := prepStoreEngine(t)
 	defer teardown()
@@ -832,6 +1127,94 @@ func TestService_EditComment(t *testing.T) {
 	assert.NoError(t, err, "allow second edit")
 }
 
+func TestService_EditCommentMarker(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "new comment", Locator: locator, User: store.User{ID: "user1", Name: "user name"}})
+	require.NoError(t, err)
+	c, err := b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.Nil(t, c.Edit, "not marked on create")
+	created := c.Timestamp
+
+	c, err = b.EditComment(locator, id, EditRequest{Orig: "new comment fixed", Text: "new comment fixed", Summary: "typo"})
+	require.NoError(t, err)
+	require.NotNil(t, c.Edit, "marked on edit")
+	assert.Equal(t, "typo", c.Edit.Summary)
+	assert.False(t, c.Edit.Timestamp.Before(created), "edit time not before creation time")
+	assert.Equal(t, created, c.Timestamp, "creation time not changed by edit")
+
+	c, err = b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	require.NotNil(t, c.Edit, "marker stored")
+	assert.Equal(t, "typo", c.Edit.Summary)
+	assert.Equal(t, created, c.Timestamp)
+}
+
+func TestService_EditCommentHistory(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, EditHistory: 2, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	orig, err := b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+
+	versions, err := b.CommentHistory(locator, "id-1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(versions), "no history, current version only")
+	assert.Equal(t, orig.Text, versions[0].Text)
+	assert.True(t, orig.Timestamp.Equal(versions[0].Timestamp))
+
+	comment, err := b.EditComment(locator, "id-1", EditRequest{Orig: "edit 1", Text: "edit 1", Summary: "first edit"})
+	require.NoError(t, err)
+	assert.Nil(t, comment.History, "history not returned on edit")
+
+	c, err := b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(c.History), "edit creates history entry")
+	assert.Equal(t, orig.Text, c.History[0].Text)
+
+	c, err = b.Get(locator, "id-1", store.User{Admin: true})
+	require.NoError(t, err)
+	assert.Nil(t, c.History, "history hidden from comment responses")
+
+	for _, txt := range []string{"edit 2", "edit 3"} {
+		_, err = b.EditComment(locator, "id-1", EditRequest{Orig: txt, Text: txt, Summary: txt})
+		require.NoError(t, err)
+	}
+
+	versions, err = b.CommentHistory(locator, "id-1")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(versions), "history capped to 2 prior versions")
+	assert.Equal(t, "edit 1", versions[0].Text)
+	assert.Equal(t, "first edit", versions[0].Summary)
+	assert.Equal(t, "edit 2", versions[1].Text)
+	assert.Equal(t, "edit 3", versions[2].Text)
+	assert.Equal(t, "edit 3", versions[2].Summary)
+	assert.False(t, versions[1].Timestamp.After(versions[2].Timestamp))
+
+	_, err = b.CommentHistory(locator, "id-bad")
+	assert.Error(t, err)
+
+	require.NoError(t, b.Delete(locator, "id-1", store.SoftDelete))
+	c, err = b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.Nil(t, c.History, "history removed with comment")
+
+	b.EditHistory = 0
+	_, err = b.EditComment(locator, "id-2", EditRequest{Orig: "xxx", Text: "xxx"})
+	require.NoError(t, err)
+	c, err = b.Engine.Get(getReq(locator, "id-2"))
+	require.NoError(t, err)
+	assert.Nil(t, c.History, "history disabled")
+}
+
 func TestService_DeleteComment(t *testing.T) {
 	eng, teardown := prepStoreEngine(t)
 	defer teardown()
@@ -923,6 +1306,92 @@ func TestService_EditCommentAdmin(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestService_EditCommentModerator(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, EditHistory: 5, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", UserID: "admin1", Admin: true})
+	assert.ErrorIs(t, err, ErrEditReasonRequired)
+	_, err = b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", UserID: "admin1", Admin: true, Reason: "  "})
+	assert.ErrorIs(t, err, ErrEditReasonRequired)
+	_, err = b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", UserID: "user2", Reason: "spam"})
+	assert.EqualError(t, err, "can't edit comment id-1 of other user")
+	c, err := b.Engine.Get(getReq(locator, "id-1"))
+	require.NoError(t, err)
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, c.Text, "rejected edits don't change the comment")
+
+	comment, err := b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", Summary: "fix",
+		UserID: "admin1", Admin: true, Reason: "personal data removed"})
+	require.NoError(t, err)
+	require.NotNil(t, comment.Edit, "moderator's edit marked")
+	assert.Equal(t, "personal data removed", comment.Edit.Reason)
+
+	_, err = b.EditComment(locator, "id-1", EditRequest{Orig: "yyy", Text: "yyy", UserID: "user1", Reason: "ignored"})
+	require.NoError(t, err, "self-edit doesn't require reason")
+
+	versions, err := b.CommentHistory(locator, "id-1")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, "", versions[0].Reason)
+	assert.Equal(t, "xxx", versions[1].Text)
+	assert.Equal(t, "fix", versions[1].Summary)
+	assert.Equal(t, "personal data removed", versions[1].Reason)
+	assert.Equal(t, "yyy", versions[2].Text)
+	assert.Equal(t, "", versions[2].Reason, "reason of self-edit not kept")
+}
+
+func TestService_EditCommentReplyEdit(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		ReplyEdit: map[string]string{AllSitesReplyEdit: ReplyEditAllow, "radio-t": ReplyEditLock}}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "c-1", ParentID: "id-1", Text: "some text", Locator: locator,
+		User: store.User{ID: "user2", Name: "user name 2"}})
+	require.NoError(t, err)
+
+	_, err = b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", UserID: "user1"})
+	assert.EqualError(t, err, "parent comment with reply can't be edited, id-1", "site's policy overrides all sites one")
+	_, err = b.EditComment(locator, "id-1", EditRequest{Delete: true, UserID: "user1"})
+	assert.Error(t, err, "delete rejected")
+	comment, err := b.EditComment(locator, "id-1", EditRequest{Orig: "yyy", Text: "yyy", UserID: "admin1", Admin: true,
+		Reason: "personal data removed"})
+	require.NoError(t, err, "moderator not restricted")
+	assert.Equal(t, "yyy", comment.Text)
+
+	b.ReplyEdit = map[string]string{AllSitesReplyEdit: ReplyEditDelete}
+	_, err = b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", UserID: "user1"})
+	assert.EqualError(t, err, "parent comment with reply can't be edited, id-1")
+
+	b.ReplyEdit = map[string]string{"radio-t": ReplyEditAllow}
+	comment, err = b.EditComment(locator, "id-1", EditRequest{Orig: "xxx", Text: "xxx", UserID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, "xxx", comment.Text)
+
+	b.ReplyEdit = map[string]string{"radio-t": ReplyEditDelete}
+	comment, err = b.EditComment(locator, "id-1", EditRequest{Delete: true, UserID: "user1"})
+	require.NoError(t, err)
+	assert.True(t, comment.Deleted)
+}
+
+func TestService_ReplyEditPolicy(t *testing.T) {
+	b := DataStore{}
+	assert.Equal(t, ReplyEditLock, b.replyEditPolicy("site1"), "lock by default")
+	assert.True(t, b.replyLocked("site1", true))
+
+	b.ReplyEdit = map[string]string{AllSitesReplyEdit: ReplyEditDelete, "site2": ReplyEditAllow, "site3": "bad"}
+	assert.Equal(t, ReplyEditDelete, b.replyEditPolicy("site1"))
+	assert.True(t, b.replyLocked("site1", false))
+	assert.False(t, b.replyLocked("site1", true))
+	assert.Equal(t, ReplyEditAllow, b.replyEditPolicy("site2"))
+	assert.False(t, b.replyLocked("site2", false))
+	assert.Equal(t, ReplyEditLock, b.replyEditPolicy("site3"), "unknown policy locks")
+}
+
 func TestService_ValidateComment(t *testing.T) {
 	b := DataStore{MinCommentSize: 6, MaxCommentSize: 2000, AdminStore: admin.NewStaticKeyStore("secret 123")}
 	longText := fmt.Sprintf("%4000s", "X")
@@ -952,6 +1421,84 @@ func TestService_ValidateComment(t *testing.T) {
 	}
 }
 
+func TestService_ValidateCommentSize(t *testing.T) {
+	b := DataStore{MinCommentSize: 3, MaxCommentSize: 5, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	user := store.User{ID: "myid", Name: "name"}
+
+	tbl := []struct {
+		text string
+		err  error
+		msg  string
+	}{
+		{text: "", err: ErrCommentTooShort, msg: "empty comment text"},
+		{text: " \n\t ", err: ErrCommentTooShort, msg: "empty comment text"},
+		{text: "ab", err: ErrCommentTooShort, msg: "comment text is smaller than min allowed size 3 (2)"},
+		{text: " ab ", err: ErrCommentTooShort, msg: "comment text is smaller than min allowed size 3 (2)"},
+		{text: "ЩЩ", err: ErrCommentTooShort, msg: "comment text is smaller than min allowed size 3 (2)"},
+		{text: "abc"},
+		{text: "ЩЩЩ"},
+		{text: "😀😀😀😀😀"},
+		{text: "日本語テキ"},
+		{text: "abcdef", err: ErrCommentTooLong, msg: "comment text exceeded max allowed size 5 (6)"},
+		{text: "😀😀😀😀😀😀", err: ErrCommentTooLong, msg: "comment text exceeded max allowed size 5 (6)"},
+		{text: "日本語テキス", err: ErrCommentTooLong, msg: "comment text exceeded max allowed size 5 (6)"},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.text, func(t *testing.T) {
+			err := b.ValidateComment(&store.Comment{Orig: tt.text, User: user})
+			if tt.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tt.err)
+			assert.EqualError(t, err, tt.msg)
+		})
+	}
+}
+
+func TestService_ValidateRendered(t *testing.T) {
+	b := DataStore{}
+	assert.NoError(t, b.ValidateRendered(&store.Comment{Text: strings.Repeat("x", 10000)}), "unlimited by default")
+
+	b.MaxRenderedSize = 10
+	assert.NoError(t, b.ValidateRendered(&store.Comment{Text: "<p>Щ😀x</p>"}), "10 characters, 17 bytes")
+	err := b.ValidateRendered(&store.Comment{Text: "<p>Щ😀xy</p>"})
+	assert.ErrorIs(t, err, ErrCommentTooLong)
+	assert.EqualError(t, err, "rendered comment exceeded max allowed size 10 (11)")
+}
+
+func TestService_ValidateRenderedImages(t *testing.T) {
+	img := `<img src="https://remark42.com/api/v1/picture/user1/abc.png" alt="pic"/>`
+	ext := `<img src="https://example.com/pic.png" alt="ext"/>`
+	b := DataStore{}
+	assert.NoError(t, b.ValidateRendered(&store.Comment{Text: strings.Repeat(img, 20)}), "unlimited by default")
+
+	b.MaxImages = map[string]int{AllSitesMaxImages: 2, "site1": 0, "site2": -1}
+	tbl := []struct {
+		site, text string
+		err        string
+	}{
+		{site: "radio-t", text: "<p>no images</p>"},
+		{site: "radio-t", text: "<p>" + img + ext + "</p>"},
+		{site: "radio-t", text: "<p>" + img + "</p><p>" + img + ext + "</p>", err: "comment exceeded max allowed number of images 2 (3)"},
+		{site: "site1", text: "<p>text</p>"},
+		{site: "site1", text: "<p>" + ext + "</p>", err: "comment exceeded max allowed number of images 0 (1)"},
+		{site: "site2", text: strings.Repeat(img, 10)},
+	}
+	for n, tt := range tbl {
+		err := b.ValidateRendered(&store.Comment{Locator: store.Locator{SiteID: tt.site}, Text: tt.text})
+		if tt.err == "" {
+			assert.NoError(t, err, "check #%d", n)
+			continue
+		}
+		require.Error(t, err, "check #%d", n)
+		assert.ErrorIs(t, err, ErrTooManyImages)
+		assert.EqualError(t, err, tt.err, "check #%d", n)
+	}
+}
+
 func TestService_Counts(t *testing.T) {
 	b, teardown := prepStoreEngine(t) // two comments for https://radio-t.com
 	defer teardown()
@@ -1310,6 +1857,137 @@ func TestService_Find(t *testing.T) {
 	assert.Equal(t, "some title, link", res[0].PostTitle)
 }
 
+func TestService_FindPinned(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	comment := store.Comment{
+		ID:        "id-3",
+		Text:      "some text3",
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator:   locator,
+		User:      store.User{ID: "user2", Name: "user name 2"},
+	}
+	_, err := b.Engine.Create(comment)
+	require.NoError(t, err)
+
+	require.NoError(t, b.SetPin(locator, "id-3", true))
+	require.NoError(t, b.SetPin(locator, "id-2", true))
+
+	for _, sortMethod := range []string{"time", "-time", "score", "-score"} {
+		res, err := b.Find(locator, sortMethod, store.User{})
+		require.NoError(t, err)
+		require.Equal(t, 3, len(res), sortMethod)
+		// pinned comments ordered by creation time
+		assert.Equal(t, "id-2", res[0].ID, sortMethod)
+		assert.Equal(t, "id-3", res[1].ID, sortMethod)
+		assert.Equal(t, "id-1", res[2].ID, sortMethod)
+	}
+
+	// site-wide listing is not affected by pins
+	res, err := b.Last("radio-t", 0, time.Time{}, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, "id-3", res[0].ID)
+	assert.Equal(t, "id-1", res[2].ID)
+}
+
+func TestService_FindModPinned(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	require.NoError(t, b.SetModPin(locator, "id-2", true))
+
+	res, err := b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "id-1", res[0].ID, "regular order for readers")
+	assert.False(t, res[1].Pin)
+	assert.False(t, res[1].PinModOnly)
+
+	res, err = b.Find(locator, "time", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "id-2", res[0].ID, "pinned first for moderators")
+	assert.True(t, res[0].Pin)
+	assert.True(t, res[0].PinModOnly)
+
+	// pinned again for everyone
+	require.NoError(t, b.SetPin(locator, "id-2", true))
+	res, err = b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, "id-2", res[0].ID)
+	assert.False(t, res[0].PinModOnly)
+
+	// un-pinned moderator's pin doesn't keep the flag
+	require.NoError(t, b.SetModPin(locator, "id-2", false))
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-2"})
+	require.NoError(t, err)
+	assert.False(t, c.Pin)
+	assert.False(t, c.PinModOnly)
+}
+
+func TestService_FindDepth(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// id-1 <- id-3 <- id-4 <- id-5, id-2 <- id-6, id-1 <- id-7
+	for i, pid := range []string{"id-1", "id-3", "id-4", "id-2", "id-1"} {
+		_, err := eng.Create(store.Comment{ID: fmt.Sprintf("id-%d", i+3), ParentID: pid, Text: fmt.Sprintf("reply %d", i+3),
+			Timestamp: time.Date(2018, 12, 20, 15, 18, 22+i, 0, time.Local), Locator: locator,
+			User: store.User{ID: "user2", Name: "user name 2"}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(locator, "id-3", store.SoftDelete))
+	expected := map[string]int{"id-1": 0, "id-2": 0, "id-3": 1, "id-4": 2, "id-5": 3, "id-6": 1, "id-7": 1}
+
+	res, err := b.Find(locator, "-time", store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 7)
+	for _, c := range res {
+		assert.Equal(t, expected[c.ID], c.Depth, c.ID)
+	}
+
+	tree := MakeTree(res, "time")
+	require.Len(t, tree.Nodes, 2)
+	assert.Equal(t, 0, tree.Nodes[0].Comment.Depth)
+	assert.Equal(t, "id-5", tree.Nodes[0].Replies[0].Replies[0].Replies[0].Comment.ID)
+	assert.Equal(t, 3, tree.Nodes[0].Replies[0].Replies[0].Replies[0].Comment.Depth)
+
+	// comments of the whole site have no depth
+	res, err = b.Last("radio-t", 10, time.Time{}, store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 6, "deleted excluded")
+	for _, c := range res {
+		assert.Equal(t, 0, c.Depth, c.ID)
+	}
+}
+
+func TestService_Unsubscribed(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	assert.False(t, b.IsUnsubscribed(locator, "user1"))
+	assert.NoError(t, b.SetUnsubscribed(locator, "user1", true))
+	assert.True(t, b.IsUnsubscribed(locator, "user1"))
+	assert.False(t, b.IsUnsubscribed(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, "user1"))
+	assert.False(t, b.IsUnsubscribed(locator, "user2"))
+
+	assert.NoError(t, b.SetUnsubscribed(locator, "user1", false))
+	assert.False(t, b.IsUnsubscribed(locator, "user1"))
+
+	assert.Error(t, b.SetUnsubscribed(store.Locator{URL: "https://radio-t.com", SiteID: "bad"}, "user1", true))
+	assert.False(t, b.IsUnsubscribed(store.Locator{URL: "https://radio-t.com", SiteID: "bad"}, "user1"))
+}
+
 func TestService_FindSince(t *testing.T) {
 	// two comments for https://radio-t.com, no reply
 	eng, teardown := prepStoreEngine(t)
@@ -1544,6 +2222,59 @@ func TestService_List(t *testing.T) {
 	assert.Equal(t, time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local), res[1].LastTS)
 }
 
+func TestService_IterateComments(t *testing.T) {
+	// two comments for https://radio-t.com, no reply
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+
+	comment := store.Comment{
+		ID:        "id-3",
+		Timestamp: time.Date(2018, 12, 20, 15, 18, 22, 0, time.Local),
+		Text:      `some text, <a href="http://radio-t.com">link</a>`,
+		Locator:   store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"},
+		User:      store.User{ID: "user2", Name: "user name", IP: "127.0.0.1"},
+	}
+	_, err := eng.Create(comment)
+	require.NoError(t, err)
+
+	iterate := func(b *DataStore, user store.User) (res []store.Comment, err error) {
+		err = b.IterateComments("radio-t", user, func(c store.Comment) error {
+			res = append(res, c)
+			return nil
+		})
+		return res, err
+	}
+
+	// bolt engine iterates, engine without Iterate read by post
+	for _, e := range []engine.Interface{eng, struct{ engine.Interface }{eng}} {
+		b := &DataStore{Engine: e, AdminStore: admin.NewStaticKeyStore("secret 123")}
+		res, err := iterate(b, store.User{Admin: true})
+		require.NoError(t, err)
+		require.Equal(t, 3, len(res))
+		assert.Equal(t, "id-1", res[0].ID, "older post first")
+		assert.Equal(t, "id-2", res[1].ID)
+		assert.Equal(t, "id-3", res[2].ID)
+		assert.Equal(t, "127.0.0.1", res[2].User.IP, "not altered for admin")
+
+		res, err = iterate(b, store.User{})
+		require.NoError(t, err)
+		require.Equal(t, 3, len(res))
+		assert.Empty(t, res[2].User.IP, "altered for non-admin")
+
+		count := 0
+		err = b.IterateComments("radio-t", store.User{}, func(store.Comment) error {
+			count++
+			return errors.New("failed")
+		})
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, 1, count, "stopped on error")
+	}
+
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	err = b.IterateComments("bad", store.User{}, func(store.Comment) error { return nil })
+	assert.Error(t, err)
+}
+
 func TestService_Count(t *testing.T) {
 	// two comments for https://radio-t.com, no reply
 	eng, teardown := prepStoreEngine(t)
@@ -1880,6 +2611,41 @@ func TestService_alterComment(t *testing.T) {
 	assert.Equal(t, engine.FlagRequest{Flag: engine.Blocked, UserID: "devid"}, engineMock.FlagCalls()[0].Req)
 }
 
+func TestService_CollapseScore(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // id-1 and id-2 by user1
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), CollapseScore: -3}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	setScore := func(id string, score int) {
+		c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id})
+		require.NoError(t, err)
+		c.Score = score
+		require.NoError(t, b.Engine.Update(c))
+	}
+	setScore("id-1", -4)
+	setScore("id-2", -3)
+
+	res, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, "id-1", res[0].ID)
+	assert.True(t, res[0].Collapsed, "score below threshold collapsed")
+	assert.NotEmpty(t, res[0].Text, "collapsed comment still returned with text")
+	assert.Equal(t, "id-2", res[1].ID)
+	assert.False(t, res[1].Collapsed, "score at threshold not collapsed")
+
+	c, err := b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Collapsed)
+
+	b.CollapseScore = 0
+	c, err = b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Collapsed, "collapsing disabled")
+}
+
 func Benchmark_ServiceCreate(b *testing.B) {
 	dbFile := fmt.Sprintf("%s/test-remark42-%d.db", os.TempDir(), rand.Intn(9999999999))
 	defer func() { _ = os.Remove(dbFile) }()
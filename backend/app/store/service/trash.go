@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ErrNotTrashed returned on restore of comment not in trash, i.e. not deleted, already purged or deleted with trash disabled
+var ErrNotTrashed = errors.New("comment not in trash")
+
+// toTrash keeps deleted comment as it was before deletion in the stored deleted one, till restored or purged
+func (s *DataStore) toTrash(locator store.Locator, comment store.Comment) error {
+	deleted, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: comment.ID})
+	if err != nil {
+		return fmt.Errorf("can't get deleted comment %s: %w", comment.ID, err)
+	}
+	comment.Trashed = nil
+	deleted.Trashed = &store.Trashed{Timestamp: time.Now(), Comment: comment}
+	deleted.Locator = locator
+	if err = s.Engine.Update(deleted); err != nil {
+		return fmt.Errorf("can't keep comment %s in trash: %w", comment.ID, err)
+	}
+	return nil
+}
+
+// Restore brings back comment from trash as it was before deletion, with text, votes and pin
+func (s *DataStore) Restore(locator store.Locator, commentID string) (store.Comment, error) {
+	restorer, ok := s.Engine.(engine.Restorer)
+	if !ok {
+		return store.Comment{}, fmt.Errorf("restore of comments not supported by the store engine")
+	}
+
+	cLock := s.getScopedLocks(locator.URL)
+	cLock.Lock()
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		cLock.Unlock()
+		return store.Comment{}, err
+	}
+	if !comment.Deleted || comment.Trashed == nil {
+		cLock.Unlock()
+		return store.Comment{}, fmt.Errorf("%w: %s", ErrNotTrashed, commentID)
+	}
+	restored := comment.Trashed.Comment
+	restored.Locator = locator
+	err = restorer.Restore(restored)
+	cLock.Unlock()
+	if err != nil {
+		return store.Comment{}, fmt.Errorf("can't restore comment %s: %w", commentID, err)
+	}
+
+	if s.repliesCache.LoadingCache != nil {
+		s.repliesCache.Delete(restored.ParentID)
+	}
+	log.Printf("[INFO] comment %s restored from trash", commentID)
+	return s.Get(locator, commentID, nonAdminUser)
+}
+
+// PurgeTrash drops comments of the site kept in trash longer than TrashTTL, they can't be restored after.
+// Images of purged comments deleted if not used by other comments of the post. Returns number of purged comments.
+func (s *DataStore) PurgeTrash(siteID string) (int, error) {
+	if s.TrashTTL <= 0 {
+		return 0, nil
+	}
+	posts, err := s.List(siteID, 0, 0)
+	if err != nil {
+		return 0, fmt.Errorf("can't list posts of %s: %w", siteID, err)
+	}
+	cutoff := time.Now().Add(-s.TrashTTL)
+	purged := 0
+	for _, post := range posts {
+		locator := store.Locator{SiteID: siteID, URL: post.URL}
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: locator})
+		if e != nil {
+			return purged, fmt.Errorf("can't get comments of %s: %w", post.URL, e)
+		}
+		for _, c := range comments {
+			if c.Trashed == nil || c.Trashed.Timestamp.After(cutoff) {
+				continue
+			}
+			if e = s.purge(locator, c); e != nil {
+				return purged, e
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// purge drops trashed content of the deleted comment and images used by it
+func (s *DataStore) purge(locator store.Locator, comment store.Comment) error {
+	cLock := s.getScopedLocks(locator.URL)
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	s.deleteImages(locator, comment.Trashed.Comment)
+	comment.Trashed = nil
+	comment.Locator = locator
+	if err := s.Engine.Update(comment); err != nil {
+		return fmt.Errorf("can't purge comment %s from trash: %w", comment.ID, err)
+	}
+	return nil
+}
+
+// TrashPurge purges comments kept in trash longer than DataStore.TrashTTL periodically
+type TrashPurge struct {
+	DataStore *DataStore
+	Sites     []string
+	Interval  time.Duration
+}
+
+// Do purges trash of all sites on start and with Interval after, till context canceled
+func (p TrashPurge) Do(ctx context.Context) {
+	log.Printf("[INFO] activate trash purge for %v, kept for %v", p.Sites, p.DataStore.TrashTTL)
+	tick := time.NewTicker(p.Interval)
+	defer tick.Stop()
+
+	for {
+		for _, siteID := range p.Sites {
+			purged, err := p.DataStore.PurgeTrash(siteID)
+			if err != nil {
+				log.Printf("[WARN] trash purge for %s failed, %v", siteID, err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("[INFO] purged %d comments from trash of %s", purged, siteID)
+			}
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			log.Printf("[WARN] terminated trash purge")
+			return
+		}
+	}
+}
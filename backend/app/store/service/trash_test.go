@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/image"
+)
+
+func TestService_TrashRestore(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1, TrashTTL: time.Hour}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-2", UserID: "user2", Val: true})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	c, err := b.Get(locator, "id-2", store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+	assert.Equal(t, "", c.Text)
+	assert.Nil(t, c.Trashed, "trash not shown to readers")
+	c, err = b.Get(locator, "id-2", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	require.NotNil(t, c.Trashed, "trash shown to moderators")
+	assert.Equal(t, "some text2", c.Trashed.Comment.Text)
+	assert.WithinDuration(t, time.Now(), c.Trashed.Timestamp, time.Second)
+	count, err := b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete), "second delete keeps trash")
+	restored, err := b.Restore(locator, "id-2")
+	require.NoError(t, err)
+	assert.False(t, restored.Deleted)
+	assert.Equal(t, "some text2", restored.Text)
+	assert.Equal(t, 1, restored.Score, "votes restored")
+	assert.Nil(t, restored.Trashed)
+	count, err = b.Count(locator)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	last, err := b.Last("radio-t", 0, time.Time{}, store.User{})
+	require.NoError(t, err)
+	assert.Len(t, last, 2)
+
+	_, err = b.Restore(locator, "id-2")
+	assert.ErrorIs(t, err, ErrNotTrashed, "not deleted")
+
+	// hard delete drops trash
+	require.NoError(t, b.Delete(locator, "id-1", store.SoftDelete))
+	require.NoError(t, b.Delete(locator, "id-1", store.HardDelete))
+	_, err = b.Restore(locator, "id-1")
+	assert.ErrorIs(t, err, ErrNotTrashed)
+
+	// no trash if disabled
+	b.TrashTTL = 0
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	_, err = b.Restore(locator, "id-2")
+	assert.ErrorIs(t, err, ErrNotTrashed)
+}
+
+func TestService_TrashPurge(t *testing.T) {
+	var lock sync.Mutex
+	deletedImages := []string{}
+	imgSvc := image.NewService(&image.StoreMock{
+		DeleteFunc: func(id string) error {
+			lock.Lock()
+			defer lock.Unlock()
+			deletedImages = append(deletedImages, id)
+			return nil
+		},
+	}, image.ServiceParams{ImageAPI: "/images/dev/", ProxyAPI: "/non_existent"})
+	defer imgSvc.Close(context.TODO())
+
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), ImageService: imgSvc,
+		TrashTTL: 100 * time.Millisecond}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := eng.Create(store.Comment{ID: "id-3", Text: `pic <img src="/images/dev/user1/pic1.png"/>`, Locator: locator,
+		User: store.User{ID: "user1"}, Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local)})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Delete(locator, "id-3", store.SoftDelete))
+	assert.Empty(t, deletedImages, "images kept in trash")
+	purged, err := b.PurgeTrash("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged, "not expired yet")
+
+	time.Sleep(150 * time.Millisecond)
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	purged, err = b.PurgeTrash("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, []string{"user1/pic1.png"}, deletedImages, "images deleted on purge")
+
+	_, err = b.Restore(locator, "id-3")
+	assert.ErrorIs(t, err, ErrNotTrashed, "purged")
+	c, err := b.Get(locator, "id-3", store.User{Admin: true})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+	assert.Nil(t, c.Trashed)
+	_, err = b.Restore(locator, "id-2")
+	assert.NoError(t, err, "trashed recently")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	done := make(chan struct{})
+	go func() {
+		TrashPurge{DataStore: &b, Sites: []string{"radio-t"}, Interval: 10 * time.Millisecond}.Do(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		c, e := b.Get(locator, "id-2", store.User{Admin: true})
+		return e == nil && c.Trashed == nil
+	}, time.Second, 20*time.Millisecond, "purged in background")
+	cancel()
+	<-done
+}
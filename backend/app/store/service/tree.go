@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
 )
 
 // Tree is formatter making tree from the list of comments
@@ -17,6 +18,7 @@ type Tree struct {
 type Node struct {
 	Comment    store.Comment `json:"comment"`
 	Replies    []*Node       `json:"replies,omitempty"`
+	Omitted    int           `json:"omitted_replies,omitempty"` // replies left out of the capped tree, see Cap
 	tsModified time.Time
 	tsCreated  time.Time
 }
@@ -100,9 +102,20 @@ func (t *Tree) filter(comments []store.Comment, fn func(comment store.Comment) b
 }
 
 // sort list of nodes, i.e. top-level comments
-// time sort uses tsModified from latest reply
+// time sort uses tsModified from latest reply. Pinned comments always go first, ordered by creation time
 func (t *Tree) sortNodes(sortType string) {
 	sort.Slice(t.Nodes, func(i, j int) bool {
+		if t.Nodes[i].Comment.Pin || t.Nodes[j].Comment.Pin {
+			if t.Nodes[i].Comment.Pin && t.Nodes[j].Comment.Pin {
+				return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+			}
+			return t.Nodes[i].Comment.Pin
+		}
+
+		if less, ok := engine.ReactionsLess(t.Nodes[i].Comment, t.Nodes[j].Comment, sortType); ok {
+			return less
+		}
+
 		switch sortType {
 		case "+time", "-time", "time":
 			if strings.HasPrefix(sortType, "-") {
@@ -145,3 +158,85 @@ func (t *Tree) sortNodes(sortType string) {
 		}
 	})
 }
+
+// Cap limits the tree to maxComments comments by leaving out replies of the oldest top-level comments, one thread
+// after another, until the rest fits. Replies of such comment dropped with their number kept in Omitted, to be loaded
+// on demand. Top-level comments never left out, so the cap is soft. Returns number of comments left out, nothing
+// done if maxComments is 0.
+func (t *Tree) Cap(maxComments int) int {
+	total := len(t.Nodes)
+	for _, n := range t.Nodes {
+		total += countReplies(n)
+	}
+	if maxComments <= 0 || total <= maxComments {
+		return 0
+	}
+
+	oldest := make([]*Node, len(t.Nodes))
+	copy(oldest, t.Nodes)
+	sort.SliceStable(oldest, func(i, j int) bool { return oldest[i].Comment.Timestamp.Before(oldest[j].Comment.Timestamp) })
+	omitted := 0
+	for _, n := range oldest {
+		if total-omitted <= maxComments {
+			break
+		}
+		if len(n.Replies) == 0 {
+			continue
+		}
+		n.Omitted = countReplies(n)
+		n.Replies = nil
+		omitted += n.Omitted
+	}
+	return omitted
+}
+
+// CollapsedNode is a comment with the number of its replies, replies themselves not included
+type CollapsedNode struct {
+	Comment store.Comment `json:"comment"`
+	Replies int           `json:"replies"`       // number of direct replies
+	Total   int           `json:"total_replies"` // number of all replies in the subtree
+}
+
+// Collapse returns top-level comments of the tree with replies replaced by counts
+func (t *Tree) Collapse() []CollapsedNode {
+	return collapseNodes(t.Nodes)
+}
+
+// CollapseReplies returns direct replies to the comment with parentID, collapsed the same way as top-level comments.
+// Returns false if there is no such comment in the tree.
+func (t *Tree) CollapseReplies(parentID string) ([]CollapsedNode, bool) {
+	if node := findNode(t.Nodes, parentID); node != nil {
+		return collapseNodes(node.Replies), true
+	}
+	return nil, false
+}
+
+func collapseNodes(nodes []*Node) []CollapsedNode {
+	res := make([]CollapsedNode, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, CollapsedNode{Comment: n.Comment, Replies: len(n.Replies), Total: countReplies(n)})
+	}
+	return res
+}
+
+// countReplies returns number of all replies in the subtree of the node
+func countReplies(node *Node) int {
+	res := len(node.Replies)
+	for _, r := range node.Replies {
+		res += countReplies(r)
+	}
+	return res
+}
+
+// findNode looks for the node with comment id in nodes and all their replies
+func findNode(nodes []*Node, id string) *Node {
+	for _, n := range nodes {
+		if n.Comment.ID == id {
+			return n
+		}
+		if res := findNode(n.Replies, id); res != nil {
+			return res
+		}
+	}
+	return nil
+}
@@ -84,6 +84,97 @@ func TestMakeEmptySubtree(t *testing.T) {
 	assert.Equal(t, string(expJSON), string(resJSON))
 }
 
+func TestTreeCollapse(t *testing.T) {
+	ts := func(min, sec int) time.Time { return time.Date(2017, 12, 25, 19, min, sec, 0, time.UTC) }
+	comments := []store.Comment{
+		{ID: "1", Timestamp: ts(46, 1)},
+		{ID: "11", ParentID: "1", Timestamp: ts(46, 11)},
+		{ID: "12", ParentID: "1", Timestamp: ts(46, 12)},
+		{ID: "121", ParentID: "12", Timestamp: ts(46, 21)},
+		{ID: "122", ParentID: "12", Deleted: true}, // deleted leaf, removed from the tree
+		{ID: "1211", ParentID: "121", Timestamp: ts(46, 31)},
+		{ID: "2", Timestamp: ts(47, 1)},
+		{ID: "3", Timestamp: ts(48, 1), Deleted: true},
+		{ID: "31", ParentID: "3", Timestamp: ts(48, 11)},
+	}
+	tree := MakeTree(comments, "time")
+
+	res := tree.Collapse()
+	require.Len(t, res, 3)
+	assert.Equal(t, "1", res[0].Comment.ID)
+	assert.Equal(t, 2, res[0].Replies)
+	assert.Equal(t, 4, res[0].Total)
+	assert.Equal(t, "2", res[1].Comment.ID)
+	assert.Equal(t, 0, res[1].Replies)
+	assert.Equal(t, 0, res[1].Total)
+	assert.Equal(t, "3", res[2].Comment.ID, "deleted with replies kept")
+	assert.Equal(t, 1, res[2].Replies)
+
+	res, ok := tree.CollapseReplies("1")
+	require.True(t, ok)
+	require.Len(t, res, 2)
+	assert.Equal(t, CollapsedNode{Comment: comments[1]}, res[0])
+	assert.Equal(t, CollapsedNode{Comment: comments[2], Replies: 1, Total: 2}, res[1])
+
+	res, ok = tree.CollapseReplies("121")
+	require.True(t, ok)
+	assert.Equal(t, []CollapsedNode{{Comment: comments[5]}}, res)
+
+	res, ok = tree.CollapseReplies("2")
+	require.True(t, ok)
+	assert.Empty(t, res)
+
+	_, ok = tree.CollapseReplies("122")
+	assert.False(t, ok, "deleted leaf not in the tree")
+	_, ok = tree.CollapseReplies("bad")
+	assert.False(t, ok)
+}
+
+func TestTreeCap(t *testing.T) {
+	ts := func(min, sec int) time.Time { return time.Date(2017, 12, 25, 19, min, sec, 0, time.UTC) }
+	comments := []store.Comment{
+		{ID: "1", Timestamp: ts(46, 1)},
+		{ID: "11", ParentID: "1", Timestamp: ts(46, 11)},
+		{ID: "111", ParentID: "11", Timestamp: ts(46, 21)},
+		{ID: "2", Timestamp: ts(47, 1)},
+		{ID: "21", ParentID: "2", Timestamp: ts(47, 11)},
+		{ID: "3", Timestamp: ts(48, 1)},
+		{ID: "31", ParentID: "3", Timestamp: ts(48, 11)},
+		{ID: "32", ParentID: "3", Timestamp: ts(48, 12)},
+		{ID: "4", Timestamp: ts(49, 1)},
+	}
+
+	tree := MakeTree(comments, "-time")
+	assert.Equal(t, 0, tree.Cap(0), "unlimited")
+	assert.Equal(t, 0, tree.Cap(9), "fits")
+	assert.Equal(t, 2, countReplies(tree.Nodes[3]))
+
+	tree = MakeTree(comments, "-time")
+	assert.Equal(t, 3, tree.Cap(6), "replies of the oldest threads omitted")
+	require.Len(t, tree.Nodes, 4)
+	assert.Equal(t, []string{"4", "3", "2", "1"}, []string{tree.Nodes[0].Comment.ID, tree.Nodes[1].Comment.ID,
+		tree.Nodes[2].Comment.ID, tree.Nodes[3].Comment.ID}, "order of threads kept")
+	assert.Nil(t, tree.Nodes[3].Replies)
+	assert.Equal(t, 2, tree.Nodes[3].Omitted)
+	assert.Equal(t, 1, tree.Nodes[2].Omitted)
+	assert.Nil(t, tree.Nodes[2].Replies)
+	assert.Len(t, tree.Nodes[1].Replies, 2, "replies of newer thread kept")
+	assert.Equal(t, 0, tree.Nodes[1].Omitted)
+
+	tree = MakeTree(comments, "time")
+	assert.Equal(t, 5, tree.Cap(2), "top-level comments always kept")
+	require.Len(t, tree.Nodes, 4)
+	for _, n := range tree.Nodes {
+		assert.Empty(t, n.Replies)
+	}
+	assert.Equal(t, 0, tree.Nodes[3].Omitted, "thread without replies")
+
+	data, err := json.Marshal(tree.Nodes[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"omitted_replies":2`)
+	assert.NotContains(t, string(data), `"replies"`)
+}
+
 func TestTreeSortNodes(t *testing.T) {
 	// unsorted by purpose
 	comments := []store.Comment{
@@ -152,6 +243,55 @@ func TestTreeSortNodes(t *testing.T) {
 	assert.Equal(t, "1", res.Nodes[0].Comment.ID)
 }
 
+func TestTreeSortNodesPinned(t *testing.T) {
+	ts := func(min int) time.Time { return time.Date(2017, 12, 25, 19, min, 0, 0, time.UTC) }
+	comments := []store.Comment{
+		{ID: "1", Timestamp: ts(1), Score: 5},
+		{ID: "2", Timestamp: ts(2), Score: 1, Pin: true},
+		{ID: "3", Timestamp: ts(3), Score: 10},
+		{ID: "4", Timestamp: ts(4), Score: -1, Pin: true},
+		{ID: "41", ParentID: "4", Timestamp: ts(5)},
+	}
+
+	for _, sortType := range []string{"time", "-time", "-score", "+score", "-active", "-controversy"} {
+		res := MakeTree(comments, sortType)
+		require.Equal(t, 4, len(res.Nodes), sortType)
+		assert.Equal(t, "2", res.Nodes[0].Comment.ID, sortType)
+		assert.Equal(t, "4", res.Nodes[1].Comment.ID, sortType)
+	}
+
+	res := MakeTree(comments, "-score")
+	assert.Equal(t, "3", res.Nodes[2].Comment.ID)
+	assert.Equal(t, "1", res.Nodes[3].Comment.ID)
+
+	res = MakeTree(comments, "-time")
+	assert.Equal(t, "3", res.Nodes[2].Comment.ID)
+	assert.Equal(t, "1", res.Nodes[3].Comment.ID)
+}
+
+func TestTreeSortNodesReactions(t *testing.T) {
+	ts := func(min int) time.Time { return time.Date(2017, 12, 25, 19, min, 0, 0, time.UTC) }
+	comments := []store.Comment{
+		{ID: "1", Timestamp: ts(1), Reactions: map[string]int{"👍": 1}},
+		{ID: "2", Timestamp: ts(2), Reactions: map[string]int{"👍": 1, "🎉": 2}},
+		{ID: "21", ParentID: "2", Timestamp: ts(3), Reactions: map[string]int{"👍": 10}},
+		{ID: "3", Timestamp: ts(4), Reactions: map[string]int{"🎉": 1}},
+		{ID: "4", Timestamp: ts(5)},
+	}
+
+	ids := func(tr *Tree) (res []string) {
+		for _, n := range tr.Nodes {
+			res = append(res, n.Comment.ID)
+		}
+		return res
+	}
+
+	assert.Equal(t, []string{"2", "1", "3", "4"}, ids(MakeTree(comments, "-reactions")), "replies' reactions not counted")
+	assert.Equal(t, []string{"4", "1", "3", "2"}, ids(MakeTree(comments, "+reactions")))
+	assert.Equal(t, []string{"2", "3", "1", "4"}, ids(MakeTree(comments, "-reactions:🎉")))
+	assert.Equal(t, []string{"1", "2", "3", "4"}, ids(MakeTree(comments, "-reactions:👍")), "ties broken by time")
+}
+
 func BenchmarkTree(b *testing.B) {
 	comments := []store.Comment{}
 	data, err := os.ReadFile("testdata/tree_bench.json")
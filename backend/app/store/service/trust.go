@@ -0,0 +1,57 @@
+package service
+
+import (
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// trust levels of comment authors, set by the number and age of user's published comments on the site
+const (
+	TrustNew     = "new"     // less than NewUserComments published, comments held for review
+	TrustBasic   = "basic"   // comments with restricted words held for review
+	TrustTrusted = "trusted" // comments never held for review
+)
+
+// TrustLevels defines requirements of the trusted level, users between new and trusted are basic
+type TrustLevels struct {
+	TrustedComments int           // published comments required to be trusted, trusted level disabled if 0
+	TrustedAge      time.Duration // min age of the first published comment of trusted user
+}
+
+// TrustLevel returns trust level of the user on the site. User with less than NewUserComments published comments
+// is new, with at least TrustedComments and the first comment older than TrustedAge is trusted, basic otherwise.
+// Admins are never new. Engine fails to count comments of user without any, so user has no comments on error.
+func (s *DataStore) TrustLevel(siteID string, user store.User) string {
+	if s.NewUserComments <= 0 && s.Trust.TrustedComments <= 0 {
+		return TrustBasic
+	}
+	count, err := s.UserCount(siteID, user.ID)
+	if err != nil {
+		log.Printf("[DEBUG] can't get comments count for %s, %v", user.ID, err)
+		count = 0
+	}
+	if count < s.NewUserComments && !user.Admin {
+		return TrustNew
+	}
+	if s.Trust.TrustedComments <= 0 || count < s.Trust.TrustedComments {
+		return TrustBasic
+	}
+	if s.Trust.TrustedAge <= 0 {
+		return TrustTrusted
+	}
+
+	// user's comments sorted from the newest, the first one is the last
+	first, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: user.ID, Limit: 1, Skip: count - 1})
+	if err != nil || len(first) == 0 {
+		log.Printf("[DEBUG] can't get the first comment of %s, %v", user.ID, err)
+		return TrustBasic
+	}
+	if time.Since(first[0].Timestamp) < s.Trust.TrustedAge {
+		return TrustBasic
+	}
+	return TrustTrusted
+}
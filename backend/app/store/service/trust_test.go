@@ -0,0 +1,90 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_TrustLevel(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // user1 has two comments stored already
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	defer b.Close()
+	user1, newUser := store.User{ID: "user1", Name: "user name"}, store.User{ID: "user-new", Name: "new user"}
+
+	assert.Equal(t, TrustBasic, b.TrustLevel("radio-t", user1), "basic without levels set")
+	assert.Equal(t, TrustBasic, b.TrustLevel("radio-t", newUser))
+	assert.Equal(t, TrustBasic, b.TrustLevel("radio-t", store.User{ID: "admin", Admin: true}))
+
+	b.NewUserComments = 2
+	assert.Equal(t, TrustBasic, b.TrustLevel("radio-t", user1))
+	assert.Equal(t, TrustNew, b.TrustLevel("radio-t", newUser), "no comments")
+	assert.Equal(t, TrustNew, b.TrustLevel("other-site", user1), "no comments on the site")
+	assert.Equal(t, TrustBasic, b.TrustLevel("radio-t", store.User{ID: "admin-new", Admin: true}), "admin never new")
+
+	b.Trust = TrustLevels{TrustedComments: 2}
+	assert.Equal(t, TrustTrusted, b.TrustLevel("radio-t", user1))
+	assert.Equal(t, TrustNew, b.TrustLevel("radio-t", newUser))
+
+	b.Trust = TrustLevels{TrustedComments: 3}
+	assert.Equal(t, TrustBasic, b.TrustLevel("radio-t", user1), "not enough comments")
+
+	firstAge := time.Since(time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)) // id-1 is the first comment of user1
+	b.Trust = TrustLevels{TrustedComments: 2, TrustedAge: firstAge + time.Hour}
+	assert.Equal(t, TrustBasic, b.TrustLevel("radio-t", user1), "first comment too fresh")
+
+	b.Trust = TrustLevels{TrustedComments: 2, TrustedAge: firstAge - time.Hour}
+	assert.Equal(t, TrustTrusted, b.TrustLevel("radio-t", user1), "first comment old enough")
+}
+
+func TestService_CreateByTrustLevel(t *testing.T) {
+	eng, teardown := prepStoreEngine(t) // user1 has two comments stored already
+	defer teardown()
+	reviewer := &mockReviewer{}
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reviewer: reviewer,
+		RestrictedWordsMatcher: NewRestrictedWordsMatcher(StaticRestrictedWordsLister{Words: []string{"duck"}}),
+		NewUserComments:        1, Trust: TrustLevels{TrustedComments: 2, TrustedAge: time.Hour}}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	comment := func(id, text, userID string) store.Comment {
+		return store.Comment{ID: id, Text: text, Locator: locator, User: store.User{ID: userID, Name: "name " + userID}}
+	}
+
+	// trusted user skips pre-moderation
+	trusted := comment("c-1", "what the duck", "user1")
+	trusted.User.Trust = TrustTrusted
+	id, err := b.Create(trusted)
+	require.NoError(t, err, "trusted user's comment with restricted words not held")
+	assert.Equal(t, "c-1", id)
+	assert.Empty(t, reviewer.reqs())
+
+	// new user held
+	_, err = b.Create(comment("c-2", "hello", "user2"))
+	require.ErrorIs(t, err, ErrCommentHeld)
+	require.Len(t, reviewer.reqs(), 1)
+	_, err = b.ReviewHeld("radio-t", "c-2", reviewer.reqs()[0].Token, true)
+	require.NoError(t, err)
+
+	// basic user published, held with restricted words
+	_, err = b.Create(comment("c-3", "hello again", "user2"))
+	require.NoError(t, err)
+	_, err = b.Create(comment("c-4", "what the duck", "user2"))
+	assert.ErrorIs(t, err, ErrCommentHeld)
+	assert.Len(t, reviewer.reqs(), 2)
+
+	// rejected without reviewer regardless of trust
+	b.Reviewer = nil
+	_, err = b.Create(comment("c-5", "what the duck", "user1"))
+	assert.ErrorIs(t, err, ErrRestrictedWordsFound)
+
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "c-1"})
+	require.NoError(t, err)
+	assert.Empty(t, c.User.Trust, "trust level not stored")
+}
@@ -8,6 +8,7 @@ import (
 	"hash"
 	"hash/crc64"
 	"io"
+	"net"
 	"regexp"
 
 	log "github.com/go-pkgz/lgr"
@@ -25,8 +26,20 @@ type User struct {
 	EmailSubscription bool   `json:"email_subscription,omitempty"`
 	SiteID            string `json:"site_id,omitempty"`
 	PaidSub           bool   `json:"paid_sub,omitempty"`
+	EmailVerified     bool   `json:"email_verified,omitempty"`
+	Trust             string `json:"trust,omitempty"` // trust level on the site set in token, not stored with comments
 }
 
+// NotifyPrefs defines what user notified about on the site
+type NotifyPrefs struct {
+	Replies  bool `json:"replies"`  // replies to user's comments
+	Mentions bool `json:"mentions"` // @mentions of the user in comments
+	Digest   bool `json:"digest"`   // email notifications collected and sent as a single digest
+}
+
+// DefaultNotifyPrefs used for users without notification preferences set
+var DefaultNotifyPrefs = NotifyPrefs{Replies: true, Mentions: true}
+
 var reValidSha = regexp.MustCompile("^[a-fA-F0-9]{40}$")
 var reValidCrc64 = regexp.MustCompile("^[a-fA-F0-9]{16}$")
 
@@ -35,6 +48,38 @@ func (u *User) HashIP(secret string) {
 	u.IP = HashValue(u.IP, secret)
 }
 
+// IPMode defines how client IP anonymized before persistence
+type IPMode string
+
+// enum of all IP anonymization modes
+const (
+	IPHash     IPMode = "hash"     // hmac of the whole address, default
+	IPTruncate IPMode = "truncate" // the last octet of IPv4 or the last 80 bits of IPv6 zeroed
+)
+
+// AnonymizeIP makes stored representation of IP, stable for the same IP and secret, so it can be compared
+// with anonymized IP of another request. Invalid IP hashed in truncate mode, as the raw value is never stored.
+func AnonymizeIP(ip string, mode IPMode, secret string) string {
+	if mode == IPTruncate {
+		if res := truncateIP(ip); res != "" {
+			return res
+		}
+	}
+	return HashValue(ip, secret)
+}
+
+// truncateIP zeroes the last octet of IPv4 and keeps /48 network of IPv6, empty string for invalid IP
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
 // HashValue makes hmac with secret
 func HashValue(val, secret string) string {
 	key := []byte(secret)
@@ -46,6 +46,35 @@ func TestUser_HashIP(t *testing.T) {
 	}
 }
 
+func TestAnonymizeIP(t *testing.T) {
+	tbl := []struct {
+		ip       string
+		mode     IPMode
+		expected string
+	}{
+		{"8.8.8.8", IPTruncate, "8.8.8.0"},
+		{"192.168.1.254", IPTruncate, "192.168.1.0"},
+		{"192.168.1.0", IPTruncate, "192.168.1.0"},
+		{"2001:db8:85a3:8d3:1319:8a2e:370:7348", IPTruncate, "2001:db8:85a3::"},
+		{"::ffff:10.1.2.3", IPTruncate, "10.1.2.0"},
+		{"bad ip", IPTruncate, "2ca5f80a7a25458d1d88e03479f6d36a5940040f"},
+		{"", IPTruncate, "823688dafca7393d24c871a2da98a84d8732e927"},
+		{"8.8.8.8", IPHash, "70a46afce9633f010b06e129b8ad08243a1c4da9"},
+		{"8.8.8.8", "", "70a46afce9633f010b06e129b8ad08243a1c4da9"},
+		{"70a46afce9633f010b06e129b8ad08243a1c4da9", IPHash, "70a46afce9633f010b06e129b8ad08243a1c4da9"},
+	}
+	for i, tt := range tbl {
+		res := AnonymizeIP(tt.ip, tt.mode, "123456")
+		assert.Equal(t, tt.expected, res, "case #%d", i)
+		assert.Equal(t, res, AnonymizeIP(tt.ip, tt.mode, "123456"), "stable, case #%d", i)
+	}
+
+	assert.Equal(t, AnonymizeIP("8.8.8.1", IPTruncate, ""), AnonymizeIP("8.8.8.2", IPTruncate, ""), "same network")
+	assert.NotEqual(t, AnonymizeIP("8.8.8.8", IPHash, "secret1"), AnonymizeIP("8.8.8.8", IPHash, "secret2"),
+		"hash depends on the secret")
+	assert.NotContains(t, AnonymizeIP("8.8.8.8", IPHash, "secret1"), "8.8.8.8")
+}
+
 func TestUser_HashFailed(t *testing.T) {
 	r := hashWithFallback(mockHash{}, "123456789")
 	assert.Equal(t, "995dc9bbdf1939fa", r)
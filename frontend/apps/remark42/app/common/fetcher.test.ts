@@ -4,7 +4,7 @@ jest.mock('./settings', () => ({
 
 import { RequestError } from 'utils/errorUtils';
 import { API_BASE, BASE_URL } from './constants.config';
-import { apiFetcher, authFetcher, adminFetcher, JWT_HEADER } from './fetcher';
+import { apiFetcher, authFetcher, adminFetcher, JWT_HEADER, XSRF_HEADER, siteCookieName } from './fetcher';
 
 type FetchImplementationProps = {
   status?: number;
@@ -127,6 +127,34 @@ describe('fetcher', () => {
     });
   });
 
+  describe('xsrf', () => {
+    afterEach(() => {
+      document.cookie = 'XSRF-TOKEN=; expires=Thu, 01 Jan 1970 00:00:00 GMT';
+      document.cookie = 'XSRF-TOKEN_remark=; expires=Thu, 01 Jan 1970 00:00:00 GMT';
+    });
+
+    it('should send xsrf token of the site', async () => {
+      expect.assertions(1);
+      document.cookie = 'XSRF-TOKEN=other-site';
+      document.cookie = 'XSRF-TOKEN_remark=site';
+      mockFetch();
+      await apiFetcher.get(apiUri);
+      expect(window.fetch).toHaveBeenCalledWith(apiUrl, { method: 'get', headers: { [XSRF_HEADER]: 'site' } });
+    });
+
+    it('should send xsrf token without site cookie', async () => {
+      expect.assertions(1);
+      document.cookie = 'XSRF-TOKEN=plain';
+      mockFetch();
+      await apiFetcher.get(apiUri);
+      expect(window.fetch).toHaveBeenCalledWith(apiUrl, { method: 'get', headers: { [XSRF_HEADER]: 'plain' } });
+    });
+
+    it('should make site cookie name', () => {
+      expect(siteCookieName('XSRF-TOKEN', 'my site;=1ы')).toBe('XSRF-TOKEN_my_site__1_');
+    });
+  });
+
   describe('send data', () => {
     it('should send JSON', async () => {
       expect.assertions(1);
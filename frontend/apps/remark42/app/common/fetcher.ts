@@ -12,6 +12,9 @@ export const XSRF_HEADER = 'X-XSRF-TOKEN';
 /** Cookie field with XSRF token */
 export const XSRF_COOKIE = 'XSRF-TOKEN';
 
+/** Name of the site's cookie, the same as made by the server, with characters not allowed in the name replaced */
+export const siteCookieName = (name: string, site: string) => `${name}_${site.replace(/[^a-zA-Z0-9.-]/gu, '_')}`;
+
 type QueryParams = Record<string, string | number | undefined>;
 type Payload = BodyInit | Record<string, unknown> | null;
 type BodylessMethod = <T>(url: string, query?: QueryParams) => Promise<T>;
@@ -48,7 +51,8 @@ const createFetcher = (baseUrl: string = ''): Methods => {
 
     // An HTTP header cannot be empty.
     // Although some webservers allow this (nginx, Apache), others answer 400 Bad Request (lighttpd).
-    const xsrfToken = getCookie(XSRF_COOKIE);
+    // XSRF cookie is named per site if the server keeps JWT cookies of sites apart
+    const xsrfToken = getCookie(siteCookieName(XSRF_COOKIE, siteId)) ?? getCookie(XSRF_COOKIE);
     if (xsrfToken !== undefined) {
       headers[XSRF_HEADER] = xsrfToken;
     }
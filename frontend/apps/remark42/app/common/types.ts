@@ -8,6 +8,8 @@ export type User = {
   verified: boolean;
   email_subscription?: boolean;
   paid_sub?: boolean;
+  /** user has confirmed email, set by the server */
+  email_verified?: boolean;
 };
 
 /** data which is used on user-info page */
@@ -100,6 +102,7 @@ export type OAuthProvider =
   | 'microsoft'
   | 'patreon'
   | 'telegram'
+  | 'oidc'
   | 'dev';
 export type FormProvider = 'email' | 'anonymous';
 export type Provider = OAuthProvider | FormProvider;
@@ -21,6 +21,13 @@ export const OAUTH_DATA = {
     },
   },
   telegram: require('assets/social/telegram.svg').default as string,
+  oidc: {
+    name: 'OpenID',
+    icons: {
+      light: require('assets/social/oidc.svg').default as string,
+      dark: require('assets/social/oidc.svg').default as string,
+    },
+  },
 } as const;
 
 export const OAUTH_PROVIDERS = Object.keys(OAUTH_DATA);
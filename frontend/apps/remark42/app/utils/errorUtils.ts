@@ -90,6 +90,18 @@ export const errorMessages = defineMessages<string | number>({
     id: 'errors.20',
     defaultMessage: 'Posted image not found. Please try to upload it again.',
   },
+  21: {
+    id: 'errors.21',
+    defaultMessage: 'This name is already taken by another anonymous user.',
+  },
+  22: {
+    id: 'errors.22',
+    defaultMessage: 'Comment is too short.',
+  },
+  23: {
+    id: 'errors.23',
+    defaultMessage: 'Comment is too long.',
+  },
   401: {
     id: 'errors.not-authorized',
     defaultMessage: 'Not authorized.',